@@ -0,0 +1,64 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	drtypes "github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// imageWaitTimeout is the maximum time to wait for a baked AMI to become
+// available. Snapshotting a large root volume takes a while.
+const imageWaitTimeout = 45 * time.Minute
+
+var _ drivers.ImageBaker = (*amazonConfig)(nil)
+
+// BakeImage creates an AMI from the instance and waits for it to become
+// available. EC2 reboots the instance to take the snapshot so that its file
+// systems are consistent.
+func (p *amazonConfig) BakeImage(ctx context.Context, instance *drtypes.Instance, name string) (string, error) {
+	logr := logger.FromContext(ctx).
+		WithField("driver", drtypes.Amazon).
+		WithField("pool", instance.Pool).
+		WithField("instanceID", instance.ID).
+		WithField("image", name)
+
+	out, err := p.service.CreateImage(ctx, &ec2.CreateImageInput{
+		InstanceId:  aws.String(instance.ID),
+		Name:        aws.String(name),
+		Description: aws.String(fmt.Sprintf("Baked from instance %s of pool %s", instance.ID, instance.Pool)),
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeImage,
+				Tags: convertTags(map[string]string{
+					"harness-pool-name":  instance.Pool,
+					"harness-baked-from": instance.ID,
+				}),
+			},
+		},
+	})
+	if err != nil {
+		logr.WithError(err).Errorln("aws: failed to create image")
+		return "", err
+	}
+	imageID := aws.ToString(out.ImageId)
+	logr = logr.WithField("ami", imageID)
+
+	logr.Traceln("aws: waiting for image to become available")
+	waiter := ec2.NewImageAvailableWaiter(p.service)
+	if err = waiter.Wait(ctx, &ec2.DescribeImagesInput{ImageIds: []string{imageID}}, imageWaitTimeout); err != nil {
+		logr.WithError(err).Errorln("aws: image did not become available")
+		return "", err
+	}
+
+	logr.Infoln("aws: image created")
+	return imageID, nil
+}
@@ -0,0 +1,50 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	drtypes "github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestBakeImage(t *testing.T) {
+	var created *ec2.CreateImageInput
+	mock := &mockEC2Client{
+		CreateImageFunc: func(_ context.Context, params *ec2.CreateImageInput, _ ...func(*ec2.Options)) (*ec2.CreateImageOutput, error) {
+			created = params
+			return &ec2.CreateImageOutput{ImageId: aws.String("ami-baked")}, nil
+		},
+		DescribeImagesFunc: func(_ context.Context, params *ec2.DescribeImagesInput, _ ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+			assert.Equal(t, []string{"ami-baked"}, params.ImageIds)
+			return &ec2.DescribeImagesOutput{Images: []types.Image{{ImageId: aws.String("ami-baked"), State: types.ImageStateAvailable}}}, nil
+		},
+	}
+	p := &amazonConfig{service: mock}
+
+	ami, err := p.BakeImage(context.Background(), &drtypes.Instance{ID: "i-123", Pool: "linux"}, "linux-20261017")
+	require.NoError(t, err)
+	assert.Equal(t, "ami-baked", ami)
+	require.NotNil(t, created)
+	assert.Equal(t, "i-123", aws.ToString(created.InstanceId))
+	assert.Equal(t, "linux-20261017", aws.ToString(created.Name))
+	assert.Nil(t, created.NoReboot, "the instance must be rebooted for a consistent snapshot")
+}
+
+func TestBakeImage_CreateFails(t *testing.T) {
+	mock := &mockEC2Client{
+		CreateImageFunc: func(context.Context, *ec2.CreateImageInput, ...func(*ec2.Options)) (*ec2.CreateImageOutput, error) {
+			return nil, errors.New("InvalidAMIName.Duplicate")
+		},
+	}
+	p := &amazonConfig{service: mock}
+
+	_, err := p.BakeImage(context.Background(), &drtypes.Instance{ID: "i-123"}, "linux")
+	assert.Error(t, err)
+}
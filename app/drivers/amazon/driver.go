@@ -18,6 +18,7 @@ import (
 	"github.com/drone-runners/drone-runner-aws/app/oshelp"
 	cf "github.com/drone-runners/drone-runner-aws/command/config"
 	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
+	"github.com/drone-runners/drone-runner-aws/metric"
 	drtypes "github.com/drone-runners/drone-runner-aws/types"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -28,6 +29,7 @@ import (
 	"github.com/aws/smithy-go"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/dchest/uniuri"
+	"github.com/hashicorp/golang-lru/v2/expirable"
 
 	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
 )
@@ -38,6 +40,7 @@ const (
 )
 
 var _ drivers.Driver = (*amazonConfig)(nil)
+var _ drivers.InterruptionDetector = (*amazonConfig)(nil)
 
 // ec2ClientAPI is an interface for EC2 client operations
 type ec2ClientAPI interface {
@@ -59,6 +62,9 @@ type ec2ClientAPI interface {
 	GetConsoleOutput(ctx context.Context, params *ec2.GetConsoleOutputInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error)
 	CreateCapacityReservation(ctx context.Context, params *ec2.CreateCapacityReservationInput, optFns ...func(*ec2.Options)) (*ec2.CreateCapacityReservationOutput, error)
 	CancelCapacityReservation(ctx context.Context, params *ec2.CancelCapacityReservationInput, optFns ...func(*ec2.Options)) (*ec2.CancelCapacityReservationOutput, error)
+	DescribeCapacityReservations(ctx context.Context, params *ec2.DescribeCapacityReservationsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeCapacityReservationsOutput, error)
+	DescribeSpotInstanceRequests(ctx context.Context, params *ec2.DescribeSpotInstanceRequestsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSpotInstanceRequestsOutput, error)
+	CreateImage(ctx context.Context, params *ec2.CreateImageInput, optFns ...func(*ec2.Options)) (*ec2.CreateImageOutput, error)
 }
 
 // amazonConfig is a struct that implements drivers.Pool interface
@@ -78,6 +84,7 @@ type amazonConfig struct {
 	image         string
 	size          string
 	sizeAlt       string
+	sizeFallbacks []string
 	user          string
 	userData      string
 	subnet        string
@@ -101,6 +108,11 @@ type amazonConfig struct {
 	// AMI cache
 	amiCache  *AMICache
 	enableC4D bool
+
+	// stockoutCache remembers (zone, size) pairs that recently returned
+	// InsufficientInstanceCapacity so zone selection can try other zones first.
+	stockoutCache *expirable.LRU[string, struct{}]
+	metrics       *metric.Metrics
 }
 
 const (
@@ -124,6 +136,15 @@ func New(opts ...Option) (drivers.Driver, error) {
 	for _, opt := range opts {
 		opt(p)
 	}
+	// one-time spot instances cannot be stopped, so they cannot be hibernated
+	// either. Pools asking for both keep launching on-demand instances, as they
+	// did before spot instances were supported.
+	if p.spotInstance && p.hibernate {
+		logger.FromContext(context.Background()).
+			WithField("region", p.region).
+			Warnln("amazon: hibernate is not supported for spot instances, launching on-demand instances")
+		p.spotInstance = false
+	}
 	// setup service
 	if p.service == nil {
 		ctx := context.Background()
@@ -159,6 +180,7 @@ func New(opts ...Option) (drivers.Driver, error) {
 	}
 	// Initialize AMI cache
 	p.amiCache = NewAMICache()
+	p.stockoutCache = expirable.NewLRU[string, struct{}](stockoutCacheSize, nil, stockoutCacheTTL)
 	return p, nil
 }
 
@@ -347,19 +369,45 @@ func (p *amazonConfig) ReserveCapacity(ctx context.Context, opts *drtypes.Instan
 		TagSpecifications: []types.TagSpecification{
 			{
 				ResourceType: types.ResourceTypeCapacityReservation,
-				Tags:         convertTags(buildHarnessTags(opts)),
+				Tags:         convertTags(capacityReservationTags(opts)),
 			},
 		},
 	}
 
-	result, err := client.CreateCapacityReservation(ctx, input)
+	// Let EC2 expire the reservation if the runner never cancels it, same as
+	// DeleteAfterDuration on GCP.
+	if opts.CapacityReservationTTL > 0 {
+		input.EndDateType = types.EndDateTypeLimited
+		input.EndDate = aws.Time(time.Now().Add(time.Duration(opts.CapacityReservationTTL) * time.Second))
+		logr.WithField("end_date", *input.EndDate).
+			Traceln("amazon: capacity reservation will expire automatically")
+	}
+
+	// Bound the call so a slow or stuck reservation fails fast and the caller
+	// can move on to the next pool.
+	if opts.ReservationPerPoolTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(opts.ReservationPerPoolTimeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	// Zones that were explicitly requested are not swapped for another zone.
+	candidates := []*requestConfig{reqCfg}
+	if len(opts.Zones) == 0 {
+		candidates = p.buildZoneCandidates(reqCfg)
+	}
+
+	var result *ec2.CreateCapacityReservationOutput
+	reqCfg, err = p.withStockoutRetry(ctx, metric.StockoutOperationReserve, candidates, true, func(cand *requestConfig) error {
+		input.AvailabilityZone = aws.String(cand.availabilityZone)
+		var createErr error
+		result, createErr = client.CreateCapacityReservation(ctx, input)
+		return createErr
+	})
 	if err != nil {
-		var apiErr smithy.APIError
-		if errors.As(err, &apiErr) {
-			if apiErr.ErrorCode() == "InsufficientInstanceCapacity" {
-				logr.WithError(err).Errorln("amazon: insufficient capacity available")
-				return nil, &ierrors.ErrCapacityUnavailable{Driver: p.DriverName()}
-			}
+		if isStockoutError(err) {
+			logr.WithError(err).Errorln("amazon: insufficient capacity available")
+			return nil, &ierrors.ErrCapacityUnavailable{Driver: p.DriverName()}
 		}
 		logr.WithError(err).Errorln("amazon: failed to create capacity reservation")
 		return nil, &ierrors.ErrCapacityUnavailable{Driver: p.DriverName()}
@@ -372,7 +420,9 @@ func (p *amazonConfig) ReserveCapacity(ctx context.Context, opts *drtypes.Instan
 
 	reservationID := aws.ToString(result.CapacityReservation.CapacityReservationId)
 
-	logr.WithField("reservation_id", reservationID).Infoln("amazon: capacity reservation created successfully")
+	logr.WithField("reservation_id", reservationID).
+		WithField("zone", reqCfg.availabilityZone).
+		Infoln("amazon: capacity reservation created successfully")
 
 	return &drtypes.CapacityReservation{
 		StageID:       "", // Will be set by the caller
@@ -424,6 +474,7 @@ func (p *amazonConfig) DestroyCapacity(ctx context.Context, capacity *drtypes.Ca
 
 // buildRunInstancesInput constructs the EC2 RunInstancesInput with all configuration
 func (p *amazonConfig) buildRunInstancesInput(
+	ctx context.Context,
 	resolvedAMI string,
 	userData string,
 	reqCfg *requestConfig,
@@ -519,16 +570,36 @@ func (p *amazonConfig) buildRunInstancesInput(
 		}
 	}
 
-	// Use capacity reservation if provided
-	if opts.CapacityReservation != nil && opts.CapacityReservation.ReservationID != "" {
+	// Use capacity reservation if provided. A targeted reservation only
+	// applies to its own zone, launching into it anywhere else fails.
+	useReservation := opts.CapacityReservation != nil && opts.CapacityReservation.ReservationID != ""
+	if useReservation && opts.CapacityReservation.GetZone() != "" && opts.CapacityReservation.GetZone() != reqCfg.availabilityZone {
+		logger.FromContext(ctx).
+			WithField("pool", opts.PoolName).
+			WithField("reservation_id", opts.CapacityReservation.ReservationID).
+			WithField("reservation_zone", opts.CapacityReservation.GetZone()).
+			WithField("zone", reqCfg.availabilityZone).
+			Warnln("amazon: capacity reservation is in a different zone, launching without it")
+		useReservation = false
+	}
+	if useReservation {
 		in.CapacityReservationSpecification = &types.CapacityReservationSpecification{
 			CapacityReservationTarget: &types.CapacityReservationTarget{
 				CapacityReservationId: aws.String(opts.CapacityReservation.ReservationID),
 			},
 		}
+	} else if p.spotInstance {
+		// capacity reservations are on-demand only, spot is used for everything else
+		in.InstanceMarketOptions = &types.InstanceMarketOptionsRequest{
+			MarketType: types.MarketTypeSpot,
+			SpotOptions: &types.SpotMarketOptions{
+				SpotInstanceType:             types.SpotInstanceTypeOneTime,
+				InstanceInterruptionBehavior: types.InstanceInterruptionBehaviorTerminate,
+			},
+		}
 	}
 
-	logr := logger.FromContext(context.Background()).
+	logr := logger.FromContext(ctx).
 		WithField("pool", opts.PoolName)
 	logr.WithField("zone", reqCfg.availabilityZone).
 		WithField("subnet", reqCfg.subnet).
@@ -624,10 +695,8 @@ func (p *amazonConfig) Create(ctx context.Context, opts *drtypes.InstanceCreateO
 		return nil, err
 	}
 
-	// Build the RunInstances input
-	in := p.buildRunInstancesInput(resolvedAMI, userData, reqCfg, tags, volumeTags, opts)
-
-	if opts.CapacityReservation != nil && opts.CapacityReservation.ReservationID != "" {
+	usesReservation := opts.CapacityReservation != nil && opts.CapacityReservation.ReservationID != ""
+	if usesReservation {
 		logr.WithField("reservation_id", opts.CapacityReservation.ReservationID).
 			Debugln("amazon: using capacity reservation")
 	}
@@ -639,7 +708,33 @@ func (p *amazonConfig) Create(ctx context.Context, opts *drtypes.InstanceCreateO
 		return nil, err
 	}
 
-	runResult, err := client.RunInstances(ctx, in)
+	// A capacity reservation holds capacity for its own instance type only.
+	sizes := drivers.MachineTypes(opts, p.size, p.sizeFallbacks)
+	if usesReservation {
+		sizes = sizes[:1]
+	}
+
+	var runResult *ec2.RunInstancesOutput
+	base := reqCfg
+	_, err = drivers.CreateWithMachineTypeFallback(ctx, p.DriverName(), sizes, isStockoutError, p.metrics, func(size string) error {
+		first := *base
+		first.size = size
+		// Persistent disks and capacity reservations are bound to their zone, so
+		// only plain creates move on to another zone after a stockout.
+		candidates := []*requestConfig{&first}
+		if !usesReservation && len(volumes) == 0 && len(opts.Zones) == 0 {
+			candidates = p.buildZoneCandidates(&first)
+		}
+		var retryErr error
+		reqCfg, retryErr = p.withStockoutRetry(ctx, metric.StockoutOperationCreate, candidates, !usesReservation, func(cand *requestConfig) error {
+			in := p.buildRunInstancesInput(ctx, resolvedAMI, userData, cand, tags, volumeTags, opts)
+			var runErr error
+			runResult, runErr = client.RunInstances(ctx, in)
+			return runErr
+		})
+		return retryErr
+	}, nil)
+	logr = logr.WithField("zone", reqCfg.availabilityZone).WithField("size", reqCfg.size)
 	if err != nil {
 		logr.WithError(err).Errorln("amazon: [provision] failed to create VMs")
 		// Cleanup created volumes before returning
@@ -970,6 +1065,87 @@ func (p *amazonConfig) Start(ctx context.Context, instance *drtypes.Instance, po
 	return p.getIP(awsInstance), nil
 }
 
+// spotInterruptionReasons are the state reason codes EC2 sets on an instance
+// it reclaimed from the spot market.
+var spotInterruptionReasons = map[string]bool{
+	"Server.SpotInstanceTermination": true,
+	"Server.SpotInstanceShutdown":    true,
+}
+
+// spotInterruptionNotices are the spot request status codes EC2 sets once an
+// interruption notice was issued, up to two minutes before the instance is
+// reclaimed.
+var spotInterruptionNotices = map[string]bool{
+	"marked-for-termination":                      true,
+	"marked-for-stop":                             true,
+	"instance-terminated-by-price":                true,
+	"instance-terminated-no-capacity":             true,
+	"instance-terminated-capacity-oversubscribed": true,
+}
+
+// Interrupted returns the IDs of the given instances that were reclaimed from
+// the spot market, either according to their state reason or because EC2
+// issued an interruption notice for their spot request.
+func (p *amazonConfig) Interrupted(ctx context.Context, instances []*drtypes.Instance) ([]string, error) {
+	if !p.spotInstance || len(instances) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(instances))
+	for i, instance := range instances {
+		ids[i] = instance.ID
+	}
+
+	// filter instead of passing InstanceIds, which fails the whole call when
+	// one of the instances no longer exists
+	response, err := p.service.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{{Name: aws.String("instance-id"), Values: ids}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("amazon: failed to describe instances: %w", err)
+	}
+
+	var interrupted []string
+	requests := map[string]string{}
+	for _, reservation := range response.Reservations {
+		for i := range reservation.Instances {
+			instance := &reservation.Instances[i]
+			instanceID := aws.ToString(instance.InstanceId)
+			if instance.StateReason != nil && spotInterruptionReasons[aws.ToString(instance.StateReason.Code)] {
+				interrupted = append(interrupted, instanceID)
+				continue
+			}
+			if instance.SpotInstanceRequestId != nil {
+				requests[*instance.SpotInstanceRequestId] = instanceID
+			}
+		}
+	}
+	if len(requests) == 0 {
+		return interrupted, nil
+	}
+
+	requestIDs := make([]string, 0, len(requests))
+	for id := range requests {
+		requestIDs = append(requestIDs, id)
+	}
+	spotResponse, err := p.service.DescribeSpotInstanceRequests(ctx, &ec2.DescribeSpotInstanceRequestsInput{
+		Filters: []types.Filter{{Name: aws.String("spot-instance-request-id"), Values: requestIDs}},
+	})
+	if err != nil {
+		return interrupted, fmt.Errorf("amazon: failed to describe spot instance requests: %w", err)
+	}
+	for i := range spotResponse.SpotInstanceRequests {
+		request := &spotResponse.SpotInstanceRequests[i]
+		if request.Status == nil || !spotInterruptionNotices[aws.ToString(request.Status.Code)] {
+			continue
+		}
+		if instanceID, ok := requests[aws.ToString(request.SpotInstanceRequestId)]; ok {
+			interrupted = append(interrupted, instanceID)
+		}
+	}
+	return interrupted, nil
+}
+
 // getDynamicConfig extracts request-specific configuration from opts without mutating shared state.
 // This prevents race conditions when multiple requests use the same pool concurrently.
 func (p *amazonConfig) getDynamicConfig(opts *drtypes.InstanceCreateOpts) (*requestConfig, error) {
@@ -982,6 +1158,10 @@ func (p *amazonConfig) getDynamicConfig(opts *drtypes.InstanceCreateOpts) (*requ
 		volumeType:       p.volumeType,
 	}
 
+	if opts.MachineType != "" {
+		cfg.size = opts.MachineType
+	}
+
 	// Determine the target zone: request zones > capacity reservation zone > round-robin
 	var targetZone string
 	var zoneSource string
@@ -1024,6 +1204,20 @@ func (p *amazonConfig) getDynamicConfig(opts *drtypes.InstanceCreateOpts) (*requ
 		numZones := uint64(len(p.zoneDetails))
 		idx := atomic.AddUint64(&p.zoneIndex, 1) - 1
 		zoneDetail := p.zoneDetails[idx%numZones]
+		// Skip zones that recently ran out of capacity for this size. When
+		// every zone is exhausted the round-robin pick is kept.
+		for k := uint64(0); k < numZones; k++ {
+			candidate := p.zoneDetails[(idx+k)%numZones]
+			if !p.isStockoutZone(candidate.AvailabilityZone, cfg.size) {
+				if k > 0 {
+					logr.WithField("skipped_zone", zoneDetail.AvailabilityZone).
+						WithField("zone", candidate.AvailabilityZone).
+						Debugln("amazon: [multi-az] skipped recently stocked-out zone")
+				}
+				zoneDetail = candidate
+				break
+			}
+		}
 		cfg.availabilityZone = zoneDetail.AvailabilityZone
 		cfg.subnet = zoneDetail.SubnetID
 		logr.WithField("zone", zoneDetail.AvailabilityZone).
@@ -1037,10 +1231,6 @@ func (p *amazonConfig) getDynamicConfig(opts *drtypes.InstanceCreateOpts) (*requ
 			Traceln("amazon: [multi-az] using pool default zone (no zone_details configured)")
 	}
 
-	if opts.MachineType != "" {
-		cfg.size = opts.MachineType
-	}
-
 	if opts.StorageOpts.BootDiskSize != "" {
 		diskSize, err := strconv.ParseInt(opts.StorageOpts.BootDiskSize, 10, 64)
 		if err != nil {
@@ -1388,12 +1578,7 @@ func (p *amazonConfig) createPersistentDisks(
 			TagSpecifications: []types.TagSpecification{
 				{
 					ResourceType: types.ResourceTypeVolume,
-					Tags: []types.Tag{
-						{
-							Key:   aws.String("Name"),
-							Value: aws.String(diskName),
-						},
-					},
+					Tags:         convertTags(diskTags(opts, diskName)),
 				},
 			},
 		}
@@ -3,6 +3,7 @@ package amazon
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -13,6 +14,9 @@ import (
 	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/drivertest"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
 	cf "github.com/drone-runners/drone-runner-aws/command/config"
 	drtypes "github.com/drone-runners/drone-runner-aws/types"
 )
@@ -37,6 +41,9 @@ type mockEC2Client struct {
 	GetConsoleOutputFunc              func(ctx context.Context, params *ec2.GetConsoleOutputInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error)
 	CreateCapacityReservationFunc     func(ctx context.Context, params *ec2.CreateCapacityReservationInput, optFns ...func(*ec2.Options)) (*ec2.CreateCapacityReservationOutput, error)
 	CancelCapacityReservationFunc     func(ctx context.Context, params *ec2.CancelCapacityReservationInput, optFns ...func(*ec2.Options)) (*ec2.CancelCapacityReservationOutput, error)
+	DescribeCapacityReservationsFunc  func(ctx context.Context, params *ec2.DescribeCapacityReservationsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeCapacityReservationsOutput, error)
+	DescribeSpotInstanceRequestsFunc  func(ctx context.Context, params *ec2.DescribeSpotInstanceRequestsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSpotInstanceRequestsOutput, error)
+	CreateImageFunc                   func(ctx context.Context, params *ec2.CreateImageInput, optFns ...func(*ec2.Options)) (*ec2.CreateImageOutput, error)
 }
 
 func (m *mockEC2Client) DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error) {
@@ -169,6 +176,31 @@ func (m *mockEC2Client) CancelCapacityReservation(ctx context.Context, params *e
 	return &ec2.CancelCapacityReservationOutput{}, nil
 }
 
+func (m *mockEC2Client) DescribeCapacityReservations(
+	ctx context.Context, params *ec2.DescribeCapacityReservationsInput, optFns ...func(*ec2.Options),
+) (*ec2.DescribeCapacityReservationsOutput, error) {
+	if m.DescribeCapacityReservationsFunc != nil {
+		return m.DescribeCapacityReservationsFunc(ctx, params, optFns...)
+	}
+	return &ec2.DescribeCapacityReservationsOutput{}, nil
+}
+
+func (m *mockEC2Client) DescribeSpotInstanceRequests(
+	ctx context.Context, params *ec2.DescribeSpotInstanceRequestsInput, optFns ...func(*ec2.Options),
+) (*ec2.DescribeSpotInstanceRequestsOutput, error) {
+	if m.DescribeSpotInstanceRequestsFunc != nil {
+		return m.DescribeSpotInstanceRequestsFunc(ctx, params, optFns...)
+	}
+	return &ec2.DescribeSpotInstanceRequestsOutput{}, nil
+}
+
+func (m *mockEC2Client) CreateImage(ctx context.Context, params *ec2.CreateImageInput, optFns ...func(*ec2.Options)) (*ec2.CreateImageOutput, error) {
+	if m.CreateImageFunc != nil {
+		return m.CreateImageFunc(ctx, params, optFns...)
+	}
+	return &ec2.CreateImageOutput{}, nil
+}
+
 // TestPing tests the Ping method with AWS SDK v2
 func TestPing(t *testing.T) {
 	tests := []struct {
@@ -779,3 +811,318 @@ func TestGetDynamicConfig_RoundRobinSetsSubnet(t *testing.T) {
 	assert.Equal(t, "us-east-1b", cfg2.availabilityZone)
 	assert.Equal(t, "subnet-bbb", cfg2.subnet)
 }
+
+// TestInterrupted tests spot interruption detection from the state reason and
+// from spot request interruption notices
+func TestInterrupted(t *testing.T) {
+	instances := []*drtypes.Instance{{ID: "i-reclaimed"}, {ID: "i-notice"}, {ID: "i-healthy"}}
+
+	mock := &mockEC2Client{
+		DescribeInstancesFunc: func(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+			assert.Empty(t, params.InstanceIds, "instances must be filtered so missing ids do not fail the call")
+			assert.ElementsMatch(t, []string{"i-reclaimed", "i-notice", "i-healthy"}, params.Filters[0].Values)
+			return &ec2.DescribeInstancesOutput{
+				Reservations: []types.Reservation{{
+					Instances: []types.Instance{
+						{
+							InstanceId:  aws.String("i-reclaimed"),
+							State:       &types.InstanceState{Name: types.InstanceStateNameTerminated},
+							StateReason: &types.StateReason{Code: aws.String("Server.SpotInstanceTermination")},
+						},
+						{
+							InstanceId:            aws.String("i-notice"),
+							State:                 &types.InstanceState{Name: types.InstanceStateNameRunning},
+							SpotInstanceRequestId: aws.String("sir-notice"),
+						},
+						{
+							InstanceId:            aws.String("i-healthy"),
+							State:                 &types.InstanceState{Name: types.InstanceStateNameRunning},
+							SpotInstanceRequestId: aws.String("sir-healthy"),
+						},
+					},
+				}},
+			}, nil
+		},
+		DescribeSpotInstanceRequestsFunc: func(ctx context.Context, params *ec2.DescribeSpotInstanceRequestsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSpotInstanceRequestsOutput, error) {
+			assert.ElementsMatch(t, []string{"sir-notice", "sir-healthy"}, params.Filters[0].Values)
+			return &ec2.DescribeSpotInstanceRequestsOutput{
+				SpotInstanceRequests: []types.SpotInstanceRequest{
+					{SpotInstanceRequestId: aws.String("sir-notice"), Status: &types.SpotInstanceStatus{Code: aws.String("marked-for-termination")}},
+					{SpotInstanceRequestId: aws.String("sir-healthy"), Status: &types.SpotInstanceStatus{Code: aws.String("fulfilled")}},
+				},
+			}, nil
+		},
+	}
+
+	p := &amazonConfig{service: mock, spotInstance: true}
+	got, err := p.Interrupted(context.Background(), instances)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"i-reclaimed", "i-notice"}, got)
+}
+
+// TestInterruptedOnDemand tests that on-demand pools never query EC2
+func TestInterruptedOnDemand(t *testing.T) {
+	mock := &mockEC2Client{
+		DescribeInstancesFunc: func(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+			t.Fatal("DescribeInstances must not be called for on-demand pools")
+			return nil, nil
+		},
+	}
+
+	p := &amazonConfig{service: mock}
+	got, err := p.Interrupted(context.Background(), []*drtypes.Instance{{ID: "i-1"}})
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+// TestBuildRunInstancesInputSpot tests that spot pools request spot capacity
+// unless a capacity reservation is targeted
+func TestBuildRunInstancesInputSpot(t *testing.T) {
+	p := &amazonConfig{spotInstance: true}
+	reqCfg := &requestConfig{size: "t3.large", availabilityZone: "us-east-1a"}
+
+	in := p.buildRunInstancesInput(context.Background(), "ami-123", "", reqCfg, nil, nil, &drtypes.InstanceCreateOpts{})
+	if assert.NotNil(t, in.InstanceMarketOptions) {
+		assert.Equal(t, types.MarketTypeSpot, in.InstanceMarketOptions.MarketType)
+		assert.Equal(t, types.SpotInstanceTypeOneTime, in.InstanceMarketOptions.SpotOptions.SpotInstanceType)
+		assert.Equal(t, types.InstanceInterruptionBehaviorTerminate, in.InstanceMarketOptions.SpotOptions.InstanceInterruptionBehavior)
+	}
+
+	in = p.buildRunInstancesInput(context.Background(), "ami-123", "", reqCfg, nil, nil, &drtypes.InstanceCreateOpts{
+		CapacityReservation: &drtypes.CapacityReservation{ReservationID: "cr-123"},
+	})
+	assert.Nil(t, in.InstanceMarketOptions)
+	assert.NotNil(t, in.CapacityReservationSpecification)
+}
+
+func TestNewSpotHibernateLaunchesOnDemand(t *testing.T) {
+	d, err := New(WithMarketType("spot"), WithHibernate(true), WithRegion("us-east-1", ""))
+	assert.NoError(t, err)
+	p := d.(*amazonConfig)
+	assert.False(t, p.spotInstance)
+	assert.True(t, p.CanHibernate())
+}
+
+// TestReserveCapacity tests that a targeted reservation is created in the
+// selected zone and expires after the configured ttl
+func TestReserveCapacity(t *testing.T) {
+	var input *ec2.CreateCapacityReservationInput
+	mock := &mockEC2Client{
+		CreateCapacityReservationFunc: func(ctx context.Context, params *ec2.CreateCapacityReservationInput, optFns ...func(*ec2.Options)) (*ec2.CreateCapacityReservationOutput, error) {
+			input = params
+			_, hasDeadline := ctx.Deadline()
+			assert.True(t, hasDeadline, "the per pool reservation timeout should bound the call")
+			return &ec2.CreateCapacityReservationOutput{
+				CapacityReservation: &types.CapacityReservation{CapacityReservationId: aws.String("cr-123")},
+			}, nil
+		},
+	}
+
+	p := &amazonConfig{service: mock, size: "m5.large", availabilityZone: "us-east-1a", subnet: "subnet-a"}
+	capacity, err := p.ReserveCapacity(context.Background(), &drtypes.InstanceCreateOpts{
+		PoolName:                  "test-pool",
+		Platform:                  drtypes.Platform{OS: "linux", Arch: "amd64"},
+		Zones:                     []string{"us-east-1b"},
+		CapacityReservationTTL:    3600,
+		ReservationPerPoolTimeout: 1000,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "cr-123", capacity.ReservationID)
+	assert.Equal(t, "test-pool", capacity.PoolName)
+	assert.Equal(t, "us-east-1b", capacity.GetZone())
+
+	assert.Equal(t, "us-east-1b", aws.ToString(input.AvailabilityZone))
+	assert.Equal(t, "m5.large", aws.ToString(input.InstanceType))
+	assert.Equal(t, types.InstanceMatchCriteriaTargeted, input.InstanceMatchCriteria)
+	assert.Equal(t, types.CapacityReservationInstancePlatform("Linux/UNIX"), input.InstancePlatform)
+	assert.Equal(t, types.EndDateTypeLimited, input.EndDateType)
+	if assert.NotNil(t, input.EndDate) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *input.EndDate, time.Minute)
+	}
+}
+
+// TestReserveCapacityUnavailable tests that reservation failures are reported
+// as unavailable capacity so the caller falls back to the next pool
+func TestReserveCapacityUnavailable(t *testing.T) {
+	mock := &mockEC2Client{
+		CreateCapacityReservationFunc: func(ctx context.Context, params *ec2.CreateCapacityReservationInput, optFns ...func(*ec2.Options)) (*ec2.CreateCapacityReservationOutput, error) {
+			assert.Equal(t, types.EndDateTypeUnlimited, params.EndDateType)
+			return nil, &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity", Message: "no capacity"}
+		},
+	}
+
+	p := &amazonConfig{service: mock, size: "m5.large", availabilityZone: "us-east-1a"}
+	_, err := p.ReserveCapacity(context.Background(), &drtypes.InstanceCreateOpts{PoolName: "test-pool"})
+	var unavailable *ierrors.ErrCapacityUnavailable
+	assert.True(t, errors.As(err, &unavailable))
+
+	_, err = p.ReserveCapacity(context.Background(), &drtypes.InstanceCreateOpts{
+		PoolName: "test-pool",
+		Platform: drtypes.Platform{OS: "darwin"},
+	})
+	assert.Error(t, err, "macOS instances cannot use capacity reservations")
+}
+
+// TestDestroyCapacity tests that reservations are cancelled and that already
+// removed reservations are not an error
+func TestDestroyCapacity(t *testing.T) {
+	var cancelled []string
+	mock := &mockEC2Client{
+		CancelCapacityReservationFunc: func(ctx context.Context, params *ec2.CancelCapacityReservationInput, optFns ...func(*ec2.Options)) (*ec2.CancelCapacityReservationOutput, error) {
+			id := aws.ToString(params.CapacityReservationId)
+			if id == "cr-gone" {
+				return nil, &smithy.GenericAPIError{Code: "InvalidCapacityReservationId.NotFound"}
+			}
+			if id == "cr-fail" {
+				return nil, &smithy.GenericAPIError{Code: "InternalError"}
+			}
+			cancelled = append(cancelled, id)
+			return &ec2.CancelCapacityReservationOutput{}, nil
+		},
+	}
+
+	p := &amazonConfig{service: mock}
+	assert.NoError(t, p.DestroyCapacity(context.Background(), &drtypes.CapacityReservation{ReservationID: "cr-123"}))
+	assert.NoError(t, p.DestroyCapacity(context.Background(), &drtypes.CapacityReservation{ReservationID: "cr-gone"}))
+	assert.Error(t, p.DestroyCapacity(context.Background(), &drtypes.CapacityReservation{ReservationID: "cr-fail"}))
+	assert.Error(t, p.DestroyCapacity(context.Background(), &drtypes.CapacityReservation{}))
+	assert.Equal(t, []string{"cr-123"}, cancelled)
+}
+
+// TestBuildRunInstancesInputCapacityReservationZone tests that instances only
+// target a reservation in their own zone
+func TestBuildRunInstancesInputCapacityReservationZone(t *testing.T) {
+	p := &amazonConfig{}
+	reservation := &drtypes.CapacityReservation{ReservationID: "cr-123", Zone: drtypes.StringPtr("us-east-1b")}
+
+	in := p.buildRunInstancesInput(context.Background(), "ami-123", "", &requestConfig{size: "m5.large", availabilityZone: "us-east-1b"}, nil, nil,
+		&drtypes.InstanceCreateOpts{CapacityReservation: reservation})
+	if assert.NotNil(t, in.CapacityReservationSpecification) {
+		assert.Equal(t, "cr-123", aws.ToString(in.CapacityReservationSpecification.CapacityReservationTarget.CapacityReservationId))
+	}
+
+	in = p.buildRunInstancesInput(context.Background(), "ami-123", "", &requestConfig{size: "m5.large", availabilityZone: "us-east-1c"}, nil, nil,
+		&drtypes.InstanceCreateOpts{CapacityReservation: reservation})
+	assert.Nil(t, in.CapacityReservationSpecification)
+}
+
+// fakeEC2 keeps the instances launched through it, so that the conformance
+// suite can check that the driver calls took effect.
+type fakeEC2 struct {
+	mu        sync.Mutex
+	launched  int
+	instances map[string]*types.Instance
+}
+
+func newFakeEC2() *fakeEC2 {
+	return &fakeEC2{instances: map[string]*types.Instance{}}
+}
+
+// Exists reports whether the instance was launched and not terminated.
+func (f *fakeEC2) Exists(instanceID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inst, ok := f.instances[instanceID]
+	return ok && inst.State.Name != types.InstanceStateNameTerminated
+}
+
+func (f *fakeEC2) find(ids []string) (*types.Instance, error) {
+	if len(ids) != 1 {
+		return nil, fmt.Errorf("fake ec2: expected one instance ID, got %v", ids)
+	}
+	inst, ok := f.instances[ids[0]]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "InvalidInstanceID.NotFound"}
+	}
+	return inst, nil
+}
+
+func (f *fakeEC2) setState(ids []string, state types.InstanceStateName) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inst, err := f.find(ids)
+	if err != nil {
+		return err
+	}
+	inst.State = &types.InstanceState{Name: state}
+	return nil
+}
+
+func (f *fakeEC2) client() *mockEC2Client {
+	return &mockEC2Client{
+		DescribeSecurityGroupsFunc: func(context.Context, *ec2.DescribeSecurityGroupsInput, ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+			return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []types.SecurityGroup{{
+				GroupName:     aws.String("sg"),
+				IpPermissions: []types.IpPermission{{IpProtocol: aws.String("tcp"), FromPort: aws.Int32(9079), ToPort: aws.Int32(9079)}},
+			}}}, nil
+		},
+		RunInstancesFunc: func(_ context.Context, params *ec2.RunInstancesInput, _ ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.launched++
+			inst := types.Instance{
+				InstanceId:       aws.String(fmt.Sprintf("i-%d", f.launched)),
+				PrivateIpAddress: aws.String(fmt.Sprintf("10.0.0.%d", f.launched)),
+				State:            &types.InstanceState{Name: types.InstanceStateNameRunning},
+				Placement:        params.Placement,
+				LaunchTime:       aws.Time(time.Now()),
+			}
+			for _, spec := range params.TagSpecifications {
+				if spec.ResourceType == types.ResourceTypeInstance {
+					inst.Tags = append(inst.Tags, spec.Tags...)
+				}
+			}
+			f.instances[*inst.InstanceId] = &inst
+			return &ec2.RunInstancesOutput{Instances: []types.Instance{inst}}, nil
+		},
+		DescribeInstancesFunc: func(_ context.Context, params *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			inst, err := f.find(params.InstanceIds)
+			if err != nil {
+				return nil, err
+			}
+			return &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: []types.Instance{*inst}}}}, nil
+		},
+		TerminateInstancesFunc: func(_ context.Context, params *ec2.TerminateInstancesInput, _ ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+			return &ec2.TerminateInstancesOutput{}, f.setState(params.InstanceIds, types.InstanceStateNameTerminated)
+		},
+		StopInstancesFunc: func(_ context.Context, params *ec2.StopInstancesInput, _ ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error) {
+			return &ec2.StopInstancesOutput{}, f.setState(params.InstanceIds, types.InstanceStateNameStopped)
+		},
+		StartInstancesFunc: func(_ context.Context, params *ec2.StartInstancesInput, _ ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error) {
+			return &ec2.StartInstancesOutput{}, f.setState(params.InstanceIds, types.InstanceStateNameRunning)
+		},
+		CreateTagsFunc: func(_ context.Context, params *ec2.CreateTagsInput, _ ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			inst, err := f.find(params.Resources)
+			if err != nil {
+				return nil, err
+			}
+			inst.Tags = append(inst.Tags, params.Tags...)
+			return &ec2.CreateTagsOutput{}, nil
+		},
+		CreateCapacityReservationFunc: func(context.Context, *ec2.CreateCapacityReservationInput, ...func(*ec2.Options)) (*ec2.CreateCapacityReservationOutput, error) {
+			return &ec2.CreateCapacityReservationOutput{
+				CapacityReservation: &types.CapacityReservation{CapacityReservationId: aws.String("cr-1")},
+			}, nil
+		},
+	}
+}
+
+// TestConformance runs the shared driver checks against the fake EC2. SetLabels
+// is a noop on AWS, so the fake does not report labels.
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, drivertest.Config{
+		NewDriver: func(*testing.T) (drivers.Driver, drivertest.Backend) {
+			fake := newFakeEC2()
+			p := newStockoutTestConfig(fake.client())
+			p.image = "ami-0123456789"
+			p.groups = []string{"sg-1"}
+			p.hibernate = true
+			return p, fake
+		},
+		ReservesCapacity: true,
+	})
+}
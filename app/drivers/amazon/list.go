@@ -0,0 +1,101 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	drtypes "github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+var _ drivers.InstanceLister = (*amazonConfig)(nil)
+
+// listedInstanceStates are the states of the instances that still exist.
+// Terminated instances stay visible for a while after they are gone.
+var listedInstanceStates = []string{"pending", "running", "shutting-down", "stopping", "stopped"}
+
+// List returns the instances of the region carrying the tags.
+func (p *amazonConfig) List(ctx context.Context, labels map[string]string) ([]*drivers.CloudInstance, error) {
+	filters := append([]types.Filter{{Name: aws.String("instance-state-name"), Values: listedInstanceStates}}, tagFilters(labels)...)
+
+	var instances []*drivers.CloudInstance
+	paginator := ec2.NewDescribeInstancesPaginator(p.service, &ec2.DescribeInstancesInput{Filters: filters})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("amazon: failed to list instances: %w", err)
+		}
+		for _, reservation := range page.Reservations {
+			for i := range reservation.Instances {
+				instances = append(instances, toCloudInstance(&reservation.Instances[i]))
+			}
+		}
+	}
+	return instances, nil
+}
+
+// Exists describes the instance by its ID.
+func (p *amazonConfig) Exists(ctx context.Context, inst *drtypes.Instance) (bool, error) {
+	out, err := p.service.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{inst.ID}})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidInstanceID.NotFound" {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("amazon: failed to describe instance %s: %w", inst.ID, err)
+	}
+	for _, reservation := range out.Reservations {
+		for i := range reservation.Instances {
+			state := reservation.Instances[i].State
+			if state == nil || slices.Contains(listedInstanceStates, string(state.Name)) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func toCloudInstance(instance *types.Instance) *drivers.CloudInstance {
+	tags := tagMap(instance.Tags)
+	cloudInstance := &drivers.CloudInstance{
+		ID:      aws.ToString(instance.InstanceId),
+		Name:    tags["Name"],
+		Created: aws.ToTime(instance.LaunchTime),
+		Labels:  tags,
+	}
+	if instance.Placement != nil {
+		cloudInstance.Zone = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+	return cloudInstance
+}
+
+// tagFilters returns the filters matching the resources carrying all the tags.
+func tagFilters(tags map[string]string) []types.Filter {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	filters := make([]types.Filter, 0, len(keys))
+	for _, k := range keys {
+		filters = append(filters, types.Filter{Name: aws.String("tag:" + k), Values: []string{tags[k]}})
+	}
+	return filters
+}
+
+// tagMap returns the tags as a map.
+func tagMap(tags []types.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return m
+}
@@ -0,0 +1,100 @@
+package amazon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	drtypes "github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestList(t *testing.T) {
+	launched := time.Date(2026, time.October, 17, 9, 0, 0, 0, time.UTC)
+	var pages int
+	mock := &mockEC2Client{
+		DescribeInstancesFunc: func(_ context.Context, params *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+			assert.Equal(t, []types.Filter{
+				{Name: aws.String("instance-state-name"), Values: listedInstanceStates},
+				{Name: aws.String("tag:harness-created-by"), Values: []string{"harness-ci"}},
+				{Name: aws.String("tag:pool_id"), Values: []string{"linux"}},
+			}, params.Filters)
+			pages++
+			if params.NextToken == nil {
+				return &ec2.DescribeInstancesOutput{
+					Reservations: []types.Reservation{{Instances: []types.Instance{{
+						InstanceId: aws.String("i-1"),
+						LaunchTime: aws.Time(launched),
+						Placement:  &types.Placement{AvailabilityZone: aws.String("us-east-1a")},
+						Tags:       []types.Tag{{Key: aws.String("Name"), Value: aws.String("linux-vm")}},
+					}}}},
+					NextToken: aws.String("next"),
+				}, nil
+			}
+			return &ec2.DescribeInstancesOutput{
+				Reservations: []types.Reservation{{Instances: []types.Instance{{InstanceId: aws.String("i-2")}}}},
+			}, nil
+		},
+	}
+	p := &amazonConfig{service: mock}
+
+	instances, err := p.List(context.Background(), map[string]string{"pool_id": "linux", "harness-created-by": "harness-ci"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, pages)
+	require.Len(t, instances, 2)
+	assert.Equal(t, "i-1", instances[0].ID)
+	assert.Equal(t, "linux-vm", instances[0].Name)
+	assert.Equal(t, "us-east-1a", instances[0].Zone)
+	assert.Equal(t, launched, instances[0].Created)
+	assert.Equal(t, "i-2", instances[1].ID)
+}
+
+func TestExists(t *testing.T) {
+	tests := []struct {
+		name string
+		out  *ec2.DescribeInstancesOutput
+		err  error
+		want bool
+	}{
+		{
+			name: "running",
+			out:  &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: []types.Instance{{State: &types.InstanceState{Name: types.InstanceStateNameRunning}}}}}},
+			want: true,
+		},
+		{
+			name: "terminated",
+			out:  &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: []types.Instance{{State: &types.InstanceState{Name: types.InstanceStateNameTerminated}}}}}},
+		},
+		{
+			name: "not found",
+			err:  &smithy.GenericAPIError{Code: "InvalidInstanceID.NotFound"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &amazonConfig{service: &mockEC2Client{
+				DescribeInstancesFunc: func(_ context.Context, params *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+					assert.Equal(t, []string{"i-1"}, params.InstanceIds)
+					return tt.out, tt.err
+				},
+			}}
+			exists, err := p.Exists(context.Background(), &drtypes.Instance{ID: "i-1"})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, exists)
+		})
+	}
+
+	p := &amazonConfig{service: &mockEC2Client{
+		DescribeInstancesFunc: func(context.Context, *ec2.DescribeInstancesInput, ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "RequestLimitExceeded"}
+		},
+	}}
+	_, err := p.Exists(context.Background(), &drtypes.Instance{ID: "i-1"})
+	assert.Error(t, err, "only a not found instance is reported as gone")
+}
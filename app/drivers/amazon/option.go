@@ -6,6 +6,7 @@ import (
 
 	"github.com/drone-runners/drone-runner-aws/app/oshelp"
 	cf "github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/metric"
 	"github.com/drone-runners/drone-runner-aws/types"
 
 	"github.com/sirupsen/logrus"
@@ -149,6 +150,14 @@ func WithSizeAlt(size string) Option {
 	}
 }
 
+// WithSizeFallbacks returns an option to set the instance types tried, in
+// order, when the pool's instance type is out of capacity in every zone.
+func WithSizeFallbacks(sizes ...string) Option {
+	return func(p *amazonConfig) {
+		p.sizeFallbacks = sizes
+	}
+}
+
 // WithSubnet returns an option to set the subnet id.
 func WithSubnet(id string) Option {
 	return func(p *amazonConfig) {
@@ -295,3 +304,11 @@ func WithEnableC4D(enableC4D bool) Option {
 		p.enableC4D = enableC4D
 	}
 }
+
+// WithMetrics returns an option to set the metrics used to record zone
+// stockouts and retries. Nil is safe and disables instrumentation.
+func WithMetrics(m *metric.Metrics) Option {
+	return func(p *amazonConfig) {
+		p.metrics = m
+	}
+}
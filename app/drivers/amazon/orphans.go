@@ -0,0 +1,84 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	_ drivers.DiskCollector             = (*amazonConfig)(nil)
+	_ drivers.CapacityReservationLister = (*amazonConfig)(nil)
+)
+
+// ListDisks returns the volumes of the region carrying the tags. Volumes are
+// referenced by their Name tag.
+func (p *amazonConfig) ListDisks(ctx context.Context, labels map[string]string) ([]*drivers.CloudResource, error) {
+	var disks []*drivers.CloudResource
+	paginator := ec2.NewDescribeVolumesPaginator(p.service, &ec2.DescribeVolumesInput{Filters: tagFilters(labels)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("amazon: failed to list volumes: %w", err)
+		}
+		for i := range page.Volumes {
+			volume := &page.Volumes[i]
+			tags := tagMap(volume.Tags)
+			disks = append(disks, &drivers.CloudResource{
+				ID:       aws.ToString(volume.VolumeId),
+				Name:     tags["Name"],
+				Zone:     aws.ToString(volume.AvailabilityZone),
+				Created:  aws.ToTime(volume.CreateTime),
+				Labels:   tags,
+				Attached: len(volume.Attachments) > 0,
+			})
+		}
+	}
+	return disks, nil
+}
+
+// DeleteDisk deletes a volume.
+func (p *amazonConfig) DeleteDisk(ctx context.Context, disk *drivers.CloudResource) error {
+	_, err := p.service.DeleteVolume(ctx, &ec2.DeleteVolumeInput{VolumeId: aws.String(disk.ID)})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidVolume.NotFound" {
+			return nil
+		}
+		return fmt.Errorf("amazon: failed to delete volume %s: %w", disk.ID, err)
+	}
+	return nil
+}
+
+// ListCapacityReservations returns the pending and active capacity
+// reservations of the region carrying the tags.
+func (p *amazonConfig) ListCapacityReservations(ctx context.Context, labels map[string]string) ([]*drivers.CloudResource, error) {
+	filters := append([]types.Filter{{Name: aws.String("state"), Values: []string{"pending", "active"}}}, tagFilters(labels)...)
+
+	var reservations []*drivers.CloudResource
+	paginator := ec2.NewDescribeCapacityReservationsPaginator(p.service, &ec2.DescribeCapacityReservationsInput{Filters: filters})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("amazon: failed to list capacity reservations: %w", err)
+		}
+		for i := range page.CapacityReservations {
+			reservation := &page.CapacityReservations[i]
+			reservations = append(reservations, &drivers.CloudResource{
+				ID:      aws.ToString(reservation.CapacityReservationId),
+				Name:    aws.ToString(reservation.CapacityReservationId),
+				Zone:    aws.ToString(reservation.AvailabilityZone),
+				Created: aws.ToTime(reservation.CreateDate),
+				Labels:  tagMap(reservation.Tags),
+			})
+		}
+	}
+	return reservations, nil
+}
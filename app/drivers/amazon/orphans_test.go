@@ -0,0 +1,85 @@
+package amazon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+)
+
+func TestListDisks(t *testing.T) {
+	created := time.Date(2026, time.October, 17, 9, 0, 0, 0, time.UTC)
+	mock := &mockEC2Client{
+		DescribeVolumesFunc: func(_ context.Context, params *ec2.DescribeVolumesInput, _ ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error) {
+			assert.Equal(t, []types.Filter{{Name: aws.String("tag:pool_id"), Values: []string{"linux"}}}, params.Filters)
+			return &ec2.DescribeVolumesOutput{Volumes: []types.Volume{
+				{
+					VolumeId:         aws.String("vol-1"),
+					AvailabilityZone: aws.String("us-east-1a"),
+					CreateTime:       aws.Time(created),
+					Tags:             []types.Tag{{Key: aws.String("Name"), Value: aws.String("disk-1")}},
+				},
+				{
+					VolumeId:    aws.String("vol-2"),
+					Attachments: []types.VolumeAttachment{{InstanceId: aws.String("i-1")}},
+				},
+			}}, nil
+		},
+	}
+	p := &amazonConfig{service: mock}
+
+	disks, err := p.ListDisks(context.Background(), map[string]string{"pool_id": "linux"})
+	require.NoError(t, err)
+	require.Len(t, disks, 2)
+	assert.Equal(t, &drivers.CloudResource{
+		ID:      "vol-1",
+		Name:    "disk-1",
+		Zone:    "us-east-1a",
+		Created: created,
+		Labels:  map[string]string{"Name": "disk-1"},
+	}, disks[0])
+	assert.True(t, disks[1].Attached)
+}
+
+func TestDeleteDisk(t *testing.T) {
+	var deleted string
+	mock := &mockEC2Client{
+		DeleteVolumeFunc: func(_ context.Context, params *ec2.DeleteVolumeInput, _ ...func(*ec2.Options)) (*ec2.DeleteVolumeOutput, error) {
+			deleted = aws.ToString(params.VolumeId)
+			return &ec2.DeleteVolumeOutput{}, nil
+		},
+	}
+	p := &amazonConfig{service: mock}
+
+	require.NoError(t, p.DeleteDisk(context.Background(), &drivers.CloudResource{ID: "vol-1", Name: "disk-1"}))
+	assert.Equal(t, "vol-1", deleted)
+}
+
+func TestListCapacityReservations(t *testing.T) {
+	mock := &mockEC2Client{
+		DescribeCapacityReservationsFunc: func(_ context.Context, params *ec2.DescribeCapacityReservationsInput, _ ...func(*ec2.Options)) (*ec2.DescribeCapacityReservationsOutput, error) {
+			assert.Equal(t, []types.Filter{
+				{Name: aws.String("state"), Values: []string{"pending", "active"}},
+				{Name: aws.String("tag:pool_id"), Values: []string{"linux"}},
+			}, params.Filters)
+			return &ec2.DescribeCapacityReservationsOutput{CapacityReservations: []types.CapacityReservation{{
+				CapacityReservationId: aws.String("cr-1"),
+				AvailabilityZone:      aws.String("us-east-1b"),
+			}}}, nil
+		},
+	}
+	p := &amazonConfig{service: mock}
+
+	reservations, err := p.ListCapacityReservations(context.Background(), map[string]string{"pool_id": "linux"})
+	require.NoError(t, err)
+	require.Len(t, reservations, 1)
+	assert.Equal(t, "cr-1", reservations[0].Name)
+	assert.Equal(t, "us-east-1b", reservations[0].Zone)
+}
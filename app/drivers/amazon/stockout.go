@@ -0,0 +1,155 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/metric"
+
+	"github.com/aws/smithy-go"
+)
+
+const (
+	// maxStockoutAttempts caps how many zones a single create or reserve call
+	// tries before giving up, so a region wide stockout fails fast.
+	maxStockoutAttempts = 3
+
+	stockoutCacheTTL = 900 * time.Second
+	// stockoutCacheSize bounds the number of remembered (zone, size) keys.
+	stockoutCacheSize = 1024
+)
+
+// stockoutErrorCodes are the EC2 error codes returned when a zone has no
+// capacity left for the requested instance type.
+var stockoutErrorCodes = []string{
+	"InsufficientInstanceCapacity",
+}
+
+func isStockoutError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, code := range stockoutErrorCodes {
+		if apiErr.ErrorCode() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// stockoutKey is the cache key for a recently-exhausted (region, zone, size).
+func (p *amazonConfig) stockoutKey(zone, size string) string {
+	return p.region + ":" + zone + ":" + size
+}
+
+// markStockout records that (zone, size) just returned InsufficientInstanceCapacity
+// so zone selection deprioritizes it until the entry expires.
+func (p *amazonConfig) markStockout(zone, size string) {
+	if p.stockoutCache == nil || zone == "" {
+		return
+	}
+	p.stockoutCache.Add(p.stockoutKey(zone, size), struct{}{})
+}
+
+// isStockoutZone reports whether (zone, size) is currently remembered as
+// recently exhausted.
+func (p *amazonConfig) isStockoutZone(zone, size string) bool {
+	if p.stockoutCache == nil {
+		return false
+	}
+	_, found := p.stockoutCache.Get(p.stockoutKey(zone, size))
+	return found
+}
+
+// buildZoneCandidates returns the ordered, capped list of zones to try: the
+// selected zone first, then the remaining zone_details in round-robin order,
+// with recently stocked-out zones moved to the back.
+func (p *amazonConfig) buildZoneCandidates(first *requestConfig) []*requestConfig {
+	candidates := []*requestConfig{first}
+	start := 0
+	for i := range p.zoneDetails {
+		if p.zoneDetails[i].AvailabilityZone == first.availabilityZone {
+			start = i + 1
+			break
+		}
+	}
+	for k := range p.zoneDetails {
+		zd := p.zoneDetails[(start+k)%len(p.zoneDetails)]
+		if zd.AvailabilityZone == "" || zd.AvailabilityZone == first.availabilityZone {
+			continue
+		}
+		c := *first
+		c.availabilityZone = zd.AvailabilityZone
+		c.subnet = zd.SubnetID
+		candidates = append(candidates, &c)
+	}
+
+	candidates = p.deprioritizeStockoutZones(candidates)
+
+	if len(candidates) > maxStockoutAttempts {
+		candidates = candidates[:maxStockoutAttempts]
+	}
+	return candidates
+}
+
+// deprioritizeStockoutZones stable-partitions candidates so that zones not
+// recently stocked out come first and recently exhausted zones move to the
+// back. Nothing is removed, so the candidate set is never emptied.
+func (p *amazonConfig) deprioritizeStockoutZones(candidates []*requestConfig) []*requestConfig {
+	if p.stockoutCache == nil {
+		return candidates
+	}
+	healthy := make([]*requestConfig, 0, len(candidates))
+	exhausted := make([]*requestConfig, 0)
+	for _, c := range candidates {
+		if p.isStockoutZone(c.availabilityZone, c.size) {
+			exhausted = append(exhausted, c)
+		} else {
+			healthy = append(healthy, c)
+		}
+	}
+	return append(healthy, exhausted...)
+}
+
+// withStockoutRetry runs attempt for each candidate in order. On a stockout the
+// zone is remembered (when markZones is set) and the next candidate is tried;
+// any other error is returned right away. It returns the candidate that
+// succeeded.
+func (p *amazonConfig) withStockoutRetry(
+	ctx context.Context,
+	operation string,
+	candidates []*requestConfig,
+	markZones bool,
+	attempt func(*requestConfig) error,
+) (*requestConfig, error) {
+	logr := logger.FromContext(ctx).WithField("driver", p.DriverName())
+	var err error
+	for i, cand := range candidates {
+		err = attempt(cand)
+		if err == nil {
+			return cand, nil
+		}
+		if !isStockoutError(err) {
+			return cand, err
+		}
+		attemptLogr := logr.WithError(err).
+			WithField("zone", cand.availabilityZone).
+			WithField("instance_type", cand.size).
+			WithField("attempt", i+1)
+		attemptLogr.Warnln("amazon: stockout detected for zone")
+		p.metrics.RecordZoneStockout(p.DriverName(), operation, cand.availabilityZone, cand.size)
+		if markZones {
+			p.markStockout(cand.availabilityZone, cand.size)
+		}
+		if i < len(candidates)-1 {
+			attemptLogr.WithField("next_zone", candidates[i+1].availabilityZone).
+				Warnln("amazon: zone stockout, retrying alternate zone")
+			p.metrics.RecordZoneRetry(p.DriverName(), operation, metric.ReasonStockout, cand.availabilityZone)
+		}
+	}
+	return candidates[len(candidates)-1], err
+}
@@ -0,0 +1,230 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	cf "github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/metric"
+	drtypes "github.com/drone-runners/drone-runner-aws/types"
+)
+
+var testZoneDetails = []cf.ZoneInfo{
+	{AvailabilityZone: "us-east-1a", SubnetID: "subnet-aaa"},
+	{AvailabilityZone: "us-east-1b", SubnetID: "subnet-bbb"},
+	{AvailabilityZone: "us-east-1c", SubnetID: "subnet-ccc"},
+	{AvailabilityZone: "us-east-1d", SubnetID: "subnet-ddd"},
+}
+
+func newStockoutTestConfig(service ec2ClientAPI) *amazonConfig {
+	return &amazonConfig{
+		service:          service,
+		region:           "us-east-1",
+		availabilityZone: "us-east-1a",
+		subnet:           "subnet-default",
+		size:             "m5.large",
+		zoneDetails:      testZoneDetails,
+		stockoutCache:    expirable.NewLRU[string, struct{}](stockoutCacheSize, nil, stockoutCacheTTL),
+		metrics: &metric.Metrics{
+			ZoneStockoutsCount: metric.ZoneStockoutsCount(),
+			ZoneRetriesCount:   metric.ZoneRetriesCount(),
+		},
+	}
+}
+
+func zonesOf(candidates []*requestConfig) []string {
+	zones := make([]string, len(candidates))
+	for i, c := range candidates {
+		zones[i] = c.availabilityZone
+	}
+	return zones
+}
+
+func TestIsStockoutError(t *testing.T) {
+	assert.True(t, isStockoutError(&smithy.GenericAPIError{Code: "InsufficientInstanceCapacity"}))
+	assert.True(t, isStockoutError(fmt.Errorf("run instances: %w",
+		&smithy.GenericAPIError{Code: "InsufficientInstanceCapacity"})))
+	assert.False(t, isStockoutError(&smithy.GenericAPIError{Code: "InstanceLimitExceeded"}))
+	assert.False(t, isStockoutError(errors.New("InsufficientInstanceCapacity")))
+	assert.False(t, isStockoutError(nil))
+}
+
+func TestBuildZoneCandidates(t *testing.T) {
+	p := newStockoutTestConfig(nil)
+	first := &requestConfig{availabilityZone: "us-east-1c", subnet: "subnet-ccc", size: "m5.large"}
+
+	got := p.buildZoneCandidates(first)
+	assert.Equal(t, []string{"us-east-1c", "us-east-1d", "us-east-1a"}, zonesOf(got))
+	assert.Equal(t, "subnet-ddd", got[1].subnet)
+	assert.Equal(t, "m5.large", got[1].size)
+}
+
+func TestBuildZoneCandidates_DeprioritizesStockout(t *testing.T) {
+	p := newStockoutTestConfig(nil)
+	p.markStockout("us-east-1a", "m5.large")
+	p.markStockout("us-east-1b", "m5.large")
+	// a stockout for another size must not affect the order
+	p.markStockout("us-east-1c", "c5.large")
+
+	first := &requestConfig{availabilityZone: "us-east-1a", subnet: "subnet-aaa", size: "m5.large"}
+	got := p.buildZoneCandidates(first)
+	assert.Equal(t, []string{"us-east-1c", "us-east-1d", "us-east-1a"}, zonesOf(got))
+}
+
+func TestGetDynamicConfig_RoundRobinSkipsStockoutZones(t *testing.T) {
+	p := newStockoutTestConfig(nil)
+	p.markStockout("us-east-1a", "m5.large")
+	p.markStockout("us-east-1b", "m5.large")
+
+	cfg, err := p.getDynamicConfig(&drtypes.InstanceCreateOpts{PoolName: "test-pool"})
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1c", cfg.availabilityZone)
+	assert.Equal(t, "subnet-ccc", cfg.subnet)
+
+	// the stockout is keyed by size, other sizes keep the round-robin zone
+	cfg, err = p.getDynamicConfig(&drtypes.InstanceCreateOpts{PoolName: "test-pool", MachineType: "c5.large"})
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1b", cfg.availabilityZone)
+
+	// a requested zone is always honoured
+	cfg, err = p.getDynamicConfig(&drtypes.InstanceCreateOpts{PoolName: "test-pool", Zones: []string{"us-east-1a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1a", cfg.availabilityZone)
+}
+
+func TestReserveCapacity_RetriesStockoutZone(t *testing.T) {
+	var attempted []string
+	mock := &mockEC2Client{
+		CreateCapacityReservationFunc: func(_ context.Context, params *ec2.CreateCapacityReservationInput, _ ...func(*ec2.Options)) (*ec2.CreateCapacityReservationOutput, error) {
+			zone := aws.ToString(params.AvailabilityZone)
+			attempted = append(attempted, zone)
+			if zone == "us-east-1a" {
+				return nil, &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity", Message: "no capacity"}
+			}
+			return &ec2.CreateCapacityReservationOutput{
+				CapacityReservation: &types.CapacityReservation{CapacityReservationId: aws.String("cr-123")},
+			}, nil
+		},
+	}
+	p := newStockoutTestConfig(mock)
+
+	capacity, err := p.ReserveCapacity(context.Background(), &drtypes.InstanceCreateOpts{PoolName: "test-pool"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"us-east-1a", "us-east-1b"}, attempted)
+	assert.Equal(t, "us-east-1b", capacity.GetZone())
+	assert.True(t, p.isStockoutZone("us-east-1a", "m5.large"))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.metrics.ZoneStockoutsCount.WithLabelValues(
+		"amazon", metric.StockoutOperationReserve, "us-east-1a", "m5.large")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.metrics.ZoneRetriesCount.WithLabelValues(
+		"amazon", metric.StockoutOperationReserve, metric.ReasonStockout, "us-east-1a")))
+}
+
+func TestReserveCapacity_RequestedZoneNotRetried(t *testing.T) {
+	attempts := 0
+	mock := &mockEC2Client{
+		CreateCapacityReservationFunc: func(_ context.Context, _ *ec2.CreateCapacityReservationInput, _ ...func(*ec2.Options)) (*ec2.CreateCapacityReservationOutput, error) {
+			attempts++
+			return nil, &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity", Message: "no capacity"}
+		},
+	}
+	p := newStockoutTestConfig(mock)
+
+	_, err := p.ReserveCapacity(context.Background(), &drtypes.InstanceCreateOpts{
+		PoolName: "test-pool",
+		Zones:    []string{"us-east-1b"},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.True(t, p.isStockoutZone("us-east-1b", "m5.large"))
+}
+
+func TestWithStockoutRetry(t *testing.T) {
+	p := newStockoutTestConfig(nil)
+	candidates := p.buildZoneCandidates(&requestConfig{availabilityZone: "us-east-1a", size: "m5.large"})
+
+	// non capacity errors fail fast
+	var attempted []string
+	_, err := p.withStockoutRetry(context.Background(), metric.StockoutOperationCreate, candidates, true, func(c *requestConfig) error {
+		attempted = append(attempted, c.availabilityZone)
+		return &smithy.GenericAPIError{Code: "InvalidParameterValue"}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"us-east-1a"}, attempted)
+
+	// a stockout in every zone gives up after the last candidate
+	attempted = nil
+	_, err = p.withStockoutRetry(context.Background(), metric.StockoutOperationCreate, candidates, false, func(c *requestConfig) error {
+		attempted = append(attempted, c.availabilityZone)
+		return &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity"}
+	})
+	assert.True(t, isStockoutError(err))
+	assert.Equal(t, []string{"us-east-1a", "us-east-1b", "us-east-1c"}, attempted)
+	assert.False(t, p.isStockoutZone("us-east-1a", "m5.large"), "zones are not remembered when markZones is off")
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.metrics.ZoneRetriesCount.WithLabelValues(
+		"amazon", metric.StockoutOperationCreate, metric.ReasonStockout, "us-east-1a"))+
+		testutil.ToFloat64(p.metrics.ZoneRetriesCount.WithLabelValues(
+			"amazon", metric.StockoutOperationCreate, metric.ReasonStockout, "us-east-1b")))
+}
+
+// TestCreate_FallsBackToNextSize verifies that once an instance type is out of
+// capacity in every candidate zone, Create moves on to the next size of the
+// pool's fallback list and records it on the instance.
+func TestCreate_FallsBackToNextSize(t *testing.T) {
+	var launches []string
+	service := &mockEC2Client{
+		DescribeSecurityGroupsFunc: func(context.Context, *ec2.DescribeSecurityGroupsInput, ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+			return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []types.SecurityGroup{{
+				GroupName: aws.String("sg"),
+				IpPermissions: []types.IpPermission{{
+					IpProtocol: aws.String("tcp"),
+					FromPort:   aws.Int32(9079),
+					ToPort:     aws.Int32(9079),
+				}},
+			}}}, nil
+		},
+		RunInstancesFunc: func(_ context.Context, params *ec2.RunInstancesInput, _ ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+			size := string(params.InstanceType)
+			launches = append(launches, size+"/"+aws.ToString(params.Placement.AvailabilityZone))
+			if size == "m5.large" {
+				return nil, &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity"}
+			}
+			return &ec2.RunInstancesOutput{Instances: []types.Instance{{InstanceId: aws.String("i-1")}}}, nil
+		},
+		DescribeInstancesFunc: func(context.Context, *ec2.DescribeInstancesInput, ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+			return &ec2.DescribeInstancesOutput{Reservations: []types.Reservation{{Instances: []types.Instance{{
+				InstanceId:       aws.String("i-1"),
+				PrivateIpAddress: aws.String("10.0.0.1"),
+			}}}}}, nil
+		},
+	}
+	p := newStockoutTestConfig(service)
+	p.image = "ami-0123456789"
+	p.groups = []string{"sg-1"}
+	p.sizeFallbacks = []string{"m5a.large"}
+	p.metrics.MachineTypeFallbacksCount = metric.MachineTypeFallbacksCount()
+
+	instance, err := p.Create(context.Background(), &drtypes.InstanceCreateOpts{PoolName: "pool"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, instance) {
+		assert.Equal(t, "m5a.large", instance.Size)
+	}
+	if assert.Len(t, launches, 4) {
+		for _, launch := range launches[:3] {
+			assert.Contains(t, launch, "m5.large/")
+		}
+		assert.Contains(t, launches[3], "m5a.large/")
+	}
+	assert.InDelta(t, 1, testutil.ToFloat64(p.metrics.MachineTypeFallbacksCount.WithLabelValues(
+		p.DriverName(), "m5.large", "m5a.large")), 0.0001)
+}
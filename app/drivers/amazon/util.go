@@ -40,6 +40,28 @@ func buildHarnessTags(opts *drtypes.InstanceCreateOpts) map[string]string {
 	}
 }
 
+// capacityReservationTags returns the tags of a capacity reservation: the
+// common harness tags and the identity labels its VM would get, so that
+// orphaned reservations can be found.
+func capacityReservationTags(opts *drtypes.InstanceCreateOpts) map[string]string {
+	tags := buildHarnessTags(opts)
+	for k, v := range opts.VMLabels {
+		tags[k] = v
+	}
+	return tags
+}
+
+// diskTags returns the tags of a persistent disk: its name and the identity
+// labels of the VM it is created for, so that orphaned disks can be found.
+func diskTags(opts *drtypes.InstanceCreateOpts, diskName string) map[string]string {
+	tags := make(map[string]string, len(opts.VMLabels)+1)
+	for k, v := range opts.VMLabels {
+		tags[k] = v
+	}
+	tags["Name"] = diskName
+	return tags
+}
+
 // helper function returns the base temporary directory based on the target platform.
 func tempdir(inputOS string) string {
 	const dir = "aws"
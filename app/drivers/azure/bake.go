@@ -0,0 +1,118 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+)
+
+var _ drivers.ImageBaker = (*config)(nil)
+
+// galleryVersionPattern matches the major.minor.patch names gallery image
+// versions must have.
+var galleryVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// BakeImage deallocates and generalizes the VM and publishes its OS disk as a
+// new version of the gallery image definition. The guest must have been
+// deprovisioned by the last provisioning script (waagent -deprovision+user on
+// Linux, sysprep on Windows), the same as for any generalized image. The image
+// name is used as the version when it is one, otherwise the version is derived
+// from the current time.
+func (c *config) BakeImage(ctx context.Context, instance *types.Instance, name string) (string, error) {
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.Azure).
+		WithField("pool", instance.Pool).
+		WithField("instanceID", instance.ID).
+		WithField("image", name)
+
+	if c.galleryName == "" || c.galleryImage == "" {
+		return "", errors.New("azure: a gallery name and image definition are required to bake images")
+	}
+	if c.galleryService == nil {
+		client, err := armcompute.NewGalleryImageVersionsClient(c.subscriptionID, c.cred, nil)
+		if err != nil {
+			return "", err
+		}
+		c.galleryService = client
+	}
+
+	poller, err := c.service.BeginDeallocate(ctx, c.resourceGroupName, instance.ID, nil)
+	if err == nil {
+		_, err = poller.PollUntilDone(ctx, nil)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("azure: failed to deallocate the VM to bake")
+		return "", err
+	}
+	if _, err = c.service.Generalize(ctx, c.resourceGroupName, instance.ID, nil); err != nil {
+		logr.WithError(err).Errorln("azure: failed to generalize the VM")
+		return "", err
+	}
+
+	vm, err := c.service.Get(ctx, c.resourceGroupName, instance.ID, nil)
+	if err != nil {
+		logr.WithError(err).Errorln("azure: failed to retrieve the VM")
+		return "", err
+	}
+	if vm.Properties == nil || vm.Properties.StorageProfile == nil || vm.Properties.StorageProfile.OSDisk == nil ||
+		vm.Properties.StorageProfile.OSDisk.ManagedDisk == nil || vm.Properties.StorageProfile.OSDisk.ManagedDisk.ID == nil {
+		return "", fmt.Errorf("azure: VM %s has no managed OS disk", instance.ID)
+	}
+	diskID := *vm.Properties.StorageProfile.OSDisk.ManagedDisk.ID
+
+	resourceGroup := c.galleryResourceGroup
+	if resourceGroup == "" {
+		resourceGroup = c.resourceGroupName
+	}
+	version := galleryVersion(name, time.Now().UTC())
+	logr = logr.WithField("version", version)
+	versionPoller, err := c.galleryService.BeginCreateOrUpdate(ctx, resourceGroup, c.galleryName, c.galleryImage, version, armcompute.GalleryImageVersion{
+		Location: to.Ptr(c.location),
+		Tags: map[string]*string{
+			"harness-pool-name":  to.Ptr(instance.Pool),
+			"harness-baked-from": to.Ptr(instance.ID),
+			"harness-image-name": to.Ptr(name),
+		},
+		Properties: &armcompute.GalleryImageVersionProperties{
+			StorageProfile: &armcompute.GalleryImageVersionStorageProfile{
+				OSDiskImage: &armcompute.GalleryOSDiskImage{
+					Source: &armcompute.GalleryArtifactVersionSource{ID: to.Ptr(diskID)},
+				},
+			},
+		},
+	}, nil)
+	if err != nil {
+		logr.WithError(err).Errorln("azure: failed to create gallery image version")
+		return "", err
+	}
+	created, err := versionPoller.PollUntilDone(ctx, nil)
+	if err != nil {
+		logr.WithError(err).Errorln("azure: gallery image version failed")
+		return "", err
+	}
+	if created.ID == nil {
+		return "", fmt.Errorf("azure: gallery image version %s has no ID", version)
+	}
+
+	logr.Infoln("azure: gallery image version created")
+	return *created.ID, nil
+}
+
+// galleryVersion returns the gallery image version to publish the image
+// named name as.
+func galleryVersion(name string, now time.Time) string {
+	if galleryVersionPattern.MatchString(name) {
+		return name
+	}
+	return fmt.Sprintf("%d.%d.%d", now.Year(), int(now.Month())*100+now.Day(), now.Hour()*10000+now.Minute()*100+now.Second()) //nolint:mnd
+}
@@ -0,0 +1,33 @@
+package azure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestGalleryVersion(t *testing.T) {
+	now := time.Date(2026, time.October, 7, 9, 5, 3, 0, time.UTC)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"1.2.3", "1.2.3"},
+		{"linux-20261007", "2026.1007.90503"},
+		{"1.2", "2026.1007.90503"},
+	}
+	for _, tt := range tests {
+		if got := galleryVersion(tt.name, now); got != tt.want {
+			t.Errorf("galleryVersion(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBakeImage_RequiresGallery(t *testing.T) {
+	c := &config{}
+	if _, err := c.BakeImage(context.Background(), &types.Instance{ID: "vm"}, "image"); err == nil {
+		t.Error("expected baking without a gallery to fail")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/drone-runners/drone-runner-aws/app/oshelp"
 	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
 	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
+	"github.com/drone-runners/drone-runner-aws/metric"
 	"github.com/drone-runners/drone-runner-aws/types"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
@@ -23,6 +25,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
 	"github.com/dchest/uniuri"
+	"github.com/hashicorp/golang-lru/v2/expirable"
 )
 
 var _ drivers.Driver = (*config)(nil)
@@ -50,22 +53,37 @@ type config struct {
 
 	IPAddress string
 
-	size        string
-	tags        map[string]string
-	zones       []*string
-	userData    string
-	userDataKey string
+	size          string
+	sizeFallbacks []string
+	tags          map[string]string
+	zones         []*string
+	userData      string
+	userDataKey   string
 
 	username string
 	password string
 
+	hibernate bool
+
+	// compute gallery image definition baked images are published to
+	galleryResourceGroup string
+	galleryName          string
+	galleryImage         string
+
 	// network configuration
 	privateIP  bool   // if true, don't create public IP
 	vnetName   string // existing VNet name (optional)
 	subnetName string // existing subnet name (optional)
 
-	service *armcompute.VirtualMachinesClient
-	cred    azcore.TokenCredential
+	service        *armcompute.VirtualMachinesClient
+	galleryService *armcompute.GalleryImageVersionsClient
+	cred           azcore.TokenCredential
+
+	// stockoutCache remembers (zone, size) pairs that recently returned
+	// ZonalAllocationFailed so zone selection can try other zones first.
+	stockoutCache *expirable.LRU[string, struct{}]
+	zoneIndex     uint64 // counter for round-robin zone selection
+	metrics       *metric.Metrics
 }
 
 func New(opts ...Option) (drivers.Driver, error) {
@@ -89,6 +107,7 @@ func New(opts ...Option) (drivers.Driver, error) {
 			return nil, err
 		}
 	}
+	p.stockoutCache = expirable.NewLRU[string, struct{}](stockoutCacheSize, nil, stockoutCacheTTL)
 	return p, nil
 }
 
@@ -105,11 +124,21 @@ func (c *config) InstanceType() string {
 }
 
 func (c *config) CanHibernate() bool {
-	return false
+	return c.hibernate
 }
 
 func (c *config) GetFullyQualifiedImage(_ context.Context, config *types.VMImageConfig) (string, error) {
-	return c.offer, nil
+	return c.image(), nil
+}
+
+// image returns the image VMs are created from, as recorded on instances: the
+// image ID when one is configured, so that a new gallery image version is
+// told apart from the previous one, otherwise the marketplace offer.
+func (c *config) image() string {
+	if c.id != "" {
+		return c.id
+	}
+	return c.offer
 }
 
 func (c *config) Zones() string {
@@ -158,6 +187,8 @@ func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		tags[k] = &tagValue
 	}
 
+	sizes := drivers.MachineTypes(opts, c.size, c.sizeFallbacks)
+
 	logr := logger.FromContext(ctx).
 		WithField("cloud", types.Azure).
 		WithField("name", name).
@@ -165,8 +196,9 @@ func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		WithField("pool", opts.PoolName).
 		WithField("zone", c.zones).
 		WithField("image", c.offer).
-		WithField("size", c.size).
-		WithField("private_ip", c.privateIP)
+		WithField("size", sizes[0]).
+		WithField("private_ip", c.privateIP).
+		WithField("hibernate", c.CanHibernate())
 
 	logr.Info("starting Azure Setup")
 
@@ -276,11 +308,10 @@ func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 
 	var in = armcompute.VirtualMachine{
 		Location: to.Ptr(c.location),
-		Zones:    c.zones,
 		Tags:     tags,
 		Properties: &armcompute.VirtualMachineProperties{
 			HardwareProfile: &armcompute.HardwareProfile{
-				VMSize: to.Ptr(armcompute.VirtualMachineSizeTypes(c.size)),
+				VMSize: to.Ptr(armcompute.VirtualMachineSizeTypes(sizes[0])),
 			},
 			StorageProfile: &armcompute.StorageProfile{
 				ImageReference: imageReference,
@@ -317,14 +348,46 @@ func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		in.Properties.SecurityProfile = securityProfile
 	}
 
-	poller, err := c.service.BeginCreateOrUpdate(ctx, c.resourceGroupName, name, in, nil)
-	if err != nil {
-		return nil, err
+	// hibernation can only be enabled when the VM is created
+	if c.hibernate {
+		in.Properties.AdditionalCapabilities = &armcompute.AdditionalCapabilities{
+			HibernationEnabled: to.Ptr(true),
+		}
 	}
-	vm, err := poller.PollUntilDone(ctx, nil)
+
+	// A VM is pinned to a single zone. Walk the configured zones and move on
+	// to the next one when a zone runs out of capacity for the size, then on
+	// to the next size once every zone is exhausted.
+	var vm armcompute.VirtualMachinesClientCreateOrUpdateResponse
+	var zone string
+	size, err := drivers.CreateWithMachineTypeFallback(ctx, c.DriverName(), sizes, isStockoutError, c.metrics, func(size string) error {
+		in.Properties.HardwareProfile.VMSize = to.Ptr(armcompute.VirtualMachineSizeTypes(size))
+		var retryErr error
+		zone, retryErr = c.withStockoutRetry(ctx, c.buildZoneCandidates(size), size, func(zone string) error {
+			in.Zones = nil
+			if zone != "" {
+				in.Zones = []*string{to.Ptr(zone)}
+			}
+			poller, createErr := c.service.BeginCreateOrUpdate(ctx, c.resourceGroupName, name, in, nil)
+			if createErr != nil {
+				return createErr
+			}
+			vm, createErr = poller.PollUntilDone(ctx, nil)
+			return createErr
+		}, func(zone string) {
+			// the zone of an existing VM cannot be changed, remove the failed one first
+			c.cleanupFailedVM(ctx, name, diskName, logr.WithField("zone", zone))
+		})
+		return retryErr
+	}, func(string) {
+		// neither can its size while it is allocated
+		c.cleanupFailedVM(ctx, name, diskName, logr.WithField("zone", zone))
+	})
 	if err != nil {
+		logr.WithError(err).Errorln("azure: failed to provision VM")
 		return nil, err
 	}
+	logr = logr.WithField("zone", zone).WithField("size", size)
 	// if windows add extension to vm
 	if opts.OS == oshelp.OSWindows {
 		_, extensionErr := c.addExtension(ctx, name)
@@ -340,7 +403,7 @@ func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		WithField("time", fmt.Sprintf("%.2fs", time.Since(startTime).Seconds())).
 		Debugln("azure: [provision] VM provisioned")
 
-	instanceMap := c.mapToInstance(&vm, opts)
+	instanceMap := c.mapToInstance(&vm, opts, zone, size)
 	logr.
 		WithField("ip", instanceMap.Address).
 		WithField("time", fmt.Sprintf("%.2fs", time.Since(startTime).Seconds())).
@@ -349,6 +412,22 @@ func (c *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 	return &instanceMap, nil
 }
 
+// cleanupFailedVM removes a VM whose allocation failed, along with its OS disk,
+// so the create can be retried under the same name in another zone.
+func (c *config) cleanupFailedVM(ctx context.Context, name, diskName string, logr logger.Logger) {
+	poller, err := c.service.BeginDelete(ctx, c.resourceGroupName, name, nil)
+	if err == nil {
+		_, err = poller.PollUntilDone(ctx, nil)
+	}
+	if err != nil {
+		logr.WithError(err).Warnln("azure: failed to delete stocked-out VM before retry")
+		return
+	}
+	if err = c.deleteDisk(ctx, diskName); err != nil {
+		logr.WithError(err).Debugln("azure: no disk to delete for stocked-out VM")
+	}
+}
+
 func (c *config) Destroy(ctx context.Context, instances []*types.Instance) ([]*types.Instance, error) {
 	return c.DestroyInstanceAndStorage(ctx, instances, nil)
 }
@@ -427,12 +506,89 @@ func (c *config) DestroyInstanceAndStorage(ctx context.Context, instances []*typ
 	return nil, nil
 }
 
-func (c *config) Hibernate(_ context.Context, _, _, _ string) error {
-	return errors.New("unimplemented")
+func (c *config) Hibernate(ctx context.Context, instanceID, poolName, _ string) error {
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.Azure).
+		WithField("pool", poolName).
+		WithField("instanceID", instanceID).
+		WithField("hibernate", c.hibernate)
+
+	if !c.hibernate {
+		return errors.New("azure: hibernation is not enabled for this pool")
+	}
+
+	poller, err := c.service.BeginDeallocate(ctx, c.resourceGroupName, instanceID,
+		&armcompute.VirtualMachinesClientBeginDeallocateOptions{Hibernate: to.Ptr(true)})
+	if err == nil {
+		_, err = poller.PollUntilDone(ctx, nil)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("azure: failed to hibernate the VM")
+		if isHibernateRetryable(err) {
+			return &ierrors.RetryableError{Msg: err.Error()}
+		}
+		return err
+	}
+
+	logr.Traceln("azure: VM hibernated")
+	return nil
 }
 
-func (c *config) Start(_ context.Context, _ *types.Instance, _ string) (ipAddress string, err error) {
-	return "", errors.New("unimplemented")
+// isHibernateRetryable reports whether a hibernate request failed only
+// because the VM is not ready yet. Azure rejects hibernation while the guest
+// is still booting or another operation on the VM is in progress.
+func isHibernateRetryable(err error) bool {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	return respErr.StatusCode == http.StatusConflict || respErr.ErrorCode == "OperationNotAllowed"
+}
+
+func (c *config) Start(ctx context.Context, instance *types.Instance, poolName string) (ipAddress string, err error) {
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.Azure).
+		WithField("pool", poolName).
+		WithField("instanceID", instance.ID)
+
+	view, err := c.service.InstanceView(ctx, c.resourceGroupName, instance.ID, nil)
+	if err != nil {
+		logr.WithError(err).Errorln("azure: failed to find VM status")
+		return "", fmt.Errorf("failed to get instance view: %w", err)
+	}
+
+	if powerState(view.Statuses) != powerStateRunning {
+		poller, startErr := c.service.BeginStart(ctx, c.resourceGroupName, instance.ID, nil)
+		if startErr == nil {
+			_, startErr = poller.PollUntilDone(ctx, nil)
+		}
+		if startErr != nil {
+			logr.WithError(startErr).Errorln("azure: failed to start VM")
+			return "", startErr
+		}
+		logr.Traceln("azure: VM started")
+	}
+
+	ipAddress, err = c.getInstanceAddress(ctx, instance.ID)
+	if err != nil {
+		logr.WithError(err).Errorln("azure: failed to retrieve IP address of the VM")
+		return "", err
+	}
+	return ipAddress, nil
+}
+
+const powerStateRunning = "running"
+
+// powerState returns the power state of a VM from its instance view
+// statuses, e.g. running, deallocating or deallocated.
+func powerState(statuses []*armcompute.InstanceViewStatus) string {
+	const prefix = "PowerState/"
+	for _, status := range statuses {
+		if status != nil && status.Code != nil && strings.HasPrefix(*status.Code, prefix) {
+			return strings.TrimPrefix(*status.Code, prefix)
+		}
+	}
+	return ""
 }
 
 func (c *config) Ping(ctx context.Context) error {
@@ -456,16 +612,19 @@ func (c *config) SetLabels(context.Context, *types.Instance, map[string]string)
 	return nil
 }
 
-func (c *config) mapToInstance(vm *armcompute.VirtualMachinesClientCreateOrUpdateResponse, opts *types.InstanceCreateOpts) types.Instance {
+func (c *config) mapToInstance(vm *armcompute.VirtualMachinesClientCreateOrUpdateResponse, opts *types.InstanceCreateOpts, zone, size string) types.Instance {
+	if zone == "" {
+		zone = c.Zones()
+	}
 	return types.Instance{
 		ID:           *vm.Name,
 		Name:         *vm.Name,
 		Provider:     types.Azure,
 		State:        types.StateProvisioning,
 		Pool:         opts.PoolName,
-		Image:        c.offer,
-		Zone:         c.Zones(),
-		Size:         c.size,
+		Image:        c.image(),
+		Zone:         zone,
+		Size:         size,
 		Platform:     opts.Platform,
 		Address:      c.IPAddress,
 		CACert:       opts.CACert,
@@ -1,8 +1,14 @@
 package azure
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+
 	"github.com/drone-runners/drone-runner-aws/app/oshelp"
 	"github.com/drone-runners/drone-runner-aws/types"
 )
@@ -245,6 +251,55 @@ func TestCanHibernate(t *testing.T) {
 	}
 }
 
+func TestWithHibernate(t *testing.T) {
+	c := &config{}
+	WithHibernate(true)(c)
+	if !c.CanHibernate() {
+		t.Errorf("CanHibernate() = false, want true")
+	}
+}
+
+func TestHibernateNotEnabled(t *testing.T) {
+	c := &config{}
+	if err := c.Hibernate(context.Background(), "vm-1", "pool", ""); err == nil {
+		t.Errorf("expected an error when hibernation is not enabled")
+	}
+}
+
+func TestIsHibernateRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "conflicting operation", err: &azcore.ResponseError{StatusCode: http.StatusConflict}, want: true},
+		{name: "guest not ready", err: &azcore.ResponseError{StatusCode: http.StatusBadRequest, ErrorCode: "OperationNotAllowed"}, want: true},
+		{name: "not found", err: &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "ResourceNotFound"}, want: false},
+		{name: "plain error", err: errors.New("boom"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isHibernateRetryable(tt.err); got != tt.want {
+				t.Errorf("isHibernateRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPowerState(t *testing.T) {
+	statuses := []*armcompute.InstanceViewStatus{
+		{Code: strPtr("ProvisioningState/succeeded")},
+		nil,
+		{Code: strPtr("PowerState/deallocated")},
+	}
+	if got := powerState(statuses); got != "deallocated" {
+		t.Errorf("powerState() = %v, want deallocated", got)
+	}
+	if got := powerState(nil); got != "" {
+		t.Errorf("powerState(nil) = %v, want empty", got)
+	}
+}
+
 func TestInstanceType(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -274,6 +329,37 @@ func TestInstanceType(t *testing.T) {
 	}
 }
 
+func TestGetFullyQualifiedImage(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *config
+		want string
+	}{
+		{
+			name: "marketplace image",
+			c:    &config{publisher: "MicrosoftWindowsServer", offer: "WindowsServer", sku: "2022-datacenter"},
+			want: "WindowsServer",
+		},
+		{
+			name: "image id",
+			c:    &config{offer: "WindowsServer", id: "/subscriptions/s/galleries/g/images/w/versions/1.0.1"},
+			want: "/subscriptions/s/galleries/g/images/w/versions/1.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.c.GetFullyQualifiedImage(context.Background(), &types.VMImageConfig{})
+			if err != nil {
+				t.Fatalf("GetFullyQualifiedImage() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetFullyQualifiedImage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestRootDir(t *testing.T) {
 	tests := []struct {
 		name    string
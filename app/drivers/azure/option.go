@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/drone-runners/drone-runner-aws/app/oshelp"
+	"github.com/drone-runners/drone-runner-aws/metric"
 	"github.com/drone-runners/drone-runner-aws/types"
 
 	"github.com/sirupsen/logrus"
@@ -80,6 +81,14 @@ func WithSize(size string) Option {
 	}
 }
 
+// WithSizeFallbacks returns an option to set the VM sizes tried, in order,
+// when the pool's size is out of capacity in every zone.
+func WithSizeFallbacks(sizes ...string) Option {
+	return func(p *config) {
+		p.sizeFallbacks = sizes
+	}
+}
+
 func WithImage(publisher, offer, sku, version string) Option {
 	return func(p *config) {
 		p.publisher = publisher
@@ -153,21 +162,35 @@ func WithUserDataKey(text, platform string) Option {
 	}
 }
 
+// WithZones sets the availability zones VMs are created in. Each VM is placed
+// in a single zone, picked round-robin with recently stocked-out zones tried
+// last.
 func WithZones(zones ...string) Option {
 	var z []*string
-	if len(zones) > 0 {
-		zone1 := "1"
-		z = append(z, &zone1)
-	} else {
-		for zone := range zones {
-			z = append(z, &zones[zone])
-		}
+	for i := range zones {
+		z = append(z, &zones[i])
 	}
 	return func(p *config) {
 		p.zones = z
 	}
 }
 
+// WithHibernate creates VMs with hibernation enabled so free instances can be
+// hibernated and resumed.
+func WithHibernate(hibernate bool) Option {
+	return func(p *config) {
+		p.hibernate = hibernate
+	}
+}
+
+// WithMetrics returns an option to set the metrics used to record zone
+// stockouts and retries. Nil is safe and disables instrumentation.
+func WithMetrics(m *metric.Metrics) Option {
+	return func(p *config) {
+		p.metrics = m
+	}
+}
+
 // WithTags returns an option to set the resource tags.
 func WithTags(t map[string]string) Option {
 	return func(p *config) {
@@ -201,3 +224,14 @@ func WithSubnet(subnetName string) Option {
 		p.subnetName = subnetName
 	}
 }
+
+// WithGallery returns an option to set the compute gallery image definition
+// that baked images are published to. The resource group defaults to the one
+// of the pool.
+func WithGallery(resourceGroup, name, image string) Option {
+	return func(p *config) {
+		p.galleryResourceGroup = resourceGroup
+		p.galleryName = name
+		p.galleryImage = image
+	}
+}
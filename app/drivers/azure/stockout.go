@@ -0,0 +1,158 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/metric"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+const (
+	// maxStockoutAttempts caps how many zones a single create tries before
+	// giving up, so a region wide stockout fails fast.
+	maxStockoutAttempts = 3
+
+	stockoutCacheTTL = 900 * time.Second
+	// stockoutCacheSize bounds the number of remembered (zone, size) keys.
+	stockoutCacheSize = 1024
+)
+
+// stockoutErrorCodes are the Azure error codes returned when a zone has no
+// capacity left for the requested VM size.
+var stockoutErrorCodes = []string{
+	"ZonalAllocationFailed",
+}
+
+func isStockoutError(err error) bool {
+	if err == nil {
+		return false
+	}
+	candidates := []string{err.Error()}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		candidates = append(candidates, respErr.ErrorCode)
+	}
+	for _, s := range candidates {
+		for _, code := range stockoutErrorCodes {
+			if strings.Contains(s, code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stockoutKey is the cache key for a recently-exhausted (location, zone, size).
+func (c *config) stockoutKey(zone, size string) string {
+	return c.location + ":" + zone + ":" + size
+}
+
+// markStockout records that (zone, size) just returned ZonalAllocationFailed
+// so zone selection deprioritizes it until the entry expires.
+func (c *config) markStockout(zone, size string) {
+	if c.stockoutCache == nil || zone == "" {
+		return
+	}
+	c.stockoutCache.Add(c.stockoutKey(zone, size), struct{}{})
+}
+
+// isStockoutZone reports whether (zone, size) is currently remembered as
+// recently exhausted.
+func (c *config) isStockoutZone(zone, size string) bool {
+	if c.stockoutCache == nil {
+		return false
+	}
+	_, found := c.stockoutCache.Get(c.stockoutKey(zone, size))
+	return found
+}
+
+// buildZoneCandidates returns the ordered, capped list of zones to create the
+// VM in. The configured zones are walked round-robin so VMs spread across
+// them, with recently stocked-out zones moved to the back. Without zones the
+// VM is regional and a single empty candidate is returned.
+func (c *config) buildZoneCandidates(size string) []string {
+	if len(c.zones) == 0 {
+		return []string{""}
+	}
+	numZones := uint64(len(c.zones))
+	start := atomic.AddUint64(&c.zoneIndex, 1) - 1
+	candidates := make([]string, 0, numZones)
+	for k := uint64(0); k < numZones; k++ {
+		if zone := c.zones[(start+k)%numZones]; zone != nil && *zone != "" {
+			candidates = append(candidates, *zone)
+		}
+	}
+
+	candidates = c.deprioritizeStockoutZones(candidates, size)
+
+	if len(candidates) > maxStockoutAttempts {
+		candidates = candidates[:maxStockoutAttempts]
+	}
+	return candidates
+}
+
+// deprioritizeStockoutZones stable-partitions zones so that zones not recently
+// stocked out come first and recently exhausted zones move to the back.
+// Nothing is removed, so the candidate set is never emptied.
+func (c *config) deprioritizeStockoutZones(zones []string, size string) []string {
+	if c.stockoutCache == nil {
+		return zones
+	}
+	healthy := make([]string, 0, len(zones))
+	exhausted := make([]string, 0)
+	for _, z := range zones {
+		if c.isStockoutZone(z, size) {
+			exhausted = append(exhausted, z)
+		} else {
+			healthy = append(healthy, z)
+		}
+	}
+	return append(healthy, exhausted...)
+}
+
+// withStockoutRetry runs attempt for each zone in order. On a stockout the zone
+// is remembered, cleanup runs for the failed attempt and the next zone is
+// tried; any other error is returned right away. It returns the zone that
+// succeeded.
+func (c *config) withStockoutRetry(
+	ctx context.Context,
+	zones []string,
+	size string,
+	attempt func(zone string) error,
+	cleanup func(zone string),
+) (string, error) {
+	logr := logger.FromContext(ctx).WithField("driver", c.DriverName())
+	var err error
+	for i, zone := range zones {
+		err = attempt(zone)
+		if err == nil {
+			return zone, nil
+		}
+		if !isStockoutError(err) {
+			return zone, err
+		}
+		attemptLogr := logr.WithError(err).
+			WithField("zone", zone).
+			WithField("size", size).
+			WithField("attempt", i+1)
+		attemptLogr.Warnln("azure: stockout detected for zone")
+		c.metrics.RecordZoneStockout(c.DriverName(), metric.StockoutOperationCreate, zone, size)
+		c.markStockout(zone, size)
+		if i < len(zones)-1 {
+			attemptLogr.WithField("next_zone", zones[i+1]).
+				Warnln("azure: zone stockout, retrying alternate zone")
+			c.metrics.RecordZoneRetry(c.DriverName(), metric.StockoutOperationCreate, metric.ReasonStockout, zone)
+			if cleanup != nil {
+				cleanup(zone)
+			}
+		}
+	}
+	return zones[len(zones)-1], err
+}
@@ -0,0 +1,145 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/drone-runners/drone-runner-aws/metric"
+)
+
+func newStockoutTestConfig(zones ...string) *config {
+	c := &config{
+		location:      "eastus",
+		stockoutCache: expirable.NewLRU[string, struct{}](stockoutCacheSize, nil, stockoutCacheTTL),
+		metrics: &metric.Metrics{
+			ZoneStockoutsCount: metric.ZoneStockoutsCount(),
+			ZoneRetriesCount:   metric.ZoneRetriesCount(),
+		},
+	}
+	WithZones(zones...)(c)
+	return c
+}
+
+func TestIsStockoutError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "response error code", err: &azcore.ResponseError{ErrorCode: "ZonalAllocationFailed"}, want: true},
+		{name: "wrapped response error", err: fmt.Errorf("create: %w", &azcore.ResponseError{ErrorCode: "ZonalAllocationFailed"}), want: true},
+		{name: "long running operation message", err: errors.New("Code: ZonalAllocationFailed, Message: Allocation failed"), want: true},
+		{name: "quota", err: &azcore.ResponseError{ErrorCode: "OperationNotAllowed"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isStockoutError(tt.err); got != tt.want {
+				t.Errorf("isStockoutError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithZones(t *testing.T) {
+	c := &config{}
+	WithZones("1", "2", "3")(c)
+	if got := c.Zones(); got != "1,2,3," {
+		t.Errorf("Zones() = %v, want %v", got, "1,2,3,")
+	}
+	c = &config{}
+	WithZones()(c)
+	if len(c.zones) != 0 {
+		t.Errorf("expected no zones, got %d", len(c.zones))
+	}
+}
+
+func TestBuildZoneCandidates_RoundRobin(t *testing.T) {
+	c := newStockoutTestConfig("1", "2", "3")
+	firsts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		candidates := c.buildZoneCandidates("Standard_D4s_v5")
+		if len(candidates) != 3 {
+			t.Fatalf("expected 3 candidates, got %v", candidates)
+		}
+		firsts[candidates[0]]++
+	}
+	for _, z := range []string{"1", "2", "3"} {
+		if firsts[z] != 2 {
+			t.Errorf("zone %s was picked first %d times, want 2", z, firsts[z])
+		}
+	}
+}
+
+func TestBuildZoneCandidates_NoZones(t *testing.T) {
+	c := newStockoutTestConfig()
+	got := c.buildZoneCandidates("Standard_D4s_v5")
+	if len(got) != 1 || got[0] != "" {
+		t.Errorf("expected a single regional candidate, got %v", got)
+	}
+}
+
+func TestBuildZoneCandidates_DeprioritizesStockout(t *testing.T) {
+	c := newStockoutTestConfig("1", "2", "3")
+	c.markStockout("1", "Standard_D4s_v5")
+	// a stockout for another size must not affect the order
+	c.markStockout("2", "Standard_D8s_v5")
+
+	got := c.buildZoneCandidates("Standard_D4s_v5")
+	want := []string{"2", "3", "1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("buildZoneCandidates() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestWithStockoutRetry(t *testing.T) {
+	c := newStockoutTestConfig("1", "2", "3")
+	size := "Standard_D4s_v5"
+
+	var attempted, cleaned []string
+	zone, err := c.withStockoutRetry(context.Background(), []string{"1", "2", "3"}, size, func(zone string) error {
+		attempted = append(attempted, zone)
+		if zone == "1" {
+			return &azcore.ResponseError{ErrorCode: "ZonalAllocationFailed"}
+		}
+		return nil
+	}, func(zone string) {
+		cleaned = append(cleaned, zone)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if zone != "2" || len(attempted) != 2 {
+		t.Errorf("expected create to succeed in zone 2 on the second attempt, got zone %q after %v", zone, attempted)
+	}
+	if len(cleaned) != 1 || cleaned[0] != "1" {
+		t.Errorf("expected the failed VM in zone 1 to be cleaned up, got %v", cleaned)
+	}
+	if !c.isStockoutZone("1", size) {
+		t.Errorf("expected zone 1 to be remembered as stocked out")
+	}
+	if got := testutil.ToFloat64(c.metrics.ZoneStockoutsCount.WithLabelValues("azure", metric.StockoutOperationCreate, "1", size)); got != 1 {
+		t.Errorf("ZoneStockoutsCount = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(c.metrics.ZoneRetriesCount.WithLabelValues("azure", metric.StockoutOperationCreate, metric.ReasonStockout, "1")); got != 1 {
+		t.Errorf("ZoneRetriesCount = %v, want 1", got)
+	}
+
+	// other errors fail fast
+	attempted = nil
+	_, err = c.withStockoutRetry(context.Background(), []string{"1", "2"}, size, func(zone string) error {
+		attempted = append(attempted, zone)
+		return errors.New("boom")
+	}, nil)
+	if err == nil || len(attempted) != 1 {
+		t.Errorf("expected a single failed attempt, got %v (err %v)", attempted, err)
+	}
+}
@@ -2,6 +2,8 @@ package azure
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
@@ -382,6 +384,39 @@ func tempdir(inputOS string) string {
 	}
 }
 
+// getInstanceAddress looks up the address of an existing VM from the network
+// resources created with it. The public IP is static and the private IP stays
+// allocated while the network interface exists, so both survive a hibernate.
+func (c *config) getInstanceAddress(ctx context.Context, instanceID string) (string, error) {
+	if c.privateIP {
+		nicClient, err := armnetwork.NewInterfacesClient(c.subscriptionID, c.cred, nil)
+		if err != nil {
+			return "", err
+		}
+		nic, err := nicClient.Get(ctx, c.resourceGroupName, fmt.Sprintf("%s-networkinterface", instanceID), nil)
+		if err != nil {
+			return "", err
+		}
+		if ip := c.getPrivateIPFromInterface(&nic.Interface); ip != "" {
+			return ip, nil
+		}
+		return "", errors.New("failed to get private IP address from network interface")
+	}
+
+	publicIPAddressClient, err := armnetwork.NewPublicIPAddressesClient(c.subscriptionID, c.cred, nil)
+	if err != nil {
+		return "", err
+	}
+	publicIP, err := publicIPAddressClient.Get(ctx, c.resourceGroupName, fmt.Sprintf("%s-publicip", instanceID), nil)
+	if err != nil {
+		return "", err
+	}
+	if publicIP.Properties == nil || publicIP.Properties.IPAddress == nil {
+		return "", errors.New("failed to get public IP address: properties or IP address is nil")
+	}
+	return *publicIP.Properties.IPAddress, nil
+}
+
 // getPrivateIPFromInterface extracts the private IP address from a network interface.
 // Returns empty string if the IP cannot be obtained (similar to AWS/GCP patterns).
 func (c *config) getPrivateIPFromInterface(nic *armnetwork.Interface) string {
@@ -0,0 +1,139 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
+
+	"github.com/drone-runners/drone-runner-aws/app/lehelper"
+	"github.com/drone-runners/drone-runner-aws/app/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// bakeScriptTimeout bounds how long a single provisioning script may run.
+const bakeScriptTimeout = time.Hour
+
+// ImageBaker is implemented by drivers that can snapshot an instance into a
+// machine image that new instances of the pool boot from.
+type ImageBaker interface {
+	// BakeImage stops the instance and creates an image named name from its
+	// boot disk. It returns the reference the pool spec points at the image
+	// with: the image path on GCE, the AMI ID on AWS and the gallery image
+	// version ID on Azure.
+	BakeImage(ctx context.Context, instance *types.Instance, name string) (string, error)
+}
+
+// BakeScript is a provisioning script run on the instance before it is baked.
+type BakeScript struct {
+	Name   string
+	Script string
+}
+
+// BakeOptions configures Manager.BakeImage.
+type BakeOptions struct {
+	// ImageName is the name of the new image.
+	ImageName string
+	// Scripts run in order through the lite-engine of the instance. Baking
+	// stops at the first script that fails.
+	Scripts []BakeScript
+}
+
+// newBakeClient returns the lite-engine client scripts are run with. It is a
+// variable so tests can replace it.
+var newBakeClient = func(instance *types.Instance, serverName string) (lehttp.Client, error) {
+	return lehelper.GetClient(instance, serverName, instance.Port, false, 0)
+}
+
+// BakeImage runs the provisioning scripts on a free instance of the pool and
+// snapshots it into a new machine image, returning the image reference. The
+// instance is claimed first so that no stage is scheduled on it, and destroyed
+// afterwards whatever the outcome: the snapshot leaves it stopped and a failed
+// script leaves it half provisioned. The pool replaces it as usual.
+func (m *Manager) BakeImage(ctx context.Context, poolName, instanceID string, opts *BakeOptions) (string, error) {
+	pool := m.poolMap[poolName]
+	if pool == nil {
+		return "", fmt.Errorf("bake: pool %q not found", poolName)
+	}
+	if opts == nil || opts.ImageName == "" {
+		return "", errors.New("bake: an image name is required")
+	}
+	instance, err := m.Find(ctx, instanceID)
+	if err != nil || instance == nil {
+		return "", fmt.Errorf("bake: failed to find instance %q: %w", instanceID, err)
+	}
+	if instance.Pool != poolName {
+		return "", fmt.Errorf("bake: instance %q belongs to pool %q, not %q", instanceID, instance.Pool, poolName)
+	}
+
+	driver := pool.DriverForTenant(instance.TenantID)
+	baker, ok := As[ImageBaker](driver)
+	if !ok {
+		return "", fmt.Errorf("bake: the %s driver cannot bake images", driver.DriverName())
+	}
+
+	logr := logger.FromContext(ctx).
+		WithField("driver", driver.DriverName()).
+		WithField("pool", poolName).
+		WithField("instance_id", instanceID).
+		WithField("image", opts.ImageName)
+
+	instance, err = m.claimFree(ctx, pool, instance, types.StateInUse)
+	if err != nil {
+		return "", fmt.Errorf("bake: failed to claim instance %q: %w", instanceID, err)
+	}
+	if instance == nil {
+		return "", fmt.Errorf("bake: instance %q is not free", instanceID)
+	}
+	defer func() {
+		if destroyErr := m.Destroy(context.Background(), poolName, instanceID, instance, nil); destroyErr != nil {
+			logr.WithError(destroyErr).Errorln("bake: failed to destroy the baked instance")
+		}
+	}()
+
+	client, err := newBakeClient(instance, m.GetTLSServerName())
+	if err != nil {
+		return "", fmt.Errorf("bake: failed to create the lite engine client: %w", err)
+	}
+	for _, script := range opts.Scripts {
+		logr.WithField("script", script.Name).Infoln("bake: running provisioning script")
+		if err = m.runBakeScript(ctx, client, instance, script); err != nil {
+			return "", err
+		}
+	}
+
+	logr.Infoln("bake: creating image")
+	image, err := baker.BakeImage(ctx, instance, opts.ImageName)
+	if err != nil {
+		return "", fmt.Errorf("bake: failed to create image %q: %w", opts.ImageName, err)
+	}
+	logr.WithField("image_ref", image).Infoln("bake: image created")
+	return image, nil
+}
+
+func (m *Manager) runBakeScript(ctx context.Context, client lehttp.Client, instance *types.Instance, script BakeScript) error {
+	req := &api.StartStepRequest{
+		ID:   oshelp.Random(),
+		Name: script.Name,
+		Kind: api.Run,
+		Run: api.RunConfig{
+			Entrypoint: oshelp.GetEntrypoint(instance.Platform.OS),
+			Command:    []string{script.Script},
+		},
+	}
+	if _, err := client.RetryStartStep(ctx, req, m.GetStartStepTimeout()); err != nil {
+		return fmt.Errorf("bake: failed to start script %s: %w", script.Name, err)
+	}
+	resp, err := client.RetryPollStep(ctx, &api.PollStepRequest{ID: req.ID}, bakeScriptTimeout)
+	if err != nil {
+		return fmt.Errorf("bake: failed to run script %s: %w", script.Name, err)
+	}
+	if resp.Error != "" || resp.ExitCode != 0 {
+		return fmt.Errorf("bake: script %s exited with code %d: %s", script.Name, resp.ExitCode, resp.Error)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/harness/lite-engine/api"
+	lehttp "github.com/harness/lite-engine/cli/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// bakingMockDriver is a flexibleMockDriver that can bake images.
+type bakingMockDriver struct {
+	flexibleMockDriver
+	baked []string
+}
+
+func (d *bakingMockDriver) BakeImage(_ context.Context, instance *types.Instance, name string) (string, error) {
+	d.baked = append(d.baked, instance.ID)
+	return "images/" + name, nil
+}
+
+// fakeBakeClient records the scripts run through it. The embedded interface
+// is nil, calling any other method panics.
+type fakeBakeClient struct {
+	lehttp.Client
+	scripts   []string
+	exitCodes map[string]int
+}
+
+func (c *fakeBakeClient) RetryStartStep(_ context.Context, in *api.StartStepRequest, _ time.Duration) (*api.StartStepResponse, error) {
+	c.scripts = append(c.scripts, in.Name)
+	return &api.StartStepResponse{}, nil
+}
+
+func (c *fakeBakeClient) RetryPollStep(_ context.Context, _ *api.PollStepRequest, _ time.Duration) (*api.PollStepResponse, error) {
+	return &api.PollStepResponse{Exited: true, ExitCode: c.exitCodes[c.scripts[len(c.scripts)-1]]}, nil
+}
+
+func newBakeTestManager(t *testing.T, driver Driver, client *fakeBakeClient) (m *Manager, claimed, deleted *[]string) {
+	t.Helper()
+	claimed, deleted = new([]string), new([]string)
+	instance := &types.Instance{ID: "inst-1", Pool: "pool1", State: types.StateCreated}
+	store := &mockInstanceStore{
+		FindFunc: func(_ context.Context, id string) (*types.Instance, error) {
+			if id != instance.ID {
+				return nil, errors.New("not found")
+			}
+			return instance, nil
+		},
+		FindAndClaimFunc: func(ctx context.Context, params *types.QueryParams, newState types.InstanceState, allowedStates []types.InstanceState, updateStartTime bool) (*types.Instance, error) {
+			assert.Equal(t, types.StateInUse, newState)
+			inst, err := claimByID(instance)(ctx, params, newState, allowedStates, updateStartTime)
+			if err == nil {
+				*claimed = append(*claimed, inst.ID)
+			}
+			return inst, err
+		},
+		DeleteFunc: func(_ context.Context, id string) error {
+			*deleted = append(*deleted, id)
+			return nil
+		},
+	}
+	original := newBakeClient
+	newBakeClient = func(*types.Instance, string) (lehttp.Client, error) { return client, nil }
+	t.Cleanup(func() { newBakeClient = original })
+	return &Manager{
+		poolMap:       map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: driver}}},
+		instanceStore: store,
+	}, claimed, deleted
+}
+
+func TestManager_BakeImage(t *testing.T) {
+	driver := &bakingMockDriver{flexibleMockDriver: flexibleMockDriver{driverName: "google"}}
+	client := &fakeBakeClient{}
+	m, claimed, deleted := newBakeTestManager(t, driver, client)
+
+	image, err := m.BakeImage(context.Background(), "pool1", "inst-1", &BakeOptions{
+		ImageName: "pool1-20261017",
+		Scripts:   []BakeScript{{Name: "docker.sh", Script: "apt-get install -y docker.io"}, {Name: "cache.sh", Script: "docker pull alpine"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "images/pool1-20261017", image)
+	assert.Equal(t, []string{"docker.sh", "cache.sh"}, client.scripts)
+	assert.Equal(t, []string{"inst-1"}, *claimed, "the instance must be claimed before it is baked")
+	assert.Equal(t, []string{"inst-1"}, driver.baked)
+	assert.Equal(t, []string{"inst-1"}, *deleted, "the baked instance must be destroyed")
+}
+
+func TestManager_BakeImage_ScriptFails(t *testing.T) {
+	driver := &bakingMockDriver{flexibleMockDriver: flexibleMockDriver{driverName: "google"}}
+	client := &fakeBakeClient{exitCodes: map[string]int{"first.sh": 2}}
+	m, _, deleted := newBakeTestManager(t, driver, client)
+
+	_, err := m.BakeImage(context.Background(), "pool1", "inst-1", &BakeOptions{
+		ImageName: "image",
+		Scripts:   []BakeScript{{Name: "first.sh"}, {Name: "second.sh"}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "first.sh exited with code 2")
+	assert.Equal(t, []string{"first.sh"}, client.scripts, "baking must stop at the failed script")
+	assert.Empty(t, driver.baked)
+	assert.Equal(t, []string{"inst-1"}, *deleted, "a half provisioned instance must not go back to the pool")
+}
+
+func TestManager_BakeImage_NotSupported(t *testing.T) {
+	limited := NewRateLimitedDriver(&flexibleMockDriver{driverName: "hetzner"}, "pool1", RateLimits{MaxInFlight: 1}, nil)
+	m, claimed, _ := newBakeTestManager(t, limited, &fakeBakeClient{})
+
+	_, err := m.BakeImage(context.Background(), "pool1", "inst-1", &BakeOptions{ImageName: "image"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot bake images")
+	assert.Empty(t, *claimed, "an instance must not be claimed when the driver cannot bake it")
+}
+
+func TestManager_BakeImage_RateLimited(t *testing.T) {
+	driver := &bakingMockDriver{flexibleMockDriver: flexibleMockDriver{driverName: "amazon"}}
+	limited := NewRateLimitedDriver(driver, "pool1", RateLimits{MaxInFlight: 1}, nil)
+	m, _, _ := newBakeTestManager(t, limited, &fakeBakeClient{})
+
+	image, err := m.BakeImage(context.Background(), "pool1", "inst-1", &BakeOptions{ImageName: "ami"})
+	require.NoError(t, err)
+	assert.Equal(t, "images/ami", image)
+}
+
+func TestManager_BakeImage_WrongPool(t *testing.T) {
+	m, claimed, _ := newBakeTestManager(t, &bakingMockDriver{}, &fakeBakeClient{})
+	m.poolMap["pool2"] = &poolEntry{Pool: Pool{Name: "pool2", Driver: &bakingMockDriver{}}}
+
+	_, err := m.BakeImage(context.Background(), "pool2", "inst-1", &BakeOptions{ImageName: "image"})
+	require.Error(t, err)
+	assert.Empty(t, *claimed)
+}
+
+func TestManager_BakeImage_Busy(t *testing.T) {
+	m, claimed, deleted := newBakeTestManager(t, &bakingMockDriver{}, &fakeBakeClient{})
+	instance, err := m.Find(context.Background(), "inst-1")
+	require.NoError(t, err)
+	instance.State = types.StateInUse
+
+	_, err = m.BakeImage(context.Background(), "pool1", "inst-1", &BakeOptions{ImageName: "image"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not free")
+	assert.Empty(t, *claimed)
+	assert.Empty(t, *deleted, "a busy instance must not be destroyed")
+}
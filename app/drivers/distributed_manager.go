@@ -29,6 +29,8 @@ const (
 	defaultTenantID         = "default"
 	stuckTerminatingMaxAge  = 2 * time.Minute
 	stuckProvisioningMaxAge = 30 * time.Minute
+	// busyTTLExtendedMaxAge is the max age of busy instances labelled with a ttl.
+	busyTTLExtendedMaxAge = 7 * 24 * time.Hour
 )
 
 // tenantIDForSetup returns the tenant id carried by setupParams, defaulting to the default tenant.
@@ -386,6 +388,7 @@ func (d *DistributedManager) provisionFromPool(
 		queryParams := &types.QueryParams{
 			PoolName:             poolName,
 			MachineType:          setupParams.MachineType,
+			MachineTypeFallbacks: setupParams.MachineTypeFallbacks,
 			NestedVirtualization: setupParams.NestedVirtualization,
 			VariantID:            candidateVariantID,
 			TenantID:             tenantID,
@@ -581,7 +584,10 @@ func (d *DistributedManager) filterVariants(
 func applyVariantToSetupParams(setupParams *types.SetupInstanceParams, variant *types.PoolVariant) {
 	// Override with variant-specific values if they are set
 	if variant.MachineType != "" {
+		// fallbacks belong to the variant's machine type, never mix them with
+		// the request's
 		setupParams.MachineType = variant.MachineType
+		setupParams.MachineTypeFallbacks = variant.MachineTypeFallbacks
 	}
 	if variant.DiskSize != 0 {
 		setupParams.DiskSize = variant.DiskSize
@@ -641,6 +647,62 @@ func (d *DistributedManager) setupInstanceAsync(
 		Infoln("setupInstanceAsync: created outbox job for instance setup")
 }
 
+// StartInterruptionMonitor periodically checks the instances of every pool
+// whose driver can detect interruptions. Interrupted free instances are
+// replaced through outbox jobs.
+func (d *DistributedManager) StartInterruptionMonitor(ctx context.Context) {
+	d.startInterruptionMonitor(ctx, d.claimTerminating, d.replenishInterrupted)
+}
+
+// claimTerminating moves a free instance to terminating, unless another runner
+// claimed it in the meantime.
+func (d *DistributedManager) claimTerminating(ctx context.Context, pool *poolEntry, inst *types.Instance) (*types.Instance, error) {
+	claimed, err := d.instanceStore.FindAndClaim(ctx, &types.QueryParams{
+		PoolName:   pool.Name,
+		InstanceID: inst.ID,
+		GPU:        inst.GPU,
+	}, types.StateTerminating, []types.InstanceState{types.StateCreated}, false)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return claimed, err
+}
+
+// replenishInterrupted queues a replacement for every interrupted pool-sourced
+// instance, keeping its variant and tenant.
+func (d *DistributedManager) replenishInterrupted(ctx context.Context, pool *poolEntry, removed []*types.Instance) {
+	for _, inst := range removed {
+		if !shouldReplenishInstance(inst) {
+			continue
+		}
+		d.setupInstanceAsync(ctx, pool.Name, d.runnerName, &types.SetupInstanceParams{
+			ImageName:            inst.Image,
+			NestedVirtualization: inst.EnableNestedVirtualization,
+			GPU:                  inst.GPU,
+			MachineType:          inst.Size,
+			Hibernate:            inst.IsHibernated,
+			VariantID:            inst.VariantID,
+			TenantID:             inst.TenantID,
+			Source:               types.InstanceSourcePool,
+		})
+	}
+}
+
+// StartImageRollout periodically replaces the stale free instances of every
+// pool, see Manager.StartImageRollout. Replacements are created through
+// outbox jobs.
+func (d *DistributedManager) StartImageRollout(ctx context.Context, interval time.Duration, maxUnavailable int) {
+	d.startImageRollout(ctx, interval, maxUnavailable, d.claimTerminating, d.replaceStale)
+}
+
+// replaceStale queues a replacement from the current pool configuration for
+// every stale instance that was removed.
+func (d *DistributedManager) replaceStale(ctx context.Context, pool *poolEntry, removed []*types.Instance) {
+	for _, inst := range removed {
+		d.setupInstanceAsync(ctx, pool.Name, d.runnerName, rolloutSetupParams(pool, inst))
+	}
+}
+
 // setupInstanceWithHibernate handles setting up the instance into hibernate mode
 func (d *DistributedManager) setupInstanceWithHibernate(
 	ctx context.Context,
@@ -713,9 +775,13 @@ func (d *DistributedManager) setupInstanceWithHibernate(
 		// Step 3: Attempt to hibernate the instance
 		shouldHibernate := false
 		tenantDriver := pool.DriverForTenant(inst.TenantID)
-		if setupParams != nil && setupParams.VariantID != "" && setupParams.VariantID != defaultVariantID {
+		switch {
+		case setupParams != nil && setupParams.VariantID != "" && setupParams.VariantID != defaultVariantID:
 			shouldHibernate = setupParams.Hibernate
-		} else {
+		case d.hibernationPolicy:
+			// the hibernation policy job decides whether the instance keeps running
+			shouldHibernate = false
+		default:
 			shouldHibernate = tenantDriver.CanHibernate()
 		}
 		err = d.hibernate(ctx, pool.Name, inst, shouldHibernate)
@@ -828,8 +894,15 @@ func (d *DistributedManager) StartInstancePurger(ctx context.Context, maxAgeBusy
 	}
 
 	d.cleanupTimer = time.NewTicker(purgerTime)
+	d.purgerSettings = PurgerSettings{MaxAgeBusy: maxAgeBusy, MaxAgeFree: maxAgeFree, FreeCapacityMaxAge: freeCapacityMaxAge, BusyLeaseTTL: d.busyLeaseTTL}
 
 	logrus.Infof("distributed dlite: Instance purger started. It will run every %.2f minutes", purgerTime.Minutes())
+	if d.busyLeaseTTL > 0 {
+		logrus.Infof("distributed dlite: busy instances holding a lease are reclaimed %.2f minutes after their last renewal", d.busyLeaseTTL.Minutes())
+	}
+	if d.purgerDryRun {
+		logrus.Warnln("distributed dlite: purger runs in dry run mode, nothing will be purged")
+	}
 
 	go func() {
 		defer d.cleanupTimer.Stop()
@@ -847,6 +920,11 @@ func (d *DistributedManager) StartInstancePurger(ctx context.Context, maxAgeBusy
 				case <-d.cleanupTimer.C:
 					logrus.Traceln("distributed dlite: Launching instance purger")
 
+					if d.purgerDryRun {
+						logPurgerReport(ctx, d, "distributed dlite: purger")
+						return
+					}
+
 					queryParams := types.QueryParams{MatchLabels: map[string]string{"retain": "false"}}
 					// All instances are labeled with retain: true/false
 					// If retain is true, instance is not cleaned up while we clean the pools or run the instance purger
@@ -888,6 +966,14 @@ func (d *DistributedManager) startInstancePurger(ctx context.Context, pool *pool
 		}
 	}
 
+	// Handle busy instances whose lease lapsed
+	if d.busyLeaseTTL > 0 {
+		if err := d.cleanupLapsedLeases(ctx, pool, queryParams); err != nil {
+			logr.WithError(err).Error("distributed dlite: purger: failed to cleanup busy instances with a lapsed lease")
+			sweepFailed = true
+		}
+	}
+
 	// Handle free instance cleanup
 	if maxAgeFree != 0 {
 		if err := d.cleanupFreeInstances(ctx, pool, maxAgeFree, queryParams); err != nil {
@@ -908,11 +994,11 @@ func (d *DistributedManager) startInstancePurger(ctx context.Context, pool *pool
 	}
 }
 
-// cleanupBusyInstances handles cleanup of busy instances (StateInUse, StateTerminating)
+// cleanupBusyInstances handles cleanup of busy instances (StateInUse, StateTerminating).
+// When leases are enabled, busy instances holding one are left to cleanupLapsedLeases.
 func (d *DistributedManager) cleanupBusyInstances(ctx context.Context, pool *poolEntry, maxAgeBusy time.Duration, queryParams *types.QueryParams) error {
 	conditions := squirrel.Or{}
 	currentTime := time.Now()
-	extendedMaxBusy := 7 * 24 * time.Hour
 
 	// First condition: instances without 'ttl' key using default max age
 	busyCondition := squirrel.And{
@@ -928,6 +1014,9 @@ func (d *DistributedManager) cleanupBusyInstances(ctx context.Context, pool *poo
 		condition := squirrel.Expr("(instance_labels->>?) = ?", key, value)
 		busyCondition = append(busyCondition, condition)
 	}
+	if d.busyLeaseTTL > 0 {
+		busyCondition = append(busyCondition, squirrel.Eq{"instance_lease_expiry": 0})
+	}
 
 	// Second condition: instances with 'ttl' key using extended max age
 	extendedBusyCondition := squirrel.And{
@@ -936,13 +1025,16 @@ func (d *DistributedManager) cleanupBusyInstances(ctx context.Context, pool *poo
 			squirrel.Eq{"instance_state": types.StateInUse},
 			squirrel.Eq{"instance_state": types.StateTerminating},
 		},
-		squirrel.Lt{"instance_started": currentTime.Add(-extendedMaxBusy).Unix()},
+		squirrel.Lt{"instance_started": currentTime.Add(-busyTTLExtendedMaxAge).Unix()},
 		squirrel.Expr("instance_labels ?? 'ttl'"),
 	}
 	for key, value := range queryParams.MatchLabels {
 		condition := squirrel.Expr("(instance_labels->>?) = ?", key, value)
 		extendedBusyCondition = append(extendedBusyCondition, condition)
 	}
+	if d.busyLeaseTTL > 0 {
+		extendedBusyCondition = append(extendedBusyCondition, squirrel.Eq{"instance_lease_expiry": 0})
+	}
 
 	// Third condition: instances stuck in terminating state for more than 5 minutes
 	stuckTerminatingCondition := squirrel.And{
@@ -1306,6 +1398,8 @@ func (d *DistributedManager) forceDeleteLeakedInstances(
 		Where(squirrel.And{
 			squirrel.Eq{"instance_pool": pool.Name},
 			squirrel.Lt{"instance_started": leakCutoff},
+			// a busy instance whose lease is still renewed is not leaked
+			squirrel.Lt{"instance_lease_expiry": time.Now().Unix()},
 		}).
 		Suffix("RETURNING instance_id, instance_name, instance_node_id, runner_name, tenant_id, instance_zone").
 		ToSql()
@@ -1342,7 +1436,7 @@ func (d *DistributedManager) forceDeleteLeakedInstances(
 	d.destroyCapacity(ctx, leaked)
 }
 
-// purgerReasonForCleanupType maps executeInstanceCleanup's cleanupType ("busy"/"free") to a
+// purgerReasonForCleanupType maps executeInstanceCleanup's cleanupType ("busy"/"free"/"lease") to a
 // metric reason label. cleanupBusyInstances/cleanupFreeInstances each OR several sub-conditions
 // (plain max-age, ttl-extended, stuck-terminating/stuck-provisioning) into a single claim query,
 // so the specific sub-condition that matched a given row isn't known here; every row in a
@@ -1350,8 +1444,11 @@ func (d *DistributedManager) forceDeleteLeakedInstances(
 // PurgerReasonBusyMaxAgeTTLExtended/PurgerReasonStuckTerminating doc comments in
 // purger_metrics.go for what a finer-grained breakdown would require.
 func purgerReasonForCleanupType(cleanupType string) string {
-	if cleanupType == "free" {
+	switch cleanupType {
+	case "free":
 		return PurgerReasonFreeMaxAge
+	case "lease":
+		return PurgerReasonLeaseExpired
 	}
 	return PurgerReasonBusyMaxAge
 }
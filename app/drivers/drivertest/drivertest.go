@@ -0,0 +1,266 @@
+// Package drivertest provides a conformance suite for drivers.Driver
+// implementations. A driver with a fake backend runs the suite from its own
+// tests against that backend:
+//
+//	func TestConformance(t *testing.T) {
+//		drivertest.Run(t, drivertest.Config{
+//			NewDriver: func(t *testing.T) (drivers.Driver, drivertest.Backend) {
+//				f := newFakeCloud()
+//				return newTestConfig(t, f, WithHibernate(true)), f
+//			},
+//		})
+//	}
+package drivertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+const (
+	// PoolName is the pool the suite creates instances in.
+	PoolName = "conformance-pool"
+	// RunnerName is the runner the suite creates instances for.
+	RunnerName = "conformance-runner"
+)
+
+// Backend is the fake backend of the driver under test, used to check that
+// calls took effect.
+type Backend interface {
+	// Exists reports whether the backend still has the instance.
+	Exists(instanceID string) bool
+}
+
+// BackendFunc adapts a func to a Backend, for drivers whose fake backend does
+// not keep track of instances itself.
+type BackendFunc func(instanceID string) bool
+
+// Exists calls f.
+func (f BackendFunc) Exists(instanceID string) bool {
+	return f(instanceID)
+}
+
+// LabelBackend is optionally implemented by a Backend that stores the tags or
+// labels of instances.
+type LabelBackend interface {
+	// Labels returns the tags or labels of the instance.
+	Labels(instanceID string) map[string]string
+}
+
+// Config describes the driver under test.
+type Config struct {
+	// NewDriver returns the driver under test, backed by a new fake backend.
+	// It is called once per check. The backend may be nil, in which case the
+	// suite only checks what the driver returns.
+	NewDriver func(t *testing.T) (drivers.Driver, Backend)
+
+	// CreateOpts optionally adjusts the options the suite creates instances
+	// with, for drivers that need an image or a machine type.
+	CreateOpts func(opts *types.InstanceCreateOpts)
+
+	// ReservesCapacity is set for drivers that support capacity reservations
+	// against their fake backend. Other drivers must report
+	// ErrCapacityReservationNotSupported.
+	ReservesCapacity bool
+}
+
+// Run runs the conformance suite as subtests of t.
+func Run(t *testing.T, c Config) {
+	t.Helper()
+	if c.NewDriver == nil {
+		t.Fatal("drivertest: Config.NewDriver is required")
+	}
+	t.Run("Create", c.testCreate)
+	t.Run("DestroyIsIdempotent", c.testDestroy)
+	t.Run("DestroyInstanceAndStorageWithoutCleanupType", c.testDestroyInstanceAndStorage)
+	t.Run("HibernateAndStart", c.testHibernateAndStart)
+	t.Run("SetTagsAndLabels", c.testSetTagsAndLabels)
+	t.Run("CapacityReservation", c.testCapacityReservation)
+}
+
+// createOpts returns the options instances are created with. The TLS material
+// is distinct per field so that mixed up fields are caught.
+func (c *Config) createOpts() *types.InstanceCreateOpts {
+	opts := &types.InstanceCreateOpts{
+		CACert:     []byte("ca-cert"),
+		CAKey:      []byte("ca-key"),
+		TLSCert:    []byte("tls-cert"),
+		TLSKey:     []byte("tls-key"),
+		Platform:   types.Platform{OS: "linux", Arch: "amd64"},
+		PoolName:   PoolName,
+		RunnerName: RunnerName,
+		AccountID:  "conformance-account",
+	}
+	if c.CreateOpts != nil {
+		c.CreateOpts(opts)
+	}
+	return opts
+}
+
+func (c *Config) create(t *testing.T, driver drivers.Driver) (*types.Instance, *types.InstanceCreateOpts) {
+	t.Helper()
+	opts := c.createOpts()
+	instance, err := driver.Create(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if instance == nil {
+		t.Fatal("Create returned a nil instance")
+	}
+	return instance, opts
+}
+
+func (c *Config) testCreate(t *testing.T) {
+	driver, backend := c.NewDriver(t)
+	instance, opts := c.create(t, driver)
+
+	if instance.ID == "" {
+		t.Error("instance has no ID")
+	}
+	if instance.Address == "" {
+		t.Error("instance has no address")
+	}
+	if instance.Pool != opts.PoolName {
+		t.Errorf("instance pool = %q, want %q", instance.Pool, opts.PoolName)
+	}
+	if string(instance.Provider) != driver.DriverName() {
+		t.Errorf("instance provider = %q, want %q", instance.Provider, driver.DriverName())
+	}
+	for _, f := range []struct {
+		name      string
+		got, want []byte
+	}{
+		{"CACert", instance.CACert, opts.CACert},
+		{"CAKey", instance.CAKey, opts.CAKey},
+		{"TLSCert", instance.TLSCert, opts.TLSCert},
+		{"TLSKey", instance.TLSKey, opts.TLSKey},
+	} {
+		if string(f.got) != string(f.want) {
+			t.Errorf("instance %s = %q, want %q", f.name, f.got, f.want)
+		}
+	}
+	if backend != nil && !backend.Exists(instance.ID) {
+		t.Errorf("instance %s was not created in the backend", instance.ID)
+	}
+}
+
+func (c *Config) testDestroy(t *testing.T) {
+	driver, backend := c.NewDriver(t)
+	instance, _ := c.create(t, driver)
+	instances := []*types.Instance{instance}
+
+	failed, err := driver.Destroy(context.Background(), instances)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Destroy: failed %d instances: %v", len(failed), err)
+	}
+	if backend != nil && backend.Exists(instance.ID) {
+		t.Errorf("instance %s still exists after Destroy", instance.ID)
+	}
+
+	// the purger and the destroy API may both destroy the same instance
+	failed, err = driver.Destroy(context.Background(), instances)
+	if err != nil || len(failed) != 0 {
+		t.Errorf("destroying a destroyed instance: failed %d instances: %v", len(failed), err)
+	}
+}
+
+func (c *Config) testDestroyInstanceAndStorage(t *testing.T) {
+	driver, backend := c.NewDriver(t)
+	instance, _ := c.create(t, driver)
+
+	failed, err := driver.DestroyInstanceAndStorage(context.Background(), []*types.Instance{instance}, nil)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("DestroyInstanceAndStorage: failed %d instances: %v", len(failed), err)
+	}
+	if backend != nil && backend.Exists(instance.ID) {
+		t.Errorf("instance %s still exists after DestroyInstanceAndStorage", instance.ID)
+	}
+}
+
+func (c *Config) testHibernateAndStart(t *testing.T) {
+	driver, backend := c.NewDriver(t)
+	if !driver.CanHibernate() {
+		t.Skip("driver cannot hibernate")
+	}
+	instance, opts := c.create(t, driver)
+
+	if err := driver.Hibernate(context.Background(), instance.ID, opts.PoolName, instance.Zone); err != nil {
+		t.Fatalf("Hibernate: %v", err)
+	}
+	if backend != nil && !backend.Exists(instance.ID) {
+		t.Fatalf("instance %s was removed by Hibernate", instance.ID)
+	}
+	ip, err := driver.Start(context.Background(), instance, opts.PoolName)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if ip == "" {
+		t.Error("Start returned no address")
+	}
+}
+
+func (c *Config) testSetTagsAndLabels(t *testing.T) {
+	driver, backend := c.NewDriver(t)
+	instance, _ := c.create(t, driver)
+
+	if err := driver.SetTags(context.Background(), instance, map[string]string{"stage": "build"}); err != nil {
+		t.Fatalf("SetTags: %v", err)
+	}
+	if err := driver.SetLabels(context.Background(), instance, map[string]string{"retain": "true"}); err != nil {
+		t.Fatalf("SetLabels: %v", err)
+	}
+	lb, ok := backend.(LabelBackend)
+	if !ok {
+		return
+	}
+	labels := lb.Labels(instance.ID)
+	for key, value := range map[string]string{"stage": "build", "retain": "true"} {
+		if labels[key] != value {
+			t.Errorf("label %s = %q, want %q (labels %v)", key, labels[key], value, labels)
+		}
+	}
+}
+
+func (c *Config) testCapacityReservation(t *testing.T) {
+	driver, _ := c.NewDriver(t)
+	ctx := context.Background()
+
+	reservation, err := driver.ReserveCapacity(ctx, c.createOpts())
+	if c.ReservesCapacity {
+		if err != nil {
+			t.Fatalf("ReserveCapacity: %v", err)
+		}
+		if reservation == nil {
+			t.Fatal("ReserveCapacity returned a nil reservation")
+		}
+		if err = driver.DestroyCapacity(ctx, reservation); err != nil {
+			t.Errorf("DestroyCapacity: %v", err)
+		}
+		return
+	}
+
+	if reservation != nil {
+		t.Errorf("ReserveCapacity returned a reservation together with error %v", err)
+	}
+	assertNotSupported(t, driver, "ReserveCapacity", err)
+	// callers release reservations without knowing whether the driver made them
+	err = driver.DestroyCapacity(ctx, &types.CapacityReservation{PoolName: PoolName, ReservationID: "reservation"})
+	assertNotSupported(t, driver, "DestroyCapacity", err)
+}
+
+func assertNotSupported(t *testing.T, driver drivers.Driver, op string, err error) {
+	t.Helper()
+	var notSupported *ierrors.ErrCapacityReservationNotSupported
+	if !errors.As(err, &notSupported) {
+		t.Errorf("%s: want ErrCapacityReservationNotSupported, got %v", op, err)
+		return
+	}
+	if notSupported.Driver != driver.DriverName() {
+		t.Errorf("%s: error names driver %q, want %q", op, notSupported.Driver, driver.DriverName())
+	}
+}
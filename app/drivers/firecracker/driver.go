@@ -0,0 +1,365 @@
+package firecracker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/lehelper"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/dchest/uniuri"
+)
+
+const slotFile = "slot"
+
+var _ drivers.Driver = (*config)(nil)
+
+// config is a struct that implements drivers.Pool interface
+type config struct {
+	binary     string
+	kernel     string
+	rootfs     string
+	kernelArgs string
+	stateDir   string
+	subnet     *net.IPNet
+	vcpus      int64
+	memoryMB   int64
+	userData   string
+	rootDir    string
+	hibernate  bool
+
+	vmm  VMM
+	host Host
+	// mu serialises slot allocation within this process.
+	mu sync.Mutex
+}
+
+func New(opts ...Option) (drivers.Driver, error) {
+	p := new(config)
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.kernel == "" || p.rootfs == "" {
+		return nil, fmt.Errorf("firecracker: kernel and rootfs images are required")
+	}
+	if p.subnet == nil || p.subnet.IP.To4() == nil || slotCount(p.subnet) == 0 {
+		return nil, fmt.Errorf("firecracker: a valid IPv4 subnet is required")
+	}
+	if p.vmm == nil {
+		p.vmm = NewVMM(p.binary)
+	}
+	if p.host == nil {
+		p.host = NewHost()
+	}
+	return p, nil
+}
+
+func (p *config) DriverName() string {
+	return string(types.Firecracker)
+}
+
+func (p *config) InstanceType() string {
+	return p.rootfs
+}
+
+func (p *config) RootDir() string {
+	return p.rootDir
+}
+
+func (p *config) CanHibernate() bool {
+	return p.hibernate
+}
+
+// GetFullyQualifiedImage resolves the root filesystem image. Relative image
+// names are looked up next to the pool's default root filesystem.
+func (p *config) GetFullyQualifiedImage(_ context.Context, config *types.VMImageConfig) (string, error) {
+	if config.ImageName == "" {
+		return p.rootfs, nil
+	}
+	if filepath.IsAbs(config.ImageName) {
+		return config.ImageName, nil
+	}
+	return filepath.Join(filepath.Dir(p.rootfs), config.ImageName), nil
+}
+
+func (p *config) Ping(_ context.Context) error {
+	if _, err := os.Stat(p.kernel); err != nil {
+		return err
+	}
+	return os.MkdirAll(p.stateDir, 0o700) //nolint:mnd
+}
+
+// ReserveCapacity reserves capacity for a VM
+func (p *config) ReserveCapacity(ctx context.Context, opts *types.InstanceCreateOpts) (*types.CapacityReservation, error) {
+	return nil, &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// DestroyCapacity destroys capacity for a VM
+func (p *config) DestroyCapacity(ctx context.Context, capacity *types.CapacityReservation) (err error) {
+	return &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// Create clones the root filesystem, wires up a tap device and boots a microVM
+// whose cloud-init data and lite-engine certificates are served over MMDS.
+func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
+	startTime := time.Now()
+
+	image, err := p.GetFullyQualifiedImage(ctx, &opts.VMImageConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image: %w", err)
+	}
+
+	name := strings.ToLower(fmt.Sprintf("%s-%s-%s", opts.RunnerName, opts.PoolName, uniuri.NewLen(8))) //nolint:mnd
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.Firecracker).
+		WithField("pool", opts.PoolName).
+		WithField("image", image).
+		WithField("name", name).
+		WithField("hibernate", p.CanHibernate())
+	logr.Infof("firecracker: creating instance %s", name)
+
+	userData, err := lehelper.GenerateUserdata(p.userData, opts)
+	if err != nil {
+		logr.WithError(err).
+			Errorln("firecracker: failed to generate user data")
+		return nil, err
+	}
+
+	m := p.machine(name)
+	if err = os.MkdirAll(m.dir, 0o700); err != nil { //nolint:mnd
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if cleanupErr := p.destroyMachine(context.Background(), name); cleanupErr != nil {
+				logr.WithError(cleanupErr).Warnln("firecracker: failed to clean up after failed create")
+			}
+		}
+	}()
+
+	p.mu.Lock()
+	slot, err := allocateSlot(p.stateDir, slotCount(p.subnet))
+	p.mu.Unlock()
+	if err != nil {
+		logr.WithError(err).Errorln("firecracker: cannot allocate network slot")
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(m.dir, slotFile), []byte(strconv.Itoa(slot)), 0o600); err != nil { //nolint:mnd
+		_ = releaseSlot(p.stateDir, slot)
+		return nil, err
+	}
+	hostIP, guestIP, err := slotAddresses(p.subnet, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.host.CloneRootFS(ctx, image, m.rootfs); err != nil {
+		logr.WithError(err).Errorln("firecracker: failed to clone root filesystem")
+		return nil, err
+	}
+	m.tap = tapName(slot)
+	hostCIDR := fmt.Sprintf("%s/%d", hostIP, slotPrefixLength)
+	if err = p.host.CreateTap(ctx, m.tap, hostCIDR); err != nil {
+		logr.WithError(err).Errorln("firecracker: failed to create tap device")
+		return nil, err
+	}
+	m.guestMAC = guestMAC(guestIP)
+	m.bootArgs = bootArgs(hostIP, guestIP, p.kernelArgs)
+	m.mmds = mmdsData(name, userData, opts)
+
+	if err = p.vmm.Boot(ctx, m); err != nil {
+		logr.WithError(err).Errorln("firecracker: failed to boot microVM")
+		return nil, err
+	}
+	logr.WithField("ip", guestIP.String()).Infof("firecracker: instance created %s", name)
+
+	labelsBytes, err := json.Marshal(opts.InternalLabels)
+	if err != nil {
+		return nil, fmt.Errorf("scheduler: could not marshal labels: %v, err: %w", opts.InternalLabels, err)
+	}
+
+	return &types.Instance{
+		ID:                name,
+		Name:              name,
+		Provider:          types.Firecracker,
+		State:             types.StateProvisioning,
+		Pool:              opts.PoolName,
+		Image:             image,
+		Size:              fmt.Sprintf("%dcpu-%dmb", p.vcpus, p.memoryMB),
+		Platform:          opts.Platform,
+		Address:           guestIP.String(),
+		CAKey:             opts.CAKey,
+		CACert:            opts.CACert,
+		TLSKey:            opts.TLSKey,
+		TLSCert:           opts.TLSCert,
+		Started:           startTime.Unix(),
+		Updated:           time.Now().Unix(),
+		IsHibernated:      false,
+		Port:              lehelper.LiteEnginePort,
+		StorageIdentifier: opts.StorageOpts.Identifier,
+		Labels:            labelsBytes,
+		ProxyURL:          opts.EgressProxyURL,
+	}, nil
+}
+
+func (p *config) Destroy(ctx context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+	return p.DestroyInstanceAndStorage(ctx, instances, nil)
+}
+
+// DestroyInstanceAndStorage stops the microVMs and removes their tap devices,
+// network slots and state directories.
+func (p *config) DestroyInstanceAndStorage(ctx context.Context, instances []*types.Instance, _ *storage.CleanupType) ([]*types.Instance, error) {
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instance ids provided")
+	}
+
+	var failed []*types.Instance
+	var firstErr error
+	for _, instance := range instances {
+		logr := logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.Firecracker)
+		if err := p.destroyMachine(ctx, instance.ID); err != nil {
+			logr.WithError(err).Errorln("firecracker: failed to destroy microVM")
+			failed = append(failed, instance)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		logr.Debugln("firecracker: microVM destroyed")
+	}
+	return failed, firstErr
+}
+
+// Logs returns the serial console output of the microVM.
+func (p *config) Logs(_ context.Context, instanceID string) (string, error) {
+	out, err := os.ReadFile(p.machine(instanceID).consolePath)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Hibernate snapshots the guest memory and device state to disk and stops the process.
+func (p *config) Hibernate(ctx context.Context, instanceID, _, _ string) error {
+	m, _, err := p.loadMachine(instanceID)
+	if err != nil {
+		return err
+	}
+	if err = p.vmm.Snapshot(ctx, m); err != nil {
+		logger.FromContext(ctx).
+			WithField("id", instanceID).
+			WithField("driver", types.Firecracker).
+			WithError(err).
+			Errorln("firecracker: snapshot failed")
+		return err
+	}
+	return nil
+}
+
+// Start resumes a hibernated microVM from its snapshot and returns its address.
+func (p *config) Start(ctx context.Context, instance *types.Instance, _ string) (string, error) {
+	m, guestIP, err := p.loadMachine(instance.ID)
+	if err != nil {
+		return "", err
+	}
+	if p.vmm.Running(ctx, m) {
+		return guestIP.String(), nil
+	}
+	if err = p.vmm.Restore(ctx, m); err != nil {
+		logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.Firecracker).
+			WithError(err).
+			Errorln("firecracker: failed to restore snapshot")
+		return "", err
+	}
+	return guestIP.String(), nil
+}
+
+func (p *config) SetTags(ctx context.Context, instance *types.Instance,
+	tags map[string]string) error {
+	return nil
+}
+
+func (p *config) SetLabels(context.Context, *types.Instance, map[string]string) error {
+	return nil
+}
+
+// machine returns the host paths of a microVM.
+func (p *config) machine(name string) *machine {
+	dir := filepath.Join(p.stateDir, "vms", name)
+	return &machine{
+		name:         name,
+		dir:          dir,
+		socketPath:   filepath.Join(dir, "firecracker.sock"),
+		pidPath:      filepath.Join(dir, "firecracker.pid"),
+		consolePath:  filepath.Join(dir, "console.log"),
+		snapshotPath: filepath.Join(dir, "snapshot"),
+		memPath:      filepath.Join(dir, "memory"),
+		kernel:       p.kernel,
+		rootfs:       filepath.Join(dir, "rootfs.ext4"),
+		vcpus:        p.vcpus,
+		memoryMB:     p.memoryMB,
+	}
+}
+
+// loadMachine rebuilds a machine from its state directory.
+func (p *config) loadMachine(name string) (*machine, net.IP, error) {
+	m := p.machine(name)
+	slot, err := readSlot(m.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	hostIP, guestIP, err := slotAddresses(p.subnet, slot)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.tap = tapName(slot)
+	m.guestMAC = guestMAC(guestIP)
+	m.bootArgs = bootArgs(hostIP, guestIP, p.kernelArgs)
+	return m, guestIP, nil
+}
+
+// destroyMachine tears down everything a microVM owns. Missing resources are
+// ignored so it can be used to clean up partially created instances.
+func (p *config) destroyMachine(ctx context.Context, name string) error {
+	m := p.machine(name)
+	if err := p.vmm.Stop(ctx, m); err != nil {
+		return err
+	}
+	slot, err := readSlot(m.dir)
+	if err == nil {
+		if err = p.host.DeleteTap(ctx, tapName(slot)); err != nil {
+			return err
+		}
+		if err = releaseSlot(p.stateDir, slot); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(m.dir)
+}
+
+func readSlot(dir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, slotFile))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,340 @@
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/drivertest"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// fakeVMM records machine lifecycle transitions without running firecracker.
+type fakeVMM struct {
+	mu       sync.Mutex
+	running  map[string]bool
+	booted   map[string]*machine
+	snapshot map[string]bool
+	calls    []string
+	bootErr  error
+}
+
+func newFakeVMM() *fakeVMM {
+	return &fakeVMM{running: map[string]bool{}, booted: map[string]*machine{}, snapshot: map[string]bool{}}
+}
+
+func (f *fakeVMM) Boot(_ context.Context, m *machine) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "boot:"+m.name)
+	if f.bootErr != nil {
+		return f.bootErr
+	}
+	f.booted[m.name] = m
+	f.running[m.name] = true
+	return os.WriteFile(m.consolePath, []byte("Linux version 6.1\n"), 0o600)
+}
+
+func (f *fakeVMM) Snapshot(_ context.Context, m *machine) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "snapshot:"+m.name)
+	if !f.running[m.name] {
+		return errors.New("not running")
+	}
+	f.snapshot[m.name] = true
+	f.running[m.name] = false
+	return nil
+}
+
+func (f *fakeVMM) Restore(_ context.Context, m *machine) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "restore:"+m.name)
+	if !f.snapshot[m.name] {
+		return errors.New("no snapshot")
+	}
+	f.running[m.name] = true
+	return nil
+}
+
+func (f *fakeVMM) Stop(_ context.Context, m *machine) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "stop:"+m.name)
+	f.running[m.name] = false
+	return nil
+}
+
+func (f *fakeVMM) Running(_ context.Context, m *machine) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.running[m.name]
+}
+
+// fakeHost tracks tap devices and copies files with the standard library.
+type fakeHost struct {
+	mu   sync.Mutex
+	taps map[string]string
+}
+
+func (h *fakeHost) CreateTap(_ context.Context, name, hostCIDR string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.taps[name]; ok {
+		return errors.New("tap exists")
+	}
+	h.taps[name] = hostCIDR
+	return nil
+}
+
+func (h *fakeHost) DeleteTap(_ context.Context, name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.taps, name)
+	return nil
+}
+
+func (h *fakeHost) CloneRootFS(_ context.Context, src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0o600)
+}
+
+func newTestConfig(t *testing.T, vmm *fakeVMM, host *fakeHost, subnet string) *config {
+	t.Helper()
+	dir := t.TempDir()
+	rootfs := filepath.Join(dir, "images", "ubuntu.ext4")
+	if err := os.MkdirAll(filepath.Dir(rootfs), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(rootfs, []byte("rootfs"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(
+		WithVMM(vmm),
+		WithHost(host),
+		WithKernel("/images/vmlinux", "quiet"),
+		WithRootFS(rootfs),
+		WithStateDir(filepath.Join(dir, "state")),
+		WithSubnet(subnet),
+		WithCPU(0),
+		WithMemory(0),
+		WithHibernate(true),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return d.(*config)
+}
+
+func createOpts() *types.InstanceCreateOpts {
+	return &types.InstanceCreateOpts{
+		PoolName:   "micro",
+		RunnerName: "runner",
+		Platform:   types.Platform{OS: "linux", Arch: "amd64"},
+		CACert:     []byte("ca-cert"),
+		TLSCert:    []byte("tls-cert"),
+		TLSKey:     []byte("tls-key"),
+	}
+}
+
+func TestNew_Validation(t *testing.T) {
+	if _, err := New(WithSubnet("")); err == nil {
+		t.Error("expected error without kernel and rootfs")
+	}
+	if _, err := New(WithKernel("k", ""), WithRootFS("r"), WithSubnet("not-a-cidr")); err == nil {
+		t.Error("expected error for an invalid subnet")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	vmm, host := newFakeVMM(), &fakeHost{taps: map[string]string{}}
+	p := newTestConfig(t, vmm, host, "")
+
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if instance.Provider != types.Firecracker || instance.Address != "172.30.0.2" || instance.Size != "2cpu-2048mb" {
+		t.Errorf("unexpected instance %+v", instance)
+	}
+	if host.taps["fc-tap0"] != "172.30.0.1/30" {
+		t.Errorf("taps = %v", host.taps)
+	}
+
+	m := vmm.booted[instance.ID]
+	if m == nil {
+		t.Fatal("machine was not booted")
+	}
+	if m.guestMAC != "06:00:ac:1e:00:02" || m.tap != "fc-tap0" || m.kernel != "/images/vmlinux" {
+		t.Errorf("unexpected machine %+v", m)
+	}
+	for _, want := range []string{"ip=172.30.0.2::172.30.0.1:255.255.255.252::eth0:off", "ds=nocloud-net;s=http://169.254.169.254/latest/", "quiet"} {
+		if !strings.Contains(m.bootArgs, want) {
+			t.Errorf("boot args %q missing %q", m.bootArgs, want)
+		}
+	}
+	if data, _ := os.ReadFile(m.rootfs); string(data) != "rootfs" {
+		t.Errorf("rootfs was not cloned, got %q", data)
+	}
+
+	latest := m.mmds["latest"].(map[string]interface{})
+	le := latest["lite-engine"].(map[string]interface{})
+	if le["ca-cert"] != "ca-cert" || le["tls-cert"] != "tls-cert" || le["tls-key"] != "tls-key" {
+		t.Errorf("mmds tls material = %v", le)
+	}
+	if !strings.Contains(latest["user-data"].(string), "ca-cert") {
+		t.Error("expected cloud-init user data in mmds")
+	}
+	if !strings.Contains(latest["meta-data"].(string), instance.ID) {
+		t.Errorf("meta-data = %q", latest["meta-data"])
+	}
+
+	second, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if second.Address != "172.30.0.6" {
+		t.Errorf("second instance address = %q", second.Address)
+	}
+}
+
+func TestCreate_SlotExhaustion(t *testing.T) {
+	vmm, host := newFakeVMM(), &fakeHost{taps: map[string]string{}}
+	p := newTestConfig(t, vmm, host, "10.0.0.0/29")
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Create(context.Background(), createOpts()); err != nil {
+			t.Fatalf("Create %d: %v", i, err)
+		}
+	}
+	if _, err := p.Create(context.Background(), createOpts()); !errors.Is(err, errNoFreeSlot) {
+		t.Fatalf("expected slot exhaustion, got %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(p.stateDir, "vms"))
+	if len(entries) != 2 {
+		t.Errorf("expected failed create to remove its state, got %d machines", len(entries))
+	}
+}
+
+func TestCreate_BootFailureReleasesResources(t *testing.T) {
+	vmm, host := newFakeVMM(), &fakeHost{taps: map[string]string{}}
+	vmm.bootErr = errors.New("kvm unavailable")
+	p := newTestConfig(t, vmm, host, "")
+
+	if _, err := p.Create(context.Background(), createOpts()); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(host.taps) != 0 {
+		t.Errorf("expected tap to be removed, got %v", host.taps)
+	}
+	slots, _ := os.ReadDir(filepath.Join(p.stateDir, "slots"))
+	if len(slots) != 0 {
+		t.Errorf("expected slot to be released, got %d", len(slots))
+	}
+}
+
+func TestHibernateAndStart(t *testing.T) {
+	vmm, host := newFakeVMM(), &fakeHost{taps: map[string]string{}}
+	p := newTestConfig(t, vmm, host, "")
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err = p.Hibernate(context.Background(), instance.ID, "micro", ""); err != nil {
+		t.Fatalf("Hibernate: %v", err)
+	}
+	if vmm.running[instance.ID] || !vmm.snapshot[instance.ID] {
+		t.Fatal("expected a stopped machine with a snapshot")
+	}
+
+	ip, err := p.Start(context.Background(), instance, "micro")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if ip != instance.Address {
+		t.Errorf("ip = %q, want %q", ip, instance.Address)
+	}
+
+	calls := len(vmm.calls)
+	if _, err = p.Start(context.Background(), instance, "micro"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if len(vmm.calls) != calls {
+		t.Errorf("expected no restore for a running machine, got %v", vmm.calls[calls:])
+	}
+}
+
+func TestDestroyAndLogs(t *testing.T) {
+	vmm, host := newFakeVMM(), &fakeHost{taps: map[string]string{}}
+	p := newTestConfig(t, vmm, host, "")
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	logs, err := p.Logs(context.Background(), instance.ID)
+	if err != nil || !strings.Contains(logs, "Linux version") {
+		t.Errorf("Logs = %q, %v", logs, err)
+	}
+
+	failed, err := p.Destroy(context.Background(), []*types.Instance{instance, {ID: "unknown"}})
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Destroy: failed=%v err=%v", failed, err)
+	}
+	if vmm.running[instance.ID] || len(host.taps) != 0 {
+		t.Error("expected machine stopped and tap removed")
+	}
+	if _, err = os.Stat(filepath.Join(p.stateDir, "vms", instance.ID)); !os.IsNotExist(err) {
+		t.Errorf("expected state directory removed, got %v", err)
+	}
+
+	// the released slot is handed out again
+	again, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if again.Address != instance.Address {
+		t.Errorf("address = %q, want reused %q", again.Address, instance.Address)
+	}
+}
+
+func TestSlotAddresses(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("192.168.0.0/24")
+	if got := slotCount(subnet); got != 64 {
+		t.Fatalf("slotCount = %d", got)
+	}
+	hostIP, guestIP, err := slotAddresses(subnet, 63)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostIP.String() != "192.168.0.253" || guestIP.String() != "192.168.0.254" {
+		t.Errorf("got %s %s", hostIP, guestIP)
+	}
+	if _, _, err = slotAddresses(subnet, 64); err == nil {
+		t.Error("expected error for slot outside the subnet")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, drivertest.Config{
+		NewDriver: func(t *testing.T) (drivers.Driver, drivertest.Backend) {
+			p := newTestConfig(t, newFakeVMM(), &fakeHost{taps: map[string]string{}}, "")
+			// a machine exists for as long as its state directory does
+			return p, drivertest.BackendFunc(func(instanceID string) bool {
+				_, err := os.Stat(filepath.Join(p.stateDir, "vms", instanceID))
+				return err == nil
+			})
+		},
+	})
+}
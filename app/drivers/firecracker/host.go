@@ -0,0 +1,73 @@
+package firecracker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Host prepares the host side resources of a microVM: its tap device and
+// its private copy of the root filesystem.
+type Host interface {
+	// CreateTap creates a tap device and assigns it the host address in CIDR notation.
+	CreateTap(ctx context.Context, name, hostCIDR string) error
+	// DeleteTap removes a tap device, it is not an error if it does not exist.
+	DeleteTap(ctx context.Context, name string) error
+	// CloneRootFS copies the root filesystem image for a new instance.
+	CloneRootFS(ctx context.Context, src, dst string) error
+}
+
+// linuxHost implements Host with iproute2 and coreutils.
+type linuxHost struct{}
+
+// NewHost returns a Host that manages tap devices and files on the local host.
+func NewHost() Host {
+	return &linuxHost{}
+}
+
+func (h *linuxHost) CreateTap(ctx context.Context, name, hostCIDR string) error {
+	commands := [][]string{
+		{"tuntap", "add", "dev", name, "mode", "tap"},
+		{"addr", "add", hostCIDR, "dev", name},
+		{"link", "set", name, "up"},
+	}
+	for _, args := range commands {
+		if err := run(ctx, "ip", args...); err != nil {
+			_ = h.DeleteTap(ctx, name)
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *linuxHost) DeleteTap(ctx context.Context, name string) error {
+	err := run(ctx, "ip", "link", "del", name)
+	if err != nil && strings.Contains(err.Error(), "Cannot find device") {
+		return nil
+	}
+	return err
+}
+
+func (h *linuxHost) CloneRootFS(ctx context.Context, src, dst string) error {
+	return run(ctx, "cp", "--sparse=always", "--reflink=auto", src, dst)
+}
+
+func run(ctx context.Context, bin string, args ...string) error {
+	cmd := exec.CommandContext(ctx, bin, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	logrus.Debugf("executing: %v %v", bin, strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		var ee *exec.Error
+		if errors.As(err, &ee) {
+			return err
+		}
+		return fmt.Errorf("%s: %w: %s", bin, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
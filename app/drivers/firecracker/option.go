@@ -0,0 +1,159 @@
+package firecracker
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/app/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+type Option func(*config)
+
+func SetPlatformDefaults(platform *types.Platform) (*types.Platform, error) {
+	if platform.Arch == "" {
+		platform.Arch = oshelp.ArchAMD64
+	}
+	if platform.Arch != oshelp.ArchAMD64 && platform.Arch != oshelp.ArchARM64 {
+		return platform, fmt.Errorf("invalid arch %s, has to be '%s/%s'", platform.Arch, oshelp.ArchAMD64, oshelp.ArchARM64)
+	}
+	// verify that we are using sane values for OS
+	if platform.OS == "" {
+		platform.OS = oshelp.OSLinux
+	}
+	if platform.OS != oshelp.OSLinux {
+		return platform, fmt.Errorf("firecracker - invalid OS %s, has to be '%s'", platform.OS, oshelp.OSLinux)
+	}
+	// set osname
+	if platform.OSName == "" {
+		platform.OSName = oshelp.Ubuntu
+	}
+	return platform, nil
+}
+
+// WithBinary sets the path of the firecracker binary.
+func WithBinary(binary string) Option {
+	return func(p *config) {
+		if binary == "" {
+			p.binary = "firecracker"
+		} else {
+			p.binary = binary
+		}
+	}
+}
+
+// WithKernel sets the path of the uncompressed guest kernel.
+func WithKernel(kernel, args string) Option {
+	return func(p *config) {
+		p.kernel = kernel
+		p.kernelArgs = args
+	}
+}
+
+// WithRootFS sets the path of the root filesystem image that is cloned for every instance.
+func WithRootFS(rootfs string) Option {
+	return func(p *config) {
+		p.rootfs = rootfs
+	}
+}
+
+// WithStateDir sets the directory holding per instance state, sockets and snapshots.
+func WithStateDir(dir string) Option {
+	return func(p *config) {
+		if dir == "" {
+			p.stateDir = "/var/lib/drone-runner/firecracker"
+		} else {
+			p.stateDir = dir
+		}
+	}
+}
+
+// WithSubnet sets the IPv4 range carved into a /30 per microVM. The host is
+// expected to route and masquerade this range for guest egress.
+func WithSubnet(cidr string) Option {
+	return func(p *config) {
+		if cidr == "" {
+			cidr = "172.30.0.0/16"
+		}
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			logrus.WithError(err).
+				Errorln("firecracker: invalid subnet")
+			return
+		}
+		p.subnet = subnet
+	}
+}
+
+func WithCPU(vcpus int64) Option {
+	return func(p *config) {
+		if vcpus <= 0 {
+			p.vcpus = 2
+		} else {
+			p.vcpus = vcpus
+		}
+	}
+}
+
+func WithMemory(memoryMB int64) Option {
+	return func(p *config) {
+		if memoryMB <= 0 {
+			p.memoryMB = 2048
+		} else {
+			p.memoryMB = memoryMB
+		}
+	}
+}
+
+func WithHibernate(hibernate bool) Option {
+	return func(p *config) {
+		p.hibernate = hibernate
+	}
+}
+
+// WithVMM overrides the process manager.
+func WithVMM(vmm VMM) Option {
+	return func(p *config) {
+		p.vmm = vmm
+	}
+}
+
+// WithHost overrides the host resource manager.
+func WithHost(host Host) Option {
+	return func(p *config) {
+		p.host = host
+	}
+}
+
+func WithUserData(text, path string) Option {
+	if text != "" {
+		return func(p *config) {
+			p.userData = text
+		}
+	}
+	return func(p *config) {
+		if path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logrus.WithError(err).
+					Fatalln("failed to read user_data file")
+				return
+			}
+			p.userData = string(data)
+		}
+	}
+}
+
+// WithRootDirectory sets the root directory for the virtual machine.
+func WithRootDirectory(dir string) Option {
+	return func(p *config) {
+		if dir == "" {
+			p.rootDir = oshelp.JoinPaths(oshelp.OSLinux, "/tmp", "firecracker")
+		} else {
+			p.rootDir = dir
+		}
+	}
+}
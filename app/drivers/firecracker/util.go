@@ -0,0 +1,118 @@
+package firecracker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+const (
+	// each microVM gets a /30: network, host (tap) address, guest address, broadcast.
+	addressesPerSlot = 4
+	slotNetmask      = "255.255.255.252"
+	slotPrefixLength = 30
+
+	mmdsAddress = "169.254.169.254"
+)
+
+var errNoFreeSlot = errors.New("firecracker: no free network slot")
+
+// slotCount returns how many /30 slots fit in the subnet.
+func slotCount(subnet *net.IPNet) int {
+	ones, bits := subnet.Mask.Size()
+	return (1 << (bits - ones)) / addressesPerSlot
+}
+
+// slotAddresses returns the host (tap) and guest addresses of a slot.
+func slotAddresses(subnet *net.IPNet, slot int) (hostIP, guestIP net.IP, err error) {
+	if slot < 0 || slot >= slotCount(subnet) {
+		return nil, nil, fmt.Errorf("firecracker: slot %d outside subnet %s", slot, subnet)
+	}
+	base := binary.BigEndian.Uint32(subnet.IP.To4()) + uint32(slot*addressesPerSlot) //nolint:gosec
+	hostIP = make(net.IP, net.IPv4len)
+	guestIP = make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(hostIP, base+1)
+	binary.BigEndian.PutUint32(guestIP, base+2) //nolint:mnd
+	return hostIP, guestIP, nil
+}
+
+// allocateSlot reserves the lowest free slot. Reservations are directories
+// under the state directory so they survive runner restarts.
+func allocateSlot(stateDir string, count int) (int, error) {
+	dir := filepath.Join(stateDir, "slots")
+	if err := os.MkdirAll(dir, 0o700); err != nil { //nolint:mnd
+		return 0, err
+	}
+	for i := 0; i < count; i++ {
+		err := os.Mkdir(filepath.Join(dir, strconv.Itoa(i)), 0o700) //nolint:mnd
+		if err == nil {
+			return i, nil
+		}
+		if !os.IsExist(err) {
+			return 0, err
+		}
+	}
+	return 0, errNoFreeSlot
+}
+
+func releaseSlot(stateDir string, slot int) error {
+	err := os.Remove(filepath.Join(stateDir, "slots", strconv.Itoa(slot)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// tapName returns the tap device name of a slot, kept well under IFNAMSIZ.
+func tapName(slot int) string {
+	return "fc-tap" + strconv.Itoa(slot)
+}
+
+// guestMAC derives the guest MAC from its address, the convention used by the
+// Firecracker examples so the guest can also derive its address from the MAC.
+func guestMAC(ip net.IP) string {
+	ip4 := ip.To4()
+	return fmt.Sprintf("06:00:%02x:%02x:%02x:%02x", ip4[0], ip4[1], ip4[2], ip4[3])
+}
+
+// bootArgs returns the kernel command line: a static address on eth0 and the
+// cloud-init NoCloud datasource pointed at the MMDS endpoint.
+func bootArgs(hostIP, guestIP net.IP, extra string) string {
+	args := []string{
+		"console=ttyS0",
+		"reboot=k",
+		"panic=1",
+		"pci=off",
+		fmt.Sprintf("ip=%s::%s:%s::eth0:off", guestIP, hostIP, slotNetmask),
+		fmt.Sprintf("ds=nocloud-net;s=http://%s/latest/", mmdsAddress),
+	}
+	if extra != "" {
+		args = append(args, extra)
+	}
+	return strings.Join(args, " ")
+}
+
+// mmdsData builds the metadata served to the guest. cloud-init reads
+// user-data and meta-data; the lite-engine TLS material is also exposed on its
+// own so images without cloud-init can fetch it directly.
+func mmdsData(name, userData string, opts *types.InstanceCreateOpts) map[string]interface{} {
+	return map[string]interface{}{
+		"latest": map[string]interface{}{
+			"meta-data":   fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", name, name),
+			"user-data":   userData,
+			"vendor-data": "",
+			"lite-engine": map[string]interface{}{
+				"ca-cert":  string(opts.CACert),
+				"tls-cert": string(opts.TLSCert),
+				"tls-key":  string(opts.TLSKey),
+			},
+		},
+	}
+}
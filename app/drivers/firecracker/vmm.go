@@ -0,0 +1,241 @@
+package firecracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const socketWaitTimeout = 5 * time.Second
+
+// machine describes a single microVM and the files it owns on the host.
+type machine struct {
+	name         string
+	dir          string
+	socketPath   string
+	pidPath      string
+	consolePath  string
+	snapshotPath string
+	memPath      string
+	kernel       string
+	rootfs       string
+	bootArgs     string
+	vcpus        int64
+	memoryMB     int64
+	tap          string
+	guestMAC     string
+	mmds         map[string]interface{}
+}
+
+// VMM manages the lifecycle of Firecracker processes. The default
+// implementation spawns the firecracker binary and drives its API socket;
+// tests substitute a fake so the driver can be exercised without KVM.
+type VMM interface {
+	// Boot starts a new process for the machine, configures it and boots the guest.
+	Boot(ctx context.Context, m *machine) error
+	// Snapshot pauses the guest, writes a full snapshot to disk and stops the process.
+	Snapshot(ctx context.Context, m *machine) error
+	// Restore starts a new process and resumes the guest from its snapshot.
+	Restore(ctx context.Context, m *machine) error
+	// Stop kills the process, it is not an error if it is not running.
+	Stop(ctx context.Context, m *machine) error
+	// Running reports whether the machine process is alive.
+	Running(ctx context.Context, m *machine) bool
+}
+
+// processVMM implements VMM by running the firecracker binary on the local host.
+type processVMM struct {
+	binary string
+}
+
+// NewVMM returns a VMM that launches the given firecracker binary.
+func NewVMM(binary string) VMM {
+	return &processVMM{binary: binary}
+}
+
+func (v *processVMM) Boot(ctx context.Context, m *machine) error {
+	if err := v.spawn(m); err != nil {
+		return err
+	}
+	api := newAPIClient(m.socketPath)
+	calls := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPut, "/machine-config", map[string]interface{}{"vcpu_count": m.vcpus, "mem_size_mib": m.memoryMB}},
+		{http.MethodPut, "/boot-source", map[string]interface{}{"kernel_image_path": m.kernel, "boot_args": m.bootArgs}},
+		{http.MethodPut, "/drives/rootfs", map[string]interface{}{"drive_id": "rootfs", "path_on_host": m.rootfs, "is_root_device": true, "is_read_only": false}},
+		{http.MethodPut, "/network-interfaces/eth0", map[string]interface{}{"iface_id": "eth0", "host_dev_name": m.tap, "guest_mac": m.guestMAC}},
+		{http.MethodPut, "/mmds/config", map[string]interface{}{"version": "V1", "network_interfaces": []string{"eth0"}}},
+		{http.MethodPut, "/mmds", m.mmds},
+		{http.MethodPut, "/actions", map[string]interface{}{"action_type": "InstanceStart"}},
+	}
+	for _, call := range calls {
+		if err := api.do(ctx, call.method, call.path, call.body); err != nil {
+			_ = v.Stop(ctx, m)
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *processVMM) Snapshot(ctx context.Context, m *machine) error {
+	api := newAPIClient(m.socketPath)
+	if err := api.do(ctx, http.MethodPatch, "/vm", map[string]interface{}{"state": "Paused"}); err != nil {
+		return err
+	}
+	if err := api.do(ctx, http.MethodPut, "/snapshot/create", map[string]interface{}{
+		"snapshot_type": "Full",
+		"snapshot_path": m.snapshotPath,
+		"mem_file_path": m.memPath,
+	}); err != nil {
+		// leave the guest usable if the snapshot could not be written
+		_ = api.do(ctx, http.MethodPatch, "/vm", map[string]interface{}{"state": "Resumed"})
+		return err
+	}
+	return v.Stop(ctx, m)
+}
+
+func (v *processVMM) Restore(ctx context.Context, m *machine) error {
+	if err := v.spawn(m); err != nil {
+		return err
+	}
+	api := newAPIClient(m.socketPath)
+	if err := api.do(ctx, http.MethodPut, "/snapshot/load", map[string]interface{}{
+		"snapshot_path": m.snapshotPath,
+		"mem_backend":   map[string]interface{}{"backend_type": "File", "backend_path": m.memPath},
+		"resume_vm":     true,
+	}); err != nil {
+		_ = v.Stop(ctx, m)
+		return err
+	}
+	return nil
+}
+
+func (v *processVMM) Stop(_ context.Context, m *machine) error {
+	defer os.Remove(m.socketPath)
+	pid, err := readPid(m.pidPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer os.Remove(m.pidPath)
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err = proc.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+func (v *processVMM) Running(_ context.Context, m *machine) bool {
+	pid, err := readPid(m.pidPath)
+	if err != nil {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+// spawn starts a detached firecracker process with its serial console
+// appended to the machine console log, and waits for the API socket.
+func (v *processVMM) spawn(m *machine) error {
+	_ = os.Remove(m.socketPath)
+	console, err := os.OpenFile(m.consolePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600) //nolint:mnd
+	if err != nil {
+		return err
+	}
+	defer console.Close()
+
+	cmd := exec.Command(v.binary, "--api-sock", m.socketPath, "--id", m.name) //nolint:gosec
+	cmd.Stdout, cmd.Stderr = console, console
+	logrus.Debugf("executing: %v %v", v.binary, strings.Join(cmd.Args[1:], " "))
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("firecracker: cannot start process: %w", err)
+	}
+	// reap the process when it exits so it does not linger as a zombie
+	go func() { _ = cmd.Wait() }()
+	if err = os.WriteFile(m.pidPath, []byte(strconv.Itoa(cmd.Process.Pid)), 0o600); err != nil { //nolint:mnd
+		_ = cmd.Process.Kill()
+		return err
+	}
+
+	deadline := time.Now().Add(socketWaitTimeout)
+	for time.Now().Before(deadline) {
+		if _, statErr := os.Stat(m.socketPath); statErr == nil {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond) //nolint:mnd
+	}
+	_ = cmd.Process.Kill()
+	return fmt.Errorf("firecracker: api socket %s did not appear", m.socketPath)
+}
+
+func readPid(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// apiClient talks to the Firecracker API over its unix socket.
+type apiClient struct {
+	client *http.Client
+}
+
+func newAPIClient(socketPath string) *apiClient {
+	return &apiClient{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+func (c *apiClient) do(ctx context.Context, method, path string, in interface{}) error {
+	encoded, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://localhost"+path, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 { //nolint:mnd
+		out, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("firecracker: %s %s failed with %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
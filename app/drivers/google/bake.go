@@ -0,0 +1,84 @@
+package google
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drone/runner-go/logger"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/metric"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ drivers.ImageBaker = (*config)(nil)
+
+// BakeImage stops the instance and creates an image from its boot disk in the
+// project of the pool. The image is referenced the way the pool spec
+// references images, without the compute API prefix.
+func (p *config) BakeImage(ctx context.Context, instance *types.Instance, name string) (string, error) {
+	logr := logger.FromContext(ctx).
+		WithField("id", instance.ID).
+		WithField("cloud", types.Google).
+		WithField("image", name)
+
+	zone, err := p.getZone(ctx, instance)
+	if err != nil {
+		return "", err
+	}
+
+	// an image taken from a running instance may miss writes still in the
+	// page cache
+	err = trackOperation(ctx, p.metrics, metric.GCPResourceInstance, metric.GCPOperationStop, zone, "", classifyOpts{}, func() error {
+		stopOp, stopErr := retry(ctx, p.metrics, metric.GCPResourceInstance, metric.GCPOperationStop, zone, classifyOpts{}, getRetries, secSleep, func() (*compute.Operation, error) {
+			return p.service.Instances.Stop(p.projectID, zone, instance.ID).Context(ctx).Do()
+		})
+		if stopErr != nil {
+			return stopErr
+		}
+		return p.waitZoneOperation(ctx, stopOp.Name, zone)
+	})
+	if err != nil {
+		logr.WithError(err).Errorln("google: failed to stop the instance to bake")
+		return "", err
+	}
+
+	vm, err := p.getInstance(ctx, p.projectID, zone, instance.ID)
+	if err != nil {
+		logr.WithError(err).Errorln("google: failed to retrieve instance data")
+		return "", err
+	}
+	var sourceDisk string
+	for _, disk := range vm.Disks {
+		if disk.Boot {
+			sourceDisk = disk.Source
+			break
+		}
+	}
+	if sourceDisk == "" {
+		return "", fmt.Errorf("google: instance %s has no boot disk", instance.ID)
+	}
+
+	image := &compute.Image{
+		Name:        name,
+		SourceDisk:  sourceDisk,
+		Description: fmt.Sprintf("Baked from instance %s of pool %s", instance.ID, instance.Pool),
+	}
+	err = trackOperation(ctx, p.metrics, metric.GCPResourceImage, metric.GCPOperationInsert, "", "", classifyOpts{}, func() error {
+		insertOp, insertErr := apiCall(ctx, p.metrics, metric.GCPResourceImage, metric.GCPOperationInsert, "", classifyOpts{}, func() (*compute.Operation, error) {
+			return p.service.Images.Insert(p.projectID, image).Context(ctx).Do()
+		})
+		if insertErr != nil {
+			return insertErr
+		}
+		return p.waitGlobalOperation(ctx, insertOp.Name)
+	})
+	if err != nil {
+		logr.WithError(err).Errorln("google: failed to create image")
+		return "", err
+	}
+
+	logr.Infoln("google: image created")
+	return fmt.Sprintf("%s/global/images/%s", p.projectID, name), nil
+}
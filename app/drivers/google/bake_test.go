@@ -0,0 +1,90 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// fakeImageCompute emulates the calls made to bake an image: instance stop and
+// get, image insert and operation polling.
+type fakeImageCompute struct {
+	mu      sync.Mutex
+	events  []string
+	stopped bool
+	images  []*compute.Image
+}
+
+func (f *fakeImageCompute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/instances/vm-1/stop"):
+		f.events = append(f.events, "stop")
+		f.stopped = true
+		writeJSON(w, http.StatusOK, map[string]any{"name": "opstop"})
+
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/instances/vm-1"):
+		writeJSON(w, http.StatusOK, &compute.Instance{
+			Name: "vm-1",
+			Disks: []*compute.AttachedDisk{
+				{Source: "projects/proj/zones/us-central1-a/disks/data", Boot: false},
+				{Source: "projects/proj/zones/us-central1-a/disks/vm-1", Boot: true},
+			},
+		})
+
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/global/images"):
+		var image compute.Image
+		_ = json.NewDecoder(r.Body).Decode(&image)
+		if !f.stopped {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": map[string]any{"code": 400, "message": "instance is running"}})
+			return
+		}
+		f.events = append(f.events, "insert:"+image.Name)
+		f.images = append(f.images, &image)
+		writeJSON(w, http.StatusOK, map[string]any{"name": "opimage"})
+
+	case r.Method == http.MethodGet && strings.Contains(path, "/operations/"):
+		op := path[strings.Index(path, "/operations/")+len("/operations/"):]
+		writeJSON(w, http.StatusOK, map[string]any{"name": op, "status": "DONE"})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBakeImage(t *testing.T) {
+	f := &fakeImageCompute{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	svc, err := compute.NewService(context.Background(), option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("compute.NewService: %v", err)
+	}
+	svc.BasePath = srv.URL + "/"
+	p := &config{projectID: "proj", service: svc}
+
+	ref, err := p.BakeImage(context.Background(), &types.Instance{ID: "vm-1", Pool: "linux", Zone: "us-central1-a"}, "linux-20261017")
+	if err != nil {
+		t.Fatalf("BakeImage: %v", err)
+	}
+	if ref != "proj/global/images/linux-20261017" {
+		t.Errorf("image reference = %q", ref)
+	}
+	if got := strings.Join(f.events, ","); got != "stop,insert:linux-20261017" {
+		t.Errorf("events = %s, want the instance stopped before the image is inserted", got)
+	}
+	if len(f.images) != 1 || f.images[0].SourceDisk != "projects/proj/zones/us-central1-a/disks/vm-1" {
+		t.Errorf("expected the image to be created from the boot disk, got %+v", f.images)
+	}
+}
@@ -92,6 +92,7 @@ type config struct {
 	diskSize                   int64
 	diskType                   string
 	hibernate                  bool
+	spot                       bool
 	image                      string
 	network                    string
 	noServiceAccount           bool
@@ -100,6 +101,7 @@ type config struct {
 	scopes                     []string
 	serviceAccountEmail        string
 	size                       string
+	machineTypeFallbacks       []string
 	tags                       []string
 	zones                      []string
 	userData                   string
@@ -272,6 +274,12 @@ func New(opts ...Option) (drivers.Driver, error) {
 		opt(p)
 	}
 
+	// Spot VMs are stopped by GCE on preemption, a hibernated one could be
+	// preempted while suspended and never resume.
+	if p.spot && p.hibernate {
+		return nil, errors.New("google: hibernate is not supported for spot VMs")
+	}
+
 	p.stockoutCache = expirable.NewLRU[string, struct{}](stockoutCacheSize, nil, stockoutCacheTTL)
 
 	ctx := context.Background()
@@ -395,7 +403,7 @@ func (p *config) ReserveCapacity(ctx context.Context, opts *types.InstanceCreate
 			},
 		},
 		SpecificReservationRequired: true, // Require specific reservation targeting
-		Description:                 fmt.Sprintf("Capacity reservation for pool %s", opts.PoolName),
+		Description:                 reservationDescription(opts.PoolName, opts.VMLabels),
 	}
 
 	if opts.CapacityReservationTTL > 0 {
@@ -563,10 +571,8 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 	// Step 2-3: Select network, resolve zone, capture per-network proxy_url
 	zone, resolvedNetwork, resolvedSubnetwork, resolvedTags, networkProxyURL := p.resolveNetworkAndZoneWithProxy(zone, opts.Zones)
 
-	machineType := p.size
-	if opts.MachineType != "" {
-		machineType = opts.MachineType
-	}
+	machineTypes := drivers.MachineTypes(opts, p.size, p.machineTypeFallbacks)
+	machineType := machineTypes[0]
 
 	// getImage returns the image to use for this instance creation
 	image, err := p.GetFullyQualifiedImage(ctx, &opts.VMImageConfig)
@@ -634,6 +640,13 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 	usesPersistentDisk := opts.StorageOpts.Identifier != ""
 	usesReservation := opts.CapacityReservation != nil && opts.CapacityReservation.ReservationID != ""
 	stockoutRetryEnabled := !usesPersistentDisk && !usesReservation
+	// Reservations are consumed by standard VMs only, and gitspaces with
+	// persistent disks must not be preempted, so both stay on standard VMs.
+	spot := p.spot && stockoutRetryEnabled
+	// A reservation holds capacity for one machine type only.
+	if usesReservation {
+		machineTypes = machineTypes[:1]
+	}
 
 	candidates := []createCandidate{{
 		zone:       zone,
@@ -643,8 +656,8 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 		proxyURL:   networkProxyURL,
 	}}
 	if stockoutRetryEnabled {
-		candidates = p.buildCreateCandidates(candidates[0], machineType)
-		p.logStockoutDeprioritization(logr, candidates, machineType)
+		candidates = p.buildCreateCandidates(candidates[0], capacityKey(machineType, spot))
+		p.logStockoutDeprioritization(logr, candidates, capacityKey(machineType, spot))
 	}
 
 	// Build the zone-independent instance spec once. Per-attempt fields (zone,
@@ -681,13 +694,9 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 		AdvancedMachineFeatures: advancedMachineFeatures,
 		CanIpForward:            false,
 		NetworkInterfaces:       []*compute.NetworkInterface{{AccessConfigs: networkConfig}},
-		Scheduling: &compute.Scheduling{
-			Preemptible:       false,
-			OnHostMaintenance: onHostMaintenance(gpu),
-			AutomaticRestart:  googleapi.Bool(true),
-		},
-		DeletionProtection: false,
-		Labels:             p.buildLabelsWithGitspace(opts),
+		Scheduling:              scheduling(gpu, spot),
+		DeletionProtection:      false,
+		Labels:                  provisioningModelLabels(p.buildLabelsWithGitspace(opts), spot),
 	}
 
 	// Add BYOI metadata for custom images
@@ -711,15 +720,27 @@ func (p *config) create(ctx context.Context, opts *types.InstanceCreateOpts, nam
 		}
 	}
 
-	op, succeeded, err := p.insertWithStockoutRetry(ctx, in, candidates, opts, machineType, bootDiskType, stockoutRetryEnabled, usesReservation, logr)
+	var op *compute.Operation
+	var succeeded createCandidate
+	machineType, err = drivers.CreateWithMachineTypeFallback(ctx, p.DriverName(), machineTypes, isStockoutError, p.metrics, func(machineType string) error {
+		// a previous machine type may have fallen back to standard VMs
+		in.Scheduling = scheduling(gpu, spot)
+		in.Labels = provisioningModelLabels(in.Labels, spot)
+		var insertErr error
+		op, succeeded, insertErr = p.insertWithSpotFallback(ctx, in, p.deprioritizeStockoutZones(candidates, capacityKey(machineType, spot)),
+			opts, machineType, bootDiskType, stockoutRetryEnabled, usesReservation, gpu, logr.WithField("size", machineType))
+		return insertErr
+	}, func(string) {
+		p.cleanupFailedInstance(ctx, succeeded.zone, in.Name, logr)
+	})
 	if err != nil {
 		return nil, succeeded.zone, err
 	}
 	zone = succeeded.zone
 	resolvedNetwork = succeeded.network
 
-	// Reflect the zone that actually succeeded in subsequent log lines.
-	logr = logr.WithField("zone", zone)
+	// Reflect the zone and machine type that actually succeeded in subsequent log lines.
+	logr = logr.WithField("zone", zone).WithField("size", machineType)
 
 	logr.Debugln("instance insert operation completed")
 
@@ -828,11 +849,13 @@ func (p *config) insertWithStockoutRetry(
 			attemptLogr.WithError(attemptErr).
 				WithField("machine_type", machineType).
 				Warnln("google: stockout detected for zone")
+			p.metrics.RecordZoneStockout(p.DriverName(), metric.StockoutOperationCreate, zone, machineType)
 			if !usesReservation {
-				p.markStockout(zone, machineType)
+				p.markStockout(zone, capacityKey(machineType, isSpotScheduling(in.Scheduling)))
 			}
 			if stockoutRetryEnabled && attempt < len(candidates)-1 {
 				attemptLogr.WithError(attemptErr).Warnln("google: zone stockout, retrying alternate zone/network candidate")
+				p.metrics.RecordZoneRetry(p.DriverName(), metric.StockoutOperationCreate, metric.ReasonStockout, zone)
 				p.cleanupFailedInstance(ctx, zone, in.Name, attemptLogr)
 				continue
 			}
@@ -904,6 +927,7 @@ func (p *config) attachPersistentDisk(
 			SizeGb: diskSize,
 			Type:   diskType,
 			Zone:   diskZone,
+			Labels: opts.VMLabels,
 		}
 		op, err := p.createPersistentDiskIfNotExists(ctx, p.projectID, diskZone, requestID, persistentDisk)
 		if err != nil {
@@ -1329,9 +1353,15 @@ func (p *config) mapToInstance(
 		instanceIP = network.AccessConfigs[0].NatIP
 	}
 
-	labelsBytes, marshalErr := json.Marshal(opts.InternalLabels)
+	// record the provisioning model the VM actually got, a spot create may
+	// have fallen back to a standard VM
+	internalLabels := opts.InternalLabels
+	if p.spot {
+		internalLabels = provisioningModelLabels(opts.InternalLabels, isSpotScheduling(vm.Scheduling))
+	}
+	labelsBytes, marshalErr := json.Marshal(internalLabels)
 	if marshalErr != nil {
-		return types.Instance{}, fmt.Errorf("scheduler: could not marshal labels: %v, err: %w", opts.InternalLabels, marshalErr)
+		return types.Instance{}, fmt.Errorf("scheduler: could not marshal labels: %v, err: %w", internalLabels, marshalErr)
 	}
 
 	started, _ := time.Parse(time.RFC3339, vm.CreationTimestamp)
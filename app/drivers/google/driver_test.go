@@ -394,7 +394,9 @@ type fakeCompute struct {
 	inserts      []*compute.Instance // decoded request body of each accepted insert
 	insertCount  int32
 	deleteCount  int32
-	stockoutZone string // inserts here "succeed" then the op fails with stockout
+	stockoutZone string              // inserts here "succeed" then the op fails with stockout
+	stockoutSpot bool                // spot inserts in any zone fail with stockout
+	listed       []*compute.Instance // returned by instance list calls
 }
 
 func zoneFromPath(path string) string {
@@ -439,8 +441,18 @@ func (f *fakeCompute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		f.exists = true
 		f.inserts = append(f.inserts, &inst)
 		f.mu.Unlock()
+		if f.stockoutSpot && isSpotScheduling(inst.Scheduling) {
+			writeJSON(w, http.StatusOK, map[string]any{"name": "opspot-" + zone})
+			return
+		}
 		writeJSON(w, http.StatusOK, map[string]any{"name": "opinsert-" + zone})
 
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/instances"):
+		f.mu.Lock()
+		items := f.listed
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, &compute.InstanceList{Items: items})
+
 	case r.Method == http.MethodDelete && strings.Contains(path, "/instances/"):
 		zone := zoneFromPath(path)
 		atomic.AddInt32(&f.deleteCount, 1)
@@ -452,7 +464,8 @@ func (f *fakeCompute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	case r.Method == http.MethodGet && strings.Contains(path, "/operations/"):
 		op := path[strings.Index(path, "/operations/")+len("/operations/"):]
-		if strings.HasPrefix(op, "opinsert-") && strings.TrimPrefix(op, "opinsert-") == f.stockoutZone {
+		if (strings.HasPrefix(op, "opinsert-") && strings.TrimPrefix(op, "opinsert-") == f.stockoutZone) ||
+			strings.HasPrefix(op, "opspot-") {
 			writeJSON(w, http.StatusOK, map[string]any{
 				"name":   op,
 				"status": "DONE",
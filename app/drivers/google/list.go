@@ -0,0 +1,95 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/metric"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var _ drivers.InstanceLister = (*config)(nil)
+
+// List returns the VMs of the project carrying the labels, in every zone and
+// not only in the zones of the pool, so that VMs left behind in a zone the
+// pool no longer uses are found too.
+func (p *config) List(ctx context.Context, labels map[string]string) ([]*drivers.CloudInstance, error) {
+	var instances []*drivers.CloudInstance
+	_, err := apiCall(ctx, p.metrics, metric.GCPResourceInstance, metric.GCPOperationList, "", classifyOpts{}, func() (struct{}, error) {
+		instances = nil
+		return struct{}{}, p.service.Instances.AggregatedList(p.projectID).
+			Filter(labelFilter(labels)).
+			Pages(ctx, func(list *compute.InstanceAggregatedList) error {
+				for _, scoped := range list.Items {
+					for _, vm := range scoped.Instances {
+						instances = append(instances, toCloudInstance(vm))
+					}
+				}
+				return nil
+			})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("google: failed to list instances: %w", err)
+	}
+	return instances, nil
+}
+
+// Exists looks up the VM in the zone of the instance, or in every zone of the
+// pool when the instance has none.
+func (p *config) Exists(ctx context.Context, inst *types.Instance) (bool, error) {
+	if inst.Zone == "" {
+		_, err := p.findInstanceZone(ctx, inst.ID)
+		if errors.Is(err, ErrInstanceNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("google: failed to look up instance %s: %w", inst.ID, err)
+		}
+		return true, nil
+	}
+	_, err := p.getInstance(ctx, p.projectID, inst.Zone, inst.ID)
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("google: failed to look up instance %s: %w", inst.ID, err)
+	}
+	return true, nil
+}
+
+// labelFilter returns the list filter matching the instances carrying all the
+// labels.
+func labelFilter(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	terms := make([]string, len(keys))
+	for i, k := range keys {
+		terms[i] = fmt.Sprintf("(labels.%s = %q)", k, labels[k])
+	}
+	return strings.Join(terms, " AND ")
+}
+
+func toCloudInstance(vm *compute.Instance) *drivers.CloudInstance {
+	created, _ := time.Parse(time.RFC3339, vm.CreationTimestamp)
+	return &drivers.CloudInstance{
+		ID:      strconv.FormatUint(vm.Id, 10),
+		Name:    vm.Name,
+		Zone:    path.Base(vm.Zone),
+		Created: created,
+		Labels:  vm.Labels,
+	}
+}
@@ -0,0 +1,33 @@
+package google
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestLabelFilter(t *testing.T) {
+	got := labelFilter(map[string]string{"pool_id": "linux", "harness-created-by": "harness-ci"})
+	want := `(labels.harness-created-by = "harness-ci") AND (labels.pool_id = "linux")`
+	if got != want {
+		t.Errorf("labelFilter() = %s, want %s", got, want)
+	}
+}
+
+func TestToCloudInstance(t *testing.T) {
+	vm := &compute.Instance{
+		Id:                1234,
+		Name:              "linux-vm",
+		Zone:              "https://www.googleapis.com/compute/v1/projects/p/zones/us-east1-b",
+		CreationTimestamp: "2026-10-17T09:05:03.000-07:00",
+		Labels:            map[string]string{"pool_id": "linux"},
+	}
+	got := toCloudInstance(vm)
+	if got.ID != "1234" || got.Name != "linux-vm" || got.Zone != "us-east1-b" || got.Labels["pool_id"] != "linux" {
+		t.Errorf("unexpected instance %+v", got)
+	}
+	if want := time.Date(2026, time.October, 17, 16, 5, 3, 0, time.UTC); !got.Created.Equal(want) {
+		t.Errorf("created = %s, want %s", got.Created, want)
+	}
+}
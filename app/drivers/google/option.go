@@ -77,6 +77,14 @@ func WithMachineImage(image string) Option {
 	}
 }
 
+// WithMachineTypeFallbacks returns an option to set the machine types tried,
+// in order, when the pool's machine type is out of capacity in every zone.
+func WithMachineTypeFallbacks(machineTypes ...string) Option {
+	return func(p *config) {
+		p.machineTypeFallbacks = machineTypes
+	}
+}
+
 // WithSize returns an option to set the instance type.
 func WithSize(size string) Option {
 	return func(p *config) {
@@ -212,6 +220,14 @@ func WithNoServiceAccount(v bool) Option {
 	}
 }
 
+// WithProvisioningModel returns an option to create Spot VMs when set to
+// "spot". Any other value creates standard VMs.
+func WithProvisioningModel(model string) Option {
+	return func(p *config) {
+		p.spot = model == ProvisioningModelSpot
+	}
+}
+
 func WithHibernate(hibernate bool) Option {
 	return func(p *config) {
 		p.hibernate = hibernate
@@ -0,0 +1,142 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/metric"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var (
+	_ drivers.DiskCollector             = (*config)(nil)
+	_ drivers.CapacityReservationLister = (*config)(nil)
+)
+
+// ListDisks returns the persistent disks of the project carrying the labels,
+// in every zone.
+func (p *config) ListDisks(ctx context.Context, labels map[string]string) ([]*drivers.CloudResource, error) {
+	var disks []*drivers.CloudResource
+	_, err := apiCall(ctx, p.metrics, metric.GCPResourceDisk, metric.GCPOperationList, "", classifyOpts{}, func() (struct{}, error) {
+		disks = nil
+		return struct{}{}, p.service.Disks.AggregatedList(p.projectID).
+			Filter(labelFilter(labels)).
+			Pages(ctx, func(list *compute.DiskAggregatedList) error {
+				for _, scoped := range list.Items {
+					for _, disk := range scoped.Disks {
+						created, _ := time.Parse(time.RFC3339, disk.CreationTimestamp)
+						disks = append(disks, &drivers.CloudResource{
+							ID:       disk.Name,
+							Name:     disk.Name,
+							Zone:     path.Base(disk.Zone),
+							Created:  created,
+							Labels:   disk.Labels,
+							Attached: len(disk.Users) > 0,
+						})
+					}
+				}
+				return nil
+			})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("google: failed to list disks: %w", err)
+	}
+	return disks, nil
+}
+
+// DeleteDisk deletes a persistent disk and waits for the deletion.
+func (p *config) DeleteDisk(ctx context.Context, disk *drivers.CloudResource) error {
+	logr := logger.FromContext(ctx).
+		WithField("disk", disk.Name).
+		WithField("zone", disk.Zone).
+		WithField("cloud", types.Google)
+	_, err := p.deletePersistentDisksForCleanup(ctx, logr, disk.Name, disk.Zone)
+	return err
+}
+
+// ListCapacityReservations returns the capacity reservations of the project
+// created with the labels. Reservations have no labels, the labels they were
+// created with are recorded in their description.
+func (p *config) ListCapacityReservations(ctx context.Context, labels map[string]string) ([]*drivers.CloudResource, error) {
+	var reservations []*drivers.CloudResource
+	_, err := apiCall(ctx, p.metrics, metric.GCPResourceReservation, metric.GCPOperationList, "", classifyOpts{}, func() (struct{}, error) {
+		reservations = nil
+		return struct{}{}, p.service.Reservations.AggregatedList(p.projectID).
+			Pages(ctx, func(list *compute.ReservationAggregatedList) error {
+				for _, scoped := range list.Items {
+					for _, reservation := range scoped.Reservations {
+						reservationLabels := descriptionLabels(reservation.Description)
+						if !hasLabels(reservationLabels, labels) {
+							continue
+						}
+						created, _ := time.Parse(time.RFC3339, reservation.CreationTimestamp)
+						reservations = append(reservations, &drivers.CloudResource{
+							ID:      reservation.Name,
+							Name:    reservation.Name,
+							Zone:    path.Base(reservation.Zone),
+							Created: created,
+							Labels:  reservationLabels,
+						})
+					}
+				}
+				return nil
+			})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("google: failed to list capacity reservations: %w", err)
+	}
+	return reservations, nil
+}
+
+// reservationDescription returns the description of a capacity reservation,
+// with the labels it is created with appended in brackets.
+func reservationDescription(poolName string, labels map[string]string) string {
+	description := fmt.Sprintf("Capacity reservation for pool %s", poolName)
+	if len(labels) == 0 {
+		return description
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + labels[k]
+	}
+	return description + " [" + strings.Join(pairs, ",") + "]"
+}
+
+// descriptionLabels returns the labels recorded in a capacity reservation
+// description by reservationDescription, or nil.
+func descriptionLabels(description string) map[string]string {
+	start := strings.LastIndex(description, " [")
+	if start < 0 || !strings.HasSuffix(description, "]") {
+		return nil
+	}
+	labels := map[string]string{}
+	for _, pair := range strings.Split(description[start+2:len(description)-1], ",") {
+		if k, v, ok := strings.Cut(pair, "="); ok {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// hasLabels reports whether labels contains all the wanted labels.
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
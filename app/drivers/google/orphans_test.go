@@ -0,0 +1,27 @@
+package google
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReservationDescription(t *testing.T) {
+	labels := map[string]string{"pool_id": "linux", "harness-created-by": "harness-ci"}
+	got := reservationDescription("linux", labels)
+	want := "Capacity reservation for pool linux [harness-created-by=harness-ci,pool_id=linux]"
+	if got != want {
+		t.Errorf("reservationDescription() = %s, want %s", got, want)
+	}
+	if parsed := descriptionLabels(got); !reflect.DeepEqual(parsed, labels) {
+		t.Errorf("descriptionLabels() = %v, want %v", parsed, labels)
+	}
+}
+
+func TestDescriptionLabels_Unlabelled(t *testing.T) {
+	if got := descriptionLabels(reservationDescription("linux", nil)); got != nil {
+		t.Errorf("descriptionLabels() = %v, want nil", got)
+	}
+	if hasLabels(nil, map[string]string{"pool_id": "linux"}) {
+		t.Error("a reservation created before labelling must not match")
+	}
+}
@@ -0,0 +1,193 @@
+package google
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	itypes "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/metric"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	_ drivers.InterruptionDetector = (*config)(nil)
+	_ drivers.InterruptionReporter = (*config)(nil)
+)
+
+// Provisioning models supported by the pool spec.
+const (
+	ProvisioningModelStandard = "standard"
+	ProvisioningModelSpot     = "spot"
+)
+
+const (
+	gceProvisioningModelSpot = "SPOT"
+
+	labelProvisioningModel = "provisioning_model"
+	labelCostClass         = "cost_class"
+
+	costClassSpot     = "spot"
+	costClassOnDemand = "on_demand"
+)
+
+// preemptedStatuses are the instance statuses a Spot VM moves through once
+// GCE preempted it. Spot VMs are created with the STOP termination action and
+// are never stopped by the runner itself, so any of these means preemption.
+var preemptedStatuses = map[string]bool{
+	"STOPPING":   true,
+	"TERMINATED": true,
+}
+
+// scheduling returns the scheduling options for a new VM. Spot VMs cannot be
+// live migrated or restarted, GCE stops them when it needs the capacity back.
+func scheduling(gpu, spot bool) *compute.Scheduling {
+	if spot {
+		return &compute.Scheduling{
+			ProvisioningModel:         gceProvisioningModelSpot,
+			InstanceTerminationAction: "STOP",
+			OnHostMaintenance:         "TERMINATE",
+			AutomaticRestart:          googleapi.Bool(false),
+		}
+	}
+	return &compute.Scheduling{
+		Preemptible:       false,
+		OnHostMaintenance: onHostMaintenance(gpu),
+		AutomaticRestart:  googleapi.Bool(true),
+	}
+}
+
+func isSpotScheduling(s *compute.Scheduling) bool {
+	return s != nil && s.ProvisioningModel == gceProvisioningModelSpot
+}
+
+// provisioningModelLabels returns a copy of labels with the provisioning model
+// and cost class of the VM added. The input map may be shared with the pool
+// config, so it is never modified.
+func provisioningModelLabels(labels map[string]string, spot bool) map[string]string {
+	out := make(map[string]string, len(labels)+2)
+	for k, v := range labels {
+		out[k] = v
+	}
+	if spot {
+		out[labelProvisioningModel] = ProvisioningModelSpot
+		out[labelCostClass] = costClassSpot
+	} else {
+		out[labelProvisioningModel] = ProvisioningModelStandard
+		out[labelCostClass] = costClassOnDemand
+	}
+	return out
+}
+
+// capacityKey is the machine type key used for the stockout cache. Spot and
+// standard capacity run out independently, so a spot stockout must not
+// deprioritize the zone for standard VMs and vice versa.
+func capacityKey(machineType string, spot bool) string {
+	if spot {
+		return machineType + "/" + ProvisioningModelSpot
+	}
+	return machineType
+}
+
+// insertWithSpotFallback creates the instance through insertWithStockoutRetry.
+// When the spec asks for a Spot VM and spot capacity is exhausted in every
+// candidate zone, the instance is recreated as a standard VM, walking the same
+// candidates again with standard capacity ordering.
+func (p *config) insertWithSpotFallback(
+	ctx context.Context,
+	in *compute.Instance,
+	candidates []createCandidate,
+	opts *types.InstanceCreateOpts,
+	machineType, bootDiskType string,
+	stockoutRetryEnabled, usesReservation, gpu bool,
+	logr logger.Logger,
+) (*compute.Operation, createCandidate, error) {
+	op, cand, err := p.insertWithStockoutRetry(ctx, in, candidates, opts, machineType, bootDiskType, stockoutRetryEnabled, usesReservation, logr)
+	if err == nil || !isSpotScheduling(in.Scheduling) || !isStockoutError(err) {
+		return op, cand, err
+	}
+
+	logr.WithError(err).
+		WithField("zone", cand.zone).
+		WithField("machine_type", machineType).
+		Warnln("google: spot capacity exhausted in all candidate zones, falling back to standard VMs")
+	p.metrics.RecordZoneRetry(p.DriverName(), metric.StockoutOperationCreate, metric.ReasonSpotFallback, cand.zone)
+	p.cleanupFailedInstance(ctx, cand.zone, in.Name, logr)
+
+	in.Scheduling = scheduling(gpu, false)
+	in.Labels = provisioningModelLabels(in.Labels, false)
+	candidates = p.deprioritizeStockoutZones(candidates, capacityKey(machineType, false))
+	return p.insertWithStockoutRetry(ctx, in, candidates, opts, machineType, bootDiskType, stockoutRetryEnabled, usesReservation, logr)
+}
+
+// Interrupted returns the IDs of the given instances whose Spot VM was
+// preempted. Instances are listed per zone, so a pool check costs one call
+// per zone instead of one per instance.
+func (p *config) Interrupted(ctx context.Context, instances []*types.Instance) ([]string, error) {
+	if !p.spot || len(instances) == 0 {
+		return nil, nil
+	}
+
+	byZone := map[string][]*types.Instance{}
+	for _, instance := range instances {
+		if instance.Zone == "" {
+			continue
+		}
+		byZone[instance.Zone] = append(byZone[instance.Zone], instance)
+	}
+
+	var interrupted []string
+	var lastErr error
+	for zone, zoneInstances := range byZone {
+		preempted, err := p.listPreempted(ctx, zone)
+		if err != nil {
+			logger.FromContext(ctx).
+				WithField("driver", p.DriverName()).
+				WithField("zone", zone).
+				WithError(err).
+				Warnln("google: failed to list preempted spot VMs")
+			lastErr = err
+			continue
+		}
+		for _, instance := range zoneInstances {
+			if preempted[instance.ID] || preempted[instance.Name] {
+				interrupted = append(interrupted, instance.ID)
+			}
+		}
+	}
+	return interrupted, lastErr
+}
+
+// InterruptionError reports a preempted instance with an *ErrSpotPreempted so
+// clients can tell GCE preemptions apart from other interruptions.
+func (p *config) InterruptionError(instance *types.Instance) error {
+	return &itypes.ErrSpotPreempted{
+		ErrSpotInterrupted: itypes.ErrSpotInterrupted{Driver: p.DriverName(), InstanceID: instance.ID},
+		Zone:               instance.Zone,
+	}
+}
+
+// listPreempted returns the IDs and names of the preempted Spot VMs in a zone.
+func (p *config) listPreempted(ctx context.Context, zone string) (map[string]bool, error) {
+	preempted := map[string]bool{}
+	_, err := apiCall(ctx, p.metrics, metric.GCPResourceInstance, metric.GCPOperationList, zone, classifyOpts{}, func() (struct{}, error) {
+		return struct{}{}, p.service.Instances.List(p.projectID, zone).
+			Filter(`scheduling.provisioningModel = "`+gceProvisioningModelSpot+`"`).
+			Pages(ctx, func(list *compute.InstanceList) error {
+				for _, vm := range list.Items {
+					if !isSpotScheduling(vm.Scheduling) || !preemptedStatuses[vm.Status] {
+						continue
+					}
+					preempted[strconv.FormatUint(vm.Id, 10)] = true
+					preempted[vm.Name] = true
+				}
+				return nil
+			})
+	})
+	return preempted, err
+}
@@ -0,0 +1,188 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/drone/runner-go/logger"
+	"google.golang.org/api/compute/v1"
+
+	itypes "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestScheduling(t *testing.T) {
+	spot := scheduling(false, true)
+	if !isSpotScheduling(spot) {
+		t.Fatalf("expected spot scheduling, got %+v", spot)
+	}
+	if spot.InstanceTerminationAction != "STOP" || spot.OnHostMaintenance != "TERMINATE" || *spot.AutomaticRestart {
+		t.Errorf("unexpected spot scheduling %+v", spot)
+	}
+
+	standard := scheduling(true, false)
+	if isSpotScheduling(standard) {
+		t.Fatalf("expected standard scheduling, got %+v", standard)
+	}
+	if standard.OnHostMaintenance != onHostMaintenance(true) || !*standard.AutomaticRestart {
+		t.Errorf("unexpected standard scheduling %+v", standard)
+	}
+}
+
+func TestProvisioningModelLabels(t *testing.T) {
+	shared := map[string]string{"pool": "linux"}
+
+	spot := provisioningModelLabels(shared, true)
+	if spot[labelProvisioningModel] != ProvisioningModelSpot || spot[labelCostClass] != costClassSpot {
+		t.Errorf("unexpected spot labels %v", spot)
+	}
+	standard := provisioningModelLabels(spot, false)
+	if standard[labelProvisioningModel] != ProvisioningModelStandard || standard[labelCostClass] != costClassOnDemand {
+		t.Errorf("unexpected standard labels %v", standard)
+	}
+	if standard["pool"] != "linux" {
+		t.Errorf("expected existing labels to be kept, got %v", standard)
+	}
+	if len(shared) != 1 {
+		t.Errorf("shared labels must not be modified, got %v", shared)
+	}
+}
+
+func TestCapacityKey(t *testing.T) {
+	if capacityKey("n2-standard-4", false) == capacityKey("n2-standard-4", true) {
+		t.Fatal("spot and standard capacity must be cached separately")
+	}
+}
+
+func newSpotTestInstance() *compute.Instance {
+	in := newTestInstance()
+	in.Scheduling = scheduling(false, true)
+	in.Labels = provisioningModelLabels(nil, true)
+	return in
+}
+
+// TestInsertWithSpotFallback_FallsBackToStandard verifies that once every
+// candidate zone is out of spot capacity the VM is created as a standard VM,
+// and that only the spot capacity is remembered as stocked out.
+func TestInsertWithSpotFallback_FallsBackToStandard(t *testing.T) {
+	f := &fakeCompute{stockoutSpot: true}
+	p, cleanup := newFakeComputeConfig(t, f)
+	defer cleanup()
+	p.stockoutCache = newTestStockoutCache()
+
+	in := newSpotTestInstance()
+	_, succeeded, err := p.insertWithSpotFallback(
+		context.Background(), in, twoZoneCandidates(),
+		&types.InstanceCreateOpts{}, "c4d-standard-4", "pd-balanced",
+		true /*stockoutRetryEnabled*/, false /*usesReservation*/, false /*gpu*/, logger.Discard(),
+	)
+	if err != nil {
+		t.Fatalf("expected fallback to standard to succeed, got error: %v", err)
+	}
+	if succeeded.zone != "us-central1-a" {
+		t.Errorf("expected the standard VM in the first candidate zone, got %s", succeeded.zone)
+	}
+
+	want := []string{
+		"insert:us-central1-a", "delete:us-central1-a",
+		"insert:us-central1-b", "delete:us-central1-b",
+		"insert:us-central1-a",
+	}
+	f.mu.Lock()
+	got := append([]string(nil), f.events...)
+	last := f.inserts[len(f.inserts)-1]
+	f.mu.Unlock()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("call order: want %v, got %v", want, got)
+	}
+	if isSpotScheduling(last.Scheduling) {
+		t.Error("expected the fallback insert to use standard scheduling")
+	}
+	if last.Labels[labelProvisioningModel] != ProvisioningModelStandard || last.Labels[labelCostClass] != costClassOnDemand {
+		t.Errorf("expected standard labels on the fallback insert, got %v", last.Labels)
+	}
+
+	if !p.isStockoutZone("us-central1-a", capacityKey("c4d-standard-4", true)) {
+		t.Error("expected spot capacity in us-central1-a to be remembered as stocked out")
+	}
+	if p.isStockoutZone("us-central1-a", capacityKey("c4d-standard-4", false)) {
+		t.Error("a spot stockout must not mark standard capacity as stocked out")
+	}
+}
+
+// TestInsertWithSpotFallback_StandardStockoutNotRetried verifies that standard
+// VMs keep the plain stockout behaviour and are not retried a second time.
+func TestInsertWithSpotFallback_StandardStockoutNotRetried(t *testing.T) {
+	f := &fakeCompute{stockoutZone: "us-central1-a"}
+	p, cleanup := newFakeComputeConfig(t, f)
+	defer cleanup()
+
+	in := newTestInstance()
+	in.Scheduling = scheduling(false, false)
+	_, _, err := p.insertWithSpotFallback(
+		context.Background(), in, twoZoneCandidates()[:1],
+		&types.InstanceCreateOpts{}, "c4d-standard-4", "pd-balanced",
+		true /*stockoutRetryEnabled*/, false /*usesReservation*/, false /*gpu*/, logger.Discard(),
+	)
+	if !isStockoutError(err) {
+		t.Fatalf("expected a stockout error, got: %v", err)
+	}
+	if got := len(f.inserts); got != 1 {
+		t.Errorf("expected exactly 1 insert, got %d", got)
+	}
+}
+
+func TestInterrupted(t *testing.T) {
+	spot := scheduling(false, true)
+	f := &fakeCompute{listed: []*compute.Instance{
+		{Id: 1, Name: "vm-1", Status: "TERMINATED", Scheduling: spot},
+		{Id: 2, Name: "vm-2", Status: "RUNNING", Scheduling: spot},
+		{Id: 3, Name: "vm-3", Status: "STOPPING", Scheduling: spot},
+		{Id: 4, Name: "vm-4", Status: "TERMINATED", Scheduling: scheduling(false, false)},
+	}}
+	p, cleanup := newFakeComputeConfig(t, f)
+	defer cleanup()
+
+	instances := []*types.Instance{
+		{ID: "1", Name: "vm-1", Zone: "us-central1-a"},
+		{ID: "2", Name: "vm-2", Zone: "us-central1-a"},
+		{ID: "vm-3", Name: "vm-3", Zone: "us-central1-a"},
+		{ID: "4", Name: "vm-4", Zone: "us-central1-a"},
+	}
+
+	// pools that do not run on spot are never checked
+	ids, err := p.Interrupted(context.Background(), instances)
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("expected no interruptions for a standard pool, got %v, %v", ids, err)
+	}
+
+	p.spot = true
+	ids, err = p.Interrupted(context.Background(), instances)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(ids)
+	if strings.Join(ids, ",") != "1,vm-3" {
+		t.Errorf("expected instances 1 and vm-3 to be preempted, got %v", ids)
+	}
+}
+
+func TestInterruptionError(t *testing.T) {
+	p := &config{}
+	err := p.InterruptionError(&types.Instance{ID: "1", Zone: "us-central1-a"})
+
+	var preemptedErr *itypes.ErrSpotPreempted
+	if !errors.As(err, &preemptedErr) {
+		t.Fatalf("expected *ErrSpotPreempted, got %T", err)
+	}
+	if preemptedErr.Zone != "us-central1-a" || preemptedErr.InstanceID != "1" || preemptedErr.Driver != string(types.Google) {
+		t.Errorf("unexpected error fields %+v", preemptedErr)
+	}
+	var interruptedErr *itypes.ErrSpotInterrupted
+	if !errors.As(err, &interruptedErr) {
+		t.Error("expected a preemption to unwrap to *ErrSpotInterrupted")
+	}
+}
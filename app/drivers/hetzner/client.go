@@ -0,0 +1,242 @@
+package hetzner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultEndpoint = "https://api.hetzner.cloud/v1"
+
+	pathServers      = "%s/servers"
+	pathServer       = "%s/servers/%d"
+	pathServerAction = "%s/servers/%d/actions/%s"
+	pathAction       = "%s/actions/%d"
+
+	actionPowerOff = "poweroff"
+	actionPowerOn  = "poweron"
+
+	actionStatusSuccess = "success"
+	actionStatusError   = "error"
+
+	serverStatusRunning = "running"
+	serverStatusOff     = "off"
+
+	errorCodeNotFound = "not_found"
+)
+
+type createServerRequest struct {
+	Name             string            `json:"name"`
+	ServerType       string            `json:"server_type"`
+	Image            string            `json:"image"`
+	Location         string            `json:"location,omitempty"`
+	UserData         string            `json:"user_data,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	SSHKeys          []string          `json:"ssh_keys,omitempty"`
+	Firewalls        []serverFirewall  `json:"firewalls,omitempty"`
+	Networks         []int64           `json:"networks,omitempty"`
+	PublicNet        *serverPublicNet  `json:"public_net,omitempty"`
+	StartAfterCreate bool              `json:"start_after_create"`
+}
+
+type serverFirewall struct {
+	Firewall int64 `json:"firewall"`
+}
+
+type serverPublicNet struct {
+	EnableIPv4 bool `json:"enable_ipv4"`
+	EnableIPv6 bool `json:"enable_ipv6"`
+}
+
+type updateServerRequest struct {
+	Labels map[string]string `json:"labels"`
+}
+
+type server struct {
+	ID        int64             `json:"id"`
+	Name      string            `json:"name"`
+	Status    string            `json:"status"`
+	Created   string            `json:"created"`
+	Labels    map[string]string `json:"labels"`
+	PublicNet struct {
+		IPv4 *struct {
+			IP string `json:"ip"`
+		} `json:"ipv4"`
+	} `json:"public_net"`
+	PrivateNet []struct {
+		Network int64  `json:"network"`
+		IP      string `json:"ip"`
+	} `json:"private_net"`
+	Datacenter struct {
+		Name     string `json:"name"`
+		Location struct {
+			Name        string `json:"name"`
+			NetworkZone string `json:"network_zone"`
+		} `json:"location"`
+	} `json:"datacenter"`
+}
+
+type action struct {
+	ID      int64  `json:"id"`
+	Command string `json:"command"`
+	Status  string `json:"status"`
+	Error   *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type serverResponse struct {
+	Server *server `json:"server"`
+	Action *action `json:"action"`
+}
+
+type serverListResponse struct {
+	Servers []*server `json:"servers"`
+}
+
+type actionResponse struct {
+	Action *action `json:"action"`
+}
+
+// apiError is the error envelope returned by the Hetzner Cloud API.
+type apiError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("hetzner: %s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// isNotFound reports whether the error is a Hetzner Cloud not_found error.
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == errorCodeNotFound || apiErr.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// Client is a minimal Hetzner Cloud API client covering the server
+// lifecycle calls used by the driver.
+type Client interface {
+	ServerCreate(ctx context.Context, in *createServerRequest) (*serverResponse, error)
+	ServerGet(ctx context.Context, id int64) (*server, error)
+	ServerList(ctx context.Context) ([]*server, error)
+	ServerDelete(ctx context.Context, id int64) (*action, error)
+	ServerUpdateLabels(ctx context.Context, id int64, labels map[string]string) (*server, error)
+	ServerAction(ctx context.Context, id int64, name string) (*action, error)
+	ActionGet(ctx context.Context, id int64) (*action, error)
+}
+
+type client struct {
+	client *http.Client
+	addr   string
+	token  string
+}
+
+func NewClient(uri, token string) Client {
+	if uri == "" {
+		uri = defaultEndpoint
+	}
+	return &client{http.DefaultClient, strings.TrimSuffix(uri, "/"), token}
+}
+
+func (c *client) ServerCreate(ctx context.Context, in *createServerRequest) (*serverResponse, error) {
+	out := new(serverResponse)
+	err := c.do(ctx, fmt.Sprintf(pathServers, c.addr), http.MethodPost, in, out)
+	return out, err
+}
+
+func (c *client) ServerGet(ctx context.Context, id int64) (*server, error) {
+	out := new(serverResponse)
+	if err := c.do(ctx, fmt.Sprintf(pathServer, c.addr, id), http.MethodGet, nil, out); err != nil {
+		return nil, err
+	}
+	if out.Server == nil {
+		return nil, fmt.Errorf("hetzner: empty server response for %d", id)
+	}
+	return out.Server, nil
+}
+
+func (c *client) ServerList(ctx context.Context) ([]*server, error) {
+	out := new(serverListResponse)
+	err := c.do(ctx, fmt.Sprintf(pathServers, c.addr)+"?per_page=1", http.MethodGet, nil, out)
+	return out.Servers, err
+}
+
+func (c *client) ServerDelete(ctx context.Context, id int64) (*action, error) {
+	out := new(actionResponse)
+	err := c.do(ctx, fmt.Sprintf(pathServer, c.addr, id), http.MethodDelete, nil, out)
+	return out.Action, err
+}
+
+func (c *client) ServerUpdateLabels(ctx context.Context, id int64, labels map[string]string) (*server, error) {
+	out := new(serverResponse)
+	in := &updateServerRequest{Labels: labels}
+	err := c.do(ctx, fmt.Sprintf(pathServer, c.addr, id), http.MethodPut, in, out)
+	return out.Server, err
+}
+
+func (c *client) ServerAction(ctx context.Context, id int64, name string) (*action, error) {
+	out := new(actionResponse)
+	err := c.do(ctx, fmt.Sprintf(pathServerAction, c.addr, id, name), http.MethodPost, nil, out)
+	return out.Action, err
+}
+
+func (c *client) ActionGet(ctx context.Context, id int64) (*action, error) {
+	out := new(actionResponse)
+	err := c.do(ctx, fmt.Sprintf(pathAction, c.addr, id), http.MethodGet, nil, out)
+	return out.Action, err
+}
+
+func (c *client) do(ctx context.Context, uri, method string, in, out interface{}) error {
+	var body io.Reader = http.NoBody
+	var length int
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+		length = len(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		req.Header.Set("Content-Length", strconv.Itoa(length))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 { //nolint:mnd
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		envelope := struct {
+			Error *apiError `json:"error"`
+		}{Error: apiErr}
+		raw, _ := io.ReadAll(resp.Body)
+		if jsonErr := json.Unmarshal(raw, &envelope); jsonErr != nil || apiErr.Code == "" {
+			apiErr.Message = string(raw)
+		}
+		return apiErr
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
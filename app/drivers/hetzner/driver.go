@@ -0,0 +1,480 @@
+package hetzner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/lehelper"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/dchest/uniuri"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	maxLabelLength      = 63
+	poolLabel           = "pool"
+)
+
+var _ drivers.Driver = (*config)(nil)
+
+// invalidLabelChars matches characters that Hetzner does not accept in label values.
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_.\-]`)
+
+// config is a struct that implements drivers.Pool interface
+type config struct {
+	token      string
+	endpoint   string
+	location   string
+	serverType string
+	image      string
+	labels     map[string]string
+	sshKeys    []string
+	firewalls  []int64
+	networks   []int64
+	privateIP  bool
+	userData   string
+	rootDir    string
+	hibernate  bool
+
+	pollInterval time.Duration
+	client       Client
+}
+
+func New(opts ...Option) (drivers.Driver, error) {
+	p := new(config)
+	p.pollInterval = defaultPollInterval
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.token == "" {
+		return nil, fmt.Errorf("hetzner: api token is required")
+	}
+	if p.client == nil {
+		p.client = NewClient(p.endpoint, p.token)
+	}
+	return p, nil
+}
+
+func (p *config) DriverName() string {
+	return string(types.Hetzner)
+}
+
+func (p *config) InstanceType() string {
+	return p.image
+}
+
+func (p *config) RootDir() string {
+	return p.rootDir
+}
+
+func (p *config) CanHibernate() bool {
+	return p.hibernate
+}
+
+func (p *config) GetFullyQualifiedImage(_ context.Context, config *types.VMImageConfig) (string, error) {
+	// If no image name is provided, return the default image
+	if config.ImageName == "" {
+		return p.image, nil
+	}
+
+	// Hetzner images are referenced either by name (e.g. "ubuntu-22.04")
+	// or by numeric ID for snapshots, both of which are accepted verbatim.
+	return config.ImageName, nil
+}
+
+func (p *config) Ping(ctx context.Context) error {
+	_, err := p.client.ServerList(ctx)
+	return err
+}
+
+// ReserveCapacity reserves capacity for a VM
+func (p *config) ReserveCapacity(ctx context.Context, opts *types.InstanceCreateOpts) (*types.CapacityReservation, error) {
+	return nil, &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// DestroyCapacity destroys capacity for a VM
+func (p *config) DestroyCapacity(ctx context.Context, capacity *types.CapacityReservation) (err error) {
+	return &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// Create a Hetzner Cloud server for the pool, it will not perform build specific setup.
+func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
+	startTime := time.Now()
+
+	location := p.location
+	if len(opts.Zones) > 0 && opts.Zones[0] != "" {
+		location = opts.Zones[0]
+	}
+	serverType := p.serverType
+	if opts.MachineType != "" {
+		serverType = opts.MachineType
+	}
+	image, err := p.GetFullyQualifiedImage(ctx, &opts.VMImageConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image: %w", err)
+	}
+
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.Hetzner).
+		WithField("pool", opts.PoolName).
+		WithField("image", image).
+		WithField("location", location).
+		WithField("server_type", serverType).
+		WithField("hibernate", p.CanHibernate())
+	var name = fmt.Sprintf("%s-%s-%s", opts.RunnerName, opts.PoolName, uniuri.NewLen(8)) //nolint:mnd
+	name = strings.ToLower(name)
+	logr.Infof("hetzner: creating instance %s", name)
+
+	userData, err := lehelper.GenerateUserdata(p.userData, opts)
+	if err != nil {
+		logr.WithError(err).
+			Errorln("hetzner: failed to generate user data")
+		return nil, err
+	}
+
+	req := &createServerRequest{
+		Name:             name,
+		ServerType:       serverType,
+		Image:            image,
+		Location:         location,
+		UserData:         userData,
+		Labels:           p.buildLabels(opts),
+		SSHKeys:          p.sshKeys,
+		Networks:         p.networks,
+		StartAfterCreate: true,
+	}
+	for _, id := range p.firewalls {
+		req.Firewalls = append(req.Firewalls, serverFirewall{Firewall: id})
+	}
+	if p.privateIP {
+		req.PublicNet = &serverPublicNet{EnableIPv4: false, EnableIPv6: false}
+	}
+
+	resp, err := p.client.ServerCreate(ctx, req)
+	if err != nil {
+		logr.WithError(err).
+			Errorln("hetzner: cannot create instance")
+		return nil, err
+	}
+	if resp.Server == nil {
+		return nil, fmt.Errorf("hetzner: empty server in create response for %s", name)
+	}
+	serverID := resp.Server.ID
+
+	if resp.Action != nil {
+		if err = p.waitAction(ctx, resp.Action); err != nil {
+			logr.WithError(err).
+				Errorln("hetzner: create server action failed")
+			p.cleanupFailedInstance(ctx, serverID, logr)
+			return nil, err
+		}
+	}
+
+	srv, err := p.waitForAddress(ctx, serverID)
+	if err != nil {
+		logr.WithError(err).
+			Errorln("hetzner: cannot ascertain instance network")
+		p.cleanupFailedInstance(ctx, serverID, logr)
+		return nil, err
+	}
+	logr.Infof("hetzner: instance created %s", name)
+
+	labelsBytes, err := json.Marshal(opts.InternalLabels)
+	if err != nil {
+		return nil, fmt.Errorf("scheduler: could not marshal labels: %v, err: %w", opts.InternalLabels, err)
+	}
+
+	return &types.Instance{
+		ID:                strconv.FormatInt(serverID, 10),
+		Name:              name,
+		Provider:          types.Hetzner,
+		State:             types.StateProvisioning,
+		Pool:              opts.PoolName,
+		Region:            srv.Datacenter.Location.NetworkZone,
+		Zone:              location,
+		Image:             image,
+		Size:              serverType,
+		Platform:          opts.Platform,
+		Address:           p.serverIP(srv),
+		CAKey:             opts.CAKey,
+		CACert:            opts.CACert,
+		TLSKey:            opts.TLSKey,
+		TLSCert:           opts.TLSCert,
+		Started:           startTime.Unix(),
+		Updated:           time.Now().Unix(),
+		IsHibernated:      false,
+		Port:              lehelper.LiteEnginePort,
+		StorageIdentifier: opts.StorageOpts.Identifier,
+		Labels:            labelsBytes,
+		ProxyURL:          opts.EgressProxyURL,
+	}, nil
+}
+
+func (p *config) Destroy(ctx context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+	return p.DestroyInstanceAndStorage(ctx, instances, nil)
+}
+
+// DestroyInstanceAndStorage deletes the Hetzner Cloud servers. Hetzner root
+// volumes are deleted together with the server so the cleanup type is ignored.
+func (p *config) DestroyInstanceAndStorage(ctx context.Context, instances []*types.Instance, _ *storage.CleanupType) ([]*types.Instance, error) {
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instance ids provided")
+	}
+
+	var failed []*types.Instance
+	var firstErr error
+	for _, instance := range instances {
+		logr := logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.Hetzner)
+
+		id, err := parseServerID(instance.ID)
+		if err != nil {
+			logr.WithError(err).Errorln("hetzner: invalid server id")
+			failed = append(failed, instance)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		logr.Debugln("hetzner: deleting server")
+		if _, err = p.client.ServerDelete(ctx, id); err != nil {
+			if isNotFound(err) {
+				logr.Warnln("hetzner: server does not exist, skipping")
+				continue
+			}
+			logr.WithError(err).Errorln("hetzner: deleting server failed")
+			failed = append(failed, instance)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		logr.Debugln("hetzner: server deleted")
+	}
+	return failed, firstErr
+}
+
+// Logs returns a short status summary. Hetzner Cloud does not expose the
+// serial console output through its API.
+func (p *config) Logs(ctx context.Context, instanceID string) (string, error) {
+	id, err := parseServerID(instanceID)
+	if err != nil {
+		return "", err
+	}
+	srv, err := p.client.ServerGet(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("hetzner: server %s (%d) is %s; console logs are not available via the API", srv.Name, srv.ID, srv.Status), nil
+}
+
+// Hibernate powers the server off. Hetzner keeps the disk and addresses of a
+// stopped server so it can be powered back on by Start.
+func (p *config) Hibernate(ctx context.Context, instanceID, _, _ string) error {
+	logr := logger.FromContext(ctx).
+		WithField("id", instanceID).
+		WithField("driver", types.Hetzner)
+
+	id, err := parseServerID(instanceID)
+	if err != nil {
+		return err
+	}
+	act, err := p.client.ServerAction(ctx, id, actionPowerOff)
+	if err != nil {
+		logr.WithError(err).Errorln("hetzner: failed to power off server")
+		return err
+	}
+	if err = p.waitAction(ctx, act); err != nil {
+		logr.WithError(err).Errorln("hetzner: power off action failed")
+		return err
+	}
+	return nil
+}
+
+// Start powers a hibernated server back on and returns its address.
+func (p *config) Start(ctx context.Context, instance *types.Instance, _ string) (string, error) {
+	logr := logger.FromContext(ctx).
+		WithField("id", instance.ID).
+		WithField("driver", types.Hetzner)
+
+	id, err := parseServerID(instance.ID)
+	if err != nil {
+		return "", err
+	}
+	srv, err := p.client.ServerGet(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if srv.Status != serverStatusOff {
+		return p.serverIP(srv), nil
+	}
+
+	act, err := p.client.ServerAction(ctx, id, actionPowerOn)
+	if err != nil {
+		logr.WithError(err).Errorln("hetzner: failed to power on server")
+		return "", err
+	}
+	if err = p.waitAction(ctx, act); err != nil {
+		logr.WithError(err).Errorln("hetzner: power on action failed")
+		return "", err
+	}
+	srv, err = p.waitForAddress(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return p.serverIP(srv), nil
+}
+
+// SetTags stores the tags as server labels, Hetzner has no separate metadata store.
+func (p *config) SetTags(ctx context.Context, instance *types.Instance, tags map[string]string) error {
+	return p.SetLabels(ctx, instance, tags)
+}
+
+// SetLabels overlays the supplied labels onto the server's existing labels.
+func (p *config) SetLabels(ctx context.Context, instance *types.Instance, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	id, err := parseServerID(instance.ID)
+	if err != nil {
+		return err
+	}
+	srv, err := p.client.ServerGet(ctx, id)
+	if err != nil {
+		return err
+	}
+	merged := make(map[string]string, len(srv.Labels)+len(labels))
+	for k, v := range srv.Labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = sanitizeLabelValue(v)
+	}
+	_, err = p.client.ServerUpdateLabels(ctx, id, merged)
+	if err != nil {
+		logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.Hetzner).
+			WithError(err).
+			Errorln("hetzner: failed to set server labels")
+	}
+	return err
+}
+
+// waitAction polls an action until it completes.
+func (p *config) waitAction(ctx context.Context, act *action) error {
+	if act == nil {
+		return nil
+	}
+	for {
+		switch act.Status {
+		case actionStatusSuccess:
+			return nil
+		case actionStatusError:
+			if act.Error != nil {
+				return fmt.Errorf("hetzner: action %s failed: %s: %s", act.Command, act.Error.Code, act.Error.Message)
+			}
+			return fmt.Errorf("hetzner: action %s failed", act.Command)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.pollInterval):
+		}
+		next, err := p.client.ActionGet(ctx, act.ID)
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			return fmt.Errorf("hetzner: empty action response for %d", act.ID)
+		}
+		act = next
+	}
+}
+
+// waitForAddress polls the server until it is running with a network address allocated.
+func (p *config) waitForAddress(ctx context.Context, id int64) (*server, error) {
+	interval := time.Duration(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+			interval = p.pollInterval
+			srv, err := p.client.ServerGet(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if srv.Status == serverStatusRunning && p.serverIP(srv) != "" {
+				return srv, nil
+			}
+		}
+	}
+}
+
+func (p *config) cleanupFailedInstance(ctx context.Context, id int64, logr logger.Logger) {
+	if _, err := p.client.ServerDelete(ctx, id); err != nil && !isNotFound(err) {
+		logr.WithError(err).
+			Warnln("hetzner: failed to delete server after failed create")
+	}
+}
+
+func (p *config) serverIP(srv *server) string {
+	if p.privateIP {
+		if len(srv.PrivateNet) > 0 {
+			return srv.PrivateNet[0].IP
+		}
+		return ""
+	}
+	if srv.PublicNet.IPv4 != nil {
+		return srv.PublicNet.IPv4.IP
+	}
+	return ""
+}
+
+// buildLabels merges the pool labels with the VM labels from the create options.
+func (p *config) buildLabels(opts *types.InstanceCreateOpts) map[string]string {
+	labels := make(map[string]string, len(p.labels)+len(opts.VMLabels)+1)
+	for k, v := range p.labels {
+		labels[k] = sanitizeLabelValue(v)
+	}
+	labels[poolLabel] = sanitizeLabelValue(opts.PoolName)
+	if opts.GitspaceOpts.GitspaceConfigIdentifier != "" {
+		labels["name"] = sanitizeLabelValue(opts.GitspaceOpts.GitspaceConfigIdentifier)
+	}
+	for k, v := range opts.VMLabels {
+		labels[k] = sanitizeLabelValue(v)
+	}
+	return labels
+}
+
+// sanitizeLabelValue coerces a value into Hetzner's label value format: at most
+// 63 characters of [a-zA-Z0-9_.-], starting and ending with an alphanumeric.
+func sanitizeLabelValue(v string) string {
+	v = invalidLabelChars.ReplaceAllString(v, "_")
+	if len(v) > maxLabelLength {
+		v = v[:maxLabelLength]
+	}
+	return strings.Trim(v, "_.-")
+}
+
+func parseServerID(id string) (int64, error) {
+	serverID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("hetzner: invalid server id %q: %w", id, err)
+	}
+	return serverID, nil
+}
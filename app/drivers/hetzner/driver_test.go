@@ -0,0 +1,377 @@
+package hetzner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/drivertest"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// fakeHetzner emulates the subset of the Hetzner Cloud API used by the driver:
+// server create/get/list/update/delete, power actions and action polling.
+type fakeHetzner struct {
+	mu       sync.Mutex
+	nextID   int64
+	servers  map[int64]map[string]any
+	creates  []createServerRequest
+	events   []string
+	failPoll bool // create action reports an error
+	token    string
+}
+
+func newFakeHetzner() *fakeHetzner {
+	return &fakeHetzner{nextID: 100, servers: map[int64]map[string]any{}}
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func notFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]any{"error": map[string]any{"code": "not_found", "message": "server not found"}})
+}
+
+func (f *fakeHetzner) newServer(id int64, name, status string, labels map[string]string) map[string]any {
+	return map[string]any{
+		"id":     id,
+		"name":   name,
+		"status": status,
+		"labels": labels,
+		"public_net": map[string]any{
+			"ipv4": map[string]any{"ip": "10.0.0." + strconv.FormatInt(id%250, 10)},
+		},
+		"private_net": []map[string]any{{"network": 1, "ip": "192.168.0." + strconv.FormatInt(id%250, 10)}},
+		"datacenter": map[string]any{
+			"name":     "fsn1-dc14",
+			"location": map[string]any{"name": "fsn1", "network_zone": "eu-central"},
+		},
+	}
+}
+
+func (f *fakeHetzner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.token = r.Header.Get("Authorization")
+	path := strings.TrimPrefix(r.URL.Path, "/v1")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodPost && path == "/servers":
+		var in createServerRequest
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		f.creates = append(f.creates, in)
+		f.nextID++
+		id := f.nextID
+		f.servers[id] = f.newServer(id, in.Name, "initializing", in.Labels)
+		f.events = append(f.events, "create:"+in.Name)
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"server": f.servers[id],
+			"action": map[string]any{"id": id, "command": "create_server", "status": "running"},
+		})
+
+	case r.Method == http.MethodGet && path == "/servers":
+		writeJSON(w, http.StatusOK, map[string]any{"servers": []any{}})
+
+	case parts[0] == "actions" && len(parts) == 2 && r.Method == http.MethodGet:
+		id, _ := strconv.ParseInt(parts[1], 10, 64)
+		if f.failPoll {
+			writeJSON(w, http.StatusOK, map[string]any{"action": map[string]any{
+				"id": id, "command": "create_server", "status": "error",
+				"error": map[string]any{"code": "resource_unavailable", "message": "no capacity"},
+			}})
+			return
+		}
+		// the server is ready once its create action has completed
+		if srv, ok := f.servers[id]; ok && srv["status"] == "initializing" {
+			srv["status"] = "running"
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"action": map[string]any{"id": id, "status": "success"}})
+
+	case parts[0] == "servers" && len(parts) >= 2:
+		id, _ := strconv.ParseInt(parts[1], 10, 64)
+		srv, ok := f.servers[id]
+		if !ok {
+			notFound(w)
+			return
+		}
+		switch {
+		case len(parts) == 4 && parts[2] == "actions" && r.Method == http.MethodPost:
+			f.events = append(f.events, parts[3]+":"+parts[1])
+			switch parts[3] {
+			case actionPowerOff:
+				srv["status"] = "off"
+			case actionPowerOn:
+				srv["status"] = "running"
+			}
+			writeJSON(w, http.StatusCreated, map[string]any{"action": map[string]any{"id": id, "command": parts[3], "status": "success"}})
+		case r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]any{"server": srv})
+		case r.Method == http.MethodPut:
+			var in updateServerRequest
+			_ = json.NewDecoder(r.Body).Decode(&in)
+			srv["labels"] = in.Labels
+			writeJSON(w, http.StatusOK, map[string]any{"server": srv})
+		case r.Method == http.MethodDelete:
+			delete(f.servers, id)
+			f.events = append(f.events, "delete:"+parts[1])
+			writeJSON(w, http.StatusOK, map[string]any{"action": map[string]any{"id": id, "command": "delete_server", "status": "success"}})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newFakeConfig(t *testing.T, f *fakeHetzner, opts ...Option) *config {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	opts = append([]Option{
+		WithToken("secret"),
+		WithEndpoint(srv.URL + "/v1"),
+		WithLocation(""),
+		WithServerType(""),
+		WithImage(""),
+	}, opts...)
+	d, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	p := d.(*config)
+	p.pollInterval = time.Millisecond
+	return p
+}
+
+func TestNew_RequiresToken(t *testing.T) {
+	if _, err := New(); err == nil {
+		t.Fatal("expected error without token")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	f := newFakeHetzner()
+	p := newFakeConfig(t, f, WithLabels(map[string]string{"team": "ci"}), WithFirewalls([]int64{7}))
+
+	instance, err := p.Create(context.Background(), &types.InstanceCreateOpts{
+		PoolName:   "linux-pool",
+		RunnerName: "Runner",
+		Platform:   types.Platform{OS: "linux", Arch: "amd64"},
+		VMLabels:   map[string]string{"account": "Acc/123"},
+		CACert:     []byte("ca"),
+		TLSCert:    []byte("cert"),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if f.token != "Bearer secret" {
+		t.Errorf("authorization header = %q", f.token)
+	}
+	if instance.Provider != types.Hetzner || instance.ID != "101" {
+		t.Errorf("unexpected instance %+v", instance)
+	}
+	if instance.Address != "10.0.0.101" {
+		t.Errorf("address = %q, want public ip", instance.Address)
+	}
+	if instance.Zone != "fsn1" || instance.Region != "eu-central" || instance.Size != "cx22" || instance.Image != "ubuntu-22.04" {
+		t.Errorf("unexpected placement %+v", instance)
+	}
+	if len(f.creates) != 1 {
+		t.Fatalf("expected one create, got %d", len(f.creates))
+	}
+	req := f.creates[0]
+	if !strings.HasPrefix(req.Name, "runner-linux-pool-") {
+		t.Errorf("name = %q", req.Name)
+	}
+	if req.UserData == "" {
+		t.Error("expected user data to be set")
+	}
+	if req.Labels["team"] != "ci" || req.Labels["pool"] != "linux-pool" || req.Labels["account"] != "Acc_123" {
+		t.Errorf("labels = %v", req.Labels)
+	}
+	if len(req.Firewalls) != 1 || req.Firewalls[0].Firewall != 7 {
+		t.Errorf("firewalls = %v", req.Firewalls)
+	}
+}
+
+func TestCreate_OverridesFromOpts(t *testing.T) {
+	f := newFakeHetzner()
+	p := newFakeConfig(t, f, WithPrivateIP(true))
+
+	instance, err := p.Create(context.Background(), &types.InstanceCreateOpts{
+		PoolName:      "pool",
+		RunnerName:    "runner",
+		Zones:         []string{"hel1"},
+		MachineType:   "cax21",
+		VMImageConfig: types.VMImageConfig{ImageName: "snapshot-1"},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	req := f.creates[0]
+	if req.Location != "hel1" || req.ServerType != "cax21" || req.Image != "snapshot-1" {
+		t.Errorf("unexpected request %+v", req)
+	}
+	if req.PublicNet == nil || req.PublicNet.EnableIPv4 {
+		t.Errorf("expected public network to be disabled, got %+v", req.PublicNet)
+	}
+	if instance.Address != "192.168.0.101" {
+		t.Errorf("address = %q, want private ip", instance.Address)
+	}
+}
+
+func TestCreate_ActionFailureDeletesServer(t *testing.T) {
+	f := newFakeHetzner()
+	f.failPoll = true
+	p := newFakeConfig(t, f)
+
+	_, err := p.Create(context.Background(), &types.InstanceCreateOpts{PoolName: "pool", RunnerName: "runner"})
+	if err == nil || !strings.Contains(err.Error(), "no capacity") {
+		t.Fatalf("expected action error, got %v", err)
+	}
+	if len(f.servers) != 0 {
+		t.Errorf("expected failed server to be deleted, got %d servers", len(f.servers))
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	f := newFakeHetzner()
+	p := newFakeConfig(t, f)
+	f.servers[1] = f.newServer(1, "a", "running", nil)
+
+	failed, err := p.Destroy(context.Background(), []*types.Instance{{ID: "1"}, {ID: "2"}, {ID: "bogus"}})
+	if err == nil {
+		t.Fatal("expected error for invalid id")
+	}
+	if len(failed) != 1 || failed[0].ID != "bogus" {
+		t.Errorf("failed = %v, want only the invalid id", failed)
+	}
+	if _, ok := f.servers[1]; ok {
+		t.Error("expected server 1 to be deleted")
+	}
+
+	if _, err := p.Destroy(context.Background(), nil); err == nil {
+		t.Error("expected error for empty instance list")
+	}
+}
+
+func TestHibernateAndStart(t *testing.T) {
+	f := newFakeHetzner()
+	p := newFakeConfig(t, f, WithHibernate(true))
+	f.servers[5] = f.newServer(5, "a", "running", nil)
+
+	if !p.CanHibernate() {
+		t.Fatal("expected hibernate to be enabled")
+	}
+	if err := p.Hibernate(context.Background(), "5", "pool", ""); err != nil {
+		t.Fatalf("Hibernate: %v", err)
+	}
+	if f.servers[5]["status"] != "off" {
+		t.Fatalf("status = %v, want off", f.servers[5]["status"])
+	}
+
+	ip, err := p.Start(context.Background(), &types.Instance{ID: "5"}, "pool")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if ip != "10.0.0.5" {
+		t.Errorf("ip = %q", ip)
+	}
+
+	// starting a running server is a no-op that returns its address
+	if _, err = p.Start(context.Background(), &types.Instance{ID: "5"}, "pool"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	want := []string{"poweroff:5", "poweron:5"}
+	if strings.Join(f.events, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", f.events, want)
+	}
+}
+
+func TestSetLabels_MergesExisting(t *testing.T) {
+	f := newFakeHetzner()
+	p := newFakeConfig(t, f)
+	f.servers[9] = f.newServer(9, "a", "running", map[string]string{"pool": "p"})
+
+	if err := p.SetTags(context.Background(), &types.Instance{ID: "9"}, map[string]string{"stage": "build #1"}); err != nil {
+		t.Fatalf("SetTags: %v", err)
+	}
+	labels := f.servers[9]["labels"].(map[string]string)
+	if labels["pool"] != "p" || labels["stage"] != "build__1" {
+		t.Errorf("labels = %v", labels)
+	}
+}
+
+func TestPingAndLogs(t *testing.T) {
+	f := newFakeHetzner()
+	p := newFakeConfig(t, f)
+	f.servers[3] = f.newServer(3, "vm", "running", nil)
+
+	if err := p.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	logs, err := p.Logs(context.Background(), "3")
+	if err != nil {
+		t.Fatalf("Logs: %v", err)
+	}
+	if !strings.Contains(logs, "running") {
+		t.Errorf("logs = %q", logs)
+	}
+	if _, err := p.Logs(context.Background(), "4"); !isNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	tests := map[string]string{
+		"simple":                "simple",
+		"with space":            "with_space",
+		"-leading":              "leading",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+	}
+	for in, want := range tests {
+		if got := sanitizeLabelValue(in); got != want {
+			t.Errorf("sanitizeLabelValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func (f *fakeHetzner) Exists(instanceID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, _ := strconv.ParseInt(instanceID, 10, 64)
+	_, ok := f.servers[id]
+	return ok
+}
+
+func (f *fakeHetzner) Labels(instanceID string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, _ := strconv.ParseInt(instanceID, 10, 64)
+	if srv, ok := f.servers[id]; ok {
+		labels, _ := srv["labels"].(map[string]string)
+		return labels
+	}
+	return nil
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, drivertest.Config{
+		NewDriver: func(t *testing.T) (drivers.Driver, drivertest.Backend) {
+			f := newFakeHetzner()
+			return newFakeConfig(t, f, WithHibernate(true)), f
+		},
+	})
+}
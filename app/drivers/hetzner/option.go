@@ -0,0 +1,144 @@
+package hetzner
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/app/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+type Option func(*config)
+
+func SetPlatformDefaults(platform *types.Platform) (*types.Platform, error) {
+	if platform.Arch == "" {
+		platform.Arch = oshelp.ArchAMD64
+	}
+	if platform.Arch != oshelp.ArchAMD64 && platform.Arch != oshelp.ArchARM64 {
+		return platform, fmt.Errorf("invalid arch %s, has to be '%s/%s'", platform.Arch, oshelp.ArchAMD64, oshelp.ArchARM64)
+	}
+	// verify that we are using sane values for OS
+	if platform.OS == "" {
+		platform.OS = oshelp.OSLinux
+	}
+	if platform.OS != oshelp.OSLinux {
+		return platform, fmt.Errorf("hetzner - invalid OS %s, has to be '%s'", platform.OS, oshelp.OSLinux)
+	}
+	// set osname
+	if platform.OSName == "" {
+		platform.OSName = oshelp.Ubuntu
+	}
+	return platform, nil
+}
+
+func WithToken(token string) Option {
+	return func(p *config) {
+		p.token = token
+	}
+}
+
+// WithEndpoint overrides the Hetzner Cloud API endpoint.
+func WithEndpoint(endpoint string) Option {
+	return func(p *config) {
+		p.endpoint = endpoint
+	}
+}
+
+func WithLocation(location string) Option {
+	return func(p *config) {
+		if location == "" {
+			p.location = "fsn1"
+		} else {
+			p.location = location
+		}
+	}
+}
+
+func WithServerType(serverType string) Option {
+	return func(p *config) {
+		if serverType == "" {
+			p.serverType = "cx22"
+		} else {
+			p.serverType = serverType
+		}
+	}
+}
+
+func WithImage(image string) Option {
+	return func(p *config) {
+		if image == "" {
+			p.image = "ubuntu-22.04"
+		} else {
+			p.image = image
+		}
+	}
+}
+
+func WithLabels(labels map[string]string) Option {
+	return func(p *config) {
+		p.labels = labels
+	}
+}
+
+func WithSSHKeys(sshKeys []string) Option {
+	return func(p *config) {
+		p.sshKeys = sshKeys
+	}
+}
+
+func WithFirewalls(firewalls []int64) Option {
+	return func(p *config) {
+		p.firewalls = firewalls
+	}
+}
+
+func WithNetworks(networks []int64) Option {
+	return func(p *config) {
+		p.networks = networks
+	}
+}
+
+// WithPrivateIP disables the public network and connects over the first private network.
+func WithPrivateIP(privateIP bool) Option {
+	return func(p *config) {
+		p.privateIP = privateIP
+	}
+}
+
+func WithHibernate(hibernate bool) Option {
+	return func(p *config) {
+		p.hibernate = hibernate
+	}
+}
+
+func WithUserData(text, path string) Option {
+	if text != "" {
+		return func(p *config) {
+			p.userData = text
+		}
+	}
+	return func(p *config) {
+		if path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logrus.WithError(err).
+					Fatalln("failed to read user_data file")
+				return
+			}
+			p.userData = string(data)
+		}
+	}
+}
+
+// WithRootDirectory sets the root directory for the virtual machine.
+func WithRootDirectory(dir string) Option {
+	return func(p *config) {
+		if dir == "" {
+			p.rootDir = oshelp.JoinPaths(oshelp.OSLinux, "/tmp", "hetzner")
+		} else {
+			p.rootDir = dir
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package drivers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Free instance states the hibernation policy chooses between, and the outcomes of moving an
+// instance to its chosen state. Bounded set, used as metric labels.
+const (
+	HibernationStateRunning    = "running"
+	HibernationStateHibernated = "hibernated"
+	HibernationStateDestroyed  = "destroyed"
+
+	HibernationOutcomeApplied = "applied"
+	HibernationOutcomeFailed  = "failed"
+	HibernationOutcomeDryRun  = "dry_run"
+)
+
+// HibernationPolicy chooses, for the free instances of a pool, which keep
+// running, which are hibernated and which are destroyed.
+type HibernationPolicy struct {
+	// CostWeight is how much an idle running VM weighs against a stage waiting
+	// for a VM to resume, from 0 (keep the predicted demand running) to 1
+	// (hibernate everything).
+	CostWeight float64
+	// Window is how far ahead the demand is predicted.
+	Window time.Duration
+}
+
+// HibernationTarget is how many free instances are kept running and
+// hibernated, the others are destroyed.
+type HibernationTarget struct {
+	Running    int
+	Hibernated int
+}
+
+// HibernationDecision is the state the policy chose for a free instance.
+type HibernationDecision struct {
+	Instance *types.Instance
+	State    string
+}
+
+// Target returns how many free instances to keep running and hibernated for
+// the demand predicted over the window, keeping keep instances in all and at
+// least the demand.
+//
+// Stages spread over the window, the k-th of them arrives after k/(demand+1)
+// of it on average, so the instance kept running for it idles that long. It is
+// kept running when the resume latency it saves the stage outweighs that idle
+// time, (1-CostWeight)*resumeLatency >= CostWeight*idle: busy windows keep
+// more of their demand running than quiet ones.
+func (p HibernationPolicy) Target(demand, keep int, resumeLatency time.Duration) HibernationTarget {
+	if demand < 0 {
+		demand = 0
+	}
+	if keep < demand {
+		keep = demand
+	}
+	running := demand
+	switch {
+	case p.CostWeight >= 1:
+		running = 0
+	case p.CostWeight > 0 && p.Window > 0:
+		worthRunning := int((1 - p.CostWeight) * resumeLatency.Seconds() * float64(demand+1) / (p.CostWeight * p.Window.Seconds()))
+		if worthRunning < running {
+			running = worthRunning
+		}
+	}
+	return HibernationTarget{Running: running, Hibernated: keep - running}
+}
+
+// Decide assigns the target to free instances, moving as few of them as
+// possible: running instances stay running and hibernated instances stay
+// hibernated as far as the target allows. Only instances the scaler created
+// are destroyed, the others are hibernated instead.
+func (t HibernationTarget) Decide(free []*types.Instance) []*HibernationDecision {
+	var running, hibernated []*types.Instance
+	for _, inst := range free {
+		if inst.IsHibernated {
+			hibernated = append(hibernated, inst)
+		} else {
+			running = append(running, inst)
+		}
+	}
+	// instances that cannot be destroyed take the slots first
+	for _, instances := range [][]*types.Instance{running, hibernated} {
+		sort.SliceStable(instances, func(i, j int) bool {
+			return !hibernationDestroyable(instances[i]) && hibernationDestroyable(instances[j])
+		})
+	}
+
+	runSlots, hibernateSlots := t.Running, t.Hibernated
+	decisions := make([]*HibernationDecision, 0, len(free))
+	var moveRunning, moveHibernated []*types.Instance
+	for _, inst := range running {
+		if runSlots > 0 {
+			runSlots--
+			decisions = append(decisions, &HibernationDecision{Instance: inst, State: HibernationStateRunning})
+		} else {
+			moveRunning = append(moveRunning, inst)
+		}
+	}
+	for _, inst := range hibernated {
+		if hibernateSlots > 0 {
+			hibernateSlots--
+			decisions = append(decisions, &HibernationDecision{Instance: inst, State: HibernationStateHibernated})
+		} else {
+			moveHibernated = append(moveHibernated, inst)
+		}
+	}
+	for _, inst := range moveRunning {
+		state := HibernationStateDestroyed
+		if hibernateSlots > 0 || !hibernationDestroyable(inst) {
+			hibernateSlots--
+			state = HibernationStateHibernated
+		}
+		decisions = append(decisions, &HibernationDecision{Instance: inst, State: state})
+	}
+	for _, inst := range moveHibernated {
+		state := HibernationStateDestroyed
+		switch {
+		case runSlots > 0:
+			runSlots--
+			state = HibernationStateRunning
+		case !hibernationDestroyable(inst):
+			state = HibernationStateHibernated
+		}
+		decisions = append(decisions, &HibernationDecision{Instance: inst, State: state})
+	}
+	return decisions
+}
+
+// hibernationDestroyable reports whether the policy may destroy a free
+// instance. Like the scale down of the scaler, only the instances the scaler
+// created are, the pool replaces the others.
+func hibernationDestroyable(inst *types.Instance) bool {
+	return inst.Source == types.InstanceSourcePredictor
+}
+
+// hibernationState returns the state of a free instance, as chosen by the policy.
+func hibernationState(inst *types.Instance) string {
+	if inst.IsHibernated {
+		return HibernationStateHibernated
+	}
+	return HibernationStateRunning
+}
+
+// CanHibernate reports whether the driver of a pool tenant can hibernate.
+func (m *Manager) CanHibernate(poolName, tenantID string) bool {
+	pool := m.poolMap[poolName]
+	if pool == nil {
+		return false
+	}
+	return pool.DriverForTenant(tenantID).CanHibernate()
+}
+
+// ApplyHibernationDecisions moves the free instances of a pool to the state
+// the policy chose for them, unless dryRun is set. Instances claimed by a
+// stage in the meantime are left alone.
+func (d *DistributedManager) ApplyHibernationDecisions(ctx context.Context, poolName string, decisions []*HibernationDecision, dryRun bool) error {
+	pool := d.poolMap[poolName]
+	if pool == nil {
+		return fmt.Errorf("hibernation policy: pool name %q not found", poolName)
+	}
+
+	var errs []error
+	for _, decision := range decisions {
+		inst := decision.Instance
+		if decision.State == hibernationState(inst) {
+			continue
+		}
+		logr := logger.FromContext(ctx).
+			WithField("pool", poolName).
+			WithField("instance_id", inst.ID).
+			WithField("from", hibernationState(inst)).
+			WithField("to", decision.State)
+		if dryRun {
+			logr.Infoln("hibernation policy: dry run, would move instance")
+			d.recordHibernationTransition(poolName, decision.State, HibernationOutcomeDryRun)
+			continue
+		}
+
+		var err error
+		switch decision.State {
+		case HibernationStateRunning:
+			err = d.resumeFree(ctx, pool, inst)
+		case HibernationStateHibernated:
+			err = d.hibernate(ctx, poolName, inst, true)
+		case HibernationStateDestroyed:
+			err = d.destroyFree(ctx, pool, inst)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			logr.Debugln("hibernation policy: instance is no longer free")
+			continue
+		}
+		if err != nil {
+			logr.WithError(err).Errorln("hibernation policy: failed to move instance")
+			d.recordHibernationTransition(poolName, decision.State, HibernationOutcomeFailed)
+			errs = append(errs, err)
+			continue
+		}
+		logr.Infoln("hibernation policy: moved instance")
+		d.recordHibernationTransition(poolName, decision.State, HibernationOutcomeApplied)
+	}
+	return errors.Join(errs...)
+}
+
+// resumeFree wakes a free hibernated instance up, so the next stage does not
+// wait for it. The instance is held in StateHibernating while it resumes so
+// no stage claims it half way.
+func (d *DistributedManager) resumeFree(ctx context.Context, pool *poolEntry, inst *types.Instance) error {
+	claimed, err := d.instanceStore.FindAndClaim(ctx,
+		&types.QueryParams{PoolName: pool.Name, InstanceID: inst.ID, GPU: inst.GPU},
+		types.StateHibernating, []types.InstanceState{types.StateCreated}, false)
+	if err != nil {
+		return fmt.Errorf("resume: failed to claim instance %s of %q pool: %w", inst.ID, pool.Name, err)
+	}
+
+	ipAddress, startErr := d.startInstanceWithMetrics(ctx, pool.DriverForTenant(claimed.TenantID), pool.Name, claimed)
+	claimed.State = types.StateCreated
+	if startErr != nil {
+		d.recordResumeAttempt(ctx, pool.Name, claimed, startErr)
+		if updateErr := d.instanceStore.Update(ctx, claimed); updateErr != nil {
+			return fmt.Errorf("resume: failed to release instance %s of %q pool: %w", claimed.ID, pool.Name, updateErr)
+		}
+		return fmt.Errorf("resume: failed to start instance %s of %q pool: %w", claimed.ID, pool.Name, startErr)
+	}
+
+	claimed.IsHibernated = false
+	claimed.Address = ipAddress
+	if err := d.instanceStore.Update(ctx, claimed); err != nil {
+		d.recordResumeAttempt(ctx, pool.Name, claimed, &lifecycleStageError{stage: lifecycleStageState, err: err})
+		return fmt.Errorf("resume: failed to update instance %s of %q pool: %w", claimed.ID, pool.Name, err)
+	}
+	d.recordResumeAttempt(ctx, pool.Name, claimed, nil)
+	return nil
+}
+
+// destroyFree claims a free instance and destroys it.
+func (d *DistributedManager) destroyFree(ctx context.Context, pool *poolEntry, inst *types.Instance) error {
+	claimed, err := d.instanceStore.FindAndClaim(ctx,
+		&types.QueryParams{PoolName: pool.Name, InstanceID: inst.ID, GPU: inst.GPU},
+		types.StateTerminating, []types.InstanceState{types.StateCreated}, false)
+	if err != nil {
+		return fmt.Errorf("destroy: failed to claim instance %s of %q pool: %w", inst.ID, pool.Name, err)
+	}
+	return d.Destroy(ctx, pool.Name, claimed.ID, claimed, nil)
+}
+
+func (d *DistributedManager) recordHibernationTransition(poolName, state, outcome string) {
+	if d.metrics != nil {
+		d.metrics.RecordHibernationTransition(poolName, state, outcome)
+	}
+}
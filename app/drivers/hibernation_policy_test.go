@@ -0,0 +1,221 @@
+package drivers
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestHibernationPolicy_Target(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        HibernationPolicy
+		demand, keep  int
+		resumeLatency time.Duration
+		want          HibernationTarget
+	}{
+		{
+			name:          "no cost weight keeps the demand running",
+			policy:        HibernationPolicy{CostWeight: 0, Window: 30 * time.Minute},
+			demand:        4,
+			keep:          6,
+			resumeLatency: 2 * time.Minute,
+			want:          HibernationTarget{Running: 4, Hibernated: 2},
+		},
+		{
+			name:          "full cost weight hibernates everything",
+			policy:        HibernationPolicy{CostWeight: 1, Window: 30 * time.Minute},
+			demand:        4,
+			keep:          4,
+			resumeLatency: 2 * time.Minute,
+			want:          HibernationTarget{Running: 0, Hibernated: 4},
+		},
+		{
+			name:          "peak demand keeps most instances running",
+			policy:        HibernationPolicy{CostWeight: 0.1, Window: 30 * time.Minute},
+			demand:        20,
+			keep:          20,
+			resumeLatency: 2 * time.Minute,
+			// 0.9*120s*21 / (0.1*1800s) = 12.6
+			want: HibernationTarget{Running: 12, Hibernated: 8},
+		},
+		{
+			name:          "off-peak demand hibernates",
+			policy:        HibernationPolicy{CostWeight: 0.1, Window: 30 * time.Minute},
+			demand:        1,
+			keep:          2,
+			resumeLatency: 2 * time.Minute,
+			// 0.9*120s*2 / (0.1*1800s) = 1.2
+			want: HibernationTarget{Running: 1, Hibernated: 1},
+		},
+		{
+			name:          "fast resumes favour hibernation",
+			policy:        HibernationPolicy{CostWeight: 0.1, Window: 30 * time.Minute},
+			demand:        4,
+			keep:          4,
+			resumeLatency: 10 * time.Second,
+			want:          HibernationTarget{Running: 0, Hibernated: 4},
+		},
+		{
+			name:          "no demand keeps the floor hibernated",
+			policy:        HibernationPolicy{CostWeight: 0.1, Window: 30 * time.Minute},
+			demand:        0,
+			keep:          2,
+			resumeLatency: 2 * time.Minute,
+			want:          HibernationTarget{Running: 0, Hibernated: 2},
+		},
+		{
+			name:          "keep is raised to the demand",
+			policy:        HibernationPolicy{CostWeight: 0, Window: 30 * time.Minute},
+			demand:        3,
+			keep:          1,
+			resumeLatency: 2 * time.Minute,
+			want:          HibernationTarget{Running: 3, Hibernated: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Target(tt.demand, tt.keep, tt.resumeLatency))
+		})
+	}
+}
+
+func TestHibernationTarget_Decide(t *testing.T) {
+	running := func(id string) *types.Instance {
+		return &types.Instance{ID: id, Source: types.InstanceSourcePredictor}
+	}
+	hibernated := func(id string) *types.Instance {
+		return &types.Instance{ID: id, Source: types.InstanceSourcePredictor, IsHibernated: true}
+	}
+
+	tests := []struct {
+		name   string
+		target HibernationTarget
+		free   []*types.Instance
+		want   map[string]string
+	}{
+		{
+			name:   "instances already in place stay",
+			target: HibernationTarget{Running: 1, Hibernated: 1},
+			free:   []*types.Instance{running("r1"), hibernated("h1")},
+			want:   map[string]string{"r1": HibernationStateRunning, "h1": HibernationStateHibernated},
+		},
+		{
+			name:   "extra running instances are hibernated",
+			target: HibernationTarget{Running: 1, Hibernated: 2},
+			free:   []*types.Instance{running("r1"), running("r2"), running("r3")},
+			want:   map[string]string{"r1": HibernationStateRunning, "r2": HibernationStateHibernated, "r3": HibernationStateHibernated},
+		},
+		{
+			name:   "hibernated instances are resumed for the demand",
+			target: HibernationTarget{Running: 2, Hibernated: 0},
+			free:   []*types.Instance{hibernated("h1"), hibernated("h2")},
+			want:   map[string]string{"h1": HibernationStateRunning, "h2": HibernationStateRunning},
+		},
+		{
+			name:   "instances beyond the target are destroyed",
+			target: HibernationTarget{Running: 1, Hibernated: 0},
+			free:   []*types.Instance{running("r1"), running("r2"), hibernated("h1")},
+			want:   map[string]string{"r1": HibernationStateRunning, "r2": HibernationStateDestroyed, "h1": HibernationStateDestroyed},
+		},
+		{
+			name:   "pool instances are hibernated instead of destroyed",
+			target: HibernationTarget{Running: 1, Hibernated: 0},
+			free:   []*types.Instance{running("r1"), {ID: "p1"}, {ID: "p2", IsHibernated: true}},
+			want:   map[string]string{"p1": HibernationStateRunning, "r1": HibernationStateDestroyed, "p2": HibernationStateHibernated},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]string{}
+			for _, d := range tt.target.Decide(tt.free) {
+				got[d.Instance.ID] = d.State
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDistributedManager_ApplyHibernationDecisions(t *testing.T) {
+	var updated []types.Instance
+	var claimedStates []types.InstanceState
+	store := &mockInstanceStore{
+		FindAndClaimFunc: func(_ context.Context, params *types.QueryParams, newState types.InstanceState, _ []types.InstanceState, _ bool) (*types.Instance, error) {
+			if params.InstanceID == "taken" {
+				return nil, sql.ErrNoRows
+			}
+			claimedStates = append(claimedStates, newState)
+			return &types.Instance{ID: params.InstanceID, IsHibernated: true, State: newState}, nil
+		},
+		UpdateFunc: func(_ context.Context, inst *types.Instance) error {
+			updated = append(updated, *inst)
+			return nil
+		},
+		DeleteFunc: func(context.Context, string) error { return nil },
+	}
+	var destroyed []string
+	driver := &flexibleMockDriver{
+		driverName: "mock",
+		StartFunc: func(context.Context, *types.Instance, string) (string, error) {
+			return "10.0.0.1", nil
+		},
+		DestroyInstanceAndStorageFunc: func(_ context.Context, instances []*types.Instance, _ *storage.CleanupType) ([]*types.Instance, error) {
+			for _, i := range instances {
+				destroyed = append(destroyed, i.ID)
+			}
+			return nil, nil
+		},
+	}
+	metrics := &fakePurgerMetrics{}
+	d := &DistributedManager{
+		Manager: Manager{
+			poolMap:       map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: driver}}},
+			instanceStore: store,
+			metrics:       metrics,
+		},
+	}
+
+	err := d.ApplyHibernationDecisions(context.Background(), "pool1", []*HibernationDecision{
+		{Instance: &types.Instance{ID: "resume", IsHibernated: true}, State: HibernationStateRunning},
+		{Instance: &types.Instance{ID: "destroy"}, State: HibernationStateDestroyed},
+		{Instance: &types.Instance{ID: "taken", IsHibernated: true}, State: HibernationStateRunning},
+		{Instance: &types.Instance{ID: "stay"}, State: HibernationStateRunning},
+	}, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, []types.InstanceState{types.StateHibernating, types.StateTerminating}, claimedStates)
+	require.Len(t, updated, 1)
+	assert.Equal(t, "resume", updated[0].ID)
+	assert.False(t, updated[0].IsHibernated)
+	assert.Equal(t, "10.0.0.1", updated[0].Address)
+	assert.Equal(t, types.StateCreated, updated[0].State)
+	assert.Equal(t, []string{"destroy"}, destroyed)
+	assert.Equal(t, []hibernationTransitionRecord{
+		{"pool1", HibernationStateRunning, HibernationOutcomeApplied},
+		{"pool1", HibernationStateDestroyed, HibernationOutcomeApplied},
+	}, metrics.hibernationMoves)
+}
+
+func TestDistributedManager_ApplyHibernationDecisions_DryRun(t *testing.T) {
+	metrics := &fakePurgerMetrics{}
+	d := &DistributedManager{
+		Manager: Manager{
+			poolMap:       map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: &flexibleMockDriver{}}}},
+			instanceStore: &mockInstanceStore{},
+			metrics:       metrics,
+		},
+	}
+
+	err := d.ApplyHibernationDecisions(context.Background(), "pool1", []*HibernationDecision{
+		{Instance: &types.Instance{ID: "inst-1"}, State: HibernationStateHibernated},
+	}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []hibernationTransitionRecord{{"pool1", HibernationStateHibernated, HibernationOutcomeDryRun}}, metrics.hibernationMoves)
+}
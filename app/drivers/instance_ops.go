@@ -2,6 +2,8 @@ package drivers
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -84,6 +86,26 @@ func (m *Manager) list(ctx context.Context, pool *poolEntry, queryParams *types.
 	return busy, free, hibernating, provisioning, terminating, nil
 }
 
+// claimFree moves a free instance to the given state, so that it is not handed
+// out anymore. The claim is atomic in the store, as another process sharing
+// it, such as the bake command next to a running runner, may claim the same
+// instance; the pool lock keeps it ordered with the hot pool of this process.
+// It returns nil when the instance is no longer free.
+func (m *Manager) claimFree(ctx context.Context, pool *poolEntry, inst *types.Instance, state types.InstanceState) (*types.Instance, error) {
+	pool.Lock()
+	defer pool.Unlock()
+
+	claimed, err := m.instanceStore.FindAndClaim(ctx, &types.QueryParams{
+		PoolName:   pool.Name,
+		InstanceID: inst.ID,
+		GPU:        inst.GPU,
+	}, state, []types.InstanceState{types.StateCreated}, false)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return claimed, err
+}
+
 // Delete deletes an instance from the store.
 func (m *Manager) Delete(ctx context.Context, instanceID string) error {
 	return m.instanceStore.Delete(ctx, instanceID)
@@ -87,6 +87,13 @@ type InstanceLifecycle interface {
 
 	// InstanceLogs returns logs for an instance.
 	InstanceLogs(ctx context.Context, poolName, instanceID string) (string, error)
+
+	// CheckInterruption returns an *ErrSpotInterrupted if the cloud provider reclaimed the instance.
+	CheckInterruption(ctx context.Context, poolName string, instance *types.Instance) error
+
+	// RenewLease extends the lease of a busy instance and returns its new expiry,
+	// or the zero time when leases are disabled.
+	RenewLease(ctx context.Context, poolName, instanceID string) (time.Time, error)
 }
 
 // HealthChecker provides health check operations.
@@ -142,4 +149,11 @@ type PurgerStarter interface {
 	// and other background tasks can report observability metrics. Passing nil disables that
 	// metrics recording.
 	SetMetrics(metrics MetricsRecorder)
+
+	// StartInterruptionMonitor starts the background check for instances reclaimed by the cloud provider.
+	StartInterruptionMonitor(ctx context.Context)
+
+	// StartImageRollout starts the background replacement of free instances created from an
+	// image their pool no longer uses.
+	StartImageRollout(ctx context.Context, interval time.Duration, maxUnavailable int)
 }
@@ -0,0 +1,265 @@
+package drivers
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/sirupsen/logrus"
+
+	itypes "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// interruptionCheckInterval is how often the instances of pools backed by
+// interruptible capacity are checked. EC2 issues spot interruption notices two
+// minutes ahead, so this has to stay well below that.
+const interruptionCheckInterval = 30 * time.Second
+
+// InterruptionDetector is implemented by drivers whose instances can be
+// reclaimed by the cloud provider at any time, such as AWS spot instances.
+type InterruptionDetector interface {
+	// Interrupted returns the IDs of the given instances that were reclaimed
+	// or received an interruption notice.
+	Interrupted(ctx context.Context, instances []*types.Instance) ([]string, error)
+}
+
+// InterruptionReporter is optionally implemented by an InterruptionDetector
+// that reports interrupted instances with a provider specific error, such as
+// GCE Spot VM preemptions.
+type InterruptionReporter interface {
+	// InterruptionError returns the error a stage running on the interrupted
+	// instance fails with. It should unwrap to *ErrSpotInterrupted.
+	InterruptionError(instance *types.Instance) error
+}
+
+// replenishFunc replaces free instances that were removed from a pool.
+type replenishFunc func(ctx context.Context, pool *poolEntry, removed []*types.Instance)
+
+// claimFunc takes a free instance out of the pool by moving it to terminating,
+// so that it is not handed out to a stage while it is destroyed. It returns nil
+// when the instance is no longer free.
+type claimFunc func(ctx context.Context, pool *poolEntry, inst *types.Instance) (*types.Instance, error)
+
+// CheckInterruption reports whether the instance was reclaimed by the cloud
+// provider. An interrupted instance is marked terminating in the store and an
+// *ErrSpotInterrupted is returned so the caller can fail the stage with a
+// retryable error. Drivers that cannot be interrupted always return nil.
+func (m *Manager) CheckInterruption(ctx context.Context, poolName string, instance *types.Instance) error {
+	pool := m.poolMap[poolName]
+	if pool == nil || instance == nil {
+		return nil
+	}
+	driver := pool.DriverForTenant(instance.TenantID)
+	detector, ok := As[InterruptionDetector](driver)
+	if !ok {
+		return nil
+	}
+
+	logr := logger.FromContext(ctx).
+		WithField("driver", driver.DriverName()).
+		WithField("pool", poolName).
+		WithField("instance_id", instance.ID)
+
+	interrupted, err := detector.Interrupted(ctx, []*types.Instance{instance})
+	if err != nil {
+		logr.WithError(err).Warnln("manager: failed to check instance for interruption")
+		return nil
+	}
+	if len(interrupted) == 0 {
+		return nil
+	}
+
+	logr.Warnln("manager: instance was interrupted by the cloud provider")
+	if stored, findErr := m.instanceStore.Find(ctx, instance.ID); findErr == nil && stored != nil {
+		m.markInterrupted(ctx, stored, logr)
+	}
+	if reporter, ok := As[InterruptionReporter](driver); ok {
+		return reporter.InterruptionError(instance)
+	}
+	return &itypes.ErrSpotInterrupted{Driver: driver.DriverName(), InstanceID: instance.ID}
+}
+
+// StartInterruptionMonitor periodically checks the instances of every pool
+// whose driver can detect interruptions. Interrupted free instances are
+// destroyed and replaced, busy ones are marked terminating and left for the
+// stage cleanup to destroy.
+func (m *Manager) StartInterruptionMonitor(ctx context.Context) {
+	m.startInterruptionMonitor(ctx, m.claimTerminating, m.replenishInterrupted)
+}
+
+func (m *Manager) startInterruptionMonitor(ctx context.Context, claim claimFunc, replenish replenishFunc) {
+	if !m.hasInterruptiblePools() {
+		return
+	}
+
+	ticker := time.NewTicker(interruptionCheckInterval)
+	logrus.Infof("Interruption monitor started. It will run every %.2f seconds", interruptionCheckInterval.Seconds())
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, pool := range m.poolMap {
+					func() {
+						defer func() {
+							if r := recover(); r != nil {
+								logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+							}
+						}()
+						if err := m.handleInterruptions(ctx, pool, claim, replenish); err != nil {
+							logger.FromContext(ctx).WithError(err).WithField("pool", pool.Name).
+								Errorln("manager: failed to handle instance interruptions")
+						}
+					}()
+				}
+			}
+		}
+	}()
+}
+
+// hasInterruptiblePools reports whether any pool driver can detect interruptions.
+func (m *Manager) hasInterruptiblePools() bool {
+	for _, pool := range m.poolMap {
+		if _, ok := As[InterruptionDetector](pool.Driver); ok {
+			return true
+		}
+		for _, driver := range pool.TenantDrivers {
+			if _, ok := As[InterruptionDetector](driver); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleInterruptions checks the busy and free instances of a pool owned by
+// this runner for interruptions. Interrupted free instances are claimed before
+// they are destroyed, those that were handed out in the meantime are left for
+// the stage to run into the interruption.
+func (m *Manager) handleInterruptions(ctx context.Context, pool *poolEntry, claim claimFunc, replenish replenishFunc) error {
+	logr := logger.FromContext(ctx).
+		WithField("driver", pool.Driver.DriverName()).
+		WithField("pool", pool.Name)
+
+	query := types.QueryParams{RunnerName: m.runnerName}
+	busy, free, hibernating, _, _, err := m.list(ctx, pool, &query)
+	if err != nil {
+		return err
+	}
+	free = append(free, hibernating...)
+
+	byTenant := map[string][]*types.Instance{}
+	for _, inst := range append(busy, free...) {
+		byTenant[inst.TenantID] = append(byTenant[inst.TenantID], inst)
+	}
+
+	interrupted := map[string]bool{}
+	for tenantID, instances := range byTenant {
+		detector, ok := As[InterruptionDetector](pool.DriverForTenant(tenantID))
+		if !ok {
+			continue
+		}
+		ids, detectErr := detector.Interrupted(ctx, instances)
+		if detectErr != nil {
+			logr.WithError(detectErr).Warnln("manager: failed to check instances for interruption")
+		}
+		for _, id := range ids {
+			interrupted[id] = true
+		}
+	}
+	if len(interrupted) == 0 {
+		return nil
+	}
+
+	for _, inst := range busy {
+		if interrupted[inst.ID] {
+			m.markInterrupted(ctx, inst, logr.WithField("instance_id", inst.ID))
+		}
+	}
+
+	var removed []*types.Instance
+	for _, inst := range free {
+		if !interrupted[inst.ID] {
+			continue
+		}
+		claimed, claimErr := claim(ctx, pool, inst)
+		if claimErr != nil {
+			logr.WithError(claimErr).WithField("instance_id", inst.ID).
+				Warnln("manager: failed to claim interrupted instance")
+			continue
+		}
+		if claimed != nil {
+			removed = append(removed, claimed)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(removed))
+	for i, inst := range removed {
+		ids[i] = inst.ID
+	}
+	logr.WithField("instance_ids", ids).
+		Warnf("manager: destroying %d interrupted free instances", len(removed))
+
+	failed, err := destroyByTenant(ctx, &pool.Pool, removed)
+	if err != nil {
+		logr.WithError(err).Errorln("manager: failed to destroy interrupted instances")
+	}
+	failedIDs := map[string]bool{}
+	for _, inst := range failed {
+		failedIDs[inst.ID] = true
+	}
+
+	var destroyed []*types.Instance
+	for _, inst := range removed {
+		if failedIDs[inst.ID] {
+			continue
+		}
+		if derr := m.instanceStore.Delete(ctx, inst.ID); derr != nil {
+			logr.WithError(derr).WithField("instance_id", inst.ID).
+				Errorln("manager: failed to delete interrupted instance from store")
+			continue
+		}
+		destroyed = append(destroyed, inst)
+	}
+
+	if len(destroyed) > 0 && replenish != nil {
+		replenish(ctx, pool, destroyed)
+	}
+	return nil
+}
+
+// markInterrupted moves an interrupted instance to the terminating state so it
+// is no longer handed out, and gets cleaned up by the stage cleanup or purger.
+func (m *Manager) markInterrupted(ctx context.Context, inst *types.Instance, logr logger.Logger) {
+	if inst.State == types.StateTerminating {
+		return
+	}
+	inst.State = types.StateTerminating
+	inst.Updated = time.Now().Unix()
+	if err := m.instanceStore.Update(ctx, inst); err != nil {
+		logr.WithError(err).Errorln("manager: failed to mark interrupted instance as terminating")
+	}
+}
+
+// claimTerminating moves a free instance to terminating.
+func (m *Manager) claimTerminating(ctx context.Context, pool *poolEntry, inst *types.Instance) (*types.Instance, error) {
+	return m.claimFree(ctx, pool, inst, types.StateTerminating)
+}
+
+// replenishInterrupted tops the pool back up after interrupted free instances
+// were removed.
+func (m *Manager) replenishInterrupted(ctx context.Context, pool *poolEntry, _ []*types.Instance) {
+	query := types.QueryParams{RunnerName: m.runnerName}
+	if err := m.buildPoolWithMutex(ctx, pool, m.GetTLSServerName(), &query); err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("pool", pool.Name).
+			Errorln("manager: failed to replenish pool after interruption")
+	}
+}
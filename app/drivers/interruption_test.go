@@ -0,0 +1,238 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	itypes "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// interruptibleMockDriver is a flexibleMockDriver that reports a fixed set of
+// instances as interrupted.
+type interruptibleMockDriver struct {
+	flexibleMockDriver
+	interrupted []string
+	err         error
+}
+
+func (d *interruptibleMockDriver) Interrupted(_ context.Context, instances []*types.Instance) ([]string, error) {
+	var ids []string
+	for _, inst := range instances {
+		for _, id := range d.interrupted {
+			if inst.ID == id {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, d.err
+}
+
+func TestManager_HandleInterruptions(t *testing.T) {
+	busy := &types.Instance{ID: "busy-interrupted", Pool: "pool1", State: types.StateInUse}
+	busyOK := &types.Instance{ID: "busy-ok", Pool: "pool1", State: types.StateInUse}
+	free := &types.Instance{ID: "free-interrupted", Pool: "pool1", State: types.StateCreated}
+	freeOK := &types.Instance{ID: "free-ok", Pool: "pool1", State: types.StateCreated}
+
+	var updated, deleted, destroyed []string
+	instanceStore := &mockInstanceStore{
+		ListFunc: func(_ context.Context, _ string, _ *types.QueryParams) ([]*types.Instance, error) {
+			return []*types.Instance{busy, busyOK, free, freeOK}, nil
+		},
+		FindAndClaimFunc: claimByID(free),
+		UpdateFunc: func(_ context.Context, inst *types.Instance) error {
+			updated = append(updated, inst.ID)
+			return nil
+		},
+		DeleteFunc: func(_ context.Context, id string) error {
+			deleted = append(deleted, id)
+			return nil
+		},
+	}
+	driver := &interruptibleMockDriver{
+		flexibleMockDriver: flexibleMockDriver{
+			driverName: "mock",
+			DestroyFunc: func(_ context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+				for _, inst := range instances {
+					destroyed = append(destroyed, inst.ID)
+				}
+				return nil, nil
+			},
+		},
+		interrupted: []string{"busy-interrupted", "free-interrupted"},
+	}
+
+	m := &Manager{instanceStore: instanceStore}
+	pool := &poolEntry{Pool: Pool{Name: "pool1", Driver: driver}}
+
+	var replenished []string
+	err := m.handleInterruptions(context.Background(), pool, m.claimTerminating, func(_ context.Context, _ *poolEntry, removed []*types.Instance) {
+		for _, inst := range removed {
+			replenished = append(replenished, inst.ID)
+		}
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"busy-interrupted"}, updated)
+	assert.Equal(t, types.StateTerminating, busy.State)
+	assert.Equal(t, types.StateTerminating, free.State)
+	assert.Equal(t, types.StateInUse, busyOK.State)
+	assert.Equal(t, []string{"free-interrupted"}, destroyed)
+	assert.Equal(t, []string{"free-interrupted"}, deleted)
+	assert.Equal(t, []string{"free-interrupted"}, replenished)
+}
+
+func TestManager_HandleInterruptions_DestroyFailureSkipsReplenish(t *testing.T) {
+	free := &types.Instance{ID: "free-interrupted", Pool: "pool1", State: types.StateCreated}
+
+	instanceStore := &mockInstanceStore{
+		ListFunc: func(_ context.Context, _ string, _ *types.QueryParams) ([]*types.Instance, error) {
+			return []*types.Instance{free}, nil
+		},
+		FindAndClaimFunc: claimByID(free),
+		DeleteFunc: func(_ context.Context, id string) error {
+			t.Fatalf("instance %s must stay in the store when the destroy failed", id)
+			return nil
+		},
+	}
+	driver := &interruptibleMockDriver{
+		flexibleMockDriver: flexibleMockDriver{
+			driverName: "mock",
+			DestroyFunc: func(_ context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+				return instances, assert.AnError
+			},
+		},
+		interrupted: []string{"free-interrupted"},
+	}
+
+	m := &Manager{instanceStore: instanceStore}
+	pool := &poolEntry{Pool: Pool{Name: "pool1", Driver: driver}}
+
+	replenishCalled := false
+	err := m.handleInterruptions(context.Background(), pool, m.claimTerminating, func(context.Context, *poolEntry, []*types.Instance) {
+		replenishCalled = true
+	})
+	assert.NoError(t, err)
+	assert.False(t, replenishCalled)
+}
+
+func TestManager_HandleInterruptions_SkipsInstancesHandedOut(t *testing.T) {
+	listed := &types.Instance{ID: "free-interrupted", Pool: "pool1", State: types.StateCreated}
+
+	instanceStore := &mockInstanceStore{
+		ListFunc: func(_ context.Context, _ string, _ *types.QueryParams) ([]*types.Instance, error) {
+			return []*types.Instance{listed}, nil
+		},
+		// a stage took the instance after the list
+		FindAndClaimFunc: claimByID(&types.Instance{ID: "free-interrupted", Pool: "pool1", State: types.StateInUse}),
+		UpdateFunc: func(_ context.Context, inst *types.Instance) error {
+			t.Fatalf("instance %s must not be updated once it was handed out", inst.ID)
+			return nil
+		},
+	}
+	driver := &interruptibleMockDriver{
+		flexibleMockDriver: flexibleMockDriver{
+			driverName: "mock",
+			DestroyFunc: func(_ context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+				t.Fatalf("destroyed %d instances that were handed out", len(instances))
+				return nil, nil
+			},
+		},
+		interrupted: []string{"free-interrupted"},
+	}
+
+	m := &Manager{instanceStore: instanceStore}
+	pool := &poolEntry{Pool: Pool{Name: "pool1", Driver: driver}}
+
+	err := m.handleInterruptions(context.Background(), pool, m.claimTerminating, func(context.Context, *poolEntry, []*types.Instance) {
+		t.Fatal("nothing was removed, nothing should be replenished")
+	})
+	assert.NoError(t, err)
+}
+
+func TestManager_CheckInterruption(t *testing.T) {
+	stored := &types.Instance{ID: "i-1", Pool: "pool1", State: types.StateInUse}
+	instanceStore := &mockInstanceStore{
+		FindFunc: func(_ context.Context, _ string) (*types.Instance, error) {
+			return stored, nil
+		},
+		UpdateFunc: func(_ context.Context, _ *types.Instance) error { return nil },
+	}
+
+	driver := &interruptibleMockDriver{
+		flexibleMockDriver: flexibleMockDriver{driverName: "amazon"},
+		interrupted:        []string{"i-1"},
+	}
+	m := &Manager{
+		instanceStore: instanceStore,
+		poolMap:       map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: driver}}},
+	}
+
+	err := m.CheckInterruption(context.Background(), "pool1", &types.Instance{ID: "i-1"})
+	var spotErr *itypes.ErrSpotInterrupted
+	if assert.True(t, errors.As(err, &spotErr)) {
+		assert.Equal(t, "i-1", spotErr.InstanceID)
+		assert.Equal(t, "amazon", spotErr.Driver)
+	}
+	assert.Equal(t, types.StateTerminating, stored.State)
+
+	// instances that were not interrupted are left alone
+	assert.NoError(t, m.CheckInterruption(context.Background(), "pool1", &types.Instance{ID: "i-2"}))
+
+	// a failed check is not reported as an interruption
+	driver.err = assert.AnError
+	driver.interrupted = nil
+	assert.NoError(t, m.CheckInterruption(context.Background(), "pool1", &types.Instance{ID: "i-1"}))
+}
+
+func TestManager_CheckInterruption_NotInterruptible(t *testing.T) {
+	m := &Manager{
+		poolMap: map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: &flexibleMockDriver{driverName: "mock"}}}},
+	}
+	assert.NoError(t, m.CheckInterruption(context.Background(), "pool1", &types.Instance{ID: "i-1"}))
+	assert.NoError(t, m.CheckInterruption(context.Background(), "unknown", &types.Instance{ID: "i-1"}))
+}
+
+// preemptibleMockDriver reports interruptions with its own error type.
+type preemptibleMockDriver struct {
+	interruptibleMockDriver
+}
+
+func (d *preemptibleMockDriver) InterruptionError(instance *types.Instance) error {
+	return &itypes.ErrSpotPreempted{
+		ErrSpotInterrupted: itypes.ErrSpotInterrupted{Driver: d.driverName, InstanceID: instance.ID},
+		Zone:               instance.Zone,
+	}
+}
+
+func TestManager_CheckInterruption_Reporter(t *testing.T) {
+	instanceStore := &mockInstanceStore{
+		FindFunc: func(_ context.Context, _ string) (*types.Instance, error) {
+			return &types.Instance{ID: "1", State: types.StateInUse}, nil
+		},
+		UpdateFunc: func(_ context.Context, _ *types.Instance) error { return nil },
+	}
+	driver := &preemptibleMockDriver{interruptibleMockDriver{
+		flexibleMockDriver: flexibleMockDriver{driverName: "google"},
+		interrupted:        []string{"1"},
+	}}
+	m := &Manager{
+		instanceStore: instanceStore,
+		poolMap:       map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: driver}}},
+	}
+
+	err := m.CheckInterruption(context.Background(), "pool1", &types.Instance{ID: "1", Zone: "us-central1-a"})
+	var preemptedErr *itypes.ErrSpotPreempted
+	if assert.True(t, errors.As(err, &preemptedErr)) {
+		assert.Equal(t, "us-central1-a", preemptedErr.Zone)
+	}
+	// a preemption is still an interruption for callers that do not care
+	var spotErr *itypes.ErrSpotInterrupted
+	assert.True(t, errors.As(err, &spotErr))
+	code, ok := itypes.SpotErrorCode(err)
+	assert.True(t, ok)
+	assert.Equal(t, itypes.ErrCodeSpotPreempted, code)
+}
@@ -0,0 +1,235 @@
+package kubevirt
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	inClusterTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token" //nolint:gosec
+	inClusterCAPath    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// object is an unstructured Kubernetes object, as used by the dynamic client.
+type object = map[string]interface{}
+
+// resource identifies a Kubernetes API resource by group, version and plural name.
+type resource struct {
+	Group    string
+	Version  string
+	Resource string
+}
+
+var (
+	resourceVirtualMachines         = resource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachines"}
+	resourceVirtualMachineInstances = resource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances"}
+	resourcePods                    = resource{Version: "v1", Resource: "pods"}
+	resourceSecrets                 = resource{Version: "v1", Resource: "secrets"}
+)
+
+// errNotFound is returned when the requested object does not exist.
+var errNotFound = errors.New("kubevirt: object not found")
+
+// Client is a minimal dynamic Kubernetes client covering the calls used by
+// the driver. Objects are passed around unstructured.
+type Client interface {
+	Version(ctx context.Context) error
+	Create(ctx context.Context, res resource, namespace string, obj object) (object, error)
+	Get(ctx context.Context, res resource, namespace, name string) (object, error)
+	List(ctx context.Context, res resource, namespace, labelSelector string) ([]object, error)
+	// Patch applies a JSON merge patch.
+	Patch(ctx context.Context, res resource, namespace, name string, patch object) (object, error)
+	Delete(ctx context.Context, res resource, namespace, name string) error
+	PodLogs(ctx context.Context, namespace, name, container string) (string, error)
+}
+
+// Connection configures access to the Kubernetes API server. When Server is
+// empty the in-cluster service account is used.
+type Connection struct {
+	Server    string
+	Token     string
+	TokenFile string
+	CAFile    string
+	Insecure  bool
+}
+
+type client struct {
+	client    *http.Client
+	addr      string
+	token     string
+	tokenFile string
+}
+
+// NewClient returns a client for the API server described by conn.
+func NewClient(conn *Connection) (Client, error) {
+	server, tokenFile, caFile := conn.Server, conn.TokenFile, conn.CAFile
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("kubevirt: no api server configured and not running in a cluster")
+		}
+		server = "https://" + net.JoinHostPort(host, port)
+		if tokenFile == "" && conn.Token == "" {
+			tokenFile = inClusterTokenPath
+		}
+		if caFile == "" {
+			caFile = inClusterCAPath
+		}
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if conn.Insecure {
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec
+	} else if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("kubevirt: cannot read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("kubevirt: no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &client{
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}},
+		addr:      strings.TrimSuffix(server, "/"),
+		token:     conn.Token,
+		tokenFile: tokenFile,
+	}, nil
+}
+
+func (c *client) Version(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodGet, "/version", "", nil, nil)
+	return err
+}
+
+func (c *client) Create(ctx context.Context, res resource, namespace string, obj object) (object, error) {
+	out := object{}
+	_, err := c.do(ctx, http.MethodPost, c.path(res, namespace, ""), "application/json", obj, &out)
+	return out, err
+}
+
+func (c *client) Get(ctx context.Context, res resource, namespace, name string) (object, error) {
+	out := object{}
+	_, err := c.do(ctx, http.MethodGet, c.path(res, namespace, name), "", nil, &out)
+	return out, err
+}
+
+func (c *client) List(ctx context.Context, res resource, namespace, labelSelector string) ([]object, error) {
+	out := struct {
+		Items []object `json:"items"`
+	}{}
+	path := c.path(res, namespace, "")
+	if labelSelector != "" {
+		path += "?labelSelector=" + url.QueryEscape(labelSelector)
+	}
+	_, err := c.do(ctx, http.MethodGet, path, "", nil, &out)
+	return out.Items, err
+}
+
+func (c *client) Patch(ctx context.Context, res resource, namespace, name string, patch object) (object, error) {
+	out := object{}
+	_, err := c.do(ctx, http.MethodPatch, c.path(res, namespace, name), "application/merge-patch+json", patch, &out)
+	return out, err
+}
+
+func (c *client) Delete(ctx context.Context, res resource, namespace, name string) error {
+	in := object{"kind": "DeleteOptions", "apiVersion": "v1", "propagationPolicy": "Background"}
+	_, err := c.do(ctx, http.MethodDelete, c.path(res, namespace, name), "application/json", in, nil)
+	return err
+}
+
+func (c *client) PodLogs(ctx context.Context, namespace, name, container string) (string, error) {
+	path := c.path(resourcePods, namespace, name) + "/log?container=" + url.QueryEscape(container)
+	raw, err := c.do(ctx, http.MethodGet, path, "", nil, nil)
+	return string(raw), err
+}
+
+func (c *client) path(res resource, namespace, name string) string {
+	var b strings.Builder
+	if res.Group == "" {
+		b.WriteString("/api/" + res.Version)
+	} else {
+		b.WriteString("/apis/" + res.Group + "/" + res.Version)
+	}
+	if namespace != "" {
+		b.WriteString("/namespaces/" + url.PathEscape(namespace))
+	}
+	b.WriteString("/" + res.Resource)
+	if name != "" {
+		b.WriteString("/" + url.PathEscape(name))
+	}
+	return b.String()
+}
+
+// do sends the request and decodes the response into out. The raw response
+// body is returned for endpoints that do not return JSON.
+func (c *client) do(ctx context.Context, method, path, contentType string, in, out interface{}) ([]byte, error) {
+	var body io.Reader = http.NoBody
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	token := c.token
+	if c.tokenFile != "" {
+		// service account tokens are rotated, read the file on every request
+		raw, readErr := os.ReadFile(c.tokenFile)
+		if readErr != nil {
+			return nil, readErr
+		}
+		token = strings.TrimSpace(string(raw))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json, */*")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode > 299 { //nolint:mnd
+		status := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(raw, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(raw))
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", errNotFound, status.Message)
+		}
+		return nil, fmt.Errorf("kubevirt: %s %s failed with %d: %s", method, path, resp.StatusCode, status.Message)
+	}
+	if out != nil && len(raw) > 0 {
+		if err = json.Unmarshal(raw, out); err != nil {
+			return raw, err
+		}
+	}
+	return raw, nil
+}
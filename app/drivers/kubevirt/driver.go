@@ -0,0 +1,564 @@
+package kubevirt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/lehelper"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/dchest/uniuri"
+)
+
+const (
+	defaultPollInterval = 2 * time.Second
+	defaultReadyTimeout = 10 * time.Minute
+
+	runStrategyAlways = "Always"
+	runStrategyHalted = "Halted"
+
+	vmiPhaseRunning   = "Running"
+	vmiPhaseFailed    = "Failed"
+	vmiPhaseSucceeded = "Succeeded"
+	podPhaseRunning   = "Running"
+
+	consoleLogContainer = "guest-console-log"
+	maxLabelLength      = 63
+	poolLabel           = "runner.drone.io/pool"
+	createdByLabel      = "kubevirt.io/created-by"
+	userDataKey         = "userdata"
+)
+
+var _ drivers.Driver = (*config)(nil)
+
+var (
+	// invalidLabelChars matches characters Kubernetes does not accept in label values.
+	invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.\-]`)
+	// invalidNameChars matches characters not allowed in DNS-1123 object names.
+	invalidNameChars = regexp.MustCompile(`[^a-z0-9\-]`)
+)
+
+// config is a struct that implements drivers.Pool interface
+type config struct {
+	conn            Connection
+	namespace       string
+	image           string
+	sourcePVC       string
+	sourceNamespace string
+	diskSize        string
+	storageClass    string
+	cpu             int64
+	memory          string
+	nodeSelector    map[string]string
+	labels          map[string]string
+	userData        string
+	rootDir         string
+	hibernate       bool
+
+	pollInterval time.Duration
+	readyTimeout time.Duration
+	client       Client
+}
+
+func New(opts ...Option) (drivers.Driver, error) {
+	p := new(config)
+	p.pollInterval = defaultPollInterval
+	p.readyTimeout = defaultReadyTimeout
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.namespace == "" {
+		p.namespace = "default"
+	}
+	if p.sourceNamespace == "" {
+		p.sourceNamespace = p.namespace
+	}
+	if p.image == "" && p.sourcePVC == "" {
+		return nil, fmt.Errorf("kubevirt: a container disk image or a source pvc is required")
+	}
+	// a container disk is ephemeral, stopping the VM would discard the build state
+	if p.hibernate && p.sourcePVC == "" {
+		return nil, fmt.Errorf("kubevirt: hibernate requires a persistent root disk cloned from source_pvc")
+	}
+	if p.client == nil {
+		client, err := NewClient(&p.conn)
+		if err != nil {
+			return nil, err
+		}
+		p.client = client
+	}
+	return p, nil
+}
+
+func (p *config) DriverName() string {
+	return string(types.KubeVirt)
+}
+
+func (p *config) InstanceType() string {
+	if p.sourcePVC != "" {
+		return p.sourcePVC
+	}
+	return p.image
+}
+
+func (p *config) RootDir() string {
+	return p.rootDir
+}
+
+func (p *config) CanHibernate() bool {
+	return p.hibernate
+}
+
+// GetFullyQualifiedImage returns the container disk image, or the source pvc
+// the root disk is cloned from.
+func (p *config) GetFullyQualifiedImage(_ context.Context, config *types.VMImageConfig) (string, error) {
+	if config.ImageName != "" {
+		return config.ImageName, nil
+	}
+	return p.InstanceType(), nil
+}
+
+func (p *config) Ping(ctx context.Context) error {
+	return p.client.Version(ctx)
+}
+
+// ReserveCapacity reserves capacity for a VM
+func (p *config) ReserveCapacity(ctx context.Context, opts *types.InstanceCreateOpts) (*types.CapacityReservation, error) {
+	return nil, &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// DestroyCapacity destroys capacity for a VM
+func (p *config) DestroyCapacity(ctx context.Context, capacity *types.CapacityReservation) (err error) {
+	return &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// Create stores the user data in a secret and creates a VirtualMachine whose
+// instance boots from it through a cloudInitNoCloud volume. The address of
+// the instance is the IP of its virt-launcher pod.
+func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
+	startTime := time.Now()
+	name := objectName(fmt.Sprintf("%s-%s-%s", opts.RunnerName, opts.PoolName, uniuri.NewLen(8))) //nolint:mnd
+	image, _ := p.GetFullyQualifiedImage(ctx, &opts.VMImageConfig)
+
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.KubeVirt).
+		WithField("pool", opts.PoolName).
+		WithField("namespace", p.namespace).
+		WithField("image", image).
+		WithField("name", name).
+		WithField("hibernate", p.CanHibernate())
+	logr.Infof("kubevirt: creating instance %s", name)
+
+	userData, err := lehelper.GenerateUserdata(p.userData, opts)
+	if err != nil {
+		logr.WithError(err).
+			Errorln("kubevirt: failed to generate user data")
+		return nil, err
+	}
+
+	labels := p.buildLabels(opts)
+	secret := object{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   object{"name": name, "namespace": p.namespace, "labels": labels},
+		"type":       "Opaque",
+		"stringData": object{userDataKey: userData},
+	}
+	if _, err = p.client.Create(ctx, resourceSecrets, p.namespace, secret); err != nil {
+		logr.WithError(err).Errorln("kubevirt: failed to create user data secret")
+		return nil, err
+	}
+
+	vm, err := p.client.Create(ctx, resourceVirtualMachines, p.namespace, p.virtualMachine(name, image, labels))
+	if err != nil {
+		logr.WithError(err).Errorln("kubevirt: failed to create virtual machine")
+		p.cleanup(name, logr)
+		return nil, err
+	}
+
+	// let the garbage collector remove the secret together with the VM
+	if uid := nestedString(vm, "metadata", "uid"); uid != "" {
+		owner := object{"metadata": object{"ownerReferences": []object{{
+			"apiVersion": "kubevirt.io/v1",
+			"kind":       "VirtualMachine",
+			"name":       name,
+			"uid":        uid,
+		}}}}
+		if _, err = p.client.Patch(ctx, resourceSecrets, p.namespace, name, owner); err != nil {
+			logr.WithError(err).Warnln("kubevirt: failed to set owner of user data secret")
+		}
+	}
+
+	ip, node, err := p.waitForAddress(ctx, name)
+	if err != nil {
+		logr.WithError(err).Errorln("kubevirt: cannot ascertain instance network")
+		p.cleanup(name, logr)
+		return nil, err
+	}
+	logr.WithField("ip", ip).WithField("node", node).Infof("kubevirt: instance created %s", name)
+
+	labelsBytes, err := json.Marshal(opts.InternalLabels)
+	if err != nil {
+		return nil, fmt.Errorf("scheduler: could not marshal labels: %v, err: %w", opts.InternalLabels, err)
+	}
+
+	return &types.Instance{
+		ID:                name,
+		Name:              name,
+		Provider:          types.KubeVirt,
+		State:             types.StateProvisioning,
+		Pool:              opts.PoolName,
+		Image:             image,
+		Zone:              node,
+		Size:              fmt.Sprintf("%dcpu-%s", p.cpu, p.memory),
+		Platform:          opts.Platform,
+		Address:           ip,
+		CAKey:             opts.CAKey,
+		CACert:            opts.CACert,
+		TLSKey:            opts.TLSKey,
+		TLSCert:           opts.TLSCert,
+		Started:           startTime.Unix(),
+		Updated:           time.Now().Unix(),
+		IsHibernated:      false,
+		Port:              lehelper.LiteEnginePort,
+		StorageIdentifier: opts.StorageOpts.Identifier,
+		Labels:            labelsBytes,
+		ProxyURL:          opts.EgressProxyURL,
+	}, nil
+}
+
+func (p *config) Destroy(ctx context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+	return p.DestroyInstanceAndStorage(ctx, instances, nil)
+}
+
+// DestroyInstanceAndStorage deletes the virtual machines. Their instances and
+// data volumes are removed by the garbage collector.
+func (p *config) DestroyInstanceAndStorage(ctx context.Context, instances []*types.Instance, _ *storage.CleanupType) ([]*types.Instance, error) {
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instance ids provided")
+	}
+
+	var failed []*types.Instance
+	var firstErr error
+	for _, instance := range instances {
+		logr := logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.KubeVirt)
+		err := p.deleteVM(ctx, instance.ID)
+		if err != nil {
+			logr.WithError(err).Errorln("kubevirt: failed to delete virtual machine")
+			failed = append(failed, instance)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		logr.Debugln("kubevirt: virtual machine deleted")
+	}
+	return failed, firstErr
+}
+
+// Logs returns the serial console log captured by the virt-launcher pod, or a
+// status summary when the console log container is not available.
+func (p *config) Logs(ctx context.Context, instanceID string) (string, error) {
+	vmi, err := p.client.Get(ctx, resourceVirtualMachineInstances, p.namespace, instanceID)
+	if err != nil {
+		return "", err
+	}
+	pod, err := p.launcherPod(ctx, vmi)
+	if err == nil && pod != nil {
+		logs, logErr := p.client.PodLogs(ctx, p.namespace, nestedString(pod, "metadata", "name"), consoleLogContainer)
+		if logErr == nil {
+			return logs, nil
+		}
+	}
+	return fmt.Sprintf("kubevirt: vmi %s/%s is %s on node %s; serial console logs are not available",
+		p.namespace, instanceID, nestedString(vmi, "status", "phase"), nestedString(vmi, "status", "nodeName")), nil
+}
+
+// Hibernate stops the virtual machine, deleting its instance and pod while
+// keeping the persistent root disk.
+func (p *config) Hibernate(ctx context.Context, instanceID, _, _ string) error {
+	logr := logger.FromContext(ctx).
+		WithField("id", instanceID).
+		WithField("driver", types.KubeVirt)
+
+	if err := p.setRunStrategy(ctx, instanceID, runStrategyHalted); err != nil {
+		logr.WithError(err).Errorln("kubevirt: failed to stop virtual machine")
+		return err
+	}
+	if err := p.waitForStopped(ctx, instanceID); err != nil {
+		logr.WithError(err).Errorln("kubevirt: virtual machine did not stop")
+		return err
+	}
+	return nil
+}
+
+// Start starts a stopped virtual machine and returns the IP of its new pod.
+func (p *config) Start(ctx context.Context, instance *types.Instance, _ string) (string, error) {
+	logr := logger.FromContext(ctx).
+		WithField("id", instance.ID).
+		WithField("driver", types.KubeVirt)
+
+	if err := p.setRunStrategy(ctx, instance.ID, runStrategyAlways); err != nil {
+		logr.WithError(err).Errorln("kubevirt: failed to start virtual machine")
+		return "", err
+	}
+	ip, _, err := p.waitForAddress(ctx, instance.ID)
+	if err != nil {
+		logr.WithError(err).Errorln("kubevirt: cannot ascertain instance network")
+		return "", err
+	}
+	return ip, nil
+}
+
+// SetTags merges the tags into the labels of the virtual machine and of its
+// running instance.
+func (p *config) SetTags(ctx context.Context, instance *types.Instance, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	labels := object{}
+	for k, v := range tags {
+		labels[k] = sanitizeLabelValue(v)
+	}
+	patch := object{"metadata": object{"labels": labels}}
+	if _, err := p.client.Patch(ctx, resourceVirtualMachines, p.namespace, instance.ID, patch); err != nil {
+		logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.KubeVirt).
+			WithError(err).
+			Errorln("kubevirt: failed to set labels")
+		return err
+	}
+	if _, err := p.client.Patch(ctx, resourceVirtualMachineInstances, p.namespace, instance.ID, patch); err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (p *config) SetLabels(ctx context.Context, instance *types.Instance, labels map[string]string) error {
+	return p.SetTags(ctx, instance, labels)
+}
+
+// virtualMachine returns the VirtualMachine manifest. The root disk is either
+// a container disk or a data volume cloned from the source pvc.
+func (p *config) virtualMachine(name, image string, labels object) object {
+	disks := []object{
+		{"name": "rootdisk", "disk": object{"bus": "virtio"}},
+		{"name": "cloudinitdisk", "disk": object{"bus": "virtio"}},
+	}
+	volumes := []object{
+		{"name": "cloudinitdisk", "cloudInitNoCloud": object{"secretRef": object{"name": name}}},
+	}
+	spec := object{"runStrategy": runStrategyAlways}
+	if p.sourcePVC != "" {
+		pvc := object{
+			"accessModes": []string{"ReadWriteOnce"},
+			"resources":   object{"requests": object{"storage": p.diskSize}},
+		}
+		if p.storageClass != "" {
+			pvc["storageClassName"] = p.storageClass
+		}
+		spec["dataVolumeTemplates"] = []object{{
+			"metadata": object{"name": name},
+			"spec": object{
+				"source": object{"pvc": object{"namespace": p.sourceNamespace, "name": image}},
+				"pvc":    pvc,
+			},
+		}}
+		volumes = append([]object{{"name": "rootdisk", "dataVolume": object{"name": name}}}, volumes...)
+	} else {
+		volumes = append([]object{{"name": "rootdisk", "containerDisk": object{"image": image}}}, volumes...)
+	}
+
+	templateSpec := object{
+		"domain": object{
+			"cpu":       object{"cores": p.cpu},
+			"resources": object{"requests": object{"memory": p.memory}},
+			"devices": object{
+				"disks":      disks,
+				"interfaces": []object{{"name": "default", "masquerade": object{}}},
+			},
+		},
+		"networks":                      []object{{"name": "default", "pod": object{}}},
+		"volumes":                       volumes,
+		"terminationGracePeriodSeconds": 0,
+	}
+	if len(p.nodeSelector) > 0 {
+		templateSpec["nodeSelector"] = p.nodeSelector
+	}
+	spec["template"] = object{
+		"metadata": object{"labels": labels},
+		"spec":     templateSpec,
+	}
+	return object{
+		"apiVersion": "kubevirt.io/v1",
+		"kind":       "VirtualMachine",
+		"metadata":   object{"name": name, "namespace": p.namespace, "labels": labels},
+		"spec":       spec,
+	}
+}
+
+// waitForAddress waits for the instance to run and returns its pod IP and node.
+func (p *config) waitForAddress(ctx context.Context, name string) (ip, node string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, p.readyTimeout)
+	defer cancel()
+	for {
+		vmi, getErr := p.client.Get(ctx, resourceVirtualMachineInstances, p.namespace, name)
+		switch {
+		case getErr == nil:
+			phase := nestedString(vmi, "status", "phase")
+			if phase == vmiPhaseFailed || phase == vmiPhaseSucceeded {
+				return "", "", fmt.Errorf("kubevirt: vmi %s terminated in phase %s", name, phase)
+			}
+			if phase == vmiPhaseRunning {
+				if ip = p.podIP(ctx, vmi); ip != "" {
+					return ip, nestedString(vmi, "status", "nodeName"), nil
+				}
+			}
+		case !errors.Is(getErr, errNotFound):
+			return "", "", getErr
+		}
+		select {
+		case <-ctx.Done():
+			return "", "", fmt.Errorf("kubevirt: vmi %s did not become ready: %w", name, ctx.Err())
+		case <-time.After(p.pollInterval):
+		}
+	}
+}
+
+// podIP returns the IP of the virt-launcher pod, falling back to the address
+// reported on the instance interface.
+func (p *config) podIP(ctx context.Context, vmi object) string {
+	if pod, err := p.launcherPod(ctx, vmi); err == nil && pod != nil {
+		if ip := nestedString(pod, "status", "podIP"); ip != "" {
+			return ip
+		}
+	}
+	if ifaces, ok := nested(vmi, "status", "interfaces").([]interface{}); ok && len(ifaces) > 0 {
+		if iface, ok := ifaces[0].(map[string]interface{}); ok {
+			ip, _ := iface["ipAddress"].(string)
+			return ip
+		}
+	}
+	return ""
+}
+
+func (p *config) launcherPod(ctx context.Context, vmi object) (object, error) {
+	uid := nestedString(vmi, "metadata", "uid")
+	if uid == "" {
+		return nil, nil
+	}
+	pods, err := p.client.List(ctx, resourcePods, p.namespace, createdByLabel+"="+uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		if nestedString(pod, "status", "phase") == podPhaseRunning {
+			return pod, nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *config) waitForStopped(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.readyTimeout)
+	defer cancel()
+	for {
+		_, err := p.client.Get(ctx, resourceVirtualMachineInstances, p.namespace, name)
+		if errors.Is(err, errNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("kubevirt: vmi %s was not deleted: %w", name, ctx.Err())
+		case <-time.After(p.pollInterval):
+		}
+	}
+}
+
+func (p *config) setRunStrategy(ctx context.Context, name, strategy string) error {
+	_, err := p.client.Patch(ctx, resourceVirtualMachines, p.namespace, name, object{"spec": object{"runStrategy": strategy}})
+	return err
+}
+
+func (p *config) deleteVM(ctx context.Context, name string) error {
+	if err := p.client.Delete(ctx, resourceVirtualMachines, p.namespace, name); err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	// the secret is owned by the VM, but may be orphaned if create failed early
+	if err := p.client.Delete(ctx, resourceSecrets, p.namespace, name); err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (p *config) cleanup(name string, logr logger.Logger) {
+	if err := p.deleteVM(context.Background(), name); err != nil {
+		logr.WithError(err).Warnln("kubevirt: failed to clean up after failed create")
+	}
+}
+
+// buildLabels returns the Kubernetes labels of the VM: the pool labels, the
+// pool name and the identity labels of the instance.
+func (p *config) buildLabels(opts *types.InstanceCreateOpts) object {
+	labels := object{}
+	for k, v := range p.labels {
+		labels[k] = sanitizeLabelValue(v)
+	}
+	for k, v := range opts.VMLabels {
+		labels[k] = sanitizeLabelValue(v)
+	}
+	labels[poolLabel] = sanitizeLabelValue(opts.PoolName)
+	return labels
+}
+
+// sanitizeLabelValue makes v a valid label value: at most 63 characters that
+// begin and end with an alphanumeric character.
+func sanitizeLabelValue(v string) string {
+	v = invalidLabelChars.ReplaceAllString(v, "_")
+	if len(v) > maxLabelLength {
+		v = v[:maxLabelLength]
+	}
+	return strings.Trim(v, "_.-")
+}
+
+// objectName makes name a valid DNS-1123 label.
+func objectName(name string) string {
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	if len(name) > maxLabelLength {
+		name = name[len(name)-maxLabelLength:]
+	}
+	return strings.Trim(name, "-")
+}
+
+func nested(obj object, fields ...string) interface{} {
+	var cur interface{} = obj
+	for _, field := range fields {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[field]
+	}
+	return cur
+}
+
+func nestedString(obj object, fields ...string) string {
+	s, _ := nested(obj, fields...).(string)
+	return s
+}
@@ -0,0 +1,380 @@
+package kubevirt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/drivertest"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// fakeClient is an in-memory dynamic client. It also plays the part of the
+// KubeVirt controllers: a VirtualMachine with the Always run strategy gets a
+// running instance and launcher pod, a halted one has them removed.
+type fakeClient struct {
+	mu        sync.Mutex
+	objects   map[resource]map[string]object
+	starts    int
+	failPhase string
+	events    []string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{objects: map[resource]map[string]object{}}
+}
+
+func (f *fakeClient) Version(context.Context) error { return nil }
+
+func (f *fakeClient) Create(_ context.Context, res resource, _ string, obj object) (object, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := nestedString(obj, "metadata", "name")
+	if _, ok := f.objects[res][name]; ok {
+		return nil, fmt.Errorf("kubevirt: %s %s already exists", res.Resource, name)
+	}
+	obj["metadata"].(object)["uid"] = "uid-" + res.Resource + "-" + name
+	f.store(res, name, obj)
+	f.events = append(f.events, "create:"+res.Resource)
+	if res == resourceVirtualMachines {
+		f.reconcile(obj)
+	}
+	return obj, nil
+}
+
+func (f *fakeClient) Get(_ context.Context, res resource, _, name string) (object, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[res][name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %q not found", errNotFound, res.Resource, name)
+	}
+	return obj, nil
+}
+
+func (f *fakeClient) List(_ context.Context, res resource, _, labelSelector string) ([]object, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, value, _ := strings.Cut(labelSelector, "=")
+	var out []object
+	for _, obj := range f.objects[res] {
+		labels, _ := nested(obj, "metadata", "labels").(object)
+		if labels[key] == value {
+			out = append(out, obj)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeClient) Patch(_ context.Context, res resource, _, name string, patch object) (object, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[res][name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %q not found", errNotFound, res.Resource, name)
+	}
+	mergePatch(obj, patch)
+	if res == resourceVirtualMachines {
+		f.reconcile(obj)
+	}
+	return obj, nil
+}
+
+func (f *fakeClient) Delete(_ context.Context, res resource, _, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.objects[res][name]; !ok {
+		return fmt.Errorf("%w: %s %q not found", errNotFound, res.Resource, name)
+	}
+	delete(f.objects[res], name)
+	f.events = append(f.events, "delete:"+res.Resource)
+	if res == resourceVirtualMachines {
+		f.stopInstance(name)
+	}
+	return nil
+}
+
+func (f *fakeClient) PodLogs(_ context.Context, _, name, container string) (string, error) {
+	if container != consoleLogContainer {
+		return "", errors.New("unknown container")
+	}
+	return "[    0.000000] Linux version 6.1 (" + name + ")\n", nil
+}
+
+func (f *fakeClient) store(res resource, name string, obj object) {
+	if f.objects[res] == nil {
+		f.objects[res] = map[string]object{}
+	}
+	f.objects[res][name] = obj
+}
+
+func (f *fakeClient) reconcile(vm object) {
+	name := nestedString(vm, "metadata", "name")
+	switch nestedString(vm, "spec", "runStrategy") {
+	case runStrategyAlways:
+		if _, ok := f.objects[resourceVirtualMachineInstances][name]; ok {
+			return
+		}
+		f.starts++
+		phase := vmiPhaseRunning
+		if f.failPhase != "" {
+			phase = f.failPhase
+		}
+		uid := fmt.Sprintf("vmi-uid-%d", f.starts)
+		labels := nested(vm, "spec", "template", "metadata", "labels").(object)
+		f.store(resourceVirtualMachineInstances, name, object{
+			"metadata": object{"name": name, "uid": uid, "labels": labels},
+			"spec":     nested(vm, "spec", "template", "spec"),
+			"status":   object{"phase": phase, "nodeName": "node-a"},
+		})
+		f.store(resourcePods, "virt-launcher-"+name, object{
+			"metadata": object{"name": "virt-launcher-" + name, "labels": object{createdByLabel: uid}},
+			"status":   object{"phase": podPhaseRunning, "podIP": fmt.Sprintf("10.244.0.%d", f.starts)},
+		})
+	case runStrategyHalted:
+		f.stopInstance(name)
+	}
+}
+
+func (f *fakeClient) stopInstance(name string) {
+	delete(f.objects[resourceVirtualMachineInstances], name)
+	delete(f.objects[resourcePods], "virt-launcher-"+name)
+}
+
+// mergePatch applies a JSON merge patch to obj.
+func mergePatch(obj, patch object) {
+	for k, v := range patch {
+		if sub, ok := v.(object); ok {
+			if dst, ok := obj[k].(object); ok {
+				mergePatch(dst, sub)
+				continue
+			}
+		}
+		obj[k] = v
+	}
+}
+
+func newTestConfig(t *testing.T, fake *fakeClient, opts ...Option) *config {
+	t.Helper()
+	opts = append([]Option{
+		WithClient(fake),
+		WithNamespace("ci"),
+		WithCPU(4),
+		WithMemory("8Gi"),
+		WithLabels(map[string]string{"team": "platform"}),
+	}, opts...)
+	d, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	p := d.(*config)
+	p.pollInterval = time.Millisecond
+	p.readyTimeout = time.Second
+	return p
+}
+
+func createOpts() *types.InstanceCreateOpts {
+	return &types.InstanceCreateOpts{
+		PoolName:   "Linux_Pool",
+		RunnerName: "runner",
+		Platform:   types.Platform{OS: "linux", Arch: "amd64"},
+		CACert:     []byte("ca-cert"),
+		TLSCert:    []byte("tls-cert"),
+		TLSKey:     []byte("tls-key"),
+		VMLabels:   map[string]string{"pool_id": "Linux_Pool", "harness_account": "acc1", "created_at": "1700000000"},
+	}
+}
+
+func TestNew_Validation(t *testing.T) {
+	if _, err := New(WithClient(newFakeClient())); err == nil {
+		t.Error("expected error without image or source pvc")
+	}
+	if _, err := New(WithClient(newFakeClient()), WithImage("quay.io/containerdisks/ubuntu:22.04"), WithHibernate(true)); err == nil {
+		t.Error("expected error for hibernate with a container disk")
+	}
+}
+
+func TestCreate_ContainerDisk(t *testing.T) {
+	fake := newFakeClient()
+	p := newTestConfig(t, fake, WithImage("quay.io/containerdisks/ubuntu:22.04"), WithNodeSelector(map[string]string{"kvm": "true"}))
+
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if instance.Address != "10.244.0.1" || instance.Zone != "node-a" || instance.Size != "4cpu-8Gi" || instance.Provider != types.KubeVirt {
+		t.Errorf("unexpected instance %+v", instance)
+	}
+	if !strings.HasPrefix(instance.ID, "runner-linux-pool-") || instance.ID != strings.ToLower(instance.ID) {
+		t.Errorf("expected a DNS-1123 name, got %q", instance.ID)
+	}
+
+	vm := fake.objects[resourceVirtualMachines][instance.ID]
+	labels := nested(vm, "metadata", "labels").(object)
+	want := map[string]string{"pool_id": "Linux_Pool", "harness_account": "acc1", "created_at": "1700000000", "team": "platform", poolLabel: "Linux_Pool"}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Errorf("label %s = %v, want %s", k, labels[k], v)
+		}
+	}
+	if nested(vm, "spec", "template", "metadata", "labels") == nil {
+		t.Error("expected labels on the instance template")
+	}
+	volumes := nested(vm, "spec", "template", "spec", "volumes").([]object)
+	if nestedString(volumes[0], "containerDisk", "image") != "quay.io/containerdisks/ubuntu:22.04" {
+		t.Errorf("root volume = %v", volumes[0])
+	}
+	if nestedString(volumes[1], "cloudInitNoCloud", "secretRef", "name") != instance.ID {
+		t.Errorf("cloud-init volume = %v", volumes[1])
+	}
+	if nested(vm, "spec", "dataVolumeTemplates") != nil {
+		t.Error("expected no data volume for a container disk")
+	}
+
+	secret := fake.objects[resourceSecrets][instance.ID]
+	if !strings.Contains(nestedString(secret, "stringData", userDataKey), "ca-cert") {
+		t.Error("expected cloud-init user data in the secret")
+	}
+	owners, _ := nested(secret, "metadata", "ownerReferences").([]object)
+	if len(owners) != 1 || owners[0]["uid"] != "uid-virtualmachines-"+instance.ID {
+		t.Errorf("secret owners = %v", owners)
+	}
+}
+
+func TestCreate_FailedInstanceCleansUp(t *testing.T) {
+	fake := newFakeClient()
+	fake.failPhase = vmiPhaseFailed
+	p := newTestConfig(t, fake, WithImage("quay.io/containerdisks/ubuntu:22.04"))
+
+	if _, err := p.Create(context.Background(), createOpts()); err == nil || !strings.Contains(err.Error(), "Failed") {
+		t.Fatalf("expected failed phase error, got %v", err)
+	}
+	if len(fake.objects[resourceVirtualMachines]) != 0 || len(fake.objects[resourceSecrets]) != 0 {
+		t.Error("expected virtual machine and secret to be deleted")
+	}
+}
+
+func TestHibernateAndStart(t *testing.T) {
+	fake := newFakeClient()
+	p := newTestConfig(t, fake, WithSourcePVC("images", "ubuntu-golden", "", "ceph-block"), WithHibernate(true))
+
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	vm := fake.objects[resourceVirtualMachines][instance.ID]
+	templates := nested(vm, "spec", "dataVolumeTemplates").([]object)
+	if nestedString(templates[0], "spec", "source", "pvc", "namespace") != "images" ||
+		nestedString(templates[0], "spec", "source", "pvc", "name") != "ubuntu-golden" ||
+		nestedString(templates[0], "spec", "pvc", "storageClassName") != "ceph-block" ||
+		nestedString(templates[0], "spec", "pvc", "resources", "requests", "storage") != "50Gi" {
+		t.Errorf("data volume template = %v", templates[0])
+	}
+
+	if err = p.Hibernate(context.Background(), instance.ID, "linux", ""); err != nil {
+		t.Fatalf("Hibernate: %v", err)
+	}
+	if nestedString(vm, "spec", "runStrategy") != runStrategyHalted {
+		t.Error("expected the virtual machine to be halted")
+	}
+	if _, ok := fake.objects[resourceVirtualMachineInstances][instance.ID]; ok {
+		t.Error("expected the instance to be removed")
+	}
+
+	// the new launcher pod has a new IP
+	ip, err := p.Start(context.Background(), instance, "linux")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if ip != "10.244.0.2" {
+		t.Errorf("ip = %q", ip)
+	}
+}
+
+func TestSetLabelsAndLogs(t *testing.T) {
+	fake := newFakeClient()
+	p := newTestConfig(t, fake, WithImage("quay.io/containerdisks/ubuntu:22.04"))
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err = p.SetLabels(context.Background(), instance, map[string]string{"stage_execution_id": "Stage/42:abc", "retain": "true"}); err != nil {
+		t.Fatalf("SetLabels: %v", err)
+	}
+	for _, res := range []resource{resourceVirtualMachines, resourceVirtualMachineInstances} {
+		labels := nested(fake.objects[res][instance.ID], "metadata", "labels").(object)
+		if labels["stage_execution_id"] != "Stage_42_abc" || labels["retain"] != "true" || labels["team"] != "platform" {
+			t.Errorf("%s labels = %v", res.Resource, labels)
+		}
+	}
+
+	logs, err := p.Logs(context.Background(), instance.ID)
+	if err != nil || !strings.Contains(logs, "Linux version") {
+		t.Errorf("Logs = %q, %v", logs, err)
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	fake := newFakeClient()
+	p := newTestConfig(t, fake, WithImage("quay.io/containerdisks/ubuntu:22.04"))
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	failed, err := p.Destroy(context.Background(), []*types.Instance{instance, {ID: "missing"}})
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Destroy: failed=%v err=%v", failed, err)
+	}
+	if len(fake.objects[resourceVirtualMachines]) != 0 || len(fake.objects[resourceVirtualMachineInstances]) != 0 || len(fake.objects[resourceSecrets]) != 0 {
+		t.Errorf("expected all objects to be deleted, got %v", fake.objects)
+	}
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	tests := map[string]string{
+		"abc":                   "abc",
+		"-leading.and.trail_":   "leading.and.trail",
+		"with space/and:colon":  "with_space_and_colon",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+	}
+	for in, want := range tests {
+		if got := sanitizeLabelValue(in); got != want {
+			t.Errorf("sanitizeLabelValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func (f *fakeClient) Exists(instanceID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.objects[resourceVirtualMachines][instanceID]
+	return ok
+}
+
+func (f *fakeClient) Labels(instanceID string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	labels := map[string]string{}
+	if vm, ok := f.objects[resourceVirtualMachines][instanceID]; ok {
+		for k, v := range nested(vm, "metadata", "labels").(object) {
+			labels[k], _ = v.(string)
+		}
+	}
+	return labels
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, drivertest.Config{
+		NewDriver: func(t *testing.T) (drivers.Driver, drivertest.Backend) {
+			fake := newFakeClient()
+			return newTestConfig(t, fake, WithSourcePVC("images", "ubuntu-golden", "", "ceph-block"), WithHibernate(true)), fake
+		},
+	})
+}
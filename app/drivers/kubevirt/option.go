@@ -0,0 +1,154 @@
+package kubevirt
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/app/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+type Option func(*config)
+
+func SetPlatformDefaults(platform *types.Platform) (*types.Platform, error) {
+	if platform.Arch == "" {
+		platform.Arch = oshelp.ArchAMD64
+	}
+	if platform.Arch != oshelp.ArchAMD64 && platform.Arch != oshelp.ArchARM64 {
+		return platform, fmt.Errorf("invalid arch %s, has to be '%s/%s'", platform.Arch, oshelp.ArchAMD64, oshelp.ArchARM64)
+	}
+	// verify that we are using sane values for OS
+	if platform.OS == "" {
+		platform.OS = oshelp.OSLinux
+	}
+	if platform.OS != oshelp.OSLinux {
+		return platform, fmt.Errorf("kubevirt - invalid OS %s, has to be '%s'", platform.OS, oshelp.OSLinux)
+	}
+	// set osname
+	if platform.OSName == "" {
+		platform.OSName = oshelp.Ubuntu
+	}
+	return platform, nil
+}
+
+// WithConnection sets how the Kubernetes API server is reached. An empty
+// server uses the in-cluster service account.
+func WithConnection(conn *Connection) Option {
+	return func(p *config) {
+		p.conn = *conn
+	}
+}
+
+// WithNamespace sets the namespace the virtual machines are created in.
+func WithNamespace(namespace string) Option {
+	return func(p *config) {
+		if namespace == "" {
+			p.namespace = "default"
+		} else {
+			p.namespace = namespace
+		}
+	}
+}
+
+// WithImage sets the container disk image booted by the virtual machines.
+func WithImage(image string) Option {
+	return func(p *config) {
+		p.image = image
+	}
+}
+
+// WithSourcePVC boots the virtual machines from a data volume cloned from
+// the given pvc, which is required to hibernate. The pvc defaults to the
+// namespace of the virtual machines.
+func WithSourcePVC(namespace, name, size, storageClass string) Option {
+	return func(p *config) {
+		p.sourcePVC = name
+		p.sourceNamespace = namespace
+		if size == "" {
+			p.diskSize = "50Gi"
+		} else {
+			p.diskSize = size
+		}
+		p.storageClass = storageClass
+	}
+}
+
+func WithCPU(cpu int64) Option {
+	return func(p *config) {
+		if cpu <= 0 {
+			p.cpu = 2
+		} else {
+			p.cpu = cpu
+		}
+	}
+}
+
+// WithMemory sets the guest memory as a Kubernetes quantity, e.g. 4Gi.
+func WithMemory(memory string) Option {
+	return func(p *config) {
+		if memory == "" {
+			p.memory = "4Gi"
+		} else {
+			p.memory = memory
+		}
+	}
+}
+
+// WithNodeSelector restricts the nodes the virtual machines are scheduled on.
+func WithNodeSelector(selector map[string]string) Option {
+	return func(p *config) {
+		p.nodeSelector = selector
+	}
+}
+
+// WithLabels sets additional labels applied to every virtual machine.
+func WithLabels(labels map[string]string) Option {
+	return func(p *config) {
+		p.labels = labels
+	}
+}
+
+func WithHibernate(hibernate bool) Option {
+	return func(p *config) {
+		p.hibernate = hibernate
+	}
+}
+
+// WithClient overrides the Kubernetes client.
+func WithClient(client Client) Option {
+	return func(p *config) {
+		p.client = client
+	}
+}
+
+func WithUserData(text, path string) Option {
+	if text != "" {
+		return func(p *config) {
+			p.userData = text
+		}
+	}
+	return func(p *config) {
+		if path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logrus.WithError(err).
+					Fatalln("failed to read user_data file")
+				return
+			}
+			p.userData = string(data)
+		}
+	}
+}
+
+// WithRootDirectory sets the root directory for the virtual machine.
+func WithRootDirectory(dir string) Option {
+	return func(p *config) {
+		if dir == "" {
+			p.rootDir = oshelp.JoinPaths(oshelp.OSLinux, "/tmp", "kubevirt")
+		} else {
+			p.rootDir = dir
+		}
+	}
+}
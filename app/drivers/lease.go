@@ -0,0 +1,79 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// ErrLeaseNotHeld is returned by RenewLease when the instance is not busy, as
+// when the purger already reclaimed it after its lease lapsed.
+var ErrLeaseNotHeld = errors.New("lease: instance is not busy")
+
+// RenewLease is a no-op, the purger of the Manager reclaims busy instances by
+// max age only.
+func (m *Manager) RenewLease(context.Context, string, string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+// RenewLease extends the lease of a busy instance by the busy lease TTL. Busy
+// instances holding a lease are reclaimed by the purger once it lapses rather
+// than by the busy max age, see cleanupLapsedLeases.
+func (d *DistributedManager) RenewLease(ctx context.Context, poolName, instanceID string) (time.Time, error) {
+	if d.busyLeaseTTL <= 0 {
+		return time.Time{}, nil
+	}
+	expiry := time.Now().Add(d.busyLeaseTTL)
+	builder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	renewSQL, args, err := builder.
+		Update("instances").
+		Set("instance_lease_expiry", expiry.Unix()).
+		Where(squirrel.Eq{
+			"instance_id":    instanceID,
+			"instance_pool":  poolName,
+			"instance_state": types.StateInUse,
+		}).
+		Suffix("RETURNING instance_id, instance_name, instance_node_id, runner_name, tenant_id, instance_zone").
+		ToSql()
+	if err != nil {
+		return time.Time{}, err
+	}
+	renewed, err := d.instanceStore.DeleteAndReturn(ctx, renewSQL, args...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(renewed) == 0 {
+		return time.Time{}, ErrLeaseNotHeld
+	}
+	return expiry, nil
+}
+
+// cleanupLapsedLeases handles cleanup of busy instances whose lease lapsed,
+// that is whose runner stopped renewing it. Unlike the busy max age there is
+// no force-delete window, a lapsed lease is never renewed again.
+func (d *DistributedManager) cleanupLapsedLeases(ctx context.Context, pool *poolEntry, queryParams *types.QueryParams) error {
+	leaseCondition := squirrel.And{
+		squirrel.Eq{"instance_pool": pool.Name},
+		squirrel.Eq{"instance_state": types.StateInUse},
+		squirrel.Gt{"instance_lease_expiry": 0},
+		squirrel.Lt{"instance_lease_expiry": time.Now().Unix()},
+	}
+	for key, value := range queryParams.MatchLabels {
+		condition := squirrel.Expr("(instance_labels->>?) = ?", key, value)
+		leaseCondition = append(leaseCondition, condition)
+	}
+
+	instances, err := d.executeInstanceCleanup(ctx, pool, squirrel.Or{leaseCondition}, "lease", 0)
+	if len(instances) > 0 {
+		logger.FromContext(ctx).
+			WithField("pool", pool.Name).
+			WithField("count", len(instances)).
+			Warnf("distributed dlite: purger: reclaimed %d busy instances with a lapsed lease", len(instances))
+	}
+	return err
+}
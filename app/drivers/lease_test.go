@@ -0,0 +1,112 @@
+package drivers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestDistributedManager_RenewLease(t *testing.T) {
+	var query string
+	var args []any
+	d := &DistributedManager{
+		Manager: Manager{
+			busyLeaseTTL: 10 * time.Minute,
+			instanceStore: &mockInstanceStore{
+				DeleteAndReturnFunc: func(_ context.Context, q string, a ...any) ([]*types.Instance, error) {
+					query, args = q, a
+					return []*types.Instance{{ID: "inst-1"}}, nil
+				},
+			},
+		},
+	}
+
+	expiry, err := d.RenewLease(context.Background(), "pool1", "inst-1")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), expiry, 5*time.Second)
+	assert.True(t, strings.HasPrefix(query, "UPDATE instances SET instance_lease_expiry = $1"), query)
+	assert.Equal(t, []any{expiry.Unix(), "inst-1", "pool1", string(types.StateInUse)}, args)
+}
+
+func TestDistributedManager_RenewLease_NotHeld(t *testing.T) {
+	d := &DistributedManager{
+		Manager: Manager{
+			busyLeaseTTL:  10 * time.Minute,
+			instanceStore: &mockInstanceStore{},
+		},
+	}
+
+	_, err := d.RenewLease(context.Background(), "pool1", "inst-1")
+	assert.ErrorIs(t, err, ErrLeaseNotHeld)
+}
+
+func TestDistributedManager_RenewLease_Disabled(t *testing.T) {
+	d := &DistributedManager{
+		Manager: Manager{
+			instanceStore: &mockInstanceStore{
+				DeleteAndReturnFunc: func(context.Context, string, ...any) ([]*types.Instance, error) {
+					t.Fatal("the store must not be called when leases are disabled")
+					return nil, nil
+				},
+			},
+		},
+	}
+
+	expiry, err := d.RenewLease(context.Background(), "pool1", "inst-1")
+	require.NoError(t, err)
+	assert.True(t, expiry.IsZero())
+}
+
+func TestDistributedPurger_CleanupLapsedLeases(t *testing.T) {
+	var claimSQL string
+	var deleted []any
+	store := &mockInstanceStore{
+		DeleteAndReturnFunc: func(_ context.Context, q string, a ...any) ([]*types.Instance, error) {
+			switch {
+			case strings.HasPrefix(q, "UPDATE instances SET instance_state = "):
+				claimSQL = q
+				return []*types.Instance{{ID: "inst-1", Name: "vm-1", Zone: "zone-a"}}, nil
+			case strings.HasPrefix(q, "DELETE FROM instances WHERE instance_id IN "):
+				deleted = a
+				return nil, nil
+			}
+			t.Fatalf("unexpected query: %s", q)
+			return nil, nil
+		},
+	}
+	var destroyed []string
+	driver := &flexibleMockDriver{
+		driverName: "mock",
+		DestroyFunc: func(_ context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+			for _, i := range instances {
+				destroyed = append(destroyed, i.ID)
+			}
+			return nil, nil
+		},
+	}
+	metrics := &fakePurgerMetrics{}
+	pool := &poolEntry{Pool: Pool{Name: "pool1", Driver: driver}}
+	d := &DistributedManager{
+		Manager: Manager{
+			poolMap:       map[string]*poolEntry{"pool1": pool},
+			instanceStore: store,
+			busyLeaseTTL:  10 * time.Minute,
+			metrics:       metrics,
+		},
+	}
+
+	err := d.cleanupLapsedLeases(context.Background(), pool, &types.QueryParams{MatchLabels: map[string]string{"retain": "false"}})
+	require.NoError(t, err)
+	assert.Contains(t, claimSQL, "instance_lease_expiry > $")
+	assert.Contains(t, claimSQL, "instance_lease_expiry < $")
+	assert.Equal(t, []string{"inst-1"}, destroyed)
+	assert.Equal(t, []any{"inst-1"}, deleted)
+	assert.Equal(t, []destroyAttemptRecord{{"pool1", "zone-a", PurgerReasonLeaseExpired, PurgerOutcomeDestroyed}}, metrics.destroyAttempts)
+	assert.Empty(t, metrics.forceDeleted, "a lapsed lease has no force-delete window")
+}
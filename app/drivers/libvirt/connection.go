@@ -0,0 +1,183 @@
+package libvirt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrDomainNotFound = errors.New("libvirt: domain not found")
+	ErrVirshNotFound  = errors.New("libvirt: virsh not found")
+)
+
+const domainStateRunning = "running"
+
+// Connection abstracts the hypervisor host. The default implementation shells
+// out to virsh and qemu-img on the local host; tests substitute a fake.
+type Connection interface {
+	// Ping verifies the hypervisor is reachable.
+	Ping(ctx context.Context) error
+	// CreateOverlay creates a qcow2 copy-on-write overlay backed by the base image.
+	CreateOverlay(ctx context.Context, backingPath, path string, sizeGB int64) error
+	// CreateSeed writes a cloud-init NoCloud seed image with the given user and meta data.
+	CreateSeed(ctx context.Context, path, userData, metaData string) error
+	// DefineDomain registers a persistent domain from its XML description.
+	DefineDomain(ctx context.Context, xml string) error
+	// StartDomain boots a domain, restoring its managed save image if one exists.
+	StartDomain(ctx context.Context, name string) error
+	// ManagedSave suspends the domain to disk and stops it.
+	ManagedSave(ctx context.Context, name string) error
+	// DestroyDomain forcefully stops a running domain.
+	DestroyDomain(ctx context.Context, name string) error
+	// UndefineDomain removes the domain definition and any managed save image.
+	UndefineDomain(ctx context.Context, name string) error
+	// DomainState returns the domain state as reported by libvirt, e.g. "running".
+	DomainState(ctx context.Context, name string) (string, error)
+	// ReadFile reads a file on the hypervisor host.
+	ReadFile(ctx context.Context, path string) ([]byte, error)
+	// RemoveFile deletes a file on the hypervisor host, ignoring missing files.
+	RemoveFile(ctx context.Context, path string) error
+}
+
+// virshConnection implements Connection using the virsh and qemu-img
+// command line tools on the local host.
+type virshConnection struct {
+	uri string
+}
+
+// NewConnection returns a Connection for the given libvirt URI.
+func NewConnection(uri string) Connection {
+	return &virshConnection{uri: uri}
+}
+
+func (c *virshConnection) Ping(ctx context.Context) error {
+	_, err := c.virsh(ctx, "version")
+	return err
+}
+
+func (c *virshConnection) CreateOverlay(ctx context.Context, backingPath, path string, sizeGB int64) error {
+	args := []string{"create", "-f", "qcow2", "-F", "qcow2", "-b", backingPath, path}
+	if sizeGB > 0 {
+		args = append(args, strconv.FormatInt(sizeGB, 10)+"G")
+	}
+	_, err := run(ctx, "qemu-img", args...)
+	return err
+}
+
+func (c *virshConnection) CreateSeed(ctx context.Context, path, userData, metaData string) error {
+	dir, err := os.MkdirTemp("", "cidata")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	userDataPath := filepath.Join(dir, "user-data")
+	metaDataPath := filepath.Join(dir, "meta-data")
+	if err = os.WriteFile(userDataPath, []byte(userData), 0o600); err != nil { //nolint:mnd
+		return err
+	}
+	if err = os.WriteFile(metaDataPath, []byte(metaData), 0o600); err != nil { //nolint:mnd
+		return err
+	}
+	_, err = run(ctx, "genisoimage", "-output", path, "-volid", "cidata", "-joliet", "-rock", userDataPath, metaDataPath)
+	return err
+}
+
+func (c *virshConnection) DefineDomain(ctx context.Context, xml string) error {
+	f, err := os.CreateTemp("", "domain-*.xml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.WriteString(xml); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	_, err = c.virsh(ctx, "define", f.Name())
+	return err
+}
+
+func (c *virshConnection) StartDomain(ctx context.Context, name string) error {
+	_, err := c.virsh(ctx, "start", name)
+	return err
+}
+
+func (c *virshConnection) ManagedSave(ctx context.Context, name string) error {
+	_, err := c.virsh(ctx, "managedsave", name)
+	return err
+}
+
+func (c *virshConnection) DestroyDomain(ctx context.Context, name string) error {
+	_, err := c.virsh(ctx, "destroy", name)
+	if err != nil && strings.Contains(err.Error(), "domain is not running") {
+		return nil
+	}
+	return err
+}
+
+func (c *virshConnection) UndefineDomain(ctx context.Context, name string) error {
+	_, err := c.virsh(ctx, "undefine", "--managed-save", name)
+	return err
+}
+
+func (c *virshConnection) DomainState(ctx context.Context, name string) (string, error) {
+	out, err := c.virsh(ctx, "domstate", name)
+	return strings.TrimSpace(out), err
+}
+
+func (c *virshConnection) ReadFile(_ context.Context, path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (c *virshConnection) RemoveFile(_ context.Context, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *virshConnection) virsh(ctx context.Context, args ...string) (string, error) {
+	if c.uri != "" {
+		args = append([]string{"--connect", c.uri}, args...)
+	}
+	out, err := run(ctx, "virsh", args...)
+	if err != nil {
+		var ee *exec.Error
+		if errors.As(err, &ee) && errors.Is(ee.Err, exec.ErrNotFound) {
+			return "", ErrVirshNotFound
+		}
+		msg := err.Error()
+		if strings.Contains(msg, "failed to get domain") || strings.Contains(msg, "Domain not found") {
+			return "", fmt.Errorf("%w: %s", ErrDomainNotFound, msg)
+		}
+	}
+	return out, err
+}
+
+func run(ctx context.Context, bin string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, bin, args...)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	logrus.Debugf("executing: %v %v", bin, strings.Join(args, " "))
+
+	if err := cmd.Run(); err != nil {
+		var ee *exec.Error
+		if errors.As(err, &ee) {
+			return "", err
+		}
+		return stdout.String(), fmt.Errorf("%s: %w: %s", bin, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
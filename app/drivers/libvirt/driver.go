@@ -0,0 +1,342 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/lehelper"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/dchest/uniuri"
+)
+
+const (
+	defaultPollInterval = 2 * time.Second
+	defaultIPTimeout    = 5 * time.Minute
+)
+
+var _ drivers.Driver = (*config)(nil)
+
+// config is a struct that implements drivers.Pool interface
+type config struct {
+	uri         string
+	image       string
+	storagePath string
+	network     string
+	leaseFile   string
+	cpus        int64
+	memoryMB    int64
+	diskSizeGB  int64
+	userData    string
+	rootDir     string
+	hibernate   bool
+
+	ipTimeout    time.Duration
+	pollInterval time.Duration
+	conn         Connection
+}
+
+func New(opts ...Option) (drivers.Driver, error) {
+	p := new(config)
+	p.pollInterval = defaultPollInterval
+	p.ipTimeout = defaultIPTimeout
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.image == "" {
+		return nil, fmt.Errorf("libvirt: base image is required")
+	}
+	if p.conn == nil {
+		p.conn = NewConnection(p.uri)
+	}
+	return p, nil
+}
+
+func (p *config) DriverName() string {
+	return string(types.Libvirt)
+}
+
+func (p *config) InstanceType() string {
+	return p.image
+}
+
+func (p *config) RootDir() string {
+	return p.rootDir
+}
+
+func (p *config) CanHibernate() bool {
+	return p.hibernate
+}
+
+// GetFullyQualifiedImage resolves the backing qcow2 image. Relative image names
+// are looked up next to the pool's default image.
+func (p *config) GetFullyQualifiedImage(_ context.Context, config *types.VMImageConfig) (string, error) {
+	if config.ImageName == "" {
+		return p.image, nil
+	}
+	if filepath.IsAbs(config.ImageName) {
+		return config.ImageName, nil
+	}
+	return filepath.Join(filepath.Dir(p.image), config.ImageName), nil
+}
+
+func (p *config) Ping(ctx context.Context) error {
+	return p.conn.Ping(ctx)
+}
+
+// ReserveCapacity reserves capacity for a VM
+func (p *config) ReserveCapacity(ctx context.Context, opts *types.InstanceCreateOpts) (*types.CapacityReservation, error) {
+	return nil, &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// DestroyCapacity destroys capacity for a VM
+func (p *config) DestroyCapacity(ctx context.Context, capacity *types.CapacityReservation) (err error) {
+	return &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// Create clones the backing image into a qcow2 overlay, attaches a cloud-init
+// seed, boots the domain and waits for its DHCP lease.
+func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
+	startTime := time.Now()
+
+	image, err := p.GetFullyQualifiedImage(ctx, &opts.VMImageConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image: %w", err)
+	}
+
+	name := strings.ToLower(fmt.Sprintf("%s-%s-%s", opts.RunnerName, opts.PoolName, uniuri.NewLen(8))) //nolint:mnd
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.Libvirt).
+		WithField("pool", opts.PoolName).
+		WithField("image", image).
+		WithField("name", name).
+		WithField("hibernate", p.CanHibernate())
+	logr.Infof("libvirt: creating instance %s", name)
+
+	userData, err := lehelper.GenerateUserdata(p.userData, opts)
+	if err != nil {
+		logr.WithError(err).
+			Errorln("libvirt: failed to generate user data")
+		return nil, err
+	}
+
+	diskPath, seedPath, consolePath := p.paths(name)
+	defer func() {
+		if err != nil {
+			p.cleanup(context.Background(), name, logr)
+		}
+	}()
+
+	if err = p.conn.CreateOverlay(ctx, image, diskPath, p.diskSizeGB); err != nil {
+		logr.WithError(err).Errorln("libvirt: failed to create disk overlay")
+		return nil, err
+	}
+	metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", name, name)
+	if err = p.conn.CreateSeed(ctx, seedPath, userData, metaData); err != nil {
+		logr.WithError(err).Errorln("libvirt: failed to create cloud-init seed")
+		return nil, err
+	}
+
+	mac := macForDomain(name)
+	domainXML, err := buildDomainXML(&domainParams{
+		name:        name,
+		cpus:        p.cpus,
+		memoryMB:    p.memoryMB,
+		diskPath:    diskPath,
+		seedPath:    seedPath,
+		network:     p.network,
+		mac:         mac,
+		consolePath: consolePath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = p.conn.DefineDomain(ctx, domainXML); err != nil {
+		logr.WithError(err).Errorln("libvirt: failed to define domain")
+		return nil, err
+	}
+	if err = p.conn.StartDomain(ctx, name); err != nil {
+		logr.WithError(err).Errorln("libvirt: failed to start domain")
+		return nil, err
+	}
+
+	ip, err := p.waitForIP(ctx, mac)
+	if err != nil {
+		logr.WithError(err).Errorln("libvirt: cannot ascertain instance network")
+		return nil, err
+	}
+	logr.WithField("ip", ip).Infof("libvirt: instance created %s", name)
+
+	labelsBytes, err := json.Marshal(opts.InternalLabels)
+	if err != nil {
+		return nil, fmt.Errorf("scheduler: could not marshal labels: %v, err: %w", opts.InternalLabels, err)
+	}
+
+	return &types.Instance{
+		ID:                name,
+		Name:              name,
+		Provider:          types.Libvirt,
+		State:             types.StateProvisioning,
+		Pool:              opts.PoolName,
+		Image:             image,
+		Size:              fmt.Sprintf("%dcpu-%dmb", p.cpus, p.memoryMB),
+		Platform:          opts.Platform,
+		Address:           ip,
+		CAKey:             opts.CAKey,
+		CACert:            opts.CACert,
+		TLSKey:            opts.TLSKey,
+		TLSCert:           opts.TLSCert,
+		Started:           startTime.Unix(),
+		Updated:           time.Now().Unix(),
+		IsHibernated:      false,
+		Port:              lehelper.LiteEnginePort,
+		StorageIdentifier: opts.StorageOpts.Identifier,
+		Labels:            labelsBytes,
+		ProxyURL:          opts.EgressProxyURL,
+	}, nil
+}
+
+func (p *config) Destroy(ctx context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+	return p.DestroyInstanceAndStorage(ctx, instances, nil)
+}
+
+// DestroyInstanceAndStorage stops and undefines the domains and removes their
+// overlay, seed and console files.
+func (p *config) DestroyInstanceAndStorage(ctx context.Context, instances []*types.Instance, _ *storage.CleanupType) ([]*types.Instance, error) {
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instance ids provided")
+	}
+
+	var failed []*types.Instance
+	var firstErr error
+	for _, instance := range instances {
+		logr := logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.Libvirt)
+		if err := p.destroyDomain(ctx, instance.ID); err != nil {
+			logr.WithError(err).Errorln("libvirt: failed to destroy domain")
+			failed = append(failed, instance)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		logr.Debugln("libvirt: domain destroyed")
+	}
+	return failed, firstErr
+}
+
+// Logs returns the serial console output of the domain.
+func (p *config) Logs(ctx context.Context, instanceID string) (string, error) {
+	_, _, consolePath := p.paths(instanceID)
+	out, err := p.conn.ReadFile(ctx, consolePath)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Hibernate saves the domain memory to disk with managed save and stops it.
+func (p *config) Hibernate(ctx context.Context, instanceID, _, _ string) error {
+	if err := p.conn.ManagedSave(ctx, instanceID); err != nil {
+		logger.FromContext(ctx).
+			WithField("id", instanceID).
+			WithField("driver", types.Libvirt).
+			WithError(err).
+			Errorln("libvirt: managed save failed")
+		return err
+	}
+	return nil
+}
+
+// Start restores a hibernated domain from its managed save image and returns its address.
+func (p *config) Start(ctx context.Context, instance *types.Instance, _ string) (string, error) {
+	state, err := p.conn.DomainState(ctx, instance.ID)
+	if err != nil {
+		return "", err
+	}
+	if state != domainStateRunning {
+		if err = p.conn.StartDomain(ctx, instance.ID); err != nil {
+			logger.FromContext(ctx).
+				WithField("id", instance.ID).
+				WithField("driver", types.Libvirt).
+				WithError(err).
+				Errorln("libvirt: failed to restore domain")
+			return "", err
+		}
+	}
+	return p.waitForIP(ctx, macForDomain(instance.ID))
+}
+
+func (p *config) SetTags(ctx context.Context, instance *types.Instance,
+	tags map[string]string) error {
+	return nil
+}
+
+func (p *config) SetLabels(context.Context, *types.Instance, map[string]string) error {
+	return nil
+}
+
+// waitForIP polls the DHCP lease file until the MAC address has been given an address.
+func (p *config) waitForIP(ctx context.Context, mac string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.ipTimeout)
+	defer cancel()
+	interval := time.Duration(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("libvirt: no DHCP lease for %s: %w", mac, ctx.Err())
+		case <-time.After(interval):
+			interval = p.pollInterval
+			data, err := p.conn.ReadFile(ctx, p.leaseFile)
+			if err != nil {
+				return "", err
+			}
+			ip, err := findLeaseIP(data, mac)
+			if err != nil {
+				return "", err
+			}
+			if ip != "" {
+				return ip, nil
+			}
+		}
+	}
+}
+
+func (p *config) destroyDomain(ctx context.Context, name string) error {
+	if err := p.conn.DestroyDomain(ctx, name); err != nil && !errors.Is(err, ErrDomainNotFound) {
+		return err
+	}
+	if err := p.conn.UndefineDomain(ctx, name); err != nil && !errors.Is(err, ErrDomainNotFound) {
+		return err
+	}
+	diskPath, seedPath, consolePath := p.paths(name)
+	for _, path := range []string{diskPath, seedPath, consolePath} {
+		if err := p.conn.RemoveFile(ctx, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *config) cleanup(ctx context.Context, name string, logr logger.Logger) {
+	if err := p.destroyDomain(ctx, name); err != nil {
+		logr.WithError(err).Warnln("libvirt: failed to clean up after failed create")
+	}
+}
+
+func (p *config) paths(name string) (diskPath, seedPath, consolePath string) {
+	return filepath.Join(p.storagePath, name+".qcow2"),
+		filepath.Join(p.storagePath, name+"-seed.iso"),
+		filepath.Join(p.storagePath, name+".console.log")
+}
@@ -0,0 +1,429 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/drivertest"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+const testLeaseFile = "/leases/virbr0.status"
+
+// fakeConnection is an in-memory hypervisor. Starting a domain hands out a
+// DHCP lease for its MAC address unless withholdLease is set.
+type fakeConnection struct {
+	mu            sync.Mutex
+	domains       map[string]*domain
+	states        map[string]string
+	saved         map[string]bool
+	files         map[string]string
+	leases        []dnsmasqStatus
+	calls         []string
+	withholdLease bool
+	failStart     error
+}
+
+func newFakeConnection() *fakeConnection {
+	return &fakeConnection{
+		domains: map[string]*domain{},
+		states:  map[string]string{},
+		saved:   map[string]bool{},
+		files:   map[string]string{},
+	}
+}
+
+func (f *fakeConnection) record(call string) {
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeConnection) Ping(context.Context) error { return nil }
+
+func (f *fakeConnection) CreateOverlay(_ context.Context, backingPath, path string, sizeGB int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("overlay:" + backingPath)
+	f.files[path] = fmt.Sprintf("qcow2 backed by %s size %d", backingPath, sizeGB)
+	return nil
+}
+
+func (f *fakeConnection) CreateSeed(_ context.Context, path, userData, metaData string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("seed")
+	f.files[path] = metaData + "\n" + userData
+	return nil
+}
+
+func (f *fakeConnection) DefineDomain(_ context.Context, data string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := new(domain)
+	if err := xml.Unmarshal([]byte(data), d); err != nil {
+		return err
+	}
+	f.record("define:" + d.Name)
+	f.domains[d.Name] = d
+	f.states[d.Name] = "shut off"
+	return nil
+}
+
+func (f *fakeConnection) StartDomain(_ context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("start:" + name)
+	if f.failStart != nil {
+		return f.failStart
+	}
+	d, ok := f.domains[name]
+	if !ok {
+		return ErrDomainNotFound
+	}
+	f.states[name] = domainStateRunning
+	delete(f.saved, name)
+	if !f.withholdLease {
+		mac := d.Devices.Interfaces[0].MAC.Address
+		f.leases = append(f.leases, dnsmasqStatus{
+			IPAddress:  fmt.Sprintf("192.168.122.%d", len(f.leases)+10),
+			MACAddress: mac,
+			ExpiryTime: time.Now().Unix() + int64(len(f.leases)),
+		})
+	}
+	return nil
+}
+
+func (f *fakeConnection) ManagedSave(_ context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("managedsave:" + name)
+	if _, ok := f.domains[name]; !ok {
+		return ErrDomainNotFound
+	}
+	f.states[name] = "shut off"
+	f.saved[name] = true
+	return nil
+}
+
+func (f *fakeConnection) DestroyDomain(_ context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("destroy:" + name)
+	if _, ok := f.domains[name]; !ok {
+		return ErrDomainNotFound
+	}
+	f.states[name] = "shut off"
+	return nil
+}
+
+func (f *fakeConnection) UndefineDomain(_ context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("undefine:" + name)
+	if _, ok := f.domains[name]; !ok {
+		return ErrDomainNotFound
+	}
+	delete(f.domains, name)
+	delete(f.states, name)
+	delete(f.saved, name)
+	return nil
+}
+
+func (f *fakeConnection) DomainState(_ context.Context, name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, ok := f.states[name]
+	if !ok {
+		return "", ErrDomainNotFound
+	}
+	return state, nil
+}
+
+func (f *fakeConnection) ReadFile(_ context.Context, path string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if path == testLeaseFile {
+		leases := make([]string, 0, len(f.leases))
+		for _, l := range f.leases {
+			leases = append(leases, fmt.Sprintf(`{"ip-address":%q,"mac-address":%q,"expiry-time":%d}`, l.IPAddress, l.MACAddress, l.ExpiryTime))
+		}
+		return []byte("[" + strings.Join(leases, ",") + "]"), nil
+	}
+	data, ok := f.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(data), nil
+}
+
+func (f *fakeConnection) RemoveFile(_ context.Context, path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, path)
+	return nil
+}
+
+func newTestConfig(t *testing.T, f *fakeConnection, opts ...Option) *config {
+	t.Helper()
+	opts = append([]Option{
+		WithConnection(f),
+		WithImage("/images/ubuntu-22.04.qcow2"),
+		WithStoragePath("/pool"),
+		WithNetwork("", testLeaseFile),
+		WithCPU(0),
+		WithMemory(0),
+		WithDiskSize(40),
+	}, opts...)
+	d, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	p := d.(*config)
+	p.pollInterval = time.Millisecond
+	p.ipTimeout = time.Second
+	return p
+}
+
+func TestNew_RequiresImage(t *testing.T) {
+	if _, err := New(WithConnection(newFakeConnection())); err == nil {
+		t.Fatal("expected error without image")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	f := newFakeConnection()
+	p := newTestConfig(t, f)
+
+	instance, err := p.Create(context.Background(), &types.InstanceCreateOpts{
+		PoolName:   "kvm",
+		RunnerName: "Runner",
+		Platform:   types.Platform{OS: "linux", Arch: "amd64"},
+		CACert:     []byte("ca-cert"),
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if instance.Provider != types.Libvirt || instance.ID != instance.Name || !strings.HasPrefix(instance.Name, "runner-kvm-") {
+		t.Errorf("unexpected instance %+v", instance)
+	}
+	if instance.Address != "192.168.122.10" {
+		t.Errorf("address = %q", instance.Address)
+	}
+	if instance.Image != "/images/ubuntu-22.04.qcow2" || instance.Size != "2cpu-4096mb" {
+		t.Errorf("unexpected image/size %q %q", instance.Image, instance.Size)
+	}
+
+	d := f.domains[instance.Name]
+	if d == nil {
+		t.Fatal("domain was not defined")
+	}
+	if d.Devices.Disks[0].Source.File != "/pool/"+instance.Name+".qcow2" {
+		t.Errorf("root disk = %q", d.Devices.Disks[0].Source.File)
+	}
+	if d.Devices.Disks[1].Device != "cdrom" || d.Devices.Disks[1].Source.File != "/pool/"+instance.Name+"-seed.iso" {
+		t.Errorf("seed disk = %+v", d.Devices.Disks[1])
+	}
+	if d.Devices.Interfaces[0].Source.Network != "default" || d.Devices.Interfaces[0].MAC.Address != macForDomain(instance.Name) {
+		t.Errorf("interface = %+v", d.Devices.Interfaces[0])
+	}
+	seed := f.files["/pool/"+instance.Name+"-seed.iso"]
+	if !strings.Contains(seed, "instance-id: "+instance.Name) || !strings.Contains(seed, "ca-cert") {
+		t.Errorf("seed does not contain cloud-init data: %q", seed)
+	}
+	if f.files["/pool/"+instance.Name+".qcow2"] != "qcow2 backed by /images/ubuntu-22.04.qcow2 size 40" {
+		t.Errorf("overlay = %q", f.files["/pool/"+instance.Name+".qcow2"])
+	}
+}
+
+func TestCreate_ImageOverride(t *testing.T) {
+	f := newFakeConnection()
+	p := newTestConfig(t, f)
+
+	instance, err := p.Create(context.Background(), &types.InstanceCreateOpts{
+		PoolName:      "kvm",
+		RunnerName:    "runner",
+		VMImageConfig: types.VMImageConfig{ImageName: "ubuntu-24.04.qcow2"},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if instance.Image != "/images/ubuntu-24.04.qcow2" {
+		t.Errorf("image = %q", instance.Image)
+	}
+}
+
+func TestCreate_CleansUpOnFailure(t *testing.T) {
+	t.Run("start failure", func(t *testing.T) {
+		f := newFakeConnection()
+		f.failStart = errors.New("no kvm")
+		p := newTestConfig(t, f)
+		if _, err := p.Create(context.Background(), &types.InstanceCreateOpts{PoolName: "kvm", RunnerName: "runner"}); err == nil {
+			t.Fatal("expected error")
+		}
+		if len(f.domains) != 0 || len(f.files) != 0 {
+			t.Errorf("expected cleanup, domains=%v files=%v", f.domains, f.files)
+		}
+	})
+	t.Run("no lease", func(t *testing.T) {
+		f := newFakeConnection()
+		f.withholdLease = true
+		p := newTestConfig(t, f)
+		p.ipTimeout = 20 * time.Millisecond
+		if _, err := p.Create(context.Background(), &types.InstanceCreateOpts{PoolName: "kvm", RunnerName: "runner"}); err == nil {
+			t.Fatal("expected error")
+		}
+		if len(f.domains) != 0 || len(f.files) != 0 {
+			t.Errorf("expected cleanup, domains=%v files=%v", f.domains, f.files)
+		}
+	})
+}
+
+func TestHibernateAndStart(t *testing.T) {
+	f := newFakeConnection()
+	p := newTestConfig(t, f, WithHibernate(true))
+	instance, err := p.Create(context.Background(), &types.InstanceCreateOpts{PoolName: "kvm", RunnerName: "runner"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err = p.Hibernate(context.Background(), instance.ID, "kvm", ""); err != nil {
+		t.Fatalf("Hibernate: %v", err)
+	}
+	if !f.saved[instance.ID] {
+		t.Fatal("expected managed save image")
+	}
+
+	ip, err := p.Start(context.Background(), instance, "kvm")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	// the restore renews the lease, the newest one wins
+	if ip != "192.168.122.11" {
+		t.Errorf("ip = %q", ip)
+	}
+	if f.saved[instance.ID] {
+		t.Error("expected managed save image to be consumed by restore")
+	}
+
+	// starting a running domain only looks up its address
+	calls := len(f.calls)
+	if _, err = p.Start(context.Background(), instance, "kvm"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if len(f.calls) != calls {
+		t.Errorf("unexpected hypervisor calls %v", f.calls[calls:])
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	f := newFakeConnection()
+	p := newTestConfig(t, f)
+	instance, err := p.Create(context.Background(), &types.InstanceCreateOpts{PoolName: "kvm", RunnerName: "runner"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// unknown domains are treated as already destroyed
+	failed, err := p.Destroy(context.Background(), []*types.Instance{instance, {ID: "gone"}})
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Destroy: failed=%v err=%v", failed, err)
+	}
+	if len(f.domains) != 0 || len(f.files) != 0 {
+		t.Errorf("expected everything removed, domains=%v files=%v", f.domains, f.files)
+	}
+	if _, err = p.Destroy(context.Background(), nil); err == nil {
+		t.Error("expected error for empty instance list")
+	}
+}
+
+func TestLogs(t *testing.T) {
+	f := newFakeConnection()
+	p := newTestConfig(t, f)
+	f.files["/pool/vm.console.log"] = "booting"
+
+	logs, err := p.Logs(context.Background(), "vm")
+	if err != nil || logs != "booting" {
+		t.Errorf("Logs = %q, %v", logs, err)
+	}
+}
+
+func TestFindLeaseIP(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		mac  string
+		want string
+	}{
+		{
+			name: "empty",
+			data: "",
+			mac:  "52:54:00:aa:bb:cc",
+		},
+		{
+			name: "status file picks latest lease",
+			data: `[
+  {"ip-address":"192.168.122.5","mac-address":"52:54:00:aa:bb:cc","expiry-time":100},
+  {"ip-address":"192.168.122.9","mac-address":"52:54:00:AA:BB:CC","expiry-time":200},
+  {"ip-address":"fd00::1","mac-address":"52:54:00:aa:bb:cc","expiry-time":300},
+  {"ip-address":"192.168.122.7","mac-address":"52:54:00:00:00:01","expiry-time":400}
+]`,
+			mac:  "52:54:00:aa:bb:cc",
+			want: "192.168.122.9",
+		},
+		{
+			name: "dnsmasq leases file",
+			data: "1700000000 52:54:00:00:00:01 10.0.0.2 other *\n1700000100 52:54:00:aa:bb:cc 10.0.0.3 vm 01:52:54:00:aa:bb:cc\n",
+			mac:  "52:54:00:aa:bb:cc",
+			want: "10.0.0.3",
+		},
+		{
+			name: "no match",
+			data: "1700000000 52:54:00:00:00:01 10.0.0.2 other *\n",
+			mac:  "52:54:00:aa:bb:cc",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := findLeaseIP([]byte(test.data), test.mac)
+			if err != nil {
+				t.Fatalf("findLeaseIP: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMacForDomain(t *testing.T) {
+	a, b := macForDomain("vm-a"), macForDomain("vm-b")
+	if a != macForDomain("vm-a") {
+		t.Error("expected a stable MAC address")
+	}
+	if a == b || !strings.HasPrefix(a, "52:54:00:") {
+		t.Errorf("unexpected MAC addresses %q %q", a, b)
+	}
+}
+
+func (f *fakeConnection) Exists(instanceID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.domains[instanceID]
+	return ok
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, drivertest.Config{
+		NewDriver: func(t *testing.T) (drivers.Driver, drivertest.Backend) {
+			f := newFakeConnection()
+			return newTestConfig(t, f, WithHibernate(true)), f
+		},
+	})
+}
@@ -0,0 +1,148 @@
+package libvirt
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/app/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+type Option func(*config)
+
+func SetPlatformDefaults(platform *types.Platform) (*types.Platform, error) {
+	if platform.Arch == "" {
+		platform.Arch = oshelp.ArchAMD64
+	}
+	if platform.Arch != oshelp.ArchAMD64 {
+		return platform, fmt.Errorf("invalid arch %s, has to be '%s'", platform.Arch, oshelp.ArchAMD64)
+	}
+	// verify that we are using sane values for OS
+	if platform.OS == "" {
+		platform.OS = oshelp.OSLinux
+	}
+	if platform.OS != oshelp.OSLinux {
+		return platform, fmt.Errorf("libvirt - invalid OS %s, has to be '%s'", platform.OS, oshelp.OSLinux)
+	}
+	// set osname
+	if platform.OSName == "" {
+		platform.OSName = oshelp.Ubuntu
+	}
+	return platform, nil
+}
+
+// WithURI sets the libvirt connection URI.
+func WithURI(uri string) Option {
+	return func(p *config) {
+		if uri == "" {
+			p.uri = "qemu:///system"
+		} else {
+			p.uri = uri
+		}
+	}
+}
+
+// WithConnection overrides the hypervisor connection.
+func WithConnection(conn Connection) Option {
+	return func(p *config) {
+		p.conn = conn
+	}
+}
+
+// WithImage sets the path of the qcow2 backing image.
+func WithImage(image string) Option {
+	return func(p *config) {
+		p.image = image
+	}
+}
+
+// WithStoragePath sets the directory holding instance disks and seeds.
+func WithStoragePath(path string) Option {
+	return func(p *config) {
+		if path == "" {
+			p.storagePath = "/var/lib/libvirt/images"
+		} else {
+			p.storagePath = path
+		}
+	}
+}
+
+// WithNetwork sets the libvirt network and the DHCP lease file used to
+// discover guest addresses.
+func WithNetwork(network, leaseFile string) Option {
+	return func(p *config) {
+		if network == "" {
+			network = "default"
+		}
+		if leaseFile == "" {
+			leaseFile = "/var/lib/libvirt/dnsmasq/virbr0.status"
+		}
+		p.network = network
+		p.leaseFile = leaseFile
+	}
+}
+
+func WithCPU(cpus int64) Option {
+	return func(p *config) {
+		if cpus <= 0 {
+			p.cpus = 2
+		} else {
+			p.cpus = cpus
+		}
+	}
+}
+
+func WithMemory(memoryMB int64) Option {
+	return func(p *config) {
+		if memoryMB <= 0 {
+			p.memoryMB = 4096
+		} else {
+			p.memoryMB = memoryMB
+		}
+	}
+}
+
+// WithDiskSize sets the overlay disk size in gigabytes, 0 keeps the backing image size.
+func WithDiskSize(diskSizeGB int64) Option {
+	return func(p *config) {
+		p.diskSizeGB = diskSizeGB
+	}
+}
+
+func WithHibernate(hibernate bool) Option {
+	return func(p *config) {
+		p.hibernate = hibernate
+	}
+}
+
+func WithUserData(text, path string) Option {
+	if text != "" {
+		return func(p *config) {
+			p.userData = text
+		}
+	}
+	return func(p *config) {
+		if path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logrus.WithError(err).
+					Fatalln("failed to read user_data file")
+				return
+			}
+			p.userData = string(data)
+		}
+	}
+}
+
+// WithRootDirectory sets the root directory for the virtual machine.
+func WithRootDirectory(dir string) Option {
+	return func(p *config) {
+		if dir == "" {
+			p.rootDir = oshelp.JoinPaths(oshelp.OSLinux, "/tmp", "libvirt")
+		} else {
+			p.rootDir = dir
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package libvirt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// domain is the subset of the libvirt domain XML schema used by the driver.
+type domain struct {
+	XMLName xml.Name      `xml:"domain"`
+	Type    string        `xml:"type,attr"`
+	Name    string        `xml:"name"`
+	Memory  domainMemory  `xml:"memory"`
+	VCPU    int64         `xml:"vcpu"`
+	OS      domainOS      `xml:"os"`
+	Feature domainFeature `xml:"features"`
+	CPU     domainCPU     `xml:"cpu"`
+	Devices domainDevices `xml:"devices"`
+}
+
+type domainMemory struct {
+	Unit  string `xml:"unit,attr"`
+	Value int64  `xml:",chardata"`
+}
+
+type domainOS struct {
+	Type struct {
+		Arch    string `xml:"arch,attr"`
+		Machine string `xml:"machine,attr"`
+		Value   string `xml:",chardata"`
+	} `xml:"type"`
+	Boot struct {
+		Dev string `xml:"dev,attr"`
+	} `xml:"boot"`
+}
+
+type domainFeature struct {
+	ACPI *struct{} `xml:"acpi"`
+	APIC *struct{} `xml:"apic"`
+}
+
+type domainCPU struct {
+	Mode string `xml:"mode,attr"`
+}
+
+type domainDevices struct {
+	Disks      []domainDisk      `xml:"disk"`
+	Interfaces []domainInterface `xml:"interface"`
+	Serials    []domainSerial    `xml:"serial"`
+}
+
+type domainDisk struct {
+	Type   string `xml:"type,attr"`
+	Device string `xml:"device,attr"`
+	Driver struct {
+		Name string `xml:"name,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"driver"`
+	Source struct {
+		File string `xml:"file,attr"`
+	} `xml:"source"`
+	Target struct {
+		Dev string `xml:"dev,attr"`
+		Bus string `xml:"bus,attr"`
+	} `xml:"target"`
+	ReadOnly *struct{} `xml:"readonly"`
+}
+
+type domainInterface struct {
+	Type   string `xml:"type,attr"`
+	Source struct {
+		Network string `xml:"network,attr"`
+	} `xml:"source"`
+	MAC struct {
+		Address string `xml:"address,attr"`
+	} `xml:"mac"`
+	Model struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+}
+
+type domainSerial struct {
+	Type   string `xml:"type,attr"`
+	Source struct {
+		Path string `xml:"path,attr"`
+	} `xml:"source"`
+	Target struct {
+		Port int `xml:"port,attr"`
+	} `xml:"target"`
+}
+
+type domainParams struct {
+	name        string
+	cpus        int64
+	memoryMB    int64
+	diskPath    string
+	seedPath    string
+	network     string
+	mac         string
+	consolePath string
+}
+
+// buildDomainXML renders the KVM domain definition for a new instance.
+func buildDomainXML(params *domainParams) (string, error) {
+	d := domain{
+		Type:   "kvm",
+		Name:   params.name,
+		Memory: domainMemory{Unit: "MiB", Value: params.memoryMB},
+		VCPU:   params.cpus,
+		CPU:    domainCPU{Mode: "host-passthrough"},
+		Feature: domainFeature{
+			ACPI: &struct{}{},
+			APIC: &struct{}{},
+		},
+	}
+	d.OS.Type.Arch = "x86_64"
+	d.OS.Type.Machine = "q35"
+	d.OS.Type.Value = "hvm"
+	d.OS.Boot.Dev = "hd"
+
+	var root domainDisk
+	root.Type = "file"
+	root.Device = "disk"
+	root.Driver.Name = "qemu"
+	root.Driver.Type = "qcow2"
+	root.Source.File = params.diskPath
+	root.Target.Dev = "vda"
+	root.Target.Bus = "virtio"
+
+	var seed domainDisk
+	seed.Type = "file"
+	seed.Device = "cdrom"
+	seed.Driver.Name = "qemu"
+	seed.Driver.Type = "raw"
+	seed.Source.File = params.seedPath
+	seed.Target.Dev = "sda"
+	seed.Target.Bus = "sata"
+	seed.ReadOnly = &struct{}{}
+
+	var nic domainInterface
+	nic.Type = "network"
+	nic.Source.Network = params.network
+	nic.MAC.Address = params.mac
+	nic.Model.Type = "virtio"
+
+	var serial domainSerial
+	serial.Type = "file"
+	serial.Source.Path = params.consolePath
+
+	d.Devices = domainDevices{
+		Disks:      []domainDisk{root, seed},
+		Interfaces: []domainInterface{nic},
+		Serials:    []domainSerial{serial},
+	}
+
+	out, err := xml.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// macForDomain derives a stable MAC address in the QEMU/KVM OUI range from the
+// domain name, so the lease can be looked up again after a restore without
+// persisting the address anywhere.
+func macForDomain(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", sum[0], sum[1], sum[2])
+}
+
+// dnsmasqStatus is an entry of the JSON status file libvirt maintains for
+// each virtual network, e.g. /var/lib/libvirt/dnsmasq/virbr0.status.
+type dnsmasqStatus struct {
+	IPAddress  string `json:"ip-address"`
+	MACAddress string `json:"mac-address"`
+	Hostname   string `json:"hostname"`
+	ExpiryTime int64  `json:"expiry-time"`
+}
+
+// findLeaseIP looks up the IPv4 address leased to mac. It understands both the
+// libvirt JSON status file and the classic dnsmasq leases file format
+// ("<expiry> <mac> <ip> <hostname> <client-id>"). When several leases exist for
+// the same MAC, the one with the latest expiry wins.
+func findLeaseIP(data []byte, mac string) (string, error) {
+	mac = strings.ToLower(mac)
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return "", nil
+	}
+
+	var ip string
+	var expiry int64 = -1
+	if trimmed[0] == '[' {
+		var entries []dnsmasqStatus
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return "", fmt.Errorf("libvirt: cannot parse lease status file: %w", err)
+		}
+		for _, e := range entries {
+			if strings.ToLower(e.MACAddress) == mac && !strings.Contains(e.IPAddress, ":") && e.ExpiryTime > expiry {
+				ip, expiry = e.IPAddress, e.ExpiryTime
+			}
+		}
+		return ip, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 { //nolint:mnd
+			continue
+		}
+		var e int64
+		if _, err := fmt.Sscan(fields[0], &e); err != nil {
+			continue
+		}
+		if strings.ToLower(fields[1]) == mac && !strings.Contains(fields[2], ":") && e > expiry {
+			ip, expiry = fields[2], e
+		}
+	}
+	return ip, scanner.Err()
+}
@@ -0,0 +1,73 @@
+package drivers
+
+import (
+	"context"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// MachineTypeFallbackRecorder records a create moving on to the next machine
+// type. It is implemented by *metric.Metrics.
+type MachineTypeFallbackRecorder interface {
+	RecordMachineTypeFallback(driver, from, to string)
+}
+
+// MachineTypes returns the ordered, de-duplicated machine types a create walks.
+// A machine type requested by the stage or variant comes with its own
+// fallbacks; otherwise the pool's machine type and fallbacks are used.
+func MachineTypes(opts *types.InstanceCreateOpts, poolMachineType string, poolFallbacks []string) []string {
+	primary, fallbacks := poolMachineType, poolFallbacks
+	if opts != nil && opts.MachineType != "" {
+		primary, fallbacks = opts.MachineType, opts.MachineTypeFallbacks
+	}
+
+	machineTypes := []string{primary}
+	seen := map[string]bool{primary: true}
+	for _, machineType := range fallbacks {
+		if machineType == "" || seen[machineType] {
+			continue
+		}
+		seen[machineType] = true
+		machineTypes = append(machineTypes, machineType)
+	}
+	return machineTypes
+}
+
+// CreateWithMachineTypeFallback calls create for each machine type in order.
+// It only moves on to the next machine type when create reports a stockout,
+// which drivers return once the machine type is out of capacity in every zone
+// they tried. cleanup, when set, removes what the failed attempt left behind
+// before the next machine type is tried. It returns the machine type of the
+// last attempt.
+func CreateWithMachineTypeFallback(
+	ctx context.Context,
+	driver string,
+	machineTypes []string,
+	isStockout func(error) bool,
+	metrics MachineTypeFallbackRecorder,
+	create func(machineType string) error,
+	cleanup func(machineType string),
+) (string, error) {
+	logr := logger.FromContext(ctx).WithField("driver", driver)
+	var err error
+	for i, machineType := range machineTypes {
+		err = create(machineType)
+		if err == nil || !isStockout(err) || i == len(machineTypes)-1 {
+			return machineType, err
+		}
+		next := machineTypes[i+1]
+		logr.WithError(err).
+			WithField("machine_type", machineType).
+			WithField("next_machine_type", next).
+			Warnln("provision: machine type out of capacity in every zone, falling back to next machine type")
+		if metrics != nil {
+			metrics.RecordMachineTypeFallback(driver, machineType, next)
+		}
+		if cleanup != nil {
+			cleanup(machineType)
+		}
+	}
+	return "", err
+}
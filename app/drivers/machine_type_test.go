@@ -0,0 +1,117 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+var errTestStockout = errors.New("stockout")
+
+func isTestStockout(err error) bool {
+	return errors.Is(err, errTestStockout)
+}
+
+type fallbackRecorder struct {
+	fallbacks [][2]string
+}
+
+func (r *fallbackRecorder) RecordMachineTypeFallback(_, from, to string) {
+	r.fallbacks = append(r.fallbacks, [2]string{from, to})
+}
+
+func TestMachineTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *types.InstanceCreateOpts
+		want []string
+	}{
+		{
+			name: "pool machine type and fallbacks",
+			opts: &types.InstanceCreateOpts{},
+			want: []string{"n2-standard-4", "n2d-standard-4", "e2-standard-4"},
+		},
+		{
+			name: "requested machine type uses its own fallbacks",
+			opts: &types.InstanceCreateOpts{MachineType: "c4d-standard-8", MachineTypeFallbacks: []string{"n2d-standard-8"}},
+			want: []string{"c4d-standard-8", "n2d-standard-8"},
+		},
+		{
+			name: "requested machine type without fallbacks",
+			opts: &types.InstanceCreateOpts{MachineType: "c4d-standard-8"},
+			want: []string{"c4d-standard-8"},
+		},
+		{
+			name: "duplicates and empty entries are dropped",
+			opts: &types.InstanceCreateOpts{MachineType: "a", MachineTypeFallbacks: []string{"b", "", "a", "b", "c"}},
+			want: []string{"a", "b", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MachineTypes(tt.opts, "n2-standard-4", []string{"n2d-standard-4", "e2-standard-4"})
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCreateWithMachineTypeFallback(t *testing.T) {
+	var tried, cleaned []string
+	recorder := &fallbackRecorder{}
+	machineType, err := CreateWithMachineTypeFallback(context.Background(), "google", []string{"a", "b", "c"}, isTestStockout, recorder,
+		func(machineType string) error {
+			tried = append(tried, machineType)
+			if machineType == "c" {
+				return nil
+			}
+			return errTestStockout
+		},
+		func(machineType string) {
+			cleaned = append(cleaned, machineType)
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, "c", machineType)
+	assert.Equal(t, []string{"a", "b", "c"}, tried)
+	assert.Equal(t, []string{"a", "b"}, cleaned)
+	assert.Equal(t, [][2]string{{"a", "b"}, {"b", "c"}}, recorder.fallbacks)
+}
+
+func TestCreateWithMachineTypeFallback_OtherErrorsFailFast(t *testing.T) {
+	var tried []string
+	machineType, err := CreateWithMachineTypeFallback(context.Background(), "google", []string{"a", "b"}, isTestStockout, nil,
+		func(machineType string) error {
+			tried = append(tried, machineType)
+			return assert.AnError
+		}, nil)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, "a", machineType)
+	assert.Equal(t, []string{"a"}, tried)
+}
+
+func TestCreateWithMachineTypeFallback_AllStockedOut(t *testing.T) {
+	var cleaned []string
+	machineType, err := CreateWithMachineTypeFallback(context.Background(), "google", []string{"a", "b"}, isTestStockout, nil,
+		func(string) error { return errTestStockout },
+		func(machineType string) { cleaned = append(cleaned, machineType) })
+	assert.ErrorIs(t, err, errTestStockout)
+	assert.Equal(t, "b", machineType)
+	// the last attempt is left for the caller to clean up
+	assert.Equal(t, []string{"a"}, cleaned)
+}
+
+func TestApplyVariantToSetupParams_MachineTypeFallbacks(t *testing.T) {
+	params := &types.SetupInstanceParams{MachineType: "requested", MachineTypeFallbacks: []string{"requested-alt"}}
+	applyVariantToSetupParams(params, &types.PoolVariant{SetupInstanceParams: types.SetupInstanceParams{
+		VariantID: "v1", MachineType: "variant", MachineTypeFallbacks: []string{"variant-alt"},
+	}})
+	assert.Equal(t, "variant", params.MachineType)
+	assert.Equal(t, []string{"variant-alt"}, params.MachineTypeFallbacks)
+
+	copied := deepCopySetupParams(params)
+	copied.MachineTypeFallbacks[0] = "changed"
+	assert.Equal(t, []string{"variant-alt"}, params.MachineTypeFallbacks)
+}
@@ -46,6 +46,10 @@ type (
 		capacityReservationTTL       int64 // seconds; GCP auto-deletes reservations after this duration
 		hosted                       bool
 		enableLEDiagnostics          bool
+		purgerDryRun                 bool
+		purgerSettings               PurgerSettings // max ages the purger was started with, reported by ExplainPurger
+		busyLeaseTTL                 time.Duration
+		hibernationPolicy            bool
 		metrics                      MetricsRecorder
 	}
 
@@ -2,6 +2,7 @@ package drivers
 
 import (
 	"context"
+	"time"
 
 	"github.com/drone-runners/drone-runner-aws/command/config"
 	"github.com/drone-runners/drone-runner-aws/store"
@@ -45,7 +46,10 @@ type ManagerConfig struct {
 	TmateBinaryFallbackURI       string
 	Hosted                       bool
 	EnableLEDiagnostics          bool
-	CapacityReservationTTL       int64 // seconds; GCP auto-deletes reservations after this duration
+	CapacityReservationTTL       int64         // seconds; GCP auto-deletes reservations after this duration
+	PurgerDryRun                 bool          // distributed mode only; the purger logs what it would purge
+	BusyLeaseTTL                 time.Duration // distributed mode only; 0 reclaims busy instances by max age
+	HibernationPolicy            bool          // distributed mode only; the hibernation policy job hibernates free instances
 }
 
 // NewManagerFromConfig creates a new Manager from a ManagerConfig.
@@ -76,6 +80,9 @@ func NewManagerFromConfig(cfg *ManagerConfig) *Manager {
 		hosted:                       cfg.Hosted,
 		enableLEDiagnostics:          cfg.EnableLEDiagnostics,
 		capacityReservationTTL:       cfg.CapacityReservationTTL,
+		purgerDryRun:                 cfg.PurgerDryRun,
+		busyLeaseTTL:                 cfg.BusyLeaseTTL,
+		hibernationPolicy:            cfg.HibernationPolicy,
 	}
 }
 
@@ -105,6 +112,8 @@ func NewManagerConfigFromEnv(ctx context.Context, instanceStore store.InstanceSt
 		TmateBinaryFallbackURI:       envConfig.Settings.TmateBinaryFallbackURI,
 		EnableLEDiagnostics:          envConfig.Settings.EnableLEDiagnostics,
 		CapacityReservationTTL:       envConfig.Settings.FreeCapacityMaxAgeMinutes * 60,
+		PurgerDryRun:                 envConfig.Settings.PurgerDryRun,
+		BusyLeaseTTL:                 time.Duration(envConfig.Settings.BusyLeaseTTLMinutes) * time.Minute,
 	}
 }
 
@@ -6,6 +6,7 @@ package drivers
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"testing"
 	"time"
@@ -30,6 +31,7 @@ type mockInstanceStore struct {
 	UpdateFunc                func(ctx context.Context, instance *types.Instance) error
 	FindAndClaimFunc          func(ctx context.Context, params *types.QueryParams, newState types.InstanceState, allowedStates []types.InstanceState, updateStartTime bool) (*types.Instance, error)
 	CountGroupedInstancesFunc func(ctx context.Context, status types.InstanceState) ([]types.InstanceCount, error)
+	DeleteAndReturnFunc       func(ctx context.Context, query string, args ...any) ([]*types.Instance, error)
 }
 
 func (m *mockInstanceStore) Find(ctx context.Context, id string) (*types.Instance, error) {
@@ -72,6 +74,9 @@ func (m *mockInstanceStore) Purge(ctx context.Context) error {
 }
 
 func (m *mockInstanceStore) DeleteAndReturn(ctx context.Context, query string, args ...any) ([]*types.Instance, error) {
+	if m.DeleteAndReturnFunc != nil {
+		return m.DeleteAndReturnFunc(ctx, query, args...)
+	}
 	return nil, nil
 }
 
@@ -88,6 +93,25 @@ func (m *mockInstanceStore) FindAndClaim(
 	return nil, errors.New("not implemented")
 }
 
+// claimByID returns a FindAndClaimFunc that claims one of the given instances
+// by ID the way the stores do, only while it is in one of the allowed states.
+func claimByID(instances ...*types.Instance) func(context.Context, *types.QueryParams, types.InstanceState, []types.InstanceState, bool) (*types.Instance, error) {
+	return func(_ context.Context, params *types.QueryParams, newState types.InstanceState, allowedStates []types.InstanceState, _ bool) (*types.Instance, error) {
+		for _, inst := range instances {
+			if inst.ID != params.InstanceID {
+				continue
+			}
+			for _, state := range allowedStates {
+				if inst.State == state {
+					inst.State = newState
+					return inst, nil
+				}
+			}
+		}
+		return nil, sql.ErrNoRows
+	}
+}
+
 func (m *mockInstanceStore) CountGroupedInstances(ctx context.Context, status types.InstanceState) ([]types.InstanceCount, error) {
 	if m.CountGroupedInstancesFunc != nil {
 		return m.CountGroupedInstancesFunc(ctx, status)
@@ -44,6 +44,9 @@ type config struct {
 	clientKeyPath     string
 	insecure          bool
 	noop              bool
+	hibernate         bool
+	placementSpec     *cf.NomadPlacement
+	placement         *placement
 	enablePinning     map[string]string
 	client            *api.Client
 	virtualizer       Virtualizer
@@ -92,6 +95,9 @@ func New(opts ...Option) (drivers.Driver, error) {
 	for _, opt := range opts {
 		opt(p)
 	}
+	if p.placement, err = newPlacement(p.placementSpec); err != nil {
+		return nil, err
+	}
 	if p.client == nil {
 		client, err := NewClient(p.address, p.insecure, p.caCertPath, p.clientCertPath, p.clientKeyPath, p.nomadToken)
 		if err != nil {
@@ -100,7 +106,9 @@ func New(opts ...Option) (drivers.Driver, error) {
 		p.client = client
 	}
 	if p.virtualizerEngine == "tart" {
-		p.virtualizer = NewMacVirtualizer(p.nomadConfig)
+		mv := NewMacVirtualizer(p.nomadConfig)
+		mv.suspendable = p.hibernate
+		p.virtualizer = mv
 	} else {
 		p.virtualizer = NewLinuxVirtualizer(p.nomadConfig)
 	}
@@ -116,7 +124,7 @@ func (p *config) RootDir() string {
 }
 
 func (p *config) CanHibernate() bool {
-	return false
+	return p.hibernate
 }
 
 func (p *config) GetFullyQualifiedImage(_ context.Context, config *types.VMImageConfig) (string, error) {
@@ -193,7 +201,16 @@ func (p *config) createAndRunResourceJob(ctx context.Context, opts *types.Instan
 	if p.noop {
 		resourceJob, resourceJobID = p.resourceJobNoop(cpus, memGB, vm, len(opts.GitspaceOpts.Ports))
 	} else {
-		resourceJob, resourceJobID = p.resourceJob(cpus, memGB, p.virtualizer.GetMachineFrequency(), len(opts.GitspaceOpts.Ports), vm, class, vmImageConfig, p.virtualizer.GetHealthCheckupGenerator())
+		params := placementParams{
+			Account:       opts.AccountID,
+			NodeClass:     class,
+			Image:         vmImageConfig.ImageName,
+			ResourceClass: opts.ResourceClass,
+		}
+		resourceJob, resourceJobID, err = p.resourceJob(cpus, memGB, p.virtualizer.GetMachineFrequency(), len(opts.GitspaceOpts.Ports), vm, params, vmImageConfig, p.virtualizer.GetHealthCheckupGenerator())
+		if err != nil {
+			return "", "", err
+		}
 	}
 
 	logr := logger.FromContext(ctx).WithField("vm", vm).WithField("node_class", class).WithField("resource_job_id", resourceJobID)
@@ -466,7 +483,7 @@ func (p *config) checkTaskGroupStatus(jobID, taskGroup string) error {
 }
 
 // resourceJob creates a job which occupies resources until the VM lifecycle
-func (p *config) resourceJob(cpus, memGB, machineFrequencyMhz, gitspacesPortCount int, vm, accountID string, vmImageConfig types.VMImageConfig, healthCheckGenerator func(time.Duration, string, string) string) (job *api.Job, id string) { //nolint
+func (p *config) resourceJob(cpus, memGB, machineFrequencyMhz, gitspacesPortCount int, vm string, params placementParams, vmImageConfig types.VMImageConfig, healthCheckGenerator func(time.Duration, string, string) string) (job *api.Job, id string, err error) { //nolint
 	id = getResourceJobID(vm)
 	portLabel := vm
 
@@ -477,12 +494,13 @@ func (p *config) resourceJob(cpus, memGB, machineFrequencyMhz, gitspacesPortCoun
 	cpu := machineFrequencyMhz*cpus - 109
 	mem := convertGigsToMegs(memGB) - 53
 
-	constraintList := []*api.Constraint{}
-	if accountID != "" {
-		constraintList = constraints(accountID)
+	pl := p.placement
+	if pl == nil {
+		pl = defaultPlacement
 	}
-	if !isSequoiaImage(vmImageConfig.ImageName) {
-		constraintList = append(constraintList, sequoiaExclusionConstraint())
+	constraintList, affinities, spreads, err := pl.render(params)
+	if err != nil {
+		return nil, id, err
 	}
 	// This job stays alive to keep resources on nomad busy until the VM is destroyed
 	// It sleeps until the max VM creation timeout, after which it periodically checks whether the VM is alive or not
@@ -497,6 +515,8 @@ func (p *config) resourceJob(cpus, memGB, machineFrequencyMhz, gitspacesPortCoun
 			Unlimited: boolToPtr(false),
 		},
 		Constraints: constraintList,
+		Affinities:  affinities,
+		Spreads:     spreads,
 		TaskGroups: []*api.TaskGroup{
 			{
 				Networks:                  getNetworkResources(portLabel, gitspacesPortCount),
@@ -524,7 +544,7 @@ func (p *config) resourceJob(cpus, memGB, machineFrequencyMhz, gitspacesPortCoun
 			},
 		},
 	}
-	return job, id
+	return job, id, nil
 }
 
 // fetchMachine returns details of the machine where the job has been allocated
@@ -712,6 +732,11 @@ func (p *config) DestroyInstanceAndStorage(ctx context.Context, instances []*typ
 		} else {
 			logr.WithError(err).Errorln("scheduler: could not free up resources")
 		}
+		// A hibernated VM holds its node through the hibernated resource job instead. The row may not
+		// say so yet, when the VM is destroyed while it is being hibernated, so it is always removed.
+		if err = p.deregisterJob(logr, getHibernatedResourceJobID(instance.ID), false); err != nil {
+			logr.WithError(err).Errorln("scheduler: could not free up resources of the hibernated VM")
+		}
 		logr.Infoln("scheduler: freed up resources, submitting destroy job")
 		_, _, err = p.client.Jobs().Register(job, nil)
 		if err != nil {
@@ -741,14 +766,6 @@ func (p *config) SetLabels(context.Context, *types.Instance, map[string]string)
 	return nil
 }
 
-func (p *config) Hibernate(_ context.Context, _, _, _ string) error {
-	return nil
-}
-
-func (p *config) Start(_ context.Context, _ *types.Instance, _ string) (string, error) {
-	return "", nil
-}
-
 // pollForJob polls on the status of the job and returns back once it is in a terminal state.
 // note: a dead job is always considered to be in a terminal state
 // if remove is set to true, it deregisters the job in case the job hasn't reached a terminal state
@@ -817,6 +834,10 @@ L:
 func (p *config) deregisterJob(logr logger.Logger, id string, purge bool) error { //nolint:unparam
 	logr.WithField("job_id", id).WithField("purge", purge).Traceln("scheduler: trying to deregister job")
 	_, _, err := p.client.Jobs().Deregister(id, purge, &api.WriteOptions{})
+	if isJobNotFound(err) {
+		logr.WithField("job_id", id).Traceln("scheduler: job not found, nothing to deregister")
+		return nil
+	}
 	if err != nil {
 		logr.WithField("job_id", id).WithField("purge", purge).WithError(err).Errorln("scheduler: could not deregister job")
 		return err
@@ -825,6 +846,11 @@ func (p *config) deregisterJob(logr logger.Logger, id string, purge bool) error
 	return nil
 }
 
+// isJobNotFound reports whether a Nomad API call failed because the job does not exist.
+func isJobNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Unexpected response code: 404")
+}
+
 func (p *config) getAllocationsForJob(logr logger.Logger, id string) {
 	allocs, _, err := p.client.Jobs().Allocations(id, true, &api.QueryOptions{})
 	if err != nil || allocs == nil || len(allocs) == 0 || allocs[0] == nil {
@@ -975,35 +1001,6 @@ func getResourceJobID(s string) string {
 	return fmt.Sprintf("init_job_resources_%s", s)
 }
 
-func constraints(accountID string) []*api.Constraint {
-	constraintList := []*api.Constraint{}
-
-	constraint := &api.Constraint{
-		LTarget: "${node.class}",
-		RTarget: accountID,
-		Operand: "=",
-	}
-
-	constraintList = append(constraintList, constraint)
-	return constraintList
-}
-
-// isSequoiaImage reports whether the given image name refers to a Sequoia image.
-// Empty image names (pool default, which is Sonoma) return false.
-func isSequoiaImage(imageName string) bool {
-	return strings.Contains(strings.ToLower(imageName), "sequoia")
-}
-
-// sequoiaExclusionConstraint returns a Nomad constraint that excludes nodes
-// tagged with meta.sequoia_only=true.
-func sequoiaExclusionConstraint() *api.Constraint {
-	return &api.Constraint{
-		LTarget: "${meta.sequoia_only}",
-		RTarget: "true",
-		Operand: "!=",
-	}
-}
-
 // Request Nomad to assign available ports dynamically.
 // 1 for lite engine and another n ports for gitspaces as requested
 // Since the port labels have to be unique, VM ID is used usually.
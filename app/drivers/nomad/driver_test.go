@@ -1,6 +1,7 @@
 package nomad
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -67,13 +68,17 @@ func TestIsSequoiaImage(t *testing.T) {
 	}
 }
 
-// TestSequoiaExclusionConstraint verifies the constraint targets the correct
-// Nomad meta attribute with the != operand.
+// TestSequoiaExclusionConstraint verifies the constraint of the default
+// placement targets the correct Nomad meta attribute with the != operand.
 func TestSequoiaExclusionConstraint(t *testing.T) {
-	c := sequoiaExclusionConstraint()
-	if c == nil {
-		t.Fatal("sequoiaExclusionConstraint() returned nil")
+	constraints, _, _, err := defaultPlacement.render(placementParams{Image: "sonoma_14.6"})
+	if err != nil {
+		t.Fatal(err)
 	}
+	if len(constraints) != 1 {
+		t.Fatalf("expected only the sequoia exclusion constraint, got %d constraints", len(constraints))
+	}
+	c := constraints[0]
 	if c.LTarget != "${meta.sequoia_only}" {
 		t.Errorf("LTarget = %q, want %q", c.LTarget, "${meta.sequoia_only}")
 	}
@@ -152,7 +157,11 @@ func TestResourceJobSequoiaExclusionConstraint(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			vmImageConfig := types.VMImageConfig{ImageName: tc.imageName}
-			job, _ := p.resourceJob(6, 12, 3200, 0, "test-vm-id", tc.accountID, vmImageConfig, noopHealthCheck)
+			params := placementParams{NodeClass: tc.accountID, Image: tc.imageName}
+			job, _, err := p.resourceJob(6, 12, 3200, 0, "test-vm-id", params, vmImageConfig, noopHealthCheck)
+			if err != nil {
+				t.Fatal(err)
+			}
 
 			found := false
 			for _, c := range job.Constraints {
@@ -190,7 +199,11 @@ func TestResourceJobNodeClassConstraintPreserved(t *testing.T) {
 
 	noopHealthCheck := func(_ time.Duration, _, _ string) string { return "sleep 1" }
 	vmImageConfig := types.VMImageConfig{ImageName: "sonoma_14.6_xcodes_15.2"}
-	job, _ := p.resourceJob(6, 12, 3200, 0, "test-vm-id", "GLOBAL_ACCOUNT_ID_MAC", vmImageConfig, noopHealthCheck)
+	params := placementParams{NodeClass: "GLOBAL_ACCOUNT_ID_MAC", Image: vmImageConfig.ImageName}
+	job, _, err := p.resourceJob(6, 12, 3200, 0, "test-vm-id", params, vmImageConfig, noopHealthCheck)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	var nodeClassFound, sequoiaExclusionFound bool
 	for _, c := range job.Constraints {
@@ -209,3 +222,17 @@ func TestResourceJobNodeClassConstraintPreserved(t *testing.T) {
 		t.Error("sequoia exclusion constraint missing for sonoma image")
 	}
 }
+
+// TestIsJobNotFound verifies that deregistering a job that is already gone,
+// such as the hold of a VM that was never hibernated, is not an error.
+func TestIsJobNotFound(t *testing.T) {
+	if !isJobNotFound(errors.New("Unexpected response code: 404 (job not found)")) {
+		t.Error("expected a 404 to be reported as not found")
+	}
+	if isJobNotFound(errors.New("Unexpected response code: 500 (rpc error)")) {
+		t.Error("expected a 500 not to be reported as not found")
+	}
+	if isJobNotFound(nil) {
+		t.Error("expected no error not to be reported as not found")
+	}
+}
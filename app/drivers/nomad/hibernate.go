@@ -0,0 +1,385 @@
+package nomad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/hashicorp/nomad/api"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Meta keys of the hibernated resource job. They record the resources of the resource job the VM was
+// running with, so that the same resources can be reserved again when it is resumed.
+const (
+	metaVMCPUMhz   = "vm_cpu_mhz"
+	metaVMMemoryMB = "vm_memory_mb"
+)
+
+// Hibernate suspends the VM with a job targeted to its node. Once the VM is suspended, its resource job
+// is swapped for a hibernated resource job which keeps the node and ports of the VM, but frees the CPU
+// and, if the virtualizer writes the VM's memory to disk, the memory reserved for it.
+func (p *config) Hibernate(ctx context.Context, instanceID, _, _ string) error {
+	if p.noop {
+		return nil
+	}
+	resourceJobID := getResourceJobID(instanceID)
+	logr := logger.FromContext(ctx).
+		WithField("driver", p.DriverName()).
+		WithField("vm", instanceID).
+		WithField("resource_job_id", resourceJobID)
+
+	resourceJob, _, err := p.client.Jobs().Info(resourceJobID, &api.QueryOptions{})
+	if err != nil {
+		return fmt.Errorf("scheduler: could not query resource job, err: %w, vm: %s", err, instanceID)
+	}
+	if resourceJob == nil || isTerminal(resourceJob) {
+		return fmt.Errorf("scheduler: resource job is not running, vm: %s", instanceID)
+	}
+	cpu, mem, err := jobResources(resourceJob)
+	if err != nil {
+		return fmt.Errorf("scheduler: %w, vm: %s", err, instanceID)
+	}
+	_, nodeID, port, gitspacesPorts, err := p.fetchMachine(logr, resourceJobID)
+	if err != nil {
+		return err
+	}
+	logr = logr.WithField("node_id", nodeID)
+
+	suspendJob, suspendJobID, suspendGroup := p.suspendJob(instanceID, nodeID)
+	logr.WithField("suspend_job_id", suspendJobID).Infoln("scheduler: submitting VM suspend job")
+	if err = p.runJob(ctx, logr, suspendJob, suspendJobID, suspendGroup, p.nomadConfig.HibernateTimeout); err != nil {
+		return fmt.Errorf("scheduler: could not suspend VM, err: %w, suspend_job_id: %s, vm: %s", err, suspendJobID, instanceID)
+	}
+
+	if err = p.deregisterJob(logr, resourceJobID, false); err != nil {
+		// the VM keeps its resources, so it can be used again right away
+		p.resumeAfterFailedHibernate(ctx, logr, instanceID, nodeID, port)
+		return fmt.Errorf("scheduler: could not free up resources of the suspended VM, err: %w, vm: %s", err, instanceID)
+	}
+
+	hibernatedJob, hibernatedJobID := p.hibernatedResourceJob(instanceID, nodeID, append([]int{port}, gitspacesPorts...), cpu, mem)
+	logr = logr.WithField("hibernated_resource_job_id", hibernatedJobID)
+	if err = p.runResourceJob(ctx, logr, hibernatedJob, hibernatedJobID); err != nil {
+		// The VM is suspended either way. Resuming it reserves its resources again, and fails if they
+		// were given away in the meantime.
+		logr.WithError(err).Warnln("scheduler: could not hold the node of the suspended VM")
+		return nil
+	}
+	logr.Infoln("scheduler: VM suspended")
+	return nil
+}
+
+// Start resumes a hibernated VM. The resources the VM was running with are reserved again on its node
+// before the VM is resumed, so it fails if the node no longer has room for the VM. The VM then keeps
+// holding its node and ports, so it can be resumed later.
+func (p *config) Start(ctx context.Context, instance *types.Instance, _ string) (string, error) {
+	if p.noop {
+		return instance.Address, nil
+	}
+	hibernatedJobID := getHibernatedResourceJobID(instance.ID)
+	logr := logger.FromContext(ctx).
+		WithField("driver", p.DriverName()).
+		WithField("vm", instance.ID).
+		WithField("node_id", instance.NodeID).
+		WithField("hibernated_resource_job_id", hibernatedJobID)
+
+	cpu, mem, err := p.hibernatedResources(hibernatedJobID)
+	if err != nil {
+		logr.WithError(err).Warnln("scheduler: could not find the resources of the hibernated VM, using the pool defaults")
+		if cpu, mem, err = p.defaultResources(); err != nil {
+			return "", err
+		}
+	}
+
+	// The resource job is registered while the hold still has the ports of the VM, so that they are
+	// never free for another VM. It is placed once the hold is dropped.
+	ports := instancePorts(instance)
+	resourceJob, resourceJobID := p.resumedResourceJob(instance.ID, instance.NodeID, ports, cpu, mem)
+	logr = logr.WithField("resource_job_id", resourceJobID)
+	if _, _, err = p.client.Jobs().Register(resourceJob, nil); err != nil {
+		return "", fmt.Errorf("scheduler: could not register resource job to resume VM, err: %w, vm: %s", err, instance.ID)
+	}
+	if err = p.deregisterJob(logr, hibernatedJobID, false); err != nil {
+		logr.WithError(err).Warnln("scheduler: could not free up the node of the hibernated VM")
+	}
+	if err = p.waitForResourceJob(ctx, logr, resourceJobID); err != nil {
+		holdJob, _ := p.hibernatedResourceJob(instance.ID, instance.NodeID, ports, cpu, mem)
+		if holdErr := p.runResourceJob(ctx, logr, holdJob, hibernatedJobID); holdErr != nil {
+			logr.WithError(holdErr).Errorln("scheduler: could not hold the node of the suspended VM again")
+		}
+		return "", fmt.Errorf("scheduler: could not reserve resources to resume VM, err: %w, vm: %s", err, instance.ID)
+	}
+
+	resumeJob, resumeJobID, resumeGroup := p.resumeJob(instance.ID, instance.NodeID, int(instance.Port))
+	logr.WithField("resume_job_id", resumeJobID).Infoln("scheduler: submitting VM resume job")
+	if err = p.runJob(ctx, logr, resumeJob, resumeJobID, resumeGroup, p.nomadConfig.ResumeTimeout); err != nil {
+		return "", fmt.Errorf("scheduler: could not resume VM, err: %w, resume_job_id: %s, vm: %s", err, resumeJobID, instance.ID)
+	}
+	logr.Infoln("scheduler: VM resumed")
+	return instance.Address, nil
+}
+
+// resumeAfterFailedHibernate resumes a VM which was suspended but still holds its resources.
+func (p *config) resumeAfterFailedHibernate(ctx context.Context, logr logger.Logger, vm, nodeID string, port int) {
+	job, id, group := p.resumeJob(vm, nodeID, port)
+	if err := p.runJob(ctx, logr, job, id, group, p.nomadConfig.ResumeTimeout); err != nil {
+		logr.WithError(err).WithField("resume_job_id", id).Errorln("scheduler: could not resume VM after failed hibernate")
+	}
+}
+
+// runJob registers a batch job and waits for all of its tasks to complete.
+func (p *config) runJob(ctx context.Context, logr logger.Logger, job *api.Job, id, group string, timeout time.Duration) error {
+	if _, _, err := p.client.Jobs().Register(job, nil); err != nil {
+		return fmt.Errorf("could not register job, err: %w", err)
+	}
+	if _, err := p.pollForJob(ctx, id, logr, timeout, true, []JobStatus{Dead}); err != nil {
+		go p.getAllocationsForJob(logr, id)
+		return err
+	}
+	if err := p.checkTaskGroupStatus(id, group); err != nil {
+		go p.getAllocationsForJob(logr, id)
+		return err
+	}
+	return nil
+}
+
+// runResourceJob registers a job which reserves resources and waits until it is running.
+func (p *config) runResourceJob(ctx context.Context, logr logger.Logger, job *api.Job, id string) error {
+	if _, _, err := p.client.Jobs().Register(job, nil); err != nil {
+		return fmt.Errorf("could not register job, err: %w", err)
+	}
+	return p.waitForResourceJob(ctx, logr, id)
+}
+
+// waitForResourceJob waits until a registered resource job is running. The job is deregistered if it
+// is not running in time.
+func (p *config) waitForResourceJob(ctx context.Context, logr logger.Logger, id string) error {
+	polled, err := p.pollForJob(ctx, id, logr, p.nomadConfig.ResourceJobTimeout, true, []JobStatus{Running, Dead})
+	if err != nil {
+		go p.getAllocationsForJob(logr, id)
+		return err
+	}
+	if polled == nil || isTerminal(polled) {
+		go p.getAllocationsForJob(logr, id)
+		return errors.New("resource job reached terminal state before starting")
+	}
+	return nil
+}
+
+// hibernatedResources returns the resources recorded by the hibernated resource job.
+func (p *config) hibernatedResources(id string) (cpu, mem int, err error) {
+	job, _, err := p.client.Jobs().Info(id, &api.QueryOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	if job == nil {
+		return 0, 0, errors.New("hibernated resource job not found")
+	}
+	if cpu, err = strconv.Atoi(job.Meta[metaVMCPUMhz]); err != nil {
+		return 0, 0, fmt.Errorf("could not parse %s of the hibernated resource job: %w", metaVMCPUMhz, err)
+	}
+	if mem, err = strconv.Atoi(job.Meta[metaVMMemoryMB]); err != nil {
+		return 0, 0, fmt.Errorf("could not parse %s of the hibernated resource job: %w", metaVMMemoryMB, err)
+	}
+	return cpu, mem, nil
+}
+
+// defaultResources returns the resources the resource job reserves for a VM of the pool's default size.
+func (p *config) defaultResources() (cpu, mem int, err error) {
+	_, _, cpus, memGB, err := p.getNomadResourceAndClass(&types.InstanceCreateOpts{})
+	if err != nil {
+		return 0, 0, err
+	}
+	return p.virtualizer.GetMachineFrequency()*cpus - 109, convertGigsToMegs(memGB) - 53, nil
+}
+
+// jobResources returns the CPU and memory reserved by a resource job.
+func jobResources(job *api.Job) (cpu, mem int, err error) {
+	if len(job.TaskGroups) == 0 || len(job.TaskGroups[0].Tasks) == 0 {
+		return 0, 0, errors.New("resource job has no tasks")
+	}
+	resources := job.TaskGroups[0].Tasks[0].Resources
+	if resources == nil || resources.CPU == nil || resources.MemoryMB == nil {
+		return 0, 0, errors.New("resource job has no resources")
+	}
+	return *resources.CPU, *resources.MemoryMB, nil
+}
+
+// suspendJob returns a job targeted to the given node which suspends the VM
+func (p *config) suspendJob(vm, nodeID string) (job *api.Job, id, group string) {
+	id = getSuspendJobID(vm)
+	group = fmt.Sprintf("suspend_task_group_%s", vm)
+	job = p.nodeJob(id, group, nodeID, "suspend_vm", p.virtualizer.GetSuspendScriptGenerator()(vm, p.machinePassword))
+	return job, id, group
+}
+
+// resumeJob returns a job targeted to the given node which resumes the suspended VM
+func (p *config) resumeJob(vm, nodeID string, port int) (job *api.Job, id, group string) {
+	id = getResumeJobID(vm)
+	group = fmt.Sprintf("resume_task_group_%s", vm)
+	job = p.nodeJob(id, group, nodeID, "resume_vm", p.virtualizer.GetResumeScriptGenerator()(vm, p.machinePassword, port))
+	return job, id, group
+}
+
+// nodeJob returns a batch job targeted to the given node which runs the script once
+func (p *config) nodeJob(id, group, nodeID, task, script string) *api.Job {
+	return &api.Job{
+		ID:          &id,
+		Name:        stringToPtr(id),
+		Type:        stringToPtr("batch"),
+		Datacenters: []string{"dc1"},
+		Constraints: []*api.Constraint{nodeConstraint(nodeID)},
+		Reschedule: &api.ReschedulePolicy{
+			Attempts:  intToPtr(0),
+			Unlimited: boolToPtr(false),
+		},
+		TaskGroups: []*api.TaskGroup{
+			{
+				StopAfterClientDisconnect: &p.nomadConfig.ClientDisconnectTimeout,
+				RestartPolicy: &api.RestartPolicy{
+					Attempts: intToPtr(0),
+				},
+				Name:  stringToPtr(group),
+				Count: intToPtr(1),
+				Tasks: []*api.Task{
+					{
+						Name:      task,
+						Driver:    "raw_exec",
+						Resources: minNomadResources(p.nomadConfig.MinNomadCPUMhz, p.nomadConfig.MinNomadMemoryMb),
+						Config: map[string]interface{}{
+							"command": p.virtualizer.GetEntryPoint(),
+							"args":    []string{"-c", script},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// hibernatedResourceJob returns a job which holds the node and ports of a suspended VM. It only reserves the
+// memory of the VM if the virtualizer keeps it on the host, and records the resources of the resource job
+// so they can be reserved again on resume.
+func (p *config) hibernatedResourceJob(vm, nodeID string, ports []int, cpu, mem int) (job *api.Job, id string) {
+	id = getHibernatedResourceJobID(vm)
+	holdMem := p.nomadConfig.MinNomadMemoryMb
+	if !p.virtualizer.SuspendReleasesMemory() {
+		holdMem = mem
+	}
+	resources := &api.Resources{
+		CPU:      intToPtr(p.nomadConfig.MinNomadCPUMhz),
+		MemoryMB: intToPtr(holdMem),
+	}
+	job = p.pinnedResourceJob(id, fmt.Sprintf("hibernated_task_group_resource_%s", vm), "hold", vm, nodeID, ports, resources,
+		"while true; do sleep 3600; done")
+	job.Meta = map[string]string{
+		metaVMCPUMhz:   strconv.Itoa(cpu),
+		metaVMMemoryMB: strconv.Itoa(mem),
+	}
+	return job, id
+}
+
+// resumedResourceJob returns the resource job of a resumed VM. It is the resource job the VM was created
+// with, but targeted to the VM's node and the ports the VM was already given.
+func (p *config) resumedResourceJob(vm, nodeID string, ports []int, cpu, mem int) (job *api.Job, id string) {
+	id = getResourceJobID(vm)
+	sleepTime := p.nomadConfig.ResourceJobTimeout + p.nomadConfig.ResumeTimeout + 2*time.Minute // add 2 minutes for a buffer
+	resources := &api.Resources{
+		CPU:      intToPtr(cpu),
+		MemoryMB: intToPtr(mem),
+	}
+	script := p.virtualizer.GetHealthCheckupGenerator()(sleepTime, vm, p.virtualizer.GetHealthCheckPort(vm))
+	job = p.pinnedResourceJob(id, fmt.Sprintf("init_task_group_resource_%s", vm), "sleep_and_ping", vm, nodeID, ports, resources, script)
+	return job, id
+}
+
+func (p *config) pinnedResourceJob(id, group, task, portLabel, nodeID string, ports []int, resources *api.Resources, script string) *api.Job {
+	return &api.Job{
+		ID:          &id,
+		Name:        stringToPtr(id),
+		Type:        stringToPtr("batch"),
+		Datacenters: []string{"dc1"},
+		Reschedule: &api.ReschedulePolicy{
+			Attempts:  intToPtr(0),
+			Unlimited: boolToPtr(false),
+		},
+		Constraints: []*api.Constraint{nodeConstraint(nodeID)},
+		TaskGroups: []*api.TaskGroup{
+			{
+				Networks:                  getReservedNetworkResources(portLabel, ports),
+				StopAfterClientDisconnect: &p.nomadConfig.ClientDisconnectTimeout,
+				RestartPolicy: &api.RestartPolicy{
+					Attempts: intToPtr(0),
+				},
+				Name:  stringToPtr(group),
+				Count: intToPtr(1),
+				Tasks: []*api.Task{
+					{
+						Name:      task,
+						Resources: resources,
+						Driver:    "raw_exec",
+						Config: map[string]interface{}{
+							"command": p.virtualizer.GetEntryPoint(),
+							"args":    []string{"-c", script},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// instancePorts returns the lite engine port of the instance followed by its gitspaces ports.
+func instancePorts(instance *types.Instance) []int {
+	gitspacesPorts := make([]int, 0, len(instance.GitspacePortMappings))
+	for _, hostPort := range instance.GitspacePortMappings {
+		gitspacesPorts = append(gitspacesPorts, hostPort)
+	}
+	sort.Ints(gitspacesPorts)
+	return append([]int{int(instance.Port)}, gitspacesPorts...)
+}
+
+// Request the ports a VM was given when it was created. Labels follow getNetworkResources, so the
+// health check finds the lite engine port under the same label.
+func getReservedNetworkResources(portLabel string, ports []int) []*api.NetworkResource {
+	reservedPorts := make([]api.Port, 0, len(ports))
+	for i, port := range ports {
+		label := portLabel
+		if i > 0 {
+			label = fmt.Sprintf("%s_gitspaces_%d", portLabel, i)
+		}
+		reservedPorts = append(reservedPorts, api.Port{Label: label, Value: port})
+	}
+	return []*api.NetworkResource{{ReservedPorts: reservedPorts}}
+}
+
+func nodeConstraint(nodeID string) *api.Constraint {
+	return &api.Constraint{
+		LTarget: "${node.unique.id}",
+		RTarget: nodeID,
+		Operand: "=",
+	}
+}
+
+// generate a job ID for a suspend job. Every suspend gets a new job, so the
+// job summary only counts the tasks of this run.
+func getSuspendJobID(s string) string {
+	return fmt.Sprintf("suspend_job_%s_%s", s, strings.ToLower(random(5))) //nolint:mnd
+}
+
+// generate a job ID for a resume job
+func getResumeJobID(s string) string {
+	return fmt.Sprintf("resume_job_%s_%s", s, strings.ToLower(random(5))) //nolint:mnd
+}
+
+// generate a job ID for the job holding the node of a hibernated VM
+func getHibernatedResourceJobID(s string) string {
+	return fmt.Sprintf("hibernated_resources_%s", s)
+}
@@ -0,0 +1,232 @@
+package nomad
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func newHibernateTestConfig(virtualizer Virtualizer) *config {
+	return &config{
+		nomadConfig: &types.NomadConfig{
+			ClientDisconnectTimeout: time.Minute,
+			ResourceJobTimeout:      time.Minute,
+			ResumeTimeout:           2 * time.Minute,
+			MinNomadCPUMhz:          40,
+			MinNomadMemoryMb:        20,
+		},
+		machinePassword: "machine-pw",
+		virtualizer:     virtualizer,
+	}
+}
+
+// TestSuspendResumeScriptSyntax checks that the generated suspend and resume
+// scripts of both virtualizers are valid bash.
+func TestSuspendResumeScriptSyntax(t *testing.T) {
+	virtualizers := map[string]Virtualizer{
+		"linux": NewLinuxVirtualizer(&types.NomadConfig{}),
+		"mac":   NewMacVirtualizer(&types.NomadConfig{}),
+	}
+	for name, v := range virtualizers {
+		scripts := map[string]string{
+			"suspend": v.GetSuspendScriptGenerator()("vm-id", "machine-pw"),
+			"resume":  v.GetResumeScriptGenerator()("vm-id", "machine-pw", 8080),
+		}
+		for kind, script := range scripts {
+			t.Run(name+"/"+kind, func(t *testing.T) {
+				if strings.Contains(script, "%!") {
+					t.Fatalf("script has a formatting error:\n%s", script)
+				}
+				cmd := exec.CommandContext(context.Background(), "bash", "-n")
+				cmd.Stdin = strings.NewReader(script)
+				if out, err := cmd.CombinedOutput(); err != nil {
+					t.Fatalf("bash syntax check failed: %v\noutput: %s\n--- script ---\n%s", err, out, script)
+				}
+			})
+		}
+	}
+}
+
+func TestLinuxSuspendResumeScripts(t *testing.T) {
+	lv := NewLinuxVirtualizer(&types.NomadConfig{})
+
+	suspend := lv.GetSuspendScriptGenerator()("vm-id", "")
+	if !strings.Contains(suspend, ignitePath+" inspect vm vm-id") || !strings.Contains(suspend, `docker pause "$CONTAINER_ID"`) {
+		t.Errorf("suspend script does not pause the container of the VM:\n%s", suspend)
+	}
+	resume := lv.GetResumeScriptGenerator()("vm-id", "", 8080)
+	if !strings.Contains(resume, `docker unpause "$CONTAINER_ID"`) || !strings.Contains(resume, "nc -z localhost 8080") {
+		t.Errorf("resume script does not unpause the VM and wait for lite engine:\n%s", resume)
+	}
+}
+
+func TestMacSuspendResumeScripts(t *testing.T) {
+	mv := NewMacVirtualizer(&types.NomadConfig{})
+	mv.suspendable = true
+
+	suspend := mv.GetSuspendScriptGenerator()("vm-id", "machine-pw")
+	if !strings.Contains(suspend, `/opt/homebrew/bin/tart suspend "$VM_ID"`) {
+		t.Errorf("suspend script does not suspend the VM:\n%s", suspend)
+	}
+	resume := mv.GetResumeScriptGenerator()("vm-id", "machine-pw", 8080)
+	for _, want := range []string{
+		`tart run --no-graphics --suspendable --dir=tmp:/tmp "$VM_ID"`,
+		"port 8080 -> $VM_IP port 9079",
+		`"/port 8080 -> /d"`,
+		`nc -z "$VM_IP" 9079`,
+	} {
+		if !strings.Contains(resume, want) {
+			t.Errorf("resume script is missing %q:\n%s", want, resume)
+		}
+	}
+}
+
+// TestStartupScriptSuspendable checks that VMs of pools which hibernate are
+// started with --suspendable, since tart cannot suspend them otherwise.
+func TestStartupScriptSuspendable(t *testing.T) {
+	script := generateScriptForTest(t, "sonoma_base", "")
+	if strings.Contains(script, "--suspendable") {
+		t.Error("expected VMs to be started without --suspendable by default")
+	}
+
+	mv := NewMacVirtualizer(&types.NomadConfig{})
+	mv.suspendable = true
+	if got := mv.tartRunArgs(); got != "--no-graphics --suspendable --dir=tmp:/tmp" {
+		t.Errorf("unexpected tart run args %q", got)
+	}
+}
+
+func TestHibernatedResourceJob(t *testing.T) {
+	cases := []struct {
+		name        string
+		virtualizer Virtualizer
+		wantMemory  int
+	}{
+		{
+			name:        "paused linux VM keeps its memory reserved",
+			virtualizer: NewLinuxVirtualizer(&types.NomadConfig{}),
+			wantMemory:  6091,
+		},
+		{
+			name:        "suspended mac VM gives its memory back",
+			virtualizer: NewMacVirtualizer(&types.NomadConfig{}),
+			wantMemory:  20,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newHibernateTestConfig(tc.virtualizer)
+			job, id := p.hibernatedResourceJob("vm-id", "node-1", []int{8080, 9000}, 6891, 6091)
+
+			if id != "hibernated_resources_vm-id" || *job.ID != id {
+				t.Errorf("unexpected job id %q", id)
+			}
+			assertPinnedToNode(t, job, "node-1")
+			assertReservedPorts(t, job, map[string]int{"vm-id": 8080, "vm-id_gitspaces_1": 9000})
+
+			resources := job.TaskGroups[0].Tasks[0].Resources
+			if *resources.CPU != 40 {
+				t.Errorf("expected the hibernated VM to only hold the minimum CPU, got %d", *resources.CPU)
+			}
+			if *resources.MemoryMB != tc.wantMemory {
+				t.Errorf("memory: want %d, got %d", tc.wantMemory, *resources.MemoryMB)
+			}
+
+			cpu, mem, err := jobResources(job)
+			if err != nil || cpu != 40 || mem != tc.wantMemory {
+				t.Errorf("jobResources: got %d, %d, %v", cpu, mem, err)
+			}
+			if job.Meta[metaVMCPUMhz] != "6891" || job.Meta[metaVMMemoryMB] != "6091" {
+				t.Errorf("expected the resources of the running VM to be recorded, got %v", job.Meta)
+			}
+		})
+	}
+}
+
+func TestResumedResourceJob(t *testing.T) {
+	p := newHibernateTestConfig(NewLinuxVirtualizer(&types.NomadConfig{}))
+	instance := &types.Instance{
+		ID:                   "vm-id",
+		NodeID:               "node-1",
+		Port:                 8080,
+		GitspacePortMappings: map[int]int{8443: 9001, 22: 9000},
+	}
+	job, id := p.resumedResourceJob(instance.ID, instance.NodeID, instancePorts(instance), 6891, 6091)
+
+	if id != getResourceJobID("vm-id") {
+		t.Errorf("expected the resumed VM to use its resource job id, got %q", id)
+	}
+	assertPinnedToNode(t, job, "node-1")
+	assertReservedPorts(t, job, map[string]int{"vm-id": 8080, "vm-id_gitspaces_1": 9000, "vm-id_gitspaces_2": 9001})
+
+	task := job.TaskGroups[0].Tasks[0]
+	if *task.Resources.CPU != 6891 || *task.Resources.MemoryMB != 6091 {
+		t.Errorf("expected the recorded resources to be reserved, got %d MHz, %d MB", *task.Resources.CPU, *task.Resources.MemoryMB)
+	}
+	args := task.Config["args"].([]string)
+	if !strings.Contains(args[1], "nc -vz localhost $NOMAD_PORT_vm-id") {
+		t.Errorf("expected the resource job to health check the lite engine port, got:\n%s", args[1])
+	}
+}
+
+func TestSuspendAndResumeJobs(t *testing.T) {
+	p := newHibernateTestConfig(NewMacVirtualizer(&types.NomadConfig{}))
+
+	suspend, suspendID, suspendGroup := p.suspendJob("vm-id", "node-1")
+	if !strings.HasPrefix(suspendID, "suspend_job_vm-id_") || *suspend.TaskGroups[0].Name != suspendGroup {
+		t.Errorf("unexpected suspend job %q, group %q", suspendID, suspendGroup)
+	}
+	assertPinnedToNode(t, suspend, "node-1")
+
+	resume, resumeID, _ := p.resumeJob("vm-id", "node-1", 8080)
+	if !strings.HasPrefix(resumeID, "resume_job_vm-id_") {
+		t.Errorf("unexpected resume job %q", resumeID)
+	}
+	assertPinnedToNode(t, resume, "node-1")
+	args := resume.TaskGroups[0].Tasks[0].Config["args"].([]string)
+	if !strings.Contains(args[1], `MACHINE_PASSWORD="machine-pw"`) {
+		t.Errorf("expected the resume script to get the machine password, got:\n%s", args[1])
+	}
+
+	_, otherID, _ := p.suspendJob("vm-id", "node-1")
+	if otherID == suspendID {
+		t.Error("expected every suspend to get its own job")
+	}
+}
+
+func assertPinnedToNode(t *testing.T, job *api.Job, nodeID string) {
+	t.Helper()
+	if len(job.Constraints) != 1 {
+		t.Fatalf("expected a single constraint, got %d", len(job.Constraints))
+	}
+	c := job.Constraints[0]
+	if c.LTarget != "${node.unique.id}" || c.Operand != "=" || c.RTarget != nodeID {
+		t.Errorf("expected the job to be pinned to node %s, got %+v", nodeID, c)
+	}
+}
+
+func assertReservedPorts(t *testing.T, job *api.Job, want map[string]int) {
+	t.Helper()
+	networks := job.TaskGroups[0].Networks
+	if len(networks) != 1 || len(networks[0].DynamicPorts) != 0 {
+		t.Fatalf("expected a single network with static ports only, got %+v", networks)
+	}
+	got := map[string]int{}
+	for _, port := range networks[0].ReservedPorts {
+		got[port.Label] = port.Value
+	}
+	if len(got) != len(want) {
+		t.Fatalf("reserved ports: want %v, got %v", want, got)
+	}
+	for label, value := range want {
+		if got[label] != value {
+			t.Errorf("reserved ports: want %v, got %v", want, got)
+		}
+	}
+}
@@ -332,6 +332,52 @@ func (lv *LinuxVirtualizer) GetDestroyScriptGenerator() func(string, string) str
 	}
 }
 
+// GetSuspendScriptGenerator pauses the microVM by freezing the container ignite runs firecracker in.
+// The guest memory stays resident, but the VM no longer uses any CPU.
+func (lv *LinuxVirtualizer) GetSuspendScriptGenerator() func(string, string) string {
+	return func(vm, _ string) string {
+		return fmt.Sprintf(`
+CONTAINER_ID=$(%s inspect vm %s -t '{{.Status.Runtime.ID}}')
+if [ -z "$CONTAINER_ID" ]; then
+  echo "could not find the container of VM %s" >&2
+  exit 1
+fi
+docker pause "$CONTAINER_ID"
+echo "paused VM %s"
+`, ignitePath, vm, vm, vm)
+	}
+}
+
+// GetResumeScriptGenerator unpauses the microVM and waits until lite engine is reachable again.
+func (lv *LinuxVirtualizer) GetResumeScriptGenerator() func(string, string, int) string {
+	return func(vm, _ string, port int) string {
+		return fmt.Sprintf(`
+CONTAINER_ID=$(%s inspect vm %s -t '{{.Status.Runtime.ID}}')
+if [ -z "$CONTAINER_ID" ]; then
+  echo "could not find the container of VM %s" >&2
+  exit 1
+fi
+if [ "$(docker inspect -f '{{.State.Paused}}' "$CONTAINER_ID")" = "true" ]; then
+  docker unpause "$CONTAINER_ID" || exit 1
+fi
+for i in {1..30}; do
+  if nc -z localhost %d; then
+    echo "resumed VM %s"
+    exit 0
+  fi
+  sleep 2
+done
+echo "lite engine did not come up after resuming VM %s" >&2
+exit 1
+`, ignitePath, vm, vm, port, vm, vm)
+	}
+}
+
+// SuspendReleasesMemory is false since a paused microVM keeps its guest memory on the host.
+func (lv *LinuxVirtualizer) SuspendReleasesMemory() bool {
+	return false
+}
+
 func (lv *LinuxVirtualizer) getScriptCleanupCmd(opts *types.InstanceCreateOpts, hostPath, provisionCephStorageScriptPath string) string {
 	cleanUpCmdFormat := "rm %s"
 	cleanUpCmdArgs := []interface{}{hostPath}
@@ -39,6 +39,8 @@ fi`
 
 type MacVirtualizer struct {
 	nomadConfig *types.NomadConfig
+	// suspendable starts VMs so that tart is able to suspend them
+	suspendable bool
 }
 
 func NewMacVirtualizer(nomadConfig *types.NomadConfig) *MacVirtualizer {
@@ -140,6 +142,7 @@ set -eo pipefail
 
 VM_IMAGE="%s"
 VM_ID="%s"
+TART_RUN_ARGS="%s"
 
 VM_USER="%s"
 VM_PASSWORD="%s"
@@ -232,7 +235,7 @@ echo "Setting tart VM config with id $VM_ID"
 
 echo "Starting tart VM with id $VM_ID"
 # Run the VM in background
-/opt/homebrew/sbin/daemonize /opt/homebrew/bin/tart run --no-graphics --dir=tmp:/tmp "$VM_ID"
+/opt/homebrew/sbin/daemonize /opt/homebrew/bin/tart run $TART_RUN_ARGS "$VM_ID"
 
 # Wait for VM to get IP
 echo "Waiting for VM to get IP"
@@ -288,7 +291,7 @@ sleep 5
 
 echo "Re-starting tart VM with id $VM_ID"
 # Run the VM in background
-/opt/homebrew/sbin/daemonize /opt/homebrew/bin/tart run --no-graphics --dir=tmp:/tmp "$VM_ID"
+/opt/homebrew/sbin/daemonize /opt/homebrew/bin/tart run $TART_RUN_ARGS "$VM_ID"
 
 # Remove known_hosts file to avoid too many authentication errors
 if [ -f ~/.ssh/known_hosts ]; then
@@ -335,7 +338,7 @@ while true
 		sleep 1
 	done
 echo "Tart VM Started"
-`, vmImageConfig.ImageName, vmID, vmImageConfig.Username, vmImageConfig.Password, vmImageConfig.VMImageAuth.Registry,
+`, vmImageConfig.ImageName, vmID, mv.tartRunArgs(), vmImageConfig.Username, vmImageConfig.Password, vmImageConfig.VMImageAuth.Registry,
 		vmImageConfig.VMImageAuth.Username, vmImageConfig.VMImageAuth.Password, machinePassword, resource.Cpus, convertGigsToMegs(memGB), resource.DiskSize, lockFunction, vmID, port, UnlockFunction)
 }
 
@@ -408,6 +411,84 @@ fi
 	}
 }
 
+// GetSuspendScriptGenerator suspends the VM with tart, which writes its memory to disk and frees it on the host.
+// tart is only able to suspend VMs started with --suspendable.
+func (mv *MacVirtualizer) GetSuspendScriptGenerator() func(string, string) string {
+	return func(vm, _ string) string {
+		return fmt.Sprintf(`
+#!/usr/bin/env bash
+
+VM_ID="%s"
+if ! /opt/homebrew/bin/tart suspend "$VM_ID"; then
+  echo "tart could not suspend VM $VM_ID" >&2
+  exit 1
+fi
+echo "Suspended tart VM with id $VM_ID"
+`, vm)
+	}
+}
+
+// GetResumeScriptGenerator restores a suspended VM and points the port forwarding rule at its
+// address again, since the VM might be given a new IP once it is running again.
+func (mv *MacVirtualizer) GetResumeScriptGenerator() func(string, string, int) string {
+	return func(vm, machinePassword string, port int) string {
+		return fmt.Sprintf(`
+#!/usr/bin/env bash
+
+VM_ID="%s"
+MACHINE_PASSWORD="%s"
+
+echo "Resuming tart VM with id $VM_ID"
+/opt/homebrew/sbin/daemonize /opt/homebrew/bin/tart run %s "$VM_ID"
+
+VM_IP=$(/opt/homebrew/bin/tart ip "$VM_ID" --wait 30 || true)
+if [ -z "$VM_IP" ]; then
+  echo "Waited 30 seconds for VM to resume, exiting..." >&2
+  exit 1
+fi
+
+# LOCK
+%s
+
+ANCHOR_FILE="/etc/pf.anchors/tart"
+ANCHOR_CONTENT="rdr pass log (all) on en0 inet proto tcp from any to any port %d -> $VM_IP port 9079"
+echo "$MACHINE_PASSWORD" | sudo -S sed -i '' "/port %d -> /d" "$ANCHOR_FILE"
+echo "$MACHINE_PASSWORD" | sudo -S sh -c "echo '$ANCHOR_CONTENT' | tee -a '$ANCHOR_FILE'"
+echo "$MACHINE_PASSWORD" | sudo -S pfctl -a tart -f "$ANCHOR_FILE"
+
+#UNLOCK
+%s
+
+MAX_RETRIES=15
+RETRY_COUNT=0
+while [ $RETRY_COUNT -lt $MAX_RETRIES ]; do
+  if nc -z "$VM_IP" 9079; then
+    echo "Resumed tart VM with id $VM_ID"
+    exit 0
+  fi
+  RETRY_COUNT=$((RETRY_COUNT + 1))
+  sleep 2
+done
+echo "Lite-engine did not come up after resuming VM $VM_ID" >&2
+exit 1
+`, vm, machinePassword, mv.tartRunArgs(), lockFunction, port, port, UnlockFunction)
+	}
+}
+
+// SuspendReleasesMemory is true since tart saves the memory of a suspended VM to disk.
+func (mv *MacVirtualizer) SuspendReleasesMemory() bool {
+	return true
+}
+
+// tartRunArgs returns the arguments VMs are started and resumed with. A suspended VM has to be
+// resumed with the same devices it was started with.
+func (mv *MacVirtualizer) tartRunArgs() string {
+	if mv.suspendable {
+		return "--no-graphics --suspendable --dir=tmp:/tmp"
+	}
+	return "--no-graphics --dir=tmp:/tmp"
+}
+
 // This will be responsible to run the cloud-init script from the mounted shared directory
 func (mv *MacVirtualizer) getStartCloudInitScript(vmID, username, password string) string {
 	// Executes the per-VM cloud-init script from Tart's shared directory (/Volumes/My Shared Files/tmp)
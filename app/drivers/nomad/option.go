@@ -61,6 +61,20 @@ func WithNoop(b bool) Option {
 	}
 }
 
+func WithHibernate(b bool) Option {
+	return func(p *config) {
+		p.hibernate = b
+	}
+}
+
+// WithPlacement sets the placement rules of the pool's resource jobs. The
+// default placement is used when it is nil.
+func WithPlacement(placement *cf.NomadPlacement) Option {
+	return func(p *config) {
+		p.placementSpec = placement
+	}
+}
+
 func WithMemory(s string) Option {
 	return func(p *config) {
 		p.vmMemoryGB = s
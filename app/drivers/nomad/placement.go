@@ -0,0 +1,291 @@
+package nomad
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/nomad/api"
+
+	cf "github.com/drone-runners/drone-runner-aws/command/config"
+)
+
+// placementParams are the values placement templates are rendered with.
+type placementParams struct {
+	// Account is the account the VM is created for.
+	Account string
+	// NodeClass is the node class the VM is pinned to, derived from
+	// enablePinning, the resource class and the global account.
+	NodeClass string
+	// Image is the VM image.
+	Image string
+	// ResourceClass is the resource class requested for the VM.
+	ResourceClass string
+}
+
+// defaultPlacement keeps the placement of pools without placement rules:
+// VMs run on nodes of their node class, and nodes tagged with
+// meta.sequoia_only=true only run Sequoia images.
+var defaultPlacement = mustParsePlacement(&cf.NomadPlacement{
+	Constraints: []cf.NomadConstraint{
+		{
+			If:        `{{ ne .NodeClass "" }}`,
+			Attribute: "${node.class}",
+			Operator:  "=",
+			Value:     "{{ .NodeClass }}",
+		},
+		{
+			If:        "{{ not (isSequoia .Image) }}",
+			Attribute: "${meta.sequoia_only}",
+			Operator:  "!=",
+			Value:     "true",
+		},
+	},
+})
+
+var placementFuncs = template.FuncMap{
+	"isSequoia": isSequoiaImage,
+	"lower":     strings.ToLower,
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+}
+
+// placement holds the parsed placement rules of a pool.
+type placement struct {
+	constraints []*placementRule
+	affinities  []*placementRule
+	spreads     []*spreadRule
+}
+
+type placementRule struct {
+	cond      *template.Template
+	attribute *template.Template
+	operator  *template.Template
+	value     *template.Template
+	weight    int8
+}
+
+type spreadRule struct {
+	cond      *template.Template
+	attribute *template.Template
+	weight    int8
+	targets   []spreadTarget
+}
+
+type spreadTarget struct {
+	value   *template.Template
+	percent uint8
+}
+
+// newPlacement parses the placement rules of a pool. The default placement
+// is returned when spec is nil.
+func newPlacement(spec *cf.NomadPlacement) (*placement, error) {
+	if spec == nil {
+		return defaultPlacement, nil
+	}
+	return parsePlacement(spec)
+}
+
+func parsePlacement(spec *cf.NomadPlacement) (*placement, error) {
+	pl := &placement{}
+	for i := range spec.Constraints {
+		c := &spec.Constraints[i]
+		rule, err := parsePlacementRule(fmt.Sprintf("constraints[%d]", i), c.If, c.Attribute, c.Operator, c.Value)
+		if err != nil {
+			return nil, err
+		}
+		pl.constraints = append(pl.constraints, rule)
+	}
+	for i := range spec.Affinities {
+		a := &spec.Affinities[i]
+		name := fmt.Sprintf("affinities[%d]", i)
+		if a.Weight == 0 || a.Weight < -100 || a.Weight > 100 {
+			return nil, fmt.Errorf("nomad placement: %s: weight must be between -100 and 100 and not zero, got %d", name, a.Weight)
+		}
+		rule, err := parsePlacementRule(name, a.If, a.Attribute, a.Operator, a.Value)
+		if err != nil {
+			return nil, err
+		}
+		rule.weight = int8(a.Weight)
+		pl.affinities = append(pl.affinities, rule)
+	}
+	for i := range spec.Spreads {
+		s := &spec.Spreads[i]
+		name := fmt.Sprintf("spreads[%d]", i)
+		if s.Weight < 0 || s.Weight > 100 {
+			return nil, fmt.Errorf("nomad placement: %s: weight must be between 0 and 100, got %d", name, s.Weight)
+		}
+		rule := &spreadRule{weight: int8(s.Weight)}
+		var err error
+		if rule.cond, err = parsePlacementTemplate(name+".if", s.If); err != nil {
+			return nil, err
+		}
+		if rule.attribute, err = parsePlacementTemplate(name+".attribute", s.Attribute); err != nil {
+			return nil, err
+		}
+		total := 0
+		for j, t := range s.Targets {
+			if t.Percent < 0 || t.Percent > 100 {
+				return nil, fmt.Errorf("nomad placement: %s.targets[%d]: percent must be between 0 and 100, got %d", name, j, t.Percent)
+			}
+			total += t.Percent
+			value, err := parsePlacementTemplate(fmt.Sprintf("%s.targets[%d].value", name, j), t.Value)
+			if err != nil {
+				return nil, err
+			}
+			rule.targets = append(rule.targets, spreadTarget{value: value, percent: uint8(t.Percent)})
+		}
+		if total > 100 {
+			return nil, fmt.Errorf("nomad placement: %s: target percentages add up to %d, more than 100", name, total)
+		}
+		pl.spreads = append(pl.spreads, rule)
+	}
+	return pl, nil
+}
+
+func mustParsePlacement(spec *cf.NomadPlacement) *placement {
+	pl, err := parsePlacement(spec)
+	if err != nil {
+		panic(err)
+	}
+	return pl
+}
+
+func parsePlacementRule(name, cond, attribute, operator, value string) (*placementRule, error) {
+	rule := &placementRule{}
+	var err error
+	if rule.cond, err = parsePlacementTemplate(name+".if", cond); err != nil {
+		return nil, err
+	}
+	if rule.attribute, err = parsePlacementTemplate(name+".attribute", attribute); err != nil {
+		return nil, err
+	}
+	if rule.operator, err = parsePlacementTemplate(name+".operator", operator); err != nil {
+		return nil, err
+	}
+	if rule.value, err = parsePlacementTemplate(name+".value", value); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// parsePlacementTemplate parses a template and renders it once, so that
+// templates referring to unknown fields fail when the pool is loaded.
+func parsePlacementTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(name).Funcs(placementFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("nomad placement: %s: %w", name, err)
+	}
+	if _, err = renderPlacement(t, placementParams{}); err != nil {
+		return nil, fmt.Errorf("nomad placement: %w", err)
+	}
+	return t, nil
+}
+
+func renderPlacement(t *template.Template, params placementParams) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	sb := &strings.Builder{}
+	if err := t.Execute(sb, params); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// applies reports whether a rule with the given condition applies to the VM.
+func applies(cond *template.Template, params placementParams) (bool, error) {
+	if cond == nil {
+		return true, nil
+	}
+	out, err := renderPlacement(cond, params)
+	if err != nil {
+		return false, err
+	}
+	return out == "true", nil
+}
+
+// render returns the constraints, affinities and spreads of a resource job.
+func (pl *placement) render(params placementParams) (constraints []*api.Constraint, affinities []*api.Affinity, spreads []*api.Spread, err error) {
+	constraints = []*api.Constraint{}
+	for _, rule := range pl.constraints {
+		lTarget, operand, rTarget, ok, err := rule.render(params)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if ok {
+			constraints = append(constraints, &api.Constraint{LTarget: lTarget, Operand: operand, RTarget: rTarget})
+		}
+	}
+	for _, rule := range pl.affinities {
+		lTarget, operand, rTarget, ok, err := rule.render(params)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if ok {
+			weight := rule.weight
+			affinities = append(affinities, &api.Affinity{LTarget: lTarget, Operand: operand, RTarget: rTarget, Weight: &weight})
+		}
+	}
+	for _, rule := range pl.spreads {
+		spread, err := rule.render(params)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if spread != nil {
+			spreads = append(spreads, spread)
+		}
+	}
+	return constraints, affinities, spreads, nil
+}
+
+// render returns the attribute, operator and value of the rule, and whether it applies to the VM.
+func (r *placementRule) render(params placementParams) (attribute, operator, value string, ok bool, err error) {
+	if ok, err = applies(r.cond, params); err != nil || !ok {
+		return "", "", "", false, err
+	}
+	if attribute, err = renderPlacement(r.attribute, params); err != nil {
+		return "", "", "", false, err
+	}
+	if operator, err = renderPlacement(r.operator, params); err != nil {
+		return "", "", "", false, err
+	}
+	if value, err = renderPlacement(r.value, params); err != nil {
+		return "", "", "", false, err
+	}
+	return attribute, operator, value, true, nil
+}
+
+func (r *spreadRule) render(params placementParams) (*api.Spread, error) {
+	ok, err := applies(r.cond, params)
+	if err != nil || !ok {
+		return nil, err
+	}
+	attribute, err := renderPlacement(r.attribute, params)
+	if err != nil {
+		return nil, err
+	}
+	spread := &api.Spread{Attribute: attribute}
+	if r.weight != 0 {
+		weight := r.weight
+		spread.Weight = &weight
+	}
+	for _, t := range r.targets {
+		value, err := renderPlacement(t.value, params)
+		if err != nil {
+			return nil, err
+		}
+		spread.SpreadTarget = append(spread.SpreadTarget, &api.SpreadTarget{Value: value, Percent: t.percent})
+	}
+	return spread, nil
+}
+
+// isSequoiaImage reports whether the given image name refers to a Sequoia image.
+// Empty image names (pool default, which is Sonoma) return false.
+func isSequoiaImage(imageName string) bool {
+	return strings.Contains(strings.ToLower(imageName), "sequoia")
+}
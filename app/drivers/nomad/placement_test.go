@@ -0,0 +1,289 @@
+package nomad
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+
+	cf "github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func int8Ptr(i int8) *int8 {
+	return &i
+}
+
+func TestPlacementRender(t *testing.T) {
+	rackSpec := &cf.NomadPlacement{
+		Constraints: []cf.NomadConstraint{
+			{
+				Attribute: "${node.class}",
+				Operator:  "=",
+				Value:     "{{ .NodeClass }}",
+			},
+			{
+				If:        `{{ eq .ResourceClass "xlarge" }}`,
+				Attribute: "${meta.cpu_generation}",
+				Operator:  ">=",
+				Value:     "4",
+			},
+		},
+		Affinities: []cf.NomadAffinity{
+			{
+				Attribute: "${meta.account}",
+				Operator:  "=",
+				Value:     "{{ .Account }}",
+				Weight:    50,
+			},
+			{
+				If:        `{{ hasPrefix (lower .Image) "tahoe" }}`,
+				Attribute: "${meta.os_version}",
+				Operator:  "version",
+				Value:     ">= 26",
+				Weight:    -20,
+			},
+		},
+		Spreads: []cf.NomadSpread{
+			{
+				Attribute: "${meta.rack}",
+				Weight:    80,
+				Targets: []cf.NomadSpreadTarget{
+					{Value: "r1", Percent: 60},
+					{Value: "r2", Percent: 40},
+				},
+			},
+			{
+				If:        `{{ ne .ResourceClass "" }}`,
+				Attribute: "${meta.{{ .ResourceClass }}_pool}",
+			},
+		},
+	}
+
+	cases := []struct {
+		name            string
+		spec            *cf.NomadPlacement
+		params          placementParams
+		wantConstraints []*api.Constraint
+		wantAffinities  []*api.Affinity
+		wantSpreads     []*api.Spread
+	}{
+		{
+			name:   "default placement pins to the node class and excludes sequoia nodes",
+			params: placementParams{NodeClass: "PAID_POOL", Image: "sonoma_14.6"},
+			wantConstraints: []*api.Constraint{
+				{LTarget: "${node.class}", Operand: "=", RTarget: "PAID_POOL"},
+				{LTarget: "${meta.sequoia_only}", Operand: "!=", RTarget: "true"},
+			},
+		},
+		{
+			name:   "default placement allows sequoia images on sequoia nodes",
+			params: placementParams{NodeClass: "GLOBAL_ACCOUNT_ID_MAC", Image: "Sequoia_15.6.1"},
+			wantConstraints: []*api.Constraint{
+				{LTarget: "${node.class}", Operand: "=", RTarget: "GLOBAL_ACCOUNT_ID_MAC"},
+			},
+		},
+		{
+			name:            "default placement without a node class",
+			params:          placementParams{Image: "sequoia_15.6.1"},
+			wantConstraints: []*api.Constraint{},
+		},
+		{
+			name:            "empty placement has no rules",
+			spec:            &cf.NomadPlacement{},
+			params:          placementParams{NodeClass: "PAID_POOL", Image: "sonoma_14.6"},
+			wantConstraints: []*api.Constraint{},
+		},
+		{
+			name:   "rules templated on account and image",
+			spec:   rackSpec,
+			params: placementParams{Account: "acct-1", NodeClass: "PAID_POOL", Image: "Tahoe_26.5"},
+			wantConstraints: []*api.Constraint{
+				{LTarget: "${node.class}", Operand: "=", RTarget: "PAID_POOL"},
+			},
+			wantAffinities: []*api.Affinity{
+				{LTarget: "${meta.account}", Operand: "=", RTarget: "acct-1", Weight: int8Ptr(50)},
+				{LTarget: "${meta.os_version}", Operand: "version", RTarget: ">= 26", Weight: int8Ptr(-20)},
+			},
+			wantSpreads: []*api.Spread{
+				{
+					Attribute: "${meta.rack}",
+					Weight:    int8Ptr(80),
+					SpreadTarget: []*api.SpreadTarget{
+						{Value: "r1", Percent: 60},
+						{Value: "r2", Percent: 40},
+					},
+				},
+			},
+		},
+		{
+			name:   "rules templated on resource class",
+			spec:   rackSpec,
+			params: placementParams{Account: "acct-2", NodeClass: "largebaremetal", Image: "sonoma_14.6", ResourceClass: "xlarge"},
+			wantConstraints: []*api.Constraint{
+				{LTarget: "${node.class}", Operand: "=", RTarget: "largebaremetal"},
+				{LTarget: "${meta.cpu_generation}", Operand: ">=", RTarget: "4"},
+			},
+			wantAffinities: []*api.Affinity{
+				{LTarget: "${meta.account}", Operand: "=", RTarget: "acct-2", Weight: int8Ptr(50)},
+			},
+			wantSpreads: []*api.Spread{
+				{
+					Attribute: "${meta.rack}",
+					Weight:    int8Ptr(80),
+					SpreadTarget: []*api.SpreadTarget{
+						{Value: "r1", Percent: 60},
+						{Value: "r2", Percent: 40},
+					},
+				},
+				{Attribute: "${meta.xlarge_pool}"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pl, err := newPlacement(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			constraints, affinities, spreads, err := pl.render(tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(constraints, tc.wantConstraints) {
+				t.Errorf("constraints:\nwant %s\ngot  %s", dump(tc.wantConstraints), dump(constraints))
+			}
+			if !reflect.DeepEqual(affinities, tc.wantAffinities) {
+				t.Errorf("affinities:\nwant %s\ngot  %s", dump(tc.wantAffinities), dump(affinities))
+			}
+			if !reflect.DeepEqual(spreads, tc.wantSpreads) {
+				t.Errorf("spreads:\nwant %s\ngot  %s", dump(tc.wantSpreads), dump(spreads))
+			}
+		})
+	}
+}
+
+func TestNewPlacementInvalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    *cf.NomadPlacement
+		wantErr string
+	}{
+		{
+			name: "template syntax error",
+			spec: &cf.NomadPlacement{Constraints: []cf.NomadConstraint{
+				{Attribute: "${node.class}", Operator: "=", Value: "{{ .NodeClass "},
+			}},
+			wantErr: "constraints[0].value",
+		},
+		{
+			name: "unknown template field",
+			spec: &cf.NomadPlacement{Constraints: []cf.NomadConstraint{
+				{Attribute: "${node.class}", Operator: "=", Value: "{{ .Tenant }}"},
+			}},
+			wantErr: "Tenant",
+		},
+		{
+			name: "affinity without weight",
+			spec: &cf.NomadPlacement{Affinities: []cf.NomadAffinity{
+				{Attribute: "${meta.rack}", Operator: "=", Value: "r1"},
+			}},
+			wantErr: "affinities[0]: weight",
+		},
+		{
+			name: "affinity weight out of range",
+			spec: &cf.NomadPlacement{Affinities: []cf.NomadAffinity{
+				{Attribute: "${meta.rack}", Operator: "=", Value: "r1", Weight: 101},
+			}},
+			wantErr: "affinities[0]: weight",
+		},
+		{
+			name: "negative spread weight",
+			spec: &cf.NomadPlacement{Spreads: []cf.NomadSpread{
+				{Attribute: "${meta.rack}", Weight: -1},
+			}},
+			wantErr: "spreads[0]: weight",
+		},
+		{
+			name: "spread targets over 100 percent",
+			spec: &cf.NomadPlacement{Spreads: []cf.NomadSpread{
+				{Attribute: "${meta.rack}", Targets: []cf.NomadSpreadTarget{
+					{Value: "r1", Percent: 70},
+					{Value: "r2", Percent: 40},
+				}},
+			}},
+			wantErr: "add up to 110",
+		},
+		{
+			name: "bad condition",
+			spec: &cf.NomadPlacement{Spreads: []cf.NomadSpread{
+				{If: "{{ eq .Image }}", Attribute: "${meta.rack}"},
+			}},
+			wantErr: "spreads[0].if",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newPlacement(tc.spec)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error to contain %q, got %q", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestResourceJobPlacement verifies the placement of the pool ends up on the
+// resource job.
+func TestResourceJobPlacement(t *testing.T) {
+	pl, err := newPlacement(&cf.NomadPlacement{
+		Constraints: []cf.NomadConstraint{
+			{Attribute: "${node.class}", Operator: "=", Value: "{{ .NodeClass }}"},
+		},
+		Affinities: []cf.NomadAffinity{
+			{Attribute: "${meta.account}", Operator: "=", Value: "{{ .Account }}", Weight: 100},
+		},
+		Spreads: []cf.NomadSpread{
+			{Attribute: "${meta.rack}", Weight: 50},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &config{
+		nomadConfig: &types.NomadConfig{ClientDisconnectTimeout: time.Minute, ResourceJobTimeout: time.Minute},
+		virtualizer: NewLinuxVirtualizer(&types.NomadConfig{InitTimeout: time.Minute}),
+		placement:   pl,
+	}
+	noopHealthCheck := func(_ time.Duration, _, _ string) string { return "sleep 1" }
+
+	params := placementParams{Account: "acct-1", NodeClass: "PAID_POOL", Image: "ubuntu"}
+	job, _, err := p.resourceJob(2, 4, 3500, 0, "test-vm-id", params, types.VMImageConfig{ImageName: "ubuntu"}, noopHealthCheck)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantConstraints := []*api.Constraint{{LTarget: "${node.class}", Operand: "=", RTarget: "PAID_POOL"}}
+	wantAffinities := []*api.Affinity{{LTarget: "${meta.account}", Operand: "=", RTarget: "acct-1", Weight: int8Ptr(100)}}
+	wantSpreads := []*api.Spread{{Attribute: "${meta.rack}", Weight: int8Ptr(50)}}
+	if !reflect.DeepEqual(job.Constraints, wantConstraints) {
+		t.Errorf("constraints: want %s, got %s", dump(wantConstraints), dump(job.Constraints))
+	}
+	if !reflect.DeepEqual(job.Affinities, wantAffinities) {
+		t.Errorf("affinities: want %s, got %s", dump(wantAffinities), dump(job.Affinities))
+	}
+	if !reflect.DeepEqual(job.Spreads, wantSpreads) {
+		t.Errorf("spreads: want %s, got %s", dump(wantSpreads), dump(job.Spreads))
+	}
+}
+
+func dump(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -23,6 +23,12 @@ type Virtualizer interface {
 	GetHealthCheckupGenerator() func(time.Duration, string, string) string
 	// Returns destroy script generator
 	GetDestroyScriptGenerator() func(vm, machinePassword string) string
+	// Returns suspend script generator, used to hibernate a running VM
+	GetSuspendScriptGenerator() func(vm, machinePassword string) string
+	// Returns resume script generator, used to start a hibernated VM which is reachable on the given host port
+	GetResumeScriptGenerator() func(vm, machinePassword string, port int) string
+	// Returns whether a suspended VM gives its memory back to the host
+	SuspendReleasesMemory() bool
 	// Returns entrypoint
 	GetEntryPoint() string
 	// Returns healthcheck port
@@ -9,6 +9,7 @@ import (
 	"github.com/drone-runners/drone-runner-aws/app/drivers"
 	"github.com/drone-runners/drone-runner-aws/app/lehelper"
 	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+	cf "github.com/drone-runners/drone-runner-aws/command/config"
 	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
 	"github.com/drone-runners/drone-runner-aws/types"
 )
@@ -16,14 +17,11 @@ import (
 var _ drivers.Driver = (*config)(nil)
 
 type config struct {
-	rootDir           string
-	hibernate         bool
-	hibernateWaitSecs int
-	startWaitSecs     int
-	createWaitSecs    int
-	destroyWaitSecs   int
-	tagWaitSecs       int
-	leIP              string
+	rootDir        string
+	hibernate      bool
+	leIP           string
+	simulationSpec *cf.NoopSimulation
+	sim            *simulation
 }
 
 func New(opts ...Option) (drivers.Driver, error) {
@@ -32,28 +30,68 @@ func New(opts ...Option) (drivers.Driver, error) {
 		opt(p)
 	}
 
-	p.hibernateWaitSecs = 5
-	p.startWaitSecs = 10
-	p.createWaitSecs = 15
-	p.destroyWaitSecs = 5
-	p.tagWaitSecs = 1
 	p.leIP = "127.0.0.1"
-
+	sim, err := newSimulation(p.simulationSpec)
+	if err != nil {
+		return nil, err
+	}
+	p.sim = sim
 	return p, nil
 }
 
-// ReserveCapacity reserves capacity for a VM
+// ReserveCapacity reserves capacity for a VM when the simulation supports
+// capacity reservations.
 func (p *config) ReserveCapacity(ctx context.Context, opts *types.InstanceCreateOpts) (*types.CapacityReservation, error) {
-	return nil, &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+	if !p.sim.capacityReservation {
+		return nil, &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+	}
+	if err := p.sim.run(ctx, opReserveCapacity); err != nil {
+		return nil, err
+	}
+	machineType := drivers.MachineTypes(opts, p.sim.machineType, p.sim.machineTypeFallbacks)[0]
+	zone, err := p.sim.pickZone(opts.Zones, machineType)
+	if err != nil {
+		return nil, err
+	}
+	return &types.CapacityReservation{
+		PoolName:      opts.PoolName,
+		ReservationID: uuid.New().String(),
+		CreatedAt:     time.Now().Unix(),
+		Zone:          &zone,
+	}, nil
 }
 
 // DestroyCapacity destroys capacity for a VM
 func (p *config) DestroyCapacity(ctx context.Context, capacity *types.CapacityReservation) (err error) {
-	return &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+	if !p.sim.capacityReservation {
+		return &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+	}
+	return p.sim.run(ctx, opDestroyCapacity)
 }
 
+// Create places the VM in the first zone with capacity, walking the machine
+// type fallbacks when every zone is out of capacity. A VM created for a
+// capacity reservation is placed in its zone.
 func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
-	time.Sleep(time.Duration(p.createWaitSecs) * time.Second)
+	if err = p.sim.run(ctx, opCreate); err != nil {
+		return nil, err
+	}
+	var zone string
+	machineTypes := drivers.MachineTypes(opts, p.sim.machineType, p.sim.machineTypeFallbacks)
+	machineType := machineTypes[0]
+	if opts.CapacityReservation != nil {
+		zone = opts.CapacityReservation.GetZone()
+	} else {
+		machineType, err = drivers.CreateWithMachineTypeFallback(ctx, p.DriverName(), machineTypes, isStockoutError, nil,
+			func(machineType string) (err error) {
+				zone, err = p.sim.pickZone(opts.Zones, machineType)
+				return err
+			}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	id := uuid.New().String()
 	return &types.Instance{
 		ID:           id,
@@ -63,6 +101,8 @@ func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (in
 		Pool:         opts.PoolName,
 		Platform:     opts.Platform,
 		Address:      p.leIP,
+		Zone:         zone,
+		Size:         machineType,
 		CACert:       opts.CACert,
 		CAKey:        opts.CAKey,
 		TLSCert:      opts.TLSCert,
@@ -78,24 +118,28 @@ func (p *config) Destroy(ctx context.Context, instances []*types.Instance) ([]*t
 	return p.DestroyInstanceAndStorage(ctx, instances, nil)
 }
 
-func (p *config) DestroyInstanceAndStorage(_ context.Context, _ []*types.Instance, _ *storage.CleanupType) ([]*types.Instance, error) {
-	time.Sleep(time.Duration(p.destroyWaitSecs) * time.Second)
+// DestroyInstanceAndStorage fails every instance when the simulated destroy
+// fails, the same as a cloud API call that could not be made.
+func (p *config) DestroyInstanceAndStorage(ctx context.Context, instances []*types.Instance, _ *storage.CleanupType) ([]*types.Instance, error) {
+	if err := p.sim.run(ctx, opDestroy); err != nil {
+		return instances, err
+	}
 	return nil, nil
 }
 
-func (p *config) Hibernate(_ context.Context, _, _, _ string) error {
-	time.Sleep(time.Duration(p.hibernateWaitSecs) * time.Second)
-	return nil
+func (p *config) Hibernate(ctx context.Context, _, _, _ string) error {
+	return p.sim.run(ctx, opHibernate)
 }
 
-func (p *config) Start(_ context.Context, _ *types.Instance, _ string) (ipAddress string, err error) {
-	time.Sleep(time.Duration(p.startWaitSecs) * time.Second)
-	return "127.0.0.1", nil
+func (p *config) Start(ctx context.Context, _ *types.Instance, _ string) (ipAddress string, err error) {
+	if err = p.sim.run(ctx, opStart); err != nil {
+		return "", err
+	}
+	return p.leIP, nil
 }
 
-func (p *config) SetTags(context.Context, *types.Instance, map[string]string) error {
-	time.Sleep(time.Duration(p.tagWaitSecs) * time.Second)
-	return nil
+func (p *config) SetTags(ctx context.Context, _ *types.Instance, _ map[string]string) error {
+	return p.sim.run(ctx, opSetTags)
 }
 
 func (p *config) SetLabels(context.Context, *types.Instance, map[string]string) error {
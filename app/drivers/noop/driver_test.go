@@ -0,0 +1,267 @@
+package noop
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/drivertest"
+	cf "github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func newTestDriver(t *testing.T, sim *cf.NoopSimulation, opts ...Option) *config {
+	t.Helper()
+	d, err := New(append([]Option{WithSimulation(sim)}, opts...)...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return d.(*config)
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, drivertest.Config{
+		NewDriver: func(t *testing.T) (drivers.Driver, drivertest.Backend) {
+			return newTestDriver(t, &cf.NoopSimulation{}, WithHibernate(true)), nil
+		},
+	})
+}
+
+func TestConformance_CapacityReservation(t *testing.T) {
+	drivertest.Run(t, drivertest.Config{
+		NewDriver: func(t *testing.T) (drivers.Driver, drivertest.Backend) {
+			return newTestDriver(t, &cf.NoopSimulation{CapacityReservation: true}), nil
+		},
+		ReservesCapacity: true,
+	})
+}
+
+func TestNew_DefaultWaits(t *testing.T) {
+	p := newTestDriver(t, nil)
+	if got := p.sim.sample(p.sim.ops[opCreate].latency); got != 15*time.Second {
+		t.Errorf("create latency = %s, want the fixed 15s", got)
+	}
+	if p.sim.ops[opCreate].failureRate != 0 {
+		t.Error("expected the default simulation to never fail")
+	}
+}
+
+func TestNew_InvalidSimulation(t *testing.T) {
+	tests := map[string]*cf.NoopSimulation{
+		"failure rate above 1":    {Create: cf.NoopOperation{FailureRate: 1.5}},
+		"unknown distribution":    {Destroy: cf.NoopOperation{Latency: cf.NoopLatency{Distribution: "pareto"}}},
+		"bad duration":            {Start: cf.NoopOperation{Latency: cf.NoopLatency{Mean: "ten seconds"}}},
+		"min above max":           {Start: cf.NoopOperation{Latency: cf.NoopLatency{Min: "10s", Max: "1s"}}},
+		"uniform without max":     {Hibernate: cf.NoopOperation{Latency: cf.NoopLatency{Distribution: "uniform", Min: "1s"}}},
+		"negative stockout rate":  {Stockouts: []cf.NoopStockout{{Zone: "a", Rate: -0.5}}},
+		"negative latency":        {SetTags: cf.NoopOperation{Latency: cf.NoopLatency{Mean: "-1s"}}},
+		"stockout rate above one": {Stockouts: []cf.NoopStockout{{Rate: 2}}},
+	}
+	for name, sim := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New(WithSimulation(sim)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestSimulation_Latency(t *testing.T) {
+	sim, err := newSimulation(&cf.NoopSimulation{Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		latency  cf.NoopLatency
+		min, max time.Duration
+	}{
+		{"fixed", cf.NoopLatency{Mean: "3s"}, 3 * time.Second, 3 * time.Second},
+		{"uniform", cf.NoopLatency{Distribution: "uniform", Min: "1s", Max: "2s"}, time.Second, 2 * time.Second},
+		{"clamped normal", cf.NoopLatency{Distribution: "normal", Mean: "10s", StdDev: "30s", Min: "5s", Max: "15s"}, 5 * time.Second, 15 * time.Second},
+		{"exponential", cf.NoopLatency{Distribution: "exponential", Mean: "1s", Max: "4s"}, 0, 4 * time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			op, err := parseOperation(cf.NoopOperation{Latency: tc.latency})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 1000; i++ {
+				if d := sim.sample(op.latency); d < tc.min || d > tc.max {
+					t.Fatalf("latency %s outside [%s, %s]", d, tc.min, tc.max)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulation_LatencyRespectsContext(t *testing.T) {
+	p := newTestDriver(t, &cf.NoopSimulation{Create: cf.NoopOperation{Latency: cf.NoopLatency{Mean: "1h"}}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Create(ctx, &types.InstanceCreateOpts{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the create to give up with the context, got %v", err)
+	}
+}
+
+func TestSimulation_Failures(t *testing.T) {
+	always := cf.NoopOperation{FailureRate: 1}
+	p := newTestDriver(t, &cf.NoopSimulation{
+		Create:    always,
+		Destroy:   always,
+		Hibernate: always,
+		Start:     always,
+		SetTags:   always,
+	}, WithHibernate(true))
+	ctx := context.Background()
+	instance := &types.Instance{ID: "vm"}
+
+	if _, err := p.Create(ctx, &types.InstanceCreateOpts{}); err == nil {
+		t.Error("expected create to fail")
+	}
+	failed, err := p.Destroy(ctx, []*types.Instance{instance})
+	if err == nil || len(failed) != 1 {
+		t.Errorf("expected destroy to fail the instance, got %v, %v", failed, err)
+	}
+	if err = p.Hibernate(ctx, instance.ID, "pool", ""); err == nil || !strings.Contains(err.Error(), "hibernate") {
+		t.Errorf("expected hibernate to fail, got %v", err)
+	}
+	if _, err = p.Start(ctx, instance, "pool"); err == nil {
+		t.Error("expected start to fail")
+	}
+	if err = p.SetTags(ctx, instance, nil); err == nil {
+		t.Error("expected set tags to fail")
+	}
+}
+
+func TestSimulation_SeedIsReproducible(t *testing.T) {
+	outcomes := func() []bool {
+		p := newTestDriver(t, &cf.NoopSimulation{Seed: 42, Create: cf.NoopOperation{FailureRate: 0.5}})
+		var got []bool
+		for i := 0; i < 50; i++ {
+			_, err := p.Create(context.Background(), &types.InstanceCreateOpts{})
+			got = append(got, err == nil)
+		}
+		return got
+	}
+	first, second := outcomes(), outcomes()
+	failures := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("outcome %d differs between runs with the same seed", i)
+		}
+		if !first[i] {
+			failures++
+		}
+	}
+	if failures == 0 || failures == len(first) {
+		t.Errorf("expected some creates to fail at a rate of 0.5, got %d of %d", failures, len(first))
+	}
+}
+
+func TestSimulation_Stockouts(t *testing.T) {
+	tests := []struct {
+		name            string
+		stockouts       []cf.NoopStockout
+		opts            *types.InstanceCreateOpts
+		wantZone        string
+		wantMachineType string
+		wantStockout    bool
+	}{
+		{
+			name:            "no stockout",
+			opts:            &types.InstanceCreateOpts{},
+			wantZone:        "zone-a",
+			wantMachineType: "small",
+		},
+		{
+			name:            "moves on to the next zone",
+			stockouts:       []cf.NoopStockout{{Zone: "zone-a"}},
+			opts:            &types.InstanceCreateOpts{},
+			wantZone:        "zone-b",
+			wantMachineType: "small",
+		},
+		{
+			name:            "stage zones take precedence",
+			stockouts:       []cf.NoopStockout{{Zone: "zone-a"}},
+			opts:            &types.InstanceCreateOpts{Zones: []string{"zone-c", "zone-a"}},
+			wantZone:        "zone-c",
+			wantMachineType: "small",
+		},
+		{
+			name:            "falls back to the next machine type",
+			stockouts:       []cf.NoopStockout{{MachineType: "small"}},
+			opts:            &types.InstanceCreateOpts{},
+			wantZone:        "zone-a",
+			wantMachineType: "medium",
+		},
+		{
+			name:         "out of capacity everywhere",
+			stockouts:    []cf.NoopStockout{{}},
+			opts:         &types.InstanceCreateOpts{},
+			wantStockout: true,
+		},
+		{
+			name:            "a reservation is placed in its zone",
+			stockouts:       []cf.NoopStockout{{}},
+			opts:            &types.InstanceCreateOpts{CapacityReservation: &types.CapacityReservation{Zone: strPtr("zone-b")}},
+			wantZone:        "zone-b",
+			wantMachineType: "small",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestDriver(t, &cf.NoopSimulation{
+				Zones:                []string{"zone-a", "zone-b"},
+				MachineType:          "small",
+				MachineTypeFallbacks: []string{"medium"},
+				Stockouts:            tc.stockouts,
+			})
+			instance, err := p.Create(context.Background(), tc.opts)
+			if tc.wantStockout {
+				if !isStockoutError(err) {
+					t.Fatalf("expected a stockout, got %v", err)
+				}
+				// the provisioner classifies create errors by their text
+				if !strings.Contains(err.Error(), "stockout") {
+					t.Errorf("stockout error %q does not say stockout", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if instance.Zone != tc.wantZone || instance.Size != tc.wantMachineType {
+				t.Errorf("instance placed in %q as %q, want %q as %q", instance.Zone, instance.Size, tc.wantZone, tc.wantMachineType)
+			}
+		})
+	}
+}
+
+func TestSimulation_ReserveCapacity(t *testing.T) {
+	p := newTestDriver(t, &cf.NoopSimulation{
+		Zones:               []string{"zone-a", "zone-b"},
+		Stockouts:           []cf.NoopStockout{{Zone: "zone-a"}},
+		CapacityReservation: true,
+	})
+	reservation, err := p.ReserveCapacity(context.Background(), &types.InstanceCreateOpts{PoolName: "pool"})
+	if err != nil {
+		t.Fatalf("ReserveCapacity: %v", err)
+	}
+	if reservation.GetZone() != "zone-b" || reservation.PoolName != "pool" || reservation.ReservationID == "" {
+		t.Errorf("unexpected reservation %+v", reservation)
+	}
+
+	p = newTestDriver(t, &cf.NoopSimulation{CapacityReservation: true, ReserveCapacity: cf.NoopOperation{FailureRate: 1}})
+	if _, err = p.ReserveCapacity(context.Background(), &types.InstanceCreateOpts{}); err == nil {
+		t.Error("expected the reservation to fail")
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -1,5 +1,7 @@
 package noop
 
+import cf "github.com/drone-runners/drone-runner-aws/command/config"
+
 type Option func(*config)
 
 // WithRootDirectory returns an OS specific temp directory
@@ -14,3 +16,11 @@ func WithHibernate(hibernate bool) Option {
 		p.hibernate = hibernate
 	}
 }
+
+// WithSimulation sets the latency and faults the driver injects. The driver
+// keeps its fixed waits when it is nil.
+func WithSimulation(simulation *cf.NoopSimulation) Option {
+	return func(p *config) {
+		p.simulationSpec = simulation
+	}
+}
@@ -0,0 +1,264 @@
+package noop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	cf "github.com/drone-runners/drone-runner-aws/command/config"
+)
+
+// Simulated operations.
+const (
+	opCreate          = "create"
+	opDestroy         = "destroy"
+	opHibernate       = "hibernate"
+	opStart           = "start"
+	opSetTags         = "set_tags"
+	opReserveCapacity = "reserve_capacity"
+	opDestroyCapacity = "destroy_capacity"
+)
+
+// Latency distributions.
+const (
+	distributionFixed       = "fixed"
+	distributionUniform     = "uniform"
+	distributionNormal      = "normal"
+	distributionExponential = "exponential"
+)
+
+type latency struct {
+	distribution string
+	mean         time.Duration
+	stddev       time.Duration
+	min          time.Duration
+	max          time.Duration
+}
+
+type operation struct {
+	latency     latency
+	failureRate float64
+}
+
+type stockout struct {
+	zone        string
+	machineType string
+	rate        float64
+}
+
+// simulation injects the latency and faults of the noop driver.
+type simulation struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+
+	ops                  map[string]operation
+	zones                []string
+	machineType          string
+	machineTypeFallbacks []string
+	stockouts            []stockout
+	capacityReservation  bool
+}
+
+// defaultSimulation keeps the fixed waits of the noop driver for pools that
+// do not configure a simulation.
+func defaultSimulation() *simulation {
+	fixed := func(d time.Duration) operation {
+		return operation{latency: latency{distribution: distributionFixed, mean: d}}
+	}
+	return &simulation{
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())), //nolint:gosec
+		ops: map[string]operation{
+			opCreate:    fixed(15 * time.Second),
+			opDestroy:   fixed(5 * time.Second),
+			opHibernate: fixed(5 * time.Second),
+			opStart:     fixed(10 * time.Second),
+			opSetTags:   fixed(time.Second),
+		},
+	}
+}
+
+func newSimulation(spec *cf.NoopSimulation) (*simulation, error) {
+	if spec == nil {
+		return defaultSimulation(), nil
+	}
+	seed := spec.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	s := &simulation{
+		rnd:                  rand.New(rand.NewSource(seed)), //nolint:gosec
+		ops:                  map[string]operation{},
+		zones:                spec.Zones,
+		machineType:          spec.MachineType,
+		machineTypeFallbacks: spec.MachineTypeFallbacks,
+		capacityReservation:  spec.CapacityReservation,
+	}
+	for name, op := range map[string]cf.NoopOperation{
+		opCreate:          spec.Create,
+		opDestroy:         spec.Destroy,
+		opHibernate:       spec.Hibernate,
+		opStart:           spec.Start,
+		opSetTags:         spec.SetTags,
+		opReserveCapacity: spec.ReserveCapacity,
+		opDestroyCapacity: spec.DestroyCapacity,
+	} {
+		parsed, err := parseOperation(op)
+		if err != nil {
+			return nil, fmt.Errorf("noop simulation: %s: %w", name, err)
+		}
+		s.ops[name] = parsed
+	}
+	for i, so := range spec.Stockouts {
+		rate := so.Rate
+		if rate == 0 {
+			rate = 1
+		}
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("noop simulation: stockouts[%d]: rate must be between 0 and 1, got %v", i, so.Rate)
+		}
+		s.stockouts = append(s.stockouts, stockout{zone: so.Zone, machineType: so.MachineType, rate: rate})
+	}
+	return s, nil
+}
+
+func parseOperation(op cf.NoopOperation) (operation, error) {
+	if op.FailureRate < 0 || op.FailureRate > 1 {
+		return operation{}, fmt.Errorf("failure rate must be between 0 and 1, got %v", op.FailureRate)
+	}
+	l := latency{distribution: op.Latency.Distribution}
+	if l.distribution == "" {
+		l.distribution = distributionFixed
+	}
+	switch l.distribution {
+	case distributionFixed, distributionUniform, distributionNormal, distributionExponential:
+	default:
+		return operation{}, fmt.Errorf("unknown latency distribution %q", l.distribution)
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"mean", op.Latency.Mean, &l.mean},
+		{"stddev", op.Latency.StdDev, &l.stddev},
+		{"min", op.Latency.Min, &l.min},
+		{"max", op.Latency.Max, &l.max},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return operation{}, fmt.Errorf("latency %s: %w", d.name, err)
+		}
+		if parsed < 0 {
+			return operation{}, fmt.Errorf("latency %s must not be negative", d.name)
+		}
+		*d.dst = parsed
+	}
+	if l.max > 0 && l.min > l.max {
+		return operation{}, fmt.Errorf("latency min %s is more than max %s", l.min, l.max)
+	}
+	if l.distribution == distributionUniform && l.max == 0 {
+		return operation{}, errors.New("uniform latency needs a max")
+	}
+	return operation{latency: l, failureRate: op.FailureRate}, nil
+}
+
+// sample returns a latency drawn from the distribution.
+func (s *simulation) sample(l latency) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var d time.Duration
+	switch l.distribution {
+	case distributionUniform:
+		d = l.min + time.Duration(s.rnd.Int63n(int64(l.max-l.min)+1))
+	case distributionNormal:
+		d = l.mean + time.Duration(s.rnd.NormFloat64()*float64(l.stddev))
+	case distributionExponential:
+		d = time.Duration(s.rnd.ExpFloat64() * float64(l.mean))
+	default:
+		d = l.mean
+	}
+	if d < l.min {
+		d = l.min
+	}
+	if l.max > 0 && d > l.max {
+		d = l.max
+	}
+	return d
+}
+
+// roll reports whether an event with the given probability happens.
+func (s *simulation) roll(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64() < probability
+}
+
+// run waits for the latency of the operation and then fails it at its
+// failure rate.
+func (s *simulation) run(ctx context.Context, name string) error {
+	op := s.ops[name]
+	if d := s.sample(op.latency); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if s.roll(op.failureRate) {
+		return fmt.Errorf("noop: simulated %s failure", name)
+	}
+	return nil
+}
+
+// stockoutError is returned when every zone tried is out of capacity for the
+// machine type.
+type stockoutError struct {
+	machineType string
+	zones       []string
+}
+
+func (e *stockoutError) Error() string {
+	return fmt.Sprintf("noop: simulated stockout of machine type %q in zones %v", e.machineType, e.zones)
+}
+
+func isStockoutError(err error) bool {
+	var stockoutErr *stockoutError
+	return errors.As(err, &stockoutErr)
+}
+
+// pickZone returns the first of the zones that has capacity for the machine
+// type. With no zones the VM is placed in no particular zone.
+func (s *simulation) pickZone(zones []string, machineType string) (string, error) {
+	if len(zones) == 0 {
+		zones = s.zones
+	}
+	if len(zones) == 0 {
+		zones = []string{""}
+	}
+	for _, zone := range zones {
+		if !s.stockedOut(zone, machineType) {
+			return zone, nil
+		}
+	}
+	return "", &stockoutError{machineType: machineType, zones: zones}
+}
+
+func (s *simulation) stockedOut(zone, machineType string) bool {
+	for _, so := range s.stockouts {
+		if (so.zone == "" || so.zone == zone) && (so.machineType == "" || so.machineType == machineType) && s.roll(so.rate) {
+			return true
+		}
+	}
+	return false
+}
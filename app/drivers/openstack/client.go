@@ -0,0 +1,427 @@
+package openstack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serviceCompute = "compute"
+	serviceNetwork = "network"
+
+	serverStatusActive           = "ACTIVE"
+	serverStatusError            = "ERROR"
+	serverStatusShelved          = "SHELVED"
+	serverStatusShelvedOffloaded = "SHELVED_OFFLOADED"
+
+	addressTypeFloating = "floating"
+
+	// tokenExpiryMargin is how long before expiry a token is renewed.
+	tokenExpiryMargin = time.Minute
+)
+
+// Credentials authenticates against Keystone v3 with either a password or an
+// application credential.
+type Credentials struct {
+	AuthURL                     string
+	Username                    string
+	Password                    string
+	UserDomain                  string
+	ProjectName                 string
+	ProjectID                   string
+	ProjectDomain               string
+	ApplicationCredentialID     string
+	ApplicationCredentialSecret string
+	Region                      string
+	// Interface selects the catalog endpoint interface, public by default.
+	Interface string
+}
+
+type createServerRequest struct {
+	Name             string              `json:"name"`
+	FlavorRef        string              `json:"flavorRef"`
+	ImageRef         string              `json:"imageRef"`
+	AvailabilityZone string              `json:"availability_zone,omitempty"`
+	KeyName          string              `json:"key_name,omitempty"`
+	UserData         string              `json:"user_data,omitempty"`
+	Metadata         map[string]string   `json:"metadata,omitempty"`
+	Networks         []serverNetwork     `json:"networks,omitempty"`
+	SecurityGroups   []serverSecurityRef `json:"security_groups,omitempty"`
+}
+
+type serverNetwork struct {
+	UUID string `json:"uuid"`
+}
+
+type serverSecurityRef struct {
+	Name string `json:"name"`
+}
+
+type serverAddress struct {
+	Addr    string `json:"addr"`
+	Version int    `json:"version"`
+	Type    string `json:"OS-EXT-IPS:type"`
+}
+
+type server struct {
+	ID               string                     `json:"id"`
+	Name             string                     `json:"name"`
+	Status           string                     `json:"status"`
+	AvailabilityZone string                     `json:"OS-EXT-AZ:availability_zone"`
+	Metadata         map[string]string          `json:"metadata"`
+	Addresses        map[string][]serverAddress `json:"addresses"`
+	Fault            *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"fault"`
+}
+
+type port struct {
+	ID string `json:"id"`
+}
+
+type floatingIP struct {
+	ID                string `json:"id"`
+	FloatingIPAddress string `json:"floating_ip_address"`
+	PortID            string `json:"port_id"`
+}
+
+type securityGroup struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type securityGroupRule struct {
+	SecurityGroupID string `json:"security_group_id"`
+	Direction       string `json:"direction"`
+	EtherType       string `json:"ethertype"`
+	Protocol        string `json:"protocol"`
+	PortRangeMin    int    `json:"port_range_min"`
+	PortRangeMax    int    `json:"port_range_max"`
+	RemoteIPPrefix  string `json:"remote_ip_prefix"`
+}
+
+// apiError is returned for non successful responses from any OpenStack service.
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("openstack: request failed with %d: %s", e.StatusCode, e.Message)
+}
+
+// isNotFound reports whether the error is an OpenStack 404 error.
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Client is a minimal OpenStack client covering the Nova and Neutron calls
+// used by the driver.
+type Client interface {
+	Authenticate(ctx context.Context) error
+
+	ServerCreate(ctx context.Context, in *createServerRequest) (*server, error)
+	ServerGet(ctx context.Context, id string) (*server, error)
+	ServerDelete(ctx context.Context, id string) error
+	ServerAction(ctx context.Context, id string, action map[string]interface{}) error
+	ServerMetadataUpdate(ctx context.Context, id string, metadata map[string]string) error
+	ServerConsoleOutput(ctx context.Context, id string, lines int) (string, error)
+
+	PortList(ctx context.Context, deviceID string) ([]*port, error)
+	FloatingIPList(ctx context.Context, portID string) ([]*floatingIP, error)
+	FloatingIPCreate(ctx context.Context, networkID, portID string) (*floatingIP, error)
+	FloatingIPDelete(ctx context.Context, id string) error
+	SecurityGroupList(ctx context.Context, name string) ([]*securityGroup, error)
+	SecurityGroupCreate(ctx context.Context, name, description string) (*securityGroup, error)
+	SecurityGroupRuleCreate(ctx context.Context, rule *securityGroupRule) error
+}
+
+type client struct {
+	client *http.Client
+	creds  Credentials
+
+	mu        sync.Mutex
+	token     string
+	expires   time.Time
+	endpoints map[string]string
+}
+
+func NewClient(creds *Credentials) Client {
+	if creds.Interface == "" {
+		creds.Interface = "public"
+	}
+	return &client{client: http.DefaultClient, creds: *creds}
+}
+
+// Authenticate issues a new Keystone token and resolves the compute and
+// network endpoints from the service catalog.
+func (c *client) Authenticate(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authenticate(ctx)
+}
+
+func (c *client) authenticate(ctx context.Context) error {
+	var identity map[string]interface{}
+	var scope map[string]interface{}
+	if c.creds.ApplicationCredentialID != "" {
+		// application credentials are already scoped to a project
+		identity = map[string]interface{}{
+			"methods": []string{"application_credential"},
+			"application_credential": map[string]string{
+				"id":     c.creds.ApplicationCredentialID,
+				"secret": c.creds.ApplicationCredentialSecret,
+			},
+		}
+	} else {
+		identity = map[string]interface{}{
+			"methods": []string{"password"},
+			"password": map[string]interface{}{
+				"user": map[string]interface{}{
+					"name":     c.creds.Username,
+					"password": c.creds.Password,
+					"domain":   map[string]string{"name": c.creds.UserDomain},
+				},
+			},
+		}
+		project := map[string]interface{}{}
+		if c.creds.ProjectID != "" {
+			project["id"] = c.creds.ProjectID
+		} else {
+			project["name"] = c.creds.ProjectName
+			project["domain"] = map[string]string{"name": c.creds.ProjectDomain}
+		}
+		scope = map[string]interface{}{"project": project}
+	}
+	auth := map[string]interface{}{"identity": identity}
+	if scope != nil {
+		auth["scope"] = scope
+	}
+
+	out := struct {
+		Token struct {
+			ExpiresAt time.Time `json:"expires_at"`
+			Catalog   []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					URL       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}{}
+	uri := strings.TrimSuffix(c.creds.AuthURL, "/") + "/auth/tokens"
+	header, err := c.send(ctx, http.MethodPost, uri, "", map[string]interface{}{"auth": auth}, &out)
+	if err != nil {
+		return fmt.Errorf("openstack: authentication failed: %w", err)
+	}
+
+	endpoints := map[string]string{}
+	for _, service := range out.Token.Catalog {
+		for _, endpoint := range service.Endpoints {
+			if endpoint.Interface != c.creds.Interface {
+				continue
+			}
+			if c.creds.Region != "" && endpoint.Region != c.creds.Region {
+				continue
+			}
+			endpoints[service.Type] = strings.TrimSuffix(endpoint.URL, "/")
+			break
+		}
+	}
+	if endpoints[serviceCompute] == "" {
+		return fmt.Errorf("openstack: no %s compute endpoint found in region %q", c.creds.Interface, c.creds.Region)
+	}
+	c.token = header.Get("X-Subject-Token")
+	c.expires = out.Token.ExpiresAt
+	c.endpoints = endpoints
+	return nil
+}
+
+func (c *client) ServerCreate(ctx context.Context, in *createServerRequest) (*server, error) {
+	out := struct {
+		Server *server `json:"server"`
+	}{}
+	err := c.do(ctx, serviceCompute, http.MethodPost, "/servers", map[string]interface{}{"server": in}, &out)
+	if err == nil && out.Server == nil {
+		err = errors.New("openstack: empty server response")
+	}
+	return out.Server, err
+}
+
+func (c *client) ServerGet(ctx context.Context, id string) (*server, error) {
+	out := struct {
+		Server *server `json:"server"`
+	}{}
+	if err := c.do(ctx, serviceCompute, http.MethodGet, "/servers/"+url.PathEscape(id), nil, &out); err != nil {
+		return nil, err
+	}
+	if out.Server == nil {
+		return nil, fmt.Errorf("openstack: empty server response for %s", id)
+	}
+	return out.Server, nil
+}
+
+func (c *client) ServerDelete(ctx context.Context, id string) error {
+	return c.do(ctx, serviceCompute, http.MethodDelete, "/servers/"+url.PathEscape(id), nil, nil)
+}
+
+func (c *client) ServerAction(ctx context.Context, id string, action map[string]interface{}) error {
+	return c.do(ctx, serviceCompute, http.MethodPost, "/servers/"+url.PathEscape(id)+"/action", action, nil)
+}
+
+func (c *client) ServerMetadataUpdate(ctx context.Context, id string, metadata map[string]string) error {
+	in := map[string]interface{}{"metadata": metadata}
+	return c.do(ctx, serviceCompute, http.MethodPost, "/servers/"+url.PathEscape(id)+"/metadata", in, nil)
+}
+
+func (c *client) ServerConsoleOutput(ctx context.Context, id string, lines int) (string, error) {
+	out := struct {
+		Output string `json:"output"`
+	}{}
+	in := map[string]interface{}{"os-getConsoleOutput": map[string]int{"length": lines}}
+	err := c.do(ctx, serviceCompute, http.MethodPost, "/servers/"+url.PathEscape(id)+"/action", in, &out)
+	return out.Output, err
+}
+
+func (c *client) PortList(ctx context.Context, deviceID string) ([]*port, error) {
+	out := struct {
+		Ports []*port `json:"ports"`
+	}{}
+	err := c.do(ctx, serviceNetwork, http.MethodGet, "/v2.0/ports?device_id="+url.QueryEscape(deviceID), nil, &out)
+	return out.Ports, err
+}
+
+func (c *client) FloatingIPList(ctx context.Context, portID string) ([]*floatingIP, error) {
+	out := struct {
+		FloatingIPs []*floatingIP `json:"floatingips"`
+	}{}
+	err := c.do(ctx, serviceNetwork, http.MethodGet, "/v2.0/floatingips?port_id="+url.QueryEscape(portID), nil, &out)
+	return out.FloatingIPs, err
+}
+
+func (c *client) FloatingIPCreate(ctx context.Context, networkID, portID string) (*floatingIP, error) {
+	out := struct {
+		FloatingIP *floatingIP `json:"floatingip"`
+	}{}
+	in := map[string]interface{}{"floatingip": map[string]string{
+		"floating_network_id": networkID,
+		"port_id":             portID,
+	}}
+	err := c.do(ctx, serviceNetwork, http.MethodPost, "/v2.0/floatingips", in, &out)
+	if err == nil && out.FloatingIP == nil {
+		err = errors.New("openstack: empty floating ip response")
+	}
+	return out.FloatingIP, err
+}
+
+func (c *client) FloatingIPDelete(ctx context.Context, id string) error {
+	return c.do(ctx, serviceNetwork, http.MethodDelete, "/v2.0/floatingips/"+url.PathEscape(id), nil, nil)
+}
+
+func (c *client) SecurityGroupList(ctx context.Context, name string) ([]*securityGroup, error) {
+	out := struct {
+		SecurityGroups []*securityGroup `json:"security_groups"`
+	}{}
+	err := c.do(ctx, serviceNetwork, http.MethodGet, "/v2.0/security-groups?name="+url.QueryEscape(name), nil, &out)
+	return out.SecurityGroups, err
+}
+
+func (c *client) SecurityGroupCreate(ctx context.Context, name, description string) (*securityGroup, error) {
+	out := struct {
+		SecurityGroup *securityGroup `json:"security_group"`
+	}{}
+	in := map[string]interface{}{"security_group": map[string]string{"name": name, "description": description}}
+	err := c.do(ctx, serviceNetwork, http.MethodPost, "/v2.0/security-groups", in, &out)
+	if err == nil && out.SecurityGroup == nil {
+		err = errors.New("openstack: empty security group response")
+	}
+	return out.SecurityGroup, err
+}
+
+func (c *client) SecurityGroupRuleCreate(ctx context.Context, rule *securityGroupRule) error {
+	in := map[string]interface{}{"security_group_rule": rule}
+	return c.do(ctx, serviceNetwork, http.MethodPost, "/v2.0/security-group-rules", in, nil)
+}
+
+// do sends a request to the given service, authenticating first when the
+// token is missing or about to expire and once more if it was rejected.
+func (c *client) do(ctx context.Context, service, method, path string, in, out interface{}) error {
+	token, base, err := c.endpoint(ctx, service, false)
+	if err != nil {
+		return err
+	}
+	_, err = c.send(ctx, method, base+path, token, in, out)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		if token, base, err = c.endpoint(ctx, service, true); err != nil {
+			return err
+		}
+		_, err = c.send(ctx, method, base+path, token, in, out)
+	}
+	return err
+}
+
+func (c *client) endpoint(ctx context.Context, service string, renew bool) (token, base string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if renew || c.token == "" || time.Now().Add(tokenExpiryMargin).After(c.expires) {
+		if err = c.authenticate(ctx); err != nil {
+			return "", "", err
+		}
+	}
+	base = c.endpoints[service]
+	if base == "" {
+		return "", "", fmt.Errorf("openstack: no %s endpoint in the service catalog", service)
+	}
+	return c.token, base, nil
+}
+
+func (c *client) send(ctx context.Context, method, uri, token string, in, out interface{}) (http.Header, error) {
+	var body io.Reader = http.NoBody
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Auth-Token", token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 { //nolint:mnd
+		raw, _ := io.ReadAll(resp.Body)
+		return resp.Header, &apiError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(raw))}
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+			return resp.Header, err
+		}
+	}
+	return resp.Header, nil
+}
@@ -0,0 +1,498 @@
+package openstack
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/lehelper"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/dchest/uniuri"
+)
+
+const (
+	defaultPollInterval  = 5 * time.Second
+	defaultSecurityGroup = "allow-docker"
+	consoleLogLines      = 500
+	maxMetadataLength    = 255
+	poolMetadata         = "pool"
+)
+
+var _ drivers.Driver = (*config)(nil)
+
+// config is a struct that implements drivers.Pool interface
+type config struct {
+	creds            Credentials
+	flavor           string
+	image            string
+	availabilityZone string
+	network          string
+	floatingNetwork  string
+	securityGroups   []string
+	keyPair          string
+	metadata         map[string]string
+	userData         string
+	rootDir          string
+	hibernate        bool
+
+	init         sync.Once
+	pollInterval time.Duration
+	client       Client
+}
+
+func New(opts ...Option) (drivers.Driver, error) {
+	p := new(config)
+	p.pollInterval = defaultPollInterval
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.flavor == "" || p.image == "" {
+		return nil, fmt.Errorf("openstack: flavor and image are required")
+	}
+	if p.client == nil {
+		if p.creds.AuthURL == "" {
+			return nil, fmt.Errorf("openstack: auth url is required")
+		}
+		p.client = NewClient(&p.creds)
+	}
+	return p, nil
+}
+
+func (p *config) DriverName() string {
+	return string(types.OpenStack)
+}
+
+func (p *config) InstanceType() string {
+	return p.image
+}
+
+func (p *config) RootDir() string {
+	return p.rootDir
+}
+
+func (p *config) CanHibernate() bool {
+	return p.hibernate
+}
+
+func (p *config) GetFullyQualifiedImage(_ context.Context, config *types.VMImageConfig) (string, error) {
+	if config.ImageName == "" {
+		return p.image, nil
+	}
+	return config.ImageName, nil
+}
+
+func (p *config) Ping(ctx context.Context) error {
+	return p.client.Authenticate(ctx)
+}
+
+// ReserveCapacity reserves capacity for a VM
+func (p *config) ReserveCapacity(ctx context.Context, opts *types.InstanceCreateOpts) (*types.CapacityReservation, error) {
+	return nil, &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// DestroyCapacity destroys capacity for a VM
+func (p *config) DestroyCapacity(ctx context.Context, capacity *types.CapacityReservation) (err error) {
+	return &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
+	p.init.Do(func() {
+		_ = p.setup(ctx)
+	})
+
+	startTime := time.Now()
+	name := fmt.Sprintf("%s-%s-%s", opts.RunnerName, opts.PoolName, uniuri.NewLen(8)) //nolint:mnd
+
+	flavor := p.flavor
+	if opts.MachineType != "" {
+		flavor = opts.MachineType
+	}
+	zone := p.availabilityZone
+	if len(opts.Zones) > 0 && opts.Zones[0] != "" {
+		zone = opts.Zones[0]
+	}
+	image, _ := p.GetFullyQualifiedImage(ctx, &opts.VMImageConfig)
+
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.OpenStack).
+		WithField("pool", opts.PoolName).
+		WithField("image", image).
+		WithField("flavor", flavor).
+		WithField("zone", zone).
+		WithField("name", name).
+		WithField("hibernate", p.CanHibernate())
+	logr.Infof("openstack: creating instance %s", name)
+
+	userData, err := lehelper.GenerateUserdata(p.userData, opts)
+	if err != nil {
+		logr.WithError(err).
+			Errorln("openstack: failed to generate user data")
+		return nil, err
+	}
+
+	in := &createServerRequest{
+		Name:             name,
+		FlavorRef:        flavor,
+		ImageRef:         image,
+		AvailabilityZone: zone,
+		KeyName:          p.keyPair,
+		UserData:         base64.StdEncoding.EncodeToString([]byte(userData)),
+		Metadata:         p.buildMetadata(opts),
+	}
+	if p.network != "" {
+		in.Networks = []serverNetwork{{UUID: p.network}}
+	}
+	for _, group := range p.groups() {
+		in.SecurityGroups = append(in.SecurityGroups, serverSecurityRef{Name: group})
+	}
+
+	srv, err := p.client.ServerCreate(ctx, in)
+	if err != nil {
+		logr.WithError(err).Errorln("openstack: failed to create server")
+		return nil, err
+	}
+	logr = logr.WithField("id", srv.ID)
+
+	srv, err = p.waitForStatus(ctx, srv.ID, serverStatusActive)
+	if err != nil {
+		logr.WithError(err).Errorln("openstack: server did not become active")
+		p.cleanupFailedInstance(srv, logr)
+		return nil, err
+	}
+
+	address := fixedAddress(srv)
+	if p.floatingNetwork != "" {
+		address, err = p.attachFloatingIP(ctx, srv.ID)
+		if err != nil {
+			logr.WithError(err).Errorln("openstack: failed to allocate floating ip")
+			p.cleanupFailedInstance(srv, logr)
+			return nil, err
+		}
+	}
+	if address == "" {
+		err = fmt.Errorf("openstack: server %s has no address", srv.ID)
+		logr.WithError(err).Errorln("openstack: cannot ascertain instance network")
+		p.cleanupFailedInstance(srv, logr)
+		return nil, err
+	}
+	if srv.AvailabilityZone != "" {
+		zone = srv.AvailabilityZone
+	}
+	logr.WithField("ip", address).Infof("openstack: instance created %s", name)
+
+	labelsBytes, err := json.Marshal(opts.InternalLabels)
+	if err != nil {
+		return nil, fmt.Errorf("scheduler: could not marshal labels: %v, err: %w", opts.InternalLabels, err)
+	}
+
+	return &types.Instance{
+		ID:                srv.ID,
+		Name:              name,
+		Provider:          types.OpenStack,
+		State:             types.StateProvisioning,
+		Pool:              opts.PoolName,
+		Image:             image,
+		Zone:              zone,
+		Size:              flavor,
+		Platform:          opts.Platform,
+		Address:           address,
+		CAKey:             opts.CAKey,
+		CACert:            opts.CACert,
+		TLSKey:            opts.TLSKey,
+		TLSCert:           opts.TLSCert,
+		Started:           startTime.Unix(),
+		Updated:           time.Now().Unix(),
+		IsHibernated:      false,
+		Port:              lehelper.LiteEnginePort,
+		StorageIdentifier: opts.StorageOpts.Identifier,
+		Labels:            labelsBytes,
+		ProxyURL:          opts.EgressProxyURL,
+	}, nil
+}
+
+func (p *config) Destroy(ctx context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+	return p.DestroyInstanceAndStorage(ctx, instances, nil)
+}
+
+// DestroyInstanceAndStorage releases the floating ips of the servers and
+// deletes them. Volumes are deleted with the server by Nova.
+func (p *config) DestroyInstanceAndStorage(ctx context.Context, instances []*types.Instance, _ *storage.CleanupType) ([]*types.Instance, error) {
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instance ids provided")
+	}
+
+	var failed []*types.Instance
+	var firstErr error
+	for _, instance := range instances {
+		logr := logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.OpenStack)
+		err := p.destroyServer(ctx, instance.ID)
+		if err != nil {
+			logr.WithError(err).Errorln("openstack: failed to delete server")
+			failed = append(failed, instance)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		logr.Debugln("openstack: server deleted")
+	}
+	return failed, firstErr
+}
+
+// Logs returns the tail of the server console log.
+func (p *config) Logs(ctx context.Context, instanceID string) (string, error) {
+	return p.client.ServerConsoleOutput(ctx, instanceID, consoleLogLines)
+}
+
+// Hibernate shelves the server, which releases its compute resources while
+// keeping the disk, ports and floating ip.
+func (p *config) Hibernate(ctx context.Context, instanceID, _, _ string) error {
+	logr := logger.FromContext(ctx).
+		WithField("id", instanceID).
+		WithField("driver", types.OpenStack)
+
+	if err := p.client.ServerAction(ctx, instanceID, map[string]interface{}{"shelve": nil}); err != nil {
+		logr.WithError(err).Errorln("openstack: failed to shelve server")
+		return err
+	}
+	if _, err := p.waitForStatus(ctx, instanceID, serverStatusShelved, serverStatusShelvedOffloaded); err != nil {
+		logr.WithError(err).Errorln("openstack: server did not shelve")
+		return err
+	}
+	return nil
+}
+
+// Start unshelves a hibernated server and returns its address.
+func (p *config) Start(ctx context.Context, instance *types.Instance, _ string) (string, error) {
+	logr := logger.FromContext(ctx).
+		WithField("id", instance.ID).
+		WithField("driver", types.OpenStack)
+
+	srv, err := p.client.ServerGet(ctx, instance.ID)
+	if err != nil {
+		return "", err
+	}
+	if srv.Status == serverStatusShelved || srv.Status == serverStatusShelvedOffloaded {
+		if err = p.client.ServerAction(ctx, instance.ID, map[string]interface{}{"unshelve": nil}); err != nil {
+			logr.WithError(err).Errorln("openstack: failed to unshelve server")
+			return "", err
+		}
+		if srv, err = p.waitForStatus(ctx, instance.ID, serverStatusActive); err != nil {
+			logr.WithError(err).Errorln("openstack: server did not become active")
+			return "", err
+		}
+	}
+	if address := floatingAddress(srv); address != "" {
+		return address, nil
+	}
+	if address := fixedAddress(srv); address != "" {
+		return address, nil
+	}
+	return instance.Address, nil
+}
+
+// SetTags merges the tags into the server metadata.
+func (p *config) SetTags(ctx context.Context, instance *types.Instance, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	metadata := make(map[string]string, len(tags))
+	for k, v := range tags {
+		metadata[k] = truncate(v)
+	}
+	if err := p.client.ServerMetadataUpdate(ctx, instance.ID, metadata); err != nil {
+		logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.OpenStack).
+			WithError(err).
+			Errorln("openstack: failed to update server metadata")
+		return err
+	}
+	return nil
+}
+
+func (p *config) SetLabels(ctx context.Context, instance *types.Instance, labels map[string]string) error {
+	return p.SetTags(ctx, instance, labels)
+}
+
+// setup creates a security group opening the docker and lite-engine ports
+// when the pool does not specify its own security groups.
+func (p *config) setup(ctx context.Context) error {
+	if len(p.securityGroups) == 0 {
+		return p.setupSecurityGroup(ctx)
+	}
+	return nil
+}
+
+func (p *config) setupSecurityGroup(ctx context.Context) error {
+	logr := logger.FromContext(ctx)
+
+	logr.Debugln("finding default security group")
+
+	groups, err := p.client.SecurityGroupList(ctx, defaultSecurityGroup)
+	if err == nil && len(groups) > 0 {
+		logr.Debugln("found default security group")
+		return nil
+	}
+
+	group, err := p.client.SecurityGroupCreate(ctx, defaultSecurityGroup, "allow docker and lite-engine ingress for build VMs")
+	if err != nil {
+		logr.WithError(err).
+			Errorln("cannot create security group")
+		return err
+	}
+	for _, port := range []int{2376, lehelper.LiteEnginePort} { //nolint:mnd
+		rule := &securityGroupRule{
+			SecurityGroupID: group.ID,
+			Direction:       "ingress",
+			EtherType:       "IPv4",
+			Protocol:        "tcp",
+			PortRangeMin:    port,
+			PortRangeMax:    port,
+			RemoteIPPrefix:  "0.0.0.0/0",
+		}
+		if err = p.client.SecurityGroupRuleCreate(ctx, rule); err != nil {
+			logr.WithError(err).
+				Errorln("cannot create security group rule")
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *config) groups() []string {
+	if len(p.securityGroups) == 0 {
+		return []string{defaultSecurityGroup}
+	}
+	return p.securityGroups
+}
+
+// attachFloatingIP allocates a floating ip on the floating network and binds
+// it to the first port of the server.
+func (p *config) attachFloatingIP(ctx context.Context, serverID string) (string, error) {
+	ports, err := p.client.PortList(ctx, serverID)
+	if err != nil {
+		return "", err
+	}
+	if len(ports) == 0 {
+		return "", fmt.Errorf("openstack: server %s has no ports", serverID)
+	}
+	fip, err := p.client.FloatingIPCreate(ctx, p.floatingNetwork, ports[0].ID)
+	if err != nil {
+		return "", err
+	}
+	return fip.FloatingIPAddress, nil
+}
+
+func (p *config) destroyServer(ctx context.Context, id string) error {
+	ports, err := p.client.PortList(ctx, id)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	for _, port := range ports {
+		fips, listErr := p.client.FloatingIPList(ctx, port.ID)
+		if listErr != nil {
+			return listErr
+		}
+		for _, fip := range fips {
+			if err = p.client.FloatingIPDelete(ctx, fip.ID); err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+	}
+	if err = p.client.ServerDelete(ctx, id); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// waitForStatus polls the server until it reaches one of the given states.
+func (p *config) waitForStatus(ctx context.Context, id string, statuses ...string) (*server, error) {
+	for {
+		srv, err := p.client.ServerGet(ctx, id)
+		if err != nil {
+			return &server{ID: id}, err
+		}
+		for _, status := range statuses {
+			if srv.Status == status {
+				return srv, nil
+			}
+		}
+		if srv.Status == serverStatusError {
+			msg := "unknown fault"
+			if srv.Fault != nil {
+				msg = srv.Fault.Message
+			}
+			return srv, fmt.Errorf("openstack: server %s is in error state: %s", id, msg)
+		}
+		select {
+		case <-ctx.Done():
+			return srv, ctx.Err()
+		case <-time.After(p.pollInterval):
+		}
+	}
+}
+
+func (p *config) cleanupFailedInstance(srv *server, logr logger.Logger) {
+	if err := p.destroyServer(context.Background(), srv.ID); err != nil {
+		logr.WithError(err).Warnln("openstack: failed to clean up server after failed create")
+	}
+}
+
+// buildMetadata returns the pool metadata plus the pool name and VM labels.
+func (p *config) buildMetadata(opts *types.InstanceCreateOpts) map[string]string {
+	metadata := map[string]string{}
+	for k, v := range p.metadata {
+		metadata[k] = truncate(v)
+	}
+	for k, v := range opts.VMLabels {
+		metadata[k] = truncate(v)
+	}
+	metadata[poolMetadata] = opts.PoolName
+	return metadata
+}
+
+// fixedAddress returns the first fixed IPv4 address, ordered by network name.
+func fixedAddress(srv *server) string {
+	return findAddress(srv, func(a serverAddress) bool { return a.Type != addressTypeFloating })
+}
+
+func floatingAddress(srv *server) string {
+	return findAddress(srv, func(a serverAddress) bool { return a.Type == addressTypeFloating })
+}
+
+func findAddress(srv *server, match func(serverAddress) bool) string {
+	networks := make([]string, 0, len(srv.Addresses))
+	for network := range srv.Addresses {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	for _, network := range networks {
+		for _, addr := range srv.Addresses[network] {
+			if addr.Version == 4 && match(addr) { //nolint:mnd
+				return addr.Addr
+			}
+		}
+	}
+	return ""
+}
+
+func truncate(v string) string {
+	if len(v) > maxMetadataLength {
+		return strings.TrimSpace(v[:maxMetadataLength])
+	}
+	return v
+}
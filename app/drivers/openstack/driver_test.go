@@ -0,0 +1,424 @@
+package openstack
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/drivertest"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// fakeCloud emulates Keystone token issuing together with the subset of the
+// Nova and Neutron APIs used by the driver.
+type fakeCloud struct {
+	mu          sync.Mutex
+	url         string
+	tokens      int
+	expireToken bool // reject the current token once to force a renewal
+	nextID      int
+	servers     map[string]map[string]interface{}
+	userData    map[string]string
+	groups      map[string]string
+	rules       []map[string]interface{}
+	fips        map[string]map[string]string
+	events      []string
+	failActive  bool
+}
+
+func newFakeCloud() *fakeCloud {
+	f := &fakeCloud{
+		servers:  map[string]map[string]interface{}{},
+		userData: map[string]string{},
+		groups:   map[string]string{},
+		fips:     map[string]map[string]string{},
+	}
+	return f
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (f *fakeCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/identity/v3/auth/tokens" {
+		f.tokens++
+		w.Header().Set("X-Subject-Token", fmt.Sprintf("token-%d", f.tokens))
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"token": map[string]interface{}{
+			"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+			"catalog": []map[string]interface{}{
+				{"type": "compute", "endpoints": []map[string]string{
+					{"interface": "internal", "region": "RegionOne", "url": "http://internal.invalid"},
+					{"interface": "public", "region": "RegionTwo", "url": "http://other-region.invalid"},
+					{"interface": "public", "region": "RegionOne", "url": f.url + "/compute/v2.1"},
+				}},
+				{"type": "network", "endpoints": []map[string]string{
+					{"interface": "public", "region": "RegionOne", "url": f.url + "/network"},
+				}},
+			},
+		}})
+		return
+	}
+	if r.Header.Get("X-Auth-Token") != fmt.Sprintf("token-%d", f.tokens) || f.expireToken {
+		f.expireToken = false
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "token expired"})
+		return
+	}
+
+	var in map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&in)
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/compute/v2.1/servers"):
+		f.serveCompute(w, r, strings.TrimPrefix(r.URL.Path, "/compute/v2.1/servers"), in)
+	case strings.HasPrefix(r.URL.Path, "/network/v2.0/"):
+		f.serveNetwork(w, r, strings.TrimPrefix(r.URL.Path, "/network/v2.0/"), in)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeCloud) serveCompute(w http.ResponseWriter, r *http.Request, path string, in map[string]interface{}) {
+	if path == "" && r.Method == http.MethodPost {
+		req := in["server"].(map[string]interface{})
+		f.nextID++
+		id := fmt.Sprintf("srv-%d", f.nextID)
+		decoded, _ := base64.StdEncoding.DecodeString(req["user_data"].(string))
+		f.userData[id] = string(decoded)
+		metadata := map[string]interface{}{}
+		if m, ok := req["metadata"].(map[string]interface{}); ok {
+			metadata = m
+		}
+		status := serverStatusActive
+		if f.failActive {
+			status = serverStatusError
+		}
+		f.servers[id] = map[string]interface{}{
+			"id": id, "name": req["name"], "status": status, "metadata": metadata,
+			"flavor": req["flavorRef"], "security_groups": req["security_groups"],
+			"OS-EXT-AZ:availability_zone": "nova",
+			"fault":                       map[string]interface{}{"code": 500, "message": "No valid host was found."},
+			"addresses": map[string]interface{}{"private": []map[string]interface{}{
+				{"addr": "fd00::" + id, "version": 6, "OS-EXT-IPS:type": "fixed"},
+				{"addr": fmt.Sprintf("192.168.0.%d", f.nextID), "version": 4, "OS-EXT-IPS:type": "fixed"},
+			}},
+		}
+		f.events = append(f.events, "create:"+id)
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"server": map[string]interface{}{"id": id}})
+		return
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	srv, ok := f.servers[parts[0]]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"itemNotFound": map[string]string{"message": "Instance could not be found"}})
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"server": srv})
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		delete(f.servers, parts[0])
+		f.events = append(f.events, "delete:"+parts[0])
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[1] == "metadata":
+		metadata := srv["metadata"].(map[string]interface{})
+		for k, v := range in["metadata"].(map[string]interface{}) {
+			metadata[k] = v
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"metadata": metadata})
+	case len(parts) == 2 && parts[1] == "action":
+		for action := range in {
+			f.events = append(f.events, action+":"+parts[0])
+			switch action {
+			case "shelve":
+				srv["status"] = serverStatusShelvedOffloaded
+			case "unshelve":
+				srv["status"] = serverStatusActive
+			case "os-getConsoleOutput":
+				writeJSON(w, http.StatusOK, map[string]string{"output": "cloud-init finished\n"})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeCloud) serveNetwork(w http.ResponseWriter, r *http.Request, path string, in map[string]interface{}) {
+	switch {
+	case path == "ports":
+		var ports []map[string]string
+		if _, ok := f.servers[r.URL.Query().Get("device_id")]; ok {
+			ports = append(ports, map[string]string{"id": "port-" + r.URL.Query().Get("device_id")})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ports": ports})
+	case path == "floatingips" && r.Method == http.MethodPost:
+		req := in["floatingip"].(map[string]interface{})
+		id := fmt.Sprintf("fip-%d", len(f.fips)+1)
+		fip := map[string]string{"id": id, "port_id": req["port_id"].(string), "floating_ip_address": fmt.Sprintf("203.0.113.%d", len(f.fips)+10)}
+		f.fips[id] = fip
+		f.events = append(f.events, "fip-create:"+req["floating_network_id"].(string))
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"floatingip": fip})
+	case path == "floatingips":
+		var fips []map[string]string
+		for _, fip := range f.fips {
+			if fip["port_id"] == r.URL.Query().Get("port_id") {
+				fips = append(fips, fip)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"floatingips": fips})
+	case strings.HasPrefix(path, "floatingips/") && r.Method == http.MethodDelete:
+		delete(f.fips, strings.TrimPrefix(path, "floatingips/"))
+		f.events = append(f.events, "fip-delete")
+		w.WriteHeader(http.StatusNoContent)
+	case path == "security-groups" && r.Method == http.MethodPost:
+		req := in["security_group"].(map[string]interface{})
+		name := req["name"].(string)
+		f.groups[name] = "sg-" + name
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"security_group": map[string]string{"id": f.groups[name], "name": name}})
+	case path == "security-groups":
+		var groups []map[string]string
+		if id, ok := f.groups[r.URL.Query().Get("name")]; ok {
+			groups = append(groups, map[string]string{"id": id, "name": r.URL.Query().Get("name")})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"security_groups": groups})
+	case path == "security-group-rules":
+		f.rules = append(f.rules, in["security_group_rule"].(map[string]interface{}))
+		writeJSON(w, http.StatusCreated, in)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestConfig(t *testing.T, fake *fakeCloud, opts ...Option) *config {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	fake.url = server.URL
+	opts = append([]Option{
+		WithCredentials(&Credentials{
+			AuthURL:     server.URL + "/identity/v3",
+			Username:    "runner",
+			Password:    "secret",
+			ProjectName: "ci",
+			Region:      "RegionOne",
+		}),
+		WithFlavor("m1.large"),
+		WithImage("ubuntu-22.04"),
+		WithNetwork("net-private"),
+		WithHibernate(true),
+	}, opts...)
+	d, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	p := d.(*config)
+	p.pollInterval = time.Millisecond
+	return p
+}
+
+func createOpts() *types.InstanceCreateOpts {
+	return &types.InstanceCreateOpts{
+		PoolName:   "linux",
+		RunnerName: "runner",
+		Platform:   types.Platform{OS: "linux", Arch: "amd64"},
+		CACert:     []byte("ca-cert"),
+		TLSCert:    []byte("tls-cert"),
+		TLSKey:     []byte("tls-key"),
+		VMLabels:   map[string]string{"account": "ACC1"},
+	}
+}
+
+func TestNew_Validation(t *testing.T) {
+	if _, err := New(WithCredentials(&Credentials{AuthURL: "http://keystone"})); err == nil {
+		t.Error("expected error without flavor and image")
+	}
+	if _, err := New(WithCredentials(&Credentials{}), WithFlavor("m1"), WithImage("img")); err == nil {
+		t.Error("expected error without auth url")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	fake := newFakeCloud()
+	p := newTestConfig(t, fake)
+
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if instance.ID != "srv-1" || instance.Address != "192.168.0.1" || instance.Zone != "nova" || instance.Size != "m1.large" || instance.Provider != types.OpenStack {
+		t.Errorf("unexpected instance %+v", instance)
+	}
+	srv := fake.servers["srv-1"]
+	metadata := srv["metadata"].(map[string]interface{})
+	if metadata["pool"] != "linux" || metadata["account"] != "ACC1" {
+		t.Errorf("metadata = %v", metadata)
+	}
+	if !strings.Contains(fake.userData["srv-1"], "ca-cert") {
+		t.Error("expected cloud-init user data")
+	}
+
+	// the default security group is created once, opening docker and lite-engine
+	if fake.groups[defaultSecurityGroup] == "" || len(fake.rules) != 2 {
+		t.Errorf("groups = %v rules = %v", fake.groups, fake.rules)
+	}
+	groups, _ := json.Marshal(srv["security_groups"])
+	if string(groups) != `[{"name":"allow-docker"}]` {
+		t.Errorf("security groups = %s", groups)
+	}
+	if _, err = p.Create(context.Background(), createOpts()); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if len(fake.rules) != 2 {
+		t.Errorf("expected security group setup to run once, got %d rules", len(fake.rules))
+	}
+}
+
+func TestCreate_FloatingIP(t *testing.T) {
+	fake := newFakeCloud()
+	p := newTestConfig(t, fake, WithFloatingNetwork("net-public"), WithSecurityGroups("default"))
+
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if instance.Address != "203.0.113.10" {
+		t.Errorf("address = %q", instance.Address)
+	}
+	if len(fake.groups) != 0 {
+		t.Error("expected no security group to be created when groups are configured")
+	}
+
+	failed, err := p.Destroy(context.Background(), []*types.Instance{instance})
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Destroy: failed=%v err=%v", failed, err)
+	}
+	if len(fake.fips) != 0 {
+		t.Errorf("expected floating ip released, got %v", fake.fips)
+	}
+	want := "create:srv-1,fip-create:net-public,fip-delete,delete:srv-1"
+	if got := strings.Join(fake.events, ","); got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+func TestCreate_ErrorStateCleansUp(t *testing.T) {
+	fake := newFakeCloud()
+	fake.failActive = true
+	p := newTestConfig(t, fake)
+
+	_, err := p.Create(context.Background(), createOpts())
+	if err == nil || !strings.Contains(err.Error(), "No valid host") {
+		t.Fatalf("expected fault message, got %v", err)
+	}
+	if len(fake.servers) != 0 {
+		t.Error("expected failed server to be deleted")
+	}
+}
+
+func TestHibernateAndStart(t *testing.T) {
+	fake := newFakeCloud()
+	p := newTestConfig(t, fake)
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err = p.Hibernate(context.Background(), instance.ID, "linux", ""); err != nil {
+		t.Fatalf("Hibernate: %v", err)
+	}
+	if fake.servers[instance.ID]["status"] != serverStatusShelvedOffloaded {
+		t.Fatal("expected the server to be shelved")
+	}
+	ip, err := p.Start(context.Background(), instance, "linux")
+	if err != nil || ip != instance.Address {
+		t.Fatalf("Start = %q, %v", ip, err)
+	}
+	events := len(fake.events)
+	if _, err = p.Start(context.Background(), instance, "linux"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if len(fake.events) != events {
+		t.Errorf("expected no unshelve for an active server, got %v", fake.events[events:])
+	}
+}
+
+func TestSetTagsAndLogs(t *testing.T) {
+	fake := newFakeCloud()
+	p := newTestConfig(t, fake)
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// an expired token is renewed transparently
+	fake.expireToken = true
+	if err = p.SetTags(context.Background(), instance, map[string]string{"retain": "true", "long": strings.Repeat("x", 300)}); err != nil {
+		t.Fatalf("SetTags: %v", err)
+	}
+	metadata := fake.servers[instance.ID]["metadata"].(map[string]interface{})
+	if metadata["retain"] != "true" || len(metadata["long"].(string)) != maxMetadataLength || metadata["pool"] != "linux" {
+		t.Errorf("metadata = %v", metadata)
+	}
+	if fake.tokens != 2 {
+		t.Errorf("expected the token to be renewed, issued %d", fake.tokens)
+	}
+
+	logs, err := p.Logs(context.Background(), instance.ID)
+	if err != nil || logs != "cloud-init finished\n" {
+		t.Errorf("Logs = %q, %v", logs, err)
+	}
+}
+
+func TestDestroy_NotFound(t *testing.T) {
+	fake := newFakeCloud()
+	p := newTestConfig(t, fake)
+
+	failed, err := p.Destroy(context.Background(), []*types.Instance{{ID: "missing"}})
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("expected a missing server to count as destroyed, got failed=%v err=%v", failed, err)
+	}
+	if _, err = p.Destroy(context.Background(), nil); err == nil {
+		t.Error("expected error without instances")
+	}
+}
+
+func (f *fakeCloud) Exists(instanceID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.servers[instanceID]
+	return ok
+}
+
+func (f *fakeCloud) Labels(instanceID string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	labels := map[string]string{}
+	metadata, _ := f.servers[instanceID]["metadata"].(map[string]interface{})
+	for k, v := range metadata {
+		labels[k], _ = v.(string)
+	}
+	return labels
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, drivertest.Config{
+		NewDriver: func(t *testing.T) (drivers.Driver, drivertest.Backend) {
+			fake := newFakeCloud()
+			return newTestConfig(t, fake), fake
+		},
+	})
+}
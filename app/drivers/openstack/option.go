@@ -0,0 +1,146 @@
+package openstack
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/app/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+type Option func(*config)
+
+func SetPlatformDefaults(platform *types.Platform) (*types.Platform, error) {
+	if platform.Arch == "" {
+		platform.Arch = oshelp.ArchAMD64
+	}
+	if platform.Arch != oshelp.ArchAMD64 && platform.Arch != oshelp.ArchARM64 {
+		return platform, fmt.Errorf("invalid arch %s, has to be '%s/%s'", platform.Arch, oshelp.ArchAMD64, oshelp.ArchARM64)
+	}
+	// verify that we are using sane values for OS
+	if platform.OS == "" {
+		platform.OS = oshelp.OSLinux
+	}
+	if platform.OS != oshelp.OSLinux {
+		return platform, fmt.Errorf("openstack - invalid OS %s, has to be '%s'", platform.OS, oshelp.OSLinux)
+	}
+	// set osname
+	if platform.OSName == "" {
+		platform.OSName = oshelp.Ubuntu
+	}
+	return platform, nil
+}
+
+// WithCredentials sets the Keystone credentials and the region.
+func WithCredentials(creds *Credentials) Option {
+	return func(p *config) {
+		if creds.UserDomain == "" {
+			creds.UserDomain = "Default"
+		}
+		if creds.ProjectDomain == "" {
+			creds.ProjectDomain = "Default"
+		}
+		p.creds = *creds
+	}
+}
+
+// WithFlavor sets the flavor name or ID.
+func WithFlavor(flavor string) Option {
+	return func(p *config) {
+		p.flavor = flavor
+	}
+}
+
+// WithImage sets the Glance image ID.
+func WithImage(image string) Option {
+	return func(p *config) {
+		p.image = image
+	}
+}
+
+func WithAvailabilityZone(zone string) Option {
+	return func(p *config) {
+		p.availabilityZone = zone
+	}
+}
+
+// WithNetwork sets the network the server port is created on.
+func WithNetwork(network string) Option {
+	return func(p *config) {
+		p.network = network
+	}
+}
+
+// WithFloatingNetwork enables allocating a floating ip from the given
+// external network for every server.
+func WithFloatingNetwork(network string) Option {
+	return func(p *config) {
+		p.floatingNetwork = network
+	}
+}
+
+// WithSecurityGroups sets the security groups of the server. When empty a
+// group allowing the docker and lite-engine ports is created.
+func WithSecurityGroups(groups ...string) Option {
+	return func(p *config) {
+		p.securityGroups = groups
+	}
+}
+
+func WithKeyPair(keyPair string) Option {
+	return func(p *config) {
+		p.keyPair = keyPair
+	}
+}
+
+// WithMetadata sets additional server metadata.
+func WithMetadata(metadata map[string]string) Option {
+	return func(p *config) {
+		p.metadata = metadata
+	}
+}
+
+func WithHibernate(hibernate bool) Option {
+	return func(p *config) {
+		p.hibernate = hibernate
+	}
+}
+
+// WithClient overrides the API client.
+func WithClient(client Client) Option {
+	return func(p *config) {
+		p.client = client
+	}
+}
+
+func WithUserData(text, path string) Option {
+	if text != "" {
+		return func(p *config) {
+			p.userData = text
+		}
+	}
+	return func(p *config) {
+		if path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logrus.WithError(err).
+					Fatalln("failed to read user_data file")
+				return
+			}
+			p.userData = string(data)
+		}
+	}
+}
+
+// WithRootDirectory sets the root directory for the virtual machine.
+func WithRootDirectory(dir string) Option {
+	return func(p *config) {
+		if dir == "" {
+			p.rootDir = oshelp.JoinPaths(oshelp.OSLinux, "/tmp", "openstack")
+		} else {
+			p.rootDir = dir
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Orphaned resource kinds and collection outcomes. Bounded set, used as metric labels.
+const (
+	OrphanKindDisk                = "disk"
+	OrphanKindCapacityReservation = "capacity_reservation"
+
+	OrphanOutcomeDeleted = "deleted"
+	OrphanOutcomeFailed  = "failed"
+	OrphanOutcomeDryRun  = "dry_run"
+)
+
+// CloudResource is a persistent disk or a capacity reservation as listed by
+// the cloud provider.
+type CloudResource struct {
+	// ID is the ID of the resource in the cloud.
+	ID string
+	// Name is what the rows reference the resource by: the disk name, one of
+	// the storage identifiers of its instance, or the reservation ID of its
+	// capacity reservation.
+	Name     string
+	Zone     string
+	Created  time.Time
+	Labels   map[string]string
+	Attached bool
+}
+
+// DiskCollector is implemented by drivers that create persistent disks
+// carrying the identity labels of their VM.
+type DiskCollector interface {
+	// ListDisks returns the persistent disks carrying all the given labels.
+	ListDisks(ctx context.Context, labels map[string]string) ([]*CloudResource, error)
+	// DeleteDisk deletes a persistent disk returned by ListDisks.
+	DeleteDisk(ctx context.Context, disk *CloudResource) error
+}
+
+// CapacityReservationLister is implemented by drivers that can enumerate the
+// capacity reservations they created. They are deleted with DestroyCapacity.
+type CapacityReservationLister interface {
+	// ListCapacityReservations returns the active capacity reservations
+	// created with all the given labels.
+	ListCapacityReservations(ctx context.Context, labels map[string]string) ([]*CloudResource, error)
+}
+
+// OrphanCollectionOptions configures Manager.CollectOrphans.
+type OrphanCollectionOptions struct {
+	// GracePeriod is how old a resource must be before it is collected. It
+	// covers the window between a resource being created and the row that
+	// references it being stored.
+	GracePeriod time.Duration
+	// DryRun only reports what would be deleted.
+	DryRun bool
+}
+
+// CollectOrphans deletes the persistent disks and capacity reservations of
+// every pool that no instance or capacity reservation row references, as left
+// behind when the runner stops between creating a resource and storing it.
+// Disks still attached to a VM or retained, as gitspace disks are between two
+// starts of their gitspace, are never deleted. Like ReconcileInstances this
+// relies on the identity labels written in hosted mode.
+func (m *Manager) CollectOrphans(ctx context.Context, opts OrphanCollectionOptions) error {
+	// rows of every pool, a disk is reused by name and may move across pools
+	rows, err := m.instanceStore.List(ctx, "", &types.QueryParams{})
+	if err != nil {
+		return err
+	}
+	disks := map[string]bool{}
+	for _, row := range rows {
+		for _, id := range strings.Split(row.StorageIdentifier, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				disks[id] = true
+			}
+		}
+	}
+	var reservations map[string]bool
+	if m.capacityReservationStore != nil {
+		capacities, listErr := m.capacityReservationStore.List(ctx, &types.CapacityReservationQueryParams{}, nil)
+		if listErr != nil {
+			return listErr
+		}
+		reservations = make(map[string]bool, len(capacities))
+		for _, capacity := range capacities {
+			reservations[capacity.ReservationID] = true
+		}
+	}
+
+	var errs []error
+	for _, pool := range m.poolMap {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+				}
+			}()
+			if err := m.collectPoolOrphans(ctx, pool, disks, reservations, opts); err != nil {
+				errs = append(errs, fmt.Errorf("pool %s: %w", pool.Name, err))
+			}
+		}()
+	}
+	return errors.Join(errs...)
+}
+
+// collectPoolOrphans deletes the orphaned resources of a pool. Reservations
+// are only collected when reservations is not nil, that is when the capacity
+// reservation rows are known.
+func (m *Manager) collectPoolOrphans(ctx context.Context, pool *poolEntry, disks, reservations map[string]bool, opts OrphanCollectionOptions) error {
+	labels := reconcileLabels(pool.Name, m.env)
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	orphaned := func(r *CloudResource, referenced map[string]bool) bool {
+		return r.Labels["harness_env"] == m.env && !referenced[r.Name] && !r.Created.After(cutoff)
+	}
+
+	var errs []error
+	for tenantID, driver := range poolDrivers(&pool.Pool) {
+		logr := logger.FromContext(ctx).
+			WithField("driver", driver.DriverName()).
+			WithField("pool", pool.Name).
+			WithField("tenant_id", tenantID)
+
+		if collector, ok := As[DiskCollector](driver); ok {
+			found, err := collector.ListDisks(ctx, labels)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			for _, disk := range found {
+				if disk.Attached || disk.Labels[LabelRetain] == "true" || !orphaned(disk, disks) {
+					continue
+				}
+				m.collectOrphan(logr, pool.Name, OrphanKindDisk, disk, opts.DryRun, func() error {
+					return collector.DeleteDisk(ctx, disk)
+				})
+			}
+		}
+
+		if lister, ok := As[CapacityReservationLister](driver); ok && reservations != nil {
+			found, err := lister.ListCapacityReservations(ctx, labels)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			for _, reservation := range found {
+				if !orphaned(reservation, reservations) {
+					continue
+				}
+				m.collectOrphan(logr, pool.Name, OrphanKindCapacityReservation, reservation, opts.DryRun, func() error {
+					return driver.DestroyCapacity(ctx, &types.CapacityReservation{
+						PoolName:      pool.Name,
+						ReservationID: reservation.Name,
+						Zone:          types.StringPtr(reservation.Zone),
+					})
+				})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// collectOrphan deletes one orphaned resource, unless dryRun is set.
+func (m *Manager) collectOrphan(logr logger.Logger, poolName, kind string, r *CloudResource, dryRun bool, remove func() error) {
+	logr = logr.WithField("kind", kind).
+		WithField("id", r.ID).
+		WithField("name", r.Name).
+		WithField("zone", r.Zone).
+		WithField("age", time.Since(r.Created).Round(time.Second))
+	outcome := OrphanOutcomeDeleted
+	if dryRun {
+		outcome = OrphanOutcomeDryRun
+		logr.Infoln("orphans: dry run, would delete orphaned resource")
+	} else if err := remove(); err != nil {
+		outcome = OrphanOutcomeFailed
+		logr.WithError(err).Errorln("orphans: failed to delete orphaned resource")
+	} else {
+		logr.Warnln("orphans: deleted orphaned resource")
+	}
+	if m.metrics != nil {
+		m.metrics.RecordOrphanedResource(poolName, kind, outcome)
+	}
+}
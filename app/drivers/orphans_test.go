@@ -0,0 +1,148 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// collectingMockDriver is a flexibleMockDriver with persistent disks and
+// capacity reservations.
+type collectingMockDriver struct {
+	*flexibleMockDriver
+	disks        []*CloudResource
+	reservations []*CloudResource
+	err          error
+	deleted      []string
+}
+
+func (d *collectingMockDriver) ListDisks(context.Context, map[string]string) ([]*CloudResource, error) {
+	return d.disks, d.err
+}
+
+func (d *collectingMockDriver) DeleteDisk(_ context.Context, disk *CloudResource) error {
+	d.deleted = append(d.deleted, disk.ID)
+	return nil
+}
+
+func (d *collectingMockDriver) ListCapacityReservations(context.Context, map[string]string) ([]*CloudResource, error) {
+	return d.reservations, d.err
+}
+
+func newOrphanTestManager(rows []*types.Instance, capacities []*types.CapacityReservation, driver *collectingMockDriver) *Manager {
+	return &Manager{
+		env: "prod",
+		instanceStore: &mockInstanceStore{
+			ListFunc: func(context.Context, string, *types.QueryParams) ([]*types.Instance, error) {
+				return rows, nil
+			},
+		},
+		capacityReservationStore: &mockCapacityReservationStore{
+			ListFunc: func(context.Context, *types.CapacityReservationQueryParams, []types.CapacityReservationState) ([]*types.CapacityReservation, error) {
+				return capacities, nil
+			},
+		},
+		poolMap: map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: driver}}},
+	}
+}
+
+func TestManager_CollectOrphans(t *testing.T) {
+	old := time.Now().Add(-3 * time.Hour)
+	prod := map[string]string{"harness_env": "prod"}
+	var destroyedReservations []string
+	driver := &collectingMockDriver{
+		flexibleMockDriver: &flexibleMockDriver{
+			driverName: "google",
+			DestroyCapacityFunc: func(_ context.Context, capacity *types.CapacityReservation) error {
+				destroyedReservations = append(destroyedReservations, capacity.ReservationID+"@"+*capacity.Zone)
+				return nil
+			},
+		},
+		disks: []*CloudResource{
+			{ID: "vol-1", Name: "referenced", Created: old, Labels: prod},
+			{ID: "vol-2", Name: "shared", Created: old, Labels: prod},
+			{ID: "vol-3", Name: "orphan", Created: old, Labels: prod},
+			{ID: "vol-4", Name: "attached", Created: old, Labels: prod, Attached: true},
+			{ID: "vol-5", Name: "retained", Created: old, Labels: map[string]string{"harness_env": "prod", LabelRetain: "true"}},
+			{ID: "vol-6", Name: "young", Created: time.Now(), Labels: prod},
+			{ID: "vol-7", Name: "other-env", Created: old, Labels: map[string]string{"harness_env": "qa"}},
+		},
+		reservations: []*CloudResource{
+			{ID: "cr-1", Name: "cr-1", Zone: "us-east1-b", Created: old, Labels: prod},
+			{ID: "cr-2", Name: "cr-2", Zone: "us-east1-c", Created: old, Labels: prod},
+		},
+	}
+	rows := []*types.Instance{
+		{ID: "1", StorageIdentifier: "referenced"},
+		{ID: "2", StorageIdentifier: "other, shared"},
+	}
+	m := newOrphanTestManager(rows, []*types.CapacityReservation{{ReservationID: "cr-1"}}, driver)
+	metrics := &fakePurgerMetrics{}
+	m.metrics = metrics
+
+	err := m.CollectOrphans(context.Background(), OrphanCollectionOptions{GracePeriod: time.Hour})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol-3"}, driver.deleted)
+	assert.Equal(t, []string{"cr-2@us-east1-c"}, destroyedReservations)
+	assert.Equal(t, []orphanedResourceRecord{
+		{"pool1", OrphanKindDisk, OrphanOutcomeDeleted},
+		{"pool1", OrphanKindCapacityReservation, OrphanOutcomeDeleted},
+	}, metrics.orphans)
+}
+
+func TestManager_CollectOrphans_DryRun(t *testing.T) {
+	old := time.Now().Add(-3 * time.Hour)
+	driver := &collectingMockDriver{
+		flexibleMockDriver: &flexibleMockDriver{
+			driverName: "google",
+			DestroyCapacityFunc: func(context.Context, *types.CapacityReservation) error {
+				t.Fatal("a dry run must not delete reservations")
+				return nil
+			},
+		},
+		disks:        []*CloudResource{{ID: "orphan", Name: "orphan", Created: old, Labels: map[string]string{"harness_env": "prod"}}},
+		reservations: []*CloudResource{{ID: "cr", Name: "cr", Created: old, Labels: map[string]string{"harness_env": "prod"}}},
+	}
+	m := newOrphanTestManager(nil, nil, driver)
+	metrics := &fakePurgerMetrics{}
+	m.metrics = metrics
+
+	assert.NoError(t, m.CollectOrphans(context.Background(), OrphanCollectionOptions{GracePeriod: time.Hour, DryRun: true}))
+	assert.Empty(t, driver.deleted)
+	assert.Equal(t, []orphanedResourceRecord{
+		{"pool1", OrphanKindDisk, OrphanOutcomeDryRun},
+		{"pool1", OrphanKindCapacityReservation, OrphanOutcomeDryRun},
+	}, metrics.orphans)
+}
+
+func TestManager_CollectOrphans_NoReservationStore(t *testing.T) {
+	driver := &collectingMockDriver{
+		flexibleMockDriver: &flexibleMockDriver{
+			driverName: "google",
+			DestroyCapacityFunc: func(context.Context, *types.CapacityReservation) error {
+				t.Fatal("reservations must not be collected without their rows")
+				return nil
+			},
+		},
+		reservations: []*CloudResource{{ID: "cr", Name: "cr", Created: time.Now().Add(-3 * time.Hour), Labels: map[string]string{"harness_env": "prod"}}},
+	}
+	m := newOrphanTestManager(nil, nil, driver)
+	m.capacityReservationStore = nil
+
+	assert.NoError(t, m.CollectOrphans(context.Background(), OrphanCollectionOptions{GracePeriod: time.Hour}))
+}
+
+func TestManager_CollectOrphans_ListError(t *testing.T) {
+	driver := &collectingMockDriver{
+		flexibleMockDriver: &flexibleMockDriver{driverName: "google"},
+		err:                assert.AnError,
+	}
+	m := newOrphanTestManager(nil, nil, driver)
+
+	assert.ErrorIs(t, m.CollectOrphans(context.Background(), OrphanCollectionOptions{}), assert.AnError)
+	assert.Empty(t, driver.deleted)
+}
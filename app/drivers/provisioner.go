@@ -219,6 +219,7 @@ func (m *Manager) setupInstance(
 		createOptions.ResourceClass = setupParams.ResourceClass
 		createOptions.Zones = setupParams.Zones
 		createOptions.MachineType = setupParams.MachineType
+		createOptions.MachineTypeFallbacks = setupParams.MachineTypeFallbacks
 		createOptions.NestedVirtualization = setupParams.NestedVirtualization
 		createOptions.GPU = setupParams.GPU
 		createOptions.StageRuntimeID = setupParams.StageRuntimeID
@@ -265,6 +266,9 @@ func (m *Manager) setupInstance(
 	source := resolveInstanceSource(setupParams)
 	if createOptions.IsHosted {
 		createOptions.VMLabels = buildIdentityVMLabels(setupParams, timeout, m.env, pool.Name, source)
+		if retain == "true" {
+			createOptions.VMLabels[LabelRetain] = retain
+		}
 	}
 	driver := pool.DriverForTenant(tenantID)
 	createOptions.DriverName = driver.DriverName()
@@ -516,6 +520,10 @@ func deepCopySetupParams(params *types.SetupInstanceParams) *types.SetupInstance
 		result.Zones = make([]string, len(params.Zones))
 		copy(result.Zones, params.Zones)
 	}
+	if len(params.MachineTypeFallbacks) > 0 {
+		result.MachineTypeFallbacks = make([]string, len(params.MachineTypeFallbacks))
+		copy(result.MachineTypeFallbacks, params.MachineTypeFallbacks)
+	}
 
 	return &result
 }
@@ -580,6 +588,10 @@ const (
 	LabelStageExecutionID    = "harness-stage-execution-id"
 	LabelCreatedAt           = "harness-created-at"
 	LabelLongRunning         = "harness-long-running"
+	// LabelRetain marks a VM retained past the purger's max age, for
+	// SkipCloudVMCleanup or gitspaces, so that reconciliation leaves it
+	// alone even when it has no instance row.
+	LabelRetain = "harness-retain"
 
 	identityCreatedBy            = "harness-ci"
 	longRunningStageThresholdSec = int64(24 * 60 * 60)
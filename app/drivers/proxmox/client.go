@@ -0,0 +1,204 @@
+package proxmox
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	taskStatusStopped = "stopped"
+	taskExitOK        = "OK"
+
+	vmStatusRunning = "running"
+	vmStatusStopped = "stopped"
+	qmpStatusPaused = "paused"
+)
+
+// errNotFound is returned when the VM or its configuration does not exist.
+var errNotFound = errors.New("proxmox: vm does not exist")
+
+type taskStatus struct {
+	Status     string `json:"status"`
+	ExitStatus string `json:"exitstatus"`
+}
+
+type vmStatus struct {
+	Status    string `json:"status"`
+	QMPStatus string `json:"qmpstatus"`
+	Name      string `json:"name"`
+	Uptime    int64  `json:"uptime"`
+}
+
+type vmConfig struct {
+	Name string `json:"name"`
+	Tags string `json:"tags"`
+}
+
+type agentInterfaces struct {
+	Result []struct {
+		Name        string `json:"name"`
+		IPAddresses []struct {
+			Type    string `json:"ip-address-type"`
+			Address string `json:"ip-address"`
+		} `json:"ip-addresses"`
+	} `json:"result"`
+}
+
+// Client is a minimal Proxmox VE API client covering the qemu lifecycle calls
+// used by the driver. Mutating calls return the UPID of the spawned task.
+type Client interface {
+	Version(ctx context.Context) error
+	NextID(ctx context.Context) (int, error)
+	Clone(ctx context.Context, node string, template, vmid int, params url.Values) (string, error)
+	SetConfig(ctx context.Context, node string, vmid int, params url.Values) error
+	GetConfig(ctx context.Context, node string, vmid int) (*vmConfig, error)
+	Status(ctx context.Context, node string, vmid int) (*vmStatus, error)
+	StatusAction(ctx context.Context, node string, vmid int, action string, params url.Values) (string, error)
+	Delete(ctx context.Context, node string, vmid int) (string, error)
+	AgentInterfaces(ctx context.Context, node string, vmid int) (*agentInterfaces, error)
+	TaskStatus(ctx context.Context, node, upid string) (*taskStatus, error)
+}
+
+type client struct {
+	client *http.Client
+	addr   string
+	token  string
+}
+
+// NewClient returns a client for the Proxmox VE API at uri, authenticating
+// with an API token of the form USER@REALM!TOKENID=SECRET.
+func NewClient(uri, token string, insecure bool) Client {
+	httpClient := http.DefaultClient
+	if insecure {
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+			},
+		}
+	}
+	return &client{httpClient, strings.TrimSuffix(uri, "/") + "/api2/json", token}
+}
+
+func (c *client) Version(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/version", nil, nil)
+}
+
+func (c *client) NextID(ctx context.Context) (int, error) {
+	var out json.Number
+	if err := c.do(ctx, http.MethodGet, "/cluster/nextid", nil, &out); err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(out.String())
+	if err != nil {
+		return 0, fmt.Errorf("proxmox: invalid next id %q: %w", out, err)
+	}
+	return id, nil
+}
+
+func (c *client) Clone(ctx context.Context, node string, template, vmid int, params url.Values) (string, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("newid", strconv.Itoa(vmid))
+	var upid string
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("/nodes/%s/qemu/%d/clone", url.PathEscape(node), template), params, &upid)
+	return upid, err
+}
+
+func (c *client) SetConfig(ctx context.Context, node string, vmid int, params url.Values) error {
+	return c.do(ctx, http.MethodPut, fmt.Sprintf("/nodes/%s/qemu/%d/config", url.PathEscape(node), vmid), params, nil)
+}
+
+func (c *client) GetConfig(ctx context.Context, node string, vmid int) (*vmConfig, error) {
+	out := new(vmConfig)
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/nodes/%s/qemu/%d/config", url.PathEscape(node), vmid), nil, out)
+	return out, err
+}
+
+func (c *client) Status(ctx context.Context, node string, vmid int) (*vmStatus, error) {
+	out := new(vmStatus)
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/nodes/%s/qemu/%d/status/current", url.PathEscape(node), vmid), nil, out)
+	return out, err
+}
+
+func (c *client) StatusAction(ctx context.Context, node string, vmid int, action string, params url.Values) (string, error) {
+	var upid string
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("/nodes/%s/qemu/%d/status/%s", url.PathEscape(node), vmid, action), params, &upid)
+	return upid, err
+}
+
+func (c *client) Delete(ctx context.Context, node string, vmid int) (string, error) {
+	var upid string
+	path := fmt.Sprintf("/nodes/%s/qemu/%d?purge=1&destroy-unreferenced-disks=1", url.PathEscape(node), vmid)
+	err := c.do(ctx, http.MethodDelete, path, nil, &upid)
+	return upid, err
+}
+
+func (c *client) AgentInterfaces(ctx context.Context, node string, vmid int) (*agentInterfaces, error) {
+	out := new(agentInterfaces)
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/nodes/%s/qemu/%d/agent/network-get-interfaces", url.PathEscape(node), vmid), nil, out)
+	return out, err
+}
+
+func (c *client) TaskStatus(ctx context.Context, node, upid string) (*taskStatus, error) {
+	out := new(taskStatus)
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/nodes/%s/tasks/%s/status", url.PathEscape(node), url.PathEscape(upid)), nil, out)
+	return out, err
+}
+
+// do sends a form encoded request and decodes the "data" member of the response envelope.
+func (c *client) do(ctx context.Context, method, path string, params url.Values, out interface{}) error {
+	var body io.Reader = http.NoBody
+	if params != nil {
+		body = strings.NewReader(params.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "PVEAPIToken="+c.token)
+	if params != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode > 299 { //nolint:mnd
+		// Proxmox reports most errors in the status line, e.g.
+		// "500 Configuration file 'nodes/pve/qemu-server/101.conf' does not exist".
+		msg := strings.TrimSpace(resp.Status + " " + string(raw))
+		if resp.StatusCode == http.StatusNotFound || strings.Contains(msg, "does not exist") {
+			return fmt.Errorf("%w: %s", errNotFound, msg)
+		}
+		return fmt.Errorf("proxmox: %s %s: %s", method, path, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	envelope := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err = json.Unmarshal(raw, &envelope); err != nil {
+		return fmt.Errorf("proxmox: cannot decode response: %w", err)
+	}
+	if len(envelope.Data) == 0 || string(envelope.Data) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(envelope.Data)))
+	decoder.UseNumber()
+	return decoder.Decode(out)
+}
@@ -0,0 +1,561 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/lehelper"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
+	"github.com/drone-runners/drone-runner-aws/types"
+
+	"github.com/dchest/uniuri"
+)
+
+const (
+	defaultPollInterval = 2 * time.Second
+	defaultIPTimeout    = 5 * time.Minute
+	tagSeparator        = ";"
+)
+
+var _ drivers.Driver = (*config)(nil)
+
+// invalidTagChars matches characters Proxmox does not accept in tags.
+var invalidTagChars = regexp.MustCompile(`[^a-z0-9_+.\-]`)
+
+// config is a struct that implements drivers.Pool interface
+type config struct {
+	endpoint       string
+	token          string
+	insecure       bool
+	node           string
+	template       int
+	resourcePool   string
+	cores          int64
+	memoryMB       int64
+	snippetStorage string
+	snippetDir     string
+	tags           []string
+	userData       string
+	rootDir        string
+	hibernate      bool
+
+	ipTimeout    time.Duration
+	pollInterval time.Duration
+	client       Client
+}
+
+func New(opts ...Option) (drivers.Driver, error) {
+	p := new(config)
+	p.pollInterval = defaultPollInterval
+	p.ipTimeout = defaultIPTimeout
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.node == "" || p.template == 0 {
+		return nil, fmt.Errorf("proxmox: node and template are required")
+	}
+	if p.snippetDir == "" {
+		return nil, fmt.Errorf("proxmox: a snippets directory is required for cloud-init user data")
+	}
+	if p.client == nil {
+		if p.endpoint == "" || p.token == "" {
+			return nil, fmt.Errorf("proxmox: endpoint and api token are required")
+		}
+		p.client = NewClient(p.endpoint, p.token, p.insecure)
+	}
+	return p, nil
+}
+
+func (p *config) DriverName() string {
+	return string(types.Proxmox)
+}
+
+func (p *config) InstanceType() string {
+	return strconv.Itoa(p.template)
+}
+
+func (p *config) RootDir() string {
+	return p.rootDir
+}
+
+func (p *config) CanHibernate() bool {
+	return p.hibernate
+}
+
+// GetFullyQualifiedImage returns the template VMID the instance is cloned from.
+func (p *config) GetFullyQualifiedImage(_ context.Context, config *types.VMImageConfig) (string, error) {
+	if config.ImageName == "" {
+		return strconv.Itoa(p.template), nil
+	}
+	if _, err := strconv.Atoi(config.ImageName); err != nil {
+		return "", fmt.Errorf("proxmox: image must be a template VMID, got %q", config.ImageName)
+	}
+	return config.ImageName, nil
+}
+
+func (p *config) Ping(ctx context.Context) error {
+	return p.client.Version(ctx)
+}
+
+// ReserveCapacity reserves capacity for a VM
+func (p *config) ReserveCapacity(ctx context.Context, opts *types.InstanceCreateOpts) (*types.CapacityReservation, error) {
+	return nil, &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// DestroyCapacity destroys capacity for a VM
+func (p *config) DestroyCapacity(ctx context.Context, capacity *types.CapacityReservation) (err error) {
+	return &ierrors.ErrCapacityReservationNotSupported{Driver: p.DriverName()}
+}
+
+// Create makes a linked clone of the template, attaches the cloud-init user
+// data as a snippet, starts the VM and waits for the guest agent to report an address.
+func (p *config) Create(ctx context.Context, opts *types.InstanceCreateOpts) (instance *types.Instance, err error) {
+	startTime := time.Now()
+
+	image, err := p.GetFullyQualifiedImage(ctx, &opts.VMImageConfig)
+	if err != nil {
+		return nil, err
+	}
+	template, _ := strconv.Atoi(image)
+
+	name := strings.ToLower(fmt.Sprintf("%s-%s-%s", opts.RunnerName, opts.PoolName, uniuri.NewLen(8))) //nolint:mnd
+	logr := logger.FromContext(ctx).
+		WithField("driver", types.Proxmox).
+		WithField("pool", opts.PoolName).
+		WithField("template", template).
+		WithField("node", p.node).
+		WithField("name", name).
+		WithField("hibernate", p.CanHibernate())
+	logr.Infof("proxmox: creating instance %s", name)
+
+	userData, err := lehelper.GenerateUserdata(p.userData, opts)
+	if err != nil {
+		logr.WithError(err).
+			Errorln("proxmox: failed to generate user data")
+		return nil, err
+	}
+
+	vmid, err := p.client.NextID(ctx)
+	if err != nil {
+		logr.WithError(err).Errorln("proxmox: cannot allocate vmid")
+		return nil, err
+	}
+	logr = logr.WithField("vmid", vmid)
+
+	cloneParams := url.Values{}
+	cloneParams.Set("name", name)
+	cloneParams.Set("full", "0")
+	if p.resourcePool != "" {
+		cloneParams.Set("pool", p.resourcePool)
+	}
+	upid, err := p.client.Clone(ctx, p.node, template, vmid, cloneParams)
+	if err == nil {
+		err = p.waitTask(ctx, upid)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("proxmox: linked clone failed")
+		p.cleanup(vmid, name, logr)
+		return nil, err
+	}
+
+	snippet := snippetName(name)
+	if err = os.WriteFile(filepath.Join(p.snippetDir, snippet), []byte(userData), 0o600); err != nil { //nolint:mnd
+		logr.WithError(err).Errorln("proxmox: failed to write cloud-init snippet")
+		p.cleanup(vmid, name, logr)
+		return nil, err
+	}
+
+	configParams := url.Values{}
+	configParams.Set("cicustom", fmt.Sprintf("user=%s:snippets/%s", p.snippetStorage, snippet))
+	configParams.Set("ipconfig0", "ip=dhcp")
+	configParams.Set("agent", "1")
+	configParams.Set("tags", strings.Join(p.buildTags(opts), tagSeparator))
+	if p.cores > 0 {
+		configParams.Set("cores", strconv.FormatInt(p.cores, 10))
+	}
+	if p.memoryMB > 0 {
+		configParams.Set("memory", strconv.FormatInt(p.memoryMB, 10))
+	}
+	if err = p.client.SetConfig(ctx, p.node, vmid, configParams); err != nil {
+		logr.WithError(err).Errorln("proxmox: failed to configure vm")
+		p.cleanup(vmid, name, logr)
+		return nil, err
+	}
+
+	upid, err = p.client.StatusAction(ctx, p.node, vmid, "start", nil)
+	if err == nil {
+		err = p.waitTask(ctx, upid)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("proxmox: failed to start vm")
+		p.cleanup(vmid, name, logr)
+		return nil, err
+	}
+
+	ip, err := p.waitForIP(ctx, vmid)
+	if err != nil {
+		logr.WithError(err).Errorln("proxmox: cannot ascertain instance network")
+		p.cleanup(vmid, name, logr)
+		return nil, err
+	}
+	logr.WithField("ip", ip).Infof("proxmox: instance created %s", name)
+
+	labelsBytes, err := json.Marshal(opts.InternalLabels)
+	if err != nil {
+		return nil, fmt.Errorf("scheduler: could not marshal labels: %v, err: %w", opts.InternalLabels, err)
+	}
+
+	return &types.Instance{
+		ID:                strconv.Itoa(vmid),
+		Name:              name,
+		Provider:          types.Proxmox,
+		State:             types.StateProvisioning,
+		Pool:              opts.PoolName,
+		Image:             image,
+		Zone:              p.node,
+		Size:              p.size(image),
+		Platform:          opts.Platform,
+		Address:           ip,
+		CAKey:             opts.CAKey,
+		CACert:            opts.CACert,
+		TLSKey:            opts.TLSKey,
+		TLSCert:           opts.TLSCert,
+		Started:           startTime.Unix(),
+		Updated:           time.Now().Unix(),
+		IsHibernated:      false,
+		Port:              lehelper.LiteEnginePort,
+		StorageIdentifier: opts.StorageOpts.Identifier,
+		Labels:            labelsBytes,
+		ProxyURL:          opts.EgressProxyURL,
+	}, nil
+}
+
+func (p *config) Destroy(ctx context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+	return p.DestroyInstanceAndStorage(ctx, instances, nil)
+}
+
+// DestroyInstanceAndStorage stops and purges the VMs together with their disks
+// and cloud-init snippets.
+func (p *config) DestroyInstanceAndStorage(ctx context.Context, instances []*types.Instance, _ *storage.CleanupType) ([]*types.Instance, error) {
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instance ids provided")
+	}
+
+	var failed []*types.Instance
+	var firstErr error
+	for _, instance := range instances {
+		logr := logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.Proxmox)
+		err := p.destroyVM(ctx, instance.ID, instance.Name)
+		if err != nil {
+			logr.WithError(err).Errorln("proxmox: failed to destroy vm")
+			failed = append(failed, instance)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		logr.Debugln("proxmox: vm destroyed")
+	}
+	return failed, firstErr
+}
+
+// Logs returns a short status summary. Proxmox only exposes the serial
+// console interactively, not through the REST API.
+func (p *config) Logs(ctx context.Context, instanceID string) (string, error) {
+	vmid, err := parseVMID(instanceID)
+	if err != nil {
+		return "", err
+	}
+	status, err := p.client.Status(ctx, p.node, vmid)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("proxmox: vm %s (%d) on %s is %s (%s); console logs are not available via the API",
+		status.Name, vmid, p.node, status.Status, status.QMPStatus), nil
+}
+
+// Hibernate suspends the VM to disk, saving its memory to the VM state volume.
+func (p *config) Hibernate(ctx context.Context, instanceID, _, _ string) error {
+	logr := logger.FromContext(ctx).
+		WithField("id", instanceID).
+		WithField("driver", types.Proxmox)
+
+	vmid, err := parseVMID(instanceID)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("todisk", "1")
+	upid, err := p.client.StatusAction(ctx, p.node, vmid, "suspend", params)
+	if err == nil {
+		err = p.waitTask(ctx, upid)
+	}
+	if err != nil {
+		logr.WithError(err).Errorln("proxmox: suspend to disk failed")
+		return err
+	}
+	return nil
+}
+
+// Start resumes a hibernated VM and returns its address. A VM suspended to
+// disk is stopped with saved state and is resumed by starting it again.
+func (p *config) Start(ctx context.Context, instance *types.Instance, _ string) (string, error) {
+	logr := logger.FromContext(ctx).
+		WithField("id", instance.ID).
+		WithField("driver", types.Proxmox)
+
+	vmid, err := parseVMID(instance.ID)
+	if err != nil {
+		return "", err
+	}
+	status, err := p.client.Status(ctx, p.node, vmid)
+	if err != nil {
+		return "", err
+	}
+
+	action := ""
+	switch {
+	case status.Status == vmStatusStopped:
+		action = "start"
+	case status.QMPStatus == qmpStatusPaused:
+		action = "resume"
+	}
+	if action != "" {
+		var upid string
+		upid, err = p.client.StatusAction(ctx, p.node, vmid, action, nil)
+		if err == nil {
+			err = p.waitTask(ctx, upid)
+		}
+		if err != nil {
+			logr.WithError(err).Errorf("proxmox: failed to %s vm", action)
+			return "", err
+		}
+	}
+	return p.waitForIP(ctx, vmid)
+}
+
+// SetTags maps the tags to Proxmox tags of the form key.value, replacing any
+// existing tag with the same key.
+func (p *config) SetTags(ctx context.Context, instance *types.Instance, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	vmid, err := parseVMID(instance.ID)
+	if err != nil {
+		return err
+	}
+	cfg, err := p.client.GetConfig(ctx, p.node, vmid)
+	if err != nil {
+		return err
+	}
+	merged := mergeTags(splitTags(cfg.Tags), tags)
+	params := url.Values{}
+	params.Set("tags", strings.Join(merged, tagSeparator))
+	if err = p.client.SetConfig(ctx, p.node, vmid, params); err != nil {
+		logger.FromContext(ctx).
+			WithField("id", instance.ID).
+			WithField("driver", types.Proxmox).
+			WithError(err).
+			Errorln("proxmox: failed to set tags")
+		return err
+	}
+	return nil
+}
+
+func (p *config) SetLabels(ctx context.Context, instance *types.Instance, labels map[string]string) error {
+	return p.SetTags(ctx, instance, labels)
+}
+
+// waitTask polls a task until it has stopped and checks its exit status.
+func (p *config) waitTask(ctx context.Context, upid string) error {
+	if upid == "" {
+		return nil
+	}
+	for {
+		status, err := p.client.TaskStatus(ctx, p.node, upid)
+		if err != nil {
+			return err
+		}
+		if status.Status == taskStatusStopped {
+			if status.ExitStatus != taskExitOK {
+				return fmt.Errorf("proxmox: task %s failed: %s", upid, status.ExitStatus)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.pollInterval):
+		}
+	}
+}
+
+// waitForIP polls the guest agent until it reports a non loopback IPv4 address.
+func (p *config) waitForIP(ctx context.Context, vmid int) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.ipTimeout)
+	defer cancel()
+	interval := time.Duration(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("proxmox: guest agent did not report an address for %d: %w", vmid, ctx.Err())
+		case <-time.After(interval):
+			interval = p.pollInterval
+			// the agent call fails until the guest agent is up, keep polling
+			ifaces, err := p.client.AgentInterfaces(ctx, p.node, vmid)
+			if err != nil {
+				if errors.Is(err, errNotFound) {
+					return "", err
+				}
+				continue
+			}
+			if ip := firstIPv4(ifaces); ip != "" {
+				return ip, nil
+			}
+		}
+	}
+}
+
+func (p *config) destroyVM(ctx context.Context, id, name string) error {
+	vmid, err := parseVMID(id)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("skiplock", "1")
+	upid, err := p.client.StatusAction(ctx, p.node, vmid, "stop", params)
+	if err == nil {
+		err = p.waitTask(ctx, upid)
+	}
+	if err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	upid, err = p.client.Delete(ctx, p.node, vmid)
+	if err == nil {
+		err = p.waitTask(ctx, upid)
+	}
+	if err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	if name != "" {
+		if err = os.Remove(filepath.Join(p.snippetDir, snippetName(name))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *config) cleanup(vmid int, name string, logr logger.Logger) {
+	if err := p.destroyVM(context.Background(), strconv.Itoa(vmid), name); err != nil {
+		logr.WithError(err).Warnln("proxmox: failed to clean up after failed create")
+	}
+}
+
+// buildTags returns the pool tags plus the pool name and VM labels.
+func (p *config) buildTags(opts *types.InstanceCreateOpts) []string {
+	labels := map[string]string{"pool": opts.PoolName}
+	for k, v := range opts.VMLabels {
+		labels[k] = v
+	}
+	tags := make([]string, 0, len(p.tags))
+	for _, t := range p.tags {
+		tags = append(tags, sanitizeTag(t))
+	}
+	return mergeTags(tags, labels)
+}
+
+// size describes the VM shape, which is inherited from the template unless overridden.
+func (p *config) size(template string) string {
+	if p.cores == 0 && p.memoryMB == 0 {
+		return "template-" + template
+	}
+	return fmt.Sprintf("%dcpu-%dmb", p.cores, p.memoryMB)
+}
+
+func firstIPv4(ifaces *agentInterfaces) string {
+	for _, iface := range ifaces.Result {
+		if iface.Name == "lo" {
+			continue
+		}
+		for _, addr := range iface.IPAddresses {
+			if addr.Type == "ipv4" && !strings.HasPrefix(addr.Address, "127.") {
+				return addr.Address
+			}
+		}
+	}
+	return ""
+}
+
+func splitTags(tags string) []string {
+	var out []string
+	for _, t := range strings.FieldsFunc(tags, func(r rune) bool { return r == ';' || r == ',' || r == ' ' }) {
+		if t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// mergeTags adds key.value tags to existing, dropping existing tags for the same keys.
+func mergeTags(existing []string, tags map[string]string) []string {
+	seen := map[string]bool{}
+	var out []string
+	prefixes := make([]string, 0, len(tags))
+	for k := range tags {
+		prefixes = append(prefixes, sanitizeTag(k)+".")
+	}
+	for _, t := range existing {
+		replaced := false
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(t, prefix) {
+				replaced = true
+				break
+			}
+		}
+		if !replaced && !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	for k, v := range tags {
+		t := sanitizeTag(k) + "." + sanitizeTag(v)
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sanitizeTag(v string) string {
+	return invalidTagChars.ReplaceAllString(strings.ToLower(v), "_")
+}
+
+func snippetName(name string) string {
+	return name + "-user-data.yaml"
+}
+
+func parseVMID(id string) (int, error) {
+	vmid, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, fmt.Errorf("proxmox: invalid vmid %q: %w", id, err)
+	}
+	return vmid, nil
+}
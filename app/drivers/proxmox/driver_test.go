@@ -0,0 +1,342 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/drivertest"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// fakeProxmox emulates the subset of the Proxmox VE API used by the driver:
+// nextid, clone, config, status actions, guest agent, tasks and delete.
+type fakeProxmox struct {
+	mu        sync.Mutex
+	nextID    int
+	vms       map[int]*fakeVM
+	events    []string
+	token     string
+	failStart bool
+}
+
+type fakeVM struct {
+	name      string
+	template  int
+	linked    bool
+	status    string
+	qmpStatus string
+	config    map[string]string
+}
+
+func newFakeProxmox() *fakeProxmox {
+	return &fakeProxmox{nextID: 200, vms: map[int]*fakeVM{9000: {name: "ubuntu-template", status: vmStatusStopped, config: map[string]string{}}}}
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func (f *fakeProxmox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.token = r.Header.Get("Authorization")
+	_ = r.ParseForm()
+	path := strings.TrimPrefix(r.URL.Path, "/api2/json")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case path == "/version":
+		writeData(w, map[string]string{"version": "8.2"})
+	case path == "/cluster/nextid":
+		f.nextID++
+		writeData(w, strconv.Itoa(f.nextID))
+	case len(parts) == 5 && parts[2] == "tasks":
+		// tasks complete immediately, a failed start reports a non OK exit
+		exit := taskExitOK
+		if strings.Contains(parts[3], "qmstart") && f.failStart {
+			exit = "start failed: kvm: not enough memory"
+		}
+		writeData(w, map[string]string{"status": taskStatusStopped, "exitstatus": exit})
+	case len(parts) >= 4 && parts[2] == "qemu":
+		vmid, _ := strconv.Atoi(parts[3])
+		vm, ok := f.vms[vmid]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Configuration file 'nodes/pve/qemu-server/%d.conf' does not exist", vmid), http.StatusInternalServerError)
+			return
+		}
+		f.serveVM(w, r, vmid, vm, parts[4:])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeProxmox) serveVM(w http.ResponseWriter, r *http.Request, vmid int, vm *fakeVM, rest []string) {
+	upid := func(kind string) string { return fmt.Sprintf("UPID:pve:0000:%s:%d:", kind, vmid) }
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodDelete:
+		f.events = append(f.events, "delete:"+strconv.Itoa(vmid))
+		delete(f.vms, vmid)
+		writeData(w, upid("qmdestroy"))
+	case len(rest) == 1 && rest[0] == "clone":
+		newID, _ := strconv.Atoi(r.PostForm.Get("newid"))
+		f.vms[newID] = &fakeVM{name: r.PostForm.Get("name"), template: vmid, linked: r.PostForm.Get("full") == "0", status: vmStatusStopped, config: map[string]string{}}
+		f.events = append(f.events, "clone:"+strconv.Itoa(newID))
+		writeData(w, upid("qmclone"))
+	case len(rest) == 1 && rest[0] == "config" && r.Method == http.MethodPut:
+		for k := range r.PostForm {
+			vm.config[k] = r.PostForm.Get(k)
+		}
+		writeData(w, nil)
+	case len(rest) == 1 && rest[0] == "config":
+		writeData(w, map[string]string{"name": vm.name, "tags": vm.config["tags"]})
+	case len(rest) == 2 && rest[1] == "current":
+		writeData(w, map[string]string{"name": vm.name, "status": vm.status, "qmpstatus": vm.qmpStatus})
+	case len(rest) == 2 && rest[0] == "status":
+		f.events = append(f.events, rest[1]+":"+strconv.Itoa(vmid))
+		switch rest[1] {
+		case "start":
+			vm.status, vm.qmpStatus = vmStatusRunning, vmStatusRunning
+		case "stop":
+			vm.status, vm.qmpStatus = vmStatusStopped, vmStatusStopped
+		case "suspend":
+			if r.PostForm.Get("todisk") == "1" {
+				vm.status, vm.qmpStatus = vmStatusStopped, vmStatusStopped
+			} else {
+				vm.qmpStatus = qmpStatusPaused
+			}
+		case "resume":
+			vm.qmpStatus = vmStatusRunning
+		}
+		writeData(w, upid("qm"+rest[1]))
+	case len(rest) == 2 && rest[0] == "agent":
+		if vm.status != vmStatusRunning {
+			http.Error(w, "QEMU guest agent is not running", http.StatusInternalServerError)
+			return
+		}
+		writeData(w, map[string]interface{}{"result": []map[string]interface{}{
+			{"name": "lo", "ip-addresses": []map[string]string{{"ip-address-type": "ipv4", "ip-address": "127.0.0.1"}}},
+			{"name": "eth0", "ip-addresses": []map[string]string{
+				{"ip-address-type": "ipv6", "ip-address": "fe80::1"},
+				{"ip-address-type": "ipv4", "ip-address": fmt.Sprintf("10.10.0.%d", vmid%250)},
+			}},
+		}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestConfig(t *testing.T, fake *fakeProxmox) *config {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	d, err := New(
+		WithEndpoint(server.URL),
+		WithToken("runner@pve!ci=secret"),
+		WithNode("pve"),
+		WithTemplate(9000),
+		WithSnippets("", t.TempDir()),
+		WithCPU(4),
+		WithMemory(8192),
+		WithTags([]string{"CI"}),
+		WithHibernate(true),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	p := d.(*config)
+	p.pollInterval = time.Millisecond
+	p.ipTimeout = time.Second
+	return p
+}
+
+func createOpts() *types.InstanceCreateOpts {
+	return &types.InstanceCreateOpts{
+		PoolName:   "linux",
+		RunnerName: "runner",
+		Platform:   types.Platform{OS: "linux", Arch: "amd64"},
+		CACert:     []byte("ca-cert"),
+		TLSCert:    []byte("tls-cert"),
+		TLSKey:     []byte("tls-key"),
+		VMLabels:   map[string]string{"account": "ACC1"},
+	}
+}
+
+func TestNew_Validation(t *testing.T) {
+	if _, err := New(WithEndpoint("https://pve:8006"), WithToken("t"), WithSnippets("", "")); err == nil {
+		t.Error("expected error without node and template")
+	}
+	if _, err := New(WithNode("pve"), WithTemplate(9000), WithSnippets("", "")); err == nil {
+		t.Error("expected error without endpoint and token")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	fake := newFakeProxmox()
+	p := newTestConfig(t, fake)
+
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if fake.token != "PVEAPIToken=runner@pve!ci=secret" {
+		t.Errorf("authorization = %q", fake.token)
+	}
+	if instance.ID != "201" || instance.Address != "10.10.0.201" || instance.Zone != "pve" || instance.Image != "9000" || instance.Provider != types.Proxmox {
+		t.Errorf("unexpected instance %+v", instance)
+	}
+
+	vm := fake.vms[201]
+	if !vm.linked || vm.template != 9000 || vm.status != vmStatusRunning {
+		t.Errorf("unexpected vm %+v", vm)
+	}
+	wantSnippet := "local:snippets/" + snippetName(instance.Name)
+	if vm.config["cicustom"] != "user="+wantSnippet || vm.config["ipconfig0"] != "ip=dhcp" || vm.config["agent"] != "1" {
+		t.Errorf("unexpected cloud-init config %v", vm.config)
+	}
+	if vm.config["cores"] != "4" || vm.config["memory"] != "8192" {
+		t.Errorf("unexpected sizing %v", vm.config)
+	}
+	if vm.config["tags"] != "account.acc1;ci;pool.linux" {
+		t.Errorf("tags = %q", vm.config["tags"])
+	}
+	data, err := os.ReadFile(filepath.Join(p.snippetDir, snippetName(instance.Name)))
+	if err != nil || !strings.Contains(string(data), "ca-cert") {
+		t.Errorf("snippet = %q, %v", data, err)
+	}
+}
+
+func TestCreate_StartFailureCleansUp(t *testing.T) {
+	fake := newFakeProxmox()
+	fake.failStart = true
+	p := newTestConfig(t, fake)
+
+	if _, err := p.Create(context.Background(), createOpts()); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, ok := fake.vms[201]; ok {
+		t.Error("expected the clone to be deleted")
+	}
+	if entries, _ := os.ReadDir(p.snippetDir); len(entries) != 0 {
+		t.Errorf("expected snippet removed, got %d files", len(entries))
+	}
+}
+
+func TestHibernateAndStart(t *testing.T) {
+	fake := newFakeProxmox()
+	p := newTestConfig(t, fake)
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err = p.Hibernate(context.Background(), instance.ID, "linux", ""); err != nil {
+		t.Fatalf("Hibernate: %v", err)
+	}
+	if fake.vms[201].status != vmStatusStopped {
+		t.Fatal("expected the vm to be suspended to disk")
+	}
+	ip, err := p.Start(context.Background(), instance, "linux")
+	if err != nil || ip != instance.Address {
+		t.Fatalf("Start = %q, %v", ip, err)
+	}
+
+	// a paused vm is resumed rather than started
+	fake.vms[201].qmpStatus = qmpStatusPaused
+	if _, err = p.Start(context.Background(), instance, "linux"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	want := []string{"clone:201", "start:201", "suspend:201", "start:201", "resume:201"}
+	if strings.Join(fake.events, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", fake.events, want)
+	}
+}
+
+func TestSetTags(t *testing.T) {
+	fake := newFakeProxmox()
+	p := newTestConfig(t, fake)
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err = p.SetTags(context.Background(), instance, map[string]string{"pool": "Other Pool", "retain": "true"}); err != nil {
+		t.Fatalf("SetTags: %v", err)
+	}
+	if got := fake.vms[201].config["tags"]; got != "account.acc1;ci;pool.other_pool;retain.true" {
+		t.Errorf("tags = %q", got)
+	}
+}
+
+func TestDestroyAndLogs(t *testing.T) {
+	fake := newFakeProxmox()
+	p := newTestConfig(t, fake)
+	instance, err := p.Create(context.Background(), createOpts())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	logs, err := p.Logs(context.Background(), instance.ID)
+	if err != nil || !strings.Contains(logs, "running") {
+		t.Errorf("Logs = %q, %v", logs, err)
+	}
+
+	failed, err := p.Destroy(context.Background(), []*types.Instance{instance, {ID: "999"}})
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Destroy: failed=%v err=%v", failed, err)
+	}
+	if _, ok := fake.vms[201]; ok {
+		t.Error("expected vm deleted")
+	}
+	if _, err = os.Stat(filepath.Join(p.snippetDir, snippetName(instance.Name))); !os.IsNotExist(err) {
+		t.Errorf("expected snippet removed, got %v", err)
+	}
+
+	failed, err = p.Destroy(context.Background(), []*types.Instance{{ID: "not-a-vmid"}})
+	if err == nil || len(failed) != 1 {
+		t.Errorf("expected invalid id to fail, got failed=%v err=%v", failed, err)
+	}
+}
+
+func (f *fakeProxmox) Exists(instanceID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	vmid, _ := strconv.Atoi(instanceID)
+	_, ok := f.vms[vmid]
+	return ok
+}
+
+// Labels returns the key.value tags of the VM as labels.
+func (f *fakeProxmox) Labels(instanceID string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	vmid, _ := strconv.Atoi(instanceID)
+	labels := map[string]string{}
+	if vm, ok := f.vms[vmid]; ok {
+		for _, tag := range strings.Split(vm.config["tags"], ";") {
+			if k, v, ok := strings.Cut(tag, "."); ok {
+				labels[k] = v
+			}
+		}
+	}
+	return labels
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, drivertest.Config{
+		NewDriver: func(t *testing.T) (drivers.Driver, drivertest.Backend) {
+			fake := newFakeProxmox()
+			return newTestConfig(t, fake), fake
+		},
+	})
+}
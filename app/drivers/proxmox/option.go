@@ -0,0 +1,157 @@
+package proxmox
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/app/oshelp"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+type Option func(*config)
+
+func SetPlatformDefaults(platform *types.Platform) (*types.Platform, error) {
+	if platform.Arch == "" {
+		platform.Arch = oshelp.ArchAMD64
+	}
+	if platform.Arch != oshelp.ArchAMD64 && platform.Arch != oshelp.ArchARM64 {
+		return platform, fmt.Errorf("invalid arch %s, has to be '%s/%s'", platform.Arch, oshelp.ArchAMD64, oshelp.ArchARM64)
+	}
+	// verify that we are using sane values for OS
+	if platform.OS == "" {
+		platform.OS = oshelp.OSLinux
+	}
+	if platform.OS != oshelp.OSLinux {
+		return platform, fmt.Errorf("proxmox - invalid OS %s, has to be '%s'", platform.OS, oshelp.OSLinux)
+	}
+	// set osname
+	if platform.OSName == "" {
+		platform.OSName = oshelp.Ubuntu
+	}
+	return platform, nil
+}
+
+// WithEndpoint sets the Proxmox VE API address, e.g. https://pve.example.com:8006.
+func WithEndpoint(endpoint string) Option {
+	return func(p *config) {
+		p.endpoint = endpoint
+	}
+}
+
+// WithToken sets the API token in the form USER@REALM!TOKENID=SECRET.
+func WithToken(token string) Option {
+	return func(p *config) {
+		p.token = token
+	}
+}
+
+// WithInsecure disables TLS certificate verification for self-signed clusters.
+func WithInsecure(insecure bool) Option {
+	return func(p *config) {
+		p.insecure = insecure
+	}
+}
+
+// WithNode sets the cluster node the VMs are created on.
+func WithNode(node string) Option {
+	return func(p *config) {
+		p.node = node
+	}
+}
+
+// WithTemplate sets the VMID of the template that is linked cloned.
+func WithTemplate(template int) Option {
+	return func(p *config) {
+		p.template = template
+	}
+}
+
+// WithResourcePool adds the VMs to a Proxmox resource pool.
+func WithResourcePool(pool string) Option {
+	return func(p *config) {
+		p.resourcePool = pool
+	}
+}
+
+// WithSnippets sets the storage holding cloud-init snippets and the local
+// directory it is mounted at. The storage must be visible to the node.
+func WithSnippets(storage, dir string) Option {
+	return func(p *config) {
+		if storage == "" {
+			p.snippetStorage = "local"
+		} else {
+			p.snippetStorage = storage
+		}
+		if dir == "" {
+			p.snippetDir = "/var/lib/vz/snippets"
+		} else {
+			p.snippetDir = dir
+		}
+	}
+}
+
+// WithCPU overrides the number of cores of the template, zero keeps the template value.
+func WithCPU(cores int64) Option {
+	return func(p *config) {
+		p.cores = cores
+	}
+}
+
+// WithMemory overrides the memory of the template, zero keeps the template value.
+func WithMemory(memoryMB int64) Option {
+	return func(p *config) {
+		p.memoryMB = memoryMB
+	}
+}
+
+// WithTags sets additional Proxmox tags applied to every VM.
+func WithTags(tags []string) Option {
+	return func(p *config) {
+		p.tags = tags
+	}
+}
+
+func WithHibernate(hibernate bool) Option {
+	return func(p *config) {
+		p.hibernate = hibernate
+	}
+}
+
+// WithClient overrides the API client.
+func WithClient(client Client) Option {
+	return func(p *config) {
+		p.client = client
+	}
+}
+
+func WithUserData(text, path string) Option {
+	if text != "" {
+		return func(p *config) {
+			p.userData = text
+		}
+	}
+	return func(p *config) {
+		if path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logrus.WithError(err).
+					Fatalln("failed to read user_data file")
+				return
+			}
+			p.userData = string(data)
+		}
+	}
+}
+
+// WithRootDirectory sets the root directory for the virtual machine.
+func WithRootDirectory(dir string) Option {
+	return func(p *config) {
+		if dir == "" {
+			p.rootDir = oshelp.JoinPaths(oshelp.OSLinux, "/tmp", "proxmox")
+		} else {
+			p.rootDir = dir
+		}
+	}
+}
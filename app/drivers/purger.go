@@ -32,8 +32,12 @@ func (m *Manager) StartInstancePurger(ctx context.Context, maxAgeBusy, maxAgeFre
 
 	d := time.Duration(maxAgeBusy.Minutes() * 0.9 * float64(time.Minute))
 	m.cleanupTimer = time.NewTicker(d)
+	m.purgerSettings = PurgerSettings{MaxAgeBusy: maxAgeBusy, MaxAgeFree: maxAgeFree}
 
 	logrus.Infof("Instance purger started. It will run every %.2f minutes", d.Minutes())
+	if m.purgerDryRun {
+		logrus.Warnln("purger: dry run, stale instances are only reported, nothing will be purged")
+	}
 
 	go func() {
 		for {
@@ -48,6 +52,10 @@ func (m *Manager) StartInstancePurger(ctx context.Context, maxAgeBusy, maxAgeFre
 				case <-ctx.Done():
 					return
 				case <-m.cleanupTimer.C:
+					if m.purgerDryRun {
+						logPurgerReport(ctx, m, "purger")
+						return
+					}
 					logrus.Traceln("Launching instance purger")
 
 					err := m.forEach(ctx,
@@ -94,19 +102,14 @@ func (m *Manager) purgeStaleInstancesForPool(
 	pool.Lock()
 	defer pool.Unlock()
 
-	queryParams := &types.QueryParams{MatchLabels: map[string]string{"retain": "false"}}
-	busy, free, hibernating, provisioning, terminating, err := m.list(ctx, pool, queryParams)
+	instances, reasonByID, err := m.staleInstances(ctx, pool, maxAgeBusy, maxAgeFree)
 	if err != nil {
-		return fmt.Errorf("failed to list instances of pool=%q error: %w", pool.Name, err)
+		return err
+	}
+	if len(instances) == 0 {
+		return nil
 	}
-	free = append(free, hibernating...)
-	busy = append(busy, terminating...)
 
-	var instances []*types.Instance
-	// reasonByID tracks which sweep bucket (busy/free/stuck-provisioning) each instance came
-	// from, so the destroy-attempts metric can carry an accurate reason label even after the
-	// three buckets are merged into a single destroy batch below.
-	reasonByID := make(map[string]string)
 	// usageStartByID captures each busy instance's pre-destroy inst.Updated as a best-effort
 	// proxy for "became inuse at" (there's no dedicated column for that), read here before
 	// destroyByTenant/Delete below can touch the instance further. Only populated for busy
@@ -117,32 +120,11 @@ func (m *Manager) purgeStaleInstancesForPool(
 	// hibernated/resumed), not "became inuse at" - usage duration can be inflated by however
 	// long the instance sat idle in the pool before being claimed.
 	usageStartByID := make(map[string]int64)
-	for _, inst := range busy {
-		startedAt := time.Unix(inst.Started, 0)
-		if time.Since(startedAt) > maxAgeBusy {
-			instances = append(instances, inst)
-			reasonByID[inst.ID] = PurgerReasonBusyMaxAge
+	for _, inst := range instances {
+		if reasonByID[inst.ID] == PurgerReasonBusyMaxAge {
 			usageStartByID[inst.ID] = inst.Updated
 		}
 	}
-	for _, inst := range free {
-		startedAt := time.Unix(inst.Started, 0)
-		if time.Since(startedAt) > maxAgeFree {
-			instances = append(instances, inst)
-			reasonByID[inst.ID] = PurgerReasonFreeMaxAge
-		}
-	}
-	for _, inst := range provisioning {
-		startedAt := time.Unix(inst.Started, 0)
-		if time.Since(startedAt) > stuckProvisioningMaxAge {
-			instances = append(instances, inst)
-			reasonByID[inst.ID] = PurgerReasonStuckProvisioning
-		}
-	}
-
-	if len(instances) == 0 {
-		return nil
-	}
 
 	instanceIDs := make([]string, len(instances))
 	for i, inst := range instances {
@@ -198,6 +180,49 @@ func (m *Manager) purgeStaleInstancesForPool(
 	return nil
 }
 
+// staleInstances lists the instances of a pool the purger destroys: busy ones
+// older than maxAgeBusy, free ones older than maxAgeFree and ones stuck
+// provisioning. reasonByID tracks which of the three buckets each instance came
+// from, so the destroy-attempts metric can carry an accurate reason label even
+// after the buckets are merged into a single destroy batch.
+func (m *Manager) staleInstances(
+	ctx context.Context,
+	pool *poolEntry,
+	maxAgeBusy, maxAgeFree time.Duration,
+) (instances []*types.Instance, reasonByID map[string]string, err error) {
+	queryParams := &types.QueryParams{MatchLabels: map[string]string{"retain": "false"}}
+	busy, free, hibernating, provisioning, terminating, err := m.list(ctx, pool, queryParams)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list instances of pool=%q error: %w", pool.Name, err)
+	}
+	free = append(free, hibernating...)
+	busy = append(busy, terminating...)
+
+	reasonByID = make(map[string]string)
+	for _, inst := range busy {
+		startedAt := time.Unix(inst.Started, 0)
+		if time.Since(startedAt) > maxAgeBusy {
+			instances = append(instances, inst)
+			reasonByID[inst.ID] = PurgerReasonBusyMaxAge
+		}
+	}
+	for _, inst := range free {
+		startedAt := time.Unix(inst.Started, 0)
+		if time.Since(startedAt) > maxAgeFree {
+			instances = append(instances, inst)
+			reasonByID[inst.ID] = PurgerReasonFreeMaxAge
+		}
+	}
+	for _, inst := range provisioning {
+		startedAt := time.Unix(inst.Started, 0)
+		if time.Since(startedAt) > stuckProvisioningMaxAge {
+			instances = append(instances, inst)
+			reasonByID[inst.ID] = PurgerReasonStuckProvisioning
+		}
+	}
+	return instances, reasonByID, nil
+}
+
 // cleanPool cleans up instances in a pool.
 func (m *Manager) cleanPool(ctx context.Context, pool *poolEntry, query *types.QueryParams, destroyBusy, destroyFree bool) error {
 	pool.Lock()
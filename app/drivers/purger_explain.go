@@ -0,0 +1,290 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Purger report actions and the kinds of rows they apply to.
+const (
+	// PurgerActionDestroy claims the row, destroys the VM or reservation and deletes the row.
+	PurgerActionDestroy = "destroy"
+	// PurgerActionForceDelete deletes the row of an instance older than twice its max age
+	// without destroying its VM, see forceDeleteLeakedInstances.
+	PurgerActionForceDelete = "force_delete"
+
+	PurgerKindInstance            = "instance"
+	PurgerKindCapacityReservation = "capacity_reservation"
+)
+
+// PurgerSettings are the max ages and the busy lease TTL the purger runs with.
+type PurgerSettings struct {
+	MaxAgeBusy         time.Duration
+	MaxAgeFree         time.Duration
+	FreeCapacityMaxAge time.Duration
+	BusyLeaseTTL       time.Duration
+}
+
+// PurgerReportEntry is an instance or a capacity reservation the next purger
+// run would act on.
+type PurgerReportEntry struct {
+	Pool   string `json:"pool"`
+	Kind   string `json:"kind"`
+	Action string `json:"action"`
+	// ID is the instance ID, or the stage ID of a capacity reservation.
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	State    string `json:"state"`
+	TenantID string `json:"tenant_id,omitempty"`
+	// Reason is the purger reason, as reported by the purger metrics.
+	Reason     string `json:"reason"`
+	AgeSeconds int64  `json:"age_seconds"`
+}
+
+// PurgerExplainer is implemented by managers that can report what their
+// purger would do, without doing it.
+type PurgerExplainer interface {
+	ExplainPurger(ctx context.Context) ([]*PurgerReportEntry, error)
+}
+
+var (
+	_ PurgerExplainer = (*Manager)(nil)
+	_ PurgerExplainer = (*DistributedManager)(nil)
+)
+
+// ExplainPurger returns every instance the next purger run would destroy,
+// with the max ages the purger was started with. Nothing is destroyed.
+func (m *Manager) ExplainPurger(ctx context.Context) ([]*PurgerReportEntry, error) {
+	if m.cleanupTimer == nil {
+		return nil, errors.New("purger is not started")
+	}
+	now := time.Now()
+	var entries []*PurgerReportEntry
+	var errs []error
+	for _, pool := range m.poolMap {
+		instances, reasonByID, err := m.staleInstances(ctx, pool, m.purgerSettings.MaxAgeBusy, m.purgerSettings.MaxAgeFree)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, inst := range instances {
+			entries = append(entries, &PurgerReportEntry{
+				Pool:       pool.Name,
+				Kind:       PurgerKindInstance,
+				Action:     PurgerActionDestroy,
+				ID:         inst.ID,
+				Name:       inst.Name,
+				State:      string(inst.State),
+				TenantID:   inst.TenantID,
+				Reason:     reasonByID[inst.ID],
+				AgeSeconds: int64(now.Sub(time.Unix(inst.Started, 0)).Seconds()),
+			})
+		}
+	}
+	sortPurgerReport(entries)
+	return entries, errors.Join(errs...)
+}
+
+// ExplainPurger returns every instance and capacity reservation the next
+// purger run would act on, with the settings the purger was started with.
+// Nothing is claimed or destroyed.
+func (d *DistributedManager) ExplainPurger(ctx context.Context) ([]*PurgerReportEntry, error) {
+	if d.cleanupTimer == nil {
+		return nil, errors.New("distributed dlite: purger is not started")
+	}
+	now := time.Now()
+	var entries []*PurgerReportEntry
+	var errs []error
+	for _, pool := range d.poolMap {
+		poolEntries, err := d.explainPool(ctx, pool, d.purgerSettings, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pool %s: %w", pool.Name, err))
+		}
+		entries = append(entries, poolEntries...)
+	}
+	sortPurgerReport(entries)
+	return entries, errors.Join(errs...)
+}
+
+// sortPurgerReport orders a purger report by pool, oldest first.
+func sortPurgerReport(entries []*PurgerReportEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Pool != entries[j].Pool {
+			return entries[i].Pool < entries[j].Pool
+		}
+		return entries[i].AgeSeconds > entries[j].AgeSeconds
+	})
+}
+
+// explainPool reports what startInstancePurger would do to a pool.
+func (d *DistributedManager) explainPool(ctx context.Context, pool *poolEntry, settings PurgerSettings, now time.Time) ([]*PurgerReportEntry, error) {
+	rows, err := d.instanceStore.List(ctx, pool.Name, &types.QueryParams{})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*PurgerReportEntry
+	for _, inst := range rows {
+		action, cleanupType := purgerInstanceAction(inst, settings, now)
+		if action == "" {
+			continue
+		}
+		entries = append(entries, &PurgerReportEntry{
+			Pool:       pool.Name,
+			Kind:       PurgerKindInstance,
+			Action:     action,
+			ID:         inst.ID,
+			Name:       inst.Name,
+			State:      string(inst.State),
+			TenantID:   inst.TenantID,
+			Reason:     purgerReasonForCleanupType(cleanupType),
+			AgeSeconds: int64(now.Sub(time.Unix(inst.Started, 0)).Seconds()),
+		})
+	}
+
+	if settings.FreeCapacityMaxAge == 0 || d.capacityReservationStore == nil {
+		return entries, nil
+	}
+	capacities, err := d.capacityReservationStore.List(ctx,
+		&types.CapacityReservationQueryParams{
+			PoolName:        pool.Name,
+			CreatedAtBefore: now.Add(-settings.FreeCapacityMaxAge).Unix(),
+		},
+		[]types.CapacityReservationState{
+			types.CapacityReservationStateTerminating,
+			types.CapacityReservationStateCreated,
+			types.CapacityReservationStateInUse,
+		},
+	)
+	if err != nil {
+		return entries, err
+	}
+	for _, capacity := range capacities {
+		reason := purgerCapacityReason(capacity, rows)
+		if reason == "" {
+			continue
+		}
+		entry := &PurgerReportEntry{
+			Pool:       pool.Name,
+			Kind:       PurgerKindCapacityReservation,
+			Action:     PurgerActionDestroy,
+			ID:         capacity.StageID,
+			Name:       capacity.ReservationID,
+			State:      string(capacity.ReservationState),
+			Reason:     reason,
+			AgeSeconds: int64(now.Sub(time.Unix(capacity.CreatedAt, 0)).Seconds()),
+		}
+		for _, inst := range rows {
+			if inst.ID == capacity.InstanceID {
+				entry.TenantID = inst.TenantID
+				break
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// purgerInstanceAction mirrors the conditions of cleanupBusyInstances,
+// cleanupLapsedLeases, cleanupFreeInstances and forceDeleteLeakedInstances, in
+// the order the purger applies them. It returns the action and the cleanup type
+// ("busy", "lease" or "free") of the sweep that would take it, or empty strings
+// when the instance is kept.
+func purgerInstanceAction(inst *types.Instance, settings PurgerSettings, now time.Time) (action, cleanupType string) {
+	labels := instanceLabels(inst)
+	_, hasTTL := labels["ttl"]
+	// the purger only matches rows labelled retain=false, see StartInstancePurger
+	purgeable := labels["retain"] == "false"
+	olderThan := func(unix int64, maxAge time.Duration) bool {
+		return unix < now.Add(-maxAge).Unix()
+	}
+	// rows with a lease still renewed are never force-deleted
+	leaked := func(maxAge time.Duration) bool {
+		return olderThan(inst.Started, 2*maxAge) && inst.LeaseExpiry < now.Unix()
+	}
+	// with leases enabled, leased rows are left to the lease sweep
+	byMaxAge := settings.BusyLeaseTTL <= 0 || inst.LeaseExpiry == 0
+
+	if settings.MaxAgeBusy != 0 {
+		if leaked(settings.MaxAgeBusy) {
+			return PurgerActionForceDelete, "busy"
+		}
+		busy := inst.State == types.StateInUse || inst.State == types.StateTerminating
+		switch {
+		case busy && purgeable && byMaxAge && !hasTTL && olderThan(inst.Started, settings.MaxAgeBusy),
+			busy && purgeable && byMaxAge && hasTTL && olderThan(inst.Started, busyTTLExtendedMaxAge),
+			inst.State == types.StateTerminating && olderThan(inst.Updated, stuckTerminatingMaxAge):
+			return PurgerActionDestroy, "busy"
+		}
+	}
+
+	if settings.BusyLeaseTTL > 0 && inst.State == types.StateInUse && purgeable &&
+		inst.LeaseExpiry > 0 && inst.LeaseExpiry < now.Unix() {
+		return PurgerActionDestroy, "lease"
+	}
+
+	if settings.MaxAgeFree != 0 {
+		if leaked(settings.MaxAgeFree) {
+			return PurgerActionForceDelete, "free"
+		}
+		free := inst.State == types.StateCreated || inst.State == types.StateHibernating
+		switch {
+		case free && purgeable && olderThan(inst.Started, settings.MaxAgeFree),
+			inst.State == types.StateProvisioning && olderThan(inst.Started, stuckProvisioningMaxAge):
+			return PurgerActionDestroy, "free"
+		}
+	}
+	return "", ""
+}
+
+// purgerCapacityReason mirrors cleanupCapacities for a reservation past the
+// capacity max age. It returns the reason the reservation would be destroyed
+// for, or an empty string when it is kept.
+func purgerCapacityReason(capacity *types.CapacityReservation, rows []*types.Instance) string {
+	switch capacity.ReservationState {
+	case types.CapacityReservationStateTerminating:
+		return PurgerCapacityReasonStuckTerminating
+	case types.CapacityReservationStateCreated:
+		return PurgerCapacityReasonStuckCreated
+	case types.CapacityReservationStateInUse:
+		if capacity.StageID == "" {
+			return ""
+		}
+		for _, inst := range rows {
+			if inst.State == types.StateInUse && inst.Stage == capacity.StageID {
+				return ""
+			}
+		}
+		return PurgerCapacityReasonOrphanedInUse
+	}
+	return ""
+}
+
+// logPurgerReport logs what the purger would do, in place of a dry run sweep.
+// Each message starts with prefix.
+func logPurgerReport(ctx context.Context, explainer PurgerExplainer, prefix string) {
+	logr := logger.FromContext(ctx)
+	entries, err := explainer.ExplainPurger(ctx)
+	if err != nil {
+		logr.WithError(err).Errorln(prefix + ": dry run: failed to explain the purger")
+	}
+	for _, entry := range entries {
+		logr.WithField("pool", entry.Pool).
+			WithField("kind", entry.Kind).
+			WithField("action", entry.Action).
+			WithField("id", entry.ID).
+			WithField("name", entry.Name).
+			WithField("state", entry.State).
+			WithField("tenant_id", entry.TenantID).
+			WithField("reason", entry.Reason).
+			WithField("age", (time.Duration(entry.AgeSeconds) * time.Second).String()).
+			Infoln(prefix + ": dry run, would purge")
+	}
+}
@@ -0,0 +1,231 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestPurgerInstanceAction(t *testing.T) {
+	now := time.Now()
+	settings := PurgerSettings{MaxAgeBusy: time.Hour, MaxAgeFree: 3 * time.Hour}
+	ago := func(d time.Duration) int64 { return now.Add(-d).Unix() }
+	purgeable := []byte(`{"retain":"false"}`)
+
+	tests := []struct {
+		name        string
+		inst        *types.Instance
+		action      string
+		cleanupType string
+	}{
+		{
+			name:        "busy past max age",
+			inst:        &types.Instance{State: types.StateInUse, Started: ago(90 * time.Minute), Labels: purgeable},
+			action:      PurgerActionDestroy,
+			cleanupType: "busy",
+		},
+		{
+			name: "busy within max age",
+			inst: &types.Instance{State: types.StateInUse, Started: ago(30 * time.Minute), Labels: purgeable},
+		},
+		{
+			name: "busy retained",
+			inst: &types.Instance{State: types.StateInUse, Started: ago(90 * time.Minute), Labels: []byte(`{"retain":"true"}`)},
+		},
+		{
+			name: "busy with ttl",
+			inst: &types.Instance{State: types.StateInUse, Started: ago(90 * time.Minute), Labels: []byte(`{"retain":"false","ttl":"1"}`)},
+		},
+		{
+			name:        "stuck terminating",
+			inst:        &types.Instance{State: types.StateTerminating, Started: ago(time.Minute), Updated: ago(5 * time.Minute)},
+			action:      PurgerActionDestroy,
+			cleanupType: "busy",
+		},
+		{
+			name:        "past twice the busy max age",
+			inst:        &types.Instance{State: types.StateCreated, Started: ago(150 * time.Minute), Labels: []byte(`{"retain":"true"}`)},
+			action:      PurgerActionForceDelete,
+			cleanupType: "busy",
+		},
+		{
+			name: "free within max age",
+			inst: &types.Instance{State: types.StateCreated, Started: ago(90 * time.Minute), Labels: purgeable},
+		},
+		{
+			name:        "stuck provisioning",
+			inst:        &types.Instance{State: types.StateProvisioning, Started: ago(45 * time.Minute)},
+			action:      PurgerActionDestroy,
+			cleanupType: "free",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, cleanupType := purgerInstanceAction(tt.inst, settings, now)
+			assert.Equal(t, tt.action, action)
+			assert.Equal(t, tt.cleanupType, cleanupType)
+		})
+	}
+
+	leased := PurgerSettings{MaxAgeBusy: time.Hour, MaxAgeFree: 3 * time.Hour, BusyLeaseTTL: 10 * time.Minute}
+	leaseTests := []struct {
+		name        string
+		inst        *types.Instance
+		action      string
+		cleanupType string
+	}{
+		{
+			name: "lease renewed past max age",
+			inst: &types.Instance{State: types.StateInUse, Started: ago(150 * time.Minute), LeaseExpiry: now.Add(5 * time.Minute).Unix(), Labels: purgeable},
+		},
+		{
+			name:        "lease lapsed",
+			inst:        &types.Instance{State: types.StateInUse, Started: ago(30 * time.Minute), LeaseExpiry: ago(time.Minute), Labels: purgeable},
+			action:      PurgerActionDestroy,
+			cleanupType: "lease",
+		},
+		{
+			name:        "no lease past max age",
+			inst:        &types.Instance{State: types.StateInUse, Started: ago(90 * time.Minute), Labels: purgeable},
+			action:      PurgerActionDestroy,
+			cleanupType: "busy",
+		},
+		{
+			name:        "lapsed lease past twice the busy max age",
+			inst:        &types.Instance{State: types.StateInUse, Started: ago(150 * time.Minute), LeaseExpiry: ago(time.Minute), Labels: purgeable},
+			action:      PurgerActionForceDelete,
+			cleanupType: "busy",
+		},
+	}
+	for _, tt := range leaseTests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, cleanupType := purgerInstanceAction(tt.inst, leased, now)
+			assert.Equal(t, tt.action, action)
+			assert.Equal(t, tt.cleanupType, cleanupType)
+		})
+	}
+
+	// without a busy max age the free sweep decides
+	action, cleanupType := purgerInstanceAction(
+		&types.Instance{State: types.StateHibernating, Started: ago(4 * time.Hour), Labels: purgeable},
+		PurgerSettings{MaxAgeFree: 3 * time.Hour}, now)
+	assert.Equal(t, PurgerActionDestroy, action)
+	assert.Equal(t, "free", cleanupType)
+}
+
+func TestDistributedManager_ExplainPurger(t *testing.T) {
+	now := time.Now()
+	rows := []*types.Instance{
+		{ID: "busy", Name: "vm-busy", Pool: "pool1", TenantID: "t1", State: types.StateInUse, Stage: "stage-busy",
+			Started: now.Add(-90 * time.Minute).Unix(), Labels: []byte(`{"retain":"false"}`)},
+		{ID: "fresh", Pool: "pool1", State: types.StateInUse, Stage: "stage-fresh", Started: now.Unix(), Labels: []byte(`{"retain":"false"}`)},
+	}
+	capacities := []*types.CapacityReservation{
+		{StageID: "stage-created", ReservationID: "r1", ReservationState: types.CapacityReservationStateCreated, CreatedAt: now.Add(-time.Hour).Unix()},
+		{StageID: "stage-fresh", ReservationID: "r2", ReservationState: types.CapacityReservationStateInUse, CreatedAt: now.Add(-time.Hour).Unix()},
+		{StageID: "stage-gone", ReservationID: "r3", ReservationState: types.CapacityReservationStateInUse, CreatedAt: now.Add(-time.Hour).Unix()},
+	}
+	var listedStates []types.CapacityReservationState
+	d := &DistributedManager{
+		Manager: Manager{
+			poolMap:      map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: &flexibleMockDriver{driverName: "mock"}}}},
+			cleanupTimer: time.NewTicker(time.Hour),
+			instanceStore: &mockInstanceStore{
+				ListFunc: func(context.Context, string, *types.QueryParams) ([]*types.Instance, error) {
+					return rows, nil
+				},
+			},
+			capacityReservationStore: &mockCapacityReservationStore{
+				ListFunc: func(_ context.Context, _ *types.CapacityReservationQueryParams, states []types.CapacityReservationState) ([]*types.CapacityReservation, error) {
+					listedStates = states
+					return capacities, nil
+				},
+			},
+			purgerSettings: PurgerSettings{MaxAgeBusy: time.Hour, MaxAgeFree: 3 * time.Hour, FreeCapacityMaxAge: 10 * time.Minute},
+		},
+	}
+	defer d.cleanupTimer.Stop()
+
+	entries, err := d.ExplainPurger(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, listedStates, 3)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, &PurgerReportEntry{
+		Pool:       "pool1",
+		Kind:       PurgerKindInstance,
+		Action:     PurgerActionDestroy,
+		ID:         "busy",
+		Name:       "vm-busy",
+		State:      string(types.StateInUse),
+		TenantID:   "t1",
+		Reason:     PurgerReasonBusyMaxAge,
+		AgeSeconds: entries[0].AgeSeconds,
+	}, entries[0])
+	assert.InDelta(t, 90*60, entries[0].AgeSeconds, 5)
+
+	reasons := map[string]string{}
+	for _, entry := range entries[1:] {
+		assert.Equal(t, PurgerKindCapacityReservation, entry.Kind)
+		reasons[entry.ID] = entry.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"stage-created": PurgerCapacityReasonStuckCreated,
+		"stage-gone":    PurgerCapacityReasonOrphanedInUse,
+	}, reasons)
+}
+
+func TestDistributedManager_ExplainPurger_NotStarted(t *testing.T) {
+	d := &DistributedManager{}
+	_, err := d.ExplainPurger(context.Background())
+	assert.Error(t, err)
+}
+
+func TestManager_ExplainPurger(t *testing.T) {
+	now := time.Now()
+	rows := []*types.Instance{
+		{ID: "busy", Name: "vm-busy", Pool: "pool1", TenantID: "t1", State: types.StateInUse,
+			Started: now.Add(-90 * time.Minute).Unix()},
+		{ID: "fresh", Pool: "pool1", State: types.StateInUse, Started: now.Unix()},
+		{ID: "free", Pool: "pool1", State: types.StateCreated, Started: now.Add(-4 * time.Hour).Unix()},
+		{ID: "stuck", Pool: "pool1", State: types.StateProvisioning, Started: now.Add(-time.Hour).Unix()},
+	}
+	m := &Manager{
+		poolMap:      map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: &flexibleMockDriver{driverName: "mock"}}}},
+		cleanupTimer: time.NewTicker(time.Hour),
+		instanceStore: &mockInstanceStore{
+			ListFunc: func(context.Context, string, *types.QueryParams) ([]*types.Instance, error) {
+				return rows, nil
+			},
+		},
+		purgerSettings: PurgerSettings{MaxAgeBusy: time.Hour, MaxAgeFree: 3 * time.Hour},
+	}
+	defer m.cleanupTimer.Stop()
+
+	entries, err := m.ExplainPurger(context.Background())
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	reasons := map[string]string{}
+	for _, entry := range entries {
+		assert.Equal(t, PurgerActionDestroy, entry.Action)
+		assert.Equal(t, PurgerKindInstance, entry.Kind)
+		reasons[entry.ID] = entry.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"busy":  PurgerReasonBusyMaxAge,
+		"free":  PurgerReasonFreeMaxAge,
+		"stuck": PurgerReasonStuckProvisioning,
+	}, reasons)
+	assert.Equal(t, "free", entries[0].ID)
+}
+
+func TestManager_ExplainPurger_NotStarted(t *testing.T) {
+	_, err := (&Manager{}).ExplainPurger(context.Background())
+	assert.Error(t, err)
+}
@@ -10,6 +10,7 @@ const (
 	PurgerReasonBusyMaxAge        = "busy_maxage"
 	PurgerReasonFreeMaxAge        = "free_maxage"
 	PurgerReasonStuckProvisioning = "stuck_provisioning"
+	PurgerReasonLeaseExpired      = "lease_expired"
 	// PurgerReasonBusyMaxAgeTTLExtended and PurgerReasonStuckTerminating are part of the bounded
 	// taxonomy but are not currently emitted by DistributedManager: its claim query ORs the
 	// ttl-extended and stuck-terminating sub-conditions together with the plain busy_maxage
@@ -82,4 +83,18 @@ type MetricsRecorder interface {
 	// the resumed VM being confirmed healthy and set up for a stage (includes the health-check
 	// and setup phases, unlike RecordVMResumeDuration).
 	RecordVMResumeToReadyDuration(poolID, zone, vmType, outcome string, duration time.Duration)
+	// RecordImageRolloutStale sets the number of free instances of a pool that were created from
+	// an image the pool no longer uses.
+	RecordImageRolloutStale(poolID string, stale int)
+	// RecordImageRolloutReplacement records the outcome of replacing one stale instance.
+	RecordImageRolloutReplacement(poolID, outcome string)
+	// RecordReconciledInstance records one leaked VM or instance row found by reconciliation and
+	// what was done with it (see reconcile.go for the bounded kind/outcome values).
+	RecordReconciledInstance(poolID, kind, outcome string)
+	// RecordOrphanedResource records one orphaned disk or capacity reservation found by the orphan
+	// collection and what was done with it (see orphans.go for the bounded kind/outcome values).
+	RecordOrphanedResource(poolID, kind, outcome string)
+	// RecordHibernationTransition records one free instance the hibernation policy moved to
+	// another state (see hibernation_policy.go for the bounded state/outcome values).
+	RecordHibernationTransition(poolID, state, outcome string)
 }
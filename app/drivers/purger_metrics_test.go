@@ -70,6 +70,26 @@ type vmResumeToReadyDurationRecord struct {
 	duration                      time.Duration
 }
 
+// imageRolloutReplacementRecord captures one call to fakePurgerMetrics.RecordImageRolloutReplacement.
+type imageRolloutReplacementRecord struct {
+	poolID, outcome string
+}
+
+// reconciledInstanceRecord captures one call to fakePurgerMetrics.RecordReconciledInstance.
+type reconciledInstanceRecord struct {
+	poolID, kind, outcome string
+}
+
+// orphanedResourceRecord captures one call to fakePurgerMetrics.RecordOrphanedResource.
+type orphanedResourceRecord struct {
+	poolID, kind, outcome string
+}
+
+// hibernationTransitionRecord captures one call to fakePurgerMetrics.RecordHibernationTransition.
+type hibernationTransitionRecord struct {
+	poolID, state, outcome string
+}
+
 // fakePurgerMetrics is an in-memory MetricsRecorder test double (covering both the purger and
 // VM lifecycle metrics) that records every call so tests can assert on exactly what was
 // reported, instead of exercising real Prometheus collectors.
@@ -88,6 +108,11 @@ type fakePurgerMetrics struct {
 	vmResumeAtts     []vmResumeAttemptRecord
 	vmResumeDurs     []vmResumeDurationRecord
 	vmResumeToReady  []vmResumeToReadyDurationRecord
+	rolloutStale     map[string]int
+	rolloutReplaced  []imageRolloutReplacementRecord
+	reconciled       []reconciledInstanceRecord
+	orphans          []orphanedResourceRecord
+	hibernationMoves []hibernationTransitionRecord
 }
 
 func (f *fakePurgerMetrics) RecordPurgerLastRun(poolID string) {
@@ -161,3 +186,36 @@ func (f *fakePurgerMetrics) RecordVMResumeToReadyDuration(poolID, zone, vmType,
 	defer f.mu.Unlock()
 	f.vmResumeToReady = append(f.vmResumeToReady, vmResumeToReadyDurationRecord{poolID, zone, vmType, outcome, duration})
 }
+
+func (f *fakePurgerMetrics) RecordImageRolloutStale(poolID string, stale int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rolloutStale == nil {
+		f.rolloutStale = map[string]int{}
+	}
+	f.rolloutStale[poolID] = stale
+}
+
+func (f *fakePurgerMetrics) RecordImageRolloutReplacement(poolID, outcome string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rolloutReplaced = append(f.rolloutReplaced, imageRolloutReplacementRecord{poolID, outcome})
+}
+
+func (f *fakePurgerMetrics) RecordReconciledInstance(poolID, kind, outcome string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reconciled = append(f.reconciled, reconciledInstanceRecord{poolID, kind, outcome})
+}
+
+func (f *fakePurgerMetrics) RecordOrphanedResource(poolID, kind, outcome string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.orphans = append(f.orphans, orphanedResourceRecord{poolID, kind, outcome})
+}
+
+func (f *fakePurgerMetrics) RecordHibernationTransition(poolID, state, outcome string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hibernationMoves = append(f.hibernationMoves, hibernationTransitionRecord{poolID, state, outcome})
+}
@@ -0,0 +1,420 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Rate limit buckets. Calls that create, change or remove cloud resources are
+// throttled separately from calls that only read them, so that a burst of
+// creates does not starve health checks and interruption checks, and the other
+// way round.
+const (
+	RateLimitBucketMutating = "mutating"
+	RateLimitBucketRead     = "read"
+)
+
+// RateLimits configures the rate limiting of a driver instance. A zero rate
+// leaves the bucket unlimited and a zero MaxInFlight leaves the number of
+// concurrent calls unlimited.
+type RateLimits struct {
+	// MutatingRate is the number of mutating calls allowed per second.
+	MutatingRate float64
+	// MutatingBurst is the number of mutating calls allowed at once. It
+	// defaults to the rate, rounded up.
+	MutatingBurst int
+	// ReadRate is the number of read calls allowed per second.
+	ReadRate float64
+	// ReadBurst is the number of read calls allowed at once. It defaults to
+	// the rate, rounded up.
+	ReadBurst int
+	// MaxInFlight is the maximum number of calls, of either kind, running at
+	// the same time.
+	MaxInFlight int
+}
+
+// Enabled reports whether any limit is set.
+func (l RateLimits) Enabled() bool {
+	return l.MutatingRate > 0 || l.ReadRate > 0 || l.MaxInFlight > 0
+}
+
+// Validate checks that the limits are not negative.
+func (l RateLimits) Validate() error {
+	switch {
+	case l.MutatingRate < 0, l.ReadRate < 0:
+		return fmt.Errorf("rate limit: rates must not be negative")
+	case l.MutatingBurst < 0, l.ReadBurst < 0:
+		return fmt.Errorf("rate limit: bursts must not be negative")
+	case l.MaxInFlight < 0:
+		return fmt.Errorf("rate limit: max in flight must not be negative")
+	}
+	return nil
+}
+
+// RateLimitRecorder records how long driver calls waited for the rate limiter.
+// It is implemented by *metric.Metrics.
+type RateLimitRecorder interface {
+	RecordDriverQueueWait(poolID, driver, bucket, operation string, wait time.Duration)
+}
+
+// DriverWrapper is implemented by drivers that wrap another driver, such as
+// the rate limited driver.
+type DriverWrapper interface {
+	Unwrap() Driver
+}
+
+// As returns the driver as a T when the driver it wraps, if any, implements T.
+// Wrappers implement the optional driver interfaces so that calls go through
+// them, which makes a plain type assertion on a wrapper always succeed.
+func As[T any](driver Driver) (T, bool) {
+	var zero T
+	inner := driver
+	for {
+		w, ok := inner.(DriverWrapper)
+		if !ok {
+			break
+		}
+		inner = w.Unwrap()
+	}
+	if _, ok := inner.(T); !ok {
+		return zero, false
+	}
+	t, ok := driver.(T)
+	return t, ok
+}
+
+// rateLimitedDriver throttles the calls made to a driver with a token bucket
+// for mutating calls, one for read calls and a cap on the calls in flight.
+type rateLimitedDriver struct {
+	driver   Driver
+	pool     string
+	mutating *rate.Limiter
+	read     *rate.Limiter
+	inFlight chan struct{}
+	metrics  RateLimitRecorder
+}
+
+var (
+	_ Driver                    = (*rateLimitedDriver)(nil)
+	_ InterruptionDetector      = (*rateLimitedDriver)(nil)
+	_ InterruptionReporter      = (*rateLimitedDriver)(nil)
+	_ ImageBaker                = (*rateLimitedDriver)(nil)
+	_ InstanceLister            = (*rateLimitedDriver)(nil)
+	_ DiskCollector             = (*rateLimitedDriver)(nil)
+	_ CapacityReservationLister = (*rateLimitedDriver)(nil)
+	_ DriverWrapper             = (*rateLimitedDriver)(nil)
+)
+
+// NewRateLimitedDriver wraps the driver of a pool so that its calls respect
+// the limits. The driver is returned as is when no limit is set.
+func NewRateLimitedDriver(driver Driver, pool string, limits RateLimits, metrics RateLimitRecorder) Driver {
+	if driver == nil || !limits.Enabled() {
+		return driver
+	}
+	d := &rateLimitedDriver{
+		driver:   driver,
+		pool:     pool,
+		mutating: newLimiter(limits.MutatingRate, limits.MutatingBurst),
+		read:     newLimiter(limits.ReadRate, limits.ReadBurst),
+		metrics:  metrics,
+	}
+	if limits.MaxInFlight > 0 {
+		d.inFlight = make(chan struct{}, limits.MaxInFlight)
+	}
+	return d
+}
+
+func newLimiter(r float64, burst int) *rate.Limiter {
+	if r <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(r))
+	}
+	return rate.NewLimiter(rate.Limit(r), burst)
+}
+
+// acquire waits for a token of the bucket and a free in-flight slot. The
+// returned func releases the slot. The time spent waiting is recorded even
+// when the context is done before the call could go ahead.
+func (d *rateLimitedDriver) acquire(ctx context.Context, bucket, operation string) (func(), error) {
+	start := time.Now()
+	limiter := d.read
+	if bucket == RateLimitBucketMutating {
+		limiter = d.mutating
+	}
+	defer func() {
+		if d.metrics != nil {
+			d.metrics.RecordDriverQueueWait(d.pool, d.driver.DriverName(), bucket, operation, time.Since(start))
+		}
+	}()
+
+	if limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("rate limit: %s on pool %s: %w", operation, d.pool, err)
+		}
+	}
+	if d.inFlight == nil {
+		return func() {}, nil
+	}
+	// select picks at random when a slot is free and the context is done
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("rate limit: %s on pool %s: %w", operation, d.pool, err)
+	}
+	select {
+	case d.inFlight <- struct{}{}:
+		return func() { <-d.inFlight }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("rate limit: %s on pool %s: %w", operation, d.pool, ctx.Err())
+	}
+}
+
+func (d *rateLimitedDriver) Unwrap() Driver {
+	return d.driver
+}
+
+func (d *rateLimitedDriver) ReserveCapacity(ctx context.Context, opts *types.InstanceCreateOpts) (*types.CapacityReservation, error) {
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "reserve_capacity")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return d.driver.ReserveCapacity(ctx, opts)
+}
+
+func (d *rateLimitedDriver) DestroyCapacity(ctx context.Context, capacity *types.CapacityReservation) error {
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "destroy_capacity")
+	if err != nil {
+		return err
+	}
+	defer release()
+	return d.driver.DestroyCapacity(ctx, capacity)
+}
+
+func (d *rateLimitedDriver) Create(ctx context.Context, opts *types.InstanceCreateOpts) (*types.Instance, error) {
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "create")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return d.driver.Create(ctx, opts)
+}
+
+// Destroy fails all instances when the call could not go ahead, the same as
+// a driver that could not reach the cloud API.
+func (d *rateLimitedDriver) Destroy(ctx context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "destroy")
+	if err != nil {
+		return instances, err
+	}
+	defer release()
+	return d.driver.Destroy(ctx, instances)
+}
+
+func (d *rateLimitedDriver) DestroyInstanceAndStorage(
+	ctx context.Context,
+	instances []*types.Instance,
+	storageCleanupType *storage.CleanupType,
+) ([]*types.Instance, error) {
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "destroy")
+	if err != nil {
+		return instances, err
+	}
+	defer release()
+	return d.driver.DestroyInstanceAndStorage(ctx, instances, storageCleanupType)
+}
+
+func (d *rateLimitedDriver) Hibernate(ctx context.Context, instanceID, poolName, zone string) error {
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "hibernate")
+	if err != nil {
+		return err
+	}
+	defer release()
+	return d.driver.Hibernate(ctx, instanceID, poolName, zone)
+}
+
+func (d *rateLimitedDriver) Start(ctx context.Context, instance *types.Instance, poolName string) (string, error) {
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "start")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return d.driver.Start(ctx, instance, poolName)
+}
+
+func (d *rateLimitedDriver) SetTags(ctx context.Context, instance *types.Instance, tags map[string]string) error {
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "set_tags")
+	if err != nil {
+		return err
+	}
+	defer release()
+	return d.driver.SetTags(ctx, instance, tags)
+}
+
+func (d *rateLimitedDriver) SetLabels(ctx context.Context, instance *types.Instance, labels map[string]string) error {
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "set_labels")
+	if err != nil {
+		return err
+	}
+	defer release()
+	return d.driver.SetLabels(ctx, instance, labels)
+}
+
+func (d *rateLimitedDriver) Ping(ctx context.Context) error {
+	release, err := d.acquire(ctx, RateLimitBucketRead, "ping")
+	if err != nil {
+		return err
+	}
+	defer release()
+	return d.driver.Ping(ctx)
+}
+
+func (d *rateLimitedDriver) Logs(ctx context.Context, instanceID string) (string, error) {
+	release, err := d.acquire(ctx, RateLimitBucketRead, "logs")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return d.driver.Logs(ctx, instanceID)
+}
+
+func (d *rateLimitedDriver) GetFullyQualifiedImage(ctx context.Context, config *types.VMImageConfig) (string, error) {
+	release, err := d.acquire(ctx, RateLimitBucketRead, "get_image")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return d.driver.GetFullyQualifiedImage(ctx, config)
+}
+
+// Interrupted must only be called when the wrapped driver is an
+// InterruptionDetector, see As.
+func (d *rateLimitedDriver) Interrupted(ctx context.Context, instances []*types.Instance) ([]string, error) {
+	detector, ok := d.driver.(InterruptionDetector)
+	if !ok {
+		return nil, nil
+	}
+	release, err := d.acquire(ctx, RateLimitBucketRead, "interrupted")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return detector.Interrupted(ctx, instances)
+}
+
+// InterruptionError does not call the cloud API so it is not rate limited.
+func (d *rateLimitedDriver) InterruptionError(instance *types.Instance) error {
+	if reporter, ok := d.driver.(InterruptionReporter); ok {
+		return reporter.InterruptionError(instance)
+	}
+	return nil
+}
+
+// BakeImage must only be called when the wrapped driver is an ImageBaker, see
+// As.
+func (d *rateLimitedDriver) BakeImage(ctx context.Context, instance *types.Instance, name string) (string, error) {
+	baker, ok := d.driver.(ImageBaker)
+	if !ok {
+		return "", fmt.Errorf("the %s driver cannot bake images", d.driver.DriverName())
+	}
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "bake_image")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return baker.BakeImage(ctx, instance, name)
+}
+
+// List must only be called when the wrapped driver is an InstanceLister, see
+// As.
+func (d *rateLimitedDriver) List(ctx context.Context, labels map[string]string) ([]*CloudInstance, error) {
+	lister, ok := d.driver.(InstanceLister)
+	if !ok {
+		return nil, fmt.Errorf("the %s driver cannot list instances", d.driver.DriverName())
+	}
+	release, err := d.acquire(ctx, RateLimitBucketRead, "list")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return lister.List(ctx, labels)
+}
+
+// Exists must only be called when the wrapped driver is an InstanceLister,
+// see As.
+func (d *rateLimitedDriver) Exists(ctx context.Context, inst *types.Instance) (bool, error) {
+	lister, ok := d.driver.(InstanceLister)
+	if !ok {
+		return false, fmt.Errorf("the %s driver cannot list instances", d.driver.DriverName())
+	}
+	release, err := d.acquire(ctx, RateLimitBucketRead, "exists")
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	return lister.Exists(ctx, inst)
+}
+
+// ListDisks must only be called when the wrapped driver is a DiskCollector,
+// see As.
+func (d *rateLimitedDriver) ListDisks(ctx context.Context, labels map[string]string) ([]*CloudResource, error) {
+	collector, ok := d.driver.(DiskCollector)
+	if !ok {
+		return nil, fmt.Errorf("the %s driver cannot list disks", d.driver.DriverName())
+	}
+	release, err := d.acquire(ctx, RateLimitBucketRead, "list_disks")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return collector.ListDisks(ctx, labels)
+}
+
+// DeleteDisk must only be called when the wrapped driver is a DiskCollector,
+// see As.
+func (d *rateLimitedDriver) DeleteDisk(ctx context.Context, disk *CloudResource) error {
+	collector, ok := d.driver.(DiskCollector)
+	if !ok {
+		return fmt.Errorf("the %s driver cannot delete disks", d.driver.DriverName())
+	}
+	release, err := d.acquire(ctx, RateLimitBucketMutating, "delete_disk")
+	if err != nil {
+		return err
+	}
+	defer release()
+	return collector.DeleteDisk(ctx, disk)
+}
+
+// ListCapacityReservations must only be called when the wrapped driver is a
+// CapacityReservationLister, see As.
+func (d *rateLimitedDriver) ListCapacityReservations(ctx context.Context, labels map[string]string) ([]*CloudResource, error) {
+	lister, ok := d.driver.(CapacityReservationLister)
+	if !ok {
+		return nil, fmt.Errorf("the %s driver cannot list capacity reservations", d.driver.DriverName())
+	}
+	release, err := d.acquire(ctx, RateLimitBucketRead, "list_capacity_reservations")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return lister.ListCapacityReservations(ctx, labels)
+}
+
+func (d *rateLimitedDriver) RootDir() string {
+	return d.driver.RootDir()
+}
+
+func (d *rateLimitedDriver) DriverName() string {
+	return d.driver.DriverName()
+}
+
+func (d *rateLimitedDriver) CanHibernate() bool {
+	return d.driver.CanHibernate()
+}
@@ -0,0 +1,190 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	itypes "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+type queueWait struct {
+	pool, driver, bucket, operation string
+}
+
+type fakeRateLimitRecorder struct {
+	mu    sync.Mutex
+	waits []queueWait
+}
+
+func (r *fakeRateLimitRecorder) RecordDriverQueueWait(poolID, driver, bucket, operation string, _ time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waits = append(r.waits, queueWait{poolID, driver, bucket, operation})
+}
+
+func TestNewRateLimitedDriver_NoLimits(t *testing.T) {
+	driver := &flexibleMockDriver{driverName: "mock"}
+	assert.Same(t, driver, NewRateLimitedDriver(driver, "pool1", RateLimits{}, nil))
+}
+
+func TestRateLimitedDriver_MaxInFlight(t *testing.T) {
+	var running, peak int32
+	unblock := make(chan struct{})
+	driver := &flexibleMockDriver{
+		driverName: "mock",
+		CreateFunc: func(_ context.Context, _ *types.InstanceCreateOpts) (*types.Instance, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			<-unblock
+			atomic.AddInt32(&running, -1)
+			return &types.Instance{}, nil
+		},
+	}
+	limited := NewRateLimitedDriver(driver, "pool1", RateLimits{MaxInFlight: 2}, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := limited.Create(context.Background(), &types.InstanceCreateOpts{})
+			assert.NoError(t, err)
+		}()
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&running) == 2 }, time.Second, time.Millisecond)
+	// the other calls stay queued while the first two are in flight
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&running))
+
+	close(unblock)
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&peak))
+}
+
+func TestRateLimitedDriver_SeparateBuckets(t *testing.T) {
+	recorder := &fakeRateLimitRecorder{}
+	driver := &flexibleMockDriver{
+		driverName: "mock",
+		CreateFunc: func(_ context.Context, _ *types.InstanceCreateOpts) (*types.Instance, error) {
+			return &types.Instance{}, nil
+		},
+	}
+	// one mutating call per hour, reads are unlimited
+	limited := NewRateLimitedDriver(driver, "pool1", RateLimits{MutatingRate: 1.0 / 3600, MutatingBurst: 1}, recorder)
+
+	_, err := limited.Create(context.Background(), &types.InstanceCreateOpts{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = limited.Hibernate(ctx, "instance1", "pool1", "zone1")
+	require.Error(t, err, "expected the second mutating call to be throttled")
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, limited.Ping(context.Background()))
+	}
+
+	assert.Equal(t, queueWait{"pool1", "mock", RateLimitBucketMutating, "create"}, recorder.waits[0])
+	assert.Equal(t, queueWait{"pool1", "mock", RateLimitBucketMutating, "hibernate"}, recorder.waits[1])
+	assert.Equal(t, queueWait{"pool1", "mock", RateLimitBucketRead, "ping"}, recorder.waits[2])
+	assert.Len(t, recorder.waits, 12)
+}
+
+func TestRateLimitedDriver_DestroyFailsAllWhenThrottled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	driver := &flexibleMockDriver{
+		driverName: "mock",
+		DestroyFunc: func(_ context.Context, _ []*types.Instance) ([]*types.Instance, error) {
+			t.Fatal("the driver must not be called")
+			return nil, nil
+		},
+	}
+	limited := NewRateLimitedDriver(driver, "pool1", RateLimits{MaxInFlight: 1}, nil)
+
+	instances := []*types.Instance{{ID: "1"}, {ID: "2"}}
+	failed, err := limited.Destroy(ctx, instances)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, instances, failed)
+}
+
+func TestRateLimitedDriver_Capabilities(t *testing.T) {
+	limits := RateLimits{ReadRate: 100}
+
+	plain := NewRateLimitedDriver(&flexibleMockDriver{driverName: "mock"}, "pool1", limits, nil)
+	_, ok := As[InterruptionDetector](plain)
+	assert.False(t, ok, "a wrapped driver must not gain capabilities")
+
+	interruptible := NewRateLimitedDriver(&interruptibleMockDriver{
+		flexibleMockDriver: flexibleMockDriver{driverName: "amazon"},
+		interrupted:        []string{"1"},
+	}, "pool1", limits, nil)
+	detector, ok := As[InterruptionDetector](interruptible)
+	require.True(t, ok)
+	ids, err := detector.Interrupted(context.Background(), []*types.Instance{{ID: "1"}, {ID: "2"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1"}, ids)
+	_, ok = As[InterruptionReporter](interruptible)
+	assert.False(t, ok)
+
+	_, ok = As[InstanceLister](plain)
+	assert.False(t, ok)
+	listing := NewRateLimitedDriver(&listingMockDriver{
+		flexibleMockDriver: &flexibleMockDriver{driverName: "google"},
+		vms:                []*CloudInstance{{ID: "1"}},
+	}, "pool1", limits, nil)
+	lister, ok := As[InstanceLister](listing)
+	require.True(t, ok)
+	vms, err := lister.List(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*CloudInstance{{ID: "1"}}, vms)
+
+	_, ok = As[DiskCollector](plain)
+	assert.False(t, ok)
+	collecting := NewRateLimitedDriver(&collectingMockDriver{
+		flexibleMockDriver: &flexibleMockDriver{driverName: "google"},
+		disks:              []*CloudResource{{ID: "disk"}},
+	}, "pool1", limits, nil)
+	collector, ok := As[DiskCollector](collecting)
+	require.True(t, ok)
+	disks, err := collector.ListDisks(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*CloudResource{{ID: "disk"}}, disks)
+	_, ok = As[CapacityReservationLister](collecting)
+	assert.True(t, ok)
+}
+
+func TestManager_CheckInterruption_RateLimited(t *testing.T) {
+	instanceStore := &mockInstanceStore{
+		FindFunc: func(_ context.Context, _ string) (*types.Instance, error) {
+			return &types.Instance{ID: "1", State: types.StateInUse}, nil
+		},
+		UpdateFunc: func(_ context.Context, _ *types.Instance) error { return nil },
+	}
+	driver := NewRateLimitedDriver(&preemptibleMockDriver{interruptibleMockDriver{
+		flexibleMockDriver: flexibleMockDriver{driverName: "google"},
+		interrupted:        []string{"1"},
+	}}, "pool1", RateLimits{MaxInFlight: 1}, nil)
+	m := &Manager{
+		instanceStore: instanceStore,
+		poolMap:       map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: driver}}},
+	}
+	assert.True(t, m.hasInterruptiblePools())
+
+	err := m.CheckInterruption(context.Background(), "pool1", &types.Instance{ID: "1", Zone: "us-central1-a"})
+	var preemptedErr *itypes.ErrSpotPreempted
+	assert.True(t, errors.As(err, &preemptedErr))
+}
@@ -0,0 +1,300 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Reconciliation kinds and outcomes. Bounded set, used as metric labels.
+const (
+	ReconcileKindLeakedVM = "leaked_vm"
+	ReconcileKindGhostRow = "ghost_row"
+
+	ReconcileOutcomeRemoved  = "removed"
+	ReconcileOutcomeFailed   = "failed"
+	ReconcileOutcomeRetained = "retained"
+	ReconcileOutcomeDryRun   = "dry_run"
+)
+
+// CloudInstance is a VM as listed by the cloud provider.
+type CloudInstance struct {
+	// ID is the ID the instance is stored with, see types.Instance.ID.
+	ID      string
+	Name    string
+	Zone    string
+	Created time.Time
+	Labels  map[string]string
+}
+
+// InstanceLister is implemented by drivers that can enumerate the VMs they
+// created, so that the cloud can be reconciled with the instance store.
+type InstanceLister interface {
+	// List returns the VMs carrying all the given labels (tags on AWS) that
+	// are not deleted yet, whatever their state. The listing must be
+	// complete: a VM missing from it is considered gone.
+	List(ctx context.Context, labels map[string]string) ([]*CloudInstance, error)
+	// Exists looks up the VM of an instance row by its ID. It returns false
+	// only when the cloud reports the VM as not found or terminated.
+	Exists(ctx context.Context, inst *types.Instance) (bool, error)
+}
+
+// ReconcileOptions configures Manager.ReconcileInstances.
+type ReconcileOptions struct {
+	// GracePeriod is how old a VM or an instance row must be before it is
+	// considered leaked. It covers the window between a VM being created and
+	// its row being stored.
+	GracePeriod time.Duration
+	// DryRun only reports what would be removed.
+	DryRun bool
+}
+
+// ReconcileInstances compares the VMs carrying the identity labels of each
+// pool (see buildIdentityVMLabels) with the instance store, destroying the
+// leaked VMs that have no row and deleting the free rows whose VM the driver
+// confirms is gone. Busy and hibernating rows are never deleted. VMs and rows
+// retained for SkipCloudVMCleanup or gitspaces are left alone. Identity labels
+// are only written in hosted mode, so this is a no-op for VMs created by a
+// self-hosted runner, and only the pools whose drivers implement
+// InstanceLister are reconciled.
+func (m *Manager) ReconcileInstances(ctx context.Context, opts ReconcileOptions) error {
+	var errs []error
+	for _, pool := range m.poolMap {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+				}
+			}()
+			if err := m.reconcilePool(ctx, pool, opts); err != nil {
+				errs = append(errs, fmt.Errorf("pool %s: %w", pool.Name, err))
+			}
+		}()
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) reconcilePool(ctx context.Context, pool *poolEntry, opts ReconcileOptions) error {
+	rows, err := m.instanceStore.List(ctx, pool.Name, &types.QueryParams{})
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		stored[row.ID] = true
+	}
+
+	labels := reconcileLabels(pool.Name, m.env)
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	var errs []error
+	for tenantID, driver := range poolDrivers(&pool.Pool) {
+		lister, ok := As[InstanceLister](driver)
+		if !ok {
+			continue
+		}
+		logr := logger.FromContext(ctx).
+			WithField("driver", driver.DriverName()).
+			WithField("pool", pool.Name).
+			WithField("tenant_id", tenantID)
+
+		vms, listErr := lister.List(ctx, labels)
+		if listErr != nil {
+			errs = append(errs, listErr)
+			continue
+		}
+
+		var leaked []*types.Instance
+		listed := make(map[string]bool, len(vms))
+		for _, vm := range vms {
+			// VMs of another environment sharing the project are not ours,
+			// whether or not this runner has an environment
+			if vm.Labels["harness_env"] != m.env {
+				continue
+			}
+			listed[vm.ID] = true
+			if stored[vm.ID] || vm.Created.After(cutoff) {
+				continue
+			}
+			if vm.Labels[LabelRetain] == "true" {
+				logr.WithField("instance_id", vm.ID).WithField("instance_name", vm.Name).
+					Infoln("reconcile: leaked VM is retained, leaving it")
+				m.recordReconciled(pool.Name, ReconcileKindLeakedVM, ReconcileOutcomeRetained)
+				continue
+			}
+			leaked = append(leaked, &types.Instance{
+				ID:       vm.ID,
+				Name:     vm.Name,
+				Zone:     vm.Zone,
+				Pool:     pool.Name,
+				TenantID: tenantID,
+			})
+		}
+		m.destroyLeaked(ctx, logr, driver, pool.Name, leaked, opts.DryRun)
+
+		// an empty listing is more likely a wrong project or a missing
+		// permission than every VM of the pool being gone
+		if len(vms) == 0 {
+			logr.Warnln("reconcile: no VMs listed, skipping instance rows")
+			continue
+		}
+		var ghosts []*types.Instance
+		for _, row := range rows {
+			if listed[row.ID] || pool.DriverForTenant(row.TenantID) != driver ||
+				!ghostCandidate(row) || time.Unix(row.Started, 0).After(cutoff) {
+				continue
+			}
+			if instanceRetained(row) {
+				m.recordReconciled(pool.Name, ReconcileKindGhostRow, ReconcileOutcomeRetained)
+				continue
+			}
+			// a VM missing from the listing may only have lost its labels,
+			// the row is a ghost once the cloud confirms the VM is gone
+			exists, existsErr := lister.Exists(ctx, row)
+			if existsErr != nil {
+				logr.WithError(existsErr).WithField("instance_id", row.ID).
+					Warnln("reconcile: failed to look up the VM of an unlisted instance row, keeping it")
+				continue
+			}
+			if exists {
+				logr.WithField("instance_id", row.ID).
+					Warnln("reconcile: VM of the instance row exists but is not listed, check its labels")
+				continue
+			}
+			ghosts = append(ghosts, row)
+		}
+		m.deleteGhosts(ctx, logr, pool.Name, ghosts, opts.DryRun)
+	}
+	return errors.Join(errs...)
+}
+
+// ghostCandidate reports whether an instance row may be deleted when its VM is
+// gone. Provisioning rows may not have a VM yet, and busy and hibernating rows
+// are cleaned up by the stage and the purger.
+func ghostCandidate(row *types.Instance) bool {
+	return row.State != types.StateProvisioning && row.State != types.StateInUse && row.State != types.StateHibernating
+}
+
+// destroyLeaked destroys VMs that have no instance row.
+func (m *Manager) destroyLeaked(ctx context.Context, logr logger.Logger, driver Driver, poolName string, leaked []*types.Instance, dryRun bool) {
+	if len(leaked) == 0 {
+		return
+	}
+	ids := make([]string, len(leaked))
+	for i, inst := range leaked {
+		ids[i] = inst.ID
+	}
+	logr = logr.WithField("instance_ids", ids)
+	if dryRun {
+		logr.Infof("reconcile: dry run, would destroy %d leaked VMs", len(leaked))
+		for range leaked {
+			m.recordReconciled(poolName, ReconcileKindLeakedVM, ReconcileOutcomeDryRun)
+		}
+		return
+	}
+
+	logr.Warnf("reconcile: destroying %d leaked VMs with no instance row", len(leaked))
+	failed, err := driver.Destroy(ctx, leaked)
+	if err != nil {
+		logr.WithError(err).Errorln("reconcile: failed to destroy leaked VMs")
+		if len(failed) == 0 {
+			failed = leaked
+		}
+	}
+	for range failed {
+		m.recordReconciled(poolName, ReconcileKindLeakedVM, ReconcileOutcomeFailed)
+	}
+	for i := len(failed); i < len(leaked); i++ {
+		m.recordReconciled(poolName, ReconcileKindLeakedVM, ReconcileOutcomeRemoved)
+	}
+}
+
+// deleteGhosts deletes instance rows whose VM no longer exists.
+func (m *Manager) deleteGhosts(ctx context.Context, logr logger.Logger, poolName string, ghosts []*types.Instance, dryRun bool) {
+	if len(ghosts) == 0 {
+		return
+	}
+	ids := make([]string, len(ghosts))
+	for i, inst := range ghosts {
+		ids[i] = inst.ID
+	}
+	logr = logr.WithField("instance_ids", ids)
+	if dryRun {
+		logr.Infof("reconcile: dry run, would delete %d instance rows with no VM", len(ghosts))
+		for range ghosts {
+			m.recordReconciled(poolName, ReconcileKindGhostRow, ReconcileOutcomeDryRun)
+		}
+		return
+	}
+
+	logr.Warnf("reconcile: deleting %d instance rows with no VM", len(ghosts))
+	for _, inst := range ghosts {
+		outcome := ReconcileOutcomeRemoved
+		if err := m.instanceStore.Delete(ctx, inst.ID); err != nil {
+			outcome = ReconcileOutcomeFailed
+			logr.WithError(err).WithField("instance_id", inst.ID).
+				Errorln("reconcile: failed to delete instance row")
+		}
+		m.recordReconciled(poolName, ReconcileKindGhostRow, outcome)
+	}
+}
+
+func (m *Manager) recordReconciled(poolName, kind, outcome string) {
+	if m.metrics != nil {
+		m.metrics.RecordReconciledInstance(poolName, kind, outcome)
+	}
+}
+
+// reconcileLabels returns the identity labels every VM of the pool created by
+// a runner of this environment carries.
+func reconcileLabels(poolName, env string) map[string]string {
+	labels := map[string]string{
+		"pool_id":      poolName,
+		LabelCreatedBy: identityCreatedBy,
+	}
+	if env != "" {
+		labels["harness_env"] = env
+	}
+	return labels
+}
+
+// poolDrivers returns the distinct drivers of a pool keyed by tenant ID.
+func poolDrivers(pool *Pool) map[string]Driver {
+	if !pool.IsMultiTenant() {
+		return map[string]Driver{types.DefaultTenantID: pool.Driver}
+	}
+	drivers := map[string]Driver{}
+	seen := map[Driver]bool{}
+	for tenantID, driver := range pool.TenantDrivers {
+		if driver == nil || seen[driver] {
+			continue
+		}
+		seen[driver] = true
+		drivers[tenantID] = driver
+	}
+	return drivers
+}
+
+// instanceRetained reports whether the instance row carries the retain label.
+func instanceRetained(inst *types.Instance) bool {
+	return instanceLabels(inst)["retain"] == "true"
+}
+
+// instanceLabels returns the labels of an instance row, or nil.
+func instanceLabels(inst *types.Instance) map[string]string {
+	if len(inst.Labels) == 0 {
+		return nil
+	}
+	var labels map[string]string
+	if err := json.Unmarshal(inst.Labels, &labels); err != nil {
+		return nil
+	}
+	return labels
+}
@@ -0,0 +1,199 @@
+package drivers
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// listingMockDriver is a flexibleMockDriver that can list its VMs. VMs that
+// are not listed exist only when they are in unlabeled.
+type listingMockDriver struct {
+	*flexibleMockDriver
+	vms       []*CloudInstance
+	err       error
+	labels    map[string]string
+	unlabeled map[string]bool
+	existsErr error
+	looked    []string
+}
+
+func (d *listingMockDriver) List(_ context.Context, labels map[string]string) ([]*CloudInstance, error) {
+	d.labels = labels
+	return d.vms, d.err
+}
+
+func (d *listingMockDriver) Exists(_ context.Context, inst *types.Instance) (bool, error) {
+	d.looked = append(d.looked, inst.ID)
+	return d.unlabeled[inst.ID], d.existsErr
+}
+
+func newReconcileTestManager(rows []*types.Instance, vms []*CloudInstance) (m *Manager, driver *listingMockDriver, destroyed, deleted *[]string) {
+	destroyed, deleted = new([]string), new([]string)
+	store := &mockInstanceStore{
+		ListFunc: func(context.Context, string, *types.QueryParams) ([]*types.Instance, error) {
+			return rows, nil
+		},
+		DeleteFunc: func(_ context.Context, id string) error {
+			*deleted = append(*deleted, id)
+			return nil
+		},
+	}
+	driver = &listingMockDriver{
+		flexibleMockDriver: &flexibleMockDriver{
+			driverName: "google",
+			DestroyFunc: func(_ context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+				for _, inst := range instances {
+					*destroyed = append(*destroyed, inst.ID)
+				}
+				return nil, nil
+			},
+		},
+		vms: vms,
+	}
+	m = &Manager{
+		env:           "prod",
+		instanceStore: store,
+		poolMap:       map[string]*poolEntry{"pool1": {Pool: Pool{Name: "pool1", Driver: driver}}},
+	}
+	return m, driver, destroyed, deleted
+}
+
+func TestManager_ReconcileInstances(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	labels := func(extra ...string) map[string]string {
+		l := map[string]string{"harness_env": "prod"}
+		for i := 0; i+1 < len(extra); i += 2 {
+			l[extra[i]] = extra[i+1]
+		}
+		return l
+	}
+	retained := []byte(`{"retain":"true"}`)
+	rows := []*types.Instance{
+		{ID: "known", State: types.StateCreated, Started: old.Unix()},
+		{ID: "ghost", State: types.StateCreated, Started: old.Unix()},
+		{ID: "ghost-retained", State: types.StateCreated, Started: old.Unix(), Labels: retained},
+		{ID: "ghost-young", State: types.StateCreated, Started: time.Now().Unix()},
+		{ID: "ghost-provisioning", State: types.StateProvisioning, Started: old.Unix()},
+		{ID: "ghost-busy", State: types.StateInUse, Started: old.Unix()},
+		{ID: "ghost-hibernating", State: types.StateHibernating, Started: old.Unix()},
+		{ID: "unlabeled", State: types.StateCreated, Started: old.Unix()},
+	}
+	vms := []*CloudInstance{
+		{ID: "known", Created: old, Labels: labels()},
+		{ID: "leaked", Name: "leaked-vm", Zone: "us-east1-b", Created: old, Labels: labels()},
+		{ID: "leaked-retained", Created: old, Labels: labels(LabelRetain, "true")},
+		{ID: "leaked-young", Created: time.Now(), Labels: labels()},
+		{ID: "other-env", Created: old, Labels: map[string]string{"harness_env": "qa"}},
+	}
+	m, driver, destroyed, deleted := newReconcileTestManager(rows, vms)
+	driver.unlabeled = map[string]bool{"unlabeled": true}
+	metrics := &fakePurgerMetrics{}
+	m.metrics = metrics
+
+	var destroyedZones []string
+	destroy := driver.DestroyFunc
+	driver.DestroyFunc = func(ctx context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+		for _, inst := range instances {
+			destroyedZones = append(destroyedZones, inst.Zone)
+		}
+		return destroy(ctx, instances)
+	}
+
+	err := m.ReconcileInstances(context.Background(), ReconcileOptions{GracePeriod: time.Hour})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"pool_id": "pool1", LabelCreatedBy: identityCreatedBy, "harness_env": "prod"}, driver.labels)
+	assert.Equal(t, []string{"leaked"}, *destroyed)
+	assert.Equal(t, []string{"us-east1-b"}, destroyedZones)
+	assert.Equal(t, []string{"ghost"}, *deleted)
+	assert.Equal(t, []string{"ghost", "unlabeled"}, driver.looked, "only free rows are looked up")
+
+	sort.Slice(metrics.reconciled, func(i, j int) bool {
+		return metrics.reconciled[i].kind+metrics.reconciled[i].outcome < metrics.reconciled[j].kind+metrics.reconciled[j].outcome
+	})
+	assert.Equal(t, []reconciledInstanceRecord{
+		{"pool1", ReconcileKindGhostRow, ReconcileOutcomeRemoved},
+		{"pool1", ReconcileKindGhostRow, ReconcileOutcomeRetained},
+		{"pool1", ReconcileKindLeakedVM, ReconcileOutcomeRemoved},
+		{"pool1", ReconcileKindLeakedVM, ReconcileOutcomeRetained},
+	}, metrics.reconciled)
+}
+
+func TestManager_ReconcileInstances_DryRun(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	rows := []*types.Instance{
+		{ID: "known", State: types.StateCreated, Started: old.Unix()},
+		{ID: "ghost", State: types.StateCreated, Started: old.Unix()},
+	}
+	vms := []*CloudInstance{
+		{ID: "known", Created: old, Labels: map[string]string{"harness_env": "prod"}},
+		{ID: "leaked", Created: old, Labels: map[string]string{"harness_env": "prod"}},
+	}
+	m, _, destroyed, deleted := newReconcileTestManager(rows, vms)
+	metrics := &fakePurgerMetrics{}
+	m.metrics = metrics
+
+	err := m.ReconcileInstances(context.Background(), ReconcileOptions{GracePeriod: time.Hour, DryRun: true})
+	assert.NoError(t, err)
+	assert.Empty(t, *destroyed)
+	assert.Empty(t, *deleted)
+	assert.ElementsMatch(t, []reconciledInstanceRecord{
+		{"pool1", ReconcileKindLeakedVM, ReconcileOutcomeDryRun},
+		{"pool1", ReconcileKindGhostRow, ReconcileOutcomeDryRun},
+	}, metrics.reconciled)
+}
+
+func TestManager_ReconcileInstances_LookupErrorKeepsRows(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	rows := []*types.Instance{{ID: "row", State: types.StateCreated, Started: old.Unix()}}
+	vms := []*CloudInstance{{ID: "other", Created: time.Now(), Labels: map[string]string{"harness_env": "prod"}}}
+	m, driver, _, deleted := newReconcileTestManager(rows, vms)
+	driver.existsErr = assert.AnError
+
+	assert.NoError(t, m.ReconcileInstances(context.Background(), ReconcileOptions{GracePeriod: time.Hour}))
+	assert.Equal(t, []string{"row"}, driver.looked)
+	assert.Empty(t, *deleted)
+}
+
+func TestManager_ReconcileInstances_EmptyListingKeepsRows(t *testing.T) {
+	rows := []*types.Instance{{ID: "row", State: types.StateCreated, Started: time.Now().Add(-2 * time.Hour).Unix()}}
+	m, _, _, deleted := newReconcileTestManager(rows, nil)
+
+	assert.NoError(t, m.ReconcileInstances(context.Background(), ReconcileOptions{GracePeriod: time.Hour}))
+	assert.Empty(t, *deleted)
+}
+
+func TestManager_ReconcileInstances_ListError(t *testing.T) {
+	rows := []*types.Instance{{ID: "row", State: types.StateCreated, Started: time.Now().Add(-2 * time.Hour).Unix()}}
+	m, driver, _, deleted := newReconcileTestManager(rows, nil)
+	driver.err = assert.AnError
+
+	assert.ErrorIs(t, m.ReconcileInstances(context.Background(), ReconcileOptions{GracePeriod: time.Hour}), assert.AnError)
+	assert.Empty(t, *deleted)
+}
+
+func TestManager_ReconcileInstances_DestroyFailure(t *testing.T) {
+	vms := []*CloudInstance{{ID: "leaked", Created: time.Now().Add(-2 * time.Hour), Labels: map[string]string{"harness_env": "prod"}}}
+	m, driver, _, _ := newReconcileTestManager(nil, vms)
+	driver.DestroyFunc = func(context.Context, []*types.Instance) ([]*types.Instance, error) {
+		return nil, assert.AnError
+	}
+	metrics := &fakePurgerMetrics{}
+	m.metrics = metrics
+
+	assert.NoError(t, m.ReconcileInstances(context.Background(), ReconcileOptions{GracePeriod: time.Hour}))
+	assert.Equal(t, []reconciledInstanceRecord{{"pool1", ReconcileKindLeakedVM, ReconcileOutcomeFailed}}, metrics.reconciled)
+}
+
+func TestManager_ReconcileInstances_DriverCannotList(t *testing.T) {
+	m, _, _, deleted := newReconcileTestManager([]*types.Instance{{ID: "row", Started: 1}}, nil)
+	m.poolMap["pool1"].Driver = &flexibleMockDriver{driverName: "digitalocean"}
+
+	assert.NoError(t, m.ReconcileInstances(context.Background(), ReconcileOptions{}))
+	assert.Empty(t, *deleted)
+}
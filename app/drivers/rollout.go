@@ -0,0 +1,251 @@
+package drivers
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// Image rollout replacement outcomes.
+const (
+	ImageRolloutOutcomeReplaced = "replaced"
+	ImageRolloutOutcomeFailed   = "failed"
+)
+
+// StartImageRollout periodically replaces the free instances of every pool
+// that were created from an image that is no longer the image of their pool or
+// variant, for example after the pool file was updated by a bake. At most
+// maxUnavailable instances of a pool are being replaced at any time; instances
+// that are still provisioning count against it. Busy instances are never
+// touched, they go away when their stage is done. A zero interval or
+// maxUnavailable disables the rollout.
+func (m *Manager) StartImageRollout(ctx context.Context, interval time.Duration, maxUnavailable int) {
+	m.startImageRollout(ctx, interval, maxUnavailable, m.claimTerminating, m.replaceStale)
+}
+
+func (m *Manager) startImageRollout(ctx context.Context, interval time.Duration, maxUnavailable int, claim claimFunc, replenish replenishFunc) {
+	if interval <= 0 || maxUnavailable <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	logrus.Infof("Image rollout started. It will run every %.2f minutes replacing at most %d instances per pool", interval.Minutes(), maxUnavailable)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, pool := range m.poolMap {
+					func() {
+						defer func() {
+							if r := recover(); r != nil {
+								logrus.Errorf("PANIC %v\n%s", r, debug.Stack())
+							}
+						}()
+						if err := m.rolloutImages(ctx, pool, maxUnavailable, claim, replenish); err != nil {
+							logger.FromContext(ctx).WithError(err).WithField("pool", pool.Name).
+								Errorln("rollout: failed to roll out the pool image")
+						}
+					}()
+				}
+			}
+		}
+	}()
+}
+
+// rolloutImages replaces up to maxUnavailable stale free instances of a pool
+// owned by this runner.
+func (m *Manager) rolloutImages(ctx context.Context, pool *poolEntry, maxUnavailable int, claim claimFunc, replenish replenishFunc) error {
+	logr := logger.FromContext(ctx).
+		WithField("driver", pool.Driver.DriverName()).
+		WithField("pool", pool.Name)
+
+	query := types.QueryParams{RunnerName: m.runnerName}
+	_, free, _, provisioning, _, err := m.list(ctx, pool, &query)
+	if err != nil {
+		return err
+	}
+
+	images := map[string]string{}
+	var stale []*types.Instance
+	for _, inst := range free {
+		if !shouldReplenishInstance(inst) || inst.Image == "" {
+			continue
+		}
+		if image := m.currentImage(ctx, pool, inst, images); image != "" && image != inst.Image {
+			stale = append(stale, inst)
+		}
+	}
+	if m.metrics != nil {
+		m.metrics.RecordImageRolloutStale(pool.Name, len(stale))
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	total := len(stale)
+	budget := maxUnavailable - len(provisioning)
+	if budget <= 0 {
+		logr.WithField("stale", total).
+			WithField("provisioning", len(provisioning)).
+			Infoln("rollout: waiting for provisioning instances before replacing stale instances")
+		return nil
+	}
+	if len(stale) > budget {
+		stale = stale[:budget]
+	}
+
+	var claimed []*types.Instance
+	for _, inst := range stale {
+		c, claimErr := claim(ctx, pool, inst)
+		if claimErr != nil {
+			logr.WithError(claimErr).WithField("instance_id", inst.ID).
+				Warnln("rollout: failed to claim stale instance")
+			continue
+		}
+		if c != nil {
+			claimed = append(claimed, c)
+		}
+	}
+	if len(claimed) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(claimed))
+	for i, inst := range claimed {
+		ids[i] = inst.ID
+	}
+	logr.WithField("instance_ids", ids).
+		Infof("rollout: replacing %d stale instances", len(claimed))
+
+	failed, err := destroyByTenant(ctx, &pool.Pool, claimed)
+	if err != nil {
+		logr.WithError(err).Errorln("rollout: failed to destroy stale instances")
+	}
+	failedIDs := map[string]bool{}
+	for _, inst := range failed {
+		failedIDs[inst.ID] = true
+	}
+
+	var destroyed []*types.Instance
+	for _, inst := range claimed {
+		outcome := ImageRolloutOutcomeReplaced
+		if failedIDs[inst.ID] {
+			// the instance is most likely intact, hand it back and retry on the next run
+			outcome = ImageRolloutOutcomeFailed
+			inst.State = types.StateCreated
+			if uerr := m.instanceStore.Update(ctx, inst); uerr != nil {
+				logr.WithError(uerr).WithField("instance_id", inst.ID).
+					Errorln("rollout: failed to release stale instance")
+			}
+		} else if derr := m.instanceStore.Delete(ctx, inst.ID); derr != nil {
+			outcome = ImageRolloutOutcomeFailed
+			logr.WithError(derr).WithField("instance_id", inst.ID).
+				Errorln("rollout: failed to delete stale instance from store")
+		} else {
+			destroyed = append(destroyed, inst)
+		}
+		if m.metrics != nil {
+			m.metrics.RecordImageRolloutReplacement(pool.Name, outcome)
+		}
+	}
+
+	if len(destroyed) > 0 && replenish != nil {
+		replenish(ctx, pool, destroyed)
+	}
+	logr.WithField("replaced", len(destroyed)).
+		WithField("remaining", total-len(destroyed)).
+		Infoln("rollout: replaced stale instances")
+	return nil
+}
+
+// currentImage returns the fully qualified image new instances of the tenant
+// and variant of inst are created from, or empty when it is not known, for
+// example because the variant was removed from the pool. Images are cached in
+// images for the duration of a run, resolving them can call the cloud API.
+func (m *Manager) currentImage(ctx context.Context, pool *poolEntry, inst *types.Instance, images map[string]string) string {
+	var imageName string
+	if inst.VariantID != "" && inst.VariantID != defaultVariantID {
+		variant := findPoolVariant(pool.VariantsForTenant(inst.TenantID), inst.VariantID)
+		if variant == nil {
+			return ""
+		}
+		imageName = variant.ImageName
+	}
+
+	key := inst.TenantID + "/" + imageName
+	if image, ok := images[key]; ok {
+		return image
+	}
+	image, err := pool.DriverForTenant(inst.TenantID).GetFullyQualifiedImage(ctx, &types.VMImageConfig{ImageName: imageName})
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).
+			WithField("pool", pool.Name).
+			WithField("image", imageName).
+			Warnln("rollout: failed to resolve the pool image")
+		image = ""
+	}
+	images[key] = image
+	return image
+}
+
+// findPoolVariant returns the variant with the given ID, or nil.
+func findPoolVariant(variants []types.PoolVariant, variantID string) *types.PoolVariant {
+	for i := range variants {
+		if variants[i].VariantID == variantID {
+			return &variants[i]
+		}
+	}
+	return nil
+}
+
+// rolloutSetupParams returns the setup parameters of the replacement of inst,
+// taken from the current pool configuration rather than from inst so that the
+// replacement gets the current image.
+func rolloutSetupParams(pool *poolEntry, inst *types.Instance) *types.SetupInstanceParams {
+	if inst.VariantID != "" && inst.VariantID != defaultVariantID {
+		if variant := findPoolVariant(pool.VariantsForTenant(inst.TenantID), inst.VariantID); variant != nil {
+			params := deepCopySetupParams(&variant.SetupInstanceParams)
+			params.TenantID = inst.TenantID
+			return params
+		}
+	}
+	if inst.TenantID == "" {
+		return nil
+	}
+	return &types.SetupInstanceParams{TenantID: inst.TenantID}
+}
+
+// replaceStale creates a replacement from the current pool configuration for
+// every stale instance that was removed.
+func (m *Manager) replaceStale(ctx context.Context, pool *poolEntry, removed []*types.Instance) {
+	wg := &sync.WaitGroup{}
+	for _, inst := range removed {
+		params := rolloutSetupParams(pool, inst)
+		wg.Add(1)
+		go func(params *types.SetupInstanceParams) {
+			defer wg.Done()
+			replacement, err := m.setupInstanceWithHibernate(ctx, pool, m.GetTLSServerName(), "", params, vmImageConfigFromSetupParams(params), nil, nil, 0, nil)
+			if err != nil {
+				logger.FromContext(ctx).WithError(err).WithField("pool", pool.Name).
+					Errorln("rollout: failed to create replacement instance")
+				return
+			}
+			logger.FromContext(ctx).
+				WithField("pool", pool.Name).
+				WithField("id", replacement.ID).
+				WithField("image", replacement.Image).
+				Infoln("rollout: created replacement instance")
+		}(params)
+	}
+	wg.Wait()
+}
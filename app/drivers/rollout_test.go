@@ -0,0 +1,153 @@
+package drivers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// newRolloutTestManager returns a manager whose store holds the given instances
+// and whose pool creates instances from img-new, or variant-new for variant v1.
+func newRolloutTestManager(instances []*types.Instance, destroy func([]*types.Instance) ([]*types.Instance, error)) (m *Manager, pool *poolEntry, deleted *[]string) {
+	deleted = new([]string)
+	byID := map[string]*types.Instance{}
+	for _, inst := range instances {
+		byID[inst.ID] = inst
+	}
+	store := &mockInstanceStore{
+		ListFunc: func(context.Context, string, *types.QueryParams) ([]*types.Instance, error) {
+			return instances, nil
+		},
+		FindFunc: func(_ context.Context, id string) (*types.Instance, error) {
+			return byID[id], nil
+		},
+		FindAndClaimFunc: claimByID(instances...),
+		UpdateFunc:       func(context.Context, *types.Instance) error { return nil },
+		DeleteFunc: func(_ context.Context, id string) error {
+			*deleted = append(*deleted, id)
+			return nil
+		},
+	}
+	driver := &flexibleMockDriver{
+		driverName: "google",
+		GetFullyQualifiedImageFunc: func(_ context.Context, config *types.VMImageConfig) (string, error) {
+			if config.ImageName == "variant" {
+				return "variant-new", nil
+			}
+			return "img-new", nil
+		},
+		DestroyFunc: func(_ context.Context, instances []*types.Instance) ([]*types.Instance, error) {
+			return destroy(instances)
+		},
+	}
+	pool = &poolEntry{Pool: Pool{
+		Name:   "pool1",
+		Driver: driver,
+		PoolVariants: []types.PoolVariant{
+			{Pool: 1, SetupInstanceParams: types.SetupInstanceParams{VariantID: "v1", ImageName: "variant", Hibernate: true}},
+		},
+	}}
+	m = &Manager{
+		instanceStore: store,
+		poolMap:       map[string]*poolEntry{"pool1": pool},
+	}
+	return m, pool, deleted
+}
+
+func TestManager_RolloutImages(t *testing.T) {
+	busy := &types.Instance{ID: "busy", State: types.StateInUse, Image: "img-old", Source: types.InstanceSourcePool}
+	stale1 := &types.Instance{ID: "stale-1", State: types.StateCreated, Image: "img-old", Source: types.InstanceSourcePool}
+	stale2 := &types.Instance{ID: "stale-2", State: types.StateCreated, Image: "img-old", Source: types.InstanceSourcePool, IsHibernated: true}
+	stale3 := &types.Instance{ID: "stale-3", State: types.StateCreated, Image: "img-old", Source: types.InstanceSourcePool}
+	staleVariant := &types.Instance{ID: "stale-variant", State: types.StateCreated, Image: "variant-old", VariantID: "v1", Source: types.InstanceSourcePool}
+	current := &types.Instance{ID: "current", State: types.StateCreated, Image: "img-new", Source: types.InstanceSourcePool}
+	currentVariant := &types.Instance{ID: "current-variant", State: types.StateCreated, Image: "variant-new", VariantID: "v1", Source: types.InstanceSourcePool}
+	removedVariant := &types.Instance{ID: "removed-variant", State: types.StateCreated, Image: "img-old", VariantID: "gone", Source: types.InstanceSourcePool}
+	noImage := &types.Instance{ID: "no-image", State: types.StateCreated, Source: types.InstanceSourcePool}
+	instances := []*types.Instance{busy, stale1, staleVariant, stale2, stale3, current, currentVariant, removedVariant, noImage}
+
+	var destroyed []string
+	m, pool, deleted := newRolloutTestManager(instances, func(instances []*types.Instance) ([]*types.Instance, error) {
+		for _, inst := range instances {
+			destroyed = append(destroyed, inst.ID)
+		}
+		return nil, nil
+	})
+	metrics := &fakePurgerMetrics{}
+	m.metrics = metrics
+
+	var replenished []*types.Instance
+	err := m.rolloutImages(context.Background(), pool, 2, m.claimTerminating, func(_ context.Context, _ *poolEntry, removed []*types.Instance) {
+		replenished = append(replenished, removed...)
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"stale-1", "stale-variant"}, destroyed, "at most max unavailable instances are replaced at a time")
+	assert.Equal(t, []string{"stale-1", "stale-variant"}, *deleted)
+	assert.Len(t, replenished, 2)
+	assert.Equal(t, types.StateInUse, busy.State, "busy instances must not be touched")
+	assert.Equal(t, types.StateCreated, stale2.State)
+	assert.Equal(t, 4, metrics.rolloutStale["pool1"])
+	assert.Equal(t, []imageRolloutReplacementRecord{
+		{"pool1", ImageRolloutOutcomeReplaced},
+		{"pool1", ImageRolloutOutcomeReplaced},
+	}, metrics.rolloutReplaced)
+
+	// the replacement of a variant instance gets the current variant configuration
+	params := rolloutSetupParams(pool, staleVariant)
+	assert.Equal(t, "v1", params.VariantID)
+	assert.Equal(t, "variant", params.ImageName)
+	assert.True(t, params.Hibernate)
+	assert.Nil(t, rolloutSetupParams(pool, stale1))
+}
+
+func TestManager_RolloutImages_ProvisioningCountsAgainstBudget(t *testing.T) {
+	instances := []*types.Instance{
+		{ID: "provisioning", State: types.StateProvisioning, Image: "img-new", Source: types.InstanceSourcePool},
+		{ID: "stale", State: types.StateCreated, Image: "img-old", Source: types.InstanceSourcePool},
+	}
+	m, pool, deleted := newRolloutTestManager(instances, func([]*types.Instance) ([]*types.Instance, error) {
+		t.Fatal("no instance must be destroyed while the budget is used up")
+		return nil, nil
+	})
+
+	assert.NoError(t, m.rolloutImages(context.Background(), pool, 1, m.claimTerminating, nil))
+	assert.Empty(t, *deleted)
+	assert.Equal(t, types.StateCreated, instances[1].State)
+}
+
+func TestManager_RolloutImages_DestroyFailure(t *testing.T) {
+	stale := &types.Instance{ID: "stale", State: types.StateCreated, Image: "img-old", Source: types.InstanceSourcePool}
+	m, pool, deleted := newRolloutTestManager([]*types.Instance{stale}, func(instances []*types.Instance) ([]*types.Instance, error) {
+		return instances, assert.AnError
+	})
+	metrics := &fakePurgerMetrics{}
+	m.metrics = metrics
+
+	replenishCalled := false
+	err := m.rolloutImages(context.Background(), pool, 1, m.claimTerminating, func(context.Context, *poolEntry, []*types.Instance) {
+		replenishCalled = true
+	})
+	assert.NoError(t, err)
+	assert.False(t, replenishCalled)
+	assert.Empty(t, *deleted)
+	assert.Equal(t, types.StateCreated, stale.State, "an instance that could not be destroyed goes back to the pool")
+	assert.Equal(t, []imageRolloutReplacementRecord{{"pool1", ImageRolloutOutcomeFailed}}, metrics.rolloutReplaced)
+}
+
+func TestManager_RolloutImages_ClaimedInTheMeantime(t *testing.T) {
+	stale := &types.Instance{ID: "stale", State: types.StateCreated, Image: "img-old", Source: types.InstanceSourcePool}
+	m, pool, deleted := newRolloutTestManager([]*types.Instance{stale}, func([]*types.Instance) ([]*types.Instance, error) {
+		t.Fatal("an instance handed out in the meantime must not be destroyed")
+		return nil, nil
+	})
+	claim := func(context.Context, *poolEntry, *types.Instance) (*types.Instance, error) {
+		return nil, nil
+	}
+
+	assert.NoError(t, m.rolloutImages(context.Background(), pool, 1, claim, nil))
+	assert.Empty(t, *deleted)
+}
@@ -16,9 +16,15 @@ import (
 	"github.com/drone-runners/drone-runner-aws/app/drivers/ankabuild"
 	"github.com/drone-runners/drone-runner-aws/app/drivers/azure"
 	"github.com/drone-runners/drone-runner-aws/app/drivers/digitalocean"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/firecracker"
 	"github.com/drone-runners/drone-runner-aws/app/drivers/google"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/hetzner"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/kubevirt"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/libvirt"
 	"github.com/drone-runners/drone-runner-aws/app/drivers/nomad"
 	"github.com/drone-runners/drone-runner-aws/app/drivers/noop"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/openstack"
+	"github.com/drone-runners/drone-runner-aws/app/drivers/proxmox"
 	"github.com/drone-runners/drone-runner-aws/app/drivers/vmfusion"
 	"github.com/drone-runners/drone-runner-aws/app/oshelp"
 	"github.com/drone-runners/drone-runner-aws/command/config"
@@ -89,12 +95,12 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string, passwords types.P
 					if !ok {
 						return nil, fmt.Errorf("invalid amazon spec")
 					}
-					return buildAmazonDriver(amazonSpec, &instance, &passwords)
+					return buildAmazonDriver(amazonSpec, &instance, &passwords, metrics)
 				}); err != nil {
 					return nil, err
 				}
 			} else {
-				driver, err := buildAmazonDriver(a, &instance, &passwords)
+				driver, err := buildAmazonDriver(a, &instance, &passwords, metrics)
 				if err != nil {
 					return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
 				}
@@ -121,6 +127,7 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string, passwords types.P
 				azure.WithUserDataKey(az.UserDataKey, instance.Platform.OS),
 				azure.WithUserData(az.UserData, az.UserDataPath),
 				azure.WithSize(az.Size),
+				azure.WithSizeFallbacks(az.SizeFallbacks...),
 				azure.WithImage(az.Image.Publisher, az.Image.Offer, az.Image.SKU, az.Image.Version),
 				azure.WithUsername(az.Image.Username),
 				azure.WithPassword(az.Image.Password),
@@ -134,6 +141,9 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string, passwords types.P
 				azure.WithPrivateIP(az.Network.PrivateIP),
 				azure.WithVNet(az.Network.VNetName),
 				azure.WithSubnet(az.Network.SubnetName),
+				azure.WithHibernate(az.Hibernate),
+				azure.WithGallery(az.Gallery.ResourceGroup, az.Gallery.Name, az.Gallery.Image),
+				azure.WithMetrics(metrics),
 			)
 			if err != nil {
 				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
@@ -225,6 +235,201 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string, passwords types.P
 			pool := mapPool(&instance, runnerName)
 			pool.Driver = driver
 			pools = append(pools, pool)
+		case string(types.Hetzner):
+			var hz, ok = instance.Spec.(*config.Hetzner)
+			if !ok {
+				return nil, fmt.Errorf("%s pool parsing failed", instance.Name)
+			}
+			platform, platformErr := hetzner.SetPlatformDefaults(&instance.Platform)
+			if platformErr != nil {
+				return nil, platformErr
+			}
+			instance.Platform = *platform
+			driver, err := hetzner.New(
+				hetzner.WithToken(hz.Account.Token),
+				hetzner.WithEndpoint(hz.Account.Endpoint),
+				hetzner.WithLocation(hz.Account.Location),
+				hetzner.WithServerType(hz.ServerType),
+				hetzner.WithImage(hz.Image),
+				hetzner.WithLabels(hz.Labels),
+				hetzner.WithSSHKeys(hz.SSHKeys),
+				hetzner.WithFirewalls(hz.Firewalls),
+				hetzner.WithNetworks(hz.Networks),
+				hetzner.WithPrivateIP(hz.PrivateIP),
+				hetzner.WithHibernate(hz.Hibernate),
+				hetzner.WithUserData(hz.UserData, hz.UserDataPath),
+				hetzner.WithRootDirectory(hz.RootDirectory),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
+			}
+			pool := mapPool(&instance, runnerName)
+			pool.Driver = driver
+			pools = append(pools, pool)
+		case string(types.Libvirt):
+			var lv, ok = instance.Spec.(*config.Libvirt)
+			if !ok {
+				return nil, fmt.Errorf("%s pool parsing failed", instance.Name)
+			}
+			platform, platformErr := libvirt.SetPlatformDefaults(&instance.Platform)
+			if platformErr != nil {
+				return nil, platformErr
+			}
+			instance.Platform = *platform
+			driver, err := libvirt.New(
+				libvirt.WithURI(lv.URI),
+				libvirt.WithImage(lv.Image),
+				libvirt.WithStoragePath(lv.StoragePath),
+				libvirt.WithNetwork(lv.Network, lv.LeaseFile),
+				libvirt.WithCPU(lv.CPUs),
+				libvirt.WithMemory(lv.MemoryMB),
+				libvirt.WithDiskSize(lv.DiskSizeGB),
+				libvirt.WithHibernate(lv.Hibernate),
+				libvirt.WithUserData(lv.UserData, lv.UserDataPath),
+				libvirt.WithRootDirectory(lv.RootDirectory),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
+			}
+			pool := mapPool(&instance, runnerName)
+			pool.Driver = driver
+			pools = append(pools, pool)
+		case string(types.Firecracker):
+			var fc, ok = instance.Spec.(*config.Firecracker)
+			if !ok {
+				return nil, fmt.Errorf("%s pool parsing failed", instance.Name)
+			}
+			platform, platformErr := firecracker.SetPlatformDefaults(&instance.Platform)
+			if platformErr != nil {
+				return nil, platformErr
+			}
+			instance.Platform = *platform
+			driver, err := firecracker.New(
+				firecracker.WithBinary(fc.Binary),
+				firecracker.WithKernel(fc.Kernel, fc.KernelArgs),
+				firecracker.WithRootFS(fc.RootFS),
+				firecracker.WithStateDir(fc.StateDir),
+				firecracker.WithSubnet(fc.Subnet),
+				firecracker.WithCPU(fc.VCPUs),
+				firecracker.WithMemory(fc.MemoryMB),
+				firecracker.WithHibernate(fc.Hibernate),
+				firecracker.WithUserData(fc.UserData, fc.UserDataPath),
+				firecracker.WithRootDirectory(fc.RootDirectory),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
+			}
+			pool := mapPool(&instance, runnerName)
+			pool.Driver = driver
+			pools = append(pools, pool)
+		case string(types.Proxmox):
+			var px, ok = instance.Spec.(*config.Proxmox)
+			if !ok {
+				return nil, fmt.Errorf("%s pool parsing failed", instance.Name)
+			}
+			platform, platformErr := proxmox.SetPlatformDefaults(&instance.Platform)
+			if platformErr != nil {
+				return nil, platformErr
+			}
+			instance.Platform = *platform
+			driver, err := proxmox.New(
+				proxmox.WithEndpoint(px.Account.Endpoint),
+				proxmox.WithToken(px.Account.Token),
+				proxmox.WithInsecure(px.Account.Insecure),
+				proxmox.WithNode(px.Account.Node),
+				proxmox.WithTemplate(px.Template),
+				proxmox.WithResourcePool(px.ResourcePool),
+				proxmox.WithSnippets(px.SnippetStorage, px.SnippetDir),
+				proxmox.WithCPU(px.Cores),
+				proxmox.WithMemory(px.MemoryMB),
+				proxmox.WithTags(px.Tags),
+				proxmox.WithHibernate(px.Hibernate),
+				proxmox.WithUserData(px.UserData, px.UserDataPath),
+				proxmox.WithRootDirectory(px.RootDirectory),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
+			}
+			pool := mapPool(&instance, runnerName)
+			pool.Driver = driver
+			pools = append(pools, pool)
+		case string(types.OpenStack):
+			var ops, ok = instance.Spec.(*config.OpenStack)
+			if !ok {
+				return nil, fmt.Errorf("%s pool parsing failed", instance.Name)
+			}
+			platform, platformErr := openstack.SetPlatformDefaults(&instance.Platform)
+			if platformErr != nil {
+				return nil, platformErr
+			}
+			instance.Platform = *platform
+			driver, err := openstack.New(
+				openstack.WithCredentials(&openstack.Credentials{
+					AuthURL:                     ops.Account.AuthURL,
+					Username:                    ops.Account.Username,
+					Password:                    ops.Account.Password,
+					UserDomain:                  ops.Account.UserDomain,
+					ProjectName:                 ops.Account.ProjectName,
+					ProjectID:                   ops.Account.ProjectID,
+					ProjectDomain:               ops.Account.ProjectDomain,
+					ApplicationCredentialID:     ops.Account.ApplicationCredentialID,
+					ApplicationCredentialSecret: ops.Account.ApplicationCredentialSecret,
+					Region:                      ops.Account.Region,
+					Interface:                   ops.Account.Interface,
+				}),
+				openstack.WithFlavor(ops.Flavor),
+				openstack.WithImage(ops.Image),
+				openstack.WithAvailabilityZone(ops.AvailabilityZone),
+				openstack.WithNetwork(ops.Network),
+				openstack.WithFloatingNetwork(ops.FloatingNetwork),
+				openstack.WithSecurityGroups(ops.SecurityGroups...),
+				openstack.WithKeyPair(ops.KeyPair),
+				openstack.WithMetadata(ops.Metadata),
+				openstack.WithHibernate(ops.Hibernate),
+				openstack.WithUserData(ops.UserData, ops.UserDataPath),
+				openstack.WithRootDirectory(ops.RootDirectory),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
+			}
+			pool := mapPool(&instance, runnerName)
+			pool.Driver = driver
+			pools = append(pools, pool)
+		case string(types.KubeVirt):
+			var kv, ok = instance.Spec.(*config.KubeVirt)
+			if !ok {
+				return nil, fmt.Errorf("%s pool parsing failed", instance.Name)
+			}
+			platform, platformErr := kubevirt.SetPlatformDefaults(&instance.Platform)
+			if platformErr != nil {
+				return nil, platformErr
+			}
+			instance.Platform = *platform
+			driver, err := kubevirt.New(
+				kubevirt.WithConnection(&kubevirt.Connection{
+					Server:    kv.Account.Server,
+					Token:     kv.Account.Token,
+					TokenFile: kv.Account.TokenFile,
+					CAFile:    kv.Account.CAFile,
+					Insecure:  kv.Account.Insecure,
+				}),
+				kubevirt.WithNamespace(kv.Namespace),
+				kubevirt.WithImage(kv.Image),
+				kubevirt.WithSourcePVC(kv.SourcePVC.Namespace, kv.SourcePVC.Name, kv.SourcePVC.Size, kv.SourcePVC.StorageClass),
+				kubevirt.WithCPU(kv.CPU),
+				kubevirt.WithMemory(kv.Memory),
+				kubevirt.WithNodeSelector(kv.NodeSelector),
+				kubevirt.WithLabels(kv.Labels),
+				kubevirt.WithHibernate(kv.Hibernate),
+				kubevirt.WithUserData(kv.UserData, kv.UserDataPath),
+				kubevirt.WithRootDirectory(kv.RootDirectory),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
+			}
+			pool := mapPool(&instance, runnerName)
+			pool.Driver = driver
+			pools = append(pools, pool)
 		case string(types.AnkaBuild):
 			var ankaBuild, ok = instance.Spec.(*config.AnkaBuild)
 			if ankaBuild.AuthToken == "" && passwords.AnkaToken != "" {
@@ -266,6 +471,7 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string, passwords types.P
 			driver, err := noop.New(
 				noop.WithRootDirectory(),
 				noop.WithHibernate(noopBuild.Hibernate),
+				noop.WithSimulation(noopBuild.Simulation),
 			)
 			if err != nil {
 				return nil, fmt.Errorf("unable to create %s pool '%s': %v", instance.Type, instance.Name, err)
@@ -306,6 +512,8 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string, passwords types.P
 				nomad.WithEnablePinning(nomadConfig.VM.EnablePinning),
 				nomad.WithImage(nomadConfig.VM.Image),
 				nomad.WithNoop(nomadConfig.VM.Noop),
+				nomad.WithHibernate(nomadConfig.VM.Hibernate),
+				nomad.WithPlacement(nomadConfig.Placement),
 				nomad.WithResource(nomadConfig.VM.Resource),
 				nomad.WithUserData(nomadConfig.VM.UserData, nomadConfig.VM.UserDataPath),
 				nomad.WithVirtualizerEngine(virtualizerEngine),
@@ -322,13 +530,44 @@ func ProcessPool(poolFile *config.PoolFile, runnerName string, passwords types.P
 		default:
 			return nil, fmt.Errorf("unknown instance tip %s", instance.Type)
 		}
+		if err := applyRateLimit(&pools[len(pools)-1], &instance, metrics); err != nil {
+			return nil, err
+		}
 	}
 	return pools, nil
 }
 
+// applyRateLimit wraps the drivers of the pool with the pool's rate limits. Pool building,
+// warm pool replenishment and the purger all go through pool.Driver or the tenant drivers,
+// so they share the same buckets and in-flight limit.
+func applyRateLimit(pool *drivers.Pool, instance *config.Instance, metrics *metric.Metrics) error {
+	if instance.RateLimit == nil {
+		return nil
+	}
+	limits := drivers.RateLimits{
+		MutatingRate:  instance.RateLimit.Mutating.Rate,
+		MutatingBurst: instance.RateLimit.Mutating.Burst,
+		ReadRate:      instance.RateLimit.Read.Rate,
+		ReadBurst:     instance.RateLimit.Read.Burst,
+		MaxInFlight:   instance.RateLimit.MaxInFlight,
+	}
+	if err := limits.Validate(); err != nil {
+		return fmt.Errorf("pool %q: %w", instance.Name, err)
+	}
+	if len(pool.TenantDrivers) == 0 {
+		pool.Driver = drivers.NewRateLimitedDriver(pool.Driver, pool.Name, limits, metrics)
+		return nil
+	}
+	for id, driver := range pool.TenantDrivers {
+		pool.TenantDrivers[id] = drivers.NewRateLimitedDriver(driver, pool.Name, limits, metrics)
+	}
+	setDefaultTenantDriver(pool)
+	return nil
+}
+
 // buildAmazonDriver constructs an Amazon driver from a (fully-resolved) Amazon spec, applying
 // credential backfill from passwords when the spec omits them.
-func buildAmazonDriver(a *config.Amazon, instance *config.Instance, passwords *types.Passwords) (drivers.Driver, error) {
+func buildAmazonDriver(a *config.Amazon, instance *config.Instance, passwords *types.Passwords, metrics *metric.Metrics) (drivers.Driver, error) {
 	if a.Account.AccessKeyID == "" && passwords.AWSAccessKeyID != "" {
 		a.Account.AccessKeyID = passwords.AWSAccessKeyID
 	}
@@ -355,6 +594,7 @@ func buildAmazonDriver(a *config.Amazon, instance *config.Instance, passwords *t
 		amazon.WithSecurityGroup(a.Network.SecurityGroups...),
 		amazon.WithSize(a.Size, instance.Platform.Arch),
 		amazon.WithSizeAlt(a.SizeAlt),
+		amazon.WithSizeFallbacks(a.SizeFallbacks...),
 		amazon.WithSubnet(a.Network.SubnetID),
 		amazon.WithUserData(a.UserData, a.UserDataPath),
 		amazon.WithVolumeSize(a.Disk.Size),
@@ -373,6 +613,7 @@ func buildAmazonDriver(a *config.Amazon, instance *config.Instance, passwords *t
 			return a.ZoneDetails
 		}()),
 		amazon.WithEnableC4D(a.EnableC4D),
+		amazon.WithMetrics(metrics),
 	)
 }
 
@@ -384,6 +625,7 @@ func buildGoogleDriver(g *config.Google, instance *config.Instance, metrics *met
 		google.WithDiskType(g.Disk.Type),
 		google.WithMachineImage(g.Image),
 		google.WithSize(g.MachineType),
+		google.WithMachineTypeFallbacks(g.MachineTypeFallbacks...),
 		google.WithNetwork(g.Network),
 		google.WithSubnetwork(g.Subnetwork),
 		google.WithPrivateIP(g.PrivateIP),
@@ -397,6 +639,7 @@ func buildGoogleDriver(g *config.Google, instance *config.Instance, metrics *met
 		google.WithZones(g.Zone...),
 		google.WithUserDataKey(g.UserDataKey, instance.Platform.OS),
 		google.WithHibernate(g.Hibernate),
+		google.WithProvisioningModel(g.ProvisioningModel),
 		google.WithLabels(map[string]string{
 			instance.Name: instance.Name,
 		}),
@@ -0,0 +1,90 @@
+package poolfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+const rateLimitedPoolYAML = `
+version: "1"
+instances:
+  - name: linux-amd64-aws
+    type: amazon
+    pool: 1
+    limit: 20
+    rate_limit:
+      mutating:
+        rate: 5
+        burst: 10
+      read:
+        rate: 20
+      max_in_flight: 50
+    spec:
+      account:
+        region: us-east-1
+      ami: ami-base
+    tenants:
+      - ids: [acctA]
+        spec:
+          ami: ami-custom
+`
+
+func TestProcessPool_RateLimit(t *testing.T) {
+	pf, err := config.Parse(strings.NewReader(rateLimitedPoolYAML))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	rl := pf.Instances[0].RateLimit
+	if rl == nil {
+		t.Fatalf("expected the rate limit to be parsed")
+	}
+	wantEqual(t, "mutating rate", rl.Mutating.Rate, 5)
+	wantEqual(t, "mutating burst", rl.Mutating.Burst, 10)
+	wantEqual(t, "read rate", rl.Read.Rate, 20)
+	wantEqual(t, "max in flight", rl.MaxInFlight, 50)
+
+	pools, err := ProcessPool(pf, "runner", types.Passwords{}, nil)
+	if err != nil {
+		t.Fatalf("ProcessPool: %v", err)
+	}
+	p := pools[0]
+	for id, driver := range p.TenantDrivers {
+		if _, ok := driver.(drivers.DriverWrapper); !ok {
+			t.Errorf("expected the driver of tenant %q to be rate limited", id)
+		}
+	}
+	// the default driver shares the limits of the default tenant
+	if p.Driver != p.TenantDrivers[types.DefaultTenantID] {
+		t.Errorf("expected the pool driver to be the default tenant driver")
+	}
+}
+
+func TestProcessPool_RateLimitNotSet(t *testing.T) {
+	yaml := strings.Replace(rateLimitedPoolYAML, "rate_limit:", "unused:", 1)
+	pf, err := config.Parse(strings.NewReader(yaml))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	pools, err := ProcessPool(pf, "runner", types.Passwords{}, nil)
+	if err != nil {
+		t.Fatalf("ProcessPool: %v", err)
+	}
+	if _, ok := pools[0].Driver.(drivers.DriverWrapper); ok {
+		t.Errorf("expected the driver not to be wrapped without a rate limit")
+	}
+}
+
+func TestProcessPool_RateLimitInvalid(t *testing.T) {
+	yaml := strings.Replace(rateLimitedPoolYAML, "rate: 20", "rate: -1", 1)
+	pf, err := config.Parse(strings.NewReader(yaml))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if _, err := ProcessPool(pf, "runner", types.Passwords{}, nil); err == nil || !strings.Contains(err.Error(), "must not be negative") {
+		t.Errorf("expected a negative rate to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package poolfile
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestProcessPool_NoopSimulation(t *testing.T) {
+	yaml := `
+version: "1"
+instances:
+  - name: simulated
+    type: noop
+    pool: 2
+    limit: 10
+    spec:
+      hibernate: true
+      simulation:
+        seed: 7
+        create:
+          latency:
+            distribution: uniform
+            min: 1ms
+            max: 5ms
+        hibernate:
+          failure_rate: 1
+        zones: [zone-a, zone-b]
+        stockouts:
+          - zone: zone-a
+        capacity_reservation: true
+`
+	pf, err := config.Parse(strings.NewReader(yaml))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	pools, err := ProcessPool(pf, "runner", types.Passwords{}, nil)
+	if err != nil {
+		t.Fatalf("ProcessPool: %v", err)
+	}
+	driver := pools[0].Driver
+
+	instance, err := driver.Create(context.Background(), &types.InstanceCreateOpts{PoolName: "simulated"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	wantEqual(t, "zone", instance.Zone, "zone-b")
+	if err = driver.Hibernate(context.Background(), instance.ID, "simulated", instance.Zone); err == nil {
+		t.Error("expected the simulated hibernate to fail")
+	}
+	if _, err = driver.ReserveCapacity(context.Background(), &types.InstanceCreateOpts{PoolName: "simulated"}); err != nil {
+		t.Errorf("ReserveCapacity: %v", err)
+	}
+}
+
+func TestProcessPool_NoopSimulationInvalid(t *testing.T) {
+	yaml := `
+version: "1"
+instances:
+  - name: simulated
+    type: noop
+    spec:
+      simulation:
+        destroy:
+          failure_rate: 2
+`
+	pf, err := config.Parse(strings.NewReader(yaml))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if _, err = ProcessPool(pf, "runner", types.Passwords{}, nil); err == nil || !strings.Contains(err.Error(), "failure rate") {
+		t.Errorf("expected an invalid failure rate to be rejected, got %v", err)
+	}
+}
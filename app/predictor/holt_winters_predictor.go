@@ -0,0 +1,288 @@
+package predictor
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+const (
+	secondsPerDay  = 24 * 3600
+	secondsPerWeek = 7 * secondsPerDay
+)
+
+// HoltWintersPredictor implements the Predictor interface using triple exponential
+// smoothing (additive Holt-Winters) with two seasonal cycles:
+// - Level and trend for the overall load and how it grows or shrinks
+// - Daily seasonality for the intra-day pattern (business hours vs nights)
+// - Weekly seasonality for the day-of-week pattern (weekdays vs weekends)
+type HoltWintersPredictor struct {
+	historyStore store.UtilizationHistoryStore
+	config       HoltWintersConfig
+}
+
+// HoltWintersConfig contains configuration parameters for the Holt-Winters predictor.
+type HoltWintersConfig struct {
+	// Alpha is the smoothing factor of the level (0.0 to 1.0). Default: 0.2
+	Alpha float64
+
+	// Beta is the smoothing factor of the trend (0.0 to 1.0). Default: 0.01
+	Beta float64
+
+	// DailyGamma is the smoothing factor of the daily seasonality (0.0 to 1.0). Default: 0.2
+	DailyGamma float64
+
+	// WeeklyGamma is the smoothing factor of the weekly seasonality (0.0 to 1.0). Default: 0.3
+	WeeklyGamma float64
+
+	// Bucket is the resolution the history is smoothed at, each bucket holding the peak
+	// utilization recorded in it. It must divide a day. Default: 30 minutes
+	Bucket time.Duration
+
+	// LookbackWeeks is the number of weeks of history to smooth. Two weeks or more
+	// initialize the weekly seasonality and the trend. Default: 3
+	LookbackWeeks int
+
+	// MinInstances is the minimum number of instances to recommend.
+	// Default: 0
+	MinInstances int
+}
+
+// DefaultHoltWintersConfig returns a HoltWintersConfig with sensible defaults.
+func DefaultHoltWintersConfig() HoltWintersConfig {
+	return HoltWintersConfig{
+		Alpha:         0.2,
+		Beta:          0.01,
+		DailyGamma:    0.2,
+		WeeklyGamma:   0.3,
+		Bucket:        30 * time.Minute,
+		LookbackWeeks: 3,
+		MinInstances:  0,
+	}
+}
+
+// NewHoltWintersPredictor creates a new predictor with the given history store and config.
+// A bucket that does not divide a day, or a lookback of less than a week, is replaced by its default.
+func NewHoltWintersPredictor(historyStore store.UtilizationHistoryStore, config HoltWintersConfig) *HoltWintersPredictor { //nolint:gocritic
+	defaults := DefaultHoltWintersConfig()
+	if config.Bucket < time.Minute || (secondsPerDay*time.Second)%config.Bucket != 0 {
+		config.Bucket = defaults.Bucket
+	}
+	if config.LookbackWeeks < 1 {
+		config.LookbackWeeks = defaults.LookbackWeeks
+	}
+	return &HoltWintersPredictor{
+		historyStore: historyStore,
+		config:       config,
+	}
+}
+
+// NewHoltWintersPredictorWithDefaults creates a new predictor with default configuration.
+func NewHoltWintersPredictorWithDefaults(historyStore store.UtilizationHistoryStore) *HoltWintersPredictor {
+	return NewHoltWintersPredictor(historyStore, DefaultHoltWintersConfig())
+}
+
+// Name returns the name of this predictor implementation.
+func (p *HoltWintersPredictor) Name() string {
+	return "holt-winters-predictor"
+}
+
+// Predict smooths the history up to the start of the window and returns the peak of
+// the forecasts of the buckets the window covers.
+func (p *HoltWintersPredictor) Predict(ctx context.Context, input *PredictionInput) (*PredictionResult, error) {
+	bucketSecs := int64(p.config.Bucket / time.Second)
+
+	// Fetch the whole lookback in a single batch query, up to but excluding the window
+	lookback := int64(p.config.LookbackWeeks) * secondsPerWeek
+	batchResults, err := p.historyStore.GetUtilizationHistoryBatch(
+		ctx,
+		input.PoolName,
+		input.TenantID,
+		input.VariantID,
+		input.ImageName,
+		[]store.TimeRange{{StartTime: input.StartTimestamp - lookback, EndTime: input.StartTimestamp - 1}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []types.UtilizationRecord
+	for _, rs := range batchResults {
+		records = append(records, rs...)
+	}
+
+	baseValue := 0.0
+	if series, first := p.bucketPeaks(records, bucketSecs); len(series) > 0 {
+		model := p.fit(series, first)
+		last := first + int64(len(series)) - 1
+
+		startBucket := input.StartTimestamp / bucketSecs
+		endBucket := startBucket
+		if input.EndTimestamp > input.StartTimestamp {
+			endBucket = (input.EndTimestamp - 1) / bucketSecs
+		}
+		for k := startBucket; k <= endBucket; k++ {
+			baseValue = math.Max(baseValue, model.forecast(k, k-last))
+		}
+	}
+
+	// Compute the predicted instance count and apply MinInstances floor.
+	// The scaler applies any over-provisioning buffer (ScalePercent).
+	predictedInstances := int(math.Ceil(baseValue))
+	if predictedInstances < p.config.MinInstances {
+		predictedInstances = p.config.MinInstances
+	}
+
+	return &PredictionResult{
+		PredictedInstances: predictedInstances,
+	}, nil
+}
+
+// bucketPeaks returns the peak utilization of every bucket from the first to the last
+// bucket with a record, and the index of the first bucket. The utilization tracker only
+// records busy instances, so buckets without a record in between had none. The buckets
+// after the last record are left out rather than taken as idle, they may still be ahead.
+func (p *HoltWintersPredictor) bucketPeaks(records []types.UtilizationRecord, bucketSecs int64) (series []float64, first int64) {
+	if len(records) == 0 {
+		return nil, 0
+	}
+
+	first, last := records[0].RecordedAt/bucketSecs, records[0].RecordedAt/bucketSecs
+	for _, record := range records {
+		first = min(first, record.RecordedAt/bucketSecs)
+		last = max(last, record.RecordedAt/bucketSecs)
+	}
+
+	series = make([]float64, last-first+1)
+	for _, record := range records {
+		i := record.RecordedAt/bucketSecs - first
+		series[i] = math.Max(series[i], float64(record.InUseInstances))
+	}
+	return series, first
+}
+
+// holtWintersModel is the state of the smoothing after the last bucket of the history.
+// Seasonal components are indexed by the bucket index modulo the season length, so
+// they line up with the time of day and the day of the week.
+type holtWintersModel struct {
+	level, trend  float64
+	daily, weekly []float64
+}
+
+// forecast returns the forecast of bucket k, h buckets after the last one smoothed.
+func (m *holtWintersModel) forecast(k, h int64) float64 {
+	if h < 0 {
+		h = 0
+	}
+	value := m.level + float64(h)*m.trend +
+		m.daily[k%int64(len(m.daily))] + m.weekly[k%int64(len(m.weekly))]
+	return math.Max(value, 0)
+}
+
+// fit initializes the model from the start of the series, then smooths the whole series.
+func (p *HoltWintersPredictor) fit(series []float64, first int64) *holtWintersModel {
+	bucketSecs := int64(p.config.Bucket / time.Second)
+	dayLen := int(secondsPerDay / bucketSecs)
+	weekLen := int(secondsPerWeek / bucketSecs)
+
+	m := &holtWintersModel{
+		daily:  make([]float64, dayLen),
+		weekly: make([]float64, weekLen),
+	}
+	p.initialize(m, series, first, dayLen, weekLen)
+
+	// Start one step back so the first update sees the initial level as its prior
+	m.level -= m.trend
+	for t, y := range series {
+		k := first + int64(t)
+		di, wi := int(k%int64(dayLen)), int(k%int64(weekLen))
+
+		prevLevel := m.level
+		m.level = p.config.Alpha*(y-m.daily[di]-m.weekly[wi]) + (1-p.config.Alpha)*(m.level+m.trend)
+		m.trend = p.config.Beta*(m.level-prevLevel) + (1-p.config.Beta)*m.trend
+		prevDaily := m.daily[di]
+		m.daily[di] = p.config.DailyGamma*(y-m.level-m.weekly[wi]) + (1-p.config.DailyGamma)*m.daily[di]
+		m.weekly[wi] = p.config.WeeklyGamma*(y-m.level-prevDaily) + (1-p.config.WeeklyGamma)*m.weekly[wi]
+	}
+	return m
+}
+
+// initialize sets the initial level, trend and seasonality from the first two weeks of
+// the series. With less than two weeks the trend starts flat, and with less than a
+// week the weekly seasonality starts flat, smoothing then learns them.
+func (p *HoltWintersPredictor) initialize(m *holtWintersModel, series []float64, first int64, dayLen, weekLen int) {
+	// The span the initial components are averaged over: whole weeks, else whole days
+	periodLen := weekLen
+	periods := min(len(series)/weekLen, 2) //nolint:mnd
+	if periods == 0 {
+		periodLen = dayLen
+		periods = len(series) / dayLen
+	}
+	if periods == 0 {
+		m.level = mean(series)
+		return
+	}
+
+	periodMeans := make([]float64, periods)
+	for i := range periodMeans {
+		periodMeans[i] = mean(series[i*periodLen : (i+1)*periodLen])
+	}
+	m.level = periodMeans[0]
+	if periods > 1 {
+		m.trend = (periodMeans[periods-1] - periodMeans[0]) / float64((periods-1)*periodLen)
+	}
+
+	// Daily: the deviation of each bucket from the mean of its day
+	span := series[:periods*periodLen]
+	days := len(span) / dayLen
+	dailyCounts := make([]int, dayLen)
+	for d := 0; d < days; d++ {
+		dayMean := mean(span[d*dayLen : (d+1)*dayLen])
+		for j := 0; j < dayLen; j++ {
+			t := d*dayLen + j
+			di := int((first + int64(t)) % int64(dayLen))
+			m.daily[di] += span[t] - dayMean
+			dailyCounts[di]++
+		}
+	}
+	averageInPlace(m.daily, dailyCounts)
+
+	if periodLen != weekLen {
+		return
+	}
+
+	// Weekly: what is left of the deviation of each bucket from the mean of its week
+	weeklyCounts := make([]int, weekLen)
+	for w := 0; w < periods; w++ {
+		for j := 0; j < weekLen; j++ {
+			t := w*weekLen + j
+			k := first + int64(t)
+			wi := int(k % int64(weekLen))
+			m.weekly[wi] += span[t] - periodMeans[w] - m.daily[k%int64(dayLen)]
+			weeklyCounts[wi]++
+		}
+	}
+	averageInPlace(m.weekly, weeklyCounts)
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func averageInPlace(sums []float64, counts []int) {
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= float64(counts[i])
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package predictor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// predictHoltWinters returns the Holt-Winters prediction of a 15-minute window starting at start.
+func predictHoltWinters(t *testing.T, predictor *HoltWintersPredictor, start time.Time) int {
+	t.Helper()
+	result, err := predictor.Predict(context.Background(), &PredictionInput{
+		PoolName:       "test-pool",
+		VariantID:      "variant-1",
+		StartTimestamp: start.Unix(),
+		EndTimestamp:   start.Add(15 * time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("prediction for %s failed: %v", start.Format(time.RFC3339), err)
+	}
+	return result.PredictedInstances
+}
+
+func TestHoltWintersPredictor_Name(t *testing.T) {
+	predictor := NewHoltWintersPredictorWithDefaults(&MockHistoryStore{})
+	expected := "holt-winters-predictor"
+	if predictor.Name() != expected {
+		t.Errorf("expected name %q, got %q", expected, predictor.Name())
+	}
+}
+
+func TestHoltWintersPredictor_Predict_EmptyHistory(t *testing.T) {
+	config := DefaultHoltWintersConfig()
+	config.MinInstances = 2
+	predictor := NewHoltWintersPredictor(&MockHistoryStore{}, config)
+
+	if got := predictHoltWinters(t, predictor, time.Date(2024, 1, 24, 10, 0, 0, 0, time.UTC)); got != 2 {
+		t.Errorf("expected minimum instances 2, got %d", got)
+	}
+}
+
+func TestNewHoltWintersPredictor_InvalidConfig(t *testing.T) {
+	config := DefaultHoltWintersConfig()
+	config.Bucket = 7 * time.Minute // does not divide a day
+	config.LookbackWeeks = 0
+
+	predictor := NewHoltWintersPredictor(&MockHistoryStore{}, config)
+	if predictor.config.Bucket != 30*time.Minute {
+		t.Errorf("expected the default bucket, got %s", predictor.config.Bucket)
+	}
+	if predictor.config.LookbackWeeks != 3 {
+		t.Errorf("expected the default lookback, got %d", predictor.config.LookbackWeeks)
+	}
+}
+
+// TestHoltWintersPredictor_IntraDaySeasonality tests that the daily seasonality forecasts
+// business hours and nights hours ahead of the history, which the EMA predictor cannot.
+func TestHoltWintersPredictor_IntraDaySeasonality(t *testing.T) {
+	// Wednesday, January 24, 2024 at midnight UTC: the history ends at night
+	referenceTime := time.Date(2024, 1, 24, 0, 0, 0, 0, time.UTC)
+	store := &MockHistoryStore{records: GenerateMockHistoryData("test-pool", "variant-1", referenceTime)} //nolint:gocritic
+	predictor := NewHoltWintersPredictorWithDefaults(store)
+
+	night := predictHoltWinters(t, predictor, referenceTime.Add(3*time.Hour))
+	peak := predictHoltWinters(t, predictor, referenceTime.Add(12*time.Hour))
+	t.Logf("Wednesday 03:00: %d instances, 12:00: %d instances", night, peak)
+
+	// Mock data: nights 3 (+2 variance), core business hours 25 (+2 variance)
+	if night < 3 || night > 8 {
+		t.Errorf("expected a night prediction between 3 and 8, got %d", night)
+	}
+	if peak < 24 || peak > 31 {
+		t.Errorf("expected a business hours prediction between 24 and 31, got %d", peak)
+	}
+}
+
+// TestHoltWintersPredictor_15MinWindowPrediction tests consecutive 15-minute windows
+// in business hours using 3 weeks of history recorded every 2 minutes.
+func TestHoltWintersPredictor_15MinWindowPrediction(t *testing.T) {
+	referenceTime := time.Date(2024, 1, 24, 10, 0, 0, 0, time.UTC)
+	store := &MockHistoryStore{records: GenerateMockHistoryData("test-pool", "variant-1", referenceTime)} //nolint:gocritic
+	predictor := NewHoltWintersPredictorWithDefaults(store)
+
+	for i := 0; i < 8; i++ {
+		windowStart := referenceTime.Add(time.Duration(i) * 15 * time.Minute)
+		got := predictHoltWinters(t, predictor, windowStart)
+		if got < 24 || got > 31 {
+			t.Errorf("window %s: expected a prediction between 24 and 31, got %d", windowStart.Format("15:04"), got)
+		}
+	}
+}
+
+// TestHoltWintersPredictor_WeekdayVsWeekend tests that the weekly seasonality
+// predicts a lighter Saturday than a Wednesday at the same time of day.
+func TestHoltWintersPredictor_WeekdayVsWeekend(t *testing.T) {
+	wednesdayRef := time.Date(2024, 1, 24, 10, 0, 0, 0, time.UTC)
+	saturdayRef := time.Date(2024, 1, 27, 10, 0, 0, 0, time.UTC)
+
+	weekday := predictHoltWinters(t,
+		NewHoltWintersPredictorWithDefaults(&MockHistoryStore{records: GenerateMockHistoryData("test-pool", "variant-1", wednesdayRef)}),
+		wednesdayRef)
+	weekend := predictHoltWinters(t,
+		NewHoltWintersPredictorWithDefaults(&MockHistoryStore{records: GenerateMockHistoryData("test-pool", "variant-1", saturdayRef)}),
+		saturdayRef)
+	t.Logf("Weekday (Wednesday 10:00-10:15): %d instances, weekend (Saturday 10:00-10:15): %d instances", weekday, weekend)
+
+	// Mock data: weekend business hours 8 (+2 variance)
+	if weekend < 8 || weekend > 13 {
+		t.Errorf("expected a weekend prediction between 8 and 13, got %d", weekend)
+	}
+	if weekend >= weekday {
+		t.Errorf("expected the weekend prediction %d below the weekday prediction %d", weekend, weekday)
+	}
+}
+
+// TestHoltWintersPredictor_15MinWindowWithSpikes tests that the weekly seasonality
+// forecasts the spikes recurring on Tuesdays.
+func TestHoltWintersPredictor_15MinWindowWithSpikes(t *testing.T) {
+	// Tuesday, January 23, 2024 at 10:00 AM UTC - a day with spikes
+	referenceTime := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+
+	stable := predictHoltWinters(t,
+		NewHoltWintersPredictorWithDefaults(&MockHistoryStore{records: GenerateMockHistoryData("test-pool", "variant-1", referenceTime)}),
+		referenceTime)
+	spiky := NewHoltWintersPredictorWithDefaults(
+		&MockHistoryStore{records: GenerateMockHistoryDataWithSpikes("test-pool", "variant-1", referenceTime)})
+
+	// Spikes last the first half hour of 10:00, 14:00 and 16:00
+	spike := predictHoltWinters(t, spiky, referenceTime)
+	afterSpike := predictHoltWinters(t, spiky, referenceTime.Add(time.Hour))
+	t.Logf("Tuesday 10:00: %d instances (%d without spikes), 11:00: %d instances", spike, stable, afterSpike)
+
+	if spike < stable+10 {
+		t.Errorf("expected the spike prediction %d to be at least 10 above the stable prediction %d", spike, stable)
+	}
+	if afterSpike >= spike {
+		t.Errorf("expected the prediction after the spike %d below the spike prediction %d", afterSpike, spike)
+	}
+}
+
+// TestHoltWintersPredictor_15MinWindowTrendDetection tests that the level and trend
+// follow a gradual increase in utilization.
+func TestHoltWintersPredictor_15MinWindowTrendDetection(t *testing.T) {
+	referenceTime := time.Date(2024, 1, 24, 10, 0, 0, 0, time.UTC)
+
+	stable := predictHoltWinters(t,
+		NewHoltWintersPredictorWithDefaults(&MockHistoryStore{records: GenerateMockHistoryData("test-pool", "variant-1", referenceTime)}),
+		referenceTime)
+	increasing := predictHoltWinters(t,
+		NewHoltWintersPredictorWithDefaults(&MockHistoryStore{records: GenerateMockHistoryDataGradualIncrease("test-pool", "variant-1", referenceTime)}),
+		referenceTime)
+	t.Logf("Prediction with gradual increase trend: %d instances (%d without)", increasing, stable)
+
+	// The last days of the history are 6 instances above the stable data
+	if increasing < stable+4 {
+		t.Errorf("expected the increasing prediction %d to be at least 4 above the stable prediction %d", increasing, stable)
+	}
+}
+
+// TestHoltWintersPredictor_IdleBuckets tests that buckets without a record between
+// records count as idle, as the utilization tracker only records busy instances.
+func TestHoltWintersPredictor_IdleBuckets(t *testing.T) {
+	referenceTime := time.Date(2024, 1, 24, 10, 0, 0, 0, time.UTC)
+
+	// Busy only on weekday mornings at 10:00, nothing recorded otherwise
+	var records []types.UtilizationRecord
+	for day := 1; day <= 21; day++ {
+		at := referenceTime.AddDate(0, 0, -day)
+		if at.Weekday() == time.Saturday || at.Weekday() == time.Sunday {
+			continue
+		}
+		records = append(records, types.UtilizationRecord{
+			Pool:           "test-pool",
+			VariantID:      "variant-1",
+			InUseInstances: 10,
+			RecordedAt:     at.Unix(),
+		})
+	}
+	predictor := NewHoltWintersPredictorWithDefaults(&MockHistoryStore{records: records})
+
+	busy := predictHoltWinters(t, predictor, referenceTime)
+	idle := predictHoltWinters(t, predictor, referenceTime.Add(4*time.Hour))
+	t.Logf("Wednesday 10:00: %d instances, 14:00: %d instances", busy, idle)
+
+	if busy < 5 {
+		t.Errorf("expected a busy prediction of at least 5, got %d", busy)
+	}
+	if idle > 2 {
+		t.Errorf("expected an idle prediction of at most 2, got %d", idle)
+	}
+}
@@ -4,6 +4,12 @@ import (
 	"context"
 )
 
+// Predictor types, as selected by configuration.
+const (
+	TypeEMAWeekendDecay = "ema_weekend_decay"
+	TypeHoltWinters     = "holt_winters"
+)
+
 // PredictionInput contains the input data for making a prediction.
 type PredictionInput struct {
 	// PoolName is the name of the pool to predict for.
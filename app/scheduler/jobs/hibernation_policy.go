@@ -0,0 +1,285 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/predictor"
+	"github.com/drone-runners/drone-runner-aws/store"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+const (
+	HibernationPolicyJobName = "hibernation-policy"
+)
+
+// HibernationManager moves the free instances of a pool to the state the
+// hibernation policy chose for them.
+type HibernationManager interface {
+	CanHibernate(poolName, tenantID string) bool
+	ApplyHibernationDecisions(ctx context.Context, poolName string, decisions []*drivers.HibernationDecision, dryRun bool) error
+}
+
+// ResumeLatencySource reports how long a hibernated VM of a pool takes to be
+// ready for a stage once resumed.
+type ResumeLatencySource interface {
+	MeanResumeToReadyLatency(poolID string) (time.Duration, bool)
+}
+
+// HibernationPolicyConfig holds the configuration of the hibernation policy job.
+type HibernationPolicyConfig struct {
+	Interval   time.Duration
+	CostWeight float64
+	// DefaultResumeLatency is used for pools that have not resumed a VM yet.
+	DefaultResumeLatency time.Duration
+	DryRun               bool
+}
+
+// HibernationPolicyJob periodically decides, for the free instances of the
+// hibernate-capable pools, which keep running, which are hibernated and which
+// are destroyed, based on the demand predicted for the next scaler window.
+type HibernationPolicyJob struct {
+	manager       HibernationManager
+	predictor     predictor.Predictor
+	instanceStore store.InstanceStore
+	historyStore  store.UtilizationHistoryStore
+	latency       ResumeLatencySource
+	scalerConfig  types.ScalerConfig
+	config        HibernationPolicyConfig
+	pools         []ScalablePool
+}
+
+// NewHibernationPolicyJob creates a new HibernationPolicyJob.
+func NewHibernationPolicyJob(
+	manager HibernationManager,
+	pred predictor.Predictor,
+	instanceStore store.InstanceStore,
+	historyStore store.UtilizationHistoryStore,
+	latency ResumeLatencySource,
+	scalerConfig types.ScalerConfig, //nolint:gocritic // acceptable for one-time setup
+	config HibernationPolicyConfig,
+	pools []ScalablePool,
+) *HibernationPolicyJob {
+	if scalerConfig.WindowDuration == 0 {
+		scalerConfig.WindowDuration = DefaultWindowDuration
+	}
+	return &HibernationPolicyJob{
+		manager:       manager,
+		predictor:     pred,
+		instanceStore: instanceStore,
+		historyStore:  historyStore,
+		latency:       latency,
+		scalerConfig:  scalerConfig,
+		config:        config,
+		pools:         pools,
+	}
+}
+
+// Name returns the job name.
+func (j *HibernationPolicyJob) Name() string {
+	return HibernationPolicyJobName
+}
+
+// Interval returns how often the job should run.
+func (j *HibernationPolicyJob) Interval() time.Duration {
+	return j.config.Interval
+}
+
+// Timeout returns the interval, hibernating an instance retries for a while.
+func (j *HibernationPolicyJob) Timeout() time.Duration {
+	return j.config.Interval
+}
+
+// RunOnStart returns false - the instances set up on start up are left
+// running until the first run.
+func (j *HibernationPolicyJob) RunOnStart() bool {
+	return false
+}
+
+// Execute applies the policy to every pool.
+func (j *HibernationPolicyJob) Execute(ctx context.Context) error {
+	now := time.Now()
+	var errs []error
+	for i := range j.pools {
+		if err := j.applyToPool(ctx, &j.pools[i], now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isPoolDisabled checks if the given pool name is in the disabled pools list
+// of the scaler config.
+func (j *HibernationPolicyJob) isPoolDisabled(poolName string) bool {
+	for _, disabledPool := range j.scalerConfig.DisabledPools {
+		if disabledPool == poolName {
+			return true
+		}
+	}
+	return false
+}
+
+// hibernationGroup is the free instances of one InstanceKey.
+type hibernationGroup struct {
+	free         []*types.Instance
+	provisioning int
+	hibernating  int
+}
+
+func (j *HibernationPolicyJob) applyToPool(ctx context.Context, pool *ScalablePool, now time.Time) error {
+	if j.isPoolDisabled(pool.Name) {
+		logrus.WithField(logFieldPool, pool.Name).Infoln("hibernation policy: pool is disabled for scaling, skipping")
+		return nil
+	}
+
+	instances, err := j.instanceStore.List(ctx, pool.Name, nil)
+	if err != nil {
+		return fmt.Errorf("hibernation policy: failed to list instances for pool %s: %w", pool.Name, err)
+	}
+
+	groups := make(map[InstanceKey]*hibernationGroup)
+	for _, inst := range instances {
+		key := hibernationInstanceKey(inst)
+		group := groups[key]
+		if group == nil {
+			group = &hibernationGroup{}
+		}
+		switch inst.State {
+		case types.StateCreated:
+			group.free = append(group.free, inst)
+		case types.StateProvisioning:
+			group.provisioning++
+		case types.StateHibernating:
+			group.hibernating++
+		default:
+			continue
+		}
+		groups[key] = group
+	}
+
+	resumeLatency, ok := j.latency.MeanResumeToReadyLatency(pool.Name)
+	if !ok {
+		resumeLatency = j.config.DefaultResumeLatency
+	}
+	policy := drivers.HibernationPolicy{CostWeight: j.config.CostWeight, Window: j.scalerConfig.WindowDuration}
+
+	keys := make([]InstanceKey, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return fmt.Sprint(keys[a]) < fmt.Sprint(keys[b])
+	})
+
+	var decisions []*drivers.HibernationDecision
+	for _, key := range keys {
+		group := groups[key]
+		if len(group.free) == 0 || !j.manager.CanHibernate(pool.Name, key.TenantID) {
+			continue
+		}
+		logr := logrus.WithFields(logrus.Fields{
+			logFieldPool:      pool.Name,
+			logFieldTenantID:  key.TenantID,
+			logFieldVariantID: key.VariantID,
+			logFieldImageName: key.ImageName,
+		})
+
+		prediction, err := j.predictor.Predict(ctx, &predictor.PredictionInput{
+			PoolName:       pool.Name,
+			TenantID:       key.TenantID,
+			VariantID:      key.VariantID,
+			ImageName:      key.ImageName,
+			StartTimestamp: now.Unix(),
+			EndTimestamp:   now.Add(j.scalerConfig.WindowDuration).Unix(),
+		})
+		if err != nil {
+			logr.WithError(err).Errorln("hibernation policy: failed to get prediction")
+			continue
+		}
+
+		demand := prediction.PredictedInstances
+		keep := j.keepCount(ctx, pool, key, demand)
+		target := policy.Target(demand, keep, resumeLatency)
+		// instances still being set up end up running, those being hibernated end up hibernated
+		target.Running = max(target.Running-group.provisioning, 0)
+		target.Hibernated = max(target.Hibernated-group.hibernating, 0)
+
+		logr.WithFields(logrus.Fields{
+			"free":           len(group.free),
+			"predicted":      demand,
+			"keep":           keep,
+			"resume_latency": resumeLatency,
+			"running":        target.Running,
+			"hibernated":     target.Hibernated,
+		}).Infoln("hibernation policy: calculated target")
+
+		decisions = append(decisions, target.Decide(group.free)...)
+	}
+
+	if len(decisions) == 0 {
+		return nil
+	}
+	return j.manager.ApplyHibernationDecisions(ctx, pool.Name, decisions, j.config.DryRun)
+}
+
+// keepCount returns how many free instances of a key are kept, running or
+// hibernated, matching the target the scaler scales the key to.
+func (j *HibernationPolicyJob) keepCount(ctx context.Context, pool *ScalablePool, key InstanceKey, demand int) int {
+	keep := demand
+	if j.scalerConfig.ScalePercent > 100 { //nolint:mnd
+		keep = int(math.Ceil(float64(demand) * j.scalerConfig.ScalePercent / 100.0)) //nolint:mnd
+	}
+	keep = max(keep, hibernationMinSize(pool, key))
+
+	if demand == 0 && j.scalerConfig.RecentUsageMinInstances > keep && j.historyStore != nil {
+		since := time.Now().AddDate(0, 0, -j.scalerConfig.RecentUsageLookbackDays).Unix()
+		recent, err := j.historyStore.HasRecentUsage(ctx, pool.Name, key.TenantID, key.VariantID, key.ImageName, since)
+		if err != nil {
+			logrus.WithError(err).WithField(logFieldPool, pool.Name).
+				Warnln("hibernation policy: failed to check recent usage, skipping recent usage minimum")
+		} else if recent {
+			keep = j.scalerConfig.RecentUsageMinInstances
+		}
+	}
+	return keep
+}
+
+// hibernationInstanceKey returns the key the scaler counts an instance under.
+func hibernationInstanceKey(inst *types.Instance) InstanceKey {
+	tenantID := inst.TenantID
+	if tenantID == "" {
+		tenantID = types.DefaultTenantID
+	}
+	variantID := inst.VariantID
+	if variantID == "" {
+		variantID = "default"
+	}
+	return InstanceKey{TenantID: tenantID, VariantID: variantID, ImageName: inst.Image}
+}
+
+// hibernationMinSize returns the configured min size of the tenant variant of a key.
+func hibernationMinSize(pool *ScalablePool, key InstanceKey) int {
+	minSize, variants := pool.MinSize, pool.Variants
+	for i := range pool.Tenants {
+		if pool.Tenants[i].ID == key.TenantID {
+			minSize, variants = pool.Tenants[i].MinSize, pool.Tenants[i].Variants
+			break
+		}
+	}
+	if key.VariantID == "default" {
+		return minSize
+	}
+	for i := range variants {
+		if variants[i].Params.VariantID == key.VariantID {
+			return variants[i].MinSize
+		}
+	}
+	return 0
+}
@@ -0,0 +1,187 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+type fakeHibernationManager struct {
+	canHibernate bool
+	decisions    []*drivers.HibernationDecision
+	dryRun       bool
+}
+
+func (f *fakeHibernationManager) CanHibernate(string, string) bool {
+	return f.canHibernate
+}
+
+func (f *fakeHibernationManager) ApplyHibernationDecisions(_ context.Context, _ string, decisions []*drivers.HibernationDecision, dryRun bool) error {
+	f.decisions = append(f.decisions, decisions...)
+	f.dryRun = dryRun
+	return nil
+}
+
+type fakeResumeLatency struct {
+	latency time.Duration
+	ok      bool
+}
+
+func (f fakeResumeLatency) MeanResumeToReadyLatency(string) (time.Duration, bool) {
+	return f.latency, f.ok
+}
+
+func hibernationPolicyStates(decisions []*drivers.HibernationDecision) map[string]string {
+	states := make(map[string]string, len(decisions))
+	for _, d := range decisions {
+		states[d.Instance.ID] = d.State
+	}
+	return states
+}
+
+func newHibernationPolicyTestJob(manager HibernationManager, pred *MockPredictor, instanceStore *MockInstanceStore, latency ResumeLatencySource) *HibernationPolicyJob {
+	return NewHibernationPolicyJob(
+		manager,
+		pred,
+		instanceStore,
+		NewMockUtilizationHistoryStore(),
+		latency,
+		types.ScalerConfig{WindowDuration: 30 * time.Minute, ScalePercent: 100},
+		HibernationPolicyConfig{
+			Interval:             5 * time.Minute,
+			CostWeight:           0.1,
+			DefaultResumeLatency: 2 * time.Minute,
+		},
+		[]ScalablePool{{Name: "pool-1"}},
+	)
+}
+
+func TestHibernationPolicyJob_PeakKeepsInstancesRunning(t *testing.T) {
+	instanceStore := NewMockInstanceStore()
+	for _, id := range []string{"inst-1", "inst-2", "inst-3"} {
+		instanceStore.AddInstance(&types.Instance{
+			ID: id, Pool: "pool-1", VariantID: "default", Image: "image-a",
+			State: types.StateCreated, IsHibernated: true, Source: types.InstanceSourcePredictor,
+		})
+	}
+	pred := NewMockPredictor()
+	pred.SetPredictionForImage("pool-1", "default", "image-a", 3)
+	manager := &fakeHibernationManager{canHibernate: true}
+
+	job := newHibernationPolicyTestJob(manager, pred, instanceStore, fakeResumeLatency{latency: 3 * time.Minute, ok: true})
+	if err := job.Execute(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 0.9*180s*4 / (0.1*1800s) = 3.6, all three are worth resuming
+	want := map[string]string{"inst-1": drivers.HibernationStateRunning, "inst-2": drivers.HibernationStateRunning, "inst-3": drivers.HibernationStateRunning}
+	got := hibernationPolicyStates(manager.decisions)
+	for id, state := range want {
+		if got[id] != state {
+			t.Errorf("expected %s to be %s, got %s", id, state, got[id])
+		}
+	}
+}
+
+func TestHibernationPolicyJob_OffPeakHibernatesAndDestroys(t *testing.T) {
+	instanceStore := NewMockInstanceStore()
+	instanceStore.AddInstance(&types.Instance{
+		ID: "pool-inst", Pool: "pool-1", VariantID: "default", Image: "image-a", State: types.StateCreated,
+	})
+	instanceStore.AddInstance(&types.Instance{
+		ID: "scaled-inst", Pool: "pool-1", VariantID: "default", Image: "image-a",
+		State: types.StateCreated, Source: types.InstanceSourcePredictor,
+	})
+	instanceStore.AddInstance(&types.Instance{
+		ID: "busy-inst", Pool: "pool-1", VariantID: "default", Image: "image-a", State: types.StateInUse,
+	})
+	pred := NewMockPredictor()
+	pred.SetPredictionForImage("pool-1", "default", "image-a", 0)
+	manager := &fakeHibernationManager{canHibernate: true}
+
+	job := newHibernationPolicyTestJob(manager, pred, instanceStore, fakeResumeLatency{})
+	job.pools[0].MinSize = 1
+	job.config.DryRun = true
+	if err := job.Execute(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := hibernationPolicyStates(manager.decisions)
+	want := map[string]string{"pool-inst": drivers.HibernationStateHibernated, "scaled-inst": drivers.HibernationStateDestroyed}
+	if len(got) != len(want) {
+		t.Fatalf("expected decisions %v, got %v", want, got)
+	}
+	for id, state := range want {
+		if got[id] != state {
+			t.Errorf("expected %s to be %s, got %s", id, state, got[id])
+		}
+	}
+	if !manager.dryRun {
+		t.Error("expected the decisions to be applied as a dry run")
+	}
+}
+
+func TestHibernationPolicyJob_SkipsPoolsThatCannotHibernate(t *testing.T) {
+	instanceStore := NewMockInstanceStore()
+	instanceStore.AddInstance(&types.Instance{
+		ID: "inst-1", Pool: "pool-1", VariantID: "default", Image: "image-a", State: types.StateCreated,
+	})
+	manager := &fakeHibernationManager{canHibernate: false}
+
+	job := newHibernationPolicyTestJob(manager, NewMockPredictor(), instanceStore, fakeResumeLatency{})
+	if err := job.Execute(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(manager.decisions) != 0 {
+		t.Errorf("expected no decisions, got %d", len(manager.decisions))
+	}
+}
+
+func TestHibernationMinSize(t *testing.T) {
+	pool := &ScalablePool{
+		Name:    "pool-1",
+		MinSize: 2,
+		Tenants: []ScalableTenant{{
+			ID:       "tenant-a",
+			MinSize:  3,
+			Variants: []ScalableVariant{{MinSize: 1, Params: types.SetupInstanceParams{VariantID: "large"}}},
+		}},
+	}
+
+	tests := []struct {
+		key  InstanceKey
+		want int
+	}{
+		{InstanceKey{TenantID: "tenant-a", VariantID: "default"}, 3},
+		{InstanceKey{TenantID: "tenant-a", VariantID: "large"}, 1},
+		{InstanceKey{TenantID: "tenant-a", VariantID: "unknown"}, 0},
+		{InstanceKey{TenantID: types.DefaultTenantID, VariantID: "default"}, 2},
+	}
+	for _, tt := range tests {
+		if got := hibernationMinSize(pool, tt.key); got != tt.want {
+			t.Errorf("min size of %+v: expected %d, got %d", tt.key, tt.want, got)
+		}
+	}
+}
+
+func TestHibernationPolicyJob_SkipsDisabledPools(t *testing.T) {
+	instanceStore := NewMockInstanceStore()
+	instanceStore.AddInstance(&types.Instance{
+		ID: "inst-1", Pool: "pool-1", VariantID: "default", Image: "image-a", State: types.StateCreated,
+	})
+	pred := NewMockPredictor()
+	pred.SetPredictionForImage("pool-1", "default", "image-a", 0)
+	manager := &fakeHibernationManager{canHibernate: true}
+
+	job := newHibernationPolicyTestJob(manager, pred, instanceStore, fakeResumeLatency{})
+	job.scalerConfig.DisabledPools = []string{"pool-1"}
+	if err := job.Execute(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(manager.decisions) != 0 {
+		t.Errorf("expected no decisions, got %d", len(manager.decisions))
+	}
+}
@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+)
+
+const (
+	OrphanCollectorJobName = "orphan-collector"
+)
+
+// OrphanCollector deletes the cloud resources no row references.
+type OrphanCollector interface {
+	CollectOrphans(ctx context.Context, opts drivers.OrphanCollectionOptions) error
+}
+
+// OrphanCollectorJob periodically deletes the persistent disks and capacity
+// reservations left behind without an instance or capacity reservation row.
+type OrphanCollectorJob struct {
+	collector OrphanCollector
+	interval  time.Duration
+	opts      drivers.OrphanCollectionOptions
+}
+
+// NewOrphanCollectorJob creates a new OrphanCollectorJob.
+func NewOrphanCollectorJob(
+	collector OrphanCollector,
+	interval time.Duration,
+	opts drivers.OrphanCollectionOptions,
+) *OrphanCollectorJob {
+	return &OrphanCollectorJob{
+		collector: collector,
+		interval:  interval,
+		opts:      opts,
+	}
+}
+
+// Name returns the job name.
+func (j *OrphanCollectorJob) Name() string {
+	return OrphanCollectorJobName
+}
+
+// Interval returns how often the job should run.
+func (j *OrphanCollectorJob) Interval() time.Duration {
+	return j.interval
+}
+
+// Timeout returns the interval, deleting disks waits for each deletion.
+func (j *OrphanCollectorJob) Timeout() time.Duration {
+	return j.interval
+}
+
+// RunOnStart returns false - orphans are old by definition, there is no need
+// to hurry.
+func (j *OrphanCollectorJob) RunOnStart() bool {
+	return false
+}
+
+// Execute collects the orphans of every pool.
+func (j *OrphanCollectorJob) Execute(ctx context.Context) error {
+	return j.collector.CollectOrphans(ctx, j.opts)
+}
@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+)
+
+const (
+	ReconcileJobName = "instance-reconcile"
+)
+
+// InstanceReconciler reconciles the cloud with the instance store.
+type InstanceReconciler interface {
+	ReconcileInstances(ctx context.Context, opts drivers.ReconcileOptions) error
+}
+
+// ReconcileJob periodically destroys leaked VMs that have no instance row and
+// deletes the instance rows whose VM no longer exists.
+type ReconcileJob struct {
+	reconciler InstanceReconciler
+	interval   time.Duration
+	opts       drivers.ReconcileOptions
+}
+
+// NewReconcileJob creates a new ReconcileJob.
+func NewReconcileJob(
+	reconciler InstanceReconciler,
+	interval time.Duration,
+	opts drivers.ReconcileOptions,
+) *ReconcileJob {
+	return &ReconcileJob{
+		reconciler: reconciler,
+		interval:   interval,
+		opts:       opts,
+	}
+}
+
+// Name returns the job name.
+func (j *ReconcileJob) Name() string {
+	return ReconcileJobName
+}
+
+// Interval returns how often the job should run.
+func (j *ReconcileJob) Interval() time.Duration {
+	return j.interval
+}
+
+// Timeout returns the interval, listing a large project can take a while.
+func (j *ReconcileJob) Timeout() time.Duration {
+	return j.interval
+}
+
+// RunOnStart returns false - rows of VMs still being created on start up
+// are covered by the grace period, but there is no need to hurry.
+func (j *ReconcileJob) RunOnStart() bool {
+	return false
+}
+
+// Execute reconciles every pool.
+func (j *ReconcileJob) Execute(ctx context.Context) error {
+	return j.reconciler.ReconcileInstances(ctx, j.opts)
+}
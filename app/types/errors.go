@@ -1,6 +1,9 @@
 package types
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 type RetryableError struct {
 	Msg string
@@ -53,3 +56,52 @@ type ErrCapacityUnavailable struct {
 func (e *ErrCapacityUnavailable) Error() string {
 	return fmt.Sprintf("capacity unavailable for %s driver", e.Driver)
 }
+
+// ErrSpotInterrupted is returned when the cloud provider reclaimed the spot
+// instance a stage was running on. The stage can be retried on a new instance.
+type ErrSpotInterrupted struct {
+	Driver     string
+	InstanceID string
+}
+
+func (e *ErrSpotInterrupted) Error() string {
+	return fmt.Sprintf("%s spot instance %s was interrupted by the cloud provider", e.Driver, e.InstanceID)
+}
+
+// ErrCodeSpotInterrupted is the error code reported to clients when a stage
+// failed because its spot instance was interrupted.
+const ErrCodeSpotInterrupted = "spot_interrupted"
+
+// ErrSpotPreempted is returned when GCE preempted the Spot VM a stage was
+// running on. It unwraps to *ErrSpotInterrupted, so callers that only care
+// about interruptions in general handle it the same way.
+type ErrSpotPreempted struct {
+	ErrSpotInterrupted
+	Zone string
+}
+
+func (e *ErrSpotPreempted) Error() string {
+	return fmt.Sprintf("%s spot VM %s was preempted in zone %s", e.Driver, e.InstanceID, e.Zone)
+}
+
+func (e *ErrSpotPreempted) Unwrap() error {
+	return &e.ErrSpotInterrupted
+}
+
+// ErrCodeSpotPreempted is the error code reported to clients when a stage
+// failed because its Spot VM was preempted.
+const ErrCodeSpotPreempted = "spot_preempted"
+
+// SpotErrorCode returns the client error code for a stage that failed because
+// its spot instance was reclaimed, and false for any other error.
+func SpotErrorCode(err error) (string, bool) {
+	var preemptedErr *ErrSpotPreempted
+	if errors.As(err, &preemptedErr) {
+		return ErrCodeSpotPreempted, true
+	}
+	var interruptedErr *ErrSpotInterrupted
+	if errors.As(err, &interruptedErr) {
+		return ErrCodeSpotInterrupted, true
+	}
+	return "", false
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package bake
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/signal"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	"github.com/drone-runners/drone-runner-aws/app/poolfile"
+	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/store/database"
+
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// empty context.
+var nocontext = context.Background()
+
+// invalidImageChars matches the characters that are not allowed in image
+// names by all the drivers that can bake images.
+var invalidImageChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+type bakeCommand struct {
+	envFile        string
+	poolFile       string
+	pool           string
+	instance       string
+	scripts        []string
+	imageName      string
+	distributed    bool
+	updatePoolFile bool
+}
+
+func (c *bakeCommand) run(*kingpin.ParseContext) error {
+	// load environment variables from file.
+	err := godotenv.Load(c.envFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// load the configuration from the environment
+	env, err := config.FromEnviron()
+	if err != nil {
+		return err
+	}
+	setupLogger(&env)
+
+	ctx, cancel := context.WithCancel(nocontext)
+	defer cancel()
+	// listen for termination signals to gracefully shutdown.
+	ctx = signal.WithContextFunc(ctx, func() {
+		println("bake: received signal, terminating process")
+		cancel()
+	})
+
+	raw, err := os.ReadFile(c.poolFile)
+	if err != nil {
+		return fmt.Errorf("bake: unable to read pool file: %w", err)
+	}
+	configPool, err := config.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("bake: unable to parse pool file: %w", err)
+	}
+	pools, err := poolfile.ProcessPool(configPool, env.Runner.Name, env.Passwords(), nil)
+	if err != nil {
+		return fmt.Errorf("bake: unable to process pool file: %w", err)
+	}
+
+	opts := &drivers.BakeOptions{ImageName: c.imageName}
+	if opts.ImageName == "" {
+		opts.ImageName = defaultImageName(c.pool, time.Now().UTC())
+	}
+	for _, path := range c.scripts {
+		script, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return fmt.Errorf("bake: unable to read script: %w", readErr)
+		}
+		opts.Scripts = append(opts.Scripts, drivers.BakeScript{Name: filepath.Base(path), Script: string(script)})
+	}
+
+	driver, datasource, iamAuth, region := env.Database.Driver, env.Database.Datasource, false, ""
+	if c.distributed {
+		driver, datasource = env.DistributedMode.Driver, env.DistributedMode.Datasource
+		iamAuth, region = env.DistributedMode.IAMAuth, env.DistributedMode.Region
+	}
+	store, _, _, _, _, err := database.ProvideStore(ctx, driver, datasource, iamAuth, region) //nolint:dogsled
+	if err != nil {
+		return fmt.Errorf("bake: unable to connect to the database: %w", err)
+	}
+
+	poolManager := drivers.New(ctx, store, &env)
+	if err = poolManager.Add(pools...); err != nil {
+		return fmt.Errorf("bake: unable to add pools: %w", err)
+	}
+
+	logrus.WithField("pool", c.pool).
+		WithField("instance", c.instance).
+		WithField("image", opts.ImageName).
+		Infoln("bake: baking image")
+	image, err := poolManager.BakeImage(ctx, c.pool, c.instance, opts)
+	if err != nil {
+		return err
+	}
+
+	if c.updatePoolFile {
+		updated, setErr := config.SetImage(raw, c.pool, image)
+		if setErr != nil {
+			return fmt.Errorf("bake: image %s created but the pool file was not updated: %w", image, setErr)
+		}
+		info, statErr := os.Stat(c.poolFile)
+		if statErr != nil {
+			return statErr
+		}
+		if err = os.WriteFile(c.poolFile, updated, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("bake: image %s created but the pool file was not updated: %w", image, err)
+		}
+		logrus.WithField("pool", c.pool).
+			WithField("image", image).
+			Infoln("bake: pool file updated")
+	}
+
+	fmt.Println(image)
+	return nil
+}
+
+// defaultImageName returns an image name derived from the pool name and the
+// time, valid for every driver that can bake images.
+func defaultImageName(pool string, now time.Time) string {
+	name := strings.Trim(invalidImageChars.ReplaceAllString(strings.ToLower(pool), "-"), "-")
+	return fmt.Sprintf("%s-%s", name, now.Format("20060102-150405"))
+}
+
+func setupLogger(c *config.EnvConfig) {
+	logger.Default = logger.Logrus(
+		logrus.NewEntry(
+			logrus.StandardLogger(),
+		),
+	)
+	if c.Debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	if c.Trace {
+		logrus.SetLevel(logrus.TraceLevel)
+	}
+}
+
+// Register the bake command.
+func Register(app *kingpin.Application) {
+	c := new(bakeCommand)
+
+	cmd := app.Command("bake", "bakes a golden image from a pool instance").
+		Action(c.run)
+	cmd.Flag("envfile", "load the environment variable file").
+		Default(".env").
+		StringVar(&c.envFile)
+	cmd.Flag("pool-file", "pool file of the pool to bake").
+		Required().
+		StringVar(&c.poolFile)
+	cmd.Flag("pool", "name of the pool").
+		Required().
+		StringVar(&c.pool)
+	cmd.Flag("instance", "id of a free instance of the pool to bake").
+		Required().
+		StringVar(&c.instance)
+	cmd.Flag("script", "provisioning script to run on the instance, can be repeated").
+		StringsVar(&c.scripts)
+	cmd.Flag("image-name", "name of the image, defaults to the pool name and the time").
+		Default("").
+		StringVar(&c.imageName)
+	cmd.Flag("distributed", "use the distributed mode database").
+		Default("false").
+		BoolVar(&c.distributed)
+	cmd.Flag("update-pool-file", "set the pool image in the pool file to the baked image").
+		Default("true").
+		BoolVar(&c.updatePoolFile)
+}
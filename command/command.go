@@ -8,6 +8,7 @@ import (
 	"context"
 	"os"
 
+	"github.com/drone-runners/drone-runner-aws/command/bake"
 	"github.com/drone-runners/drone-runner-aws/command/daemon"
 	"github.com/drone-runners/drone-runner-aws/command/harness/delegate"
 	"github.com/drone-runners/drone-runner-aws/command/harness/delegate/tester"
@@ -28,6 +29,7 @@ func Command() {
 	app := kingpin.New("drone", "drone aws runner")
 	registerCompile(app)
 	registerExec(app)
+	bake.Register(app)
 	daemon.Register(app)
 	delegate.RegisterDelegate(app)
 	dlite.RegisterDlite(app)
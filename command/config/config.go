@@ -36,6 +36,25 @@ type (
 		// as the default tenant). When empty, the pool is single-tenant and the flat Spec above
 		// is used unchanged (backward compatible).
 		Tenants []Tenant `json:"tenants,omitempty" yaml:"tenants,omitempty"`
+		// RateLimit optionally throttles the cloud API calls made by the pool. Every driver
+		// of the pool, one per tenant, gets its own buckets and in-flight limit.
+		RateLimit *RateLimit `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	}
+
+	// RateLimit configures the token buckets and in-flight limit of a pool's drivers.
+	// Mutating calls (create, destroy, hibernate, start, tags, capacity reservations) and
+	// read calls (ping, logs, image lookups, interruption checks) have separate buckets.
+	RateLimit struct {
+		Mutating    RateLimitBucket `json:"mutating,omitempty" yaml:"mutating,omitempty"`
+		Read        RateLimitBucket `json:"read,omitempty" yaml:"read,omitempty"`
+		MaxInFlight int             `json:"max_in_flight,omitempty" yaml:"max_in_flight,omitempty"`
+	}
+
+	// RateLimitBucket is a token bucket refilled at Rate tokens per second and holding at
+	// most Burst tokens. A zero rate leaves the bucket unlimited.
+	RateLimitBucket struct {
+		Rate  float64 `json:"rate,omitempty" yaml:"rate,omitempty"`
+		Burst int     `json:"burst,omitempty" yaml:"burst,omitempty"`
 	}
 
 	// Tenant represents a per-account override inside a multi-tenant pool. The pool's top-level
@@ -72,6 +91,7 @@ type (
 		Name          string            `json:"name,omitempty" yaml:"name,omitempty"`
 		Size          string            `json:"size,omitempty"`
 		SizeAlt       string            `json:"size_alt,omitempty" yaml:"size_alt,omitempty"`
+		SizeFallbacks []string          `json:"size_fallbacks,omitempty" yaml:"size_fallbacks,omitempty"` // tried in order when size is out of capacity
 		AMI           string            `json:"ami,omitempty"`
 		VPC           string            `json:"vpc,omitempty" yaml:"vpc,omitempty"`
 		Tags          map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
@@ -145,8 +165,9 @@ type (
 	}
 
 	Nomad struct {
-		Server NomadServer `json:"server" yaml:"server"`
-		VM     NomadVM     `json:"vm" yaml:"vm"`
+		Server    NomadServer     `json:"server" yaml:"server"`
+		VM        NomadVM         `json:"vm" yaml:"vm"`
+		Placement *NomadPlacement `json:"placement,omitempty" yaml:"placement,omitempty"`
 	}
 
 	NomadServer struct {
@@ -164,6 +185,7 @@ type (
 		DiskSize      string                   `json:"disk_size" yaml:"disk_size"`
 		EnablePinning map[string]string        `json:"enablePinning" yaml:"enablePinning"`
 		Noop          bool                     `json:"noop" yaml:"noop"`
+		Hibernate     bool                     `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
 		Resource      map[string]NomadResource `json:"resource" yaml:"resource"`
 		Account       struct {
 			Username        string `json:"username,omitempty"  yaml:"username"`
@@ -180,6 +202,44 @@ type (
 		DiskSize string `json:"disk_size" yaml:"disk_size"`
 	}
 
+	// NomadPlacement decides where the resource jobs of a pool's VMs are placed.
+	// Every string is a Go template over the account, node class, image and
+	// resource class of the VM. A rule with an `if` template only applies when
+	// it renders to "true". When unset, VMs are placed on nodes of their node
+	// class, and non Sequoia images are kept off Sequoia-only nodes.
+	NomadPlacement struct {
+		Constraints []NomadConstraint `json:"constraints,omitempty" yaml:"constraints,omitempty"`
+		Affinities  []NomadAffinity   `json:"affinities,omitempty" yaml:"affinities,omitempty"`
+		Spreads     []NomadSpread     `json:"spreads,omitempty" yaml:"spreads,omitempty"`
+	}
+
+	NomadConstraint struct {
+		If        string `json:"if,omitempty" yaml:"if,omitempty"`
+		Attribute string `json:"attribute" yaml:"attribute"`
+		Operator  string `json:"operator" yaml:"operator"`
+		Value     string `json:"value,omitempty" yaml:"value,omitempty"`
+	}
+
+	NomadAffinity struct {
+		If        string `json:"if,omitempty" yaml:"if,omitempty"`
+		Attribute string `json:"attribute" yaml:"attribute"`
+		Operator  string `json:"operator" yaml:"operator"`
+		Value     string `json:"value,omitempty" yaml:"value,omitempty"`
+		Weight    int    `json:"weight" yaml:"weight"`
+	}
+
+	NomadSpread struct {
+		If        string              `json:"if,omitempty" yaml:"if,omitempty"`
+		Attribute string              `json:"attribute" yaml:"attribute"`
+		Weight    int                 `json:"weight,omitempty" yaml:"weight,omitempty"`
+		Targets   []NomadSpreadTarget `json:"targets,omitempty" yaml:"targets,omitempty"`
+	}
+
+	NomadSpreadTarget struct {
+		Value   string `json:"value" yaml:"value"`
+		Percent int    `json:"percent" yaml:"percent"`
+	}
+
 	// Azure specifies the configuration for an Azure instance.
 	Azure struct {
 		Account           AzureAccount      `json:"account,omitempty"`
@@ -192,11 +252,22 @@ type (
 		UserDataPath      string            `json:"user_data_path,omitempty" yaml:"user_data_path,omitempty"`
 		Image             AzureImage        `json:"image,omitempty" yaml:"image,omitempty"`
 		Size              string            `json:"size,omitempty"  yaml:"size,omitempty"`
+		SizeFallbacks     []string          `json:"size_fallbacks,omitempty" yaml:"size_fallbacks,omitempty"` // tried in order when size is out of capacity
 		Zones             []string          `json:"zones,omitempty" yaml:"zones,omitempty"`
 		Tags              map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
 		SecurityGroupName string            `json:"security_group_name,omitempty" yaml:"security_group_name,omitempty"`
 		SecurityType      string            `json:"security_type,omitempty" yaml:"security_type,omitempty"`
 		Network           AzureNetwork      `json:"network,omitempty" yaml:"network,omitempty"`
+		Hibernate         bool              `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
+		Gallery           AzureGallery      `json:"gallery,omitempty" yaml:"gallery,omitempty"`
+	}
+
+	// AzureGallery is the generalized image definition of a compute gallery that baked
+	// images are published to as new versions.
+	AzureGallery struct {
+		ResourceGroup string `json:"resource_group,omitempty" yaml:"resource_group,omitempty"` // defaults to the pool's resource group
+		Name          string `json:"name,omitempty" yaml:"name,omitempty"`
+		Image         string `json:"image,omitempty" yaml:"image,omitempty"` // image definition name
 	}
 
 	// AzureNetwork provides network settings for Azure instances.
@@ -241,6 +312,147 @@ type (
 		Region string `json:"region,omitempty" yaml:"region,omitempty"`
 	}
 
+	// Hetzner specifies the configuration for a Hetzner Cloud server.
+	Hetzner struct {
+		Account       HetznerAccount    `json:"account,omitempty"`
+		Image         string            `json:"image,omitempty" yaml:"image,omitempty"`
+		ServerType    string            `json:"server_type,omitempty" yaml:"server_type,omitempty"`
+		Labels        map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+		SSHKeys       []string          `json:"ssh_keys,omitempty" yaml:"ssh_keys,omitempty"`
+		Firewalls     []int64           `json:"firewalls,omitempty" yaml:"firewalls,omitempty"`
+		Networks      []int64           `json:"networks,omitempty" yaml:"networks,omitempty"`
+		PrivateIP     bool              `json:"private_ip,omitempty" yaml:"private_ip,omitempty"`
+		Hibernate     bool              `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
+		RootDirectory string            `json:"root_directory,omitempty" yaml:"root_directory"`
+		UserData      string            `json:"user_data,omitempty" yaml:"user_data,omitempty"`
+		UserDataPath  string            `json:"user_data_path,omitempty" yaml:"user_data_path,omitempty"`
+	}
+
+	// Libvirt specifies the configuration for a local libvirt/QEMU-KVM host.
+	Libvirt struct {
+		URI           string `json:"uri,omitempty" yaml:"uri,omitempty"`
+		Image         string `json:"image,omitempty" yaml:"image,omitempty"`
+		StoragePath   string `json:"storage_path,omitempty" yaml:"storage_path,omitempty"`
+		Network       string `json:"network,omitempty" yaml:"network,omitempty"`
+		LeaseFile     string `json:"lease_file,omitempty" yaml:"lease_file,omitempty"`
+		CPUs          int64  `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+		MemoryMB      int64  `json:"memory_mb,omitempty" yaml:"memory_mb,omitempty"`
+		DiskSizeGB    int64  `json:"disk_size_gb,omitempty" yaml:"disk_size_gb,omitempty"`
+		Hibernate     bool   `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
+		RootDirectory string `json:"root_directory,omitempty" yaml:"root_directory"`
+		UserData      string `json:"user_data,omitempty" yaml:"user_data,omitempty"`
+		UserDataPath  string `json:"user_data_path,omitempty" yaml:"user_data_path,omitempty"`
+	}
+
+	// Firecracker specifies the configuration for Firecracker microVMs on the runner host.
+	Firecracker struct {
+		Binary        string `json:"binary,omitempty" yaml:"binary,omitempty"`
+		Kernel        string `json:"kernel,omitempty" yaml:"kernel,omitempty"`
+		KernelArgs    string `json:"kernel_args,omitempty" yaml:"kernel_args,omitempty"`
+		RootFS        string `json:"rootfs,omitempty" yaml:"rootfs,omitempty"`
+		StateDir      string `json:"state_dir,omitempty" yaml:"state_dir,omitempty"`
+		Subnet        string `json:"subnet,omitempty" yaml:"subnet,omitempty"`
+		VCPUs         int64  `json:"vcpus,omitempty" yaml:"vcpus,omitempty"`
+		MemoryMB      int64  `json:"memory_mb,omitempty" yaml:"memory_mb,omitempty"`
+		Hibernate     bool   `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
+		RootDirectory string `json:"root_directory,omitempty" yaml:"root_directory"`
+		UserData      string `json:"user_data,omitempty" yaml:"user_data,omitempty"`
+		UserDataPath  string `json:"user_data_path,omitempty" yaml:"user_data_path,omitempty"`
+	}
+
+	// Proxmox specifies the configuration for a Proxmox VE virtual machine.
+	Proxmox struct {
+		Account        ProxmoxAccount `json:"account,omitempty"`
+		Template       int            `json:"template,omitempty" yaml:"template,omitempty"`
+		ResourcePool   string         `json:"resource_pool,omitempty" yaml:"resource_pool,omitempty"`
+		SnippetStorage string         `json:"snippet_storage,omitempty" yaml:"snippet_storage,omitempty"`
+		SnippetDir     string         `json:"snippet_dir,omitempty" yaml:"snippet_dir,omitempty"`
+		Cores          int64          `json:"cores,omitempty" yaml:"cores,omitempty"`
+		MemoryMB       int64          `json:"memory_mb,omitempty" yaml:"memory_mb,omitempty"`
+		Tags           []string       `json:"tags,omitempty" yaml:"tags,omitempty"`
+		Hibernate      bool           `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
+		RootDirectory  string         `json:"root_directory,omitempty" yaml:"root_directory"`
+		UserData       string         `json:"user_data,omitempty" yaml:"user_data,omitempty"`
+		UserDataPath   string         `json:"user_data_path,omitempty" yaml:"user_data_path,omitempty"`
+	}
+
+	// OpenStack specifies the configuration for an OpenStack Nova server.
+	OpenStack struct {
+		Account          OpenStackAccount  `json:"account,omitempty"`
+		Flavor           string            `json:"flavor,omitempty" yaml:"flavor,omitempty"`
+		Image            string            `json:"image,omitempty" yaml:"image,omitempty"`
+		AvailabilityZone string            `json:"availability_zone,omitempty" yaml:"availability_zone,omitempty"`
+		Network          string            `json:"network,omitempty" yaml:"network,omitempty"`
+		FloatingNetwork  string            `json:"floating_network,omitempty" yaml:"floating_network,omitempty"`
+		SecurityGroups   []string          `json:"security_groups,omitempty" yaml:"security_groups,omitempty"`
+		KeyPair          string            `json:"key_pair,omitempty" yaml:"key_pair,omitempty"`
+		Metadata         map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+		Hibernate        bool              `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
+		RootDirectory    string            `json:"root_directory,omitempty" yaml:"root_directory"`
+		UserData         string            `json:"user_data,omitempty" yaml:"user_data,omitempty"`
+		UserDataPath     string            `json:"user_data_path,omitempty" yaml:"user_data_path,omitempty"`
+	}
+
+	// KubeVirt specifies the configuration for a KubeVirt virtual machine.
+	KubeVirt struct {
+		Account       KubeVirtAccount   `json:"account,omitempty"`
+		Namespace     string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+		Image         string            `json:"image,omitempty" yaml:"image,omitempty"`
+		SourcePVC     KubeVirtPVC       `json:"source_pvc,omitempty" yaml:"source_pvc,omitempty"`
+		CPU           int64             `json:"cpu,omitempty" yaml:"cpu,omitempty"`
+		Memory        string            `json:"memory,omitempty" yaml:"memory,omitempty"`
+		NodeSelector  map[string]string `json:"node_selector,omitempty" yaml:"node_selector,omitempty"`
+		Labels        map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+		Hibernate     bool              `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
+		RootDirectory string            `json:"root_directory,omitempty" yaml:"root_directory"`
+		UserData      string            `json:"user_data,omitempty" yaml:"user_data,omitempty"`
+		UserDataPath  string            `json:"user_data_path,omitempty" yaml:"user_data_path,omitempty"`
+	}
+
+	HetznerAccount struct {
+		Token    string `json:"token,omitempty" yaml:"token"`
+		Location string `json:"location,omitempty" yaml:"location,omitempty"`
+		Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	}
+
+	ProxmoxAccount struct {
+		Endpoint string `json:"endpoint,omitempty" yaml:"endpoint"`
+		Token    string `json:"token,omitempty" yaml:"token"`
+		Node     string `json:"node,omitempty" yaml:"node"`
+		Insecure bool   `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	}
+
+	OpenStackAccount struct {
+		AuthURL                     string `json:"auth_url,omitempty" yaml:"auth_url"`
+		Username                    string `json:"username,omitempty" yaml:"username,omitempty"`
+		Password                    string `json:"password,omitempty" yaml:"password,omitempty"`
+		UserDomain                  string `json:"user_domain,omitempty" yaml:"user_domain,omitempty"`
+		ProjectName                 string `json:"project_name,omitempty" yaml:"project_name,omitempty"`
+		ProjectID                   string `json:"project_id,omitempty" yaml:"project_id,omitempty"`
+		ProjectDomain               string `json:"project_domain,omitempty" yaml:"project_domain,omitempty"`
+		ApplicationCredentialID     string `json:"application_credential_id,omitempty" yaml:"application_credential_id,omitempty"`
+		ApplicationCredentialSecret string `json:"application_credential_secret,omitempty" yaml:"application_credential_secret,omitempty"`
+		Region                      string `json:"region,omitempty" yaml:"region,omitempty"`
+		Interface                   string `json:"interface,omitempty" yaml:"interface,omitempty"`
+	}
+
+	// KubeVirtAccount configures access to the Kubernetes API server. When
+	// server is empty the in-cluster service account is used.
+	KubeVirtAccount struct {
+		Server    string `json:"server,omitempty" yaml:"server,omitempty"`
+		Token     string `json:"token,omitempty" yaml:"token,omitempty"`
+		TokenFile string `json:"token_file,omitempty" yaml:"token_file,omitempty"`
+		CAFile    string `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`
+		Insecure  bool   `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	}
+
+	KubeVirtPVC struct {
+		Namespace    string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+		Name         string `json:"name,omitempty" yaml:"name,omitempty"`
+		Size         string `json:"size,omitempty" yaml:"size,omitempty"`
+		StorageClass string `json:"storage_class,omitempty" yaml:"storage_class,omitempty"`
+	}
+
 	// GoogleNetwork specifies a network/subnetwork/tags/zone combination for a GCP instance.
 	// Multiple entries can be defined and will be used in round-robin fashion.
 	// Prefer networks[] over the deprecated top-level network/subnetwork/tags fields.
@@ -262,6 +474,7 @@ type (
 		Tags                       []string          `json:"tags,omitempty" yaml:"tags,omitempty"` // Deprecated: use networks[].tags
 		Size                       string            `json:"size,omitempty" yaml:"size,omitempty"`
 		MachineType                string            `json:"machine_type,omitempty" yaml:"machine_type,omitempty"`
+		MachineTypeFallbacks       []string          `json:"machine_type_fallbacks,omitempty" yaml:"machine_type_fallbacks,omitempty"` // tried in order when machine_type is out of capacity
 		UserData                   string            `json:"user_data,omitempty" yaml:"user_data,omitempty"`
 		UserDataPath               string            `json:"user_data_path,omitempty" yaml:"user_data_path,omitempty"`
 		UserDataKey                string            `json:"user_data_key,omitempty" yaml:"user_data_key,omitempty"`
@@ -274,6 +487,7 @@ type (
 		Labels                     map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
 		Scopes                     []string          `json:"scopes,omitempty" yaml:"scopes,omitempty"`
 		Hibernate                  bool              `json:"hibernate,omitempty"`
+		ProvisioningModel          string            `json:"provisioning_model,omitempty" yaml:"provisioning_model,omitempty"` // standard (default) or spot
 		EnableNestedVirtualization bool              `json:"enable_nested_virtualization,omitempty" yaml:"enable_nested_virtualization,omitempty"`
 		EnableC4D                  bool              `json:"enable_c4d,omitempty" yaml:"enable_c4d,omitempty"`
 		GPU                        bool              `json:"gpu,omitempty" yaml:"gpu,omitempty"`
@@ -308,6 +522,62 @@ type (
 	// Noop specifies the configuration for a Noop instance.
 	Noop struct {
 		Hibernate bool `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
+		// Simulation makes the noop driver inject latency and faults. Without it every
+		// operation takes a fixed time and succeeds.
+		Simulation *NoopSimulation `json:"simulation,omitempty" yaml:"simulation,omitempty"`
+	}
+
+	// NoopSimulation configures the latency and faults the noop driver injects, to reproduce
+	// purger, fallback and hibernate behaviour without a cloud. Operations that are not
+	// configured complete at once and never fail.
+	NoopSimulation struct {
+		// Seed makes the injected faults reproducible. Zero seeds from the clock.
+		Seed            int64         `json:"seed,omitempty" yaml:"seed,omitempty"`
+		Create          NoopOperation `json:"create,omitempty" yaml:"create,omitempty"`
+		Destroy         NoopOperation `json:"destroy,omitempty" yaml:"destroy,omitempty"`
+		Hibernate       NoopOperation `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
+		Start           NoopOperation `json:"start,omitempty" yaml:"start,omitempty"`
+		SetTags         NoopOperation `json:"set_tags,omitempty" yaml:"set_tags,omitempty"`
+		ReserveCapacity NoopOperation `json:"reserve_capacity,omitempty" yaml:"reserve_capacity,omitempty"`
+		DestroyCapacity NoopOperation `json:"destroy_capacity,omitempty" yaml:"destroy_capacity,omitempty"`
+		// Zones are tried in order by creates and reservations that do not ask for zones.
+		Zones []string `json:"zones,omitempty" yaml:"zones,omitempty"`
+		// MachineType and MachineTypeFallbacks are walked in order when every zone is out
+		// of capacity, unless the stage asks for its own machine type.
+		MachineType          string   `json:"machine_type,omitempty" yaml:"machine_type,omitempty"`
+		MachineTypeFallbacks []string `json:"machine_type_fallbacks,omitempty" yaml:"machine_type_fallbacks,omitempty"`
+		// Stockouts make zones run out of capacity for creates and reservations.
+		Stockouts []NoopStockout `json:"stockouts,omitempty" yaml:"stockouts,omitempty"`
+		// CapacityReservation makes the driver support capacity reservations.
+		CapacityReservation bool `json:"capacity_reservation,omitempty" yaml:"capacity_reservation,omitempty"`
+	}
+
+	// NoopOperation is the latency and failure rate of a simulated operation.
+	NoopOperation struct {
+		Latency NoopLatency `json:"latency,omitempty" yaml:"latency,omitempty"`
+		// FailureRate is the probability, from 0 to 1, that the operation fails.
+		FailureRate float64 `json:"failure_rate,omitempty" yaml:"failure_rate,omitempty"`
+	}
+
+	// NoopLatency is a latency distribution. Durations are Go durations such as "15s".
+	// Distribution is one of fixed (the default, always Mean), uniform (between Min and Max),
+	// normal (Mean and StdDev) or exponential (Mean). Min and Max, when set, clamp the
+	// normal and exponential distributions.
+	NoopLatency struct {
+		Distribution string `json:"distribution,omitempty" yaml:"distribution,omitempty"`
+		Mean         string `json:"mean,omitempty" yaml:"mean,omitempty"`
+		StdDev       string `json:"stddev,omitempty" yaml:"stddev,omitempty"`
+		Min          string `json:"min,omitempty" yaml:"min,omitempty"`
+		Max          string `json:"max,omitempty" yaml:"max,omitempty"`
+	}
+
+	// NoopStockout runs a zone out of capacity for a machine type. An empty zone or machine
+	// type matches all of them. Rate is the probability, from 0 to 1, that an attempt hits
+	// the stockout; it defaults to 1.
+	NoopStockout struct {
+		Zone        string  `json:"zone,omitempty" yaml:"zone,omitempty"`
+		MachineType string  `json:"machine_type,omitempty" yaml:"machine_type,omitempty"`
+		Rate        float64 `json:"rate,omitempty" yaml:"rate,omitempty"`
 	}
 
 	// disk provides disk size and type.
@@ -441,6 +711,8 @@ type EnvConfig struct {
 		BYOIInitTimeout         time.Duration `envconfig:"NOMAD_BYOI_INIT_TIMEOUT" default:"30m"`
 		InitTimeout             time.Duration `envconfig:"NOMAD_INIT_TIMEOUT" default:"1m"`
 		DestroyTimeout          time.Duration `envconfig:"NOMAD_DESTROY_TIMEOUT" default:"1m"`
+		HibernateTimeout        time.Duration `envconfig:"NOMAD_HIBERNATE_TIMEOUT" default:"2m"`
+		ResumeTimeout           time.Duration `envconfig:"NOMAD_RESUME_TIMEOUT" default:"2m"`
 		GlobalAccount           string        `envconfig:"NOMAD_GLOBAL_ACCOUNT" default:"PAID_POOL"`
 		DestroyRetryAttempts    int           `envconfig:"NOMAD_DESTROY_RETRY_ATTEMPTS" default:"1"`
 		MinNomadCPUMhz          int           `envconfig:"NOMAD_MIN_CPU_MHZ" default:"40"`
@@ -477,6 +749,22 @@ type EnvConfig struct {
 			IntervalHours int `envconfig:"DLITE_SCHEDULER_HISTORY_CLEANUP_INTERVAL_HOURS" default:"24"`
 			RetentionDays int `envconfig:"DLITE_SCHEDULER_HISTORY_CLEANUP_RETENTION_DAYS" default:"60"`
 		}
+		// Reconcile destroys the VMs carrying the identity labels of a pool that have no
+		// instance row and deletes the rows whose VM is gone. Hosted mode only.
+		Reconcile struct {
+			Enabled         bool `envconfig:"DLITE_SCHEDULER_RECONCILE_ENABLED" default:"false"`
+			IntervalMins    int  `envconfig:"DLITE_SCHEDULER_RECONCILE_INTERVAL_MINS" default:"30"`
+			GracePeriodMins int  `envconfig:"DLITE_SCHEDULER_RECONCILE_GRACE_PERIOD_MINS" default:"60"`
+			DryRun          bool `envconfig:"DLITE_SCHEDULER_RECONCILE_DRY_RUN" default:"false"`
+		}
+		// OrphanCollector deletes the persistent disks and capacity reservations carrying
+		// the identity labels of a pool that no row references. Hosted mode only.
+		OrphanCollector struct {
+			Enabled         bool `envconfig:"DLITE_SCHEDULER_ORPHAN_COLLECTOR_ENABLED" default:"false"`
+			IntervalMins    int  `envconfig:"DLITE_SCHEDULER_ORPHAN_COLLECTOR_INTERVAL_MINS" default:"60"`
+			GracePeriodMins int  `envconfig:"DLITE_SCHEDULER_ORPHAN_COLLECTOR_GRACE_PERIOD_MINS" default:"120"`
+			DryRun          bool `envconfig:"DLITE_SCHEDULER_ORPHAN_COLLECTOR_DRY_RUN" default:"false"`
+		}
 		Scaler struct {
 			Enabled                 bool     `envconfig:"DLITE_SCHEDULER_SCALER_ENABLED" default:"false"`
 			WindowDurationMins      int      `envconfig:"DLITE_SCHEDULER_SCALER_WINDOW_DURATION_MINS" default:"30"`
@@ -488,12 +776,32 @@ type EnvConfig struct {
 			RecentUsageMinInstances int      `envconfig:"DLITE_SCHEDULER_SCALER_RECENT_USAGE_MIN_INSTANCES" default:"0"`
 			ScalePercent            float64  `envconfig:"DLITE_SCHEDULER_SCALER_SCALE_PERCENT" default:"100"`
 		}
+		// HibernationPolicy keeps the free instances of hibernate-capable pools running,
+		// hibernated or destroyed based on the predicted demand, instead of hibernating every
+		// instance once set up. Hosted mode only.
+		HibernationPolicy struct {
+			Enabled                  bool    `envconfig:"DLITE_SCHEDULER_HIBERNATION_POLICY_ENABLED" default:"false"`
+			IntervalMins             int     `envconfig:"DLITE_SCHEDULER_HIBERNATION_POLICY_INTERVAL_MINS" default:"5"`
+			CostWeight               float64 `envconfig:"DLITE_SCHEDULER_HIBERNATION_POLICY_COST_WEIGHT" default:"0.1"`
+			DefaultResumeLatencySecs int     `envconfig:"DLITE_SCHEDULER_HIBERNATION_POLICY_DEFAULT_RESUME_LATENCY_SECS" default:"120"`
+			DryRun                   bool    `envconfig:"DLITE_SCHEDULER_HIBERNATION_POLICY_DRY_RUN" default:"false"`
+		}
 		Predictor struct {
+			// Type selects the predictor: ema_weekend_decay or holt_winters.
+			Type            string  `envconfig:"DLITE_PREDICTOR_TYPE" default:"ema_weekend_decay"`
 			EMAPeriod       int     `envconfig:"DLITE_PREDICTOR_EMA_PERIOD" default:"3"`
 			EMAWeight       float64 `envconfig:"DLITE_PREDICTOR_EMA_WEIGHT" default:"0.85"`
 			MinInstances    int     `envconfig:"DLITE_PREDICTOR_MIN_INSTANCES" default:"0"`
 			MaxLookbackDays int     `envconfig:"DLITE_PREDICTOR_MAX_LOOKBACK_DAYS" default:"4"`
 			TargetWeekdays  int     `envconfig:"DLITE_PREDICTOR_TARGET_WEEKDAYS" default:"2"`
+			HoltWinters     struct {
+				Alpha         float64 `envconfig:"DLITE_PREDICTOR_HOLT_WINTERS_ALPHA" default:"0.2"`
+				Beta          float64 `envconfig:"DLITE_PREDICTOR_HOLT_WINTERS_BETA" default:"0.01"`
+				DailyGamma    float64 `envconfig:"DLITE_PREDICTOR_HOLT_WINTERS_DAILY_GAMMA" default:"0.2"`
+				WeeklyGamma   float64 `envconfig:"DLITE_PREDICTOR_HOLT_WINTERS_WEEKLY_GAMMA" default:"0.3"`
+				BucketMins    int     `envconfig:"DLITE_PREDICTOR_HOLT_WINTERS_BUCKET_MINS" default:"30"`
+				LookbackWeeks int     `envconfig:"DLITE_PREDICTOR_HOLT_WINTERS_LOOKBACK_WEEKS" default:"3"`
+			}
 		}
 	}
 
@@ -502,6 +810,7 @@ type EnvConfig struct {
 		ReusePool                    bool     `envconfig:"DRONE_REUSE_POOL" default:"false"`
 		BusyMaxAge                   int64    `envconfig:"DRONE_SETTINGS_BUSY_MAX_AGE" default:"24"`
 		FreeMaxAge                   int64    `envconfig:"DRONE_SETTINGS_FREE_MAX_AGE" default:"72"`
+		BusyLeaseTTLMinutes          int64    `envconfig:"DRONE_SETTINGS_BUSY_LEASE_TTL_MINUTES" default:"0"`
 		FreeCapacityMaxAgeMinutes    int64    `envconfig:"DRONE_SETTINGS_FREE_CAPACITY_MAX_AGE" default:"10"`
 		MinPoolSize                  int      `envconfig:"DRONE_MIN_POOL_SIZE" default:"1"`
 		MaxPoolSize                  int      `envconfig:"DRONE_MAX_POOL_SIZE" default:"2"`
@@ -511,6 +820,7 @@ type EnvConfig struct {
 		PluginBinaryURI              string   `envconfig:"DRONE_PLUGIN_BINARY_URI" default:"https://github.com/drone/plugin/releases/download/v3.9.7"`
 		PluginBinaryFallbackURI      string   `envconfig:"DRONE_PLUGIN_BINARY_FALLBACK_URI" default:"https://app.harness.io/storage/harness-download/harness-ti/harness-plugin/v3.9.7"`
 		PurgerTime                   int64    `envconfig:"DRONE_PURGER_TIME_MINUTES" default:"15"`
+		PurgerDryRun                 bool     `envconfig:"DRONE_PURGER_DRY_RUN" default:"false"`
 		AutoInjectionBinaryURI       string   `envconfig:"DRONE_HARNESS_AUTO_INJECTION_BINARY_URI" default:"https://app.harness.io/storage/harness-download/harness-ti/auto-injection/1.0.19"`
 		AnnotationsBinaryURI         string   `envconfig:"DRONE_ANNOTATIONS_CLI_URI" default:"https://storage.googleapis.com/harness-ti/hcli/v0.23/"`
 		AnnotationsBinaryFallbackURI string   `envconfig:"DRONE_ANNOTATIONS_CLI_FALLBACK_URI" default:"https://app.harness.io/storage/harness-download/harness-ti/hcli/v0.23/"`
@@ -519,6 +829,10 @@ type EnvConfig struct {
 		TmateBinaryURI               string   `envconfig:"DRONE_TMATE_BINARY_URI" default:"https://github.com/harness/tmate/releases/download/1.0/"`
 		TmateBinaryFallbackURI       string   `envconfig:"DRONE_TMATE_BINARY_FALLBACK_URI" default:"https://app.harness.io/storage/harness-download/harness-ti/harness-tmate/1.0/"`
 		FallbackPoolIDs              []string `envconfig:"DRONE_FALLBACK_POOL_IDS"`
+		// ImageRolloutMaxUnavailable is how many free instances of a pool are replaced at a time
+		// when the pool image changes, 0 (the default) leaves them until they reach the free max age.
+		ImageRolloutMaxUnavailable  int   `envconfig:"DRONE_SETTINGS_IMAGE_ROLLOUT_MAX_UNAVAILABLE"`
+		ImageRolloutIntervalMinutes int64 `envconfig:"DRONE_SETTINGS_IMAGE_ROLLOUT_INTERVAL" default:"5"`
 	}
 
 	Egress struct {
@@ -615,6 +929,8 @@ func (c *EnvConfig) NomadConfig() *types.NomadConfig {
 		InitTimeout:             c.Nomad.InitTimeout,
 		ByoiInitTimeout:         c.Nomad.BYOIInitTimeout,
 		DestroyTimeout:          c.Nomad.DestroyTimeout,
+		HibernateTimeout:        c.Nomad.HibernateTimeout,
+		ResumeTimeout:           c.Nomad.ResumeTimeout,
 		GlobalAccount:           c.Nomad.GlobalAccount,
 		DestroyRetryAttempts:    c.Nomad.DestroyRetryAttempts,
 		MinNomadCPUMhz:          c.Nomad.MinNomadCPUMhz,
@@ -698,6 +1014,18 @@ func (s *Instance) newSpec() (interface{}, error) {
 		return new(Azure), nil
 	case string(types.DigitalOcean):
 		return new(DigitalOcean), nil
+	case string(types.Hetzner):
+		return new(Hetzner), nil
+	case string(types.Libvirt):
+		return new(Libvirt), nil
+	case string(types.Firecracker):
+		return new(Firecracker), nil
+	case string(types.Proxmox):
+		return new(Proxmox), nil
+	case string(types.OpenStack):
+		return new(OpenStack), nil
+	case string(types.KubeVirt):
+		return new(KubeVirt), nil
 	case string(types.Google), "gcp":
 		return new(Google), nil
 	case string(types.VMFusion):
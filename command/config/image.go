@@ -0,0 +1,107 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+// imagePath returns the keys of the image reference in the spec of a pool of
+// the given type, for the drivers that can bake images.
+func imagePath(instanceType string) ([]string, error) {
+	switch instanceType {
+	case string(types.Amazon), "aws":
+		return []string{"spec", "ami"}, nil
+	case string(types.Google), "gcp":
+		return []string{"spec", "image"}, nil
+	case string(types.Azure):
+		return []string{"spec", "image", "id"}, nil
+	default:
+		return nil, fmt.Errorf("pool type %q has no image reference to update", instanceType)
+	}
+}
+
+// SetImage returns the pool file with the image reference of the named pool
+// replaced, leaving the rest of the file, comments included, as it was. Only
+// the base spec of the pool is updated: tenants and variants that override
+// the image keep their own.
+func SetImage(data []byte, poolName, image string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, fmt.Errorf("pool file is empty")
+	}
+	instances := mappingValue(doc.Content[0], "instances")
+	if instances == nil || instances.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("pool file has no instances")
+	}
+
+	var pool *yaml.Node
+	for _, instance := range instances.Content {
+		if name := mappingValue(instance, "name"); name != nil && name.Value == poolName {
+			pool = instance
+			break
+		}
+	}
+	if pool == nil {
+		return nil, fmt.Errorf("pool %q not found in the pool file", poolName)
+	}
+	var instanceType string
+	if t := mappingValue(pool, "type"); t != nil {
+		instanceType = t.Value
+	}
+	path, err := imagePath(instanceType)
+	if err != nil {
+		return nil, err
+	}
+
+	node := pool
+	for _, key := range path[:len(path)-1] {
+		child := mappingValue(node, key)
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+		}
+		if child.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("pool %q: %s is not a mapping", poolName, key)
+		}
+		node = child
+	}
+	last := path[len(path)-1]
+	if value := mappingValue(node, last); value != nil {
+		value.Kind, value.Tag, value.Value = yaml.ScalarNode, "!!str", image
+	} else {
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: last},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: image})
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2) //nolint:mnd
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mappingValue returns the value of the key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+const setImagePoolFile = `version: "1"
+instances:
+  # the default linux pool
+  - name: linux
+    type: amazon
+    pool: 2
+    spec:
+      ami: ami-old # replaced by baking
+      size: t3.large
+  - name: gcp
+    type: google
+    spec:
+      size: e2-standard-4
+  - name: windows
+    type: azure
+    spec:
+      image:
+        publisher: MicrosoftWindowsServer
+  - name: mac
+    type: anka
+    spec:
+      vm_id: mac
+`
+
+func TestSetImage(t *testing.T) {
+	tests := []struct {
+		pool  string
+		image string
+		want  []string
+	}{
+		{"linux", "ami-new", []string{"ami: ami-new # replaced by baking", "# the default linux pool", "size: t3.large"}},
+		{"gcp", "proj/global/images/gcp-1", []string{"image: proj/global/images/gcp-1"}},
+		{"windows", "/subscriptions/s/galleries/g/images/w/versions/1.0.0", []string{"id: /subscriptions/s/galleries/g/images/w/versions/1.0.0", "publisher: MicrosoftWindowsServer"}},
+	}
+	for _, tt := range tests {
+		out, err := SetImage([]byte(setImagePoolFile), tt.pool, tt.image)
+		if err != nil {
+			t.Fatalf("%s: SetImage returned error: %v", tt.pool, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("%s: expected output to contain %q, got:\n%s", tt.pool, want, out)
+			}
+		}
+		if strings.Count(string(out), tt.image) != 1 {
+			t.Errorf("%s: expected the image to be set once, got:\n%s", tt.pool, out)
+		}
+
+		pool, err := Parse(strings.NewReader(string(out)))
+		if err != nil {
+			t.Fatalf("%s: updated pool file does not parse: %v", tt.pool, err)
+		}
+		if len(pool.Instances) != 4 {
+			t.Errorf("%s: expected 4 pools, got %d", tt.pool, len(pool.Instances))
+		}
+	}
+}
+
+func TestSetImage_Errors(t *testing.T) {
+	if _, err := SetImage([]byte(setImagePoolFile), "missing", "img"); err == nil {
+		t.Error("expected an error for an unknown pool")
+	}
+	if _, err := SetImage([]byte(setImagePoolFile), "mac", "img"); err == nil {
+		t.Error("expected an error for a driver without an image reference")
+	}
+}
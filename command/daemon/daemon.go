@@ -147,6 +147,10 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 			Errorln("delegate: failed to start instance purger")
 		return err
 	}
+	poolManager.StartInterruptionMonitor(ctx)
+	poolManager.StartImageRollout(ctx,
+		time.Minute*time.Duration(env.Settings.ImageRolloutIntervalMinutes),
+		env.Settings.ImageRolloutMaxUnavailable)
 
 	opts := engine.Opts{
 		Repopulate: true,
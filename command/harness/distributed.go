@@ -2,6 +2,7 @@ package harness
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -58,6 +59,11 @@ func SetupDistributedMode(cfg DistributedSetupConfig) (*DistributedSetupResult,
 	managerCfg.StageOwnerStore = stageOwnerStore
 	managerCfg.CapacityReservationStore = capacityReservationStore
 	managerCfg.Hosted = cfg.Hosted
+	// the hibernation policy job is only registered with the scaler stores. Without the job, or
+	// in dry run where it only logs its moves, free instances keep hibernating as configured.
+	hibernationPolicy := cfg.Hosted && cfg.Env.Scheduler.HibernationPolicy.Enabled &&
+		instanceStore != nil && utilizationHistoryStore != nil
+	managerCfg.HibernationPolicy = hibernationPolicy && !cfg.Env.Scheduler.HibernationPolicy.DryRun
 	poolManager := drivers.NewDistributedManager(
 		drivers.NewManagerFromConfig(&managerCfg),
 		outboxStore,
@@ -104,6 +110,31 @@ func SetupDistributedMode(cfg DistributedSetupConfig) (*DistributedSetupResult,
 		sched.Register(historyCleanupJob)
 	}
 
+	// Identity labels, which reconciliation finds the VMs by, are only written in hosted mode
+	if cfg.Hosted && cfg.Env.Scheduler.Reconcile.Enabled {
+		reconcileJob := jobs.NewReconcileJob(
+			poolManager,
+			time.Duration(cfg.Env.Scheduler.Reconcile.IntervalMins)*time.Minute,
+			drivers.ReconcileOptions{
+				GracePeriod: time.Duration(cfg.Env.Scheduler.Reconcile.GracePeriodMins) * time.Minute,
+				DryRun:      cfg.Env.Scheduler.Reconcile.DryRun,
+			},
+		)
+		sched.Register(reconcileJob)
+	}
+
+	if cfg.Hosted && cfg.Env.Scheduler.OrphanCollector.Enabled {
+		orphanCollectorJob := jobs.NewOrphanCollectorJob(
+			poolManager,
+			time.Duration(cfg.Env.Scheduler.OrphanCollector.IntervalMins)*time.Minute,
+			drivers.OrphanCollectionOptions{
+				GracePeriod: time.Duration(cfg.Env.Scheduler.OrphanCollector.GracePeriodMins) * time.Minute,
+				DryRun:      cfg.Env.Scheduler.OrphanCollector.DryRun,
+			},
+		)
+		sched.Register(orphanCollectorJob)
+	}
+
 	// Setup the pool
 	poolConfig, err := SetupPoolWithEnv(cfg.Ctx, cfg.Env, poolManager, cfg.PoolFile, cfg.Metrics)
 	if err != nil {
@@ -129,18 +160,10 @@ func SetupDistributedMode(cfg DistributedSetupConfig) (*DistributedSetupResult,
 		scalablePools := buildScalablePools(poolConfig)
 
 		// Create predictor with config from environment
-		predictorConfig := predictor.PredictorConfig{
-			EMAPeriod:        cfg.Env.Scheduler.Predictor.EMAPeriod,
-			EMAWeight:        cfg.Env.Scheduler.Predictor.EMAWeight,
-			WeekDecayFactors: cfg.Env.PredictorConfig(),
-			MinInstances:     cfg.Env.Scheduler.Predictor.MinInstances,
-			MaxLookbackDays:  cfg.Env.Scheduler.Predictor.MaxLookbackDays,
-			TargetWeekdays:   cfg.Env.Scheduler.Predictor.TargetWeekdays,
+		pred, err := newPredictor(cfg.Env, utilizationHistoryStore)
+		if err != nil {
+			return nil, err
 		}
-		pred := predictor.NewEMAWeekendDecayPredictor(
-			utilizationHistoryStore,
-			predictorConfig,
-		)
 
 		// Create scaler
 		scaler := jobs.NewScaler(
@@ -170,8 +193,28 @@ func SetupDistributedMode(cfg DistributedSetupConfig) (*DistributedSetupResult,
 			"enabled":         scalerConfig.Enabled,
 			"window_duration": scalerConfig.WindowDuration,
 			"lead_time":       scalerConfig.LeadTime,
+			"predictor":       pred.Name(),
 			"pools":           len(scalablePools),
 		}).Infoln("scaler registered")
+
+		if hibernationPolicy {
+			hibernationPolicyJob := jobs.NewHibernationPolicyJob(
+				poolManager,
+				pred,
+				instanceStore,
+				utilizationHistoryStore,
+				cfg.Metrics,
+				scalerConfig,
+				jobs.HibernationPolicyConfig{
+					Interval:             time.Duration(cfg.Env.Scheduler.HibernationPolicy.IntervalMins) * time.Minute,
+					CostWeight:           cfg.Env.Scheduler.HibernationPolicy.CostWeight,
+					DefaultResumeLatency: time.Duration(cfg.Env.Scheduler.HibernationPolicy.DefaultResumeLatencySecs) * time.Second,
+					DryRun:               cfg.Env.Scheduler.HibernationPolicy.DryRun,
+				},
+				scalablePools,
+			)
+			sched.Register(hibernationPolicyJob)
+		}
 	}
 
 	return &DistributedSetupResult{
@@ -199,6 +242,35 @@ func RegisterDistributedMetrics(ctx context.Context, metrics *metric.Metrics, re
 	metrics.UpdateWarmPoolCount(ctx)
 }
 
+// newPredictor creates the predictor selected by the environment configuration.
+func newPredictor(env *config.EnvConfig, historyStore store.UtilizationHistoryStore) (predictor.Predictor, error) {
+	switch env.Scheduler.Predictor.Type {
+	case predictor.TypeEMAWeekendDecay, "":
+		return predictor.NewEMAWeekendDecayPredictor(historyStore, predictor.PredictorConfig{
+			EMAPeriod:        env.Scheduler.Predictor.EMAPeriod,
+			EMAWeight:        env.Scheduler.Predictor.EMAWeight,
+			WeekDecayFactors: env.PredictorConfig(),
+			MinInstances:     env.Scheduler.Predictor.MinInstances,
+			MaxLookbackDays:  env.Scheduler.Predictor.MaxLookbackDays,
+			TargetWeekdays:   env.Scheduler.Predictor.TargetWeekdays,
+		}), nil
+	case predictor.TypeHoltWinters:
+		hw := env.Scheduler.Predictor.HoltWinters
+		return predictor.NewHoltWintersPredictor(historyStore, predictor.HoltWintersConfig{
+			Alpha:         hw.Alpha,
+			Beta:          hw.Beta,
+			DailyGamma:    hw.DailyGamma,
+			WeeklyGamma:   hw.WeeklyGamma,
+			Bucket:        time.Duration(hw.BucketMins) * time.Minute,
+			LookbackWeeks: hw.LookbackWeeks,
+			MinInstances:  env.Scheduler.Predictor.MinInstances,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown predictor type %q, expected %q or %q",
+			env.Scheduler.Predictor.Type, predictor.TypeEMAWeekendDecay, predictor.TypeHoltWinters)
+	}
+}
+
 // buildScalablePools converts pool configuration to scalable pool definitions.
 func buildScalablePools(poolConfig *config.PoolFile) []jobs.ScalablePool {
 	var scalablePools []jobs.ScalablePool
@@ -249,6 +321,7 @@ func buildScalableVariants(variants []types.PoolVariant) []jobs.ScalableVariant
 				VariantID:            variant.VariantID,
 				ImageName:            variant.ImageName,
 				MachineType:          variant.MachineType,
+				MachineTypeFallbacks: variant.MachineTypeFallbacks,
 				NestedVirtualization: variant.NestedVirtualization,
 				Hibernate:            variant.Hibernate,
 				Zones:                variant.Zones,
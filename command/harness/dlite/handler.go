@@ -32,6 +32,8 @@ func Handler(p *poller.Poller, d *dliteCommand) http.Handler {
 	r.Mount("/maintenance_mode", maintenanceModeRouter(p, d))
 	r.Mount("/metrics", promhttp.Handler())
 	r.Get("/healthz", handleHealthz)
+	r.Get("/purger/report", harness.NewHTTPHandlers(d.vmService).HandlePurgerReport)
+	r.Post("/heartbeat", harness.NewHTTPHandlers(d.vmService).HandleHeartbeat)
 
 	return r
 }
@@ -9,6 +9,8 @@ import (
 
 type VMTaskExecutionResponse struct {
 	ErrorMessage           string                    `json:"error_message"`
+	ErrorCode              string                    `json:"error_code,omitempty"`
+	Retryable              bool                      `json:"retryable,omitempty"`
 	IPAddress              string                    `json:"ip_address"`
 	OutputVars             map[string]string         `json:"output_vars"`
 	ServiceStatuses        []VMServiceStatus         `json:"service_statuses"`
@@ -7,6 +7,8 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/wings-software/dlite/client"
 	"github.com/wings-software/dlite/httphelper"
+
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
 )
 
 // decodeTask decodes a task from the HTTP request body.
@@ -43,9 +45,15 @@ func unmarshalTaskRequest(w http.ResponseWriter, taskBytes []byte, req interface
 	return true
 }
 
-// writeErrorResponse writes an error response.
+// writeErrorResponse writes an error response. Spot interruptions are flagged
+// as retryable so the stage can be retried on a new instance.
 func writeErrorResponse(w http.ResponseWriter, err error) {
-	httphelper.WriteJSON(w, failedResponse(err.Error()), httpFailed)
+	resp := failedResponse(err.Error())
+	if code, ok := ierrors.SpotErrorCode(err); ok {
+		resp.ErrorCode = code
+		resp.Retryable = true
+	}
+	httphelper.WriteJSON(w, resp, httpFailed)
 }
 
 // writeSuccessResponse writes a success response.
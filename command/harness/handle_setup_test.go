@@ -42,13 +42,15 @@ func newTestInitMetrics() *metric.Metrics {
 // the methods handleSetup actually calls have configurable Func fields; everything else is a
 // harmless no-op/zero-value stub.
 type fakeIManager struct {
-	existsFunc           func(name string) bool
-	provisionFunc        func(ctx context.Context) (*types.Instance, *types.CapacityReservation, bool, string, error)
-	startInstanceFunc    func(ctx context.Context, poolName, instanceID string) (*types.Instance, error)
-	updateFunc           func(ctx context.Context, instance *types.Instance) error
-	destroyFunc          func(ctx context.Context, poolName, instanceID string) error
-	destroyCapacityFunc  func(ctx context.Context, capacity *types.CapacityReservation) error
-	getInstanceByStageID func(ctx context.Context, poolName, stageID string) (*types.Instance, error)
+	existsFunc            func(name string) bool
+	provisionFunc         func(ctx context.Context) (*types.Instance, *types.CapacityReservation, bool, string, error)
+	startInstanceFunc     func(ctx context.Context, poolName, instanceID string) (*types.Instance, error)
+	updateFunc            func(ctx context.Context, instance *types.Instance) error
+	destroyFunc           func(ctx context.Context, poolName, instanceID string) error
+	destroyCapacityFunc   func(ctx context.Context, capacity *types.CapacityReservation) error
+	getInstanceByStageID  func(ctx context.Context, poolName, stageID string) (*types.Instance, error)
+	checkInterruptionFunc func(ctx context.Context, poolName string, instance *types.Instance) error
+	renewLeaseFunc        func(ctx context.Context, poolName, instanceID string) (time.Time, error)
 }
 
 //nolint:gocritic // unnamed results mirror drivers.InstanceProvisioner's Provision signature
@@ -123,6 +125,18 @@ func (f *fakeIManager) SetInstanceTags(context.Context, string, *types.Instance,
 	return nil
 }
 func (f *fakeIManager) InstanceLogs(context.Context, string, string) (string, error) { return "", nil }
+func (f *fakeIManager) CheckInterruption(ctx context.Context, poolName string, instance *types.Instance) error {
+	if f.checkInterruptionFunc != nil {
+		return f.checkInterruptionFunc(ctx, poolName, instance)
+	}
+	return nil
+}
+func (f *fakeIManager) RenewLease(ctx context.Context, poolName, instanceID string) (time.Time, error) {
+	if f.renewLeaseFunc != nil {
+		return f.renewLeaseFunc(ctx, poolName, instanceID)
+	}
+	return time.Time{}, nil
+}
 
 func (f *fakeIManager) PingDriver(context.Context) error { return nil }
 func (f *fakeIManager) GetHealthCheckTimeout(string, types.DriverType, bool, bool) time.Duration {
@@ -144,7 +158,9 @@ func (f *fakeIManager) GetStartStepTimeout() time.Duration  { return time.Minute
 func (f *fakeIManager) StartInstancePurger(context.Context, time.Duration, time.Duration, time.Duration, time.Duration) error {
 	return nil
 }
-func (f *fakeIManager) SetMetrics(drivers.MetricsRecorder) {}
+func (f *fakeIManager) SetMetrics(drivers.MetricsRecorder)                    {}
+func (f *fakeIManager) StartInterruptionMonitor(context.Context)              {}
+func (f *fakeIManager) StartImageRollout(context.Context, time.Duration, int) {}
 
 var _ drivers.IManager = (*fakeIManager)(nil)
 
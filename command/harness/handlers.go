@@ -11,8 +11,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/wings-software/dlite/httphelper"
 
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
 	"github.com/drone-runners/drone-runner-aws/app/httprender"
-	errors "github.com/drone-runners/drone-runner-aws/app/types"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
 	"github.com/drone-runners/drone-runner-aws/command/harness/common"
 	"github.com/drone-runners/drone-runner-aws/command/harness/storage"
 )
@@ -37,6 +38,8 @@ func (h *HTTPHandlers) Router() http.Handler {
 	mux.Post("/destroy", h.HandleDestroy)
 	mux.Post("/step", h.HandleStep)
 	mux.Post("/suspend", h.HandleSuspend)
+	mux.Post("/heartbeat", h.HandleHeartbeat)
+	mux.Get("/purger/report", h.HandlePurgerReport)
 	mux.Mount("/metrics", promhttp.Handler())
 	mux.Get("/healthz", h.HandleHealthz)
 
@@ -151,6 +154,55 @@ func (h *HTTPHandlers) HandleSuspend(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// HandleHeartbeat handles instance lease renewal requests.
+func (h *HTTPHandlers) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	req := &HeartbeatVMRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		logrus.WithError(err).Error("could not decode heartbeat request body")
+		httprender.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	resp, err := h.service.Heartbeat(r.Context(), req)
+	if err != nil {
+		logrus.WithField("stage_runtime_id", req.StageRuntimeID).
+			WithField("instance_id", req.InstanceID).
+			WithError(err).Error("could not renew the instance lease")
+		writeError(w, err)
+		return
+	}
+
+	httprender.OK(w, resp)
+}
+
+// HandlePurgerReport lists the instances and capacity reservations the next
+// purger run would act on, optionally filtered by the pool URL parameter.
+func (h *HTTPHandlers) HandlePurgerReport(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.service.PurgerReport(r.Context())
+	if err != nil && entries == nil {
+		logrus.WithError(err).Error("could not explain the purger")
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Warn("purger report is incomplete")
+	}
+
+	if pool := r.URL.Query().Get("pool"); pool != "" {
+		filtered := make([]*drivers.PurgerReportEntry, 0, len(entries))
+		for _, entry := range entries {
+			if entry.Pool == pool {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+	if entries == nil {
+		entries = []*drivers.PurgerReportEntry{}
+	}
+	httprender.OK(w, entries)
+}
+
 // HandleDestroy handles VM destroy requests.
 func (h *HTTPHandlers) HandleDestroy(w http.ResponseWriter, r *http.Request) {
 	// Legacy request format for backward compatibility.
@@ -191,12 +243,29 @@ func (h *HTTPHandlers) HandleDestroy(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// retryableErrorResponse is written for failures the client should retry on
+// a new instance. Code identifies the failure, e.g. spot_interrupted.
+type retryableErrorResponse struct {
+	Message   string `json:"error_msg"`
+	Code      string `json:"error_code"`
+	Retryable bool   `json:"retryable"`
+}
+
 // writeError writes an appropriate HTTP error response based on the error type.
 func writeError(w http.ResponseWriter, err error) {
+	if code, ok := ierrors.SpotErrorCode(err); ok {
+		httphelper.WriteJSON(w, &retryableErrorResponse{
+			Message:   err.Error(),
+			Code:      code,
+			Retryable: true,
+		}, http.StatusServiceUnavailable)
+		return
+	}
+
 	switch err.(type) {
-	case *errors.BadRequestError:
+	case *ierrors.BadRequestError:
 		httphelper.WriteBadRequest(w, err)
-	case *errors.NotFoundError:
+	case *ierrors.NotFoundError:
 		httphelper.WriteNotFound(w, err)
 	default:
 		httphelper.WriteInternalError(w, err)
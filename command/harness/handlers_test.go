@@ -0,0 +1,71 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "bad request", err: ierrors.NewBadRequestError("bad"), wantStatus: http.StatusBadRequest},
+		{name: "not found", err: ierrors.NewNotFoundError("missing"), wantStatus: http.StatusNotFound},
+		{name: "internal", err: fmt.Errorf("boom"), wantStatus: http.StatusInternalServerError},
+		{
+			name:       "wrapped spot interruption",
+			err:        fmt.Errorf("could not provision a VM from the pool: %w", &ierrors.ErrSpotInterrupted{Driver: "amazon", InstanceID: "i-1"}),
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "spot preemption",
+			err: &ierrors.ErrSpotPreempted{
+				ErrSpotInterrupted: ierrors.ErrSpotInterrupted{Driver: "google", InstanceID: "123"},
+				Zone:               "us-central1-a",
+			},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeError(w, tt.err)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestWriteErrorSpotInterruptedBody(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeError(w, &ierrors.ErrSpotInterrupted{Driver: "amazon", InstanceID: "i-1"})
+
+	out := retryableErrorResponse{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&out))
+	assert.Equal(t, ierrors.ErrCodeSpotInterrupted, out.Code)
+	assert.True(t, out.Retryable)
+	assert.Contains(t, out.Message, "i-1")
+}
+
+func TestWriteErrorSpotPreemptedBody(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeError(w, fmt.Errorf("step failed: %w", &ierrors.ErrSpotPreempted{
+		ErrSpotInterrupted: ierrors.ErrSpotInterrupted{Driver: "google", InstanceID: "123"},
+		Zone:               "us-central1-a",
+	}))
+
+	out := retryableErrorResponse{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&out))
+	assert.Equal(t, ierrors.ErrCodeSpotPreempted, out.Code)
+	assert.True(t, out.Retryable)
+	assert.Contains(t, out.Message, "us-central1-a")
+}
@@ -0,0 +1,110 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/store"
+)
+
+type HeartbeatVMRequest struct {
+	PoolID         string `json:"pool_id"`
+	StageRuntimeID string `json:"stage_runtime_id"`
+	InstanceID     string `json:"instance_id"`
+}
+
+type HeartbeatVMResponse struct {
+	InstanceID string `json:"instance_id"`
+	// LeaseExpiry is the unix time the lease lapses at unless renewed again,
+	// zero when leases are disabled.
+	LeaseExpiry int64 `json:"lease_expiry"`
+}
+
+// HandleHeartbeat renews the lease of the instance running a stage, for
+// stages that go longer than the lease TTL without calling /step.
+func HandleHeartbeat(
+	ctx context.Context,
+	r *HeartbeatVMRequest,
+	s store.StageOwnerStore,
+	poolManager drivers.IManager,
+) (*HeartbeatVMResponse, error) {
+	if r.StageRuntimeID == "" && r.InstanceID == "" {
+		return nil, ierrors.NewBadRequestError("either field 'stage_runtime_id' or 'instance_id' must be provided")
+	}
+
+	poolID := r.PoolID
+	if poolID == "" {
+		entity, err := s.Find(ctx, r.StageRuntimeID)
+		if err != nil || entity == nil {
+			return nil, ierrors.NewNotFoundError(fmt.Sprintf("failed to find stage owner entity for stage: %s", r.StageRuntimeID))
+		}
+		poolID = entity.PoolName
+	}
+
+	inst, err := getInstance(ctx, poolID, r.StageRuntimeID, r.InstanceID, poolManager)
+	if err != nil {
+		return nil, err
+	}
+	if inst == nil {
+		return nil, ierrors.NewNotFoundError(fmt.Sprintf("no instance found for stage: %s", r.StageRuntimeID))
+	}
+
+	expiry, err := poolManager.RenewLease(ctx, poolID, inst.ID)
+	if errors.Is(err, drivers.ErrLeaseNotHeld) {
+		return nil, ierrors.NewNotFoundError(fmt.Sprintf("instance %s is not busy", inst.ID))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to renew the lease of instance %s: %w", inst.ID, err)
+	}
+
+	resp := &HeartbeatVMResponse{InstanceID: inst.ID}
+	if !expiry.IsZero() {
+		resp.LeaseExpiry = expiry.Unix()
+	}
+	return resp, nil
+}
+
+// keepLeaseAlive renews the lease of a busy instance, then keeps renewing it
+// every third of its TTL until the returned function is called. Renewal is
+// best effort, a failed renewal is only logged.
+func keepLeaseAlive(ctx context.Context, poolManager drivers.IManager, poolID, instanceID string, logr *logrus.Entry) (stop func()) {
+	renew := func() time.Duration {
+		expiry, err := poolManager.RenewLease(ctx, poolID, instanceID)
+		if err != nil {
+			logr.WithError(err).Warnln("failed to renew the instance lease")
+			return 0
+		}
+		if expiry.IsZero() {
+			return 0
+		}
+		return time.Until(expiry) / 3 //nolint:mnd
+	}
+
+	interval := renew()
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				renew()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,59 @@
+package harness
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
+	"github.com/drone-runners/drone-runner-aws/types"
+)
+
+func TestHandleHeartbeat_RenewsLease(t *testing.T) {
+	expiry := time.Now().Add(10 * time.Minute)
+	var renewedPool, renewedID string
+	pm := &fakeIManager{
+		getInstanceByStageID: func(_ context.Context, _, stageID string) (*types.Instance, error) {
+			return &types.Instance{ID: "inst-" + stageID}, nil
+		},
+		renewLeaseFunc: func(_ context.Context, poolName, instanceID string) (time.Time, error) {
+			renewedPool, renewedID = poolName, instanceID
+			return expiry, nil
+		},
+	}
+
+	resp, err := HandleHeartbeat(context.Background(), &HeartbeatVMRequest{PoolID: "pool1", StageRuntimeID: "stage1"}, nil, pm)
+	if err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if renewedPool != "pool1" || renewedID != "inst-stage1" {
+		t.Errorf("expected the lease of inst-stage1 in pool1 to be renewed, got %s in %s", renewedID, renewedPool)
+	}
+	if resp.InstanceID != "inst-stage1" || resp.LeaseExpiry != expiry.Unix() {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestHandleHeartbeat_LeaseNotHeld(t *testing.T) {
+	pm := &fakeIManager{
+		getInstanceByStageID: func(context.Context, string, string) (*types.Instance, error) {
+			return &types.Instance{ID: "inst-1"}, nil
+		},
+		renewLeaseFunc: func(context.Context, string, string) (time.Time, error) {
+			return time.Time{}, drivers.ErrLeaseNotHeld
+		},
+	}
+
+	_, err := HandleHeartbeat(context.Background(), &HeartbeatVMRequest{PoolID: "pool1", StageRuntimeID: "stage1"}, nil, pm)
+	if _, ok := err.(*ierrors.NotFoundError); !ok {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestHandleHeartbeat_MissingIDs(t *testing.T) {
+	_, err := HandleHeartbeat(context.Background(), &HeartbeatVMRequest{PoolID: "pool1"}, nil, &fakeIManager{})
+	if _, ok := err.(*ierrors.BadRequestError); !ok {
+		t.Errorf("expected a bad request error, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package harness
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -112,6 +113,27 @@ func derivePoolImageForLog(poolManager drivers.IManager, pool string) string {
 	if d, ok := spec.(*config.DigitalOcean); ok {
 		return d.Image
 	}
+	if h, ok := spec.(*config.Hetzner); ok {
+		return h.Image
+	}
+	if l, ok := spec.(*config.Libvirt); ok {
+		return l.Image
+	}
+	if f, ok := spec.(*config.Firecracker); ok {
+		return f.RootFS
+	}
+	if kv, ok := spec.(*config.KubeVirt); ok {
+		if kv.SourcePVC.Name != "" {
+			return kv.SourcePVC.Name
+		}
+		return kv.Image
+	}
+	if o, ok := spec.(*config.OpenStack); ok {
+		return o.Image
+	}
+	if px, ok := spec.(*config.Proxmox); ok && px.Template != 0 {
+		return strconv.Itoa(px.Template)
+	}
 	if az, ok := spec.(*config.Azure); ok {
 		if az.Image.Version != "" {
 			return az.Image.Version
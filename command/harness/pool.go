@@ -63,6 +63,7 @@ func SetupPool(
 			Errorln("failed to start instance purger")
 		return configPool, err
 	}
+	poolManager.StartInterruptionMonitor(ctx)
 	// lets remove any old instances. Bootstrap cleanup is best-effort: log and
 	// continue so transient AWS/GCP errors don't crash-loop the runner on startup.
 	if !reusePool {
@@ -115,7 +116,7 @@ func SetupPoolWithEnv(ctx context.Context, env *config.EnvConfig, poolManager dr
 		logrus.WithError(confErr).Fatalln("Unable to load pool file, or use an in memory pool")
 	}
 
-	return SetupPool(ctx,
+	configPool, err := SetupPool(ctx,
 		configPool,
 		env.Runner.Name,
 		env.Passwords(),
@@ -126,6 +127,13 @@ func SetupPoolWithEnv(ctx context.Context, env *config.EnvConfig, poolManager dr
 		env.Settings.ReusePool,
 		env.Settings.FreeCapacityMaxAgeMinutes,
 		metrics)
+	if err != nil {
+		return configPool, err
+	}
+	poolManager.StartImageRollout(ctx,
+		time.Minute*time.Duration(env.Settings.ImageRolloutIntervalMinutes),
+		env.Settings.ImageRolloutMaxUnavailable)
+	return configPool, nil
 }
 
 func Cleanup(reusePool bool, poolManager drivers.IManager, destroyBusy, destroyFree bool) error {
@@ -0,0 +1,40 @@
+package harness
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/app/predictor"
+	"github.com/drone-runners/drone-runner-aws/command/config"
+)
+
+func TestNewPredictor_SelectsByType(t *testing.T) {
+	tests := []struct {
+		predictorType string
+		wantName      string
+	}{
+		{"", "ema-weekend-decay-predictor"},
+		{predictor.TypeEMAWeekendDecay, "ema-weekend-decay-predictor"},
+		{predictor.TypeHoltWinters, "holt-winters-predictor"},
+	}
+	for _, tt := range tests {
+		env := &config.EnvConfig{}
+		env.Scheduler.Predictor.Type = tt.predictorType
+
+		pred, err := newPredictor(env, nil)
+		if err != nil {
+			t.Fatalf("type %q: unexpected error: %v", tt.predictorType, err)
+		}
+		if pred.Name() != tt.wantName {
+			t.Errorf("type %q: expected predictor %q, got %q", tt.predictorType, tt.wantName, pred.Name())
+		}
+	}
+}
+
+func TestNewPredictor_UnknownType(t *testing.T) {
+	env := &config.EnvConfig{}
+	env.Scheduler.Predictor.Type = "arima"
+
+	if _, err := newPredictor(env, nil); err == nil {
+		t.Error("expected an error for an unknown predictor type")
+	}
+}
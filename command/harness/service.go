@@ -6,6 +6,7 @@ import (
 	"github.com/harness/lite-engine/api"
 
 	"github.com/drone-runners/drone-runner-aws/app/drivers"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
 	"github.com/drone-runners/drone-runner-aws/command/config"
 	"github.com/drone-runners/drone-runner-aws/metric"
 	"github.com/drone-runners/drone-runner-aws/store"
@@ -156,6 +157,11 @@ func (s *VMService) Suspend(ctx context.Context, req *SuspendVMRequest) error {
 	)
 }
 
+// Heartbeat renews the lease of the instance running a stage.
+func (s *VMService) Heartbeat(ctx context.Context, req *HeartbeatVMRequest) (*HeartbeatVMResponse, error) {
+	return HandleHeartbeat(ctx, req, s.stageOwnerStore, s.poolManager)
+}
+
 // ReserveCapacity handles capacity reservation requests.
 func (s *VMService) ReserveCapacity(ctx context.Context, req *CapacityReservationRequest) (*types.CapacityReservation, error) {
 	return HandleCapacityReservation(
@@ -200,6 +206,15 @@ func (s *VMService) CleanPools(ctx context.Context, destroyBusy, destroyFree boo
 	return s.poolManager.CleanPools(ctx, destroyBusy, destroyFree)
 }
 
+// PurgerReport returns what the next purger run would act on.
+func (s *VMService) PurgerReport(ctx context.Context) ([]*drivers.PurgerReportEntry, error) {
+	explainer, ok := s.poolManager.(drivers.PurgerExplainer)
+	if !ok {
+		return nil, ierrors.NewBadRequestError("the pool manager cannot explain its purger")
+	}
+	return explainer.ExplainPurger(ctx)
+}
+
 // VMServiceOption is a functional option for configuring VMService.
 type VMServiceOption func(*VMService)
 
@@ -281,6 +281,10 @@ func HandleSetup(
 				return nil, "", fmt.Errorf("could not create stage owner entity: %w", cerr)
 			}
 		}
+		// the stage holds the instance by its lease from now on, renewed by /step and /heartbeat
+		if _, leaseErr := poolManager.RenewLease(noContext, selectedPool, instance.ID); leaseErr != nil {
+			internalLogr.WithError(leaseErr).Warnln("failed to grant the instance lease")
+		}
 		if fallback {
 			// fallback metric records the first pool ID which was tried and the associated driver.
 			// We don't record final pool which was used as this metric is only used to get data about
@@ -624,9 +628,10 @@ func handleSetup(
 	}, metrics, pool, initZone, initVMType, initSource)
 	if healthErr != nil {
 		printError(buildLog, "Machine health check failed")
+		err = interruptedOr(ctx, poolManager, pool, instance, fmt.Errorf("failed to call lite-engine retry health: %w", healthErr))
 		go cleanUpInstanceFn(true)
 		initFailureReason = InitReasonHealthFailed
-		return nil, false, false, variantID, fmt.Errorf("failed to call lite-engine retry health: %w", healthErr)
+		return nil, false, false, variantID, err
 	}
 
 	printOK(buildLog, "Machine health check passed")
@@ -646,9 +651,10 @@ func handleSetup(
 	setupErr := runSetupPhase(ctx, client, &r.SetupRequest, poolManager.GetSetupTimeout(), metrics, pool, initZone, initVMType, initSource)
 	if setupErr != nil {
 		printError(buildLog, "Machine setup failed")
+		err = interruptedOr(ctx, poolManager, pool, instance, fmt.Errorf("failed to call setup lite-engine: %w", setupErr))
 		go cleanUpInstanceFn(true)
 		initFailureReason = InitReasonSetupFailed
-		return nil, false, false, variantID, fmt.Errorf("failed to call setup lite-engine: %w", setupErr)
+		return nil, false, false, variantID, err
 	}
 
 	return instance, warmed, hibernated, variantID, nil
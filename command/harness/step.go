@@ -89,6 +89,9 @@ func HandleStep(ctx context.Context,
 
 	ctx = logger.WithContext(ctx, logr)
 
+	// a step can outlive the lease of the instance, keep it renewed while it runs
+	defer keepLeaseAlive(ctx, poolManager, poolID, inst.ID, logr)()
+
 	// set the envs from previous step only for non-container steps
 	if r.Image == "" {
 		setPrevStepExportEnvs(r)
@@ -161,7 +164,7 @@ func HandleStep(ctx context.Context,
 	}
 	startStepResponse, err := client.RetryStartStep(ctx, &r.StartStepRequest, poolManager.GetStartStepTimeout())
 	if err != nil {
-		return nil, fmt.Errorf("failed to call LE.RetryStartStep: %w", err)
+		return nil, interruptedOr(ctx, poolManager, poolID, inst, fmt.Errorf("failed to call LE.RetryStartStep: %w", err))
 	}
 
 	logr.WithField("startStepResponse", startStepResponse).Traceln("LE.StartStep complete")
@@ -171,7 +174,7 @@ func HandleStep(ctx context.Context,
 	if !async {
 		pollResponse, err = client.RetryPollStep(ctx, &api.PollStepRequest{ID: r.StartStepRequest.ID}, StepTimeout)
 		if err != nil {
-			return nil, fmt.Errorf("failed to call LE.RetryPollStep: %w", err)
+			return nil, interruptedOr(ctx, poolManager, poolID, inst, fmt.Errorf("failed to call LE.RetryPollStep: %w", err))
 		}
 	}
 
@@ -201,6 +204,15 @@ func getInstance(ctx context.Context, poolID, stageRuntimeID,
 	return inst, nil
 }
 
+// interruptedOr returns the interruption error when the instance was reclaimed
+// by the cloud provider, so the stage can be retried, and err otherwise.
+func interruptedOr(ctx context.Context, poolManager drivers.IManager, poolID string, inst *types.Instance, err error) error {
+	if interruptErr := poolManager.CheckInterruption(ctx, poolID, inst); interruptErr != nil {
+		return interruptErr
+	}
+	return err
+}
+
 func setPrevStepExportEnvs(r *ExecuteVMRequest) {
 	prevStepExportEnvs := envState().Get(r.StageRuntimeID)
 	for k, v := range prevStepExportEnvs {
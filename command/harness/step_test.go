@@ -1,10 +1,14 @@
 package harness
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/drone-runners/drone-runner-aws/app/oshelp"
+	ierrors "github.com/drone-runners/drone-runner-aws/app/types"
 	"github.com/drone-runners/drone-runner-aws/command/config"
+	"github.com/drone-runners/drone-runner-aws/types"
 )
 
 const (
@@ -178,3 +182,21 @@ func TestConfigureEgressStepCAEnvVars(t *testing.T) {
 		}
 	})
 }
+
+func TestInterruptedOr(t *testing.T) {
+	leErr := errors.New("failed to call LE.RetryStartStep: connection refused")
+	inst := &types.Instance{ID: "i-1"}
+
+	poolManager := &fakeIManager{}
+	if got := interruptedOr(context.Background(), poolManager, "pool1", inst, leErr); got != leErr {
+		t.Errorf("expected the original error, got %v", got)
+	}
+
+	poolManager.checkInterruptionFunc = func(_ context.Context, poolName string, instance *types.Instance) error {
+		return &ierrors.ErrSpotInterrupted{Driver: "amazon", InstanceID: instance.ID}
+	}
+	var spotErr *ierrors.ErrSpotInterrupted
+	if got := interruptedOr(context.Background(), poolManager, "pool1", inst, leErr); !errors.As(got, &spotErr) {
+		t.Errorf("expected a spot interruption error, got %v", got)
+	}
+}
@@ -49,12 +49,28 @@ type Metrics struct {
 	GCPOperationRetriesCount *prometheus.CounterVec
 	GCPOperationsInflight    *prometheus.GaugeVec
 
+	// Zone stockout metrics shared by the cloud drivers
+	ZoneStockoutsCount        *prometheus.CounterVec
+	ZoneRetriesCount          *prometheus.CounterVec
+	MachineTypeFallbacksCount *prometheus.CounterVec
+
+	// Cloud API rate limiting metrics
+	DriverQueueWaitDuration *prometheus.HistogramVec
+
 	// Background instance/capacity purger metrics
 	PurgerLastRunTimestamp             *prometheus.GaugeVec
 	PurgerInstanceDestroyAttemptsCount *prometheus.CounterVec
 	PurgerInstancesForceDeletedCount   *prometheus.CounterVec
 	PurgerCapacityDestroyAttemptsCount *prometheus.CounterVec
 
+	// Image rollout metrics
+	ImageRolloutStaleInstances    *prometheus.GaugeVec
+	ImageRolloutReplacementsCount *prometheus.CounterVec
+
+	// Reconciliation metrics
+	ReconciledInstancesCount *prometheus.CounterVec
+	OrphanedResourcesCount   *prometheus.CounterVec
+
 	// VM creation and usage metrics
 	VMCreationAttemptsCount *prometheus.CounterVec
 	VMCreationDurationCount *prometheus.HistogramVec
@@ -67,6 +83,7 @@ type Metrics struct {
 	VMResumeAttemptsCount        *prometheus.CounterVec
 	VMResumeDurationCount        *prometheus.HistogramVec
 	VMResumeToReadyDurationCount *prometheus.HistogramVec
+	HibernationTransitionsCount  *prometheus.CounterVec
 
 	// Overall health and initialization metrics
 	VMHealthCheckAttemptsCount *prometheus.CounterVec
@@ -558,12 +575,28 @@ func RegisterMetrics() *Metrics {
 	gcpOperationRetriesCount := GCPOperationRetriesCount()
 	gcpOperationsInflight := GCPOperationsInflight()
 
+	// Zone stockout metrics
+	zoneStockoutsCount := ZoneStockoutsCount()
+	zoneRetriesCount := ZoneRetriesCount()
+	machineTypeFallbacksCount := MachineTypeFallbacksCount()
+
+	// Cloud API rate limiting metrics
+	driverQueueWaitDuration := DriverQueueWaitDuration()
+
 	// Background purger metrics
 	purgerLastRunTimestamp := PurgerLastRunTimestamp()
 	purgerInstanceDestroyAttemptsCount := PurgerInstanceDestroyAttemptsCount()
 	purgerInstancesForceDeletedCount := PurgerInstancesForceDeletedCount()
 	purgerCapacityDestroyAttemptsCount := PurgerCapacityDestroyAttemptsCount()
 
+	// Image rollout metrics
+	imageRolloutStaleInstances := ImageRolloutStaleInstances()
+	imageRolloutReplacementsCount := ImageRolloutReplacementsCount()
+
+	// Reconciliation metrics
+	reconciledInstancesCount := ReconciledInstancesCount()
+	orphanedResourcesCount := OrphanedResourcesCount()
+
 	// VM creation and usage metrics
 	vmCreationAttemptsCount := VMCreationAttemptsCount()
 	vmCreationDurationCount := VMCreationDurationCount()
@@ -576,6 +609,7 @@ func RegisterMetrics() *Metrics {
 	vmResumeAttemptsCount := VMResumeAttemptsCount()
 	vmResumeDurationCount := VMResumeDurationCount()
 	vmResumeToReadyDurationCount := VMResumeToReadyDurationCount()
+	hibernationTransitionsCount := HibernationTransitionsCount()
 
 	// Overall health and initialization metrics
 	vmHealthCheckAttemptsCount := VMHealthCheckAttemptsCount()
@@ -600,11 +634,15 @@ func RegisterMetrics() *Metrics {
 		instanceIdleAge,
 		gcpAPIRequestsCount, gcpAPIRequestDuration,
 		gcpOperationsCount, gcpOperationDuration, gcpOperationRetriesCount, gcpOperationsInflight,
+		zoneStockoutsCount, zoneRetriesCount, machineTypeFallbacksCount,
+		driverQueueWaitDuration,
 		purgerLastRunTimestamp, purgerInstanceDestroyAttemptsCount,
 		purgerInstancesForceDeletedCount, purgerCapacityDestroyAttemptsCount,
+		imageRolloutStaleInstances, imageRolloutReplacementsCount,
+		reconciledInstancesCount, orphanedResourcesCount,
 		vmCreationAttemptsCount, vmCreationDurationCount, vmUsageDurationCount, vmsCurrent,
 		vmHibernateAttemptsCount, vmHibernateDurationCount, vmResumeAttemptsCount,
-		vmResumeDurationCount, vmResumeToReadyDurationCount,
+		vmResumeDurationCount, vmResumeToReadyDurationCount, hibernationTransitionsCount,
 		vmHealthCheckAttemptsCount, vmHealthCheckDurationCount, vmSetupAttemptsCount,
 		vmSetupDurationCount, vmInitAttemptsCount, vmInitDurationCount,
 		cleanupAttemptsCount, cleanupDurationCount,
@@ -635,10 +673,18 @@ func RegisterMetrics() *Metrics {
 		GCPOperationDuration:                    gcpOperationDuration,
 		GCPOperationRetriesCount:                gcpOperationRetriesCount,
 		GCPOperationsInflight:                   gcpOperationsInflight,
+		ZoneStockoutsCount:                      zoneStockoutsCount,
+		ZoneRetriesCount:                        zoneRetriesCount,
+		MachineTypeFallbacksCount:               machineTypeFallbacksCount,
+		DriverQueueWaitDuration:                 driverQueueWaitDuration,
 		PurgerLastRunTimestamp:                  purgerLastRunTimestamp,
 		PurgerInstanceDestroyAttemptsCount:      purgerInstanceDestroyAttemptsCount,
 		PurgerInstancesForceDeletedCount:        purgerInstancesForceDeletedCount,
 		PurgerCapacityDestroyAttemptsCount:      purgerCapacityDestroyAttemptsCount,
+		ImageRolloutStaleInstances:              imageRolloutStaleInstances,
+		ImageRolloutReplacementsCount:           imageRolloutReplacementsCount,
+		ReconciledInstancesCount:                reconciledInstancesCount,
+		OrphanedResourcesCount:                  orphanedResourcesCount,
 		VMCreationAttemptsCount:                 vmCreationAttemptsCount,
 		VMCreationDurationCount:                 vmCreationDurationCount,
 		VMUsageDurationCount:                    vmUsageDurationCount,
@@ -648,6 +694,7 @@ func RegisterMetrics() *Metrics {
 		VMResumeAttemptsCount:                   vmResumeAttemptsCount,
 		VMResumeDurationCount:                   vmResumeDurationCount,
 		VMResumeToReadyDurationCount:            vmResumeToReadyDurationCount,
+		HibernationTransitionsCount:             hibernationTransitionsCount,
 		VMHealthCheckAttemptsCount:              vmHealthCheckAttemptsCount,
 		VMHealthCheckDurationCount:              vmHealthCheckDurationCount,
 		VMSetupAttemptsCount:                    vmSetupAttemptsCount,
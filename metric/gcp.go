@@ -40,6 +40,7 @@ const (
 	GCPResourceZoneOperation   = "zone_operation"
 	GCPResourceGlobalOperation = "global_operation"
 	GCPResourceRegion          = "region"
+	GCPResourceImage           = "image"
 )
 
 // GCP operation label values.
@@ -53,6 +54,7 @@ const (
 	GCPOperationSetMetadata      = "set_metadata"
 	GCPOperationSerialPortOutput = "serial_port_output"
 	GCPOperationList             = "list"
+	GCPOperationStop             = "stop"
 )
 
 // GCPAPIRequestsCount provides metrics for raw GCP Compute API HTTP requests,
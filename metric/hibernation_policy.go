@@ -0,0 +1,68 @@
+package metric
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+)
+
+// HibernationTransitionsCount counts the free instances the hibernation policy moved to another
+// state, one increment per instance per target state and outcome.
+func HibernationTransitionsCount() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_hibernation_policy_transitions_total",
+			Help: "Total number of free instances the hibernation policy moved to another state",
+		},
+		[]string{"pool_id", "state", "outcome"},
+	)
+}
+
+// RecordHibernationTransition increments the hibernation policy transitions counter. Safe to call
+// on a nil *Metrics.
+func (m *Metrics) RecordHibernationTransition(poolID, state, outcome string) {
+	if m == nil || m.HibernationTransitionsCount == nil {
+		return
+	}
+	m.HibernationTransitionsCount.WithLabelValues(poolID, state, outcome).Inc()
+}
+
+// MeanResumeToReadyLatency returns the mean latency of the successful resumes of a pool observed
+// by VMResumeToReadyDurationCount since the process started, across zones and VM types. It
+// returns false when the pool has not resumed a VM yet.
+func (m *Metrics) MeanResumeToReadyLatency(poolID string) (time.Duration, bool) {
+	if m == nil || m.VMResumeToReadyDurationCount == nil {
+		return 0, false
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		m.VMResumeToReadyDurationCount.Collect(ch)
+		close(ch)
+	}()
+
+	var sum float64
+	var count uint64
+	for sample := range ch {
+		var pb dto.Metric
+		if err := sample.Write(&pb); err != nil || pb.GetHistogram() == nil {
+			continue
+		}
+		labels := make(map[string]string, len(pb.GetLabel()))
+		for _, label := range pb.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["pool_id"] != poolID || labels["outcome"] != drivers.VMLifecycleOutcomeSuccess {
+			continue
+		}
+		sum += pb.GetHistogram().GetSampleSum()
+		count += pb.GetHistogram().GetSampleCount()
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return time.Duration(sum / float64(count) * float64(time.Second)), true
+}
@@ -0,0 +1,43 @@
+package metric
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drone-runners/drone-runner-aws/app/drivers"
+)
+
+func TestMetrics_RecordHibernationTransition(t *testing.T) {
+	m := &Metrics{HibernationTransitionsCount: HibernationTransitionsCount()}
+	m.RecordHibernationTransition("pool1", "running", "applied")
+	m.RecordHibernationTransition("pool1", "hibernated", "dry_run")
+
+	assert.InDelta(t, 1, testutil.ToFloat64(m.HibernationTransitionsCount.WithLabelValues("pool1", "running", "applied")), 0.0001)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.HibernationTransitionsCount.WithLabelValues("pool1", "hibernated", "dry_run")), 0.0001)
+
+	var nilMetrics *Metrics
+	assert.NotPanics(t, func() { nilMetrics.RecordHibernationTransition("pool1", "running", "applied") })
+}
+
+func TestMetrics_MeanResumeToReadyLatency(t *testing.T) {
+	m := &Metrics{VMResumeToReadyDurationCount: VMResumeToReadyDurationCount()}
+
+	_, ok := m.MeanResumeToReadyLatency("pool1")
+	assert.False(t, ok, "no resume observed yet")
+
+	m.RecordVMResumeToReadyDuration("pool1", "zone-a", "small", drivers.VMLifecycleOutcomeSuccess, 60*time.Second)
+	m.RecordVMResumeToReadyDuration("pool1", "zone-b", "large", drivers.VMLifecycleOutcomeSuccess, 120*time.Second)
+	m.RecordVMResumeToReadyDuration("pool1", "zone-a", "small", "failure", 600*time.Second)
+	m.RecordVMResumeToReadyDuration("pool2", "zone-a", "small", drivers.VMLifecycleOutcomeSuccess, 10*time.Second)
+
+	latency, ok := m.MeanResumeToReadyLatency("pool1")
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Second, latency)
+
+	var nilMetrics *Metrics
+	_, ok = nilMetrics.MeanResumeToReadyLatency("pool1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,33 @@
+package metric
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// driverQueueWaitBuckets go from calls that did not wait at all to calls that
+// queued behind a long burst of creates.
+var driverQueueWaitBuckets = []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300}
+
+// DriverQueueWaitDuration observes how long a driver call waited for the pool's
+// rate limiter (token bucket and in-flight limit) before reaching the cloud API.
+func DriverQueueWaitDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "runner_driver_queue_wait_seconds",
+			Help:    "Time a driver call waited for the pool's cloud API rate limiter",
+			Buckets: driverQueueWaitBuckets,
+		},
+		[]string{"pool_id", "driver", "bucket", "operation"},
+	)
+}
+
+// RecordDriverQueueWait observes the rate limiter wait of a driver call. Safe to
+// call on a nil *Metrics.
+func (m *Metrics) RecordDriverQueueWait(poolID, driver, bucket, operation string, wait time.Duration) {
+	if m == nil || m.DriverQueueWaitDuration == nil || wait < 0 {
+		return
+	}
+	m.DriverQueueWaitDuration.WithLabelValues(poolID, driver, bucket, operation).Observe(wait.Seconds())
+}
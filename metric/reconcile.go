@@ -0,0 +1,47 @@
+package metric
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ReconciledInstancesCount counts the leaked VMs and instance rows with no VM found by
+// reconciliation, one increment per instance per kind and outcome.
+func ReconciledInstancesCount() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_reconciled_instances_total",
+			Help: "Total number of leaked VMs and instance rows with no VM found by reconciliation",
+		},
+		[]string{"pool_id", "kind", "outcome"},
+	)
+}
+
+// RecordReconciledInstance increments the reconciled instances counter. Safe to call on a nil
+// *Metrics.
+func (m *Metrics) RecordReconciledInstance(poolID, kind, outcome string) {
+	if m == nil || m.ReconciledInstancesCount == nil {
+		return
+	}
+	m.ReconciledInstancesCount.WithLabelValues(poolID, kind, outcome).Inc()
+}
+
+// OrphanedResourcesCount counts the orphaned disks and capacity reservations found by the orphan
+// collection, one increment per resource per kind and outcome.
+func OrphanedResourcesCount() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_orphaned_resources_total",
+			Help: "Total number of orphaned disks and capacity reservations found by the orphan collection",
+		},
+		[]string{"pool_id", "kind", "outcome"},
+	)
+}
+
+// RecordOrphanedResource increments the orphaned resources counter. Safe to call on a nil
+// *Metrics.
+func (m *Metrics) RecordOrphanedResource(poolID, kind, outcome string) {
+	if m == nil || m.OrphanedResourcesCount == nil {
+		return
+	}
+	m.OrphanedResourcesCount.WithLabelValues(poolID, kind, outcome).Inc()
+}
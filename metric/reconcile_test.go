@@ -0,0 +1,37 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_RecordReconciledInstance(t *testing.T) {
+	m := &Metrics{ReconciledInstancesCount: ReconciledInstancesCount()}
+	m.RecordReconciledInstance("pool1", "leaked_vm", "removed")
+	m.RecordReconciledInstance("pool1", "leaked_vm", "removed")
+	m.RecordReconciledInstance("pool1", "ghost_row", "retained")
+
+	assert.InDelta(t, 2, testutil.ToFloat64(m.ReconciledInstancesCount.WithLabelValues("pool1", "leaked_vm", "removed")), 0.0001)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.ReconciledInstancesCount.WithLabelValues("pool1", "ghost_row", "retained")), 0.0001)
+}
+
+func TestMetrics_RecordReconciledInstance_NilSafe(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.RecordReconciledInstance("pool1", "leaked_vm", "removed")
+	})
+}
+
+func TestMetrics_RecordOrphanedResource(t *testing.T) {
+	m := &Metrics{OrphanedResourcesCount: OrphanedResourcesCount()}
+	m.RecordOrphanedResource("pool1", "disk", "deleted")
+	m.RecordOrphanedResource("pool1", "capacity_reservation", "dry_run")
+
+	assert.InDelta(t, 1, testutil.ToFloat64(m.OrphanedResourcesCount.WithLabelValues("pool1", "disk", "deleted")), 0.0001)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.OrphanedResourcesCount.WithLabelValues("pool1", "capacity_reservation", "dry_run")), 0.0001)
+
+	var nilMetrics *Metrics
+	assert.NotPanics(t, func() { nilMetrics.RecordOrphanedResource("pool1", "disk", "deleted") })
+}
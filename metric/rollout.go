@@ -0,0 +1,46 @@
+package metric
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ImageRolloutStaleInstances reports the free instances of a pool created from an image the pool
+// no longer uses, which the image rollout has yet to replace.
+func ImageRolloutStaleInstances() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "runner_image_rollout_stale_instances",
+			Help: "Free instances of a pool created from an image the pool no longer uses",
+		},
+		[]string{"pool_id"},
+	)
+}
+
+// ImageRolloutReplacementsCount counts the stale instances the image rollout replaced, one
+// increment per instance per outcome.
+func ImageRolloutReplacementsCount() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_image_rollout_replacements_total",
+			Help: "Total number of stale instances replaced by the image rollout",
+		},
+		[]string{"pool_id", "outcome"},
+	)
+}
+
+// RecordImageRolloutStale sets the stale instances gauge of a pool. Safe to call on a nil
+// *Metrics.
+func (m *Metrics) RecordImageRolloutStale(poolID string, stale int) {
+	if m == nil || m.ImageRolloutStaleInstances == nil {
+		return
+	}
+	m.ImageRolloutStaleInstances.WithLabelValues(poolID).Set(float64(stale))
+}
+
+// RecordImageRolloutReplacement increments the replacements counter.
+func (m *Metrics) RecordImageRolloutReplacement(poolID, outcome string) {
+	if m == nil || m.ImageRolloutReplacementsCount == nil {
+		return
+	}
+	m.ImageRolloutReplacementsCount.WithLabelValues(poolID, outcome).Inc()
+}
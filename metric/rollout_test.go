@@ -0,0 +1,32 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_RecordImageRollout(t *testing.T) {
+	m := &Metrics{
+		ImageRolloutStaleInstances:    ImageRolloutStaleInstances(),
+		ImageRolloutReplacementsCount: ImageRolloutReplacementsCount(),
+	}
+	m.RecordImageRolloutStale("pool1", 4)
+	m.RecordImageRolloutStale("pool1", 3)
+	m.RecordImageRolloutReplacement("pool1", "replaced")
+	m.RecordImageRolloutReplacement("pool1", "replaced")
+	m.RecordImageRolloutReplacement("pool1", "failed")
+
+	assert.InDelta(t, 3, testutil.ToFloat64(m.ImageRolloutStaleInstances.WithLabelValues("pool1")), 0.0001)
+	assert.InDelta(t, 2, testutil.ToFloat64(m.ImageRolloutReplacementsCount.WithLabelValues("pool1", "replaced")), 0.0001)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.ImageRolloutReplacementsCount.WithLabelValues("pool1", "failed")), 0.0001)
+}
+
+func TestMetrics_RecordImageRollout_NilSafe(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.RecordImageRolloutStale("pool1", 1)
+		m.RecordImageRolloutReplacement("pool1", "replaced")
+	})
+}
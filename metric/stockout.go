@@ -0,0 +1,81 @@
+package metric
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Zone stockout operation label values.
+const (
+	StockoutOperationCreate  = "create"
+	StockoutOperationReserve = "reserve"
+)
+
+// ReasonStockout is the retry reason recorded when a driver moves on to another
+// zone because the previous one ran out of capacity. It matches
+// GCPReasonStockout so retries can be compared across clouds.
+const ReasonStockout = GCPReasonStockout
+
+// ReasonSpotFallback is the retry reason recorded when spot capacity ran out in
+// every candidate zone and the create is retried with standard VMs.
+const ReasonSpotFallback = "spot_fallback"
+
+// ZoneStockoutsCount counts create and reserve attempts that failed because the
+// zone had no capacity left for the machine type (GCP ZONE_RESOURCE_POOL_EXHAUSTED,
+// AWS InsufficientInstanceCapacity, Azure ZonalAllocationFailed).
+func ZoneStockoutsCount() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_zone_stockouts_total",
+			Help: "Total number of create/reserve attempts that failed because the zone ran out of capacity",
+		},
+		[]string{"driver", "operation", "zone", "vm_type"},
+	)
+}
+
+// ZoneRetriesCount counts retries into an alternate zone made within a single
+// create or reserve call. zone is the zone that failed and triggered the retry.
+func ZoneRetriesCount() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_zone_retries_total",
+			Help: "Total number of retries into an alternate zone made within create/reserve calls",
+		},
+		[]string{"driver", "operation", "reason", "zone"},
+	)
+}
+
+// MachineTypeFallbacksCount counts creates that moved on to the next machine
+// type of a pool's fallback list because the previous one was out of capacity
+// in every zone tried.
+func MachineTypeFallbacksCount() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "runner_machine_type_fallbacks_total",
+			Help: "Total number of creates that fell back to the next machine type after a stockout in every zone",
+		},
+		[]string{"driver", "from_vm_type", "to_vm_type"},
+	)
+}
+
+// RecordZoneStockout increments the stockout counter. Safe to call on a nil *Metrics.
+func (m *Metrics) RecordZoneStockout(driver, operation, zone, vmType string) {
+	if m == nil || m.ZoneStockoutsCount == nil {
+		return
+	}
+	m.ZoneStockoutsCount.WithLabelValues(driver, operation, zone, vmType).Inc()
+}
+
+// RecordZoneRetry increments the zone retry counter. Safe to call on a nil *Metrics.
+func (m *Metrics) RecordZoneRetry(driver, operation, reason, zone string) {
+	if m == nil || m.ZoneRetriesCount == nil {
+		return
+	}
+	m.ZoneRetriesCount.WithLabelValues(driver, operation, reason, zone).Inc()
+}
+
+// RecordMachineTypeFallback increments the machine type fallback counter. Safe
+// to call on a nil *Metrics.
+func (m *Metrics) RecordMachineTypeFallback(driver, from, to string) {
+	if m == nil || m.MachineTypeFallbacksCount == nil {
+		return
+	}
+	m.MachineTypeFallbacksCount.WithLabelValues(driver, from, to).Inc()
+}
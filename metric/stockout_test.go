@@ -0,0 +1,45 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordZoneStockout_NilSafe(t *testing.T) {
+	var m *Metrics
+	require.NotPanics(t, func() {
+		m.RecordZoneStockout("amazon", StockoutOperationCreate, "us-east-1a", "m5.large")
+		m.RecordZoneRetry("amazon", StockoutOperationCreate, ReasonStockout, "us-east-1a")
+	})
+	require.NotPanics(t, func() {
+		(&Metrics{}).RecordZoneStockout("amazon", StockoutOperationCreate, "us-east-1a", "m5.large")
+	})
+}
+
+func TestRecordZoneStockout_Increments(t *testing.T) {
+	m := &Metrics{ZoneStockoutsCount: ZoneStockoutsCount(), ZoneRetriesCount: ZoneRetriesCount()}
+	m.RecordZoneStockout("azure", StockoutOperationCreate, "1", "Standard_D4s_v5")
+	m.RecordZoneStockout("azure", StockoutOperationCreate, "1", "Standard_D4s_v5")
+	m.RecordZoneRetry("azure", StockoutOperationCreate, ReasonStockout, "1")
+
+	assert.InDelta(t, 2, testutil.ToFloat64(m.ZoneStockoutsCount.WithLabelValues(
+		"azure", StockoutOperationCreate, "1", "Standard_D4s_v5")), 0.0001)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.ZoneRetriesCount.WithLabelValues(
+		"azure", StockoutOperationCreate, ReasonStockout, "1")), 0.0001)
+}
+
+func TestRecordMachineTypeFallback(t *testing.T) {
+	var nilMetrics *Metrics
+	require.NotPanics(t, func() {
+		nilMetrics.RecordMachineTypeFallback("google", "n2-standard-4", "n2d-standard-4")
+		(&Metrics{}).RecordMachineTypeFallback("google", "n2-standard-4", "n2d-standard-4")
+	})
+
+	m := &Metrics{MachineTypeFallbacksCount: MachineTypeFallbacksCount()}
+	m.RecordMachineTypeFallback("google", "n2-standard-4", "n2d-standard-4")
+	assert.InDelta(t, 1, testutil.ToFloat64(m.MachineTypeFallbacksCount.WithLabelValues(
+		"google", "n2-standard-4", "n2d-standard-4")), 0.0001)
+}
@@ -3,8 +3,11 @@ package ldb
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
+	"slices"
 	"sort"
 	"time"
 
@@ -96,8 +99,33 @@ func (s InstanceStore) Update(_ context.Context, instance *types.Instance) error
 	return s.db.Put([]byte(key), data.Bytes(), nil)
 }
 
+// FindAndClaim claims the instance params.InstanceID, other queries are not supported. Leveldb is
+// opened by a single process, callers serialize the claims of the instances they share.
 func (s InstanceStore) FindAndClaim(ctx context.Context, params *types.QueryParams, newState types.InstanceState, allowedStates []types.InstanceState, updateStartTime bool) (*types.Instance, error) {
-	panic("implement me")
+	if params.InstanceID == "" {
+		return nil, errors.New("claim: an instance id is required")
+	}
+	inst, err := s.Find(ctx, params.InstanceID)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	if (params.PoolName != "" && inst.Pool != params.PoolName) || !slices.Contains(allowedStates, inst.State) {
+		return nil, sql.ErrNoRows
+	}
+
+	now := time.Now().Unix()
+	inst.State = newState
+	inst.Updated = now
+	if updateStartTime {
+		inst.Started = now
+	}
+	if err = s.Update(ctx, inst); err != nil {
+		return nil, err
+	}
+	return inst, nil
 }
 
 func (s InstanceStore) Purge(ctx context.Context) error {
@@ -0,0 +1 @@
+ALTER TABLE instances ADD COLUMN IF NOT EXISTS instance_lease_expiry INTEGER NOT NULL DEFAULT 0;
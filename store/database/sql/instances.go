@@ -2,8 +2,11 @@ package sql
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/drone-runners/drone-runner-aws/store"
 	"github.com/drone-runners/drone-runner-aws/types"
@@ -160,7 +163,8 @@ func (s InstanceStore) FindAndClaim(
 	}
 
 	if params.MachineType != "" {
-		subQuery = subQuery.Where(squirrel.Eq{"instance_size": params.MachineType})
+		// a create may have fallen back to an equivalent machine type
+		subQuery = subQuery.Where(squirrel.Eq{"instance_size": append([]string{params.MachineType}, params.MachineTypeFallbacks...)})
 	}
 
 	if params.NestedVirtualization {
@@ -223,6 +227,7 @@ WITH candidate AS (
 UPDATE instances
 SET instance_state = $1,
     instance_updated = extract(epoch FROM now()),
+    instance_lease_expiry = 0,
     instance_started = CASE WHEN $%d THEN extract(epoch FROM now()) ELSE instance_started END
 FROM candidate
 WHERE instances.instance_id = candidate.inst_id
@@ -243,6 +248,7 @@ RETURNING %s
 		&dst.Port, &dst.OwnerID, &dst.StorageIdentifier, &dst.Labels,
 		&dst.EnableNestedVirtualization, &dst.RunnerName, &dst.VariantID,
 		&dst.GPU, &dst.Source, &dst.Network, &dst.ProxyURL, &dst.TenantID,
+		&dst.LeaseExpiry,
 	)
 	if err != nil {
 		return nil, err
@@ -255,6 +261,55 @@ RETURNING %s
 	return dst, nil
 }
 
+// claimByID moves the instance params.InstanceID to newState if it is in one of allowedStates. It is a
+// conditional update rather than the row lock FindAndClaim takes, so it also works on sqlite, where the
+// instance may be claimed by another process sharing the database file. It returns sql.ErrNoRows when the
+// instance is not in an allowed state.
+func (s InstanceStore) claimByID(
+	ctx context.Context,
+	params *types.QueryParams,
+	newState types.InstanceState,
+	allowedStates []types.InstanceState,
+	updateStartTime bool,
+) (*types.Instance, error) {
+	if params.InstanceID == "" {
+		return nil, errors.New("claim: an instance id is required")
+	}
+
+	now := time.Now().Unix()
+	stmt := squirrel.Update("instances").
+		Set("instance_state", newState).
+		Set("instance_updated", now).
+		Set("instance_lease_expiry", 0).
+		Where(squirrel.Eq{"instance_id": params.InstanceID})
+	if updateStartTime {
+		stmt = stmt.Set("instance_started", now)
+	}
+	if params.PoolName != "" {
+		stmt = stmt.Where(squirrel.Eq{"instance_pool": params.PoolName})
+	}
+	if len(allowedStates) > 0 {
+		stmt = stmt.Where(squirrel.Eq{"instance_state": allowedStates})
+	}
+
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build claim query: %w", err)
+	}
+	res, err := s.db.ExecContext(ctx, s.db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return s.Find(ctx, params.InstanceID)
+}
+
 func (s InstanceStore) Purge(ctx context.Context) error {
 	panic("implement me")
 }
@@ -296,6 +351,7 @@ const instanceColumns = `
 ,instance_network
 ,instance_proxy_url
 ,tenant_id
+,instance_lease_expiry
 `
 
 const instanceFindByID = `SELECT ` + instanceColumns + `
@@ -341,6 +397,7 @@ INSERT INTO instances (
 ,instance_network
 ,instance_proxy_url
 ,tenant_id
+,instance_lease_expiry
 ) values (
  :instance_id
 ,:instance_node_id
@@ -378,6 +435,7 @@ INSERT INTO instances (
 ,:instance_network
 ,:instance_proxy_url
 ,:tenant_id
+,:instance_lease_expiry
 ) RETURNING instance_id
 `
 
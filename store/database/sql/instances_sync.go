@@ -47,10 +47,11 @@ func (i InstanceStoreSync) Update(ctx context.Context, instance *types.Instance)
 	return i.base.Update(ctx, instance)
 }
 
+// FindAndClaim claims the instance params.InstanceID, other queries are not supported.
 func (i InstanceStoreSync) FindAndClaim(ctx context.Context, params *types.QueryParams, newState types.InstanceState, allowedStates []types.InstanceState, updateStartTime bool) (*types.Instance, error) { //nolint: lll
 	mutex.Lock()
 	defer mutex.Unlock()
-	panic("implement me")
+	return i.base.claimByID(ctx, params, newState, allowedStates, updateStartTime)
 }
 
 func (i InstanceStoreSync) Purge(ctx context.Context) error {
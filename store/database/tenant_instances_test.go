@@ -2,6 +2,8 @@ package database
 
 import (
 	"context"
+	dbsql "database/sql"
+	"errors"
 	"path/filepath"
 	"testing"
 
@@ -79,3 +81,48 @@ func TestInstanceStore_TenantIDDefaultsWhenUnset(t *testing.T) {
 		t.Errorf("expected default tenant, got %q", got.TenantID)
 	}
 }
+
+func TestInstanceStore_LeaseExpiryPersisted(t *testing.T) {
+	ctx := context.Background()
+	s := newTestInstanceStore(t)
+
+	inst := &types.Instance{ID: "l1", Name: "l1", Pool: "aws", State: types.StateInUse, TenantID: types.DefaultTenantID, LeaseExpiry: 1700000000, Labels: []byte("{}")}
+	if err := s.Create(ctx, inst); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	got, err := s.Find(ctx, "l1")
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if got.LeaseExpiry != 1700000000 {
+		t.Errorf("expected lease expiry 1700000000, got %d", got.LeaseExpiry)
+	}
+}
+
+func TestInstanceStoreSync_FindAndClaimByID(t *testing.T) {
+	ctx := context.Background()
+	s := sql.NewInstanceStoreSync(newTestInstanceStore(t))
+
+	inst := &types.Instance{ID: "c1", Name: "c1", Pool: "aws", State: types.StateCreated, TenantID: types.DefaultTenantID, Labels: []byte("{}")}
+	if err := s.Create(ctx, inst); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	params := &types.QueryParams{PoolName: "aws", InstanceID: "c1"}
+	allowed := []types.InstanceState{types.StateCreated}
+	got, err := s.FindAndClaim(ctx, params, types.StateInUse, allowed, false)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if got.State != types.StateInUse {
+		t.Errorf("expected the claimed instance to be in use, got %q", got.State)
+	}
+
+	// a second claim loses, the instance is no longer free
+	if _, err = s.FindAndClaim(ctx, params, types.StateInUse, allowed, false); !errors.Is(err, dbsql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an instance in use, got %v", err)
+	}
+	if _, err = s.FindAndClaim(ctx, &types.QueryParams{PoolName: "aws"}, types.StateInUse, allowed, false); err == nil {
+		t.Error("expected an error without an instance id")
+	}
+}
@@ -38,6 +38,12 @@ const (
 	Azure        = DriverType("azure")
 	DigitalOcean = DriverType("digitalocean")
 	Google       = DriverType("google")
+	Hetzner      = DriverType("hetzner")
+	Libvirt      = DriverType("libvirt")
+	Firecracker  = DriverType("firecracker")
+	Proxmox      = DriverType("proxmox")
+	OpenStack    = DriverType("openstack")
+	KubeVirt     = DriverType("kubevirt")
 	VMFusion     = DriverType("vmfusion")
 	Noop         = DriverType("noop")
 	Nomad        = DriverType("nomad")
@@ -111,6 +117,7 @@ type Instance struct {
 	Network                    string         `db:"instance_network" json:"network"`
 	ProxyURL                   string         `db:"instance_proxy_url" json:"proxy_url"`
 	TenantID                   string         `db:"tenant_id" json:"tenant_id"`
+	LeaseExpiry                int64          `db:"instance_lease_expiry" json:"lease_expiry"` // unix seconds, 0 when no lease is held
 }
 
 // DefaultTenantID is the tenant identifier used for single-tenant pools and as the DB default
@@ -145,6 +152,8 @@ type NomadConfig struct {
 	InitTimeout             time.Duration
 	ByoiInitTimeout         time.Duration
 	DestroyTimeout          time.Duration
+	HibernateTimeout        time.Duration
+	ResumeTimeout           time.Duration
 	GlobalAccount           string
 	DestroyRetryAttempts    int
 	MinNomadCPUMhz          int
@@ -195,6 +204,7 @@ type InstanceCreateOpts struct {
 	VMLabels                     map[string]string
 	Zones                        []string
 	MachineType                  string
+	MachineTypeFallbacks         []string // tried in order once MachineType is out of capacity in every zone
 	LiteEngineFallbackPath       string
 	PluginBinaryFallbackURI      string
 	VMImageConfig                VMImageConfig
@@ -237,6 +247,7 @@ type QueryParams struct {
 	InstanceID           string
 	ImageName            string
 	MachineType          string
+	MachineTypeFallbacks []string // instances of these sizes also match MachineType
 	NestedVirtualization bool
 	GPU                  bool
 	VariantID            string
@@ -363,6 +374,7 @@ type SetupInstanceParams struct {
 	ImageName            string         `json:"image_name,omitempty" yaml:"image_name,omitempty"`
 	NestedVirtualization bool           `json:"enable_nested_virtualization,omitempty" yaml:"enable_nested_virtualization,omitempty"`
 	MachineType          string         `json:"machine_type,omitempty" yaml:"machine_type,omitempty"`
+	MachineTypeFallbacks []string       `json:"machine_type_fallbacks,omitempty" yaml:"machine_type_fallbacks,omitempty"`
 	Hibernate            bool           `json:"hibernate,omitempty" yaml:"hibernate,omitempty"`
 	Zones                []string       `json:"zones,omitempty" yaml:"zones,omitempty"`
 	VariantID            string         `json:"variant_id,omitempty" yaml:"variant_id,omitempty"`